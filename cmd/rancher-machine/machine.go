@@ -5,20 +5,31 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rancher/machine/commands"
 	"github.com/rancher/machine/commands/mcndirs"
+	"github.com/rancher/machine/drivers/alibabacloud"
 	"github.com/rancher/machine/drivers/amazonec2"
 	"github.com/rancher/machine/drivers/azure"
+	"github.com/rancher/machine/drivers/container"
 	"github.com/rancher/machine/drivers/digitalocean"
 	"github.com/rancher/machine/drivers/exoscale"
 	"github.com/rancher/machine/drivers/generic"
 	"github.com/rancher/machine/drivers/google"
 	"github.com/rancher/machine/drivers/hyperv"
+	"github.com/rancher/machine/drivers/ibmcloud"
+	"github.com/rancher/machine/drivers/multipass"
 	"github.com/rancher/machine/drivers/none"
 	"github.com/rancher/machine/drivers/noop"
+	"github.com/rancher/machine/drivers/nutanix"
+	"github.com/rancher/machine/drivers/oci"
+	"github.com/rancher/machine/drivers/opennebula"
 	"github.com/rancher/machine/drivers/openstack"
+	"github.com/rancher/machine/drivers/outscale"
 	"github.com/rancher/machine/drivers/pod"
+	"github.com/rancher/machine/drivers/qemu"
 	"github.com/rancher/machine/drivers/rackspace"
 	"github.com/rancher/machine/drivers/softlayer"
 	"github.com/rancher/machine/drivers/virtualbox"
@@ -85,6 +96,48 @@ func setDebugOutputLevel() {
 	}
 }
 
+func setShowSecrets() {
+	// check --show-secrets, before the flags are parsed to show them in the
+	// first lines
+	for _, f := range os.Args {
+		if f == "--show-secrets" {
+			os.Setenv(log.ShowSecretsEnvVar, "1")
+		}
+	}
+
+	showEnv := os.Getenv(log.ShowSecretsEnvVar)
+	if showEnv != "" {
+		show, err := strconv.ParseBool(showEnv)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing boolean value from %s: %s\n", log.ShowSecretsEnvVar, err)
+			os.Exit(1)
+		}
+		log.SetShowSecrets(show)
+	}
+}
+
+func setLogFormat() {
+	// check --log-format, before the flags are parsed to log the first
+	// lines in the format
+	format := os.Getenv(log.FormatEnvVar)
+	for i, f := range os.Args {
+		if strings.HasPrefix(f, "--log-format=") {
+			format = strings.TrimPrefix(f, "--log-format=")
+		} else if f == "--log-format" && i+1 < len(os.Args) {
+			format = os.Args[i+1]
+		}
+	}
+
+	if err := log.SetFormat(format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting the log format: %s\n", err)
+		os.Exit(1)
+	}
+	if format != "" {
+		// the plugins log in the format too
+		os.Setenv(log.FormatEnvVar, format)
+	}
+}
+
 func main() {
 	if os.Getenv(localbinary.PluginEnvKey) == localbinary.PluginEnvVal {
 		driverName := os.Getenv(localbinary.PluginEnvDriverName)
@@ -94,7 +147,9 @@ func main() {
 
 	localbinary.CurrentBinaryIsDockerMachine = true
 
+	setLogFormat()
 	setDebugOutputLevel()
+	setShowSecrets()
 	cli.AppHelpTemplate = AppHelpTemplate
 	cli.CommandHelpTemplate = CommandHelpTemplate
 	app := cli.NewApp()
@@ -114,6 +169,17 @@ func main() {
 			Name:  "debug, D",
 			Usage: "Enable debug mode",
 		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_LOG_FORMAT",
+			Name:   "log-format",
+			Usage:  "Format of the log lines: text, or json for a JSON object per line with the level, timestamp, host, phase and message",
+			Value:  "text",
+		},
+		cli.BoolFlag{
+			EnvVar: "MACHINE_SHOW_SECRETS",
+			Name:   "show-secrets",
+			Usage:  "Show the secrets of the drivers, e.g. their passwords and API keys, in the logs and in inspect rather than redact them",
+		},
 		cli.StringFlag{
 			EnvVar: "MACHINE_STORAGE_PATH",
 			Name:   "storage-path, s",
@@ -132,6 +198,12 @@ func main() {
 			Usage:  "Private key to generate certificates",
 			Value:  "",
 		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_TLS_CA_SIGNER",
+			Name:   "tls-ca-signer",
+			Usage:  "Command signing the certificates in place of the CA private key, reading a CSR from stdin and writing the certificate to stdout",
+			Value:  "",
+		},
 		cli.StringFlag{
 			EnvVar: "MACHINE_TLS_CLIENT_CERT",
 			Name:   "tls-client-cert",
@@ -179,6 +251,51 @@ func main() {
 			Usage:  "The path to the kubeconfig needed for secrets management",
 			Value:  "",
 		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_ENCRYPTION_KEY_FILE",
+			Name:   "encryption-key-file",
+			Usage:  "File holding the 32 bytes AES key encrypting the secrets in the machine configs, raw or base64 encoded (or set MACHINE_ENCRYPTION_KEY)",
+			Value:  "",
+		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_ENCRYPTION_KMS_PLUGIN",
+			Name:   "encryption-kms-plugin",
+			Usage:  "Executable wrapping the keys encrypting the secrets in the machine configs with a KMS",
+			Value:  "",
+		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_STORE_URL",
+			Name:   "store-url",
+			Usage:  "Store to share the machines in: an S3-compatible bucket (s3://bucket/prefix?endpoint=https://sos-ch-gva-2.exo.io&region=ch-gva-2), etcd (etcd://host:2379/prefix) or Consul (consul://host:8500/prefix)",
+			Value:  "",
+		},
+		cli.StringSliceFlag{
+			Name:  "hook",
+			Usage: "Command run locally for a phase of the life of the machines, as phase=command, the phase being pre-create, post-create, pre-provision, post-provision, pre-remove or post-remove. Executables in <storage-path>/hooks.d/<phase> are run too",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name:  "remote-hook",
+			Usage: "Command run on the machine over SSH for a phase of its life, as phase=command. Scripts with the .remote extension in <storage-path>/hooks.d/<phase> are run too",
+			Value: &cli.StringSlice{},
+		},
+		cli.DurationFlag{
+			EnvVar: "MACHINE_LOCK_TIMEOUT",
+			Name:   "lock-timeout",
+			Usage:  "How long to wait for a machine used by another process before failing, 0 to wait indefinitely",
+			Value:  time.Minute,
+		},
+		cli.DurationFlag{
+			EnvVar: "MACHINE_IP_CACHE_TTL",
+			Name:   "ip-cache-ttl",
+			Usage:  "How long to reuse the IP address of a machine before asking its driver again, 0 to always ask it",
+			Value:  5 * time.Minute,
+		},
+		cli.StringSliceFlag{
+			Name:  "retry",
+			Usage: "Retry policy of an operation (default, ssh, winrm, docker or state), e.g. ssh:attempts=100,interval=2s,multiplier=1.5,max-interval=30s,jitter=0.2",
+			Value: &cli.StringSlice{},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -189,10 +306,14 @@ func main() {
 
 func runDriver(driverName string) {
 	switch driverName {
+	case "aliyunecs":
+		plugin.RegisterDriver(alibabacloud.NewDriver("", ""))
 	case "amazonec2":
 		plugin.RegisterDriver(amazonec2.NewDriver("", ""))
 	case "azure":
 		plugin.RegisterDriver(azure.NewDriver("", ""))
+	case "container":
+		plugin.RegisterDriver(container.NewDriver("", ""))
 	case "digitalocean":
 		plugin.RegisterDriver(digitalocean.NewDriver("", ""))
 	case "exoscale":
@@ -203,10 +324,24 @@ func runDriver(driverName string) {
 		plugin.RegisterDriver(google.NewDriver("", ""))
 	case "hyperv":
 		plugin.RegisterDriver(hyperv.NewDriver("", ""))
+	case "ibmcloud":
+		plugin.RegisterDriver(ibmcloud.NewDriver("", ""))
+	case "multipass":
+		plugin.RegisterDriver(multipass.NewDriver("", ""))
 	case "none":
 		plugin.RegisterDriver(none.NewDriver("", ""))
+	case "nutanix":
+		plugin.RegisterDriver(nutanix.NewDriver("", ""))
+	case "oci":
+		plugin.RegisterDriver(oci.NewDriver("", ""))
+	case "opennebula":
+		plugin.RegisterDriver(opennebula.NewDriver("", ""))
 	case "openstack":
 		plugin.RegisterDriver(openstack.NewDriver("", ""))
+	case "outscale":
+		plugin.RegisterDriver(outscale.NewDriver("", ""))
+	case "qemu":
+		plugin.RegisterDriver(qemu.NewDriver("", ""))
 	case "rackspace":
 		plugin.RegisterDriver(rackspace.NewDriver("", ""))
 	case "softlayer":
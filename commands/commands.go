@@ -1,28 +1,39 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rancher/machine/commands/mcndirs"
 	"github.com/rancher/machine/libmachine"
 	"github.com/rancher/machine/libmachine/crashreport"
 	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/events"
+	"github.com/rancher/machine/libmachine/healthcheck"
 	"github.com/rancher/machine/libmachine/host"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnerror"
 	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/persist"
+	"github.com/rancher/machine/libmachine/reconcile"
 	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/urfave/cli"
 )
 
 const (
 	defaultMachineName = "default"
+	// ipCacheLockTimeout bounds the wait for a host used by another process
+	// before saving the addresses cached
+	ipCacheLockTimeout = time.Second
 )
 
 var (
@@ -93,7 +104,13 @@ func (c *contextCommandLine) Application() *cli.App {
 // targetHost returns a specific host name if one is indicated by the first CLI
 // arg, or the default host name if no host is specified.
 func targetHost(c CommandLine, api libmachine.API) (string, error) {
-	if len(c.Args()) == 0 {
+	return targetHostOf(c.Args(), api)
+}
+
+// targetHostOf returns the machine named by the first of args, the default
+// one if args is empty.
+func targetHostOf(args []string, api libmachine.API) (string, error) {
+	if len(args) == 0 {
 		defaultExists, err := api.Exists(defaultMachineName)
 		if err != nil {
 			return "", fmt.Errorf("Error checking if host %q exists: %s", defaultMachineName, err)
@@ -106,7 +123,7 @@ func targetHost(c CommandLine, api libmachine.API) (string, error) {
 		return "", ErrNoDefault
 	}
 
-	return c.Args()[0], nil
+	return args[0], nil
 }
 
 func runAction(actionName string, c CommandLine, api libmachine.API) error {
@@ -128,6 +145,12 @@ func runAction(actionName string, c CommandLine, api libmachine.API) error {
 		hostsToLoad = c.Args()
 	}
 
+	unlock, err := lockHosts(api, hostsToLoad)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	hosts, hostsInError := persist.LoadHosts(api, hostsToLoad)
 
 	if len(hostsInError) > 0 {
@@ -142,10 +165,25 @@ func runAction(actionName string, c CommandLine, api libmachine.API) error {
 		return ErrHostLoad
 	}
 
-	if errs := runActionForeachMachine(actionName, hosts); len(errs) > 0 {
+	phases, hasHooks := actionHooks[actionName]
+	if hasHooks {
+		for _, h := range hosts {
+			if err := hooksOf(api).Run(phases[0], h); err != nil {
+				return err
+			}
+		}
+	}
+
+	if errs := runActionForeachMachine(actionName, hosts, eventsOf(api)); len(errs) > 0 {
 		return consolidateErrs(errs)
 	}
 
+	if hasHooks {
+		for _, h := range hosts {
+			hooksOf(api).RunPost(phases[1], h)
+		}
+	}
+
 	for _, h := range hosts {
 		if err := api.Save(h); err != nil {
 			return fmt.Errorf("Error saving host to store: %s", err)
@@ -164,6 +202,8 @@ func runCommand(command func(commandLine CommandLine, api libmachine.API) error)
 			api.SSHClientType = ssh.Native
 		}
 		api.GithubAPIToken = context.GlobalString("github-api-token")
+		api.LockTimeout = context.GlobalDuration("lock-timeout")
+		host.IPCacheTTL = context.GlobalDuration("ip-cache-ttl")
 
 		// TODO (nathanleclaire): These should ultimately be accessed
 		// through the libmachine client by the rest of the code and
@@ -173,6 +213,31 @@ func runCommand(command func(commandLine CommandLine, api libmachine.API) error)
 		mcndirs.BaseDir = context.GlobalString("storage-path")
 		mcnutils.GithubAPIToken = api.GithubAPIToken
 		ssh.SetDefaultClient(api.SSHClientType)
+		ssh.SetKnownHostsFile(filepath.Join(mcndirs.GetBaseDir(), "known_hosts"))
+		ssh.SetMultiplexing(filepath.Join(mcndirs.GetBaseDir(), "cm"))
+		defer ssh.CloseConnections()
+
+		if err := addHooksFromFlags(api, context.GlobalStringSlice("hook"), context.GlobalStringSlice("remote-hook")); err != nil {
+			log.Error(err)
+			osExit(1)
+			return
+		}
+
+		if err := setRetryPoliciesFromFlags(context.GlobalStringSlice("retry")); err != nil {
+			log.Error(err)
+			osExit(1)
+			return
+		}
+
+		encryption, err := encryptionFromFlags(&contextCommandLine{context})
+		if err != nil {
+			log.Error(err)
+			osExit(1)
+			return
+		}
+		if filestore, ok := api.Store.(*persist.Filestore); ok {
+			filestore.Encryption = encryption
+		}
 
 		secretName, secretNamespace := context.GlobalString("secret-name"), context.GlobalString("secret-namespace")
 		if secretName != "" {
@@ -186,6 +251,17 @@ func runCommand(command func(commandLine CommandLine, api libmachine.API) error)
 			api.Store = secretStore
 		}
 
+		if storeURL := context.GlobalString("store-url"); storeURL != "" {
+			remoteStore, err := persist.NewRemoteStore(api.Store, storeURL)
+			if err != nil {
+				log.Error(err)
+				osExit(1)
+				return
+			}
+
+			api.Store = remoteStore
+		}
+
 		if err := command(&contextCommandLine{context}, api); err != nil {
 			log.Error(err)
 
@@ -281,6 +357,39 @@ var Commands = []cli.Command{
 			},
 		},
 	},
+	{
+		Name:        "events",
+		Usage:       "Show the events of machines",
+		Description: "Argument(s) are one or more machine names, all of them if none is given.",
+		Action:      runCommand(cmdEvents),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "follow, f",
+				Usage: "Wait for new events",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the events as JSON lines",
+			},
+		},
+	},
+	{
+		Name:        "healthcheck",
+		Usage:       "Check the health of machines",
+		Description: "Argument(s) are one or more machine names. The state of the driver, SSH, the Docker API, the certificates and the disk pressure of the machines are checked.",
+		Action:      runCommand(cmdHealthcheck),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the reports as JSON lines",
+			},
+			cli.IntFlag{
+				Name:  "disk-threshold",
+				Usage: "Disk usage in percent from which the disk check fails",
+				Value: healthcheck.DiskPressureThreshold,
+			},
+		},
+	},
 	{
 		Name:        "inspect",
 		Usage:       "Inspect information about a machine",
@@ -299,6 +408,12 @@ var Commands = []cli.Command{
 		Usage:       "Get the IP address of a machine",
 		Description: "Argument(s) are one or more machine names.",
 		Action:      runCommand(cmdIP),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "refresh",
+				Usage: "Ask the driver for the IP address rather than using the one cached",
+			},
+		},
 	},
 	{
 		Name:            "kill",
@@ -333,6 +448,18 @@ var Commands = []cli.Command{
 			},
 		},
 	},
+	{
+		Name:        "migrate",
+		Usage:       "Upgrade the configs of machines to the current version",
+		Description: "Argument(s) are one or more machine names, all of them if none is given. The configs are otherwise upgraded when first loaded.",
+		Action:      runCommand(cmdMigrate),
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "List the migrations without applying them",
+			},
+		},
+	},
 	{
 		Name:            "provision",
 		Usage:           "Re-provision existing machines",
@@ -340,6 +467,34 @@ var Commands = []cli.Command{
 		Flags:           []cli.Flag{updateConfigBoolFlag},
 		SkipFlagParsing: true,
 	},
+	{
+		Name:        "encrypt-store",
+		Usage:       "Encrypt the secrets in the configs of existing machines",
+		Description: "Argument(s) are one or more machine names, all of them if none is given. The key is given by MACHINE_ENCRYPTION_KEY, --encryption-key-file or --encryption-kms-plugin.",
+		Action:      runCommand(cmdEncryptStore),
+	},
+	{
+		Name:        "reconcile",
+		Usage:       "Restart or recreate the machines found stopped or in error",
+		Description: "Argument(s) are one or more machine names. The machines are checked every interval until interrupted.",
+		Action:      runCommand(cmdReconcile),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "policy",
+				Usage: "What to do with the machines stopped or in error: restart, recreate or alert-only",
+				Value: string(reconcile.Restart),
+			},
+			cli.IntFlag{
+				Name:  "interval",
+				Usage: "Time in seconds between two checks of the machines",
+				Value: reconcileDefaultInterval,
+			},
+			cli.BoolFlag{
+				Name:  "once",
+				Usage: "Check the machines once and exit",
+			},
+		},
+	},
 	{
 		Name:        "regenerate-certs",
 		Usage:       "Regenerate TLS Certificates for a machine",
@@ -380,10 +535,31 @@ var Commands = []cli.Command{
 		Action:          runCommand(withDriverFlags("rm", true, &updateConfigGenericFlag, cmdRm)),
 		SkipFlagParsing: true,
 	},
+	{
+		Name:        "rotate-certs",
+		Usage:       "Renew the certificates of machines expiring soon, restarting their engine without provisioning them",
+		Description: "Argument(s) are one or more machine names, all of them if none is given.",
+		Action:      runCommand(cmdRotateCerts),
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "within",
+				Usage: "Renew the certificates expiring in less than this number of days",
+				Value: int(host.CertExpiryWarning.Hours() / 24),
+			},
+			cli.BoolFlag{
+				Name:  "force, f",
+				Usage: "Renew the certificates even if they do not expire soon",
+			},
+			cli.BoolFlag{
+				Name:  "client-certs",
+				Usage: "Also renew the client certificate",
+			},
+		},
+	},
 	{
 		Name:            "ssh",
 		Usage:           "Log into or run a command on a machine with SSH.",
-		Description:     "Arguments are [machine-name] [command]",
+		Description:     "Arguments are [-A] [--reset-hostkey] [-L|-R [bind_address:]port:host:hostport] [-D [bind_address:]port] [-N] [machine-name] [command]",
 		Action:          runCommand(cmdSSH),
 		SkipFlagParsing: true,
 	},
@@ -399,7 +575,7 @@ var Commands = []cli.Command{
 			},
 			cli.BoolFlag{
 				Name:  "delta, d",
-				Usage: "Reduce amount of data sent over network by sending only the differences (uses rsync)",
+				Usage: "Reduce amount of data sent over network by sending only the differences (uses rsync, or only sends the changed files with --native-ssh)",
 			},
 			cli.BoolFlag{
 				Name:  "quiet, q",
@@ -460,9 +636,14 @@ var Commands = []cli.Command{
 	},
 }
 
-func printIP(h *host.Host) func() error {
+func printIP(h *host.Host, refresh bool) func() error {
 	return func() error {
-		ip, err := h.Driver.GetIP()
+		getIP := h.IP
+		if refresh {
+			getIP = h.RefreshIP
+		}
+
+		ip, err := getIP()
 		if err != nil {
 			return fmt.Errorf("Error getting IP address: %s", err)
 		}
@@ -473,38 +654,86 @@ func printIP(h *host.Host) func() error {
 	}
 }
 
+// saveIPCache saves the addresses of h cached by the commands which don't
+// save the host, e.g. env or url. The cache is not worth waiting for another
+// process using the host, so it is left unsaved then.
+func saveIPCache(api libmachine.API, h *host.Host) {
+	if !h.IPCacheUpdated() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ipCacheLockTimeout)
+	defer cancel()
+	unlock, err := persist.LockHost(ctx, api, h.Name)
+	if err != nil {
+		log.Debugf("Not saving the IP cache of %s: %s", h.Name, err)
+		return
+	}
+	defer unlock()
+
+	stored, err := api.Load(h.Name)
+	if err != nil {
+		log.Debugf("Not saving the IP cache of %s: %s", h.Name, err)
+		return
+	}
+	stored.IPCache = h.IPCache
+	if err := api.Save(stored); err != nil {
+		log.Debugf("Error saving the IP cache of %s: %s", h.Name, err)
+	}
+}
+
 // machineCommand maps the command name to the corresponding machine command.
 // We run commands concurrently and communicate back an error if there was one.
-func machineCommand(actionName string, host *host.Host, errorChan chan<- error) {
+func machineCommand(ctx context.Context, actionName string, host *host.Host, bus *events.Bus, errorChan chan<- error) {
 	// TODO: These actions should have their own type.
 	commands := map[string](func() error){
 		"configureAuth":    host.ConfigureAuth,
 		"configureAllAuth": host.ConfigureAllAuth,
-		"start":            host.Start,
-		"stop":             host.Stop,
+		"start":            func() error { return host.StartContext(ctx) },
+		"stop":             func() error { return host.StopContext(ctx) },
 		"restart":          host.Restart,
 		"kill":             host.Kill,
 		"upgrade":          host.Upgrade,
-		"ip":               printIP(host),
+		"ip":               printIP(host, false),
+		"refreshIP":        printIP(host, true),
 		"provision":        host.Provision,
+		"rotateCerts":      host.RotateCerts,
 	}
 
 	log.Debugf("command=%s machine=%s", actionName, host.Name)
 
-	errorChan <- commands[actionName]()
+	types, hasEvents := actionEvents[actionName]
+	if hasEvents {
+		bus.Publish(events.New(host, types[0], nil))
+		drivers.SetProgressFunc(host.Driver, bus.ProgressFunc(host))
+	}
+
+	err := commands[actionName]()
+	if hasEvents {
+		if err != nil {
+			bus.Publish(events.New(host, events.Error, err))
+		} else {
+			bus.Publish(events.New(host, types[1], nil))
+		}
+	}
+
+	errorChan <- err
 }
 
 // runActionForeachMachine will run the command across multiple machines
-func runActionForeachMachine(actionName string, machines []*host.Host) []error {
+func runActionForeachMachine(actionName string, machines []*host.Host, bus *events.Bus) []error {
 	var (
 		numConcurrentActions = 0
 		errorChan            = make(chan error)
 		errs                 = []error{}
 	)
 
+	ctx, stop := interruptContext()
+	defer stop()
+
 	for _, machine := range machines {
 		numConcurrentActions++
-		go machineCommand(actionName, machine, errorChan)
+		go machineCommand(ctx, actionName, machine, bus, errorChan)
 	}
 
 	// TODO: We should probably only do 5-10 of these
@@ -521,6 +750,51 @@ func runActionForeachMachine(actionName string, machines []*host.Host) []error {
 	return errs
 }
 
+// lockHosts locks the hosts if the store supports it, so that several
+// processes sharing the store don't change them at once. They are locked in
+// order, which keeps processes locking the same hosts from deadlocking.
+func lockHosts(api libmachine.API, names []string) (func(), error) {
+	sorted := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	unlocks := []func() error{}
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			if err := unlocks[i](); err != nil {
+				log.Warnf("Error releasing the lock of machine %s: %s", sorted[i], err)
+			}
+		}
+	}
+	for _, name := range sorted {
+		unlock, err := persist.LockHost(context.Background(), api, name)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// interruptContext returns a context done when the command is interrupted,
+// so that the drivers abort the operations in flight and clean up. A second
+// interrupt terminates the command as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
 func consolidateErrs(errs []error) error {
 	finalErr := ""
 	for _, err := range errs {
@@ -542,9 +816,18 @@ func withDriverFlags(
 	handler cmdHandler,
 ) cmdHandler {
 	return func(c CommandLine, api libmachine.API) error {
+		// A profile given with --profile names the driver of a new host, unless --driver overrides it.
+		var driverName string
+		if !fromExistingHost {
+			var err error
+			if driverName, err = profileDriver(c); err != nil {
+				return err
+			}
+		}
+
 		// If a required flag was specified, we need to make sure its value is set (either via envvars or CLI flags)
 		// before attempting to load driver config. If it is not set anywhere, we don't load driver config.
-		if requiredFlag != nil {
+		if requiredFlag != nil && driverName == "" {
 			// Handle cases where flag names contain comma-separated long and short versions.
 			flagNameParts := strings.SplitN(requiredFlag.Name, ",", 2)
 			flagLong := "--" + strings.TrimSpace(flagNameParts[0])
@@ -560,8 +843,9 @@ func withDriverFlags(
 
 		// To determine what driver flags we need to parse, we'll either get the driver name from an existing host or
 		// from the --driver flag or MACHINE_DRIVER envvar.
-		var driverName string
-		if fromExistingHost {
+		switch {
+		case driverName != "":
+		case fromExistingHost:
 			// The host name should be the last argument because the CLI library doesn't allow options after arguments.
 			hostName := c.Args()[len(c.Args())-1]
 			h, err := api.Load(hostName)
@@ -570,7 +854,7 @@ func withDriverFlags(
 			}
 
 			driverName = h.DriverName
-		} else {
+		default:
 			var ok bool
 			if driverName, ok = getFlagValue(c.Args(), "--driver", "-d", "MACHINE_DRIVER"); !ok {
 				driverName = "virtualbox"
@@ -653,3 +937,24 @@ func getFlagValue(args []string, long, short, envvar string) (string, bool) {
 
 	return "", false
 }
+
+// setRetryPoliciesFromFlags sets the retry policies given by --retry in the
+// form operation:settings. They are set in the environment, which the plugins
+// of the drivers inherit.
+func setRetryPoliciesFromFlags(specs []string) error {
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || !mcnutils.IsRetryOperation(parts[0]) {
+			return fmt.Errorf("invalid --retry %q, expected an operation (default, ssh, winrm, docker or state) followed by :settings", spec)
+		}
+
+		op, settings := parts[0], parts[1]
+		if _, err := mcnutils.RetryPolicyFor(op).Override(settings); err != nil {
+			return fmt.Errorf("invalid --retry %q: %s", spec, err)
+		}
+		if err := os.Setenv(mcnutils.RetryPolicyEnvVar(op), settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package commands
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rancher/machine/commands/commandstest"
 	"github.com/rancher/machine/drivers/fakedriver"
@@ -12,8 +15,11 @@ import (
 	"github.com/rancher/machine/libmachine/crashreport"
 	"github.com/rancher/machine/libmachine/host"
 	"github.com/rancher/machine/libmachine/hosttest"
+	"github.com/rancher/machine/libmachine/libmachinetest"
 	"github.com/rancher/machine/libmachine/mcnerror"
+	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/provision"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli"
@@ -110,7 +116,7 @@ func TestRunActionForeachMachine(t *testing.T) {
 		},
 	}
 
-	runActionForeachMachine("start", machines)
+	runActionForeachMachine("start", machines, nil)
 
 	for _, machine := range machines {
 		machineState, _ := machine.Driver.GetState()
@@ -118,7 +124,7 @@ func TestRunActionForeachMachine(t *testing.T) {
 		assert.Equal(t, state.Running, machineState)
 	}
 
-	runActionForeachMachine("stop", machines)
+	runActionForeachMachine("stop", machines, nil)
 
 	for _, machine := range machines {
 		machineState, _ := machine.Driver.GetState()
@@ -132,7 +138,7 @@ func TestPrintIPEmptyGivenLocalEngine(t *testing.T) {
 	defer stdoutGetter.Stop()
 
 	host, _ := hosttest.GetDefaultTestHost()
-	err := printIP(host)()
+	err := printIP(host, false)()
 
 	assert.NoError(t, err)
 	assert.Equal(t, "\n", stdoutGetter.Output())
@@ -147,7 +153,7 @@ func TestPrintIPPrintsGivenRemoteEngine(t *testing.T) {
 		MockState: state.Running,
 		MockIP:    "1.2.3.4",
 	}
-	err := printIP(host)()
+	err := printIP(host, false)()
 
 	assert.NoError(t, err)
 	assert.Equal(t, "1.2.3.4\n", stdoutGetter.Output())
@@ -229,6 +235,9 @@ func TestSendCrashReport(t *testing.T) {
 
 		context := cli.NewContext(cli.NewApp(), &flag.FlagSet{}, nil)
 		runCommand(command)(context)
+		// runCommand sets the known hosts file and multiplexing of the store
+		ssh.SetKnownHostsFile("")
+		ssh.SetMultiplexing("")
 
 		assert.Equal(t, test.sent, mockCrashReporter.sent, test.description)
 	}
@@ -265,6 +274,9 @@ func checkErrorCodeForCommand(command func(commandLine CommandLine, api libmachi
 
 	defer func() {
 		osExit = originalOSExit
+		// runCommand sets the known hosts file and multiplexing of the store
+		ssh.SetKnownHostsFile("")
+		ssh.SetMultiplexing("")
 	}()
 
 	osExit = func(code int) {
@@ -276,3 +288,61 @@ func checkErrorCodeForCommand(command func(commandLine CommandLine, api libmachi
 
 	return setExitCode
 }
+
+// lockingAPI records the locking of hosts.
+type lockingAPI struct {
+	libmachinetest.FakeAPI
+	events []string
+}
+
+func (api *lockingAPI) LockHost(ctx context.Context, name string) (func() error, error) {
+	api.events = append(api.events, "lock "+name)
+	return func() error {
+		api.events = append(api.events, "unlock "+name)
+		return nil
+	}, nil
+}
+
+func TestLockHostsInOrder(t *testing.T) {
+	api := &lockingAPI{}
+
+	unlock, err := lockHosts(api, []string{"b", "a", "b"})
+	assert.NoError(t, err)
+	unlock()
+
+	assert.Equal(t, []string{"lock a", "lock b", "unlock b", "unlock a"}, api.events)
+}
+
+func TestSetRetryPoliciesFromFlags(t *testing.T) {
+	defer os.Unsetenv("MACHINE_RETRY_DOCKER")
+
+	assert.NoError(t, setRetryPoliciesFromFlags([]string{"docker:attempts=20,interval=1s"}))
+	assert.Equal(t, "attempts=20,interval=1s", os.Getenv("MACHINE_RETRY_DOCKER"))
+	assert.Equal(t, 20, mcnutils.RetryPolicyFor(mcnutils.RetryDocker).MaxAttempts)
+
+	assert.Error(t, setRetryPoliciesFromFlags([]string{"attempts=20"}))
+	assert.Error(t, setRetryPoliciesFromFlags([]string{"cloud:attempts=20"}))
+	assert.Error(t, setRetryPoliciesFromFlags([]string{"ssh:attempts=many"}))
+}
+
+func TestPrintIPRefreshesIP(t *testing.T) {
+	defer func(ttl time.Duration) { host.IPCacheTTL = ttl }(host.IPCacheTTL)
+	host.IPCacheTTL = time.Minute
+
+	stdoutGetter := commandstest.NewStdoutGetter()
+	defer stdoutGetter.Stop()
+
+	h, _ := hosttest.GetDefaultTestHost()
+	driver := &fakedriver.Driver{
+		MockState: state.Running,
+		MockIP:    "1.2.3.4",
+	}
+	h.Driver = driver
+	assert.NoError(t, printIP(h, false)())
+
+	driver.MockIP = "5.6.7.8"
+	assert.NoError(t, printIP(h, false)())
+	assert.NoError(t, printIP(h, true)())
+
+	assert.Equal(t, "1.2.3.4\n1.2.3.4\n5.6.7.8\n", stdoutGetter.Output())
+}
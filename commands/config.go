@@ -30,6 +30,7 @@ func cmdConfig(c CommandLine, api libmachine.API) error {
 	if err != nil {
 		return fmt.Errorf("Error running connection boilerplate: %s", err)
 	}
+	saveIPCache(api, host)
 
 	log.Debug(dockerHost)
 
@@ -18,6 +18,7 @@ import (
 	"github.com/rancher/machine/commands/mcndirs"
 	"github.com/rancher/machine/libmachine"
 	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/cert"
 	"github.com/rancher/machine/libmachine/crashreport"
 	"github.com/rancher/machine/libmachine/drivers"
 	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
@@ -26,6 +27,9 @@ import (
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnerror"
 	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/persist"
+	"github.com/rancher/machine/libmachine/provision"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/swarm"
 	"github.com/urfave/cli"
 	"gopkg.in/yaml.v2"
@@ -43,12 +47,94 @@ var (
 			Value:  "virtualbox",
 			EnvVar: "MACHINE_DRIVER",
 		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Label of the machine in the form key=value, listed by ls, which filters them with --filter label=key=value. The drivers supporting them tag the resources of the machine with them",
+			Value: &cli.StringSlice{},
+		},
 		cli.StringFlag{
 			Name:   "engine-install-url",
-			Usage:  "Custom URL to use for engine installation",
+			Usage:  "Custom URL to use for engine installation, or docker.io for the package of Ubuntu",
 			Value:  drivers.DefaultEngineInstallURL,
 			EnvVar: "MACHINE_DOCKER_INSTALL_URL",
 		},
+		cli.StringFlag{
+			Name:   "engine-http-proxy",
+			Usage:  "HTTP proxy the package manager, the install script and the engine download through",
+			EnvVar: "MACHINE_HTTP_PROXY",
+		},
+		cli.StringFlag{
+			Name:   "engine-https-proxy",
+			Usage:  "HTTPS proxy the package manager, the install script and the engine download through",
+			EnvVar: "MACHINE_HTTPS_PROXY",
+		},
+		cli.StringFlag{
+			Name:   "engine-no-proxy",
+			Usage:  "Comma-separated hosts, domains and CIDRs not reached through the proxies",
+			EnvVar: "MACHINE_NO_PROXY",
+		},
+		cli.StringFlag{
+			Name:   "engine-package-mirror",
+			Usage:  "Mirror of the APT, YUM or Zypper repositories of the distribution, replacing their scheme and host, for the machines with no access to the internet",
+			EnvVar: "MACHINE_PACKAGE_MIRROR",
+		},
+		cli.StringFlag{
+			Name:   "engine-download-url",
+			Usage:  "Mirror of https://download.docker.com serving the Docker packages and GPG keys, for the install script and the docker-ce repository",
+			EnvVar: "MACHINE_DOCKER_DOWNLOAD_URL",
+		},
+		cli.StringFlag{
+			Name:  "engine-daemon-json",
+			Usage: "Settings of the daemon.json of dockerd, inline JSON or a file, along with those set by machine and the engine flags",
+		},
+		cli.StringFlag{
+			Name:  "engine-version",
+			Usage: "Docker version to install, e.g. 24.0.7, held at that version by the package manager and not upgraded by upgrade",
+		},
+		cli.StringFlag{
+			Name:  "engine-install-tarball",
+			Usage: "Local engine bundle to copy to the machine and install rather than from --engine-install-url, for offline machines: a deb or rpm package, or a tarball of those or of the static binaries",
+		},
+		cli.IntFlag{
+			Name:  "engine-port",
+			Usage: "Port the engine listens on, used by the URL of the machine",
+			Value: engine.DefaultPort,
+		},
+		cli.StringFlag{
+			Name:  "engine-runtime",
+			Usage: "Container runtime to provision, docker, containerd alone with its CRI plugin for tools such as k3s and RKE2, or podman serving its Docker-compatible API",
+			Value: engine.RuntimeDocker,
+		},
+		cli.BoolFlag{
+			Name:  "engine-local-only",
+			Usage: "Have containerd only listen on its local socket rather than also on --engine-port with TLS",
+		},
+		cli.StringFlag{
+			Name:  "engine-rootless-user",
+			Usage: "Run Docker in rootless mode as this user, created unless it exists, rather than as root",
+		},
+		cli.BoolFlag{
+			Name:  "engine-gpu-runtime",
+			Usage: "Install the NVIDIA Container Toolkit and its nvidia runtime, verifying that docker run --gpus is given the GPUs of the machine",
+		},
+		cli.BoolFlag{
+			Name:  "engine-selinux-enforcing",
+			Usage: "Keep SELinux enforcing, failing rather than switching it to permissive mode when it cannot be configured for the engine",
+		},
+		cli.StringSliceFlag{
+			Name:  "engine-sysctl",
+			Usage: "Kernel parameter set on the host, as key=value, e.g. net.ipv4.ip_forward=1",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name:  "engine-kernel-module",
+			Usage: "Kernel module loaded on the host, e.g. br_netfilter or overlay",
+			Value: &cli.StringSlice{},
+		},
+		cli.BoolFlag{
+			Name:  "engine-disable-swap",
+			Usage: "Turn the swap of the host off, as Kubernetes requires",
+		},
 		cli.StringSliceFlag{
 			Name:  "engine-opt",
 			Usage: "Specify arbitrary flags to include with the created engine in the form flag=value",
@@ -65,6 +151,16 @@ var (
 			Value:  &cli.StringSlice{},
 			EnvVar: "ENGINE_REGISTRY_MIRROR",
 		},
+		cli.StringSliceFlag{
+			Name:  "engine-registry-ca",
+			Usage: "CA certificate a registry is trusted with, as host=file, installed in the certs.d of the engine",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name:  "engine-registry-auth",
+			Usage: "Credentials of a registry or registry mirror, as host=username:password, the engine authenticates with",
+			Value: &cli.StringSlice{},
+		},
 		cli.StringSliceFlag{
 			Name:  "engine-label",
 			Usage: "Specify labels for the created engine",
@@ -129,67 +225,289 @@ var (
 		},
 		cli.StringSliceFlag{
 			Name:  "tls-san",
-			Usage: "Support extra SANs for TLS certs",
+			Usage: "Extra DNS name or IP of the server certificate, e.g. of a load balancer, can be given several times",
 			Value: &cli.StringSlice{},
 		},
+		cli.StringFlag{
+			Name:  "tls-subject",
+			Usage: "Subject of the server certificate, e.g. CN=docker.example.com,O=Example; the CN is added to its SANs",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "tls-key-algorithm",
+			Usage: "Algorithm of the keys of the certificates: rsa-2048, rsa-4096, ecdsa-p256 or ed25519 (Docker 20.10+); the CA and client certificates only use it when generated",
+			Value: string(cert.DefaultKeyAlgorithm),
+		},
 		cli.StringFlag{
 			Name:  "custom-install-script",
 			Usage: "Use a custom provisioning script instead of installing docker",
 			Value: "",
 		},
+		cli.StringFlag{
+			Name:  "ssh-bastion-host",
+			Usage: "Jump host the SSH connections to the machine tunnel through, as host[:port], e.g. for a machine with only a private IP",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "ssh-bastion-user",
+			Usage: "User on the SSH bastion, the local one by default",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "ssh-bastion-key",
+			Usage: "Private key of the user on the SSH bastion, the key of the machine by default",
+			Value: "",
+		},
 		cli.StringFlag{
 			Name:  "hostname-override",
 			Usage: "Specify hostname to use during cloud-init instead of default generated hostname",
 			Value: "",
 		},
+		cli.BoolFlag{
+			Name:   "rollback-on-failure",
+			Usage:  "Remove the machine and what its driver created if the creation or the provisioning fails",
+			EnvVar: "MACHINE_ROLLBACK_ON_FAILURE",
+		},
+		cli.StringFlag{
+			Name:   "keyring-account",
+			Usage:  "Account of the driver in the OS keyring to read the credentials from, keeping them out of the store",
+			EnvVar: "MACHINE_KEYRING_ACCOUNT",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Run the pre-create checks and print what would be created, with the cloud-init, creating nothing",
+		},
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "YAML or JSON spec of the machines to create, with their driver and flags",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "Profile to read the flags not given on the command line from",
+			EnvVar: "MACHINE_PROFILE",
+		},
+		cli.StringFlag{
+			Name:  "save-profile",
+			Usage: "Save the driver and the flags given as a profile, creating no machine if no name is given",
+		},
+		cli.IntFlag{
+			Name:  "parallelism",
+			Usage: "Number of machines created at once when several names are given, all of them if 0",
+			Value: 5,
+		},
 	}
 )
 
 func cmdCreate(c CommandLine, api libmachine.API) error {
-	if len(c.Args()) > 1 {
-		return fmt.Errorf("invalid arguments: found extra arguments %v", c.Args()[1:])
+	c, err := withProfile(c)
+	if err != nil {
+		return err
+	}
+
+	if profile := c.String("save-profile"); profile != "" {
+		if err := saveProfile(c.GlobalString("storage-path"), profile, profileFromFlags(c)); err != nil {
+			return fmt.Errorf("error saving the profile %s: %s", profile, err)
+		}
+		log.Infof("Profile %s saved", profile)
+		if len(c.Args()) == 0 {
+			return nil
+		}
 	}
 
-	name := c.Args().First()
-	if name == "" {
+	names := c.Args()
+	if len(names) == 0 || names[0] == "" {
 		c.ShowHelp()
 		return errNoMachineName
 	}
 
-	if !host.ValidateHostName(name) {
-		return fmt.Errorf("error creating machine: [%s]", mcnerror.ErrInvalidHostname)
+	seen := map[string]bool{}
+	for i, name := range names {
+		// flags are only parsed before the names
+		if strings.HasPrefix(name, "-") {
+			return fmt.Errorf("invalid arguments: found extra arguments %v", names[i:])
+		}
+		if !host.ValidateHostName(name) {
+			return fmt.Errorf("error creating machine: [%s]", mcnerror.ErrInvalidHostname)
+		}
+		if seen[name] {
+			return fmt.Errorf("invalid arguments: machine %s given more than once", name)
+		}
+		seen[name] = true
 	}
 
 	if err := validateSwarmDiscovery(c.String("swarm-discovery")); err != nil {
 		return fmt.Errorf("error parsing swarm discovery: [%s]", err)
 	}
 
+	if c.Bool("dry-run") {
+		return planCreate(c, api, names)
+	}
+
+	if client, ok := api.(*libmachine.Client); ok {
+		client.RollbackOnFailure = c.Bool("rollback-on-failure")
+	}
+
+	unlock, err := lockHosts(api, names)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if len(names) > 1 {
+		if c.String("hostname-override") != "" {
+			return errors.New("invalid arguments: --hostname-override cannot be used with several machines")
+		}
+		return createMachines(c, api, names)
+	}
+
+	name := names[0]
+	h, err := hostToCreate(c, api, name)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if err := api.CreateContext(ctx, h); err != nil {
+		// Wait for all the logs to reach the client
+		time.Sleep(2 * time.Second)
+
+		vBoxLog := ""
+		if h.DriverName == "virtualbox" {
+			vBoxLog = filepath.Join(api.GetMachinesDir(), h.Name, h.Name, "Logs", "VBox.log")
+		}
+
+		return crashreport.CrashError{
+			Cause:       err,
+			Command:     "Create",
+			Context:     "api.performCreate",
+			DriverName:  h.DriverName,
+			LogFilePath: vBoxLog,
+		}
+	}
+
+	if err := api.Save(h); err != nil {
+		return fmt.Errorf("error attempting to save store: %s", err)
+	}
+
+	if h.HostOptions.CustomInstallScript == "" && !h.HostOptions.EngineOptions.IsContainerd() {
+		log.Infof("to see how to connect your Docker Client to the Docker Engine running on this virtual machine, run: %s env %s", os.Args[0], name)
+	}
+
+	return nil
+}
+
+// createMachines creates several machines with the same flags concurrently,
+// reporting the progress of each.
+func createMachines(c CommandLine, api libmachine.API, names []string) error {
+	hosts := []*host.Host{}
+	for _, name := range names {
+		h, err := hostToCreate(c, api, name)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		hosts = append(hosts, h)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	statuses := libmachine.BatchCreate(ctx, api, hosts, libmachine.BatchOptions{
+		Parallelism: c.Int("parallelism"),
+		Progress:    logCreateStatus,
+	})
+
+	errs := []error{}
+	for _, status := range statuses {
+		if status.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", status.Name, status.Err))
+		}
+	}
+	log.Infof("%d of %d machines created", len(statuses)-len(errs), len(statuses))
+	if len(errs) > 0 {
+		return consolidateErrs(errs)
+	}
+	return nil
+}
+
+func logCreateStatus(status libmachine.CreateStatus) {
+	switch status.State {
+	case libmachine.CreateRunning:
+		log.Infof("[%s] Creating...", status.Name)
+	case libmachine.CreateSucceeded:
+		log.Infof("[%s] Created in %s", status.Name, status.Duration.Round(time.Second))
+	case libmachine.CreateFailed:
+		log.Errorf("[%s] Failed after %s: %s", status.Name, status.Duration.Round(time.Second), status.Err)
+	case libmachine.CreateSkipped:
+		log.Warnf("[%s] Skipped: %s", status.Name, status.Err)
+	}
+}
+
+// hostToCreate returns the host named name if its creation failed, to resume
+// it, or else a new host configured from the flags of the create command.
+func hostToCreate(c CommandLine, api libmachine.API, name string) (*host.Host, error) {
+	exists, err := api.Exists(name)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if host exists: %s", err)
+	}
+	if exists {
+		h, err := api.Load(name)
+		if err != nil {
+			return nil, fmt.Errorf("error loading host %s: %s", name, err)
+		}
+		if h.IsCreating() {
+			return h, nil
+		}
+	}
+	return newHostFromFlags(c, api, name)
+}
+
+// newHostFromFlags returns a new host named name, configured from the flags
+// of the create command.
+func newHostFromFlags(c CommandLine, api libmachine.API, name string) (*host.Host, error) {
+	h, _, err := newHostAndDriverOpts(c, api, name)
+	return h, err
+}
+
+// newHostAndDriverOpts returns a new host named name and the flags its driver
+// was configured with.
+func newHostAndDriverOpts(c CommandLine, api libmachine.API, name string) (*host.Host, *rpcdriver.RPCFlags, error) {
 	// TODO: Fix hacky JSON solution
 	rawDriver, err := json.Marshal(&drivers.BaseDriver{
 		MachineName: name,
 		StorePath:   c.GlobalString("storage-path"),
 	})
 	if err != nil {
-		return fmt.Errorf("error attempting to marshal bare driver data: %s", err)
+		return nil, nil, fmt.Errorf("error attempting to marshal bare driver data: %s", err)
 	}
 
 	driverName := c.String("driver")
 	h, err := api.NewHost(driverName, rawDriver)
 	if err != nil {
-		return fmt.Errorf("error getting new host: %s", err)
+		return nil, nil, fmt.Errorf("error getting new host: %s", err)
+	}
+
+	keyAlgorithm, err := cert.ParseKeyAlgorithm(c.String("tls-key-algorithm"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --tls-key-algorithm: %s", err)
 	}
 
 	h.HostOptions = &host.Options{
 		AuthOptions: &auth.Options{
-			CertDir:          mcndirs.GetMachineCertDir(),
-			CaCertPath:       tlsPath(c, "tls-ca-cert", "ca.pem"),
-			CaPrivateKeyPath: tlsPath(c, "tls-ca-key", "ca-key.pem"),
-			ClientCertPath:   tlsPath(c, "tls-client-cert", "cert.pem"),
-			ClientKeyPath:    tlsPath(c, "tls-client-key", "key.pem"),
-			ServerCertPath:   filepath.Join(mcndirs.GetMachineDir(), name, "server.pem"),
-			ServerKeyPath:    filepath.Join(mcndirs.GetMachineDir(), name, "server-key.pem"),
-			StorePath:        filepath.Join(mcndirs.GetMachineDir(), name),
-			ServerCertSANs:   c.StringSlice("tls-san"),
+			CertDir:           mcndirs.GetMachineCertDir(),
+			CaCertPath:        tlsPath(c, "tls-ca-cert", "ca.pem"),
+			CaPrivateKeyPath:  tlsPath(c, "tls-ca-key", "ca-key.pem"),
+			ClientCertPath:    tlsPath(c, "tls-client-cert", "cert.pem"),
+			ClientKeyPath:     tlsPath(c, "tls-client-key", "key.pem"),
+			ServerCertPath:    filepath.Join(mcndirs.GetMachineDir(), name, "server.pem"),
+			ServerKeyPath:     filepath.Join(mcndirs.GetMachineDir(), name, "server-key.pem"),
+			StorePath:         filepath.Join(mcndirs.GetMachineDir(), name),
+			ServerCertSANs:    c.StringSlice("tls-san"),
+			ServerCertSubject: c.String("tls-subject"),
+			ExternalCA:        c.GlobalString("tls-ca-cert") != "",
+			CaSignerCommand:   c.GlobalString("tls-ca-signer"),
+			KeyAlgorithm:      string(keyAlgorithm),
 		},
 		EngineOptions: &engine.Options{
 			ArbitraryFlags:   c.StringSlice("engine-opt"),
@@ -200,6 +518,21 @@ func cmdCreate(c CommandLine, api libmachine.API) error {
 			StorageDriver:    c.String("engine-storage-driver"),
 			TLSVerify:        true,
 			InstallURL:       c.String("engine-install-url"),
+			InstallTarball:   c.String("engine-install-tarball"),
+			HTTPProxy:        c.String("engine-http-proxy"),
+			HTTPSProxy:       c.String("engine-https-proxy"),
+			NoProxy:          c.String("engine-no-proxy"),
+			PackageMirror:    c.String("engine-package-mirror"),
+			DownloadURL:      c.String("engine-download-url"),
+			Version:          c.String("engine-version"),
+			Port:             c.Int("engine-port"),
+			Runtime:          c.String("engine-runtime"),
+			LocalOnly:        c.Bool("engine-local-only"),
+			RootlessUser:     c.String("engine-rootless-user"),
+			GPURuntime:       c.Bool("engine-gpu-runtime"),
+			SELinuxEnforcing: c.Bool("engine-selinux-enforcing"),
+			KernelModules:    c.StringSlice("engine-kernel-module"),
+			DisableSwap:      c.Bool("engine-disable-swap"),
 		},
 		SwarmOptions: &swarm.Options{
 			IsSwarm:            c.Bool("swarm") || c.Bool("swarm-master"),
@@ -216,12 +549,144 @@ func cmdCreate(c CommandLine, api libmachine.API) error {
 		},
 	}
 
+	if h.Labels, err = host.ParseLabels(c.StringSlice("label")); err != nil {
+		return nil, nil, err
+	}
+
+	enginePort := c.Int("engine-port")
+	if enginePort < 1 || enginePort > 65535 {
+		return nil, nil, fmt.Errorf("invalid --engine-port %d, expected a port between 1 and 65535", enginePort)
+	}
+
+	switch runtime := c.String("engine-runtime"); {
+	case runtime != engine.RuntimeDocker && runtime != engine.RuntimeContainerd && runtime != engine.RuntimePodman:
+		return nil, nil, fmt.Errorf("invalid --engine-runtime %q, expected %s, %s or %s", runtime, engine.RuntimeDocker, engine.RuntimeContainerd, engine.RuntimePodman)
+	case c.Bool("engine-local-only") && runtime != engine.RuntimeContainerd:
+		return nil, nil, errors.New("--engine-local-only requires --engine-runtime containerd")
+	case c.Bool("engine-gpu-runtime") && runtime != engine.RuntimeDocker:
+		return nil, nil, errors.New("--engine-gpu-runtime requires --engine-runtime docker")
+	case h.HostOptions.SwarmOptions.IsSwarm && runtime != engine.RuntimeDocker:
+		return nil, nil, errors.New("--swarm requires --engine-runtime docker")
+	}
+
+	if user := c.String("engine-rootless-user"); user != "" {
+		switch {
+		case c.String("engine-runtime") != engine.RuntimeDocker:
+			return nil, nil, errors.New("--engine-rootless-user requires --engine-runtime docker")
+		case enginePort < 1024:
+			return nil, nil, errors.New("--engine-rootless-user requires an --engine-port of 1024 or more, which users can listen on")
+		case c.Bool("engine-gpu-runtime"):
+			return nil, nil, errors.New("--engine-rootless-user cannot be used with --engine-gpu-runtime")
+		case h.HostOptions.SwarmOptions.IsSwarm:
+			return nil, nil, errors.New("--engine-rootless-user cannot be used with --swarm")
+		}
+		if err := provision.ValidateRootlessUser(user); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-rootless-user: %s", err)
+		}
+	}
+
+	for _, name := range []string{"engine-http-proxy", "engine-https-proxy"} {
+		if proxy := c.String(name); proxy != "" {
+			if err := provision.ValidateProxyURL(proxy); err != nil {
+				return nil, nil, fmt.Errorf("invalid --%s: %s", name, err)
+			}
+		}
+	}
+	if noProxy := c.String("engine-no-proxy"); noProxy != "" {
+		if err := provision.ValidateNoProxy(noProxy); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-no-proxy: %s", err)
+		}
+	}
+
+	for _, name := range []string{"engine-package-mirror", "engine-download-url"} {
+		if mirror := c.String(name); mirror != "" {
+			if err := provision.ValidateMirrorURL(mirror); err != nil {
+				return nil, nil, fmt.Errorf("invalid --%s: %s", name, err)
+			}
+		}
+	}
+
+	engineOptions := h.HostOptions.EngineOptions
+	if engineOptions.RegistryCA, err = provision.ParseRegistryCAs(c.StringSlice("engine-registry-ca")); err != nil {
+		return nil, nil, fmt.Errorf("invalid --engine-registry-ca: %s", err)
+	}
+	for _, registry := range engineOptions.InsecureRegistry {
+		if _, ok := engineOptions.RegistryCA[registry]; ok {
+			return nil, nil, fmt.Errorf("registry %s is both insecure and trusted with --engine-registry-ca", registry)
+		}
+	}
+	if engineOptions.RegistryAuth, err = provision.ParseRegistryAuth(c.StringSlice("engine-registry-auth")); err != nil {
+		return nil, nil, fmt.Errorf("invalid --engine-registry-auth: %s", err)
+	}
+	if engineOptions.Sysctl, err = provision.ParseSysctls(c.StringSlice("engine-sysctl")); err != nil {
+		return nil, nil, fmt.Errorf("invalid --engine-sysctl: %s", err)
+	}
+	for _, module := range engineOptions.KernelModules {
+		if err := provision.ValidateKernelModule(module); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-kernel-module: %s", err)
+		}
+	}
+
+	if daemonJSON := c.String("engine-daemon-json"); daemonJSON != "" {
+		if c.String("engine-runtime") != engine.RuntimeDocker {
+			return nil, nil, errors.New("--engine-daemon-json requires --engine-runtime docker")
+		}
+		if engineOptions.DaemonJSON, err = provision.ReadDaemonJSON(daemonJSON); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-daemon-json: %s", err)
+		}
+		if err := provision.CheckDaemonJSON(engineOptions.DaemonJSON, *engineOptions); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-daemon-json: %s", err)
+		}
+	}
+
+	if version := c.String("engine-version"); version != "" {
+		if c.String("engine-runtime") != engine.RuntimeDocker {
+			return nil, nil, errors.New("--engine-version requires --engine-runtime docker")
+		}
+		if err := provision.ValidateEngineVersion(version); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-version: %s", err)
+		}
+	}
+
+	if tarball := c.String("engine-install-tarball"); tarball != "" {
+		if c.String("engine-runtime") != engine.RuntimeDocker {
+			return nil, nil, errors.New("--engine-install-tarball requires --engine-runtime docker")
+		}
+		if err := provision.CheckEngineBundle(tarball); err != nil {
+			return nil, nil, fmt.Errorf("invalid --engine-install-tarball: %s", err)
+		}
+		// the bundle is read again when the machine is provisioned
+		if h.HostOptions.EngineOptions.InstallTarball, err = filepath.Abs(tarball); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, san := range c.StringSlice("tls-san") {
+		if san == "" || strings.ContainsAny(san, " \t,") {
+			return nil, nil, fmt.Errorf("invalid --tls-san %q, expected a DNS name or an IP", san)
+		}
+	}
+	if subject := c.String("tls-subject"); subject != "" {
+		if _, err := cert.ParseSubject(subject); err != nil {
+			return nil, nil, fmt.Errorf("invalid --tls-subject: %s", err)
+		}
+	}
+
+	if c.GlobalString("tls-ca-signer") != "" && c.GlobalString("tls-ca-cert") == "" {
+		return nil, nil, errors.New("--tls-ca-signer requires --tls-ca-cert, the CA the certificates it signs chain to")
+	}
+
+	bastion, err := sshBastion(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	exists, err := api.Exists(h.Name)
 	if err != nil {
-		return fmt.Errorf("error checking if host exists: %s", err)
+		return nil, nil, fmt.Errorf("error checking if host exists: %s", err)
 	}
 	if exists {
-		return mcnerror.ErrHostAlreadyExists{
+		return nil, nil, mcnerror.ErrHostAlreadyExists{
 			Name: h.Name,
 		}
 	}
@@ -234,6 +699,18 @@ func cmdCreate(c CommandLine, api libmachine.API) error {
 	userdataFlag := drivers.DriverUserdataFlag(h.Driver)
 	osFlag := drivers.DriverOSFlag(h.Driver)
 
+	h.HostOptions.MachineOS = host.DefaultMachineOS
+	if osFlag != "" && driverOpts.String(osFlag) != "" {
+		h.HostOptions.MachineOS = driverOpts.String(osFlag)
+	}
+
+	if account := c.String("keyring-account"); account != "" {
+		if err := setKeyringCredentials(c, driverOpts, mcnFlags, driverName, account); err != nil {
+			return nil, nil, err
+		}
+		h.HostOptions.KeyringAccount = account
+	}
+
 	customInstallScript := c.String("custom-install-script")
 	h.HostOptions.HostnameOverride = c.String("hostname-override")
 	if customInstallScript != "" {
@@ -245,41 +722,85 @@ func cmdCreate(c CommandLine, api libmachine.API) error {
 		if userdataFlag != "" {
 			err = updateUserdataFile(driverOpts, name, h.HostOptions.HostnameOverride, userdataFlag, osFlag, customInstallScript)
 			if err != nil {
-				return fmt.Errorf("could not alter cloud-init file: %v", err)
+				return nil, nil, fmt.Errorf("could not alter cloud-init file: %v", err)
 			}
 		}
 	}
 
 	if err := h.Driver.SetConfigFromFlags(driverOpts); err != nil {
-		return fmt.Errorf("error setting machine configuration from flags provided: %s", err)
+		return nil, nil, fmt.Errorf("error setting machine configuration from flags provided: %s", err)
 	}
 
-	if err := api.Create(h); err != nil {
-		// Wait for all the logs to reach the client
-		time.Sleep(2 * time.Second)
+	if len(h.Labels) > 0 {
+		if err := drivers.SetLabels(h.Driver, h.Labels); err != nil {
+			return nil, nil, fmt.Errorf("error setting the labels of the machine: %s", err)
+		}
+	}
 
-		vBoxLog := ""
-		if h.DriverName == "virtualbox" {
-			vBoxLog = filepath.Join(api.GetMachinesDir(), h.Name, h.Name, "Logs", "VBox.log")
+	if enginePort != engine.DefaultPort {
+		if err := drivers.SetEnginePort(h.Driver, enginePort); err != nil {
+			return nil, nil, fmt.Errorf("error setting the engine port of the machine: %s", err)
 		}
+	}
 
-		return crashreport.CrashError{
-			Cause:       err,
-			Command:     "Create",
-			Context:     "api.performCreate",
-			DriverName:  h.DriverName,
-			LogFilePath: vBoxLog,
+	if bastion != nil {
+		if err := drivers.SetSSHBastion(h.Driver, bastion); err != nil {
+			return nil, nil, fmt.Errorf("error setting the SSH bastion of the machine: %s", err)
 		}
 	}
 
-	if err := api.Save(h); err != nil {
-		return fmt.Errorf("error attempting to save store: %s", err)
+	return h, driverOpts, nil
+}
+
+// sshBastion returns the jump host given by --ssh-bastion-host, nil if none.
+func sshBastion(c CommandLine) (*ssh.Bastion, error) {
+	if c.String("ssh-bastion-host") == "" {
+		if c.String("ssh-bastion-user") != "" || c.String("ssh-bastion-key") != "" {
+			return nil, errors.New("--ssh-bastion-user and --ssh-bastion-key require --ssh-bastion-host")
+		}
+		return nil, nil
 	}
 
-	if customInstallScript == "" {
-		log.Infof("to see how to connect your Docker Client to the Docker Engine running on this virtual machine, run: %s env %s", os.Args[0], name)
+	bastion, err := ssh.ParseBastion(c.String("ssh-bastion-host"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --ssh-bastion-host: %s", err)
 	}
+	if user := c.String("ssh-bastion-user"); user != "" {
+		bastion.User = user
+	}
+	if keyPath := c.String("ssh-bastion-key"); keyPath != "" {
+		if bastion.KeyPath, err = filepath.Abs(keyPath); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(bastion.KeyPath); err != nil {
+			return nil, fmt.Errorf("invalid --ssh-bastion-key: %s", err)
+		}
+	}
+	return bastion, nil
+}
 
+// setKeyringCredentials sets the driver flags not given on the command line
+// from the credentials of account in the OS keyring.
+func setKeyringCredentials(c CommandLine, driverOpts *rpcdriver.RPCFlags, mcnFlags []mcnflag.Flag, driverName, account string) error {
+	credentials, err := persist.KeyringCredentials(driverName, account)
+	if err != nil {
+		return err
+	}
+
+	flags := map[string]mcnflag.Flag{}
+	for _, f := range mcnFlags {
+		flags[f.String()] = f
+	}
+	for name, value := range credentials {
+		switch flags[name].(type) {
+		case mcnflag.StringFlag, *mcnflag.StringFlag:
+		default:
+			return fmt.Errorf("invalid credentials for account %s in the keyring: %s is not a string flag of driver %s", account, name, driverName)
+		}
+		if !c.IsSet(name) {
+			driverOpts.Values[name] = value
+		}
+	}
 	return nil
 }
 
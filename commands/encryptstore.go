@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/persist"
+)
+
+var errNoEncryptionKey = errors.New("no encryption key: set MACHINE_ENCRYPTION_KEY, --encryption-key-file or --encryption-kms-plugin")
+
+// encryptionFromFlags returns the key encrypting the secrets of the machine
+// configs, or nil if none is configured.
+func encryptionFromFlags(c CommandLine) (persist.KeyWrapper, error) {
+	return persist.NewKeyWrapper(os.Getenv("MACHINE_ENCRYPTION_KEY"), c.GlobalString("encryption-key-file"), c.GlobalString("encryption-kms-plugin"))
+}
+
+// cmdEncryptStore encrypts the secrets of the configs of existing machines,
+// saved in plaintext before encryption was configured.
+func cmdEncryptStore(c CommandLine, api libmachine.API) error {
+	encryption, err := encryptionFromFlags(c)
+	if err != nil {
+		return err
+	}
+	if encryption == nil {
+		return errNoEncryptionKey
+	}
+
+	names := c.Args()
+	if len(names) == 0 {
+		if names, err = api.List(); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockHosts(api, names)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the configs are loaded from the store, as the drivers need not be
+	// running to save them
+	var store persist.Store = api
+	if client, ok := api.(*libmachine.Client); ok {
+		store = client.Store
+	}
+
+	errs := []error{}
+	for _, name := range names {
+		h, err := store.Load(name)
+		if err == nil {
+			err = store.Save(h)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+			continue
+		}
+		log.Infof("Encrypted the config of %s", name)
+	}
+
+	if len(errs) > 0 {
+		return consolidateErrs(errs)
+	}
+	return nil
+}
@@ -103,7 +103,7 @@ func shellCfgSet(c CommandLine, api libmachine.API) (*ShellConfig, error) {
 	}
 
 	if c.Bool("no-proxy") {
-		ip, err := host.Driver.GetIP()
+		ip, err := host.IP()
 		if err != nil {
 			return nil, fmt.Errorf("Error getting host IP: %s", err)
 		}
@@ -124,6 +124,8 @@ func shellCfgSet(c CommandLine, api libmachine.API) (*ShellConfig, error) {
 		shellCfg.NoProxyValue = noProxyValue
 	}
 
+	saveIPCache(api, host)
+
 	if runtimeOS() == "windows" {
 		shellCfg.ComposePathsVar = true
 	}
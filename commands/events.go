@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/events"
+)
+
+// actionEvents maps the actions to the types of the events published
+// before and after them.
+var actionEvents = map[string][2]events.Type{
+	"start":     {events.Starting, events.Started},
+	"stop":      {events.Stopping, events.Stopped},
+	"restart":   {events.Restarting, events.Restarted},
+	"kill":      {events.Killing, events.Killed},
+	"provision": {events.Provisioning, events.Provisioned},
+}
+
+// eventsOf returns the event bus of api, or nil if it has none.
+func eventsOf(api libmachine.API) *events.Bus {
+	if client, ok := api.(*libmachine.Client); ok {
+		return client.Events
+	}
+	return nil
+}
+
+// cmdEvents prints the events of the machines journaled in the store,
+// following the new ones with --follow.
+func cmdEvents(c CommandLine, api libmachine.API) error {
+	machines := map[string]bool{}
+	for _, name := range c.Args() {
+		machines[name] = true
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	bus := eventsOf(api)
+	if bus == nil || bus.Journal == "" {
+		return errors.New("the events of the machines are not journaled")
+	}
+
+	return events.ReadJournal(ctx, bus.Journal, c.Bool("follow"), func(e events.Event) error {
+		if len(machines) > 0 && !machines[e.Machine] {
+			return nil
+		}
+
+		if c.Bool("json") {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			return nil
+		}
+
+		line := fmt.Sprintf("%s %s %s", e.Time.Local().Format(time.RFC3339), e.Machine, e.Type)
+		if e.Error != "" {
+			line += ": " + e.Error
+		}
+		if e.Progress != nil {
+			line += fmt.Sprintf(": %s %s (%d%%)", e.Progress.Operation, e.Progress.Status, e.Progress.Percent)
+		}
+		fmt.Println(line)
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/healthcheck"
+	"github.com/rancher/machine/libmachine/persist"
+)
+
+// cmdHealthcheck checks the health of the machines, failing if one of them
+// is unhealthy.
+func cmdHealthcheck(c CommandLine, api libmachine.API) error {
+	names := c.Args()
+	if len(names) == 0 {
+		target, err := targetHost(c, api)
+		if err != nil {
+			return err
+		}
+		names = []string{target}
+	}
+
+	if threshold := c.Int("disk-threshold"); threshold > 0 {
+		healthcheck.DiskPressureThreshold = threshold
+	}
+
+	hosts, hostsInError := persist.LoadHosts(api, names)
+	if len(hostsInError) > 0 {
+		errs := []error{}
+		for _, err := range hostsInError {
+			errs = append(errs, err)
+		}
+		return consolidateErrs(errs)
+	}
+
+	reports := make([]*healthcheck.Report, len(hosts))
+	var wg sync.WaitGroup
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reports[i] = healthcheck.Check(hosts[i])
+		}(i)
+	}
+	wg.Wait()
+
+	unhealthy := []string{}
+	for _, r := range reports {
+		if c.Bool("json") {
+			line, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+		} else {
+			printHealthReport(os.Stdout, r)
+		}
+
+		if !r.Healthy {
+			unhealthy = append(unhealthy, r.Machine)
+		}
+	}
+
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("unhealthy machines: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+func printHealthReport(w io.Writer, r *healthcheck.Report) {
+	health := "healthy"
+	if !r.Healthy {
+		health = "unhealthy"
+	}
+	fmt.Fprintf(w, "%s: %s\n", r.Machine, health)
+
+	tabWriter := tabwriter.NewWriter(w, 5, 1, 3, ' ', 0)
+	for _, check := range r.Checks {
+		fmt.Fprintf(tabWriter, "  %s\t%s\t%s\n", check.Name, check.Status, check.Message)
+	}
+	tabWriter.Flush()
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rancher/machine/commands/commandstest"
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/healthcheck"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/libmachinetest"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdHealthcheckUnhealthy(t *testing.T) {
+	commandLine := &commandstest.FakeCommandLine{
+		CliArgs: []string{"machine"},
+		LocalFlags: &commandstest.FakeFlagger{
+			Data: map[string]interface{}{"json": true},
+		},
+	}
+	api := &libmachinetest.FakeAPI{
+		Hosts: []*host.Host{
+			{
+				Name: "machine",
+				Driver: &fakedriver.Driver{
+					MockState: state.Stopped,
+				},
+			},
+		},
+	}
+
+	stdoutGetter := commandstest.NewStdoutGetter()
+	defer stdoutGetter.Stop()
+
+	err := cmdHealthcheck(commandLine, api)
+
+	assert.EqualError(t, err, "unhealthy machines: machine")
+	report := healthcheck.Report{}
+	assert.NoError(t, json.Unmarshal([]byte(stdoutGetter.Output()), &report))
+	assert.Equal(t, "machine", report.Machine)
+	assert.False(t, report.Healthy)
+	assert.Equal(t, healthcheck.Fail, report.Checks[0].Status)
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/hooks"
+)
+
+// actionHooks maps the actions to the phases of the hooks run before and
+// after them.
+var actionHooks = map[string][2]hooks.Phase{
+	"provision": {hooks.PreProvision, hooks.PostProvision},
+}
+
+// hooksOf returns the hooks of api, or nil if it has none.
+func hooksOf(api libmachine.API) *hooks.Runner {
+	if client, ok := api.(*libmachine.Client); ok {
+		return client.Hooks
+	}
+	return nil
+}
+
+// addHooksFromFlags adds the hooks given by --hook and --remote-hook.
+func addHooksFromFlags(api *libmachine.Client, local, remote []string) error {
+	if api.Hooks == nil {
+		api.Hooks = &hooks.Runner{}
+	}
+	for i, flags := range [][]string{local, remote} {
+		for _, flag := range flags {
+			hook, err := hooks.Parse(flag, i == 1)
+			if err != nil {
+				return err
+			}
+			api.Hooks.Hooks = append(api.Hooks.Hooks, hook)
+		}
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"text/template"
 
 	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
 )
 
 var funcMap = template.FuncMap{
@@ -35,6 +37,9 @@ func cmdInspect(c CommandLine, api libmachine.API) error {
 	if err != nil {
 		return err
 	}
+	if !log.SecretsShown() {
+		host.Driver = drivers.Redact(host.Driver)
+	}
 
 	tmplString := c.String("format")
 	if tmplString != "" {
@@ -60,12 +65,13 @@ func cmdInspect(c CommandLine, api libmachine.API) error {
 
 		os.Stdout.Write([]byte{'\n'})
 	} else {
-		prettyJSON, err := json.MarshalIndent(host, "", "    ")
-		if err != nil {
+		// keep the <REDACTED> secrets readable
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "    ")
+		if err := encoder.Encode(host); err != nil {
 			return err
 		}
-
-		fmt.Println(string(prettyJSON))
 	}
 
 	return nil
@@ -3,5 +3,8 @@ package commands
 import "github.com/rancher/machine/libmachine"
 
 func cmdIP(c CommandLine, api libmachine.API) error {
+	if c.Bool("refresh") {
+		return runAction("refreshIP", c, api)
+	}
 	return runAction("ip", c, api)
 }
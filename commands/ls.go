@@ -28,7 +28,7 @@ import (
 const (
 	lsDefaultTimeout = 10
 	tableFormatKey   = "table"
-	lsDefaultFormat  = "table {{ .Name }}\t{{ .Active }}\t{{ .DriverName}}\t{{ .State }}\t{{ .URL }}\t{{ .Swarm }}\t{{ .DockerVersion }}\t{{ .Error}}"
+	lsDefaultFormat  = "table {{ .Name }}\t{{ .Active }}\t{{ .DriverName}}\t{{ .State }}\t{{ .URL }}\t{{ .Swarm }}\t{{ .DockerVersion }}\t{{ .Labels }}\t{{ .Error}}"
 )
 
 var (
@@ -46,6 +46,8 @@ var (
 		"Error":         "ERRORS",
 		"DockerVersion": "DOCKER",
 		"ResponseTime":  "RESPONSE",
+		"Labels":        "LABELS",
+		"CertsExpiry":   "CERTS_EXPIRY",
 	}
 )
 
@@ -63,6 +65,11 @@ type HostListItem struct {
 	Error         string
 	DockerVersion string
 	ResponseTime  time.Duration
+	// Labels are the labels of the host as key=value, comma separated
+	Labels string
+	// CertsExpiry is the date the first of the certificates of the host
+	// expires, if known
+	CertsExpiry string
 }
 
 // FilterOptions -
@@ -148,9 +155,40 @@ func cmdLs(c CommandLine, api libmachine.API) error {
 		}
 	}
 
+	if tabWriter, ok := w.(*tabwriter.Writer); ok {
+		tabWriter.Flush()
+	}
+	warnExpiringCerts(hostList)
+
 	return nil
 }
 
+// certsExpiry returns the date the first of the certificates of h expires,
+// empty if it is not known.
+func certsExpiry(h *host.Host) string {
+	notAfter, err := h.CertsNotAfter()
+	if err != nil || notAfter.IsZero() {
+		return ""
+	}
+	return notAfter.Local().Format("2006-01-02")
+}
+
+// warnExpiringCerts warns about the hosts whose certificates expire in less
+// than host.CertExpiryWarning.
+func warnExpiringCerts(hostList []*host.Host) {
+	for _, h := range hostList {
+		if expiring, _ := h.CertsExpiring(); !expiring {
+			continue
+		}
+		notAfter, _ := h.CertsNotAfter()
+		if notAfter.Before(time.Now()) {
+			log.Warnf("The certificates of %s have expired, run rotate-certs %s to renew them", h.Name, h.Name)
+		} else {
+			log.Warnf("The certificates of %s expire on %s, run rotate-certs %s to renew them", h.Name, notAfter.Local().Format("2006-01-02"), h.Name)
+		}
+	}
+}
+
 func parseFormat(format string) (*template.Template, bool, error) {
 	table := false
 	finalFormat := format
@@ -305,23 +343,31 @@ func matchesName(host *host.Host, names []string) bool {
 	return false
 }
 
+// matchesLabel tells whether the host or its engine has one of labels, given
+// as key=value, or as key for any value.
 func matchesLabel(host *host.Host, labels []string) bool {
 	if len(labels) == 0 {
 		return true
 	}
 
-	var englabels = make(map[string]string, len(host.HostOptions.EngineOptions.Labels))
+	var englabels = map[string]string{}
 
-	if host.HostOptions != nil && host.HostOptions.EngineOptions.Labels != nil {
+	if host.HostOptions != nil && host.HostOptions.EngineOptions != nil {
 		for _, s := range host.HostOptions.EngineOptions.Labels {
 			kv := strings.SplitN(s, "=", 2)
-			englabels[kv[0]] = kv[1]
+			if len(kv) == 2 {
+				englabels[kv[0]] = kv[1]
+			}
 		}
 	}
 
+	matches := func(hostLabels map[string]string, kv []string) bool {
+		val, exists := hostLabels[kv[0]]
+		return exists && (len(kv) == 1 || strings.EqualFold(val, kv[1]))
+	}
 	for _, l := range labels {
 		kv := strings.SplitN(l, "=", 2)
-		if val, exists := englabels[kv[0]]; exists && strings.EqualFold(val, kv[1]) {
+		if matches(host.Labels, kv) || matches(englabels, kv) {
 			return true
 		}
 	}
@@ -352,7 +398,9 @@ func attemptGetHostState(h *host.Host, stateQueryChan chan<- HostListItem) {
 		currentState, _ = h.Driver.GetState()
 	}
 
-	if err == nil && url != "" {
+	if h.HostOptions != nil && h.HostOptions.EngineOptions.IsContainerd() {
+		dockerVersion = engine.RuntimeContainerd
+	} else if err == nil && url != "" {
 		// PERFORMANCE: Reuse the url instead of asking the host again.
 		// This reduces the number of calls to the drivers
 		dockerHost := &mcndockerclient.RemoteDocker{
@@ -412,6 +460,8 @@ func attemptGetHostState(h *host.Host, stateQueryChan chan<- HostListItem) {
 		DockerVersion: dockerVersion,
 		Error:         hostError,
 		ResponseTime:  time.Now().Round(time.Millisecond).Sub(requestBeginning.Round(time.Millisecond)),
+		Labels:        h.LabelsString(),
+		CertsExpiry:   certsExpiry(h),
 	}
 }
 
@@ -435,6 +485,8 @@ func getHostState(h *host.Host, hostListItemsChan chan<- HostListItem, timeout t
 			DriverName:   h.Driver.DriverName(),
 			State:        state.Timeout,
 			ResponseTime: timeout,
+			Labels:       h.LabelsString(),
+			CertsExpiry:  certsExpiry(h),
 		}
 	}
 }
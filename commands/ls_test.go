@@ -111,6 +111,22 @@ func TestFilterHostsReturnSetLabel(t *testing.T) {
 	assert.EqualValues(t, actual, hosts)
 }
 
+func TestFilterHostsByHostLabel(t *testing.T) {
+	prod := &host.Host{
+		Name:   "prod",
+		Labels: map[string]string{"env": "prod"},
+	}
+	dev := &host.Host{
+		Name:   "dev",
+		Labels: map[string]string{"env": "dev"},
+	}
+	unlabelled := &host.Host{Name: "unlabelled"}
+	hosts := []*host.Host{prod, dev, unlabelled}
+
+	assert.Equal(t, []*host.Host{prod}, filterHosts(hosts, FilterOptions{Labels: []string{"env=prod"}}))
+	assert.Equal(t, []*host.Host{prod, dev}, filterHosts(hosts, FilterOptions{Labels: []string{"env"}}))
+}
+
 func TestFilterHostsReturnsEmptyGivenEmptyHosts(t *testing.T) {
 	opts := FilterOptions{
 		SwarmName: []string{"foo"},
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/persist"
+	"github.com/rancher/machine/libmachine/version"
+)
+
+// cmdMigrate upgrades the configs of machines to the current version, which
+// is otherwise done when they are first loaded. With --dry-run, it only
+// lists the migrations.
+func cmdMigrate(c CommandLine, api libmachine.API) error {
+	names := c.Args()
+	if len(names) == 0 {
+		var err error
+		if names, err = api.List(); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockHosts(api, names)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var store persist.Store = api
+	if client, ok := api.(*libmachine.Client); ok {
+		store = client.Store
+	}
+
+	errs := []error{}
+	for _, name := range names {
+		if err := migrateHost(store, name, c.Bool("dry-run")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return consolidateErrs(errs)
+	}
+	return nil
+}
+
+func migrateHost(store persist.Store, name string, dryRun bool) error {
+	data, err := ioutil.ReadFile(filepath.Join(store.GetMachinesDir(), name, "config.json"))
+	if err != nil {
+		return err
+	}
+	pending, err := host.PendingMigrations(data)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		log.Infof("%s: the config is up to date (version %d)", name, version.ConfigVersion)
+		return nil
+	}
+	for _, m := range pending {
+		log.Infof("%s: version %d to %d: %s", name, m.From, m.From+1, m.Description)
+	}
+	if dryRun {
+		return nil
+	}
+
+	h, err := store.Load(name)
+	if err != nil {
+		return err
+	}
+	// the file store saves the configs it migrates, keeping the previous
+	// one as a backup, which saving again would replace
+	if _, ok := store.(*persist.Filestore); !ok {
+		if err := store.Save(h); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("%s: migrated the config to version %d", name, version.ConfigVersion)
+	return nil
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/persist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateHost(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "machine-migrate-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	store := persist.NewFilestore(storePath, "", "")
+	config := filepath.Join(store.GetMachinesDir(), "default", "config.json")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(config), 0700))
+	assert.NoError(t, ioutil.WriteFile(config, []byte(`{
+    "ConfigVersion": 3,
+    "Driver": {"MachineName": "default"},
+    "DriverName": "none",
+    "HostOptions": {"AuthOptions": {"StorePath": "`+filepath.Dir(config)+`"}},
+    "Name": "default"
+}`), 0600))
+
+	assert.NoError(t, migrateHost(store, "default", true))
+	data, err := ioutil.ReadFile(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"ConfigVersion": 3`)
+
+	assert.NoError(t, migrateHost(store, "default", false))
+	data, err = ioutil.ReadFile(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"ConfigVersion": 4`)
+	assert.Contains(t, string(data), `"MachineOS": "linux"`)
+
+	backup, err := ioutil.ReadFile(config + ".bak")
+	assert.NoError(t, err)
+	assert.Contains(t, string(backup), `"ConfigVersion": 3`)
+}
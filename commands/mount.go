@@ -71,7 +71,7 @@ func getMountCmd(src, dest string, unmount bool, hostInfoLoader HostInfoLoader)
 		dest = srcPath
 	}
 
-	sshArgs := baseSSHFSArgs
+	sshArgs := withHostKeyChecking(baseSSHFSArgs, srcHost.GetMachineName())
 	if srcHost.GetSSHKeyPath() != "" {
 		sshArgs = append(sshArgs, "-o", "IdentitiesOnly=yes")
 	}
@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// createPlan is what creating a machine would do.
+type createPlan struct {
+	Host *host.Host
+	// Driver holds the resources resolved by the driver, if it can tell them
+	Driver []drivers.PlanItem
+	// CloudInit is the user data sent to the machine, if any
+	CloudInit string
+}
+
+// planCreate prints what creating the machines would do. The pre-create
+// checks of their driver run, but nothing is created.
+func planCreate(c CommandLine, api libmachine.API, names []string) error {
+	for _, name := range names {
+		plan, err := newCreatePlan(c, api, name)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		plan.print(os.Stdout)
+	}
+
+	log.Infof("Dry run, no machine created")
+	return nil
+}
+
+func newCreatePlan(c CommandLine, api libmachine.API, name string) (*createPlan, error) {
+	h, driverOpts, err := newHostAndDriverOpts(c, api, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Driver.PreCreateCheck(); err != nil {
+		return nil, fmt.Errorf("error with pre-create check: %s", err)
+	}
+
+	plan := &createPlan{Host: h}
+	if plan.Driver, err = drivers.Plan(h.Driver); err != nil {
+		return nil, fmt.Errorf("error planning the creation: %s", err)
+	}
+
+	if userdataFlag := drivers.DriverUserdataFlag(h.Driver); userdataFlag != "" {
+		if path := driverOpts.String(userdataFlag); path != "" {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the user data: %s", err)
+			}
+			plan.CloudInit = string(data)
+		}
+	}
+
+	return plan, nil
+}
+
+func (p *createPlan) print(w io.Writer) {
+	tabWriter := tabwriter.NewWriter(w, 5, 1, 3, ' ', 0)
+	line := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(tabWriter, "%s:\t%s\n", name, value)
+		}
+	}
+
+	line("Machine", p.Host.Name)
+	line("Driver", p.Host.DriverName)
+	for _, item := range p.Driver {
+		line(item.Name, item.Value)
+	}
+
+	options := p.Host.HostOptions
+	line("Custom install script", options.CustomInstallScript)
+	if engine := options.EngineOptions; engine != nil {
+		line("Engine install URL", engine.InstallURL)
+		line("Engine download URL", engine.DownloadURL)
+		line("Package mirror", engine.PackageMirror)
+		line("HTTP proxy", engine.HTTPProxy)
+		line("HTTPS proxy", engine.HTTPSProxy)
+		line("No proxy", engine.NoProxy)
+		line("Engine storage driver", engine.StorageDriver)
+		line("Engine options", strings.Join(engine.ArbitraryFlags, ", "))
+		line("Engine daemon.json", engine.DaemonJSON)
+		line("Engine labels", strings.Join(engine.Labels, ", "))
+		line("Engine environment", strings.Join(engine.Env, ", "))
+		line("Insecure registries", strings.Join(engine.InsecureRegistry, ", "))
+		line("Registry mirrors", strings.Join(engine.RegistryMirror, ", "))
+	}
+	if swarm := options.SwarmOptions; swarm != nil && swarm.IsSwarm {
+		line("Swarm discovery", swarm.Discovery)
+		line("Swarm master", fmt.Sprint(swarm.Master))
+	}
+	tabWriter.Flush()
+
+	if p.CloudInit != "" {
+		fmt.Fprintf(w, "Cloud-init:\n%s\n", strings.TrimRight(p.CloudInit, "\n"))
+	}
+	fmt.Fprintln(w)
+}
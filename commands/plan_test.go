@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePlanPrint(t *testing.T) {
+	plan := &createPlan{
+		Host: &host.Host{
+			Name:       "node-7",
+			DriverName: "amazonec2",
+			HostOptions: &host.Options{
+				EngineOptions: &engine.Options{
+					InstallURL: "https://get.docker.com",
+					Labels:     []string{"role=worker", "env=prod"},
+				},
+			},
+		},
+		Driver: []drivers.PlanItem{
+			{Name: "Zone", Value: "eu-west-1a"},
+			{Name: "Subnet", Value: ""},
+		},
+		CloudInit: "#cloud-config\nhostname: node-7\n",
+	}
+
+	out := &bytes.Buffer{}
+	plan.print(out)
+
+	assert.Equal(t, `Machine:              node-7
+Driver:               amazonec2
+Zone:                 eu-west-1a
+Engine install URL:   https://get.docker.com
+Engine labels:        role=worker, env=prod
+Cloud-init:
+#cloud-config
+hostname: node-7
+
+`, out.String())
+}
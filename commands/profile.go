@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/persist"
+	"github.com/urfave/cli"
+)
+
+// profileFlagsExcluded are the create flags never saved in a profile.
+var profileFlagsExcluded = map[string]bool{
+	"driver":       true,
+	"dry-run":      true,
+	"file":         true,
+	"profile":      true,
+	"save-profile": true,
+}
+
+// Profile is a named set of create flags, saved in the profiles directory of
+// the store so that machines can be created alike with --profile.
+type Profile struct {
+	Driver string
+	// Flags holds the values of the flags set, as given on the command line
+	Flags map[string][]string
+}
+
+func profilePath(storePath, name string) (string, error) {
+	if !host.ValidateHostName(name) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	return filepath.Join(storePath, "profiles", name+".json"), nil
+}
+
+func loadProfile(storePath, name string) (*Profile, error) {
+	path, err := profilePath(storePath, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("profile %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Profile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %s", name, err)
+	}
+	return profile, nil
+}
+
+func saveProfile(storePath, name string, profile *Profile) error {
+	data, err := json.MarshalIndent(profile, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	path, err := profilePath(storePath, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// profileFromFlags returns the profile of the create flags set. The secrets
+// are left out, to be given on the command line or with --keyring-account.
+func profileFromFlags(c CommandLine) *Profile {
+	profile := &Profile{
+		Driver: c.String("driver"),
+		Flags:  map[string][]string{},
+	}
+
+	for _, name := range c.FlagNames() {
+		if profileFlagsExcluded[name] || !c.IsSet(name) {
+			continue
+		}
+		if persist.IsSensitiveFlag(name) {
+			log.Warnf("Not saving the secret flag --%s in the profile", name)
+			continue
+		}
+		if getter, ok := c.Generic(name).(flag.Getter); ok {
+			profile.Flags[name] = []string{fmt.Sprint(getter.Get())}
+		} else {
+			profile.Flags[name] = c.StringSlice(name)
+		}
+	}
+	return profile
+}
+
+// profileDriver returns the driver of the spec given with --file or of the
+// profile given with --profile, or "" if there is none or --driver overrides
+// it.
+func profileDriver(c CommandLine) (string, error) {
+	if _, ok := getFlagValue(c.Args(), "--driver", "-d", ""); ok {
+		return "", nil
+	}
+
+	if path, ok := getFlagValue(c.Args(), "--file", "-f", ""); ok && path != "" {
+		spec, err := loadSpec(path)
+		if err != nil {
+			return "", err
+		}
+		return spec.Driver, nil
+	}
+
+	name, ok := getFlagValue(c.Args(), "--profile", "", "MACHINE_PROFILE")
+	if !ok || name == "" {
+		return "", nil
+	}
+
+	profile, err := loadProfile(c.GlobalString("storage-path"), name)
+	if err != nil {
+		return "", err
+	}
+	return profile.Driver, nil
+}
+
+// withProfile returns c with the flags of the spec given with --file or of
+// the profile given with --profile as the defaults of those not set on the
+// command line.
+func withProfile(c CommandLine) (CommandLine, error) {
+	if path := c.String("file"); path != "" {
+		if c.String("profile") != "" {
+			return nil, errors.New("invalid arguments: --file and --profile cannot be used together")
+		}
+		return withSpec(c, path)
+	}
+
+	name := c.String("profile")
+	if name == "" {
+		return c, nil
+	}
+	profile, err := loadProfile(c.GlobalString("storage-path"), name)
+	if err != nil {
+		return nil, err
+	}
+	return &profileCommandLine{CommandLine: c, profile: profile}, nil
+}
+
+// withSpec returns c with the flags and the machine names of the spec at
+// path, checking that its flags are create flags of its driver.
+func withSpec(c CommandLine, path string) (CommandLine, error) {
+	spec, err := loadSpec(path)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := map[string]bool{}
+	for _, name := range c.FlagNames() {
+		flags[name] = true
+	}
+	profile := spec.profile()
+	for name := range profile.Flags {
+		if !flags[name] {
+			return nil, fmt.Errorf("invalid spec %s: unknown flag %s of driver %s", path, name, c.String("driver"))
+		}
+	}
+
+	return &profileCommandLine{CommandLine: c, profile: profile, names: spec.names()}, nil
+}
+
+// profileCommandLine reads the flags not set on the command line from a
+// profile, and the machine names, if none is given, from names.
+type profileCommandLine struct {
+	CommandLine
+	profile *Profile
+	names   []string
+}
+
+func (c *profileCommandLine) Args() cli.Args {
+	if args := c.CommandLine.Args(); len(args) > 0 {
+		return args
+	}
+	return c.names
+}
+
+func (c *profileCommandLine) fromProfile(name string) ([]string, bool) {
+	if c.CommandLine.IsSet(name) {
+		return nil, false
+	}
+	if name == "driver" {
+		return []string{c.profile.Driver}, c.profile.Driver != ""
+	}
+	values, ok := c.profile.Flags[name]
+	return values, ok && len(values) > 0
+}
+
+func (c *profileCommandLine) IsSet(name string) bool {
+	_, ok := c.fromProfile(name)
+	return ok || c.CommandLine.IsSet(name)
+}
+
+func (c *profileCommandLine) Bool(name string) bool {
+	if values, ok := c.fromProfile(name); ok {
+		b, _ := strconv.ParseBool(values[0])
+		return b
+	}
+	return c.CommandLine.Bool(name)
+}
+
+func (c *profileCommandLine) Int(name string) int {
+	if values, ok := c.fromProfile(name); ok {
+		i, _ := strconv.Atoi(values[0])
+		return i
+	}
+	return c.CommandLine.Int(name)
+}
+
+func (c *profileCommandLine) String(name string) string {
+	if values, ok := c.fromProfile(name); ok {
+		return values[0]
+	}
+	return c.CommandLine.String(name)
+}
+
+func (c *profileCommandLine) StringSlice(name string) []string {
+	if values, ok := c.fromProfile(name); ok {
+		return values
+	}
+	return c.CommandLine.StringSlice(name)
+}
+
+// Generic returns the flags of the profile as values of the type of the flag,
+// which getDriverOpts sends to the driver.
+func (c *profileCommandLine) Generic(name string) interface{} {
+	value := c.CommandLine.Generic(name)
+	getter, isGetter := value.(flag.Getter)
+	values, ok := c.fromProfile(name)
+	if !ok || !isGetter {
+		return value
+	}
+
+	switch getter.Get().(type) {
+	case bool:
+		return profileValue{c.Bool(name)}
+	case int:
+		return profileValue{c.Int(name)}
+	}
+	return profileValue{values[0]}
+}
+
+// profileValue is a flag.Getter of a value read from a profile.
+type profileValue struct {
+	value interface{}
+}
+
+func (v profileValue) Get() interface{} {
+	return v.value
+}
+
+func (v profileValue) Set(string) error {
+	return fmt.Errorf("the flags of a profile are read-only")
+}
+
+func (v profileValue) String() string {
+	return fmt.Sprint(v.value)
+}
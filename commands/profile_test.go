@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rancher/machine/commands/commandstest"
+	"github.com/stretchr/testify/assert"
+)
+
+// flagSetCommandLine returns the values of a flag set as generic flags.
+type flagSetCommandLine struct {
+	*commandstest.FakeCommandLine
+	flags *flag.FlagSet
+}
+
+func (c *flagSetCommandLine) Generic(name string) interface{} {
+	return c.flags.Lookup(name).Value
+}
+
+func TestSaveAndLoadProfile(t *testing.T) {
+	storePath, err := ioutil.TempDir("", "machine-profile-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(storePath)
+
+	_, err = loadProfile(storePath, "prod-worker")
+	assert.EqualError(t, err, "profile prod-worker not found")
+
+	profile := &Profile{
+		Driver: "amazonec2",
+		Flags:  map[string][]string{"engine-label": {"role=worker", "env=prod"}},
+	}
+	assert.NoError(t, saveProfile(storePath, "prod-worker", profile))
+
+	loaded, err := loadProfile(storePath, "prod-worker")
+	assert.NoError(t, err)
+	assert.Equal(t, profile, loaded)
+
+	assert.EqualError(t, saveProfile(storePath, "../worker", profile), `invalid profile name "../worker"`)
+}
+
+func TestProfileFromFlags(t *testing.T) {
+	c := &commandstest.FakeCommandLine{
+		LocalFlags: &commandstest.FakeFlagger{
+			Data: map[string]interface{}{
+				"driver":               "amazonec2",
+				"save-profile":         "prod-worker",
+				"engine-label":         []string{"role=worker"},
+				"amazonec2-secret-key": []string{"s3cr3t"},
+			},
+		},
+	}
+
+	profile := profileFromFlags(c)
+	assert.Equal(t, "amazonec2", profile.Driver)
+	assert.Equal(t, map[string][]string{"engine-label": {"role=worker"}}, profile.Flags)
+}
+
+func TestProfileCommandLine(t *testing.T) {
+	c := &profileCommandLine{
+		CommandLine: &commandstest.FakeCommandLine{
+			LocalFlags: &commandstest.FakeFlagger{
+				Data: map[string]interface{}{
+					"engine-label": []string{"role=worker"},
+				},
+			},
+		},
+		profile: &Profile{
+			Driver: "amazonec2",
+			Flags: map[string][]string{
+				"engine-label":        {"role=ignored"},
+				"engine-opt":          {"log-level=debug"},
+				"swarm":               {"true"},
+				"parallelism":         {"3"},
+				"amazonec2-region":    {"eu-west-1"},
+				"amazonec2-root-size": {"64"},
+			},
+		},
+	}
+
+	assert.Equal(t, "amazonec2", c.String("driver"))
+	assert.Equal(t, []string{"role=worker"}, c.StringSlice("engine-label"))
+	assert.Equal(t, []string{"log-level=debug"}, c.StringSlice("engine-opt"))
+	assert.True(t, c.Bool("swarm"))
+	assert.Equal(t, 3, c.Int("parallelism"))
+	assert.True(t, c.IsSet("amazonec2-region"))
+	assert.False(t, c.IsSet("amazonec2-zone"))
+
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.Int("amazonec2-root-size", 16, "")
+	flags.Bool("swarm", false, "")
+	flags.String("amazonec2-region", "us-east-1", "")
+	c.CommandLine = &flagSetCommandLine{FakeCommandLine: c.CommandLine.(*commandstest.FakeCommandLine), flags: flags}
+
+	assert.Equal(t, 64, c.Generic("amazonec2-root-size").(flag.Getter).Get())
+	assert.Equal(t, true, c.Generic("swarm").(flag.Getter).Get())
+	assert.Equal(t, "eu-west-1", c.Generic("amazonec2-region").(flag.Getter).Get())
+}
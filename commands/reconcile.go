@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/reconcile"
+)
+
+// reconcileDefaultInterval is the default time in seconds between two checks
+// of the machines.
+const reconcileDefaultInterval = 30
+
+// cmdReconcile heals the machines found stopped or in error according to a
+// policy, until interrupted or once with --once.
+func cmdReconcile(c CommandLine, api libmachine.API) error {
+	names := c.Args()
+	if len(names) == 0 {
+		return ErrNoMachineSpecified
+	}
+
+	policy, err := reconcile.ParsePolicy(c.String("policy"))
+	if err != nil {
+		return err
+	}
+	if c.Int("interval") <= 0 {
+		return fmt.Errorf("invalid interval %d", c.Int("interval"))
+	}
+	interval := time.Duration(c.Int("interval")) * time.Second
+
+	for _, name := range names {
+		if _, err := api.Load(name); err != nil {
+			return err
+		}
+	}
+
+	r := &reconcile.Reconciler{
+		API:      api,
+		Policy:   policy,
+		Interval: interval,
+		Events:   eventsOf(api),
+		Report:   printReconcileResult,
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if c.Bool("once") {
+		for _, result := range r.Reconcile(ctx, names) {
+			if result.Err != nil {
+				return fmt.Errorf("error reconciling %s: %s", result.Machine, result.Err)
+			}
+		}
+		return nil
+	}
+
+	log.Infof("Reconciling %d machine(s) every %s with the policy %s...", len(names), interval, policy)
+	return r.Run(ctx, names)
+}
+
+func printReconcileResult(result reconcile.Result) {
+	switch {
+	case result.Action == "" && result.Err != nil:
+		log.Warnf("%s: %s", result.Machine, result.Err)
+	case result.Action == "":
+		log.Debugf("%s is %s", result.Machine, result.State)
+	case result.Err != nil:
+		log.Errorf("%s was %s, %s failed: %s", result.Machine, result.State, result.Action, result.Err)
+	case result.Action != reconcile.AlertOnly:
+		log.Infof("%s was %s, %s succeeded", result.Machine, result.State, result.Action)
+	}
+}
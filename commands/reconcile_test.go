@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/commands/commandstest"
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/libmachinetest"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdReconcileRequiresMachines(t *testing.T) {
+	commandLine := &commandstest.FakeCommandLine{}
+
+	err := cmdReconcile(commandLine, &libmachinetest.FakeAPI{})
+
+	assert.Equal(t, ErrNoMachineSpecified, err)
+}
+
+func TestCmdReconcileInvalidPolicy(t *testing.T) {
+	commandLine := &commandstest.FakeCommandLine{
+		CliArgs: []string{"machine"},
+		LocalFlags: &commandstest.FakeFlagger{
+			Data: map[string]interface{}{"policy": "reboot", "interval": 30},
+		},
+	}
+
+	err := cmdReconcile(commandLine, &libmachinetest.FakeAPI{})
+
+	assert.EqualError(t, err, `invalid policy "reboot", expected restart, recreate or alert-only`)
+}
+
+func TestCmdReconcileOnce(t *testing.T) {
+	commandLine := &commandstest.FakeCommandLine{
+		CliArgs: []string{"machine"},
+		LocalFlags: &commandstest.FakeFlagger{
+			Data: map[string]interface{}{"policy": "alert-only", "interval": 30, "once": true},
+		},
+	}
+	api := &libmachinetest.FakeAPI{
+		Hosts: []*host.Host{
+			{
+				Name:   "machine",
+				Driver: &fakedriver.Driver{MockState: state.Stopped},
+			},
+		},
+	}
+
+	err := cmdReconcile(commandLine, api)
+
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, libmachinetest.State(api, "machine"))
+}
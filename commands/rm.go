@@ -6,6 +6,10 @@ import (
 	"strings"
 
 	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/events"
+	"github.com/rancher/machine/libmachine/hooks"
+	"github.com/rancher/machine/libmachine/host"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnerror"
 )
@@ -27,8 +31,14 @@ func cmdRm(c CommandLine, api libmachine.API) error {
 		return nil
 	}
 
+	unlock, err := lockHosts(api, c.Args())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	for _, hostName := range c.Args() {
-		err := removeRemoteMachine(hostName, api)
+		h, err := removeRemoteMachine(hostName, api)
 		if err != nil {
 			if _, ok := err.(mcnerror.ErrHostDoesNotExist); !ok {
 				errorOccurred = collectError(fmt.Sprintf("Error removing host %q: %s", hostName, err), force, errorOccurred)
@@ -43,6 +53,10 @@ func cmdRm(c CommandLine, api libmachine.API) error {
 				errorOccurred = collectError(fmt.Sprintf("Can't remove \"%s\"", hostName), force, errorOccurred)
 			} else {
 				log.Infof("Successfully removed %s", hostName)
+				if h != nil {
+					eventsOf(api).Publish(events.New(h, events.Removed, nil))
+					hooksOf(api).RunPost(hooks.PostRemove, h)
+				}
 			}
 		}
 	}
@@ -67,18 +81,32 @@ func userConfirm(confirm bool, force bool) bool {
 	return sure
 }
 
-func removeRemoteMachine(hostName string, api libmachine.API) error {
+func removeRemoteMachine(hostName string, api libmachine.API) (*host.Host, error) {
 	currentHost, loaderr := api.Load(hostName)
 	if loaderr != nil {
-		return loaderr
+		return nil, loaderr
+	}
+
+	if err := hooksOf(api).Run(hooks.PreRemove, currentHost); err != nil {
+		return currentHost, err
 	}
 
-	err := currentHost.Driver.Remove()
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// the IP of the machine may be given to another one
+	if err := currentHost.ForgetHostKey(); err != nil {
+		log.Debugf("Error forgetting the host key of %s: %s", hostName, err)
+	}
+
+	eventsOf(api).Publish(events.New(currentHost, events.Removing, nil))
+	err := drivers.Remove(ctx, currentHost.Driver)
 	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "not found") {
-		return err
+		eventsOf(api).Publish(events.New(currentHost, events.Error, err))
+		return currentHost, err
 	}
 
-	return nil
+	return currentHost, nil
 }
 
 func removeLocalMachine(hostName string, api libmachine.API) error {
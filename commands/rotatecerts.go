@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/persist"
+)
+
+// cmdRotateCerts renews the certificates of the machines expiring soon, all
+// of them with --force, restarting their engine without provisioning them
+// again. The client certificate, shared by the machines, is renewed when it
+// expires soon or with --client-certs.
+func cmdRotateCerts(c CommandLine, api libmachine.API) error {
+	if days := c.Int("within"); days > 0 {
+		host.CertExpiryWarning = time.Duration(days) * 24 * time.Hour
+	}
+
+	names := c.Args()
+	if len(names) == 0 {
+		var err error
+		if names, err = api.List(); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockHosts(api, names)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	hosts, hostsInError := persist.LoadHosts(api, names)
+	if len(hostsInError) > 0 {
+		errs := []error{}
+		for _, err := range hostsInError {
+			errs = append(errs, err)
+		}
+		return consolidateErrs(errs)
+	}
+
+	toRotate := []*host.Host{}
+	for _, h := range hosts {
+		if h.AuthOptions() == nil || h.HostOptions.CustomInstallScript != "" {
+			continue
+		}
+		expiring, err := h.CertsExpiring()
+		if err != nil {
+			return fmt.Errorf("%s: error reading the certificates: %s", h.Name, err)
+		}
+		if expiring || c.Bool("force") {
+			toRotate = append(toRotate, h)
+		}
+	}
+
+	if len(toRotate) == 0 {
+		log.Infof("No certificates expire in the next %d days", int(host.CertExpiryWarning.Hours()/24))
+		return nil
+	}
+
+	if err := renewClientCert(toRotate[0], c.Bool("client-certs")); err != nil {
+		return err
+	}
+
+	if errs := runActionForeachMachine("rotateCerts", toRotate, eventsOf(api)); len(errs) > 0 {
+		return consolidateErrs(errs)
+	}
+
+	for _, h := range toRotate {
+		if err := api.Save(h); err != nil {
+			return fmt.Errorf("Error saving host to store: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// renewClientCert renews the client certificate shared by the machines if it
+// expires soon or force is set. The CA is not renewed, as the certificates of
+// all the machines would have to be.
+func renewClientCert(h *host.Host, force bool) error {
+	authOptions := h.AuthOptions()
+	deadline := time.Now().Add(host.CertExpiryWarning)
+
+	if authOptions.CertExpiry.CA.Before(deadline) {
+		log.Warnf("The CA certificate expires on %s, run regenerate-certs --client-certs to renew it", authOptions.CertExpiry.CA.Local().Format("2006-01-02"))
+	}
+
+	if !force && !authOptions.CertExpiry.Client.Before(deadline) {
+		return nil
+	}
+	if err := cert.RenewClientCertificate(authOptions); err != nil {
+		return fmt.Errorf("Error renewing the client certificate: %s", err)
+	}
+	return nil
+}
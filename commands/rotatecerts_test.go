@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/commands/commandstest"
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/libmachinetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdRotateCertsSkipsMachinesWithoutDocker(t *testing.T) {
+	commandLine := &commandstest.FakeCommandLine{
+		CliArgs: []string{"machine"},
+		LocalFlags: &commandstest.FakeFlagger{
+			Data: map[string]interface{}{"force": true},
+		},
+	}
+	api := &libmachinetest.FakeAPI{
+		Hosts: []*host.Host{
+			{
+				Name:        "machine",
+				Driver:      &fakedriver.Driver{},
+				HostOptions: &host.Options{},
+			},
+		},
+	}
+
+	err := cmdRotateCerts(commandLine, api)
+
+	assert.NoError(t, err)
+}
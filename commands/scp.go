@@ -7,8 +7,11 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/persist"
+	"github.com/rancher/machine/libmachine/ssh"
 )
 
 var (
@@ -80,7 +83,15 @@ func getScpCmd(src, dest string, recursive bool, delta bool, quiet bool, hostInf
 
 	// TODO: Check that "-3" flag is available in user's version of scp.
 	// It is on every system I've checked, but the manual mentioned it's "newer"
-	sshArgs := baseSSHArgs
+	// a copy between two machines verifies their keys under their address,
+	// the alias applying to both
+	alias := ""
+	if srcHost != nil && destHost == nil {
+		alias = srcHost.GetMachineName()
+	} else if destHost != nil && srcHost == nil {
+		alias = destHost.GetMachineName()
+	}
+	sshArgs := withHostKeyChecking(baseSSHArgs, alias)
 	if !delta {
 		sshArgs = append(sshArgs, "-3")
 		if recursive {
@@ -109,7 +120,7 @@ func getScpCmd(src, dest string, recursive bool, delta bool, quiet bool, hostInf
 	// TODO: Check that "--progress" flag is available in user's version of rsync.
 	// Use quiet mode as a workaround, if it should happen to not be supported...
 	if delta {
-		sshArgs = append([]string{"-e"}, "ssh "+strings.Join(sshArgs, " "))
+		sshArgs = append([]string{"-e"}, "ssh "+strings.Join(quoteSpaced(sshArgs), " "))
 		if !quiet {
 			sshArgs = append([]string{"--progress"}, sshArgs...)
 		}
@@ -130,6 +141,125 @@ func getScpCmd(src, dest string, recursive bool, delta bool, quiet bool, hostInf
 	return cmd, nil
 }
 
+// useNativeScp tells whether the copies use the native client, when it is
+// the SSH client used or the scp binary, rsync with delta, is missing.
+func useNativeScp(delta bool) bool {
+	if ssh.GetDefaultClient() == ssh.Native {
+		return true
+	}
+	binary := "scp"
+	if delta {
+		binary = "rsync"
+	}
+	_, err := exec.LookPath(binary)
+	return err != nil
+}
+
+// nativeScp copies between a machine and a local path with the native
+// client, showing the progress unless quiet. The interrupted copies resume,
+// and with delta only the changed files are copied.
+func nativeScp(src, dest string, recursive bool, delta bool, quiet bool, hostInfoLoader HostInfoLoader) error {
+	srcHost, srcUser, srcPath, _, err := getInfoForScpArg(src, hostInfoLoader)
+	if err != nil {
+		return err
+	}
+
+	destHost, destUser, destPath, _, err := getInfoForScpArg(dest, hostInfoLoader)
+	if err != nil {
+		return err
+	}
+
+	if (srcHost == nil) == (destHost == nil) {
+		return errors.New("The native copies are between a machine and a local path, copying between two machines requires the scp binary")
+	}
+
+	opts := ssh.CopyOptions{Recursive: recursive, Delta: delta}
+	if !quiet {
+		opts.Progress = os.Stderr
+	}
+
+	if destHost != nil {
+		client, err := nativeScpClient(destHost, destUser)
+		if err != nil {
+			return err
+		}
+		return client.Upload(srcPath, destPath, opts)
+	}
+
+	client, err := nativeScpClient(srcHost, srcUser)
+	if err != nil {
+		return err
+	}
+	return client.Download(srcPath, destPath, opts)
+}
+
+// nativeScpClient returns the native client of the machine of hostInfo,
+// connecting as user if set.
+func nativeScpClient(hostInfo HostInfo, user string) (*ssh.NativeClient, error) {
+	hostname, err := hostInfo.GetSSHHostname()
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := hostInfo.GetSSHPort()
+	if err != nil {
+		return nil, err
+	}
+
+	if user == "" {
+		user = hostInfo.GetSSHUsername()
+	}
+
+	auth := &ssh.Auth{}
+	var bastion *ssh.Bastion
+	if d, ok := hostInfo.(drivers.Driver); ok {
+		auth = drivers.GetSSHAuth(d)
+		bastion = drivers.GetSSHBastion(d)
+	} else if hostInfo.GetSSHKeyPath() != "" {
+		auth.Keys = []string{hostInfo.GetSSHKeyPath()}
+	}
+
+	client, err := ssh.NewNativeClient(user, mcnutils.UnbracketHost(hostname), port, auth)
+	if err != nil {
+		return nil, err
+	}
+	native := client.(*ssh.NativeClient)
+	if bastion != nil {
+		if err := native.SetBastion(bastion); err != nil {
+			return nil, err
+		}
+	}
+	return native, nil
+}
+
+// withHostKeyChecking returns args with the host key options of the ssh
+// package, verifying the host keys under alias if a known hosts file is set.
+func withHostKeyChecking(args []string, alias string) []string {
+	others := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-o" && i+1 < len(args) &&
+			(strings.HasPrefix(args[i+1], "StrictHostKeyChecking=") || strings.HasPrefix(args[i+1], "UserKnownHostsFile=")) {
+			i++
+			continue
+		}
+		others = append(others, args[i])
+	}
+	return append(ssh.HostKeyCheckingArgs(alias), others...)
+}
+
+// quoteSpaced quotes the args containing spaces, e.g. a ProxyCommand, for
+// the command given to rsync -e.
+func quoteSpaced(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.Contains(arg, " ") {
+			arg = "'" + arg + "'"
+		}
+		quoted[i] = arg
+	}
+	return quoted
+}
+
 func missesExplicitSSHKey(hostInfo HostInfo) bool {
 	return hostInfo != nil && hostInfo.GetSSHKeyPath() == ""
 }
@@ -167,6 +297,12 @@ func getInfoForScpArg(hostAndPath string, hostInfoLoader HostInfoLoader) (h Host
 		args = append(args, "-o", fmt.Sprintf("IdentityFile=%q", h.GetSSHKeyPath()))
 	}
 
+	if b, ok := h.(drivers.SSHBastioner); ok {
+		if bastion := b.GetSSHBastion(); bastion != nil {
+			args = append(args, "-o", "ProxyCommand="+bastion.ProxyCommand("ssh"))
+		}
+	}
+
 	return
 }
 
@@ -183,7 +319,7 @@ func generateLocationArg(hostInfo HostInfo, user, path string) (string, error) {
 	if user == "" {
 		user = hostInfo.GetSSHUsername()
 	}
-	location := fmt.Sprintf("%s@%s:%s", user, hostname, path)
+	location := fmt.Sprintf("%s@%s:%s", user, mcnutils.BracketHost(hostname), path)
 	return location, nil
 }
 
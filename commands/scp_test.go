@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -106,6 +107,18 @@ func TestRemoteLocation(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRemoteLocationIPv6(t *testing.T) {
+	hostInfo := MockHostInfo{
+		ip:          "2001:db8::1",
+		sshUsername: "root",
+	}
+
+	arg, err := generateLocationArg(&hostInfo, "", "/home/docker/foo")
+
+	assert.Equal(t, "root@[2001:db8::1]:/home/docker/foo", arg)
+	assert.NoError(t, err)
+}
+
 func TestGetScpCmd(t *testing.T) {
 	hostInfoLoader := MockHostInfoLoader{MockHostInfo{
 		ip:          "12.34.56.78",
@@ -177,3 +190,51 @@ func TestGetScpCmdWithDelta(t *testing.T) {
 	assert.Equal(t, expectedCmd, cmd)
 	assert.NoError(t, err)
 }
+
+// bastionHostInfo is a host reached through an SSH bastion.
+type bastionHostInfo struct {
+	MockHostInfo
+	bastion *ssh.Bastion
+}
+
+func (h *bastionHostInfo) GetSSHBastion() *ssh.Bastion {
+	return h.bastion
+}
+
+func (h *bastionHostInfo) SetSSHBastion(bastion *ssh.Bastion) error {
+	h.bastion = bastion
+	return nil
+}
+
+type bastionHostInfoLoader struct {
+	bastion *ssh.Bastion
+}
+
+func (l *bastionHostInfoLoader) load(name string) (HostInfo, error) {
+	return &bastionHostInfo{MockHostInfo: MockHostInfo{name: name}, bastion: l.bastion}, nil
+}
+
+func TestGetInfoForScpArgWithBastion(t *testing.T) {
+	loader := &bastionHostInfoLoader{&ssh.Bastion{Host: "10.0.0.1", User: "jump"}}
+
+	_, _, _, opts, err := getInfoForScpArg("myfunhost:/home/docker/foo", loader)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-o", `ProxyCommand="ssh" -F /dev/null -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=quiet -p 22 -W %h:%p jump@10.0.0.1`}, opts)
+}
+
+func TestUseNativeScp(t *testing.T) {
+	ssh.SetDefaultClient(ssh.Native)
+	defer ssh.SetDefaultClient(ssh.External)
+
+	assert.True(t, useNativeScp(false))
+}
+
+func TestNativeScpBetweenMachines(t *testing.T) {
+	hostInfoLoader := MockHostInfoLoader{MockHostInfo{
+		ip:          "1.2.3.4",
+		sshUsername: "user",
+	}}
+
+	err := nativeScp("host1:/tmp/foo", "host2:/tmp/foo", false, false, true, &hostInfoLoader)
+	assert.EqualError(t, err, "The native copies are between a machine and a local path, copying between two machines requires the scp binary")
+}
@@ -0,0 +1,233 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// specVariableRe matches the references to environment variables of a spec,
+// $VAR, ${VAR} or ${VAR:-default}, and $$ escaping a dollar.
+var specVariableRe = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Spec declares the machines to create, as an alternative to the create
+// flags. It is read from a YAML or JSON file given with create -f.
+type Spec struct {
+	Driver string `yaml:"driver"`
+	// Name is the name of the machine, or the prefix of the names of the
+	// machines if Count is more than 1
+	Name  string `yaml:"name"`
+	Count int    `yaml:"count"`
+	// Flags holds the values of create flags, lists for the flags given
+	// several times
+	Flags  map[string]interface{} `yaml:"flags"`
+	Engine SpecEngine             `yaml:"engine"`
+	// Labels are the labels of the machines, EngineLabels those of their
+	// engine
+	Labels       []string `yaml:"labels"`
+	EngineLabels []string `yaml:"engineLabels"`
+}
+
+// SpecEngine holds the options of the engine of a spec.
+type SpecEngine struct {
+	InstallURL         string   `yaml:"install-url"`
+	StorageDriver      string   `yaml:"storage-driver"`
+	Opts               []string `yaml:"opts"`
+	Env                []string `yaml:"env"`
+	InsecureRegistries []string `yaml:"insecure-registries"`
+	RegistryMirrors    []string `yaml:"registry-mirrors"`
+}
+
+// specInterpolator replaces the references to environment variables in the
+// strings of a spec, recording those unset which have no default.
+type specInterpolator struct {
+	missing map[string]bool
+}
+
+func (i *specInterpolator) interpolate(s string) string {
+	return specVariableRe.ReplaceAllStringFunc(s, func(ref string) string {
+		match := specVariableRe.FindStringSubmatch(ref)
+		if match[0] == "$$" {
+			return "$"
+		}
+
+		name := match[1] + match[4]
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		i.missing[name] = true
+		return ""
+	})
+}
+
+func (i *specInterpolator) interpolateSlice(values []string) {
+	for j := range values {
+		values[j] = i.interpolate(values[j])
+	}
+}
+
+// interpolateFlag interpolates the strings of the value of a flag, a scalar
+// or a list.
+func (i *specInterpolator) interpolateFlag(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return i.interpolate(v)
+	case []interface{}:
+		for j, item := range v {
+			if s, ok := item.(string); ok {
+				v[j] = i.interpolate(s)
+			}
+		}
+	}
+	return value
+}
+
+// interpolate replaces the references to environment variables in the
+// strings of the spec, once decoded for their values not to change its
+// structure, failing on those unset which have no default.
+func (s *Spec) interpolate() error {
+	i := &specInterpolator{missing: map[string]bool{}}
+	s.Driver = i.interpolate(s.Driver)
+	s.Name = i.interpolate(s.Name)
+	for name, value := range s.Flags {
+		s.Flags[name] = i.interpolateFlag(value)
+	}
+	s.Engine.InstallURL = i.interpolate(s.Engine.InstallURL)
+	s.Engine.StorageDriver = i.interpolate(s.Engine.StorageDriver)
+	i.interpolateSlice(s.Engine.Opts)
+	i.interpolateSlice(s.Engine.Env)
+	i.interpolateSlice(s.Engine.InsecureRegistries)
+	i.interpolateSlice(s.Engine.RegistryMirrors)
+	i.interpolateSlice(s.Labels)
+	i.interpolateSlice(s.EngineLabels)
+
+	if len(i.missing) > 0 {
+		names := []string{}
+		for name := range i.missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("environment variables not set: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func loadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %s", path, err)
+	}
+	if err := spec.interpolate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %s", path, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %s", path, err)
+	}
+	return spec, nil
+}
+
+func (s *Spec) validate() error {
+	if s.Driver == "" {
+		return fmt.Errorf("driver missing")
+	}
+	if s.Count < 0 {
+		return fmt.Errorf("invalid count %d", s.Count)
+	}
+	if s.Count > 0 && s.Name == "" {
+		return fmt.Errorf("count given without a name")
+	}
+	for name, value := range s.Flags {
+		if profileFlagsExcluded[name] {
+			return fmt.Errorf("flag %s cannot be set in a spec", name)
+		}
+		if _, err := specFlagValues(value); err != nil {
+			return fmt.Errorf("invalid value of flag %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// specFlagValues returns the value of a flag of a spec as given on the
+// command line.
+func specFlagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("no value")
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			switch item.(type) {
+			case nil, []interface{}, map[interface{}]interface{}:
+				return nil, fmt.Errorf("expected a list of scalars")
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[interface{}]interface{}:
+		return nil, fmt.Errorf("expected a scalar or a list")
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
+
+// profile returns the create flags of a spec as a profile.
+func (s *Spec) profile() *Profile {
+	profile := &Profile{
+		Driver: s.Driver,
+		Flags:  map[string][]string{},
+	}
+	for name, value := range s.Flags {
+		profile.Flags[name], _ = specFlagValues(value)
+	}
+
+	setString := func(name, value string) {
+		if value != "" {
+			profile.Flags[name] = []string{value}
+		}
+	}
+	setString("engine-install-url", s.Engine.InstallURL)
+	setString("engine-storage-driver", s.Engine.StorageDriver)
+
+	appendSlice := func(name string, values []string) {
+		if len(values) > 0 {
+			profile.Flags[name] = append(profile.Flags[name], values...)
+		}
+	}
+	appendSlice("engine-opt", s.Engine.Opts)
+	appendSlice("engine-env", s.Engine.Env)
+	appendSlice("engine-insecure-registry", s.Engine.InsecureRegistries)
+	appendSlice("engine-registry-mirror", s.Engine.RegistryMirrors)
+	appendSlice("engine-label", s.EngineLabels)
+	appendSlice("label", s.Labels)
+
+	return profile
+}
+
+// names returns the names of the machines of a spec: Name, or Name-1 to
+// Name-Count if Count is more than 1.
+func (s *Spec) names() []string {
+	if s.Name == "" {
+		return nil
+	}
+	if s.Count <= 1 {
+		return []string{s.Name}
+	}
+
+	names := []string{}
+	for i := 1; i <= s.Count; i++ {
+		names = append(names, fmt.Sprintf("%s-%d", s.Name, i))
+	}
+	return names
+}
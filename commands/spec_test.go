@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSpec(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "machine-spec-")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "spec.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestInterpolateSpec(t *testing.T) {
+	os.Setenv("MACHINE_SPEC_REGION", "eu-west-1")
+	defer os.Unsetenv("MACHINE_SPEC_REGION")
+	// the values cannot change the structure of the spec
+	os.Setenv("MACHINE_SPEC_TAGS", "team:web # {x}\ncount: 9")
+	defer os.Unsetenv("MACHINE_SPEC_TAGS")
+
+	path := writeSpec(t, `
+# ${MACHINE_SPEC_UNSET} in a comment
+driver: amazonec2
+name: node-$MACHINE_SPEC_REGION
+flags:
+  amazonec2-region: $MACHINE_SPEC_REGION
+  amazonec2-zone: ${MACHINE_SPEC_ZONE:-a}
+  amazonec2-spot-price: $$5
+  amazonec2-tags: [$MACHINE_SPEC_TAGS, 3]
+  amazonec2-root-size: 64
+labels:
+  - region=${MACHINE_SPEC_REGION}
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	spec, err := loadSpec(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"node-eu-west-1"}, spec.names())
+	assert.Equal(t, map[string][]string{
+		"amazonec2-region":     {"eu-west-1"},
+		"amazonec2-zone":       {"a"},
+		"amazonec2-spot-price": {"$5"},
+		"amazonec2-tags":       {"team:web # {x}\ncount: 9", "3"},
+		"amazonec2-root-size":  {"64"},
+		"label":                {"region=eu-west-1"},
+	}, spec.profile().Flags)
+
+	unset := writeSpec(t, "driver: none\nname: ${MACHINE_SPEC_ZONE}\nflags: {url: $MACHINE_SPEC_SIZE}")
+	defer os.RemoveAll(filepath.Dir(unset))
+	_, err = loadSpec(unset)
+	assert.EqualError(t, err, "invalid spec "+unset+": environment variables not set: MACHINE_SPEC_SIZE, MACHINE_SPEC_ZONE")
+}
+
+func TestLoadSpec(t *testing.T) {
+	path := writeSpec(t, `
+driver: amazonec2
+name: worker
+count: 3
+flags:
+  amazonec2-region: eu-west-1
+  amazonec2-root-size: 64
+  amazonec2-security-group: [web, ssh]
+engine:
+  storage-driver: overlay2
+  opts: [log-level=debug]
+labels: [role=worker]
+engineLabels: [storage=ssd]
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	spec, err := loadSpec(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker-1", "worker-2", "worker-3"}, spec.names())
+	assert.Equal(t, &Profile{
+		Driver: "amazonec2",
+		Flags: map[string][]string{
+			"amazonec2-region":         {"eu-west-1"},
+			"amazonec2-root-size":      {"64"},
+			"amazonec2-security-group": {"web", "ssh"},
+			"engine-storage-driver":    {"overlay2"},
+			"engine-opt":               {"log-level=debug"},
+			"engine-label":             {"storage=ssd"},
+			"label":                    {"role=worker"},
+		},
+	}, spec.profile())
+}
+
+func TestLoadSpecJSON(t *testing.T) {
+	path := writeSpec(t, `{"driver": "none", "name": "node", "flags": {"url": "tcp://1.2.3.4:2376"}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	spec, err := loadSpec(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"node"}, spec.names())
+	assert.Equal(t, map[string][]string{"url": {"tcp://1.2.3.4:2376"}}, spec.profile().Flags)
+}
+
+func TestLoadSpecInvalid(t *testing.T) {
+	var tests = []struct {
+		spec     string
+		expected string
+	}{
+		{`name: node`, "driver missing"},
+		{"driver: none\ncount: 2", "count given without a name"},
+		{"driver: none\nsize: 2", "field size not found"},
+		{"driver: none\nflags: {driver: amazonec2}", "flag driver cannot be set in a spec"},
+		{"driver: none\nflags: {url: {host: 1.2.3.4}}", "invalid value of flag url: expected a scalar or a list"},
+	}
+
+	for _, test := range tests {
+		path := writeSpec(t, test.spec)
+		_, err := loadSpec(path)
+		os.RemoveAll(filepath.Dir(path))
+
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), test.expected)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 )
 
@@ -23,7 +27,41 @@ func cmdSSH(c CommandLine, api libmachine.API) error {
 		return nil
 	}
 
-	target, err := targetHost(c, api)
+	// --reset-hostkey forgets the host key of a rebuilt machine, -A forwards
+	// the ssh-agent, -L, -R and -D forward ports, -N without a command
+	args := c.Args()
+	resetHostKey, forwardAgent, forwardOnly := false, false, false
+	var forwards []ssh.Forward
+	for len(args) > 0 {
+		if args[0] == "--reset-hostkey" {
+			resetHostKey = true
+		} else if args[0] == "-A" || args[0] == "--forward-agent" {
+			forwardAgent = true
+		} else if args[0] == "-N" {
+			forwardOnly = true
+		} else if forwardType, ok := forwardFlag(args[0]); ok {
+			spec := strings.TrimPrefix(args[0], "-"+string(forwardType))
+			if spec == "" {
+				if len(args) < 2 {
+					return fmt.Errorf("-%s requires an argument", forwardType)
+				}
+				spec, args = args[1], args[1:]
+			}
+			forward, err := ssh.ParseForward(forwardType, spec)
+			if err != nil {
+				return err
+			}
+			forwards = append(forwards, forward)
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+	if forwardOnly && len(forwards) == 0 {
+		return errors.New("-N requires a port forwarding with -L, -R or -D")
+	}
+
+	target, err := targetHostOf(args, api)
 	if err != nil {
 		return err
 	}
@@ -42,10 +80,64 @@ func cmdSSH(c CommandLine, api libmachine.API) error {
 		return errStateInvalidForSSH{host.Name}
 	}
 
+	if resetHostKey {
+		if err := host.ForgetHostKey(); err != nil {
+			return fmt.Errorf("Error forgetting the host key of %s: %s", host.Name, err)
+		}
+		log.Infof("Forgot the SSH host key of %s, the next connection records it", host.Name)
+	}
+
+	// the password of the machine, or the answers of its 2FA, are prompted
+	// for when no key is accepted
+	ssh.SetPasswordPrompt(true)
+	defer ssh.SetPasswordPrompt(false)
+
 	client, err := host.CreateSSHClient()
 	if err != nil {
 		return err
 	}
 
-	return client.Shell(c.Args().Tail()...)
+	if forwardAgent {
+		forwarder, ok := client.(ssh.AgentForwarder)
+		if !ok {
+			return errors.New("the SSH client cannot forward the ssh-agent")
+		}
+		if err := forwarder.ForwardAgent(); err != nil {
+			return err
+		}
+	}
+
+	if len(forwards) > 0 {
+		forwarder, ok := client.(ssh.PortForwarder)
+		if !ok {
+			return errors.New("the SSH client cannot forward ports")
+		}
+		for _, forward := range forwards {
+			if err := forwarder.Forward(forward); err != nil {
+				return err
+			}
+		}
+		if forwardOnly {
+			if err := forwarder.ForwardOnly(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var command []string
+	if len(args) > 0 {
+		command = args[1:]
+	}
+	return client.Shell(command...)
+}
+
+// forwardFlag returns the forwarding type of the ssh flag arg, -L, -R or -D
+// with its argument attached or not.
+func forwardFlag(arg string) (ssh.ForwardType, bool) {
+	for _, t := range []ssh.ForwardType{ssh.LocalForward, ssh.RemoteForward, ssh.DynamicForward} {
+		if strings.HasPrefix(arg, "-"+string(t)) {
+			return t, true
+		}
+	}
+	return "", false
 }
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/rancher/machine/commands/commandstest"
@@ -28,12 +29,15 @@ func (fsc *FakeSSHClientCreator) CreateSSHClient(d drivers.Driver) (ssh.Client,
 
 func TestCmdSSH(t *testing.T) {
 	testCases := []struct {
-		commandLine   CommandLine
-		api           libmachine.API
-		expectedErr   error
-		helpShown     bool
-		clientCreator host.SSHClientCreator
-		expectedShell []string
+		commandLine    CommandLine
+		api            libmachine.API
+		expectedErr    error
+		helpShown      bool
+		clientCreator  host.SSHClientCreator
+		expectedShell  []string
+		agentForwarded bool
+		forwards       []ssh.Forward
+		forwardedOnly  bool
 	}{
 		{
 			commandLine: &commandstest.FakeCommandLine{
@@ -92,6 +96,55 @@ func TestCmdSSH(t *testing.T) {
 			},
 			expectedErr: errStateInvalidForSSH{"default"},
 		},
+		{
+			commandLine: &commandstest.FakeCommandLine{
+				CliArgs: []string{"--reset-hostkey", "-A", "default", "uptime"},
+			},
+			api: &libmachinetest.FakeAPI{
+				Hosts: []*host.Host{
+					{
+						Name: "default",
+						Driver: &fakedriver.Driver{
+							MockState: state.Running,
+						},
+					},
+				},
+			},
+			expectedErr:    nil,
+			clientCreator:  &FakeSSHClientCreator{},
+			expectedShell:  []string{"uptime"},
+			agentForwarded: true,
+		},
+		{
+			commandLine: &commandstest.FakeCommandLine{
+				CliArgs: []string{"-L", "8080:10.0.0.3:80", "-D1080", "-N", "default"},
+			},
+			api: &libmachinetest.FakeAPI{
+				Hosts: []*host.Host{
+					{
+						Name: "default",
+						Driver: &fakedriver.Driver{
+							MockState: state.Running,
+						},
+					},
+				},
+			},
+			expectedErr:   nil,
+			clientCreator: &FakeSSHClientCreator{},
+			expectedShell: []string{},
+			forwards: []ssh.Forward{
+				{Type: ssh.LocalForward, BindAddress: "localhost", BindPort: 8080, Host: "10.0.0.3", HostPort: 80},
+				{Type: ssh.DynamicForward, BindAddress: "localhost", BindPort: 1080},
+			},
+			forwardedOnly: true,
+		},
+		{
+			commandLine: &commandstest.FakeCommandLine{
+				CliArgs: []string{"-N", "default"},
+			},
+			api:         &libmachinetest.FakeAPI{},
+			expectedErr: errors.New("-N requires a port forwarding with -L, -R or -D"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -106,6 +159,9 @@ func TestCmdSSH(t *testing.T) {
 
 		if fcc, ok := tc.clientCreator.(*FakeSSHClientCreator); ok {
 			assert.Equal(t, tc.expectedShell, fcc.client.(*sshtest.FakeClient).ActivatedShell)
+			assert.Equal(t, tc.agentForwarded, fcc.client.(*sshtest.FakeClient).AgentForwarded)
+			assert.Equal(t, tc.forwards, fcc.client.(*sshtest.FakeClient).Forwards)
+			assert.Equal(t, tc.forwardedOnly, fcc.client.(*sshtest.FakeClient).ForwardedOnly)
 		}
 	}
 }
@@ -25,6 +25,7 @@ func cmdURL(c CommandLine, api libmachine.API) error {
 	if err != nil {
 		return err
 	}
+	saveIPCache(api, host)
 
 	fmt.Println(url)
 
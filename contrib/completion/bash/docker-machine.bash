@@ -33,14 +33,23 @@ _docker_machine_machines() {
 
 _docker_machine_drivers() {
     local drivers=(
+        aliyunecs
         amazonec2
         azure
+        container
         digitalocean
         exoscale
         generic
         google
         hyperv
+        ibmcloud
+        multipass
+        nutanix
+        oci
+        opennebula
         openstack
+        outscale
+        qemu
         rackspace
         softlayer
         virtualbox
@@ -148,6 +157,10 @@ _docker_machine_create() {
             COMPREPLY=($(compgen -W "$(_docker_machine_drivers)" -- "${cur}"))
             return
             ;;
+        --file|-f)
+            _filedir
+            return
+            ;;
     esac
 
     # driver specific options are only included in help output if --driver is given,
@@ -155,7 +168,7 @@ _docker_machine_create() {
     local driver="$(_docker_machine_value_of_option '--driver|-d')"
     local parsed_options="$(_docker_machine_q create ${driver:+--driver $driver} --help | grep '^   -' | sed 's/^   //; s/[^a-z0-9-].*$//')"
     if [[ ${cur} == -* ]]; then
-        COMPREPLY=($(compgen -W "${parsed_options} -d --help" -- "${cur}"))
+        COMPREPLY=($(compgen -W "${parsed_options} -d -f --help" -- "${cur}"))
     fi
 }
 
@@ -174,6 +187,22 @@ _docker_machine_env() {
     fi
 }
 
+_docker_machine_encrypt_store() {
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
+    fi
+}
+
+_docker_machine_events() {
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--follow -f --json --help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
+    fi
+}
+
 # See docker-machine-wrapper.bash for the use command
 _docker_machine_use() {
     if [[ "${cur}" == -* ]]; then
@@ -183,6 +212,20 @@ _docker_machine_use() {
     fi
 }
 
+_docker_machine_healthcheck() {
+    case "${prev}" in
+        --disk-threshold)
+            return
+            ;;
+    esac
+
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--json --disk-threshold --help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
+    fi
+}
+
 _docker_machine_inspect() {
     case "${prev}" in
         --format|-f)
@@ -199,7 +242,7 @@ _docker_machine_inspect() {
 
 _docker_machine_ip() {
     if [[ "${cur}" == -* ]]; then
-        COMPREPLY=($(compgen -W "--help" -- "${cur}"))
+        COMPREPLY=($(compgen -W "--refresh --help" -- "${cur}"))
     else
         COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
     fi
@@ -242,6 +285,14 @@ _docker_machine_ls() {
     fi
 }
 
+_docker_machine_migrate() {
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--dry-run --help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
+    fi
+}
+
 _docker_machine_mount() {
     if [[ "${cur}" == -* ]]; then
         COMPREPLY=($(compgen -W "--help --unmount -u" -- "${cur}"))
@@ -265,6 +316,24 @@ _docker_machine_provision() {
     fi
 }
 
+_docker_machine_reconcile() {
+    case "${prev}" in
+        --policy)
+            COMPREPLY=($(compgen -W "restart recreate alert-only" -- "${cur}"))
+            return
+            ;;
+        --interval)
+            return
+            ;;
+    esac
+
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--policy --interval --once --help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
+    fi
+}
+
 _docker_machine_regenerate_certs() {
     if [[ "${cur}" == -* ]]; then
         COMPREPLY=($(compgen -W "--client-certs --force -f --help" -- "${cur}"))
@@ -289,9 +358,29 @@ _docker_machine_rm() {
     fi
 }
 
+_docker_machine_rotate_certs() {
+    case "${prev}" in
+        --within)
+            return
+            ;;
+    esac
+
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "--within --force -f --client-certs --help" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "$(_docker_machine_machines --filter state=Running)" -- "${cur}"))
+    fi
+}
+
 _docker_machine_ssh() {
+    case "${prev}" in
+        -L|-R|-D)
+            return
+            ;;
+    esac
+
     if [[ "${cur}" == -* ]]; then
-        COMPREPLY=($(compgen -W "--help" -- "${cur}"))
+        COMPREPLY=($(compgen -W "--forward-agent -A -D --help -L -N -R --reset-hostkey" -- "${cur}"))
     else
         COMPREPLY=($(compgen -W "$(_docker_machine_machines)" -- "${cur}"))
     fi
@@ -369,8 +458,10 @@ _docker_machine_docker_machine() {
         _filedir
     elif [[ " ${wants_dir[*]} " =~ " ${prev} " ]]; then
         _filedir -d
+    elif [[ "${prev}" == --log-format ]]; then
+        COMPREPLY=($(compgen -W "text json" -- "${cur}"))
     elif [[ "${cur}" == -* ]]; then
-        COMPREPLY=($(compgen -W "${flags[*]} ${wants_dir[*]} ${wants_file[*]}" -- "${cur}"))
+        COMPREPLY=($(compgen -W "${flags[*]} --log-format ${wants_dir[*]} ${wants_file[*]}" -- "${cur}"))
     else
         COMPREPLY=($(compgen -W "${commands[*]}" -- "${cur}"))
     fi
@@ -378,9 +469,9 @@ _docker_machine_docker_machine() {
 
 _docker_machine() {
     COMPREPLY=()
-    local commands=(active config create env inspect ip kill ls mount provision regenerate-certs restart rm ssh scp start status stop upgrade url version help)
+    local commands=(active config create encrypt-store env events healthcheck inspect ip kill ls migrate mount provision reconcile regenerate-certs restart rm rotate-certs ssh scp start status stop upgrade url version help)
 
-    local flags=(--debug --native-ssh --github-api-token --bugsnag-api-token --help --version)
+    local flags=(--debug --native-ssh --show-secrets --ip-cache-ttl --tls-ca-signer --github-api-token --bugsnag-api-token --help --version)
     local wants_dir=(--storage-path)
     local wants_file=(--tls-ca-cert --tls-ca-key --tls-client-cert --tls-client-key)
 
@@ -396,7 +487,7 @@ _docker_machine() {
 
     for (( i=1; i < ${cword}; ++i)); do
         local word=${words[i]}
-        if [[ " ${wants_file[*]} ${wants_dir[*]} " =~ " ${word} " ]]; then
+        if [[ " ${wants_file[*]} ${wants_dir[*]} --log-format --ip-cache-ttl --tls-ca-signer " =~ " ${word} " ]]; then
             # skip the next option
             (( ++i ))
         elif [[ " ${commands[*]} " =~ " ${word} " ]]; then
@@ -0,0 +1,560 @@
+package alibabacloud
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	AccessKeyID        string `secret:"false"`
+	AccessKeySecret    string `secret:"true"`
+	RAMRole            string
+	Region             string
+	Zone               string
+	InstanceType       string
+	ImageID            string
+	ImageFamily        string
+	VpcID              string
+	VSwitchID          string
+	SecurityGroups     []string
+	SystemDiskCategory string
+	SystemDiskSize     int
+	InternetBandwidth  int
+	PrivateIPOnly      bool
+	UserDataFile       string
+	InstanceID         string
+	KeyPairName        string
+	EIPAllocationID    string
+}
+
+const (
+	defaultRegion             = "cn-hangzhou"
+	defaultInstanceType       = "ecs.g6.large"
+	defaultImageFamily        = "acs:ubuntu_22_04_x64"
+	defaultSecurityGroup      = "docker-machine"
+	defaultSystemDiskCategory = "cloud_essd"
+	defaultInternetBandwidth  = 5
+	defaultSSHUser            = "root"
+	defaultSSHPort            = 22
+	dockerPort                = 2376
+	minSystemDiskSize         = 20
+	instanceWaitAttempts      = 120
+	instanceWaitInterval      = 5 * time.Second
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "ECS_ACCESS_KEY_ID",
+			Name:   "aliyunecs-access-key-id",
+			Usage:  "AccessKey ID",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_ACCESS_KEY_SECRET",
+			Name:   "aliyunecs-access-key-secret",
+			Usage:  "AccessKey secret",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_RAM_ROLE",
+			Name:   "aliyunecs-ram-role",
+			Usage:  "Authenticate with the RAM role attached to the ECS instance running the driver, instead of an AccessKey",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_REGION",
+			Name:   "aliyunecs-region",
+			Usage:  "Region",
+			Value:  defaultRegion,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_ZONE",
+			Name:   "aliyunecs-zone",
+			Usage:  "Zone, used with --aliyunecs-vpc-id to pick a vSwitch (default is the zone of the vSwitch)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_INSTANCE_TYPE",
+			Name:   "aliyunecs-instance-type",
+			Usage:  "Instance type",
+			Value:  defaultInstanceType,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_IMAGE_ID",
+			Name:   "aliyunecs-image-id",
+			Usage:  "Image ID (default is the latest image of --aliyunecs-image-family)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_IMAGE_FAMILY",
+			Name:   "aliyunecs-image-family",
+			Usage:  "Image family to take the latest image from",
+			Value:  defaultImageFamily,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_VPC_ID",
+			Name:   "aliyunecs-vpc-id",
+			Usage:  "VPC ID",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_VSWITCH_ID",
+			Name:   "aliyunecs-vswitch-id",
+			Usage:  "vSwitch ID, which also selects the VPC and zone",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "ECS_SECURITY_GROUP",
+			Name:   "aliyunecs-security-group",
+			Usage:  "Name or ID of a security group of the instance, a missing group given by name is created",
+			Value:  []string{defaultSecurityGroup},
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_SYSTEM_DISK_CATEGORY",
+			Name:   "aliyunecs-system-disk-category",
+			Usage:  "System disk category",
+			Value:  defaultSystemDiskCategory,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ECS_SYSTEM_DISK_SIZE",
+			Name:   "aliyunecs-system-disk-size",
+			Usage:  "System disk size in GB (default is the size of the image)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ECS_INTERNET_MAX_BANDWIDTH",
+			Name:   "aliyunecs-internet-max-bandwidth",
+			Usage:  "Bandwidth of the EIP in Mbps",
+			Value:  defaultInternetBandwidth,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "ECS_PRIVATE_ADDR_ONLY",
+			Name:   "aliyunecs-private-address-only",
+			Usage:  "Don't bind an EIP and connect to the private IP",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_USERDATA",
+			Name:   "aliyunecs-userdata",
+			Usage:  "Path to file with cloud-init user-data",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ECS_SSH_USER",
+			Name:   "aliyunecs-ssh-user",
+			Usage:  "SSH user",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ECS_SSH_PORT",
+			Name:   "aliyunecs-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Region:             defaultRegion,
+		InstanceType:       defaultInstanceType,
+		ImageFamily:        defaultImageFamily,
+		SecurityGroups:     []string{defaultSecurityGroup},
+		SystemDiskCategory: defaultSystemDiskCategory,
+		InternetBandwidth:  defaultInternetBandwidth,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "aliyunecs"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["aliyunecs-access-key-id"]; ok {
+		d.AccessKeyID = driverOpts.String("aliyunecs-access-key-id")
+	}
+	if _, ok := driverOpts.Values["aliyunecs-access-key-secret"]; ok {
+		d.AccessKeySecret = driverOpts.String("aliyunecs-access-key-secret")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.AccessKeyID = flags.String("aliyunecs-access-key-id")
+	d.AccessKeySecret = flags.String("aliyunecs-access-key-secret")
+	d.RAMRole = flags.String("aliyunecs-ram-role")
+	d.Region = flags.String("aliyunecs-region")
+	d.Zone = flags.String("aliyunecs-zone")
+	d.InstanceType = flags.String("aliyunecs-instance-type")
+	d.ImageID = flags.String("aliyunecs-image-id")
+	d.ImageFamily = flags.String("aliyunecs-image-family")
+	d.VpcID = flags.String("aliyunecs-vpc-id")
+	d.VSwitchID = flags.String("aliyunecs-vswitch-id")
+	d.SecurityGroups = flags.StringSlice("aliyunecs-security-group")
+	d.SystemDiskCategory = flags.String("aliyunecs-system-disk-category")
+	d.SystemDiskSize = flags.Int("aliyunecs-system-disk-size")
+	d.InternetBandwidth = flags.Int("aliyunecs-internet-max-bandwidth")
+	d.PrivateIPOnly = flags.Bool("aliyunecs-private-address-only")
+	d.UserDataFile = flags.String("aliyunecs-userdata")
+	d.SSHUser = flags.String("aliyunecs-ssh-user")
+	d.SSHPort = flags.Int("aliyunecs-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.RAMRole != "" {
+		if d.AccessKeyID != "" || d.AccessKeySecret != "" {
+			return errors.New("--aliyunecs-ram-role cannot be combined with an AccessKey")
+		}
+	} else if d.AccessKeyID == "" || d.AccessKeySecret == "" {
+		return errors.New("aliyunecs driver requires --aliyunecs-access-key-id and --aliyunecs-access-key-secret, or --aliyunecs-ram-role")
+	}
+	if d.VSwitchID == "" && (d.VpcID == "" || d.Zone == "") {
+		return errors.New("aliyunecs driver requires either --aliyunecs-vswitch-id or both --aliyunecs-vpc-id and --aliyunecs-zone")
+	}
+	if d.ImageID == "" && d.ImageFamily == "" {
+		return errors.New("aliyunecs driver requires --aliyunecs-image-id or --aliyunecs-image-family")
+	}
+	if d.SystemDiskSize != 0 && d.SystemDiskSize < minSystemDiskSize {
+		return fmt.Errorf("--aliyunecs-system-disk-size must be at least %d GB", minSystemDiskSize)
+	}
+	if !d.PrivateIPOnly && d.InternetBandwidth <= 0 {
+		return errors.New("--aliyunecs-internet-max-bandwidth must be positive")
+	}
+	return nil
+}
+
+func (d *Driver) client() *Client {
+	c := NewClient(d.Region, &Credentials{
+		AccessKeyID:     d.AccessKeyID,
+		AccessKeySecret: d.AccessKeySecret,
+		RAMRole:         d.RAMRole,
+	})
+	c.DockerPort = d.GetEnginePort()
+	return c
+}
+
+// vswitch resolves the vSwitch and checks that it matches the VPC and zone
+// when they are given too.
+func (d *Driver) vswitch(c *Client) (*VSwitch, error) {
+	vsw, err := c.VSwitch(d.VSwitchID, d.VpcID, d.Zone)
+	if err != nil {
+		return nil, err
+	}
+	if d.VpcID != "" && vsw.VpcID != d.VpcID {
+		return nil, fmt.Errorf("vSwitch %s is not in VPC %s", vsw.VSwitchID, d.VpcID)
+	}
+	if d.Zone != "" && vsw.ZoneID != d.Zone {
+		return nil, fmt.Errorf("vSwitch %s is not in zone %s", vsw.VSwitchID, d.Zone)
+	}
+	return vsw, nil
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("user-data file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	c := d.client()
+	vsw, err := d.vswitch(c)
+	if err != nil {
+		return err
+	}
+	for _, sg := range d.SecurityGroups {
+		if strings.HasPrefix(sg, "sg-") {
+			if _, err := c.SecurityGroupID(vsw.VpcID, sg, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	c := d.client()
+	vsw, err := d.vswitch(c)
+	if err != nil {
+		return err
+	}
+
+	var securityGroupIDs []string
+	for _, sg := range d.SecurityGroups {
+		id, err := c.SecurityGroupID(vsw.VpcID, sg, true)
+		if err != nil {
+			return fmt.Errorf("error getting security group %s: %s", sg, err)
+		}
+		securityGroupIDs = append(securityGroupIDs, id)
+	}
+
+	var userData []byte
+	if d.UserDataFile != "" {
+		if userData, err = ioutil.ReadFile(d.UserDataFile); err != nil {
+			return err
+		}
+	}
+
+	d.KeyPairName = d.MachineName
+	if err := c.ImportKeyPair(d.KeyPairName, strings.TrimSpace(string(publicKey))); err != nil {
+		return fmt.Errorf("error importing key pair: %s", err)
+	}
+
+	log.Infof("Creating instance %s (%s) in %s...", d.MachineName, d.InstanceType, vsw.ZoneID)
+	d.InstanceID, err = c.RunInstance(d.runInstancesParams(vsw, securityGroupIDs, userData))
+	if err != nil {
+		return fmt.Errorf("error creating instance: %s", err)
+	}
+
+	log.Infof("Waiting for instance %s to be running...", d.InstanceID)
+	if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		instance, err := c.GetInstance(d.InstanceID)
+		if err != nil {
+			return false, err
+		}
+		return instance.Status == "Running", nil
+	}, instanceWaitAttempts, instanceWaitInterval); err != nil {
+		return err
+	}
+
+	if !d.PrivateIPOnly {
+		log.Infof("Allocating an EIP...")
+		eip, err := c.AllocateEIP(d.MachineName, d.InternetBandwidth)
+		if err != nil {
+			return fmt.Errorf("error allocating EIP: %s", err)
+		}
+		d.EIPAllocationID = eip.AllocationID
+
+		log.Infof("Binding EIP %s to %s...", eip.IPAddress, d.InstanceID)
+		if err := c.AssociateEIP(d.EIPAllocationID, d.InstanceID); err != nil {
+			return fmt.Errorf("error binding EIP: %s", err)
+		}
+		if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+			eip, err := c.GetEIP(d.EIPAllocationID)
+			if err != nil {
+				return false, err
+			}
+			return eip.Status == "InUse", nil
+		}, instanceWaitAttempts, instanceWaitInterval); err != nil {
+			return err
+		}
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+func (d *Driver) runInstancesParams(vsw *VSwitch, securityGroupIDs []string, userData []byte) url.Values {
+	params := url.Values{
+		"ZoneId":              {vsw.ZoneID},
+		"VSwitchId":           {vsw.VSwitchID},
+		"InstanceType":        {d.InstanceType},
+		"InstanceName":        {d.MachineName},
+		"HostName":            {d.MachineName},
+		"KeyPairName":         {d.KeyPairName},
+		"SystemDisk.Category": {d.SystemDiskCategory},
+	}
+	if d.ImageID != "" {
+		params.Set("ImageId", d.ImageID)
+	} else {
+		params.Set("ImageFamily", d.ImageFamily)
+	}
+	for i, id := range securityGroupIDs {
+		params.Set("SecurityGroupIds."+strconv.Itoa(i+1), id)
+	}
+	if d.SystemDiskSize > 0 {
+		params.Set("SystemDisk.Size", strconv.Itoa(d.SystemDiskSize))
+	}
+	if len(userData) > 0 {
+		params.Set("UserData", base64.StdEncoding.EncodeToString(userData))
+	}
+	return params
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the EIP, or the private IP with --aliyunecs-private-address-only
+func (d *Driver) GetIP() (string, error) {
+	if d.InstanceID == "" {
+		return "", errors.New("instance has not been created")
+	}
+
+	instance, err := d.client().GetInstance(d.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	return instanceIP(instance, d.PrivateIPOnly)
+}
+
+func instanceIP(instance *Instance, private bool) (string, error) {
+	if !private {
+		if instance.EipAddress.IPAddress == "" {
+			return "", errors.New("instance has no EIP bound")
+		}
+		return instance.EipAddress.IPAddress, nil
+	}
+
+	addresses := instance.VpcAttributes.PrivateIPAddress.IPAddress
+	if len(addresses) == 0 {
+		return "", errors.New("instance has no private IP")
+	}
+	return addresses[0], nil
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	instance, err := d.client().GetInstance(d.InstanceID)
+	if err != nil {
+		return state.Error, err
+	}
+	return instanceState(instance.Status), nil
+}
+
+func instanceState(status string) state.State {
+	switch status {
+	case "Pending", "Starting":
+		return state.Starting
+	case "Running":
+		return state.Running
+	case "Stopping":
+		return state.Stopping
+	case "Stopped":
+		return state.Stopped
+	}
+	return state.None
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Starting %s...", d.MachineName)
+	return d.client().StartInstance(d.InstanceID)
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Stopping %s...", d.MachineName)
+	return d.client().StopInstance(d.InstanceID, false)
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Killing %s...", d.MachineName)
+	return d.client().StopInstance(d.InstanceID, true)
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	log.Infof("Restarting %s...", d.MachineName)
+	return d.client().RebootInstance(d.InstanceID)
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	c := d.client()
+
+	if d.EIPAllocationID != "" {
+		log.Infof("Unbinding EIP %s...", d.EIPAllocationID)
+		if err := c.UnassociateEIP(d.EIPAllocationID, d.InstanceID); err != nil {
+			return err
+		}
+		if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+			eip, err := c.GetEIP(d.EIPAllocationID)
+			if err != nil {
+				// already released
+				return true, nil
+			}
+			return eip.Status == "Available", nil
+		}, instanceWaitAttempts, instanceWaitInterval); err != nil {
+			return err
+		}
+
+		log.Infof("Releasing EIP %s...", d.EIPAllocationID)
+		if err := c.ReleaseEIP(d.EIPAllocationID); err != nil {
+			return err
+		}
+	}
+
+	if d.InstanceID != "" {
+		log.Infof("Deleting instance %s...", d.InstanceID)
+		if err := c.DeleteInstance(d.InstanceID); err != nil {
+			return err
+		}
+	}
+
+	if d.KeyPairName != "" {
+		log.Debugf("Deleting key pair %s...", d.KeyPairName)
+		if err := c.DeleteKeyPair(d.KeyPairName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package alibabacloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"aliyunecs-ram-role":   "rancher",
+			"aliyunecs-vswitch-id": "vsw-1",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, []string{defaultSecurityGroup}, driver.(*Driver).SecurityGroups)
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		d := NewDriver("default", "path").(*Driver)
+		d.AccessKeyID, d.AccessKeySecret, d.VSwitchID = "id", "secret", "vsw-1"
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "access key", modify: func(d *Driver) {}},
+		{name: "ram role", modify: func(d *Driver) { d.AccessKeyID, d.AccessKeySecret, d.RAMRole = "", "", "rancher" }},
+		{name: "vpc and zone", modify: func(d *Driver) { d.VSwitchID, d.VpcID, d.Zone = "", "vpc-1", "cn-hangzhou-h" }},
+		{name: "no credentials", modify: func(d *Driver) { d.AccessKeySecret = "" }, err: "aliyunecs driver requires --aliyunecs-access-key-id and --aliyunecs-access-key-secret, or --aliyunecs-ram-role"},
+		{name: "both credentials", modify: func(d *Driver) { d.RAMRole = "rancher" }, err: "--aliyunecs-ram-role cannot be combined with an AccessKey"},
+		{name: "no vswitch", modify: func(d *Driver) { d.VSwitchID, d.VpcID = "", "vpc-1" }, err: "aliyunecs driver requires either --aliyunecs-vswitch-id or both --aliyunecs-vpc-id and --aliyunecs-zone"},
+		{name: "no image", modify: func(d *Driver) { d.ImageFamily = "" }, err: "aliyunecs driver requires --aliyunecs-image-id or --aliyunecs-image-family"},
+		{name: "small disk", modify: func(d *Driver) { d.SystemDiskSize = 10 }, err: "--aliyunecs-system-disk-size must be at least 20 GB"},
+		{name: "no bandwidth", modify: func(d *Driver) { d.InternetBandwidth = 0 }, err: "--aliyunecs-internet-max-bandwidth must be positive"},
+		{name: "private without bandwidth", modify: func(d *Driver) { d.InternetBandwidth, d.PrivateIPOnly = 0, true }},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	// example of the Alibaba Cloud signature documentation
+	params := url.Values{
+		"AccessKeyId":      {"testid"},
+		"Action":           {"DescribeRegions"},
+		"Format":           {"XML"},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureNonce":   {"3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf"},
+		"SignatureVersion": {"1.0"},
+		"Timestamp":        {"2016-02-23T12:46:24Z"},
+		"Version":          {"2014-05-26"},
+	}
+
+	assert.Equal(t, "OLeaidS1JvxuMvnyHOwuJ+uX5qY=", sign("GET", params, "testsecret"))
+}
+
+func TestRunInstancesParams(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.KeyPairName = "default"
+
+	params := d.runInstancesParams(&VSwitch{VSwitchID: "vsw-1", VpcID: "vpc-1", ZoneID: "cn-hangzhou-h"}, []string{"sg-1", "sg-2"}, []byte("#cloud-config\n"))
+	assert.Equal(t, "cn-hangzhou-h", params.Get("ZoneId"))
+	assert.Equal(t, "vsw-1", params.Get("VSwitchId"))
+	assert.Equal(t, defaultImageFamily, params.Get("ImageFamily"))
+	assert.Empty(t, params.Get("ImageId"))
+	assert.Equal(t, "sg-1", params.Get("SecurityGroupIds.1"))
+	assert.Equal(t, "sg-2", params.Get("SecurityGroupIds.2"))
+	assert.Empty(t, params.Get("SystemDisk.Size"))
+	assert.Equal(t, "I2Nsb3VkLWNvbmZpZwo=", params.Get("UserData"))
+
+	d.ImageID = "m-1"
+	d.SystemDiskSize = 40
+	params = d.runInstancesParams(&VSwitch{VSwitchID: "vsw-1"}, nil, nil)
+	assert.Equal(t, "m-1", params.Get("ImageId"))
+	assert.Empty(t, params.Get("ImageFamily"))
+	assert.Equal(t, "40", params.Get("SystemDisk.Size"))
+	assert.Empty(t, params.Get("UserData"))
+}
+
+func TestInstanceIP(t *testing.T) {
+	instance := &Instance{}
+	_, err := instanceIP(instance, false)
+	assert.Error(t, err)
+	_, err = instanceIP(instance, true)
+	assert.Error(t, err)
+
+	instance.EipAddress.IPAddress = "47.1.2.3"
+	instance.VpcAttributes.PrivateIPAddress.IPAddress = []string{"172.16.0.4"}
+	ip, err := instanceIP(instance, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "47.1.2.3", ip)
+	ip, err = instanceIP(instance, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "172.16.0.4", ip)
+}
+
+func TestInstanceState(t *testing.T) {
+	assert.Equal(t, state.Starting, instanceState("Pending"))
+	assert.Equal(t, state.Running, instanceState("Running"))
+	assert.Equal(t, state.Stopping, instanceState("Stopping"))
+	assert.Equal(t, state.Stopped, instanceState("Stopped"))
+	assert.Equal(t, state.None, instanceState(""))
+}
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/meta-data/ram/security-credentials/rancher", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Code":"Success","AccessKeyId":"STS.id","AccessKeySecret":"secret","SecurityToken":"token","Expiration":"2099-01-01T00:00:00Z"}`)
+	})
+	mux.HandleFunc("/ecs/", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "STS.id", q.Get("AccessKeyId"))
+		assert.Equal(t, "token", q.Get("SecurityToken"))
+		assert.Equal(t, "cn-beijing", q.Get("RegionId"))
+
+		signature := q.Get("Signature")
+		q.Del("Signature")
+		assert.Equal(t, sign("GET", q, "secret"), signature)
+
+		switch q.Get("Action") {
+		case "DescribeInstances":
+			assert.Equal(t, `["i-1"]`, q.Get("InstanceIds"))
+			fmt.Fprint(w, `{"Instances":{"Instance":[{"InstanceId":"i-1","Status":"Running","EipAddress":{"AllocationId":"eip-1","IpAddress":"47.1.2.3"}}]}}`)
+		case "DeleteInstance":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"Code":"InvalidInstanceId.NotFound","Message":"The specified InstanceId does not exist.","RequestId":"R1"}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"Code":"Forbidden.RAM","Message":"User not authorized to operate on the specified resource.","RequestId":"R2"}`)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	oldMetadata := metadataEndpoint
+	metadataEndpoint = server.URL + "/meta-data/ram/security-credentials/"
+	defer func() { metadataEndpoint = oldMetadata }()
+
+	c := NewClient("cn-beijing", &Credentials{RAMRole: "rancher"})
+	c.ECSEndpoint = server.URL + "/ecs"
+
+	instance, err := c.GetInstance("i-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Running", instance.Status)
+	assert.Equal(t, "47.1.2.3", instance.EipAddress.IPAddress)
+
+	assert.NoError(t, c.DeleteInstance("i-1"))
+	assert.EqualError(t, c.StartInstance("i-1"), "StartInstance: Forbidden.RAM: User not authorized to operate on the specified resource. (request R2)")
+}
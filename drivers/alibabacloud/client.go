@@ -0,0 +1,485 @@
+package alibabacloud
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ecsAPIVersion = "2014-05-26"
+	vpcAPIVersion = "2016-04-28"
+
+	timestampFormat = "2006-01-02T15:04:05Z"
+)
+
+var (
+	ecsEndpointFormat        = "https://ecs.%s.aliyuncs.com"
+	vpcEndpointFormat        = "https://vpc.%s.aliyuncs.com"
+	metadataEndpoint         = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+	credentialsExpiryMargin  = 5 * time.Minute
+	defaultHTTPClientTimeout = 60 * time.Second
+)
+
+// Credentials signs requests either with a static AccessKey pair or with the
+// temporary credentials of the RAM role attached to the ECS instance that
+// runs the driver.
+type Credentials struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	RAMRole         string
+
+	mu            sync.Mutex
+	securityToken string
+	stsKeyID      string
+	stsKeySecret  string
+	expires       time.Time
+	http          *http.Client
+}
+
+// get returns the AccessKey ID, secret and security token to sign a request
+// with, refreshing the role credentials from the instance metadata when they
+// are about to expire.
+func (c *Credentials) get() (string, string, string, error) {
+	if c.RAMRole == "" {
+		return c.AccessKeyID, c.AccessKeySecret, "", nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.securityToken != "" && time.Now().Add(credentialsExpiryMargin).Before(c.expires) {
+		return c.stsKeyID, c.stsKeySecret, c.securityToken, nil
+	}
+
+	httpClient := c.http
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultHTTPClientTimeout}
+	}
+	resp, err := httpClient.Get(metadataEndpoint + url.PathEscape(c.RAMRole))
+	if err != nil {
+		return "", "", "", fmt.Errorf("cannot get credentials of RAM role %s: %s", c.RAMRole, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", "", fmt.Errorf("cannot get credentials of RAM role %s: %s", c.RAMRole, resp.Status)
+	}
+
+	var creds struct {
+		Code            string
+		AccessKeyID     string `json:"AccessKeyId"`
+		AccessKeySecret string
+		SecurityToken   string
+		Expiration      string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return "", "", "", fmt.Errorf("cannot decode credentials of RAM role %s: %s", c.RAMRole, err)
+	}
+	if creds.Code != "Success" {
+		return "", "", "", fmt.Errorf("cannot get credentials of RAM role %s: %s", c.RAMRole, creds.Code)
+	}
+
+	expires, err := time.Parse(timestampFormat, creds.Expiration)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid expiration of RAM role credentials: %s", err)
+	}
+
+	c.stsKeyID = creds.AccessKeyID
+	c.stsKeySecret = creds.AccessKeySecret
+	c.securityToken = creds.SecurityToken
+	c.expires = expires
+	return c.stsKeyID, c.stsKeySecret, c.securityToken, nil
+}
+
+// Client calls the ECS and VPC APIs of one region.
+type Client struct {
+	RegionID    string
+	ECSEndpoint string
+	VPCEndpoint string
+	// DockerPort is the port of the Docker daemon allowed by the security
+	// groups created
+	DockerPort int
+	creds      *Credentials
+	http       *http.Client
+}
+
+type Instance struct {
+	InstanceID string `json:"InstanceId"`
+	Status     string
+	ZoneID     string `json:"ZoneId"`
+	EipAddress struct {
+		AllocationID string `json:"AllocationId"`
+		IPAddress    string `json:"IpAddress"`
+	}
+	VpcAttributes struct {
+		VpcID            string `json:"VpcId"`
+		VSwitchID        string `json:"VSwitchId"`
+		PrivateIPAddress struct {
+			IPAddress []string `json:"IpAddress"`
+		} `json:"PrivateIpAddress"`
+	}
+}
+
+type VSwitch struct {
+	VSwitchID string `json:"VSwitchId"`
+	VpcID     string `json:"VpcId"`
+	ZoneID    string `json:"ZoneId"`
+}
+
+type EIP struct {
+	AllocationID string `json:"AllocationId"`
+	IPAddress    string `json:"IpAddress"`
+	Status       string
+}
+
+type apiError struct {
+	Code      string
+	Message   string
+	RequestID string `json:"RequestId"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s (request %s)", e.Code, e.Message, e.RequestID)
+}
+
+func NewClient(regionID string, creds *Credentials) *Client {
+	httpClient := &http.Client{Timeout: defaultHTTPClientTimeout}
+	creds.http = httpClient
+	return &Client{
+		RegionID:    regionID,
+		ECSEndpoint: fmt.Sprintf(ecsEndpointFormat, regionID),
+		VPCEndpoint: fmt.Sprintf(vpcEndpointFormat, regionID),
+		DockerPort:  dockerPort,
+		creds:       creds,
+		http:        httpClient,
+	}
+}
+
+// percentEncode escapes a value the way the RPC signature expects it.
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+// sign computes the signature of version 1.0 of the RPC-style API.
+func sign(method string, params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, percentEncode(k)+"="+percentEncode(params.Get(k)))
+	}
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (c *Client) call(endpoint, version, action string, params url.Values, out interface{}) error {
+	keyID, secret, token, err := c.creds.get()
+	if err != nil {
+		return err
+	}
+
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("Action", action)
+	params.Set("Version", version)
+	params.Set("Format", "JSON")
+	params.Set("RegionId", c.RegionID)
+	params.Set("AccessKeyId", keyID)
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("SignatureNonce", nonce())
+	params.Set("Timestamp", time.Now().UTC().Format(timestampFormat))
+	if token != "" {
+		params.Set("SecurityToken", token)
+	}
+	params.Set("Signature", sign("GET", params, secret))
+
+	resp, err := c.http.Get(endpoint + "/?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &apiError{}
+		if json.Unmarshal(data, apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("%s: %w", action, apiErr)
+		}
+		return fmt.Errorf("%s: %s", action, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *Client) ecs(action string, params url.Values, out interface{}) error {
+	return c.call(c.ECSEndpoint, ecsAPIVersion, action, params, out)
+}
+
+func (c *Client) vpc(action string, params url.Values, out interface{}) error {
+	return c.call(c.VPCEndpoint, vpcAPIVersion, action, params, out)
+}
+
+func isErrorCode(err error, codes ...string) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// VSwitch returns the vSwitch with that ID, or else the first vSwitch of the
+// VPC in the zone.
+func (c *Client) VSwitch(vswitchID, vpcID, zoneID string) (*VSwitch, error) {
+	params := url.Values{}
+	if vswitchID != "" {
+		params.Set("VSwitchId", vswitchID)
+	}
+	if vpcID != "" {
+		params.Set("VpcId", vpcID)
+	}
+	if zoneID != "" {
+		params.Set("ZoneId", zoneID)
+	}
+
+	var resp struct {
+		VSwitches struct {
+			VSwitch []VSwitch
+		}
+	}
+	if err := c.vpc("DescribeVSwitches", params, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.VSwitches.VSwitch) == 0 {
+		if vswitchID != "" {
+			return nil, fmt.Errorf("vSwitch %s not found", vswitchID)
+		}
+		return nil, fmt.Errorf("no vSwitch found in VPC %s and zone %s", vpcID, zoneID)
+	}
+	return &resp.VSwitches.VSwitch[0], nil
+}
+
+// SecurityGroupID resolves a security group of the VPC by ID or by name. A
+// group that is given by name and doesn't exist is created, allowing SSH and
+// Docker.
+func (c *Client) SecurityGroupID(vpcID, name string, create bool) (string, error) {
+	params := url.Values{"VpcId": {vpcID}, "PageSize": {"50"}}
+	if strings.HasPrefix(name, "sg-") {
+		params.Set("SecurityGroupIds", fmt.Sprintf(`["%s"]`, name))
+	} else {
+		params.Set("SecurityGroupName", name)
+	}
+
+	var resp struct {
+		SecurityGroups struct {
+			SecurityGroup []struct {
+				SecurityGroupID   string `json:"SecurityGroupId"`
+				SecurityGroupName string
+			}
+		}
+	}
+	if err := c.ecs("DescribeSecurityGroups", params, &resp); err != nil {
+		return "", err
+	}
+	for _, sg := range resp.SecurityGroups.SecurityGroup {
+		if sg.SecurityGroupID == name || sg.SecurityGroupName == name {
+			return sg.SecurityGroupID, nil
+		}
+	}
+
+	if !create || strings.HasPrefix(name, "sg-") {
+		return "", fmt.Errorf("security group %s not found in VPC %s", name, vpcID)
+	}
+	return c.createSecurityGroup(vpcID, name)
+}
+
+func (c *Client) createSecurityGroup(vpcID, name string) (string, error) {
+	var created struct {
+		SecurityGroupID string `json:"SecurityGroupId"`
+	}
+	if err := c.ecs("CreateSecurityGroup", url.Values{
+		"VpcId":             {vpcID},
+		"SecurityGroupName": {name},
+		"Description":       {"Created by rancher-machine"},
+	}, &created); err != nil {
+		return "", err
+	}
+
+	for _, port := range []string{"22", strconv.Itoa(c.DockerPort)} {
+		if err := c.ecs("AuthorizeSecurityGroup", url.Values{
+			"SecurityGroupId": {created.SecurityGroupID},
+			"IpProtocol":      {"tcp"},
+			"PortRange":       {port + "/" + port},
+			"SourceCidrIp":    {"0.0.0.0/0"},
+		}, nil); err != nil {
+			return "", err
+		}
+	}
+
+	return created.SecurityGroupID, nil
+}
+
+func (c *Client) ImportKeyPair(name, publicKey string) error {
+	return c.ecs("ImportKeyPair", url.Values{"KeyPairName": {name}, "PublicKeyBody": {publicKey}}, nil)
+}
+
+func (c *Client) DeleteKeyPair(name string) error {
+	return c.ecs("DeleteKeyPairs", url.Values{"KeyPairNames": {fmt.Sprintf(`["%s"]`, name)}}, nil)
+}
+
+// RunInstance creates and starts one instance.
+func (c *Client) RunInstance(params url.Values) (string, error) {
+	params.Set("Amount", "1")
+
+	var resp struct {
+		InstanceIDSets struct {
+			InstanceIDSet []string `json:"InstanceIdSet"`
+		} `json:"InstanceIdSets"`
+	}
+	if err := c.ecs("RunInstances", params, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.InstanceIDSets.InstanceIDSet) == 0 {
+		return "", errors.New("RunInstances returned no instance")
+	}
+	return resp.InstanceIDSets.InstanceIDSet[0], nil
+}
+
+func (c *Client) GetInstance(id string) (*Instance, error) {
+	var resp struct {
+		Instances struct {
+			Instance []Instance
+		}
+	}
+	if err := c.ecs("DescribeInstances", url.Values{"InstanceIds": {fmt.Sprintf(`["%s"]`, id)}}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Instances.Instance) == 0 {
+		return nil, fmt.Errorf("instance %s not found", id)
+	}
+	return &resp.Instances.Instance[0], nil
+}
+
+func (c *Client) StartInstance(id string) error {
+	return c.ecs("StartInstance", url.Values{"InstanceId": {id}}, nil)
+}
+
+func (c *Client) StopInstance(id string, force bool) error {
+	return c.ecs("StopInstance", url.Values{"InstanceId": {id}, "ForceStop": {strconv.FormatBool(force)}}, nil)
+}
+
+func (c *Client) RebootInstance(id string) error {
+	return c.ecs("RebootInstance", url.Values{"InstanceId": {id}}, nil)
+}
+
+// DeleteInstance deletes the instance even if it is running, treating an
+// instance that is already gone as deleted.
+func (c *Client) DeleteInstance(id string) error {
+	err := c.ecs("DeleteInstance", url.Values{"InstanceId": {id}, "Force": {"true"}}, nil)
+	if isErrorCode(err, "InvalidInstanceId.NotFound") {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) AllocateEIP(name string, bandwidth int) (*EIP, error) {
+	// unlike DescribeEipAddresses, AllocateEipAddress calls the address EipAddress
+	var resp struct {
+		AllocationID string `json:"AllocationId"`
+		EipAddress   string
+	}
+	if err := c.vpc("AllocateEipAddress", url.Values{
+		"Name":               {name},
+		"Bandwidth":          {strconv.Itoa(bandwidth)},
+		"InternetChargeType": {"PayByTraffic"},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return &EIP{AllocationID: resp.AllocationID, IPAddress: resp.EipAddress}, nil
+}
+
+func (c *Client) GetEIP(allocationID string) (*EIP, error) {
+	var resp struct {
+		EipAddresses struct {
+			EipAddress []EIP
+		}
+	}
+	if err := c.vpc("DescribeEipAddresses", url.Values{"AllocationId": {allocationID}}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.EipAddresses.EipAddress) == 0 {
+		return nil, fmt.Errorf("EIP %s not found", allocationID)
+	}
+	return &resp.EipAddresses.EipAddress[0], nil
+}
+
+func (c *Client) AssociateEIP(allocationID, instanceID string) error {
+	return c.vpc("AssociateEipAddress", url.Values{
+		"AllocationId": {allocationID},
+		"InstanceId":   {instanceID},
+		"InstanceType": {"EcsInstance"},
+	}, nil)
+}
+
+func (c *Client) UnassociateEIP(allocationID, instanceID string) error {
+	err := c.vpc("UnassociateEipAddress", url.Values{
+		"AllocationId": {allocationID},
+		"InstanceId":   {instanceID},
+		"InstanceType": {"EcsInstance"},
+	}, nil)
+	if isErrorCode(err, "InvalidAllocationId.NotFound", "IncorrectEipStatus") {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) ReleaseEIP(allocationID string) error {
+	err := c.vpc("ReleaseEipAddress", url.Values{"AllocationId": {allocationID}}, nil)
+	if isErrorCode(err, "InvalidAllocationId.NotFound") {
+		return nil
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package amazonec2
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/base64"
@@ -13,12 +14,15 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/rancher/machine/drivers/driverutil"
@@ -56,10 +60,11 @@ const (
 const (
 	keypairNotFoundCode             = "InvalidKeyPair.NotFound"
 	spotInstanceRequestNotFoundCode = "InvalidSpotInstanceRequestID.NotFound"
+	spotInstanceTerminationCode     = "Server.SpotInstanceTermination"
+	spotInstanceShutdownCode        = "Server.SpotInstanceShutdown"
 )
 
 var (
-	dockerPort                           int64 = 2376
 	swarmPort                            int64 = 3376
 	kubeApiPort                          int64 = 6443
 	httpPort                             int64 = 80
@@ -78,13 +83,22 @@ var (
 	nodePorts                                  = []int64{30000, 32767}
 	calicoPort                           int64 = 179
 	errorNoPrivateSSHKey                       = errors.New("using --amazonec2-keypair-name also requires --amazonec2-ssh-keypath")
-	errorMissingCredentials                    = errors.New("amazonec2 driver requires AWS credentials configured with the --amazonec2-access-key and --amazonec2-secret-key options, environment variables, ~/.aws/credentials, or an instance role")
+	errorMissingCredentials                    = errors.New("amazonec2 driver requires AWS credentials configured with the --amazonec2-access-key and --amazonec2-secret-key options, --amazonec2-profile, environment variables, ~/.aws/credentials and ~/.aws/config, or an instance or task role")
 	errorNoVPCIdFound                          = errors.New("amazonec2 driver requires either the --amazonec2-subnet-id or --amazonec2-vpc-id option or an AWS Account with a default vpc-id")
 	errorNoSubnetsFound                        = errors.New("The desired subnet could not be located in this region. Is '--amazonec2-subnet-id' or AWS_SUBNET_ID configured correctly?")
 	errorDisableSSLWithoutCustomEndpoint       = errors.New("using --amazonec2-insecure-transport also requires --amazonec2-endpoint")
 	errorReadingUserData                       = errors.New("unable to read --amazonec2-userdata file")
 	errorInvalidValueForHTTPToken              = errors.New("httpToken must be either optional or required")
 	errorInvalidValueForHTTPEndpoint           = errors.New("httpEndpoint must be either enabled or disabled")
+	errorInvalidValueForHTTPHopLimit           = errors.New("httpPutResponseHopLimit must be between 1 and 64")
+	errorInvalidPlacementStrategy              = errors.New("placement group strategy must be one of cluster, spread or partition")
+	errorPartitionWithoutPartitionGroup        = errors.New("using --amazonec2-placement-partition requires a partition placement group")
+	errorInvalidTenancy                        = errors.New("tenancy must be one of default, dedicated or host")
+	errorHostIdWithoutHostTenancy              = errors.New("using --amazonec2-host-id requires --amazonec2-tenancy=host")
+	errorMultipleInterfacesPublicIP            = errors.New("additional network interfaces require --amazonec2-private-address-only or --amazonec2-use-private-address, AWS doesn't assign public IPs to multi-interface instances")
+	errorInvalidDockerInterface                = errors.New("--amazonec2-docker-interface must refer to the primary or an additional network interface")
+	errorIopsNotSupported                      = errors.New("volume IOPS can only be set for io1, io2 and gp3 volumes")
+	errorThroughputNotSupported                = errors.New("volume throughput can only be set for gp3 volumes")
 )
 
 type Driver struct {
@@ -92,9 +106,10 @@ type Driver struct {
 	clientFactory         func() Ec2Client
 	awsCredentialsFactory func() awsCredentials
 	Id                    string
-	AccessKey             string
-	SecretKey             string
-	SessionToken          string
+	AccessKey             string `secret:"true"`
+	SecretKey             string `secret:"true"`
+	SessionToken          string `secret:"true"`
+	Profile               string
 	Region                string
 	AMI                   string
 	SSHKeyID              int
@@ -114,13 +129,25 @@ type Driver struct {
 	SecurityGroupName  string
 	SecurityGroupNames []string
 
-	SecurityGroupReadOnly   bool
-	OpenPorts               []string
-	Tags                    string
+	SecurityGroupReadOnly bool
+	OpenPorts             []string
+	Tags                  string
+	// Labels are the labels of the machine, added to the tags
+	Labels                  map[string]string
 	ReservationId           string
 	DeviceName              string
 	RootSize                int64
 	VolumeType              string
+	VolumeIops              int64
+	VolumeThroughput        int64
+	DataVolumes             []DataVolume
+	LaunchTemplate          string
+	LaunchTemplateVersion   string
+	PlacementGroup          string
+	PlacementGroupStrategy  string
+	PlacementPartition      int64
+	Tenancy                 string
+	HostId                  string
 	IamInstanceProfile      string
 	VpcId                   string
 	SubnetId                string
@@ -128,9 +155,16 @@ type Driver struct {
 	keyPath                 string
 	RequestSpotInstance     bool
 	SpotPrice               string
+	SpotFallbackOnDemand    bool
+	OnDemandFallback        bool
+	SpotInstanceRequestId   string
 	BlockDurationMinutes    int64
 	PrivateIPOnly           bool
 	UsePrivateIP            bool
+	UseSSM                  bool
+	SecondaryPrivateIPCount int64
+	NetworkInterfaces       []NetworkInterface
+	DockerInterfaceIndex    int64
 	UseEbsOptimizedInstance bool
 	Monitoring              bool
 	SSHPrivateKeyPath       string
@@ -139,12 +173,34 @@ type Driver struct {
 	DisableSSL              bool
 	UserDataFile            string
 	EncryptEbsVolume        bool
-	spotInstanceRequestId   string
 	kmsKeyId                *string
 	bdmList                 []*ec2.BlockDeviceMapping
+	templateTags            []*ec2.LaunchTemplateTagSpecification
+	ssmTunnel               *ssmTunnel
 	// Metadata Options
-	HttpEndpoint string
-	HttpTokens   string
+	HttpEndpoint            string
+	HttpTokens              string `secret:"false"`
+	HttpPutResponseHopLimit int64
+}
+
+// DataVolume describes an additional EBS volume attached to the instance at
+// creation time.
+type DataVolume struct {
+	DeviceName string
+	Size       int64
+	Type       string
+	Iops       int64
+	Throughput int64
+	Encrypted  bool
+}
+
+// NetworkInterface describes an additional ENI attached to the instance at
+// creation time. Its device index follows the order of the flags, starting
+// at 1 (eth1).
+type NetworkInterface struct {
+	SubnetId                string
+	SecurityGroupIds        []string
+	SecondaryPrivateIPCount int64
 }
 
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
@@ -164,6 +220,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "AWS Session Token",
 			EnvVar: "AWS_SESSION_TOKEN",
 		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-profile",
+			Usage:  "AWS shared config profile, which may use SSO, role chaining or a credential process, when no access key is set",
+			EnvVar: "AWS_PROFILE",
+		},
 		mcnflag.StringFlag{
 			Name:   "amazonec2-ami",
 			Usage:  "AWS machine image",
@@ -175,6 +236,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultRegion,
 			EnvVar: "AWS_DEFAULT_REGION",
 		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-launch-template",
+			Usage:  "AWS launch template id or name; machine flags override the template settings",
+			EnvVar: "AWS_LAUNCH_TEMPLATE",
+		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-launch-template-version",
+			Usage:  "AWS launch template version (defaults to the template's default version)",
+			EnvVar: "AWS_LAUNCH_TEMPLATE_VERSION",
+		},
 		mcnflag.StringFlag{
 			Name:   "amazonec2-vpc-id",
 			Usage:  "AWS VPC id",
@@ -234,6 +305,45 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultVolumeType,
 			EnvVar: "AWS_VOLUME_TYPE",
 		},
+		mcnflag.IntFlag{
+			Name:   "amazonec2-volume-iops",
+			Usage:  "Provisioned IOPS of the root volume (io1, io2 and gp3 only)",
+			EnvVar: "AWS_VOLUME_IOPS",
+		},
+		mcnflag.IntFlag{
+			Name:   "amazonec2-volume-throughput",
+			Usage:  "Throughput of the root volume in MiB/s (gp3 only)",
+			EnvVar: "AWS_VOLUME_THROUGHPUT",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "amazonec2-data-volume",
+			Usage: "Additional EBS volume (e.g. device=/dev/sdf,size=100,type=gp3,iops=3000,throughput=125,encrypted=true)",
+		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-placement-group",
+			Usage:  "AWS placement group to launch the instance in",
+			EnvVar: "AWS_PLACEMENT_GROUP",
+		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-placement-group-strategy",
+			Usage:  "Create the placement group with this strategy (cluster, spread or partition) if it does not exist",
+			EnvVar: "AWS_PLACEMENT_GROUP_STRATEGY",
+		},
+		mcnflag.IntFlag{
+			Name:   "amazonec2-placement-partition",
+			Usage:  "Partition number within a partition placement group",
+			EnvVar: "AWS_PLACEMENT_PARTITION",
+		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-tenancy",
+			Usage:  "AWS instance tenancy (default, dedicated or host)",
+			EnvVar: "AWS_TENANCY",
+		},
+		mcnflag.StringFlag{
+			Name:   "amazonec2-host-id",
+			Usage:  "AWS dedicated host id to launch the instance on; requires --amazonec2-tenancy=host",
+			EnvVar: "AWS_HOST_ID",
+		},
 		mcnflag.StringFlag{
 			Name:   "amazonec2-iam-instance-profile",
 			Usage:  "AWS IAM Instance Profile",
@@ -254,6 +364,10 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage: "AWS spot instance bid price (in dollar)",
 			Value: defaultSpotPrice,
 		},
+		mcnflag.BoolFlag{
+			Name:  "amazonec2-spot-fallback-on-demand",
+			Usage: "Launch an on-demand instance if the spot instance request cannot be fulfilled",
+		},
 		mcnflag.IntFlag{
 			Name:  "amazonec2-block-duration-minutes",
 			Usage: "AWS spot instance duration in minutes (60, 120, 180, 240, 300, or 360)",
@@ -267,6 +381,22 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:  "amazonec2-use-private-address",
 			Usage: "Force the usage of private IP address",
 		},
+		mcnflag.IntFlag{
+			Name:  "amazonec2-secondary-private-ip-count",
+			Usage: "Number of secondary private IP addresses to assign to the primary network interface",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "amazonec2-network-interface",
+			Usage: "Additional network interface (e.g. subnet=subnet-1234,security-groups=sg-1;sg-2,private-ip-count=2)",
+		},
+		mcnflag.IntFlag{
+			Name:  "amazonec2-docker-interface",
+			Usage: "Device index of the network interface whose private address is used for the Docker URL (0 uses the regular address)",
+		},
+		mcnflag.BoolFlag{
+			Name:  "amazonec2-use-ssm",
+			Usage: "Connect to the instance through an AWS SSM session instead of public SSH; implies --amazonec2-private-address-only, the Docker URL being reachable from the VPC only",
+		},
 		mcnflag.BoolFlag{
 			Name:  "amazonec2-monitoring",
 			Usage: "Set this flag to enable CloudWatch monitoring",
@@ -326,6 +456,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "The state of token usage for your instance metadata requests.",
 			EnvVar: "AWS_HTTP_TOKENS",
 		},
+		mcnflag.IntFlag{
+			Name:   "amazonec2-http-put-response-hop-limit",
+			Usage:  "The desired HTTP PUT response hop limit for instance metadata requests (1-64)",
+			EnvVar: "AWS_HTTP_PUT_RESPONSE_HOP_LIMIT",
+		},
 	}
 }
 
@@ -366,11 +501,19 @@ func (d *Driver) buildClient() Ec2Client {
 		config = config.WithEndpoint(d.Endpoint)
 		config = config.WithDisableSSL(d.DisableSSL)
 	}
-	return ec2.New(session.New(config))
+	client := ec2.New(session.New(config))
+	client.Handlers.Build.PushFront(d.setRequestContext)
+	return client
+}
+
+// setRequestContext has the EC2 requests, and the waits between their
+// retries, aborted when the context of the operation in progress is done.
+func (d *Driver) setRequestContext(r *request.Request) {
+	r.SetContext(d.Context())
 }
 
 func (d *Driver) buildCredentials() awsCredentials {
-	return NewAWSCredentials(d.AccessKey, d.SecretKey, d.SessionToken)
+	return NewAWSCredentials(d.AccessKey, d.SecretKey, d.SessionToken, d.Profile)
 }
 
 func (d *Driver) getClient() Ec2Client {
@@ -409,18 +552,25 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		return err
 	}
 
+	d.LaunchTemplate = flags.String("amazonec2-launch-template")
+	d.LaunchTemplateVersion = flags.String("amazonec2-launch-template-version")
+
+	// When launching from a template the AMI is resolved in PreCreateCheck,
+	// the template's image wins over the region default.
 	image := flags.String("amazonec2-ami")
-	if len(image) == 0 {
+	if len(image) == 0 && d.LaunchTemplate == "" {
 		image = regionDetails[region].AmiId
 	}
 
 	d.AccessKey = flags.String("amazonec2-access-key")
 	d.SecretKey = flags.String("amazonec2-secret-key")
 	d.SessionToken = flags.String("amazonec2-session-token")
+	d.Profile = flags.String("amazonec2-profile")
 	d.Region = region
 	d.AMI = image
 	d.RequestSpotInstance = flags.Bool("amazonec2-request-spot-instance")
 	d.SpotPrice = flags.String("amazonec2-spot-price")
+	d.SpotFallbackOnDemand = flags.Bool("amazonec2-spot-fallback-on-demand")
 	d.BlockDurationMinutes = int64(flags.Int("amazonec2-block-duration-minutes"))
 	d.InstanceType = flags.String("amazonec2-instance-type")
 	d.VpcId = flags.String("amazonec2-vpc-id")
@@ -433,11 +583,48 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.DeviceName = flags.String("amazonec2-device-name")
 	d.RootSize = int64(flags.Int("amazonec2-root-size"))
 	d.VolumeType = flags.String("amazonec2-volume-type")
+	d.VolumeIops = int64(flags.Int("amazonec2-volume-iops"))
+	d.VolumeThroughput = int64(flags.Int("amazonec2-volume-throughput"))
+	if err := validateVolumeOptions(d.VolumeType, d.VolumeIops, d.VolumeThroughput); err != nil {
+		return err
+	}
+
+	d.DataVolumes = nil
+	for _, spec := range flags.StringSlice("amazonec2-data-volume") {
+		volume, err := parseDataVolume(spec)
+		if err != nil {
+			return err
+		}
+		d.DataVolumes = append(d.DataVolumes, volume)
+	}
 	d.IamInstanceProfile = flags.String("amazonec2-iam-instance-profile")
+	d.PlacementGroup = flags.String("amazonec2-placement-group")
+	d.PlacementGroupStrategy = flags.String("amazonec2-placement-group-strategy")
+	d.PlacementPartition = int64(flags.Int("amazonec2-placement-partition"))
+	d.Tenancy = flags.String("amazonec2-tenancy")
+	d.HostId = flags.String("amazonec2-host-id")
+	if err := d.validatePlacement(); err != nil {
+		return err
+	}
 	d.SSHUser = flags.String("amazonec2-ssh-user")
 	d.SSHPort = 22
 	d.PrivateIPOnly = flags.Bool("amazonec2-private-address-only")
 	d.UsePrivateIP = flags.Bool("amazonec2-use-private-address")
+	d.UseSSM = flags.Bool("amazonec2-use-ssm")
+	d.SecondaryPrivateIPCount = int64(flags.Int("amazonec2-secondary-private-ip-count"))
+	d.DockerInterfaceIndex = int64(flags.Int("amazonec2-docker-interface"))
+	d.NetworkInterfaces = nil
+	for _, spec := range flags.StringSlice("amazonec2-network-interface") {
+		networkInterface, err := parseNetworkInterface(spec)
+		if err != nil {
+			return err
+		}
+		d.NetworkInterfaces = append(d.NetworkInterfaces, networkInterface)
+	}
+	if d.UseSSM {
+		// The instance is reached through SSM, it doesn't need a public address.
+		d.PrivateIPOnly = true
+	}
 	d.Monitoring = flags.Bool("amazonec2-monitoring")
 	d.UseEbsOptimizedInstance = flags.Bool("amazonec2-use-ebs-optimized-instance")
 	d.SSHPrivateKeyPath = flags.String("amazonec2-ssh-keypath")
@@ -465,6 +652,14 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		d.HttpTokens = httpTokens
 	}
 
+	hopLimit := flags.Int("amazonec2-http-put-response-hop-limit")
+	if hopLimit != 0 {
+		if hopLimit < 1 || hopLimit > 64 {
+			return errorInvalidValueForHTTPHopLimit
+		}
+		d.HttpPutResponseHopLimit = int64(hopLimit)
+	}
+
 	kmskeyid := flags.String("amazonec2-kms-key")
 	if kmskeyid != "" {
 		d.kmsKeyId = aws.String(kmskeyid)
@@ -480,8 +675,19 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		return errorNoPrivateSSHKey
 	}
 
+	if len(d.NetworkInterfaces) > 0 && !d.PrivateIPOnly && !d.UsePrivateIP {
+		return errorMultipleInterfacesPublicIP
+	}
+
+	if d.DockerInterfaceIndex < 0 || d.DockerInterfaceIndex > int64(len(d.NetworkInterfaces)) {
+		return errorInvalidDockerInterface
+	}
+
 	_, err = d.awsCredentialsFactory().Credentials().Get()
 	if err != nil {
+		if d.Profile != "" {
+			return fmt.Errorf("unable to get the AWS credentials of profile %q, run \"aws sso login --profile %s\" if it uses SSO: %s", d.Profile, d.Profile, err)
+		}
 		return errorMissingCredentials
 	}
 
@@ -607,11 +813,161 @@ func (d *Driver) checkAMI() error {
 	return nil
 }
 
+func (d *Driver) validatePlacement() error {
+	switch d.PlacementGroupStrategy {
+	case "", ec2.PlacementStrategyCluster, ec2.PlacementStrategySpread, ec2.PlacementStrategyPartition:
+	default:
+		return errorInvalidPlacementStrategy
+	}
+
+	if d.PlacementPartition != 0 && (d.PlacementGroup == "" || d.PlacementGroupStrategy == ec2.PlacementStrategyCluster || d.PlacementGroupStrategy == ec2.PlacementStrategySpread) {
+		return errorPartitionWithoutPartitionGroup
+	}
+
+	switch d.Tenancy {
+	case "", ec2.TenancyDefault, ec2.TenancyDedicated, ec2.TenancyHost:
+	default:
+		return errorInvalidTenancy
+	}
+
+	if d.HostId != "" && d.Tenancy != ec2.TenancyHost {
+		return errorHostIdWithoutHostTenancy
+	}
+
+	return nil
+}
+
+// configurePlacementGroup makes sure the requested placement group exists,
+// creating it when a strategy was given.
+func (d *Driver) configurePlacementGroup() error {
+	if d.PlacementGroup == "" {
+		return nil
+	}
+
+	groups, err := d.getClient().DescribePlacementGroups(&ec2.DescribePlacementGroupsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("group-name"),
+				Values: []*string{&d.PlacementGroup},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(groups.PlacementGroups) > 0 {
+		log.Debugf("joining existing placement group %s", d.PlacementGroup)
+		return nil
+	}
+
+	if d.PlacementGroupStrategy == "" {
+		return fmt.Errorf("placement group %s not found; use --amazonec2-placement-group-strategy to create it", d.PlacementGroup)
+	}
+
+	log.Debugf("creating %s placement group %s", d.PlacementGroupStrategy, d.PlacementGroup)
+	_, err = d.getClient().CreatePlacementGroup(&ec2.CreatePlacementGroupInput{
+		GroupName: &d.PlacementGroup,
+		Strategy:  &d.PlacementGroupStrategy,
+	})
+	if err != nil && !strings.Contains(err.Error(), "Duplicate") {
+		return fmt.Errorf("unable to create placement group %s: %s", d.PlacementGroup, err)
+	}
+
+	return nil
+}
+
+// launchTemplateSpecification references the configured launch template either
+// by id (lt-...) or by name.
+func (d *Driver) launchTemplateSpecification() *ec2.LaunchTemplateSpecification {
+	spec := &ec2.LaunchTemplateSpecification{}
+	if strings.HasPrefix(d.LaunchTemplate, "lt-") {
+		spec.LaunchTemplateId = aws.String(d.LaunchTemplate)
+	} else {
+		spec.LaunchTemplateName = aws.String(d.LaunchTemplate)
+	}
+	if d.LaunchTemplateVersion != "" {
+		spec.Version = aws.String(d.LaunchTemplateVersion)
+	}
+	return spec
+}
+
+// checkLaunchTemplate resolves the launch template and lets it supply the
+// settings which were left at their defaults on the command line.
+func (d *Driver) checkLaunchTemplate() error {
+	if d.LaunchTemplate == "" {
+		return nil
+	}
+
+	spec := d.launchTemplateSpecification()
+	version := aws.String("$Default")
+	if spec.Version != nil {
+		version = spec.Version
+	}
+
+	versions, err := d.getClient().DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId:   spec.LaunchTemplateId,
+		LaunchTemplateName: spec.LaunchTemplateName,
+		Versions:           []*string{version},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe launch template %s: %s", d.LaunchTemplate, err)
+	}
+	if len(versions.LaunchTemplateVersions) == 0 || versions.LaunchTemplateVersions[0].LaunchTemplateData == nil {
+		return fmt.Errorf("launch template %s version %s not found", d.LaunchTemplate, *version)
+	}
+
+	d.applyLaunchTemplateData(versions.LaunchTemplateVersions[0].LaunchTemplateData)
+	return nil
+}
+
+func (d *Driver) applyLaunchTemplateData(data *ec2.ResponseLaunchTemplateData) {
+	if d.AMI == "" {
+		if data.ImageId != nil {
+			d.AMI = *data.ImageId
+		} else {
+			d.AMI = regionDetails[d.Region].AmiId
+		}
+	}
+
+	if d.InstanceType == defaultInstanceType && data.InstanceType != nil {
+		d.InstanceType = *data.InstanceType
+	}
+
+	if d.IamInstanceProfile == "" && data.IamInstanceProfile != nil && data.IamInstanceProfile.Name != nil {
+		d.IamInstanceProfile = *data.IamInstanceProfile.Name
+	}
+
+	groupIds := data.SecurityGroupIds
+	if len(groupIds) == 0 && len(data.NetworkInterfaces) > 0 {
+		groupIds = data.NetworkInterfaces[0].Groups
+	}
+	if len(groupIds) > 0 && reflect.DeepEqual(d.SecurityGroupNames, []string{defaultSecurityGroup}) {
+		d.SecurityGroupNames = nil
+		d.SecurityGroupIds = aws.StringValueSlice(groupIds)
+	}
+
+	d.templateTags = data.TagSpecifications
+}
+
 func (d *Driver) PreCreateCheck() error {
+	if d.UseSSM {
+		if err := checkSSMPrerequisites(); err != nil {
+			return err
+		}
+		if d.IamInstanceProfile == "" {
+			log.Warn("--amazonec2-use-ssm requires an instance profile allowing the SSM agent to register, make sure the launch template provides one")
+		}
+	}
+
 	if err := d.checkSubnet(); err != nil {
 		return err
 	}
 
+	if err := d.checkLaunchTemplate(); err != nil {
+		return err
+	}
+
 	if err := d.checkAMI(); err != nil {
 		return err
 	}
@@ -619,6 +975,37 @@ func (d *Driver) PreCreateCheck() error {
 	return nil
 }
 
+// Plan returns the instance to create, with the subnet and the AMI resolved
+// by PreCreateCheck.
+func (d *Driver) Plan() ([]drivers.PlanItem, error) {
+	items := []drivers.PlanItem{
+		{Name: "Region", Value: d.Region},
+		{Name: "Zone", Value: d.getRegionZone()},
+		{Name: "AMI", Value: d.AMI},
+		{Name: "Instance type", Value: d.InstanceType},
+		{Name: "VPC", Value: d.VpcId},
+		{Name: "Subnet", Value: d.SubnetId},
+	}
+	if groups := d.securityGroupIds(); len(groups) > 0 {
+		items = append(items, drivers.PlanItem{Name: "Security groups", Value: strings.Join(groups, ", ")})
+	} else {
+		items = append(items, drivers.PlanItem{Name: "Security groups", Value: strings.Join(d.securityGroupNames(), ", ")})
+	}
+	for i, networkInterface := range d.NetworkInterfaces {
+		items = append(items, drivers.PlanItem{Name: fmt.Sprintf("Network interface eth%d", i+1), Value: networkInterface.SubnetId})
+	}
+	items = append(items,
+		drivers.PlanItem{Name: "Root volume", Value: fmt.Sprintf("%s, %d GB, %s", d.DeviceName, d.RootSize, d.VolumeType)},
+	)
+	if d.LaunchTemplate != "" {
+		items = append(items, drivers.PlanItem{Name: "Launch template", Value: d.LaunchTemplate})
+	}
+	if d.RequestSpotInstance {
+		items = append(items, drivers.PlanItem{Name: "Spot price", Value: d.SpotPrice})
+	}
+	return items, nil
+}
+
 func (d *Driver) instanceIpAvailable() bool {
 	ip, err := d.GetIP()
 	if err != nil {
@@ -632,6 +1019,75 @@ func (d *Driver) instanceIpAvailable() bool {
 	return false
 }
 
+// buildNetworkInterfaceSpecs returns the primary interface followed by the
+// additional ones, if any.
+func (d *Driver) buildNetworkInterfaceSpecs() []*ec2.InstanceNetworkInterfaceSpecification {
+	primary := &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex: aws.Int64(0), // eth0
+		Groups:      makePointerSlice(d.securityGroupIds()),
+		SubnetId:    &d.SubnetId,
+	}
+	// AWS refuses to associate a public address when launching with
+	// multiple interfaces, even to turn it off.
+	if len(d.NetworkInterfaces) == 0 {
+		primary.AssociatePublicIpAddress = aws.Bool(!d.PrivateIPOnly)
+	}
+	if d.SecondaryPrivateIPCount != 0 {
+		primary.SecondaryPrivateIpAddressCount = aws.Int64(d.SecondaryPrivateIPCount)
+	}
+
+	netSpecs := []*ec2.InstanceNetworkInterfaceSpecification{primary}
+	for i := range d.NetworkInterfaces {
+		networkInterface := &d.NetworkInterfaces[i]
+		spec := &ec2.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:         aws.Int64(int64(i + 1)),
+			SubnetId:            &networkInterface.SubnetId,
+			Groups:              makePointerSlice(networkInterface.SecurityGroupIds),
+			DeleteOnTermination: aws.Bool(true),
+		}
+		if networkInterface.SecondaryPrivateIPCount != 0 {
+			spec.SecondaryPrivateIpAddressCount = aws.Int64(networkInterface.SecondaryPrivateIPCount)
+		}
+		netSpecs = append(netSpecs, spec)
+	}
+
+	return netSpecs
+}
+
+// parseNetworkInterface parses an additional network interface given as comma
+// separated key=value pairs. Security group ids are separated by semicolons.
+func parseNetworkInterface(spec string) (NetworkInterface, error) {
+	networkInterface := NetworkInterface{}
+
+	options, err := mcnutils.ParseOptions("network interface", spec)
+	if err != nil {
+		return networkInterface, err
+	}
+	for _, option := range options {
+		key, value := option.Key, option.Value
+		switch key {
+		case "subnet":
+			networkInterface.SubnetId = value
+		case "security-groups":
+			networkInterface.SecurityGroupIds = strings.Split(value, ";")
+		case "private-ip-count":
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return networkInterface, fmt.Errorf("invalid value for network interface option %q in %q: %s", key, spec, err)
+			}
+			networkInterface.SecondaryPrivateIPCount = count
+		default:
+			return networkInterface, fmt.Errorf("unknown network interface option %q in %q", key, spec)
+		}
+	}
+
+	if networkInterface.SubnetId == "" {
+		return networkInterface, fmt.Errorf("network interface %q requires a subnet", spec)
+	}
+
+	return networkInterface, nil
+}
+
 func makePointerSlice(stackSlice []string) []*string {
 	pointerSlice := []*string{}
 	for i := range stackSlice {
@@ -671,9 +1127,15 @@ func (d *Driver) Base64UserData() (userdata string, err error) {
 }
 
 func (d *Driver) Create() error {
+	return d.CreateContext(context.Background())
+}
+
+// CreateContext creates the instance, aborting the EC2 calls and waits in
+// progress when ctx is done. What was created is then removed.
+func (d *Driver) CreateContext(ctx context.Context) error {
 	// PreCreateCheck has already been called
 
-	if err := d.innerCreate(); err != nil {
+	if err := d.WithContext(ctx, d.innerCreate); err != nil {
 		log.Warnf("error encountered during instance creation: %s", err.Error())
 		// cleanup partially created resources
 		if removalErr := d.Remove(); removalErr != nil {
@@ -696,6 +1158,10 @@ func (d *Driver) innerCreate() error {
 		return err
 	}
 
+	if err := d.configurePlacementGroup(); err != nil {
+		return err
+	}
+
 	var userdata string
 	if b64, err := d.Base64UserData(); err != nil {
 		return err
@@ -705,161 +1171,99 @@ func (d *Driver) innerCreate() error {
 
 	bdmList := d.updateBDMList()
 
-	netSpecs := []*ec2.InstanceNetworkInterfaceSpecification{{
-		DeviceIndex:              aws.Int64(0), // eth0
-		Groups:                   makePointerSlice(d.securityGroupIds()),
-		SubnetId:                 &d.SubnetId,
-		AssociatePublicIpAddress: aws.Bool(!d.PrivateIPOnly),
-	}}
+	netSpecs := d.buildNetworkInterfaceSpecs()
 
 	regionZone := d.getRegionZone()
 	log.Debugf("launching instance in subnet %s", d.SubnetId)
 
-	var instance *ec2.Instance
-	if d.RequestSpotInstance {
-		req := ec2.RunInstancesInput{
-			ImageId:  &d.AMI,
-			MinCount: aws.Int64(1),
-			MaxCount: aws.Int64(1),
-			Placement: &ec2.Placement{
-				AvailabilityZone: &regionZone,
-			},
-			KeyName:           &d.KeyName,
-			InstanceType:      &d.InstanceType,
-			NetworkInterfaces: netSpecs,
-			Monitoring:        &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(d.Monitoring)},
-			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-				Name: &d.IamInstanceProfile,
-			},
-			EbsOptimized:        &d.UseEbsOptimizedInstance,
-			BlockDeviceMappings: bdmList,
-			UserData:            &userdata,
-			MetadataOptions:     &ec2.InstanceMetadataOptionsRequest{},
-			InstanceMarketOptions: &ec2.InstanceMarketOptionsRequest{
-				MarketType: aws.String(ec2.MarketTypeSpot),
-				SpotOptions: &ec2.SpotMarketOptions{
-					MaxPrice:         &d.SpotPrice,
-					SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
-				},
-			},
-		}
+	req := ec2.RunInstancesInput{
+		ImageId:  &d.AMI,
+		MinCount: aws.Int64(1),
+		MaxCount: aws.Int64(1),
+		Placement: &ec2.Placement{
+			AvailabilityZone: &regionZone,
+		},
+		KeyName:           &d.KeyName,
+		InstanceType:      &d.InstanceType,
+		NetworkInterfaces: netSpecs,
+		Monitoring:        &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(d.Monitoring)},
+		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
+			Name: &d.IamInstanceProfile,
+		},
+		EbsOptimized:        &d.UseEbsOptimizedInstance,
+		BlockDeviceMappings: bdmList,
+		UserData:            &userdata,
+		MetadataOptions:     &ec2.InstanceMetadataOptionsRequest{},
+	}
 
-		if d.HttpEndpoint != "" {
-			req.MetadataOptions.HttpEndpoint = aws.String(d.HttpEndpoint)
-		}
+	if d.HttpEndpoint != "" {
+		req.MetadataOptions.HttpEndpoint = aws.String(d.HttpEndpoint)
+	}
 
-		if d.HttpTokens != "" {
-			req.MetadataOptions.HttpTokens = aws.String(d.HttpTokens)
-		}
+	if d.HttpTokens != "" {
+		req.MetadataOptions.HttpTokens = aws.String(d.HttpTokens)
+	}
 
-		if d.BlockDurationMinutes != 0 {
-			req.InstanceMarketOptions.SpotOptions.BlockDurationMinutes = &d.BlockDurationMinutes
-		}
-		res, err := d.getClient().RunInstances(&req)
-		if err != nil {
-			return fmt.Errorf("Error request spot instance: %s", err)
-		}
-		d.spotInstanceRequestId = *res.Instances[0].SpotInstanceRequestId
+	if d.HttpPutResponseHopLimit != 0 {
+		req.MetadataOptions.HttpPutResponseHopLimit = aws.Int64(d.HttpPutResponseHopLimit)
+	}
 
-		log.Info("Waiting for spot instance...")
-		for i := 0; i < 3; i++ {
-			// AWS eventual consistency means we could not have SpotInstanceRequest ready yet
-			err = d.getClient().WaitUntilSpotInstanceRequestFulfilled(&ec2.DescribeSpotInstanceRequestsInput{
-				SpotInstanceRequestIds: []*string{&d.spotInstanceRequestId},
-			})
-			if err != nil {
-				if awsErr, ok := err.(awserr.Error); ok {
-					if awsErr.Code() == spotInstanceRequestNotFoundCode {
-						time.Sleep(5 * time.Second)
-						continue
-					}
-				}
-				return fmt.Errorf("Error fulfilling spot request: %v", err)
-			}
-			break
-		}
-		log.Infof("Created spot instance request %v", d.spotInstanceRequestId)
-		// resolve instance id
-		for i := 0; i < 3; i++ {
-			// Even though the waiter succeeded, eventual consistency means we could
-			// get a describe output that does not include this information. Try a
-			// few times just in case
-			var resolvedSpotInstance *ec2.DescribeSpotInstanceRequestsOutput
-			resolvedSpotInstance, err = d.getClient().DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
-				SpotInstanceRequestIds: []*string{&d.spotInstanceRequestId},
-			})
-			if err != nil {
-				// Unexpected; no need to retry
-				return fmt.Errorf("Error describing previously made spot instance request: %v", err)
-			}
-			maybeInstanceId := resolvedSpotInstance.SpotInstanceRequests[0].InstanceId
-			if maybeInstanceId != nil {
-				var instances *ec2.DescribeInstancesOutput
-				instances, err = d.getClient().DescribeInstances(&ec2.DescribeInstancesInput{
-					InstanceIds: []*string{maybeInstanceId},
-				})
-				if err != nil {
-					// Retry if we get an id from spot instance but EC2 doesn't recognize it yet; see above, eventual consistency possible
-					continue
-				}
-				instance = instances.Reservations[0].Instances[0]
-				err = nil
-				break
-			}
-			time.Sleep(5 * time.Second)
-		}
+	if d.PlacementGroup != "" {
+		req.Placement.GroupName = aws.String(d.PlacementGroup)
+	}
 
-		if err != nil {
-			return fmt.Errorf("Error resolving spot instance to real instance: %v", err)
+	if d.PlacementPartition != 0 {
+		req.Placement.PartitionNumber = aws.Int64(d.PlacementPartition)
+	}
+
+	if d.Tenancy != "" {
+		req.Placement.Tenancy = aws.String(d.Tenancy)
+	}
+
+	if d.HostId != "" {
+		req.Placement.HostId = aws.String(d.HostId)
+	}
+
+	if d.LaunchTemplate != "" {
+		// Anything not set explicitly is inherited from the template.
+		req.LaunchTemplate = d.launchTemplateSpecification()
+		if d.IamInstanceProfile == "" {
+			req.IamInstanceProfile = nil
 		}
-	} else {
-		log.Debug("Building tags for instance creation")
-		resourceTags := d.buildResourceTags([]string{
-			ec2InstanceResource, // required
-			ec2VolumeResource,   // EBS volume
-			ec2NetworkInterfaceResource,
-		})
-		req := ec2.RunInstancesInput{
-			ImageId:  &d.AMI,
-			MinCount: aws.Int64(1),
-			MaxCount: aws.Int64(1),
-			Placement: &ec2.Placement{
-				AvailabilityZone: &regionZone,
-			},
-			KeyName:           &d.KeyName,
-			InstanceType:      &d.InstanceType,
-			NetworkInterfaces: netSpecs,
-			Monitoring:        &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(d.Monitoring)},
-			IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
-				Name: &d.IamInstanceProfile,
-			},
-			EbsOptimized:        &d.UseEbsOptimizedInstance,
-			BlockDeviceMappings: bdmList,
-			UserData:            &userdata,
-			MetadataOptions:     &ec2.InstanceMetadataOptionsRequest{},
-			TagSpecifications:   resourceTags,
+		if userdata == "" {
+			req.UserData = nil
 		}
+	}
 
-		if d.HttpEndpoint != "" {
-			req.MetadataOptions.HttpEndpoint = aws.String(d.HttpEndpoint)
-		}
+	var instance *ec2.Instance
+	if d.RequestSpotInstance {
+		var err error
+		instance, err = d.runSpotInstance(req)
+		if err != nil {
+			if !d.SpotFallbackOnDemand {
+				return err
+			}
 
-		if d.HttpTokens != "" {
-			req.MetadataOptions.HttpTokens = aws.String(d.HttpTokens)
+			log.Warnf("Unable to obtain a spot instance, falling back to an on-demand instance: %s", err)
+			if err := d.abandonSpotInstanceRequest(); err != nil {
+				return err
+			}
+			d.OnDemandFallback = true
 		}
+	}
 
-		res, err := d.getClient().RunInstances(&req)
-
+	if !d.isSpotInstance() {
+		var err error
+		instance, err = d.runOnDemandInstance(req)
 		if err != nil {
-			return fmt.Errorf("Error launching instance: %s", err)
+			return err
 		}
-		instance = res.Instances[0]
 	}
 
 	d.InstanceId = *instance.InstanceId
 
 	log.Debug("waiting for ip address to become available")
-	if err := mcnutils.WaitFor(d.instanceIpAvailable); err != nil {
+	if err := mcnutils.WaitForContext(d.Context(), d.instanceIpAvailable); err != nil {
 		return err
 	}
 
@@ -871,7 +1275,7 @@ func (d *Driver) innerCreate() error {
 		return err
 	}
 
-	if d.RequestSpotInstance {
+	if d.isSpotInstance() {
 		// tags for spot instances should be added
 		// after the instance has been created and
 		// transitioned into a 'running' state. The spot-instance
@@ -892,10 +1296,131 @@ func (d *Driver) innerCreate() error {
 	return nil
 }
 
+// isSpotInstance returns whether the instance is a spot instance, i.e. one was
+// requested and the creation did not fall back to an on-demand instance.
+func (d *Driver) isSpotInstance() bool {
+	return d.RequestSpotInstance && !d.OnDemandFallback
+}
+
+// runOnDemandInstance launches a regular on-demand instance. Tags are supplied
+// within the request so that they are applied at creation time.
+func (d *Driver) runOnDemandInstance(req ec2.RunInstancesInput) (*ec2.Instance, error) {
+	log.Debug("Building tags for instance creation")
+	req.TagSpecifications = mergeTemplateTags(d.buildResourceTags([]string{
+		ec2InstanceResource, // required
+		ec2VolumeResource,   // EBS volume
+		ec2NetworkInterfaceResource,
+	}), d.templateTags)
+
+	res, err := d.getClient().RunInstances(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Error launching instance: %s", err)
+	}
+
+	return res.Instances[0], nil
+}
+
+// runSpotInstance launches a one-time spot instance capped at SpotPrice and
+// waits for the spot instance request to be fulfilled.
+func (d *Driver) runSpotInstance(req ec2.RunInstancesInput) (*ec2.Instance, error) {
+	req.InstanceMarketOptions = &ec2.InstanceMarketOptionsRequest{
+		MarketType: aws.String(ec2.MarketTypeSpot),
+		SpotOptions: &ec2.SpotMarketOptions{
+			MaxPrice:         &d.SpotPrice,
+			SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
+		},
+	}
+
+	if d.BlockDurationMinutes != 0 {
+		req.InstanceMarketOptions.SpotOptions.BlockDurationMinutes = &d.BlockDurationMinutes
+	}
+	res, err := d.getClient().RunInstances(&req)
+	if err != nil {
+		return nil, fmt.Errorf("Error request spot instance: %s", err)
+	}
+	d.SpotInstanceRequestId = *res.Instances[0].SpotInstanceRequestId
+
+	log.Info("Waiting for spot instance...")
+	for i := 0; i < 3; i++ {
+		// AWS eventual consistency means we could not have SpotInstanceRequest ready yet
+		err = d.getClient().WaitUntilSpotInstanceRequestFulfilled(&ec2.DescribeSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: []*string{&d.SpotInstanceRequestId},
+		})
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok {
+				if awsErr.Code() == spotInstanceRequestNotFoundCode {
+					time.Sleep(5 * time.Second)
+					continue
+				}
+			}
+			return nil, fmt.Errorf("Error fulfilling spot request: %v", err)
+		}
+		break
+	}
+	log.Infof("Created spot instance request %v", d.SpotInstanceRequestId)
+	// resolve instance id
+	for i := 0; i < 3; i++ {
+		// Even though the waiter succeeded, eventual consistency means we could
+		// get a describe output that does not include this information. Try a
+		// few times just in case
+		var resolvedSpotInstance *ec2.DescribeSpotInstanceRequestsOutput
+		resolvedSpotInstance, err = d.getClient().DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: []*string{&d.SpotInstanceRequestId},
+		})
+		if err != nil {
+			// Unexpected; no need to retry
+			return nil, fmt.Errorf("Error describing previously made spot instance request: %v", err)
+		}
+		maybeInstanceId := resolvedSpotInstance.SpotInstanceRequests[0].InstanceId
+		if maybeInstanceId != nil {
+			var instances *ec2.DescribeInstancesOutput
+			instances, err = d.getClient().DescribeInstances(&ec2.DescribeInstancesInput{
+				InstanceIds: []*string{maybeInstanceId},
+			})
+			if err != nil {
+				// Retry if we get an id from spot instance but EC2 doesn't recognize it yet; see above, eventual consistency possible
+				continue
+			}
+			return instances.Reservations[0].Instances[0], nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return nil, fmt.Errorf("Error resolving spot instance to real instance: %v", err)
+}
+
+// abandonSpotInstanceRequest cancels the spot instance request made by
+// runSpotInstance and terminates any instance which has been launched for it
+// in the meantime, so that falling back to on-demand never leaves a stray
+// spot instance behind.
+func (d *Driver) abandonSpotInstanceRequest() error {
+	if d.SpotInstanceRequestId == "" {
+		return nil
+	}
+
+	if err := d.cancelSpotInstanceRequest(); err != nil {
+		return fmt.Errorf("Error cancelling spot instance request %s: %v", d.SpotInstanceRequestId, err)
+	}
+
+	res, err := d.getClient().DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{&d.SpotInstanceRequestId},
+	})
+	if err == nil && len(res.SpotInstanceRequests) > 0 && res.SpotInstanceRequests[0].InstanceId != nil {
+		d.InstanceId = *res.SpotInstanceRequests[0].InstanceId
+		if err := d.terminate(); err != nil {
+			return err
+		}
+		d.InstanceId = ""
+	}
+
+	d.SpotInstanceRequestId = ""
+	return nil
+}
+
 // configureTags will add tags to the instance after
 // it has been created and transitioned into 'running'.
 func (d *Driver) configureTags(instance *ec2.Instance) error {
-	tags := append(buildEC2Tags(d.Tags), &ec2.Tag{
+	tags := append(d.buildTags(), &ec2.Tag{
 		Key:   aws.String("Name"),
 		Value: &d.MachineName,
 	})
@@ -921,7 +1446,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
-	ip, err := d.GetIP()
+	ip, err := d.getDockerIP()
 	if err != nil {
 		return "", err
 	}
@@ -929,7 +1454,32 @@ func (d *Driver) GetURL() (string, error) {
 		return "", nil
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, fmt.Sprintf("%d", dockerPort))), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// getDockerIP returns the address the Docker URL points to, which is the
+// private address of the selected interface when --amazonec2-docker-interface
+// is used.
+func (d *Driver) getDockerIP() (string, error) {
+	if d.DockerInterfaceIndex == 0 {
+		return d.GetIP()
+	}
+
+	inst, err := d.getInstance()
+	if err != nil {
+		return "", err
+	}
+
+	for _, networkInterface := range inst.NetworkInterfaces {
+		if networkInterface.Attachment != nil && aws.Int64Value(networkInterface.Attachment.DeviceIndex) == d.DockerInterfaceIndex {
+			if networkInterface.PrivateIpAddress == nil {
+				return "", fmt.Errorf("No private IP for network interface %d of instance %v", d.DockerInterfaceIndex, d.InstanceId)
+			}
+			return *networkInterface.PrivateIpAddress, nil
+		}
+	}
+
+	return "", fmt.Errorf("network interface %d not found on instance %v", d.DockerInterfaceIndex, d.InstanceId)
 }
 
 func (d *Driver) GetIP() (string, error) {
@@ -958,11 +1508,35 @@ func (d *Driver) GetIP() (string, error) {
 	return *inst.PublicIpAddress, nil
 }
 
+// GetStateContext returns the state of the instance, aborting the EC2 calls
+// in progress when ctx is done.
+func (d *Driver) GetStateContext(ctx context.Context) (s state.State, err error) {
+	err = d.WithContext(ctx, func() error {
+		s, err = d.GetState()
+		return err
+	})
+	return s, err
+}
+
 func (d *Driver) GetState() (state.State, error) {
+	if d.InstanceId == "" && d.SpotInstanceRequestId != "" {
+		return d.getSpotInstanceRequestState()
+	}
+
 	inst, err := d.getInstance()
 	if err != nil {
 		return state.Error, err
 	}
+
+	if inst.StateReason != nil && inst.StateReason.Code != nil {
+		switch *inst.StateReason.Code {
+		case spotInstanceTerminationCode:
+			return state.Error, fmt.Errorf("spot instance %v was interrupted and terminated by AWS", d.InstanceId)
+		case spotInstanceShutdownCode:
+			log.Warnf("spot instance %v was interrupted and stopped by AWS", d.InstanceId)
+		}
+	}
+
 	switch *inst.State.Name {
 	case ec2.InstanceStateNamePending:
 		return state.Starting, nil
@@ -982,11 +1556,64 @@ func (d *Driver) GetState() (state.State, error) {
 	}
 }
 
+// getSpotInstanceRequestState maps the state of a spot instance request which
+// has not been resolved to an instance yet.
+func (d *Driver) getSpotInstanceRequestState() (state.State, error) {
+	res, err := d.getClient().DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{&d.SpotInstanceRequestId},
+	})
+	if err != nil {
+		return state.Error, err
+	}
+	if len(res.SpotInstanceRequests) == 0 {
+		return state.Error, fmt.Errorf("spot instance request %v not found", d.SpotInstanceRequestId)
+	}
+
+	request := res.SpotInstanceRequests[0]
+	switch aws.StringValue(request.State) {
+	case ec2.SpotInstanceStateOpen:
+		return state.Starting, nil
+	case ec2.SpotInstanceStateActive:
+		if request.InstanceId == nil {
+			return state.Starting, nil
+		}
+		d.InstanceId = *request.InstanceId
+		return d.GetState()
+	default:
+		msg := aws.StringValue(request.State)
+		if request.Status != nil && request.Status.Message != nil {
+			msg = *request.Status.Message
+		}
+		return state.Error, fmt.Errorf("spot instance request %v was not fulfilled: %s", d.SpotInstanceRequestId, msg)
+	}
+}
+
 func (d *Driver) GetSSHHostname() (string, error) {
+	if d.UseSSM {
+		return ssmLocalHost, nil
+	}
+
 	// TODO: use @nathanleclaire retry func here (ehazlett)
 	return d.GetIP()
 }
 
+// IsEnginePrivate tells whether the Docker daemon is reachable from the VPC
+// only. The SSM session forwards SSH, the Docker URL remaining the private
+// address of the instance.
+func (d *Driver) IsEnginePrivate() bool {
+	return d.UseSSM
+}
+
+// GetSSHPort returns the local end of the SSM tunnel when connecting through
+// SSM, the instance's SSH port otherwise.
+func (d *Driver) GetSSHPort() (int, error) {
+	if d.UseSSM {
+		return d.ssmTunnelPort()
+	}
+
+	return d.BaseDriver.GetSSHPort()
+}
+
 func (d *Driver) GetSSHUsername() string {
 	if d.SSHUser == "" {
 		d.SSHUser = defaultSSHUser
@@ -995,6 +1622,18 @@ func (d *Driver) GetSSHUsername() string {
 	return d.SSHUser
 }
 
+// StartContext starts the instance and waits for it to run, aborting the EC2
+// calls and waits in progress when ctx is done.
+func (d *Driver) StartContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Start)
+}
+
+// StopContext stops the instance, aborting the EC2 calls in progress when ctx
+// is done.
+func (d *Driver) StopContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Stop)
+}
+
 func (d *Driver) Start() error {
 	_, err := d.getClient().StartInstances(&ec2.StartInstancesInput{
 		InstanceIds: []*string{&d.InstanceId},
@@ -1007,6 +1646,7 @@ func (d *Driver) Start() error {
 }
 
 func (d *Driver) Stop() error {
+	d.closeSSMTunnel()
 	_, err := d.getClient().StopInstances(&ec2.StopInstancesInput{
 		InstanceIds: []*string{&d.InstanceId},
 		Force:       aws.Bool(false),
@@ -1029,7 +1669,14 @@ func (d *Driver) Kill() error {
 	return err
 }
 
+// RemoveContext removes the instance, aborting the EC2 calls in progress when
+// ctx is done.
+func (d *Driver) RemoveContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Remove)
+}
+
 func (d *Driver) Remove() error {
+	d.closeSSMTunnel()
 	multierr := mcnutils.MultiError{
 		Errs: []error{},
 	}
@@ -1040,7 +1687,7 @@ func (d *Driver) Remove() error {
 
 	// In case of failure waiting for a SpotInstance, we must cancel the unfulfilled request, otherwise an instance may be created later.
 	// If the instance was created, terminating it will be enough for canceling the SpotInstanceRequest
-	if d.RequestSpotInstance && d.spotInstanceRequestId != "" {
+	if d.SpotInstanceRequestId != "" {
 		if err := d.cancelSpotInstanceRequest(); err != nil {
 			multierr.Errs = append(multierr.Errs, err)
 		}
@@ -1062,12 +1709,19 @@ func (d *Driver) Remove() error {
 func (d *Driver) cancelSpotInstanceRequest() error {
 	// NB: Canceling a Spot instance request does not terminate running Spot instances associated with the request
 	_, err := d.getClient().CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
-		SpotInstanceRequestIds: []*string{&d.spotInstanceRequestId},
+		SpotInstanceRequestIds: []*string{&d.SpotInstanceRequestId},
 	})
 
 	return err
 }
 
+func (d *Driver) closeSSMTunnel() {
+	if d.ssmTunnel != nil {
+		d.ssmTunnel.close()
+		d.ssmTunnel = nil
+	}
+}
+
 func (d *Driver) getInstance() (*ec2.Instance, error) {
 	instances, err := d.getClient().DescribeInstances(&ec2.DescribeInstancesInput{
 		InstanceIds: []*string{&d.InstanceId},
@@ -1093,7 +1747,7 @@ func (d *Driver) instanceIsRunning() bool {
 }
 
 func (d *Driver) waitForInstance() error {
-	if err := mcnutils.WaitFor(d.instanceIsRunning); err != nil {
+	if err := mcnutils.WaitForContext(d.Context(), d.instanceIsRunning); err != nil {
 		return err
 	}
 
@@ -1199,7 +1853,7 @@ func (d *Driver) securityGroupAvailableFunc(id string) func() bool {
 //
 // NB: The ec2InstanceResource must be passed for the EC2 instance to have a name.
 func (d *Driver) buildResourceTags(resources []string) []*ec2.TagSpecification {
-	tags := buildEC2Tags(d.Tags)
+	tags := d.buildTags()
 	if len(tags) == 0 {
 		resource := ec2InstanceResource
 		return []*ec2.TagSpecification{{
@@ -1229,6 +1883,33 @@ func (d *Driver) buildResourceTags(resources []string) []*ec2.TagSpecification {
 	return tagSpecs
 }
 
+// mergeTemplateTags adds the launch template tags to the tags of the request.
+// Tags supplied in the request replace the template's on a per-resource basis,
+// so the template tags have to be carried over explicitly. Machine tags win
+// when both define the same key.
+func mergeTemplateTags(tagSpecs []*ec2.TagSpecification, templateTags []*ec2.LaunchTemplateTagSpecification) []*ec2.TagSpecification {
+	for _, templateSpec := range templateTags {
+		var spec *ec2.TagSpecification
+		for _, s := range tagSpecs {
+			if aws.StringValue(s.ResourceType) == aws.StringValue(templateSpec.ResourceType) {
+				spec = s
+				break
+			}
+		}
+		if spec == nil {
+			spec = &ec2.TagSpecification{ResourceType: templateSpec.ResourceType}
+			tagSpecs = append(tagSpecs, spec)
+		}
+
+		for _, tag := range templateSpec.Tags {
+			if !hasTagKey(spec.Tags, *tag.Key) {
+				spec.Tags = append(spec.Tags, tag)
+			}
+		}
+	}
+	return tagSpecs
+}
+
 func (d *Driver) configureSecurityGroups(groupNames []string) error {
 	if len(groupNames) == 0 {
 		log.Debugf("no security groups to configure in %s", d.VpcId)
@@ -1331,7 +2012,7 @@ func (d *Driver) configureSecurityGroups(groupNames []string) error {
 
 			// wait until created (dat eventual consistency)
 			log.Debugf("waiting for group (%s) to become available", *group.GroupId)
-			if err := mcnutils.WaitFor(d.securityGroupAvailableFunc(*group.GroupId)); err != nil {
+			if err := mcnutils.WaitForContext(d.Context(), d.securityGroupAvailableFunc(*group.GroupId)); err != nil {
 				return err
 			}
 		}
@@ -1374,7 +2055,8 @@ func (d *Driver) configureSecurityGroupPermissions(group *ec2.SecurityGroup) ([]
 
 	// we are only adding ports when the group is rancher-nodes
 	if *group.GroupName == defaultSecurityGroup && hasTagKey(group.Tags, machineSecurityGroupName) {
-		if _, ok := hasPortsInbound["22/tcp"]; !ok {
+		// SSH goes through SSM and doesn't need to be reachable
+		if _, ok := hasPortsInbound["22/tcp"]; !ok && !d.UseSSM {
 			inboundPerms = append(inboundPerms, &ec2.IpPermission{
 				IpProtocol: aws.String("tcp"),
 				FromPort:   aws.Int64(sshPort),
@@ -1383,6 +2065,7 @@ func (d *Driver) configureSecurityGroupPermissions(group *ec2.SecurityGroup) ([]
 			})
 		}
 
+		dockerPort := int64(d.GetEnginePort())
 		if _, ok := hasPortsInbound[fmt.Sprintf("%d/tcp", dockerPort)]; !ok {
 			inboundPerms = append(inboundPerms, &ec2.IpPermission{
 				IpProtocol: aws.String("tcp"),
@@ -1652,6 +2335,33 @@ func (d *Driver) getRegionZone() string {
 
 // buildEC2Tags accepts a string of tagGroups (in the format of 'key1,value1,key2,value2')
 // and returns a slice of ec2.Tag's which can be applied to various ec2 resources.
+// SetLabels sets the labels of the machine, which tag its resources.
+func (d *Driver) SetLabels(labels map[string]string) error {
+	d.Labels = labels
+	return nil
+}
+
+// buildTags returns the tags of the resources of the machine: those of
+// --amazonec2-tags, then the labels of the machine not overridden by them.
+func (d *Driver) buildTags() []*ec2.Tag {
+	tags := buildEC2Tags(d.Tags)
+
+	keys := []string{}
+	for key := range d.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !hasTagKey(tags, key) {
+			tags = append(tags, &ec2.Tag{
+				Key:   aws.String(key),
+				Value: aws.String(d.Labels[key]),
+			})
+		}
+	}
+	return tags
+}
+
 func buildEC2Tags(tagGroups string) []*ec2.Tag {
 	if tagGroups == "" {
 		return []*ec2.Tag{}
@@ -1704,6 +2414,12 @@ func (d *Driver) updateBDMList() []*ec2.BlockDeviceMapping {
 			if *bdm.DeviceName == d.DeviceName {
 				bdm.Ebs.VolumeSize = aws.Int64(d.RootSize)
 				bdm.Ebs.VolumeType = aws.String(d.VolumeType)
+				if d.VolumeIops != 0 {
+					bdm.Ebs.Iops = aws.Int64(d.VolumeIops)
+				}
+				if d.VolumeThroughput != 0 {
+					bdm.Ebs.Throughput = aws.Int64(d.VolumeThroughput)
+				}
 			}
 			bdm.Ebs.DeleteOnTermination = aws.Bool(true)
 			bdm.Ebs.KmsKeyId = d.kmsKeyId
@@ -1712,5 +2428,86 @@ func (d *Driver) updateBDMList() []*ec2.BlockDeviceMapping {
 		}
 	}
 
+	for _, volume := range d.DataVolumes {
+		ebs := &ec2.EbsBlockDevice{
+			DeleteOnTermination: aws.Bool(true),
+			VolumeSize:          aws.Int64(volume.Size),
+			VolumeType:          aws.String(volume.Type),
+			Encrypted:           aws.Bool(volume.Encrypted),
+		}
+		if volume.Encrypted {
+			ebs.KmsKeyId = d.kmsKeyId
+		}
+		if volume.Iops != 0 {
+			ebs.Iops = aws.Int64(volume.Iops)
+		}
+		if volume.Throughput != 0 {
+			ebs.Throughput = aws.Int64(volume.Throughput)
+		}
+		bdmList = append(bdmList, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(volume.DeviceName),
+			Ebs:        ebs,
+		})
+	}
+
 	return bdmList
 }
+
+// validateVolumeOptions checks that IOPS and throughput are only requested for
+// volume types which support provisioning them.
+func validateVolumeOptions(volumeType string, iops, throughput int64) error {
+	if iops != 0 {
+		switch volumeType {
+		case ec2.VolumeTypeIo1, ec2.VolumeTypeIo2, ec2.VolumeTypeGp3:
+		default:
+			return errorIopsNotSupported
+		}
+	}
+
+	if throughput != 0 && volumeType != ec2.VolumeTypeGp3 {
+		return errorThroughputNotSupported
+	}
+
+	return nil
+}
+
+// parseDataVolume parses a data volume spec given as comma separated
+// key=value pairs, e.g. 'device=/dev/sdf,size=100,type=gp3,encrypted=true'.
+// The device name and size are mandatory, the type defaults to gp3.
+func parseDataVolume(spec string) (DataVolume, error) {
+	volume := DataVolume{Type: ec2.VolumeTypeGp3}
+
+	options, err := mcnutils.ParseOptions("data volume", spec)
+	if err != nil {
+		return volume, err
+	}
+	for _, option := range options {
+		key, value := option.Key, option.Value
+		var err error
+		switch key {
+		case "device":
+			volume.DeviceName = value
+		case "size":
+			volume.Size, err = strconv.ParseInt(value, 10, 64)
+		case "type":
+			volume.Type = value
+		case "iops":
+			volume.Iops, err = strconv.ParseInt(value, 10, 64)
+		case "throughput":
+			volume.Throughput, err = strconv.ParseInt(value, 10, 64)
+		case "encrypted":
+			volume.Encrypted, err = strconv.ParseBool(value)
+		default:
+			return volume, fmt.Errorf("unknown data volume option %q in %q", key, spec)
+		}
+		if err != nil {
+			return volume, fmt.Errorf("invalid value for data volume option %q in %q: %s", key, spec, err)
+		}
+	}
+
+	if volume.DeviceName == "" || volume.Size <= 0 {
+		return volume, fmt.Errorf("data volume %q requires a device and a positive size", spec)
+	}
+
+	return volume, validateVolumeOptions(volume.Type, volume.Iops, volume.Throughput)
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/rancher/machine/commands/commandstest"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
 	"github.com/rancher/machine/version"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -75,6 +77,27 @@ func TestRancherSecurityGroupPermissions(t *testing.T) {
 	assert.Equal(t, testSSHPort, *perms[0].FromPort)
 }
 
+func TestRancherSecurityGroupPermissionsWithSSM(t *testing.T) {
+	driver := NewTestDriver()
+	driver.UseSSM = true
+
+	perms, err := driver.configureSecurityGroupPermissions(rancherSecurityGroup)
+
+	assert.Nil(t, err)
+	assert.Len(t, perms, 16)
+	for _, perm := range perms {
+		assert.NotEqual(t, testSSHPort, *perm.FromPort)
+	}
+}
+
+func TestIsEnginePrivateWithSSM(t *testing.T) {
+	driver := NewTestDriver()
+	assert.False(t, drivers.IsEnginePrivate(driver))
+
+	driver.UseSSM = true
+	assert.True(t, drivers.IsEnginePrivate(driver))
+}
+
 func TestConfigureSecurityGroupPermissionsDockerAndSsh(t *testing.T) {
 	driver := NewTestDriver()
 	group := securityGroup
@@ -183,6 +206,24 @@ func TestGetRegionZoneForDefaultEndpoint(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPlan(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.Region = "us-east-1"
+	driver.Zone = "e"
+	driver.AMI = "ami-12345"
+	driver.InstanceType = "t3.large"
+	driver.VpcId = "vpc-1"
+	driver.SubnetId = "subnet-1"
+	driver.SecurityGroupIds = []string{"sg-1", "sg-2"}
+
+	items, err := driver.Plan()
+
+	assert.NoError(t, err)
+	assert.Contains(t, items, drivers.PlanItem{Name: "Zone", Value: "us-east-1e"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Subnet", Value: "subnet-1"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Security groups", Value: "sg-1, sg-2"})
+}
+
 func TestGetRegionZoneForCustomEndpoint(t *testing.T) {
 	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
 	driver.awsCredentialsFactory = NewValidAwsCredentials
@@ -266,6 +307,56 @@ func TestEndpointIsMandatoryWhenSSLDisabled(t *testing.T) {
 	assert.Equal(t, err, errorDisableSSLWithoutCustomEndpoint)
 }
 
+func TestInvalidMetadataOptions(t *testing.T) {
+	tests := []struct {
+		flag  string
+		value interface{}
+		err   error
+	}{
+		{"amazonec2-http-tokens", "sometimes", errorInvalidValueForHTTPToken},
+		{"amazonec2-http-endpoint", "on", errorInvalidValueForHTTPEndpoint},
+		{"amazonec2-http-put-response-hop-limit", 65, errorInvalidValueForHTTPHopLimit},
+		{"amazonec2-http-put-response-hop-limit", -1, errorInvalidValueForHTTPHopLimit},
+	}
+
+	for _, test := range tests {
+		driver := NewTestDriver()
+		driver.awsCredentialsFactory = NewValidAwsCredentials
+		options := &commandstest.FakeFlagger{
+			Data: map[string]interface{}{
+				"name":             "test",
+				"amazonec2-region": "us-east-1",
+				test.flag:          test.value,
+			},
+		}
+
+		err := driver.SetConfigFromFlags(options)
+
+		assert.Equal(t, test.err, err, test.flag)
+	}
+}
+
+func TestMetadataOptionsRequireIMDSv2(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":                                  "test",
+			"amazonec2-region":                      "us-east-1",
+			"amazonec2-http-tokens":                 "required",
+			"amazonec2-http-endpoint":               "enabled",
+			"amazonec2-http-put-response-hop-limit": 2,
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "required", driver.HttpTokens)
+	assert.Equal(t, "enabled", driver.HttpEndpoint)
+	assert.Equal(t, int64(2), driver.HttpPutResponseHopLimit)
+}
+
 var values = []string{
 	"bob",
 	"jake",
@@ -495,3 +586,428 @@ func TestInvalidAMI(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestGetStatePendingSpotRequest(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithSpotRequest{
+		request: &ec2.SpotInstanceRequest{State: aws.String(ec2.SpotInstanceStateOpen)},
+	})
+	driver.SpotInstanceRequestId = "sir-1234"
+
+	st, err := driver.GetState()
+
+	assert.NoError(t, err)
+	assert.Equal(t, state.Starting, st)
+}
+
+func TestGetStateFulfilledSpotRequest(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithSpotRequest{
+		request: &ec2.SpotInstanceRequest{
+			State:      aws.String(ec2.SpotInstanceStateActive),
+			InstanceId: aws.String("i-1234"),
+		},
+		instance: &ec2.Instance{
+			InstanceId: aws.String("i-1234"),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+	})
+	driver.SpotInstanceRequestId = "sir-1234"
+
+	st, err := driver.GetState()
+
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, st)
+	assert.Equal(t, "i-1234", driver.InstanceId)
+}
+
+func TestGetStateCancelledSpotRequest(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithSpotRequest{
+		request: &ec2.SpotInstanceRequest{
+			State:  aws.String(ec2.SpotInstanceStateCancelled),
+			Status: &ec2.SpotInstanceStatus{Message: aws.String("price too low")},
+		},
+	})
+	driver.SpotInstanceRequestId = "sir-1234"
+
+	st, err := driver.GetState()
+
+	assert.EqualError(t, err, "spot instance request sir-1234 was not fulfilled: price too low")
+	assert.Equal(t, state.Error, st)
+}
+
+func TestGetStateInterruptedSpotInstance(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithSpotRequest{
+		instance: &ec2.Instance{
+			InstanceId:  aws.String("i-1234"),
+			State:       &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)},
+			StateReason: &ec2.StateReason{Code: aws.String(spotInstanceTerminationCode)},
+		},
+	})
+	driver.InstanceId = "i-1234"
+
+	st, err := driver.GetState()
+
+	assert.EqualError(t, err, "spot instance i-1234 was interrupted and terminated by AWS")
+	assert.Equal(t, state.Error, st)
+}
+
+func TestValidateVolumeOptions(t *testing.T) {
+	assert.NoError(t, validateVolumeOptions("gp3", 4000, 250))
+	assert.NoError(t, validateVolumeOptions("io2", 4000, 0))
+	assert.NoError(t, validateVolumeOptions("gp2", 0, 0))
+	assert.Equal(t, errorIopsNotSupported, validateVolumeOptions("gp2", 4000, 0))
+	assert.Equal(t, errorThroughputNotSupported, validateVolumeOptions("io1", 0, 250))
+}
+
+func TestParseDataVolume(t *testing.T) {
+	volume, err := parseDataVolume("device=/dev/sdf,size=100,iops=3000,throughput=125,encrypted=true")
+
+	assert.NoError(t, err)
+	assert.Equal(t, DataVolume{
+		DeviceName: "/dev/sdf",
+		Size:       100,
+		Type:       "gp3",
+		Iops:       3000,
+		Throughput: 125,
+		Encrypted:  true,
+	}, volume)
+}
+
+func TestParseDataVolumeInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"size=100",
+		"device=/dev/sdf",
+		"device=/dev/sdf,size=abc",
+		"device=/dev/sdf,size=100,color=blue",
+		"device=/dev/sdf,size=100,type=gp2,throughput=125",
+	} {
+		_, err := parseDataVolume(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestUpdateBDMListGp3(t *testing.T) {
+	driver := NewTestDriver()
+	driver.DeviceName = "/dev/sda1"
+	driver.VolumeType = "gp3"
+	driver.VolumeIops = 4000
+	driver.VolumeThroughput = 250
+	driver.DataVolumes = []DataVolume{{DeviceName: "/dev/sdf", Size: 50, Type: "gp3"}}
+	driver.bdmList = []*ec2.BlockDeviceMapping{
+		{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsBlockDevice{}},
+	}
+
+	bdmList := driver.updateBDMList()
+
+	assert.Len(t, bdmList, 2)
+	assert.Equal(t, int64(4000), *bdmList[0].Ebs.Iops)
+	assert.Equal(t, int64(250), *bdmList[0].Ebs.Throughput)
+	assert.Equal(t, "/dev/sdf", *bdmList[1].DeviceName)
+	assert.Equal(t, int64(50), *bdmList[1].Ebs.VolumeSize)
+	assert.Nil(t, bdmList[1].Ebs.Iops)
+}
+
+func TestLaunchTemplateSpecification(t *testing.T) {
+	driver := NewTestDriver()
+
+	driver.LaunchTemplate = "lt-0123456789"
+	spec := driver.launchTemplateSpecification()
+	assert.Equal(t, "lt-0123456789", *spec.LaunchTemplateId)
+	assert.Nil(t, spec.LaunchTemplateName)
+	assert.Nil(t, spec.Version)
+
+	driver.LaunchTemplate = "org-standard"
+	driver.LaunchTemplateVersion = "3"
+	spec = driver.launchTemplateSpecification()
+	assert.Nil(t, spec.LaunchTemplateId)
+	assert.Equal(t, "org-standard", *spec.LaunchTemplateName)
+	assert.Equal(t, "3", *spec.Version)
+}
+
+func TestApplyLaunchTemplateData(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AMI = ""
+	driver.IamInstanceProfile = "explicit-profile"
+
+	driver.applyLaunchTemplateData(&ec2.ResponseLaunchTemplateData{
+		ImageId:            aws.String("ami-template"),
+		InstanceType:       aws.String("m5.large"),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecification{Name: aws.String("template-profile")},
+		SecurityGroupIds:   []*string{aws.String("sg-1"), aws.String("sg-2")},
+	})
+
+	assert.Equal(t, "ami-template", driver.AMI)
+	assert.Equal(t, "m5.large", driver.InstanceType)
+	assert.Equal(t, "explicit-profile", driver.IamInstanceProfile)
+	assert.Empty(t, driver.SecurityGroupNames)
+	assert.Equal(t, []string{"sg-1", "sg-2"}, driver.SecurityGroupIds)
+}
+
+func TestApplyLaunchTemplateDataKeepsOverrides(t *testing.T) {
+	driver := NewTestDriver()
+	driver.AMI = "ami-explicit"
+	driver.InstanceType = "c5.xlarge"
+	driver.SecurityGroupNames = []string{"custom"}
+
+	driver.applyLaunchTemplateData(&ec2.ResponseLaunchTemplateData{
+		ImageId:          aws.String("ami-template"),
+		InstanceType:     aws.String("m5.large"),
+		SecurityGroupIds: []*string{aws.String("sg-1")},
+	})
+
+	assert.Equal(t, "ami-explicit", driver.AMI)
+	assert.Equal(t, "c5.xlarge", driver.InstanceType)
+	assert.Equal(t, []string{"custom"}, driver.SecurityGroupNames)
+	assert.Empty(t, driver.SecurityGroupIds)
+}
+
+func TestMergeTemplateTags(t *testing.T) {
+	tagSpecs := []*ec2.TagSpecification{{
+		ResourceType: aws.String(ec2InstanceResource),
+		Tags:         []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("machine")}},
+	}}
+	templateTags := []*ec2.LaunchTemplateTagSpecification{
+		{
+			ResourceType: aws.String(ec2InstanceResource),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String("template")},
+				{Key: aws.String("cost-center"), Value: aws.String("42")},
+			},
+		},
+		{
+			ResourceType: aws.String(ec2VolumeResource),
+			Tags:         []*ec2.Tag{{Key: aws.String("backup"), Value: aws.String("daily")}},
+		},
+	}
+
+	merged := mergeTemplateTags(tagSpecs, templateTags)
+
+	assert.Len(t, merged, 2)
+	assert.Equal(t, []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("machine")},
+		{Key: aws.String("cost-center"), Value: aws.String("42")},
+	}, merged[0].Tags)
+	assert.Equal(t, ec2VolumeResource, *merged[1].ResourceType)
+}
+
+func TestBuildTagsWithLabels(t *testing.T) {
+	driver := NewTestDriver()
+	driver.Tags = "team,infra"
+	assert.NoError(t, driver.SetLabels(map[string]string{"team": "web", "env": "prod"}))
+
+	assert.Equal(t, []*ec2.Tag{
+		{Key: aws.String("team"), Value: aws.String("infra")},
+		{Key: aws.String("env"), Value: aws.String("prod")},
+	}, driver.buildTags())
+}
+
+func TestValidatePlacement(t *testing.T) {
+	tests := []struct {
+		driver Driver
+		err    error
+	}{
+		{Driver{PlacementGroup: "pg", PlacementGroupStrategy: "cluster"}, nil},
+		{Driver{PlacementGroup: "pg", PlacementGroupStrategy: "partition", PlacementPartition: 2}, nil},
+		{Driver{PlacementGroup: "pg", PlacementPartition: 2}, nil},
+		{Driver{Tenancy: "host", HostId: "h-1234"}, nil},
+		{Driver{PlacementGroupStrategy: "random"}, errorInvalidPlacementStrategy},
+		{Driver{PlacementGroup: "pg", PlacementGroupStrategy: "spread", PlacementPartition: 2}, errorPartitionWithoutPartitionGroup},
+		{Driver{PlacementPartition: 2}, errorPartitionWithoutPartitionGroup},
+		{Driver{Tenancy: "shared"}, errorInvalidTenancy},
+		{Driver{Tenancy: "dedicated", HostId: "h-1234"}, errorHostIdWithoutHostTenancy},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.err, test.driver.validatePlacement(), "%+v", test.driver)
+	}
+}
+
+func TestConfigurePlacementGroupCreatesMissingGroup(t *testing.T) {
+	client := &fakeEC2WithPlacementGroups{}
+	driver := NewCustomTestDriver(client)
+	driver.PlacementGroup = "low-latency"
+	driver.PlacementGroupStrategy = "cluster"
+
+	err := driver.configurePlacementGroup()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "low-latency", *client.created.GroupName)
+	assert.Equal(t, "cluster", *client.created.Strategy)
+}
+
+func TestConfigurePlacementGroupJoinsExistingGroup(t *testing.T) {
+	client := &fakeEC2WithPlacementGroups{
+		groups: []*ec2.PlacementGroup{{GroupName: aws.String("low-latency")}},
+	}
+	driver := NewCustomTestDriver(client)
+	driver.PlacementGroup = "low-latency"
+
+	err := driver.configurePlacementGroup()
+
+	assert.NoError(t, err)
+	assert.Nil(t, client.created)
+}
+
+func TestConfigurePlacementGroupMissingWithoutStrategy(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithPlacementGroups{})
+	driver.PlacementGroup = "low-latency"
+
+	err := driver.configurePlacementGroup()
+
+	assert.EqualError(t, err, "placement group low-latency not found; use --amazonec2-placement-group-strategy to create it")
+}
+
+func TestSSMImpliesPrivateAddress(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":              "test",
+			"amazonec2-region":  "us-east-1",
+			"amazonec2-use-ssm": true,
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+	assert.NoError(t, err)
+	assert.True(t, driver.PrivateIPOnly)
+
+	hostname, err := driver.GetSSHHostname()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", hostname)
+}
+
+func TestSSMTunnelRequiresInstance(t *testing.T) {
+	driver := NewTestDriver()
+	driver.UseSSM = true
+
+	_, err := driver.GetSSHPort()
+
+	assert.EqualError(t, err, "cannot open an SSM session before the instance has been created")
+}
+
+func TestSSMCredentialsEnv(t *testing.T) {
+	driver := NewTestDriver()
+	assert.Empty(t, driver.ssmCredentialsEnv())
+
+	driver.AccessKey = "access"
+	driver.SecretKey = "secret"
+	driver.SessionToken = "token"
+	assert.Equal(t, []string{
+		"AWS_ACCESS_KEY_ID=access",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"AWS_SESSION_TOKEN=token",
+	}, driver.ssmCredentialsEnv())
+
+	driver = NewTestDriver()
+	driver.Profile = "sso"
+	assert.Equal(t, []string{"AWS_PROFILE=sso"}, driver.ssmCredentialsEnv())
+}
+
+func TestProfileIsSetFromFlags(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":              "test",
+			"amazonec2-region":  "us-east-1",
+			"amazonec2-zone":    "e",
+			"amazonec2-profile": "sso",
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "sso", driver.Profile)
+}
+
+func TestParseNetworkInterface(t *testing.T) {
+	networkInterface, err := parseNetworkInterface("subnet=subnet-1234,security-groups=sg-1;sg-2,private-ip-count=2")
+
+	assert.NoError(t, err)
+	assert.Equal(t, NetworkInterface{
+		SubnetId:                "subnet-1234",
+		SecurityGroupIds:        []string{"sg-1", "sg-2"},
+		SecondaryPrivateIPCount: 2,
+	}, networkInterface)
+
+	_, err = parseNetworkInterface("security-groups=sg-1")
+	assert.Error(t, err)
+
+	_, err = parseNetworkInterface("subnet=subnet-1234,vlan=3")
+	assert.Error(t, err)
+}
+
+func TestBuildNetworkInterfaceSpecs(t *testing.T) {
+	driver := NewTestDriver()
+	driver.SubnetId = "subnet-primary"
+	driver.SecondaryPrivateIPCount = 1
+
+	netSpecs := driver.buildNetworkInterfaceSpecs()
+	assert.Len(t, netSpecs, 1)
+	assert.True(t, *netSpecs[0].AssociatePublicIpAddress)
+	assert.Equal(t, int64(1), *netSpecs[0].SecondaryPrivateIpAddressCount)
+
+	driver.NetworkInterfaces = []NetworkInterface{{SubnetId: "subnet-storage", SecurityGroupIds: []string{"sg-1"}}}
+
+	netSpecs = driver.buildNetworkInterfaceSpecs()
+	assert.Len(t, netSpecs, 2)
+	assert.Nil(t, netSpecs[0].AssociatePublicIpAddress)
+	assert.Equal(t, int64(1), *netSpecs[1].DeviceIndex)
+	assert.Equal(t, "subnet-storage", *netSpecs[1].SubnetId)
+	assert.Nil(t, netSpecs[1].SecondaryPrivateIpAddressCount)
+}
+
+func TestMultipleInterfacesRequirePrivateAddress(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithLogin{})
+	driver.awsCredentialsFactory = NewValidAwsCredentials
+	options := &commandstest.FakeFlagger{
+		Data: map[string]interface{}{
+			"name":                        "test",
+			"amazonec2-region":            "us-east-1",
+			"amazonec2-network-interface": []string{"subnet=subnet-1234"},
+		},
+	}
+
+	err := driver.SetConfigFromFlags(options)
+
+	assert.Equal(t, errorMultipleInterfacesPublicIP, err)
+}
+
+func TestGetDockerIPFromInterface(t *testing.T) {
+	driver := NewCustomTestDriver(&fakeEC2WithSpotRequest{
+		instance: &ec2.Instance{
+			InstanceId:       aws.String("i-1234"),
+			PrivateIpAddress: aws.String("10.0.0.10"),
+			NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+				{
+					Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+					PrivateIpAddress: aws.String("10.0.0.10"),
+				},
+				{
+					Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+					PrivateIpAddress: aws.String("10.1.0.10"),
+				},
+			},
+		},
+	})
+	driver.InstanceId = "i-1234"
+	driver.DockerInterfaceIndex = 1
+
+	ip, err := driver.getDockerIP()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "10.1.0.10", ip)
+}
+
+func TestIsSpotInstance(t *testing.T) {
+	driver := NewDriver("machineFoo", "path")
+	assert.False(t, driver.isSpotInstance())
+
+	driver.RequestSpotInstance = true
+	assert.True(t, driver.isSpotInstance())
+
+	driver.OnDemandFallback = true
+	assert.False(t, driver.isSpotInstance())
+	assert.True(t, driver.RequestSpotInstance)
+}
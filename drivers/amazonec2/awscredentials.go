@@ -21,12 +21,12 @@ type defaultAWSCredentials struct {
 	fallbackProvider awsCredentials
 }
 
-func NewAWSCredentials(id, secret, token string) *defaultAWSCredentials {
+func NewAWSCredentials(id, secret, token, profile string) *defaultAWSCredentials {
 	creds := defaultAWSCredentials{
 		AccessKey:        id,
 		SecretKey:        secret,
 		SessionToken:     token,
-		fallbackProvider: &AwsDefaultCredentialsProvider{},
+		fallbackProvider: &AwsDefaultCredentialsProvider{Profile: profile},
 		providerFactory:  &defaultProviderFactory{},
 	}
 	return &creds
@@ -38,18 +38,43 @@ func (c *defaultAWSCredentials) Credentials() *credentials.Credentials {
 		providers = append(providers, c.providerFactory.NewStaticProvider(c.AccessKey, c.SecretKey, c.SessionToken))
 	}
 	if c.fallbackProvider != nil {
-		fallbackCreds, err := c.fallbackProvider.Credentials().Get()
-		if err == nil {
-			providers = append(providers, &credentials.StaticProvider{Value: fallbackCreds})
-		}
+		providers = append(providers, &refreshingProvider{c.fallbackProvider.Credentials()})
 	}
 	return credentials.NewChainCredentials(providers)
 }
 
-type AwsDefaultCredentialsProvider struct{}
+// refreshingProvider gets the credentials of the fallback chain again when
+// they expire, as SSO and assumed role credentials only last an hour or so.
+type refreshingProvider struct {
+	creds *credentials.Credentials
+}
+
+func (p *refreshingProvider) Retrieve() (credentials.Value, error) {
+	return p.creds.Get()
+}
+
+func (p *refreshingProvider) IsExpired() bool {
+	return p.creds.IsExpired()
+}
+
+// AwsDefaultCredentialsProvider gets the credentials of the SDK default chain:
+// the environment, the shared credentials and config files with their SSO
+// sessions, role chaining and credential processes, then the ECS and EC2
+// metadata endpoints.
+type AwsDefaultCredentialsProvider struct {
+	// Profile is the shared config profile, AWS_PROFILE or "default" if empty.
+	Profile string
+}
 
 func (c *AwsDefaultCredentialsProvider) Credentials() *credentials.Credentials {
-	return session.New().Config.Credentials
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           c.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return credentials.NewCredentials(&credentials.ErrorProvider{Err: err, ProviderName: "SharedConfigProvider"})
+	}
+	return sess.Config.Credentials
 }
 
 type defaultProviderFactory struct{}
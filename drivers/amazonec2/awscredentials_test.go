@@ -1,13 +1,16 @@
 package amazonec2
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAccessKeyIsMandatoryWhenSystemCredentialsAreNotPresent(t *testing.T) {
-	awsCreds := NewAWSCredentials("", "", "")
+	awsCreds := NewAWSCredentials("", "", "", "")
 	awsCreds.fallbackProvider = nil
 
 	_, err := awsCreds.Credentials().Get()
@@ -15,7 +18,7 @@ func TestAccessKeyIsMandatoryWhenSystemCredentialsAreNotPresent(t *testing.T) {
 }
 
 func TestAccessKeyIsMandatoryEvenIfSecretKeyIsPassedWhenSystemCredentialsAreNotPresent(t *testing.T) {
-	awsCreds := NewAWSCredentials("", "secret", "")
+	awsCreds := NewAWSCredentials("", "secret", "", "")
 	awsCreds.fallbackProvider = nil
 
 	_, err := awsCreds.Credentials().Get()
@@ -23,7 +26,7 @@ func TestAccessKeyIsMandatoryEvenIfSecretKeyIsPassedWhenSystemCredentialsAreNotP
 }
 
 func TestSecretKeyIsMandatoryWhenSystemCredentialsAreNotPresent(t *testing.T) {
-	awsCreds := NewAWSCredentials("access", "", "")
+	awsCreds := NewAWSCredentials("access", "", "", "")
 	awsCreds.fallbackProvider = nil
 
 	_, err := awsCreds.Credentials().Get()
@@ -31,7 +34,7 @@ func TestSecretKeyIsMandatoryWhenSystemCredentialsAreNotPresent(t *testing.T) {
 }
 
 func TestFallbackCredentialsAreLoadedWhenAccessKeyAndSecretKeyAreMissing(t *testing.T) {
-	awsCreds := NewAWSCredentials("", "", "")
+	awsCreds := NewAWSCredentials("", "", "", "")
 	awsCreds.fallbackProvider = &fallbackCredentials{}
 
 	creds, err := awsCreds.Credentials().Get()
@@ -43,7 +46,7 @@ func TestFallbackCredentialsAreLoadedWhenAccessKeyAndSecretKeyAreMissing(t *test
 }
 
 func TestFallbackCredentialsAreLoadedWhenAccessKeyIsMissing(t *testing.T) {
-	awsCreds := NewAWSCredentials("", "secret", "")
+	awsCreds := NewAWSCredentials("", "secret", "", "")
 	awsCreds.fallbackProvider = &fallbackCredentials{}
 
 	creds, err := awsCreds.Credentials().Get()
@@ -55,7 +58,7 @@ func TestFallbackCredentialsAreLoadedWhenAccessKeyIsMissing(t *testing.T) {
 }
 
 func TestFallbackCredentialsAreLoadedWhenSecretKeyIsMissing(t *testing.T) {
-	awsCreds := NewAWSCredentials("access", "", "")
+	awsCreds := NewAWSCredentials("access", "", "", "")
 	awsCreds.fallbackProvider = &fallbackCredentials{}
 
 	creds, err := awsCreds.Credentials().Get()
@@ -67,7 +70,7 @@ func TestFallbackCredentialsAreLoadedWhenSecretKeyIsMissing(t *testing.T) {
 }
 
 func TestOptionCredentialsAreLoadedWhenAccessKeyAndSecretKeyAreProvided(t *testing.T) {
-	awsCreds := NewAWSCredentials("access", "secret", "")
+	awsCreds := NewAWSCredentials("access", "secret", "", "")
 	awsCreds.fallbackProvider = &fallbackCredentials{}
 
 	creds, err := awsCreds.Credentials().Get()
@@ -79,7 +82,7 @@ func TestOptionCredentialsAreLoadedWhenAccessKeyAndSecretKeyAreProvided(t *testi
 }
 
 func TestFallbackCredentialsAreLoadedIfStaticCredentialsGenerateError(t *testing.T) {
-	awsCreds := NewAWSCredentials("access", "secret", "token")
+	awsCreds := NewAWSCredentials("access", "secret", "token", "")
 	awsCreds.fallbackProvider = &fallbackCredentials{}
 	awsCreds.providerFactory = &errorCredentialsProvider{}
 
@@ -92,10 +95,53 @@ func TestFallbackCredentialsAreLoadedIfStaticCredentialsGenerateError(t *testing
 }
 
 func TestErrorGeneratedWhenAllProvidersGenerateErrors(t *testing.T) {
-	awsCreds := NewAWSCredentials("access", "secret", "token")
+	awsCreds := NewAWSCredentials("access", "secret", "token", "")
 	awsCreds.fallbackProvider = &errorFallbackCredentials{}
 	awsCreds.providerFactory = &errorCredentialsProvider{}
 
 	_, err := awsCreds.Credentials().Get()
 	assert.Error(t, err)
 }
+
+func TestFallbackCredentialsAreRefreshedWhenExpired(t *testing.T) {
+	provider := &expiringProvider{}
+	awsCreds := NewAWSCredentials("", "", "", "")
+	awsCreds.fallbackProvider = &providerCredentials{provider}
+
+	creds := awsCreds.Credentials()
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "access_1", value.AccessKeyID)
+
+	provider.expired = true
+	value, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "access_2", value.AccessKeyID)
+}
+
+func TestDefaultCredentialsProviderUsesProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "config")
+	assert.NoError(t, ioutil.WriteFile(config, []byte(`[profile machine]
+aws_access_key_id = profile_access
+aws_secret_access_key = profile_secret
+`), 0600))
+	for name, value := range map[string]string{
+		"AWS_CONFIG_FILE":             config,
+		"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(dir, "credentials"),
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_PROFILE":                 "",
+	} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Setenv(name, value)
+	}
+
+	value, err := (&AwsDefaultCredentialsProvider{Profile: "machine"}).Credentials().Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "profile_access", value.AccessKeyID)
+	assert.Equal(t, "profile_secret", value.SecretAccessKey)
+}
@@ -0,0 +1,179 @@
+package amazonec2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+)
+
+const (
+	ssmPortForwardingDocument = "AWS-StartPortForwardingSession"
+	ssmLocalHost              = "127.0.0.1"
+)
+
+var (
+	awsCLIBinary                 = "aws"
+	sessionManagerPluginBinary   = "session-manager-plugin"
+	ssmTunnelConnectTimeout      = 30 * time.Second
+	ssmTunnelConnectPollInterval = 500 * time.Millisecond
+)
+
+// ssmTunnel is an SSM port-forwarding session from a local port to the SSH
+// port of the instance, kept open for the lifetime of the driver process.
+type ssmTunnel struct {
+	cmd       *exec.Cmd
+	localPort int
+	done      chan struct{}
+}
+
+// checkSSMPrerequisites makes sure the AWS CLI and the session manager plugin,
+// which implement the SSM session protocol, are available.
+func checkSSMPrerequisites() error {
+	for _, binary := range []string{awsCLIBinary, sessionManagerPluginBinary} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("using --amazonec2-use-ssm requires %q in your PATH: %s", binary, err)
+		}
+	}
+	return nil
+}
+
+// ssmTunnelPort returns the local port of the SSM tunnel to the instance,
+// opening the tunnel first if needed.
+func (d *Driver) ssmTunnelPort() (int, error) {
+	if d.ssmTunnel != nil && d.ssmTunnel.alive() {
+		return d.ssmTunnel.localPort, nil
+	}
+
+	if d.InstanceId == "" {
+		return 0, fmt.Errorf("cannot open an SSM session before the instance has been created")
+	}
+
+	tunnel, err := d.startSSMTunnel()
+	if err != nil {
+		return 0, err
+	}
+	d.ssmTunnel = tunnel
+
+	return tunnel.localPort, nil
+}
+
+func (d *Driver) startSSMTunnel() (*ssmTunnel, error) {
+	localPort, err := getAvailableTCPPort()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find a free local port for the SSM session: %s", err)
+	}
+
+	sshPort, err := d.BaseDriver.GetSSHPort()
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := json.Marshal(map[string][]string{
+		"portNumber":      {strconv.Itoa(sshPort)},
+		"localPortNumber": {strconv.Itoa(localPort)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(awsCLIBinary, "ssm", "start-session",
+		"--region", d.Region,
+		"--target", d.InstanceId,
+		"--document-name", ssmPortForwardingDocument,
+		"--parameters", string(parameters),
+	)
+	cmd.Env = append(os.Environ(), d.ssmCredentialsEnv()...)
+	setTunnelProcAttr(cmd)
+
+	log.Debugf("starting SSM port forwarding session to %s:%d on local port %d", d.InstanceId, sshPort, localPort)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start SSM session: %s", err)
+	}
+
+	tunnel := &ssmTunnel{
+		cmd:       cmd,
+		localPort: localPort,
+		done:      make(chan struct{}),
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Debugf("SSM session to %s ended: %s", d.InstanceId, err)
+		}
+		close(tunnel.done)
+	}()
+
+	addr := net.JoinHostPort(ssmLocalHost, strconv.Itoa(localPort))
+	err = mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		if !tunnel.alive() {
+			return false, errors.New("SSM session exited")
+		}
+		conn, err := net.DialTimeout("tcp", addr, ssmTunnelConnectPollInterval)
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	}, int(ssmTunnelConnectTimeout/ssmTunnelConnectPollInterval), ssmTunnelConnectPollInterval)
+	if err != nil {
+		tunnel.close()
+		return nil, fmt.Errorf("SSM session to %s did not become ready: %s", d.InstanceId, err)
+	}
+
+	return tunnel, nil
+}
+
+// ssmCredentialsEnv hands the credentials or the profile given on the command
+// line to the AWS CLI. Without them the CLI falls back to its own credential
+// chain.
+func (d *Driver) ssmCredentialsEnv() []string {
+	if d.AccessKey == "" || d.SecretKey == "" {
+		if d.Profile != "" {
+			return []string{"AWS_PROFILE=" + d.Profile}
+		}
+		return nil
+	}
+
+	env := []string{
+		"AWS_ACCESS_KEY_ID=" + d.AccessKey,
+		"AWS_SECRET_ACCESS_KEY=" + d.SecretKey,
+	}
+	if d.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+d.SessionToken)
+	}
+	return env
+}
+
+func (t *ssmTunnel) alive() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (t *ssmTunnel) close() {
+	if t.alive() {
+		if err := t.cmd.Process.Kill(); err != nil {
+			log.Debugf("unable to stop SSM session: %s", err)
+		}
+	}
+}
+
+func getAvailableTCPPort() (int, error) {
+	ln, err := net.Listen("tcp4", ssmLocalHost+":0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,12 @@
+package amazonec2
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setTunnelProcAttr makes sure the SSM session does not outlive the plugin
+// process, which exits without running any cleanup.
+func setTunnelProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package amazonec2
+
+import "os/exec"
+
+func setTunnelProcAttr(cmd *exec.Cmd) {}
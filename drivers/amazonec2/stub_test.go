@@ -2,6 +2,7 @@ package amazonec2
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -162,6 +163,41 @@ func (f *fakeEC2SecurityGroupTestRecorder) CreateTags(input *ec2.CreateTagsInput
 	return value, err
 }
 
+type fakeEC2WithSpotRequest struct {
+	*fakeEC2
+	request  *ec2.SpotInstanceRequest
+	instance *ec2.Instance
+}
+
+func (f *fakeEC2WithSpotRequest) DescribeSpotInstanceRequests(input *ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	return &ec2.DescribeSpotInstanceRequestsOutput{
+		SpotInstanceRequests: []*ec2.SpotInstanceRequest{f.request},
+	}, nil
+}
+
+func (f *fakeEC2WithSpotRequest) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{
+			{Instances: []*ec2.Instance{f.instance}},
+		},
+	}, nil
+}
+
+type fakeEC2WithPlacementGroups struct {
+	*fakeEC2
+	groups  []*ec2.PlacementGroup
+	created *ec2.CreatePlacementGroupInput
+}
+
+func (f *fakeEC2WithPlacementGroups) DescribePlacementGroups(input *ec2.DescribePlacementGroupsInput) (*ec2.DescribePlacementGroupsOutput, error) {
+	return &ec2.DescribePlacementGroupsOutput{PlacementGroups: f.groups}, nil
+}
+
+func (f *fakeEC2WithPlacementGroups) CreatePlacementGroup(input *ec2.CreatePlacementGroupInput) (*ec2.CreatePlacementGroupOutput, error) {
+	f.created = input
+	return &ec2.CreatePlacementGroupOutput{}, nil
+}
+
 func NewTestDriver() *Driver {
 	driver := NewDriver("machineFoo", "path")
 	driver.clientFactory = func() Ec2Client {
@@ -177,3 +213,26 @@ func NewCustomTestDriver(ec2Client Ec2Client) *Driver {
 	}
 	return driver
 }
+
+type expiringProvider struct {
+	retrieved int
+	expired   bool
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	p.expired = false
+	return credentials.Value{AccessKeyID: fmt.Sprintf("access_%d", p.retrieved), SecretAccessKey: "secret"}, nil
+}
+
+func (p *expiringProvider) IsExpired() bool {
+	return p.expired
+}
+
+type providerCredentials struct {
+	provider credentials.Provider
+}
+
+func (c *providerCredentials) Credentials() *credentials.Credentials {
+	return credentials.NewCredentials(c.provider)
+}
@@ -11,7 +11,9 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/rancher/machine/drivers/azure/azureutil"
@@ -35,6 +37,8 @@ const (
 	defaultAzureSubnetPrefix    = "192.168.0.0/16"
 	defaultStorageType          = string(storage.StandardLRS)
 	defaultAzureAvailabilitySet = "docker-machine"
+	defaultSpotEvictionPolicy   = "Deallocate"
+	defaultSpotMaxPrice         = "-1"
 )
 
 const (
@@ -55,6 +59,7 @@ const (
 	flAzureFaultDomainCount          = "azure-fault-domain-count"
 	flAzureUpdateDomainCount         = "azure-update-domain-count"
 	flAzureDiskSize                  = "azure-disk-size"
+	flAzureDataDisk                  = "azure-data-disk"
 	flAzurePorts                     = "azure-open-port"
 	flAzurePrivateIPAddr             = "azure-private-ip-address"
 	flAzureUsePrivateIP              = "azure-use-private-ip"
@@ -63,14 +68,21 @@ const (
 	flAzureDNSLabel                  = "azure-dns"
 	flAzureStorageType               = "azure-storage-type"
 	flAzureCustomData                = "azure-custom-data"
+	flAzureVMExtension               = "azure-vm-extension"
 	flAzureClientID                  = "azure-client-id"
 	flAzureClientSecret              = "azure-client-secret"
+	flAzureUseManagedIdentity        = "azure-use-managed-identity"
+	flAzureFederatedTokenFile        = "azure-federated-token-file"
 	flAzureNSG                       = "azure-nsg"
 	flAzurePlan                      = "azure-plan"
 	flAzureTags                      = "azure-tags"
 	flAzureAcceleratedNetworking     = "azure-accelerated-networking"
 	flAzureEnablePublicIPStandardSKU = "azure-enable-public-ip-standard-sku"
 	flAzureAvailabilityZones         = "azure-availability-zone"
+	flAzureProximityPlacementGroup   = "azure-proximity-placement-group"
+	flAzureSpot                      = "azure-spot"
+	flAzureSpotEvictionPolicy        = "azure-spot-eviction-policy"
+	flAzureSpotMaxPrice              = "azure-spot-max-price"
 )
 
 const (
@@ -83,7 +95,10 @@ type Driver struct {
 	*drivers.BaseDriver
 
 	ClientID     string // service principal account name
-	ClientSecret string // service principal account password
+	ClientSecret string `secret:"true"` // service principal account password
+
+	UseManagedIdentity bool   // authenticate as the managed identity of the host
+	FederatedTokenFile string // path to a federated OIDC token for workload identity
 
 	Environment    string
 	SubscriptionID string
@@ -99,16 +114,21 @@ type Driver struct {
 	SubnetPrefix              string
 	AvailabilitySet           string
 	NSG                       string
-	Plan                      string
+	ImagePlan                 string `json:"Plan"`
 	ManagedDisks              bool
 	FaultCount                int
 	UpdateCount               int
 	DiskSize                  int
+	DataDisks                 []azureutil.DataDisk
 	StorageType               string
 	Tags                      map[string]*string
 	AcceleratedNetworking     bool
 	AvailabilityZone          string
+	ProximityPlacementGroup   string
 	EnablePublicIPStandardSKU bool
+	Spot                      bool
+	SpotEvictionPolicy        string
+	SpotMaxPrice              float64
 
 	OpenPorts      []string
 	PrivateIPAddr  string
@@ -117,6 +137,7 @@ type Driver struct {
 	DNSLabel       string
 	StaticPublicIP bool
 	CustomDataFile string // Can provide cloud-config file here
+	VMExtensions   []VMExtension
 
 	// Ephemeral fields
 	deploymentCtx *azureutil.DeploymentContext
@@ -192,7 +213,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.StringFlag{
 			Name:   flAzureImage,
-			Usage:  "Azure virtual machine OS image",
+			Usage:  "Azure virtual machine OS image: a publisher:offer:sku:version URN, a custom or gallery image ID, or a community or shared gallery image ID, using the latest version if none is given",
 			EnvVar: "AZURE_IMAGE",
 			Value:  defaultAzureImage,
 		},
@@ -216,7 +237,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.StringFlag{
 			Name:   flAzureAvailabilitySet,
-			Usage:  "Azure Availability Set to place the virtual machine into",
+			Usage:  "Azure Availability Set to place the virtual machine into (set to an empty string to not use one)",
 			EnvVar: "AZURE_AVAILABILITY_SET",
 			Value:  defaultAzureAvailabilitySet,
 		},
@@ -253,18 +274,26 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			EnvVar: "AZURE_DISK_SIZE",
 			Value:  30,
 		},
+		mcnflag.StringSliceFlag{
+			Name:  flAzureDataDisk,
+			Usage: "Attach an empty managed data disk, given as 'size=<GB>[,type=<sku>][,iops=<n>][,mbps=<n>]' (type defaults to the storage type, iops/mbps require UltraSSD_LRS)",
+		},
 		mcnflag.StringFlag{
 			Name:   flAzureCustomData,
 			EnvVar: "AZURE_CUSTOM_DATA_FILE",
 			Usage:  "Path to file with custom-data",
 		},
+		mcnflag.StringSliceFlag{
+			Name:  flAzureVMExtension,
+			Usage: "Install a VM extension after create, given as 'publisher=<publisher>,type=<type>,version=<version>[,name=<name>][,settings=<json file>][,protected-settings=<json file>]'",
+		},
 		mcnflag.StringFlag{
 			Name:  flAzurePrivateIPAddr,
 			Usage: "Specify a static private IP address for the machine",
 		},
 		mcnflag.StringFlag{
 			Name:   flAzureStorageType,
-			Usage:  "Type of Storage Account to host the OS Disk for the machine",
+			Usage:  "Type of Storage Account to host the OS Disk for the machine (e.g. Standard_LRS, StandardSSD_LRS, Premium_LRS)",
 			EnvVar: "AZURE_STORAGE_TYPE",
 			Value:  defaultStorageType,
 		},
@@ -299,6 +328,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Azure Service Principal Account password (optional, browser auth is used if not specified)",
 			EnvVar: "AZURE_CLIENT_SECRET",
 		},
+		mcnflag.BoolFlag{
+			Name:   flAzureUseManagedIdentity,
+			Usage:  "Authenticate with the managed identity of the Azure resource docker-machine runs on (set --azure-client-id to pick a user-assigned identity)",
+			EnvVar: "AZURE_USE_MANAGED_IDENTITY",
+		},
+		mcnflag.StringFlag{
+			Name:   flAzureFederatedTokenFile,
+			Usage:  "Path to a federated token file to authenticate with workload identity as --azure-client-id",
+			EnvVar: "AZURE_FEDERATED_TOKEN_FILE",
+		},
 		mcnflag.StringFlag{
 			Name:   flAzureTags,
 			Usage:  "Tags to be applied to the Azure VM instance",
@@ -309,6 +348,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Specify the Availability Zones the Azure resources should be created in",
 			EnvVar: "AZURE_AVAILABILITY_ZONE",
 		},
+		mcnflag.StringFlag{
+			Name:   flAzureProximityPlacementGroup,
+			Usage:  "Azure Proximity Placement Group to co-locate the virtual machine in (accepts either a name, created if missing, or a resource ID)",
+			EnvVar: "AZURE_PROXIMITY_PLACEMENT_GROUP",
+		},
 		mcnflag.BoolFlag{
 			Name:   flAzureEnablePublicIPStandardSKU,
 			Usage:  "Specify if a Standard SKU should be used for the Public IP of the Azure VM",
@@ -319,6 +363,23 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Specify if an Accelerated Networking NIC should be created for your VM",
 			EnvVar: "AZURE_ACCELERATED_NETWORKING",
 		},
+		mcnflag.BoolFlag{
+			Name:   flAzureSpot,
+			Usage:  "Create the virtual machine as an Azure Spot VM",
+			EnvVar: "AZURE_SPOT",
+		},
+		mcnflag.StringFlag{
+			Name:   flAzureSpotEvictionPolicy,
+			Usage:  "What happens to the Spot VM when it is evicted (Deallocate or Delete)",
+			EnvVar: "AZURE_SPOT_EVICTION_POLICY",
+			Value:  defaultSpotEvictionPolicy,
+		},
+		mcnflag.StringFlag{
+			Name:   flAzureSpotMaxPrice,
+			Usage:  "Maximum hourly price in US dollars to pay for the Spot VM, -1 to pay up to the on-demand price",
+			EnvVar: "AZURE_SPOT_MAX_PRICE",
+			Value:  defaultSpotMaxPrice,
+		},
 	}
 }
 
@@ -351,6 +412,10 @@ func (d *Driver) UnmarshalJSON(data []byte) error {
 		d.ClientSecret = driverOpts.String(flAzureClientSecret)
 	}
 
+	if _, ok := driverOpts.Values[flAzureFederatedTokenFile]; ok {
+		d.FederatedTokenFile = driverOpts.String(flAzureFederatedTokenFile)
+	}
+
 	return nil
 }
 
@@ -374,7 +439,6 @@ func (d *Driver) SetConfigFromFlags(fl drivers.DriverOptions) error {
 		{&d.VirtualNetwork, flAzureVNet},
 		{&d.SubnetName, flAzureSubnet},
 		{&d.SubnetPrefix, flAzureSubnetPrefix},
-		{&d.AvailabilitySet, flAzureAvailabilitySet},
 		{&d.StorageType, flAzureStorageType},
 	}
 	for _, f := range flags {
@@ -386,6 +450,8 @@ func (d *Driver) SetConfigFromFlags(fl drivers.DriverOptions) error {
 
 	// Optional flags or Flags of other types
 	d.AvailabilityZone = fl.String(flAzureAvailabilityZones)
+	d.AvailabilitySet = fl.String(flAzureAvailabilitySet)
+	d.ProximityPlacementGroup = fl.String(flAzureProximityPlacementGroup)
 	d.EnablePublicIPStandardSKU = fl.Bool(flAzureEnablePublicIPStandardSKU)
 	d.Tags = azureutil.BuildInstanceTags(fl.String(flAzureTags))
 	d.AcceleratedNetworking = fl.Bool(flAzureAcceleratedNetworking)
@@ -398,16 +464,51 @@ func (d *Driver) SetConfigFromFlags(fl drivers.DriverOptions) error {
 	d.DockerPort = fl.Int(flAzureDockerPort)
 	d.DNSLabel = fl.String(flAzureDNSLabel)
 	d.CustomDataFile = fl.String(flAzureCustomData)
+	d.VMExtensions = nil
+	for _, spec := range fl.StringSlice(flAzureVMExtension) {
+		ext, err := parseVMExtension(spec)
+		if err != nil {
+			return err
+		}
+		d.VMExtensions = append(d.VMExtensions, ext)
+	}
+	if _, err := azureutil.ParseImageReference(d.Image); err != nil {
+		return err
+	}
 	d.ManagedDisks = fl.Bool(flAzureManagedDisks)
 	d.FaultCount = fl.Int(flAzureFaultDomainCount)
 	d.UpdateCount = fl.Int(flAzureUpdateDomainCount)
 	d.DiskSize = fl.Int(flAzureDiskSize)
+	d.DataDisks = nil
+	for _, spec := range fl.StringSlice(flAzureDataDisk) {
+		disk, err := parseDataDisk(spec, d.StorageType)
+		if err != nil {
+			return err
+		}
+		d.DataDisks = append(d.DataDisks, disk)
+	}
 	d.NSG = fl.String(flAzureNSG)
-	d.Plan = fl.String(flAzurePlan)
+	d.ImagePlan = fl.String(flAzurePlan)
+
+	d.Spot = fl.Bool(flAzureSpot)
+	if d.Spot {
+		var err error
+		if d.SpotEvictionPolicy, err = parseSpotEvictionPolicy(fl.String(flAzureSpotEvictionPolicy)); err != nil {
+			return err
+		}
+		if d.SpotMaxPrice, err = parseSpotMaxPrice(fl.String(flAzureSpotMaxPrice)); err != nil {
+			return err
+		}
+	}
 
 	d.ClientID = fl.String(flAzureClientID)
 	d.ClientSecret = fl.String(flAzureClientSecret)
 	d.TenantID = fl.String(flAzureTenantID)
+	d.UseManagedIdentity = fl.Bool(flAzureUseManagedIdentity)
+	d.FederatedTokenFile = fl.String(flAzureFederatedTokenFile)
+	if err := d.validateAuthOptions(); err != nil {
+		return err
+	}
 
 	// Set flags on the BaseDriver
 	d.BaseDriver.SSHPort = sshPort
@@ -428,6 +529,27 @@ func (d *Driver) PreCreateCheck() (err error) {
 		}
 	}
 
+	for _, ext := range d.VMExtensions {
+		for _, file := range []string{ext.SettingsFile, ext.ProtectedSettingsFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); os.IsNotExist(err) {
+				return fmt.Errorf("settings file %s of VM extension %s could not be found", file, ext.Name)
+			}
+		}
+	}
+
+	if d.StorageType == string(compute.StorageAccountTypesUltraSSDLRS) {
+		return fmt.Errorf("%s can only be used for data disks, not for the OS disk (--%s)", d.StorageType, flAzureStorageType)
+	}
+	if azureutil.IsGalleryImage(d.Image) && !d.ManagedDisks {
+		return fmt.Errorf("Managed Disks must be used when creating a VM from a gallery image (--azure-managed-disks)")
+	}
+	if len(d.DataDisks) > 0 && !d.ManagedDisks {
+		return fmt.Errorf("Managed Disks must be used when attaching data disks (--azure-managed-disks)")
+	}
+
 	if d.AvailabilityZone != "" {
 		if !d.ManagedDisks {
 			return fmt.Errorf("Managed Disks must be used when creating resources in specific Availability Zones (--azure-managed-disks)")
@@ -443,7 +565,7 @@ func (d *Driver) PreCreateCheck() (err error) {
 		}
 	}
 
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
@@ -485,13 +607,64 @@ func (d *Driver) PreCreateCheck() (err error) {
 	return nil
 }
 
+// Plan returns the virtual machine to create, with the firewall rules
+// checked by PreCreateCheck.
+func (d *Driver) Plan() ([]drivers.PlanItem, error) {
+	items := []drivers.PlanItem{
+		{Name: "Location", Value: d.Location},
+		{Name: "Resource group", Value: d.ResourceGroup},
+		{Name: "Virtual machine", Value: d.naming().VM()},
+		{Name: "Size", Value: d.Size},
+		{Name: "Image", Value: d.Image},
+		{Name: "Image plan", Value: d.ImagePlan},
+		{Name: "Virtual network", Value: d.VirtualNetwork},
+		{Name: "Subnet", Value: fmt.Sprintf("%s, %s", d.SubnetName, d.SubnetPrefix)},
+		{Name: "Network security group", Value: d.NSG},
+		{Name: "Open ports", Value: strings.Join(d.OpenPorts, ", ")},
+	}
+	if d.AvailabilityZone != "" {
+		items = append(items, drivers.PlanItem{Name: "Availability zone", Value: d.AvailabilityZone})
+	} else {
+		items = append(items, drivers.PlanItem{Name: "Availability set", Value: d.AvailabilitySet})
+	}
+	items = append(items, drivers.PlanItem{Name: "OS disk", Value: fmt.Sprintf("%d GB, %s", d.DiskSize, d.StorageType)})
+	if len(d.DataDisks) > 0 {
+		items = append(items, drivers.PlanItem{Name: "Data disks", Value: strconv.Itoa(len(d.DataDisks))})
+	}
+	switch {
+	case d.NoPublicIP:
+		items = append(items, drivers.PlanItem{Name: "Public IP", Value: "none"})
+	case d.StaticPublicIP:
+		items = append(items, drivers.PlanItem{Name: "Public IP", Value: "static"})
+	default:
+		items = append(items, drivers.PlanItem{Name: "Public IP", Value: "dynamic"})
+	}
+	if d.Spot {
+		items = append(items, drivers.PlanItem{Name: "Spot", Value: fmt.Sprintf("%s, max price %g", d.SpotEvictionPolicy, d.SpotMaxPrice)})
+	}
+	return items, nil
+}
+
+// CreateContext creates the virtual machine, aborting the Azure calls in
+// progress when ctx is done. What was created is then removed.
+func (d *Driver) CreateContext(ctx context.Context) error {
+	err := d.WithContext(ctx, d.Create)
+	if err != nil && ctx.Err() != nil {
+		log.Warnf("Creation of the virtual machine interrupted, removing what was created: %s", err)
+		if err := d.Remove(); err != nil {
+			log.Warnf("Error removing the virtual machine: %s", err)
+		}
+	}
+	return err
+}
+
 // Create creates the virtual machine.
 func (d *Driver) Create() error {
 	// NOTE(ahmetalpbalkan): We can probably parallelize the sh*t out of this.
 	// However that would lead to a concurrency logic and while creation of a
 	// resource fails, other ones would be kicked off, which could lead to a
 	// resource leak. This is slower but safer.
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
@@ -513,8 +686,15 @@ func (d *Driver) Create() error {
 	if err := c.CreateResourceGroup(ctx, d.ResourceGroup, d.Location); err != nil {
 		return err
 	}
+	if d.ProximityPlacementGroup != "" {
+		if isResourceID(d.ProximityPlacementGroup) {
+			d.deploymentCtx.ProximityPlacementGroupID = d.ProximityPlacementGroup
+		} else if err := c.CreateProximityPlacementGroupIfNotExists(ctx, d.deploymentCtx, d.ResourceGroup, d.ProximityPlacementGroup, d.Location); err != nil {
+			return err
+		}
+	}
 	// availability sets and availability zones cannot be used together. The presence of an Availability Zone indicates that an Availability set should not be created / used
-	if d.AvailabilityZone == "" {
+	if d.useAvailabilitySet() {
 		if err := c.CreateAvailabilitySetIfNotExists(ctx, d.deploymentCtx, d.ResourceGroup, d.AvailabilitySet, d.Location, d.ManagedDisks, int32(d.FaultCount), int32(d.UpdateCount)); err != nil {
 			return err
 		}
@@ -550,10 +730,19 @@ func (d *Driver) Create() error {
 		return err
 	}
 	if err := c.CreateVirtualMachine(ctx, d.ResourceGroup, d.naming().VM(), d.Location, d.Size, d.deploymentCtx.AvailabilitySetID,
-		d.deploymentCtx.NetworkInterfaceID, d.BaseDriver.SSHUser, d.deploymentCtx.SSHPublicKey, d.Image, d.Plan, customData, d.deploymentCtx.StorageAccount,
-		d.ManagedDisks, d.StorageType, int32(d.DiskSize), d.Tags, d.AvailabilityZone); err != nil {
+		d.deploymentCtx.NetworkInterfaceID, d.BaseDriver.SSHUser, d.deploymentCtx.SSHPublicKey, d.Image, d.ImagePlan, customData, d.deploymentCtx.StorageAccount,
+		d.ManagedDisks, d.StorageType, int32(d.DiskSize), d.Tags, d.AvailabilityZone, d.deploymentCtx.ProximityPlacementGroupID, d.DataDisks, d.spotOptions()); err != nil {
 		return err
 	}
+	for _, ext := range d.VMExtensions {
+		vmExtension, err := ext.load()
+		if err != nil {
+			return err
+		}
+		if err := c.CreateVirtualMachineExtension(ctx, d.ResourceGroup, d.naming().VM(), d.Location, vmExtension); err != nil {
+			return fmt.Errorf("failed to install VM extension %s: %v", ext.Name, err)
+		}
+	}
 	ip, err := d.GetIP()
 	if err != nil {
 		return err
@@ -562,6 +751,12 @@ func (d *Driver) Create() error {
 	return nil
 }
 
+// RemoveContext deletes the virtual machine and resources associated to it,
+// aborting the Azure calls in progress when ctx is done.
+func (d *Driver) RemoveContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Remove)
+}
+
 // Remove deletes the virtual machine and resources associated to it.
 func (d *Driver) Remove() error {
 	if err := d.checkLegacyDriver(false); err != nil {
@@ -576,7 +771,7 @@ func (d *Driver) Remove() error {
 	//     then delete the VM, this could enable some parallelization.
 
 	log.Info("NOTICE: Please check Azure portal/CLI to make sure you have no leftover resources to avoid unexpected charges.")
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
@@ -599,11 +794,17 @@ func (d *Driver) Remove() error {
 		return err
 	}
 	// availability sets and availability zones cannot be used together. The absence of any Availability Zones indicates that an Availability set was created and should be deleted.
-	if d.AvailabilityZone == "" {
+	if d.useAvailabilitySet() {
 		if err := c.CleanupAvailabilitySetIfExists(ctx, d.ResourceGroup, d.AvailabilitySet); err != nil {
 			return err
 		}
 	}
+	// the group is removed after the availability set, which might be placed in it
+	if d.ProximityPlacementGroup != "" && !isResourceID(d.ProximityPlacementGroup) {
+		if err := c.CleanupProximityPlacementGroupIfExists(ctx, d.ResourceGroup, d.ProximityPlacementGroup); err != nil {
+			return err
+		}
+	}
 	if err := c.CleanupSubnetIfExists(ctx, d.ResourceGroup, d.VirtualNetwork, d.SubnetName); err != nil {
 		return err
 	}
@@ -618,7 +819,7 @@ func (d *Driver) GetIP() (string, error) {
 	}
 
 	if d.resolvedIP == "" {
-		ctx := context.Background()
+		ctx := d.Context()
 		ip, err := d.ipAddress(ctx)
 		if err != nil {
 			return "", err
@@ -656,13 +857,30 @@ func (d *Driver) GetURL() (string, error) {
 	return u, nil
 }
 
+// SetEnginePort sets the port of the Docker daemon, overriding
+// --azure-docker-port.
+func (d *Driver) SetEnginePort(port int) error {
+	d.DockerPort = port
+	return nil
+}
+
+// GetStateContext returns the state of the virtual machine role instance,
+// aborting the Azure calls in progress when ctx is done.
+func (d *Driver) GetStateContext(ctx context.Context) (s state.State, err error) {
+	err = d.WithContext(ctx, func() error {
+		s, err = d.GetState()
+		return err
+	})
+	return s, err
+}
+
 // GetState returns the state of the virtual machine role instance.
 func (d *Driver) GetState() (state.State, error) {
 	if err := d.checkLegacyDriver(true); err != nil {
 		return state.None, err
 	}
 
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return state.None, err
@@ -673,24 +891,48 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.None, err
 	}
 
+	if d.Spot && powerState == azureutil.Deallocated {
+		// an evicted Spot VM is deallocated, it can be started again once
+		// capacity is available at the configured max price
+		log.Debug("Spot virtual machine is deallocated, it might have been evicted.")
+	}
+
 	machineState := machineStateForVMPowerState(powerState)
 	log.Debugf("Determined Azure PowerState=%q, docker-machine state=%q",
 		powerState, machineState)
 	return machineState, nil
 }
 
+// StartContext issues a power on for the virtual machine instance, aborting
+// the Azure calls in progress when ctx is done.
+func (d *Driver) StartContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Start)
+}
+
 // Start issues a power on for the virtual machine instance.
 func (d *Driver) Start() error {
 	if err := d.checkLegacyDriver(true); err != nil {
 		return err
 	}
 
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
 	}
-	return c.StartVirtualMachine(ctx, d.ResourceGroup, d.naming().VM())
+	if err := c.StartVirtualMachine(ctx, d.ResourceGroup, d.naming().VM()); err != nil {
+		if d.Spot {
+			return fmt.Errorf("unable to start Spot virtual machine, there might be no capacity available at the configured max price: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// StopContext issues a power off for the virtual machine instance, aborting
+// the Azure calls in progress when ctx is done.
+func (d *Driver) StopContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Stop)
 }
 
 // Stop issues a power off for the virtual machine instance.
@@ -699,7 +941,7 @@ func (d *Driver) Stop() error {
 		return err
 	}
 
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
@@ -718,11 +960,24 @@ func (d *Driver) Restart() error {
 	// NOTE(ahmetalpbalkan) Azure will always keep the VM in Running state
 	// during the restart operation. Hence we rely on returned async operation
 	// polling to make sure the reboot is waited upon.
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
 	}
+
+	// A deallocated VM cannot be rebooted, which is where an evicted Spot VM
+	// ends up. Start it instead.
+	if d.Spot {
+		powerState, err := c.GetVirtualMachinePowerState(ctx, d.ResourceGroup, d.naming().VM())
+		if err != nil {
+			return err
+		}
+		if powerState == azureutil.Deallocated {
+			log.Info("Spot virtual machine is deallocated, starting it.")
+			return d.Start()
+		}
+	}
 	return c.RestartVirtualMachine(ctx, d.ResourceGroup, d.naming().VM())
 }
 
@@ -732,7 +987,7 @@ func (d *Driver) Kill() error {
 		return err
 	}
 
-	ctx := context.Background()
+	ctx := d.Context()
 	c, err := d.newAzureClient(ctx)
 	if err != nil {
 		return err
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/rancher/machine/libmachine/log"
@@ -21,7 +22,7 @@ const (
 	validateAuthorizerTimeout = time.Second * 5
 )
 
-// Azure driver allows two authentication methods:
+// Azure driver allows four authentication methods:
 //
 // 1. OAuth Device Flow
 //
@@ -43,6 +44,18 @@ const (
 // This is designed for headless authentication to Azure APIs but requires more
 // steps from user to create a Service Principal Account and provide its
 // credentials to the machine driver.
+//
+// 3. Managed Identity
+//
+// When running on an Azure resource with a system or user-assigned managed
+// identity, tokens are obtained from the local identity endpoint and no
+// credentials need to be configured or stored at all.
+//
+// 4. Workload Identity
+//
+// A federated OIDC token, such as the one projected into Kubernetes pods by
+// Azure Workload Identity, is exchanged for an access token of the app
+// registration or user-assigned identity trusting its issuer.
 
 var (
 	// AD app id for docker-machine driver in various Azure realms
@@ -134,6 +147,49 @@ func AuthenticateClientCredentials(ctx context.Context, env azure.Environment, s
 	return authorizer, nil
 }
 
+// AuthenticateManagedIdentity obtains a token from the managed identity endpoint
+// of the Azure resource the driver is running on. The system-assigned identity
+// is used unless the client ID of a user-assigned identity is given.
+func AuthenticateManagedIdentity(ctx context.Context, env azure.Environment, clientID string) (*autorest.BearerAuthorizer, error) {
+	servicePrincipalToken, err := adal.NewServicePrincipalTokenFromManagedIdentity(env.ResourceManagerEndpoint, &adal.ManagedIdentityOptions{
+		ClientID: clientID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	authorizer := autorest.NewBearerAuthorizer(servicePrincipalToken)
+	ValidateAuthorizer(ctx, env, authorizer)
+	return authorizer, nil
+}
+
+// AuthenticateWorkloadIdentity exchanges the federated token found in
+// tokenFile for a token of the given client. The file is read on every
+// authentication so rotated tokens are picked up.
+func AuthenticateWorkloadIdentity(ctx context.Context, env azure.Environment, subscriptionID, tenantID, clientID, tokenFile string) (*autorest.BearerAuthorizer, error) {
+	if tenantID == "" {
+		var err error
+		tenantID, err = loadOrFindTenantID(ctx, env, subscriptionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	jwt, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read federated token: %v", err)
+	}
+	oauthCfg, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to obtain oauth config for azure environment: %v", err)
+	}
+	servicePrincipalToken, err := adal.NewServicePrincipalTokenFromFederatedToken(*oauthCfg, clientID, strings.TrimSpace(string(jwt)), env.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	authorizer := autorest.NewBearerAuthorizer(servicePrincipalToken)
+	ValidateAuthorizer(ctx, env, authorizer)
+	return authorizer, nil
+}
+
 // ValidateAuthorizer makes a call to Azure SDK with given authorizer to make sure it is valid
 func ValidateAuthorizer(ctx context.Context, env azure.Environment, authorizer *autorest.BearerAuthorizer) error {
 	goCtx, cancel := context.WithTimeout(ctx, validateAuthorizerTimeout)
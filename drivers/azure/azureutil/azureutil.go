@@ -12,7 +12,7 @@ import (
 	"github.com/rancher/machine/drivers/azure/logutil"
 	"github.com/rancher/machine/libmachine/log"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-12-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2019-06-01/storage"
@@ -471,8 +471,18 @@ func (a AzureClient) DeleteVirtualMachineIfExists(ctx context.Context, resourceG
 		return err
 	}
 
-	// Remove disk
+	// Remove disks
 	if vmProperties := vm.VirtualMachineProperties; vmProperties != nil {
+		if dataDisks := vmProperties.StorageProfile.DataDisks; dataDisks != nil {
+			for _, disk := range *dataDisks {
+				if disk.ManagedDisk == nil {
+					continue
+				}
+				if err := a.removeManagedDisk(ctx, resourceGroup, to.String(vm.ID), to.String(disk.Name)); err != nil {
+					return err
+				}
+			}
+		}
 		if managedDisk := vmProperties.StorageProfile.OsDisk.ManagedDisk; managedDisk != nil {
 			return a.removeManagedDisk(ctx, resourceGroup, to.String(vm.ID), ResourceNaming(name).OSDisk())
 		}
@@ -561,10 +571,28 @@ func (a AzureClient) removeOSDiskBlob(ctx context.Context, resourceGroup, vmName
 	return nil
 }
 
+// SpotOptions configures a virtual machine to run as an Azure Spot VM.
+type SpotOptions struct {
+	// EvictionPolicy is either Deallocate or Delete.
+	EvictionPolicy string
+	// MaxPrice is the maximum hourly price in US dollars, -1 caps it at the
+	// on-demand price so the VM is never evicted for price reasons.
+	MaxPrice float64
+}
+
+// DataDisk is an empty managed disk attached to a virtual machine.
+type DataDisk struct {
+	SizeGB      int32
+	StorageType string
+	// IOPS and MBps can only be provisioned for UltraSSD_LRS disks
+	IOPS int64
+	MBps int64
+}
+
 // CreateVirtualMachine creates a VM according to the specifications and adds an SSH key to access the VM
 func (a AzureClient) CreateVirtualMachine(ctx context.Context, resourceGroup, name, location, size, availabilitySetID, networkInterfaceID,
 	username, sshPublicKey, imageName, imagePlan, customData string, storageAccount *storage.AccountProperties, isManaged bool,
-	storageType string, diskSize int32, tags map[string]*string, availabilityZone string) error {
+	storageType string, diskSize int32, tags map[string]*string, availabilityZone, proximityPlacementGroupID string, dataDisks []DataDisk, spot *SpotOptions) error {
 	// TODO: "VM created from Image cannot have blob based disks. All disks have to be managed disks."
 	imgReference, err := a.getImageReference(ctx, imageName, location)
 	if err != nil {
@@ -586,6 +614,7 @@ func (a AzureClient) CreateVirtualMachine(ctx context.Context, resourceGroup, na
 		"username": username,
 		"osImage":  imageName,
 		"plan":     imagePurchasePlan,
+		"spot":     spot != nil,
 	})
 
 	sshKeyPath := fmt.Sprintf("/home/%s/.ssh/authorized_keys", username)
@@ -640,12 +669,37 @@ func (a AzureClient) CreateVirtualMachine(ctx context.Context, resourceGroup, na
 	// in particular Availability Zones - you can only specify one or the other.
 	// if a user has provided an availability zone it is assumed that
 	// no availability sets should be created / used.
-	if availabilityZone == "" {
+	if availabilityZone != "" {
+		vm.Zones = to.StringSlicePtr([]string{availabilityZone})
+	} else if availabilitySetID != "" {
 		vm.VirtualMachineProperties.AvailabilitySet = &compute.SubResource{
 			ID: to.StringPtr(availabilitySetID),
 		}
-	} else {
-		vm.Zones = to.StringSlicePtr([]string{availabilityZone})
+	}
+
+	if len(dataDisks) > 0 {
+		vm.VirtualMachineProperties.StorageProfile.DataDisks = getDataDisks(name, dataDisks)
+		for _, disk := range dataDisks {
+			if disk.StorageType == string(compute.StorageAccountTypesUltraSSDLRS) {
+				vm.VirtualMachineProperties.AdditionalCapabilities = &compute.AdditionalCapabilities{
+					UltraSSDEnabled: to.BoolPtr(true),
+				}
+			}
+		}
+	}
+
+	if proximityPlacementGroupID != "" {
+		vm.VirtualMachineProperties.ProximityPlacementGroup = &compute.SubResource{
+			ID: to.StringPtr(proximityPlacementGroupID),
+		}
+	}
+
+	if spot != nil {
+		vm.VirtualMachineProperties.Priority = compute.Spot
+		vm.VirtualMachineProperties.EvictionPolicy = compute.VirtualMachineEvictionPolicyTypes(spot.EvictionPolicy)
+		vm.VirtualMachineProperties.BillingProfile = &compute.BillingProfile{
+			MaxPrice: to.Float64Ptr(spot.MaxPrice),
+		}
 	}
 
 	future, err := virtualMachinesClient.CreateOrUpdate(ctx, resourceGroup, name, vm)
@@ -659,24 +713,63 @@ func (a AzureClient) CreateVirtualMachine(ctx context.Context, resourceGroup, na
 	return err
 }
 
-// getImageReference parses a publisher:offer:sku:version or parses the string as a custom image reference
-func (a AzureClient) getImageReference(ctx context.Context, image, location string) (*compute.ImageReference, error) {
-	if strings.Contains(strings.ToLower(image), "/images/") {
-		// image represents an ARM resource identifer for a custom image
-		return &compute.ImageReference{
-			ID: to.StringPtr(image),
-		}, nil
+// VMExtension is a virtual machine extension installed after the VM has been
+// created, e.g. CustomScript or a monitoring agent.
+type VMExtension struct {
+	Name               string
+	Publisher          string
+	Type               string
+	TypeHandlerVersion string
+	Settings           map[string]interface{}
+	ProtectedSettings  map[string]interface{}
+}
+
+// CreateVirtualMachineExtension installs the extension on the virtual machine
+// and waits until it has been provisioned.
+func (a AzureClient) CreateVirtualMachineExtension(ctx context.Context, resourceGroup, vmName, location string, ext VMExtension) error {
+	log.Info("Installing virtual machine extension.", logutil.Fields{
+		"vm":        vmName,
+		"name":      ext.Name,
+		"publisher": ext.Publisher,
+		"type":      ext.Type,
+		"version":   ext.TypeHandlerVersion,
+	})
+
+	props := &compute.VirtualMachineExtensionProperties{
+		Publisher:               to.StringPtr(ext.Publisher),
+		Type:                    to.StringPtr(ext.Type),
+		TypeHandlerVersion:      to.StringPtr(ext.TypeHandlerVersion),
+		AutoUpgradeMinorVersion: to.BoolPtr(true),
 	}
-	if urn := strings.Split(image, ":"); len(urn) == 4 {
-		// image represents an ARM resource identifier for a gallery image version
-		return &compute.ImageReference{
-			Publisher: to.StringPtr(urn[0]),
-			Offer:     to.StringPtr(urn[1]),
-			Sku:       to.StringPtr(urn[2]),
-			Version:   to.StringPtr(urn[3]),
-		}, nil
+	if ext.Settings != nil {
+		props.Settings = ext.Settings
 	}
-	return nil, fmt.Errorf("image provided must be an image URN or an ARM resource identifier")
+	if ext.ProtectedSettings != nil {
+		props.ProtectedSettings = ext.ProtectedSettings
+	}
+
+	extensionsClient := a.virtualMachineExtensionsClient()
+	future, err := extensionsClient.CreateOrUpdate(ctx, resourceGroup, vmName, ext.Name, compute.VirtualMachineExtension{
+		Location:                          to.StringPtr(location),
+		VirtualMachineExtensionProperties: props,
+	})
+	if err != nil {
+		return err
+	}
+	if err = future.WaitForCompletionRef(ctx, extensionsClient.Client); err != nil {
+		return err
+	}
+	_, err = future.Result(extensionsClient)
+	return err
+}
+
+// getImageReference parses the image, resolving the latest version of a gallery image
+func (a AzureClient) getImageReference(ctx context.Context, image, location string) (*compute.ImageReference, error) {
+	image, err := a.resolveGalleryImageVersion(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImageReference(image)
 }
 
 // getImagePurchasePlan parses a publisher:product:plan as a image purchase plan reference
@@ -721,6 +814,31 @@ func getOSDisk(name string, account *storage.AccountProperties, isManaged bool,
 	return osdisk
 }
 
+// getDataDisks creates the empty managed data disks of the VM, numbered by LUN
+// in the order they were given.
+func getDataDisks(name string, dataDisks []DataDisk) *[]compute.DataDisk {
+	disks := make([]compute.DataDisk, 0, len(dataDisks))
+	for lun, d := range dataDisks {
+		disk := compute.DataDisk{
+			Lun:          to.Int32Ptr(int32(lun)),
+			Name:         to.StringPtr(ResourceNaming(name).DataDisk(lun)),
+			CreateOption: compute.DiskCreateOptionTypesEmpty,
+			DiskSizeGB:   to.Int32Ptr(d.SizeGB),
+			ManagedDisk: &compute.ManagedDiskParameters{
+				StorageAccountType: compute.StorageAccountTypes(d.StorageType),
+			},
+		}
+		if d.IOPS > 0 {
+			disk.DiskIOPSReadWrite = to.Int64Ptr(d.IOPS)
+		}
+		if d.MBps > 0 {
+			disk.DiskMBpsReadWrite = to.Int64Ptr(d.MBps)
+		}
+		disks = append(disks, disk)
+	}
+	return &disks
+}
+
 // GetVirtualMachinePowerState returns the VM's power state
 func (a AzureClient) GetVirtualMachinePowerState(ctx context.Context, resourceGroup, name string) (VMPowerState, error) {
 	log.Debug("Querying instance view for power state.")
@@ -735,6 +853,11 @@ func (a AzureClient) GetVirtualMachinePowerState(ctx context.Context, resourceGr
 // CreateAvailabilitySetIfNotExists checks that managed disk option match availability set if it already exists. If the
 // availability set does not already exists than it is created with configured parameters.
 func (a AzureClient) CreateAvailabilitySetIfNotExists(ctx context.Context, deploymentCtx *DeploymentContext, resourceGroup, name, location string, isManaged bool, faultCount int32, updateCount int32) error {
+	var ppg *compute.SubResource
+	if deploymentCtx.ProximityPlacementGroupID != "" {
+		ppg = &compute.SubResource{ID: to.StringPtr(deploymentCtx.ProximityPlacementGroupID)}
+	}
+
 	var avSet compute.AvailabilitySet
 	f := logutil.Fields{"name": name}
 	log.Info("Configuring availability set.", f)
@@ -758,6 +881,7 @@ func (a AzureClient) CreateAvailabilitySetIfNotExists(ctx context.Context, deplo
 				AvailabilitySetProperties: &compute.AvailabilitySetProperties{
 					PlatformFaultDomainCount:  to.Int32Ptr(faultCount),
 					PlatformUpdateDomainCount: to.Int32Ptr(updateCount),
+					ProximityPlacementGroup:   ppg,
 				},
 				Sku: &compute.Sku{
 					Name: to.StringPtr(skuName),
@@ -779,12 +903,57 @@ func (a AzureClient) CreateAvailabilitySetIfNotExists(ctx context.Context, deplo
 		if !isManaged && to.String(avSet.Sku.Name) != "Classic" {
 			return fmt.Errorf("cannot convert managed availability set %s to non-managed availability set", name)
 		}
+		if ppg != nil {
+			props := avSet.AvailabilitySetProperties
+			if props == nil || props.ProximityPlacementGroup == nil || !strings.EqualFold(to.String(props.ProximityPlacementGroup.ID), to.String(ppg.ID)) {
+				return fmt.Errorf("existing availability set %s is not in proximity placement group %s", name, to.String(ppg.ID))
+			}
+		}
 	}
 
 	deploymentCtx.AvailabilitySetID = to.String(avSet.ID)
 	return nil
 }
 
+// CreateProximityPlacementGroupIfNotExists creates a standard proximity
+// placement group unless it already exists, and saves its ID to the
+// deployment context.
+func (a AzureClient) CreateProximityPlacementGroupIfNotExists(ctx context.Context, deploymentCtx *DeploymentContext, resourceGroup, name, location string) error {
+	f := logutil.Fields{"name": name}
+	log.Info("Configuring proximity placement group.", f)
+
+	ppgCleanupInfo := &ppgCleanup{rg: resourceGroup, name: name}
+	err := ppgCleanupInfo.Get(ctx, a)
+	exists, err := checkResourceExistsFromError(err)
+	if err != nil {
+		return fmt.Errorf("error getting proximity placement group: %v", err)
+	}
+
+	ppg := ppgCleanupInfo.ref
+	if !exists {
+		log.Debug("Proximity placement group does not exist, creating.", f)
+		ppg, err = a.proximityPlacementGroupsClient().CreateOrUpdate(ctx, resourceGroup, name,
+			compute.ProximityPlacementGroup{
+				Location: to.StringPtr(location),
+				ProximityPlacementGroupProperties: &compute.ProximityPlacementGroupProperties{
+					ProximityPlacementGroupType: compute.Standard,
+				},
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	deploymentCtx.ProximityPlacementGroupID = to.String(ppg.ID)
+	return nil
+}
+
+// CleanupProximityPlacementGroupIfExists removes a proximity placement group
+// if no virtual machines or availability sets are placed in it anymore.
+func (a AzureClient) CleanupProximityPlacementGroupIfExists(ctx context.Context, resourceGroup, name string) error {
+	return a.cleanupResourceIfExists(ctx, &ppgCleanup{rg: resourceGroup, name: name})
+}
+
 // CleanupAvailabilitySetIfExists removes an availability set if there are no
 // virtual machines attached to it. Note that this method is not safe for
 // multiple concurrent writers, in case of races, deployment of a machine could
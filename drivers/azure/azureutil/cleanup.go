@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-12-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 	"github.com/rancher/machine/drivers/azure/logutil"
 	"github.com/rancher/machine/libmachine/log"
@@ -154,6 +154,38 @@ func (c *avSetCleanup) CanBeDeleted(ctx context.Context, a AzureClient) bool {
 	return c.ref.AvailabilitySetProperties.VirtualMachines == nil || len(*c.ref.AvailabilitySetProperties.VirtualMachines) == 0
 }
 
+// ppgCleanup manages cleanup of Proximity Placement Group resources.
+type ppgCleanup struct {
+	rg, name string
+	ref      compute.ProximityPlacementGroup
+}
+
+func (c *ppgCleanup) Get(ctx context.Context, a AzureClient) (err error) {
+	serviceClient := a.proximityPlacementGroupsClient()
+	c.ref, err = serviceClient.Get(ctx, c.rg, c.name, "")
+	return err
+}
+
+func (c *ppgCleanup) Delete(ctx context.Context, a AzureClient) error {
+	serviceClient := a.proximityPlacementGroupsClient()
+	_, err := serviceClient.Delete(ctx, c.rg, c.name)
+	return err
+}
+
+func (c *ppgCleanup) ResourceType() string { return "Proximity Placement Group" }
+
+func (c *ppgCleanup) LogFields() logutil.Fields { return logutil.Fields{"name": c.name} }
+
+func (c *ppgCleanup) CanBeDeleted(ctx context.Context, a AzureClient) bool {
+	c.Get(ctx, a) // updates c.ref
+	props := c.ref.ProximityPlacementGroupProperties
+	if props == nil {
+		return true
+	}
+	return (props.VirtualMachines == nil || len(*props.VirtualMachines) == 0) &&
+		(props.AvailabilitySets == nil || len(*props.AvailabilitySets) == 0)
+}
+
 type nsgCleanup struct {
 	rg, name   string
 	usedInPool bool
@@ -274,7 +306,7 @@ func (c *vmCleanup) Get(ctx context.Context, a AzureClient) (err error) {
 
 func (c *vmCleanup) Delete(ctx context.Context, a AzureClient) error {
 	serviceClient := a.virtualMachinesClient()
-	future, err := serviceClient.Delete(ctx, c.rg, c.name)
+	future, err := serviceClient.Delete(ctx, c.rg, c.name, nil)
 	if err != nil {
 		return err
 	}
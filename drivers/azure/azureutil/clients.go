@@ -6,7 +6,7 @@ import (
 
 	"github.com/rancher/machine/version"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-12-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-11-01/subscriptions"
@@ -140,6 +140,16 @@ func (a AzureClient) virtualMachinesClient() compute.VirtualMachinesClient {
 	return c
 }
 
+func (a AzureClient) virtualMachineExtensionsClient() compute.VirtualMachineExtensionsClient {
+	c := compute.NewVirtualMachineExtensionsClientWithBaseURI(a.env.ResourceManagerEndpoint, a.subscriptionID)
+	c.Authorizer = a.auth
+	c.Client.UserAgent += fmt.Sprintf(";docker-machine/%s", version.Version)
+	c.RequestInspector = withInspection()
+	c.ResponseInspector = byInspecting()
+	c.PollingDelay = defaultClientPollingDelay
+	return c
+}
+
 func (a AzureClient) availabilitySetsClient() compute.AvailabilitySetsClient {
 	c := compute.NewAvailabilitySetsClientWithBaseURI(a.env.ResourceManagerEndpoint, a.subscriptionID)
 	c.Authorizer = a.auth
@@ -150,6 +160,16 @@ func (a AzureClient) availabilitySetsClient() compute.AvailabilitySetsClient {
 	return c
 }
 
+func (a AzureClient) proximityPlacementGroupsClient() compute.ProximityPlacementGroupsClient {
+	c := compute.NewProximityPlacementGroupsClientWithBaseURI(a.env.ResourceManagerEndpoint, a.subscriptionID)
+	c.Authorizer = a.auth
+	c.Client.UserAgent += fmt.Sprintf(";docker-machine/%s", version.Version)
+	c.RequestInspector = withInspection()
+	c.ResponseInspector = byInspecting()
+	c.PollingDelay = defaultClientPollingDelay
+	return c
+}
+
 func (a AzureClient) imagesClient() compute.ImagesClient {
 	c := compute.NewImagesClientWithBaseURI(a.env.ResourceManagerEndpoint, a.subscriptionID)
 	c.Authorizer = a.auth
@@ -160,8 +180,10 @@ func (a AzureClient) imagesClient() compute.ImagesClient {
 	return c
 }
 
-func (a AzureClient) galleryImageVersionsClient() compute.GalleryImageVersionsClient {
-	c := compute.NewGalleryImageVersionsClientWithBaseURI(a.env.ResourceManagerEndpoint, a.subscriptionID)
+// galleryImageVersionsClient takes the subscription of the gallery, which may
+// be shared from another subscription.
+func (a AzureClient) galleryImageVersionsClient(subscriptionID string) compute.GalleryImageVersionsClient {
+	c := compute.NewGalleryImageVersionsClientWithBaseURI(a.env.ResourceManagerEndpoint, subscriptionID)
 	c.Authorizer = a.auth
 	c.Client.UserAgent += fmt.Sprintf(";docker-machine/%s", version.Version)
 	c.RequestInspector = withInspection()
@@ -8,13 +8,14 @@ import (
 // DeploymentContext contains references to various sources created and then
 // used in creating other resources.
 type DeploymentContext struct {
-	VirtualNetworkExists   bool
-	StorageAccount         *storage.AccountProperties
-	PublicIPAddressID      string
-	NetworkSecurityGroupID string
-	SubnetID               string
-	NetworkInterfaceID     string
-	SSHPublicKey           string
-	AvailabilitySetID      string
-	FirewallRules          *[]network.SecurityRule
+	VirtualNetworkExists      bool
+	StorageAccount            *storage.AccountProperties
+	PublicIPAddressID         string
+	NetworkSecurityGroupID    string
+	SubnetID                  string
+	NetworkInterfaceID        string
+	SSHPublicKey              string
+	AvailabilitySetID         string
+	ProximityPlacementGroupID string
+	FirewallRules             *[]network.SecurityRule
 }
@@ -0,0 +1,166 @@
+package azureutil
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// latestVersion selects the newest version of a gallery image, as Azure does
+// when a VM is created from the image definition.
+const latestVersion = "latest"
+
+var (
+	galleryImageRe = regexp.MustCompile(`(?i)^/subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/galleries/([^/]+)/images/([^/]+)(?:/versions/([^/]+))?$`)
+	// community and direct shared gallery images are referenced by their
+	// public name, e.g. /CommunityGalleries/name/Images/image/Versions/1.0.0
+	publicGalleryImageRe = regexp.MustCompile(`(?i)^/(communityGalleries|sharedGalleries)/[^/]+/images/[^/]+(/versions/[^/]+)?$`)
+)
+
+// GalleryImage is an image definition of an Azure Compute Gallery and one of
+// its versions, if given.
+type GalleryImage struct {
+	SubscriptionID string
+	ResourceGroup  string
+	Gallery        string
+	Image          string
+	Version        string
+}
+
+// ParseGalleryImage parses the resource ID of a gallery image definition or
+// version. It returns nil if image is not such an ID.
+func ParseGalleryImage(image string) *GalleryImage {
+	m := galleryImageRe.FindStringSubmatch(image)
+	if m == nil {
+		return nil
+	}
+	return &GalleryImage{
+		SubscriptionID: m[1],
+		ResourceGroup:  m[2],
+		Gallery:        m[3],
+		Image:          m[4],
+		Version:        m[5],
+	}
+}
+
+// ID returns the resource ID of the image version, or of the image definition
+// if no version is given.
+func (g GalleryImage) ID() string {
+	id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s",
+		g.SubscriptionID, g.ResourceGroup, g.Gallery, g.Image)
+	if g.Version != "" {
+		id += "/versions/" + g.Version
+	}
+	return id
+}
+
+// ParseImageReference parses an image given as a publisher:offer:sku:version
+// URN, the resource ID of a custom image or of a gallery image, or the ID of
+// a community or direct shared gallery image. Gallery images without a
+// version use the latest one.
+func ParseImageReference(image string) (*compute.ImageReference, error) {
+	if m := publicGalleryImageRe.FindStringSubmatch(image); m != nil {
+		id := image
+		if m[2] == "" {
+			id += "/Versions/" + latestVersion
+		}
+		if strings.EqualFold(m[1], "communityGalleries") {
+			return &compute.ImageReference{CommunityGalleryImageID: to.StringPtr(id)}, nil
+		}
+		return &compute.ImageReference{SharedGalleryImageID: to.StringPtr(id)}, nil
+	}
+	if strings.Contains(strings.ToLower(image), "/images/") {
+		// image represents an ARM resource identifer for a custom image or
+		// a gallery image
+		return &compute.ImageReference{
+			ID: to.StringPtr(image),
+		}, nil
+	}
+	if urn := strings.Split(image, ":"); len(urn) == 4 {
+		return &compute.ImageReference{
+			Publisher: to.StringPtr(urn[0]),
+			Offer:     to.StringPtr(urn[1]),
+			Sku:       to.StringPtr(urn[2]),
+			Version:   to.StringPtr(urn[3]),
+		}, nil
+	}
+	return nil, fmt.Errorf("image provided must be an image URN, an ARM resource identifier or a community or shared gallery image identifier")
+}
+
+// IsGalleryImage tells whether image is an image of a gallery, private,
+// community or shared.
+func IsGalleryImage(image string) bool {
+	return ParseGalleryImage(image) != nil || publicGalleryImageRe.MatchString(image)
+}
+
+// resolveGalleryImageVersion returns the ID of the latest version of a
+// gallery image if no version or "latest" is given, so that the version the
+// VM is created from is logged. Other images are returned as is.
+func (a AzureClient) resolveGalleryImageVersion(ctx context.Context, image string) (string, error) {
+	g := ParseGalleryImage(image)
+	if g == nil || (g.Version != "" && !strings.EqualFold(g.Version, latestVersion)) {
+		return image, nil
+	}
+
+	var versions []compute.GalleryImageVersion
+	it, err := a.galleryImageVersionsClient(g.SubscriptionID).ListByGalleryImageComplete(ctx, g.ResourceGroup, g.Gallery, g.Image)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the versions of gallery image %s: %v", g.Image, err)
+	}
+	for ; it.NotDone(); err = it.NextWithContext(ctx) {
+		if err != nil {
+			return "", fmt.Errorf("failed to list the versions of gallery image %s: %v", g.Image, err)
+		}
+		versions = append(versions, it.Value())
+	}
+
+	if g.Version, err = latestGalleryImageVersion(versions); err != nil {
+		return "", fmt.Errorf("gallery image %s: %v", g.Image, err)
+	}
+	log.Infof("Using version %s of gallery image %s.", g.Version, g.Image)
+	return g.ID(), nil
+}
+
+// latestGalleryImageVersion returns the highest of the succeeded versions
+// not excluded from latest.
+func latestGalleryImageVersion(versions []compute.GalleryImageVersion) (string, error) {
+	latest := ""
+	for _, v := range versions {
+		if v.Name == nil || v.GalleryImageVersionProperties == nil {
+			continue
+		}
+		if v.ProvisioningState != compute.GalleryProvisioningStateSucceeded {
+			continue
+		}
+		if p := v.PublishingProfile; p != nil && to.Bool(p.ExcludeFromLatest) {
+			continue
+		}
+		if latest == "" || compareGalleryImageVersions(*v.Name, latest) > 0 {
+			latest = *v.Name
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no version available")
+	}
+	return latest, nil
+}
+
+// compareGalleryImageVersions compares versions in the major.minor.patch
+// format of gallery images.
+func compareGalleryImageVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, _ := strconv.Atoi(as[i])
+		bn, _ := strconv.Atoi(bs[i])
+		if an != bn {
+			return an - bn
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,92 @@
+package azureutil
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+)
+
+const galleryImage = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/ubuntu"
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		image       string
+		expected    *compute.ImageReference
+		expectedErr bool
+	}{
+		{"canonical:UbuntuServer:18.04-LTS:latest", &compute.ImageReference{
+			Publisher: to.StringPtr("canonical"),
+			Offer:     to.StringPtr("UbuntuServer"),
+			Sku:       to.StringPtr("18.04-LTS"),
+			Version:   to.StringPtr("latest"),
+		}, false},
+		{"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/custom", &compute.ImageReference{
+			ID: to.StringPtr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/custom"),
+		}, false},
+		{galleryImage + "/versions/1.0.0", &compute.ImageReference{
+			ID: to.StringPtr(galleryImage + "/versions/1.0.0"),
+		}, false},
+		{"/CommunityGalleries/public-1234/Images/ubuntu/Versions/1.0.0", &compute.ImageReference{
+			CommunityGalleryImageID: to.StringPtr("/CommunityGalleries/public-1234/Images/ubuntu/Versions/1.0.0"),
+		}, false},
+		{"/CommunityGalleries/public-1234/Images/ubuntu", &compute.ImageReference{
+			CommunityGalleryImageID: to.StringPtr("/CommunityGalleries/public-1234/Images/ubuntu/Versions/latest"),
+		}, false},
+		{"/SharedGalleries/shared-1234/Images/ubuntu/Versions/latest", &compute.ImageReference{
+			SharedGalleryImageID: to.StringPtr("/SharedGalleries/shared-1234/Images/ubuntu/Versions/latest"),
+		}, false},
+		{"ubuntu", nil, true},
+	}
+
+	for _, tc := range tests {
+		ref, err := ParseImageReference(tc.image)
+		assert.Equal(t, tc.expected, ref, tc.image)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.image)
+		} else {
+			assert.NoError(t, err, tc.image)
+		}
+	}
+}
+
+func TestParseGalleryImage(t *testing.T) {
+	g := ParseGalleryImage(galleryImage + "/versions/latest")
+	assert.Equal(t, &GalleryImage{SubscriptionID: "sub", ResourceGroup: "rg", Gallery: "gallery", Image: "ubuntu", Version: "latest"}, g)
+
+	g.Version = "1.2.3"
+	assert.Equal(t, galleryImage+"/versions/1.2.3", g.ID())
+
+	assert.Nil(t, ParseGalleryImage("canonical:UbuntuServer:18.04-LTS:latest"))
+	assert.True(t, IsGalleryImage(galleryImage))
+	assert.True(t, IsGalleryImage("/CommunityGalleries/public-1234/Images/ubuntu"))
+	assert.False(t, IsGalleryImage("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/custom"))
+}
+
+func TestLatestGalleryImageVersion(t *testing.T) {
+	version := func(name string, state compute.GalleryProvisioningState, excluded bool) compute.GalleryImageVersion {
+		return compute.GalleryImageVersion{
+			Name: to.StringPtr(name),
+			GalleryImageVersionProperties: &compute.GalleryImageVersionProperties{
+				ProvisioningState: state,
+				PublishingProfile: &compute.GalleryImageVersionPublishingProfile{ExcludeFromLatest: to.BoolPtr(excluded)},
+			},
+		}
+	}
+
+	latest, err := latestGalleryImageVersion([]compute.GalleryImageVersion{
+		version("1.2.0", compute.GalleryProvisioningStateSucceeded, false),
+		version("1.10.0", compute.GalleryProvisioningStateSucceeded, false),
+		version("1.9.0", compute.GalleryProvisioningStateSucceeded, false),
+		version("2.0.0", compute.GalleryProvisioningStateSucceeded, true),
+		version("2.1.0", compute.GalleryProvisioningStateFailed, false),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.10.0", latest)
+
+	_, err = latestGalleryImageVersion([]compute.GalleryImageVersion{
+		version("1.0.0", compute.GalleryProvisioningStateCreating, false),
+	})
+	assert.Error(t, err)
+}
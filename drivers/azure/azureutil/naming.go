@@ -12,6 +12,7 @@ const (
 	fmtOSDisk          = "%s-os-disk"
 	fmtOSDiskContainer = "vhd-%s" // place vhds of VMs in separate containers for ease of cleanup
 	fmtOSDiskBlob      = "%s-os-disk.vhd"
+	fmtDataDisk        = "%s-data-disk-%d"
 )
 
 // ResourceNaming provides methods to construct Azure resource names for a given
@@ -38,3 +39,6 @@ func (r ResourceNaming) OSDiskContainer() string { return fmt.Sprintf(fmtOSDiskC
 
 // OSDiskBlob returns the Azure resource name for an OS disk blob
 func (r ResourceNaming) OSDiskBlob() string { return fmt.Sprintf(fmtOSDiskBlob, r) }
+
+// DataDisk returns the Azure resource name for the data disk at the given LUN
+func (r ResourceNaming) DataDisk(lun int) string { return fmt.Sprintf(fmtDataDisk, r, lun) }
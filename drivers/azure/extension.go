@@ -0,0 +1,98 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rancher/machine/drivers/azure/azureutil"
+	"github.com/rancher/machine/libmachine/mcnutils"
+)
+
+// VMExtension is a VM extension requested on the command line. Settings are
+// kept as file paths so protected settings, which commonly hold secrets, are
+// never written to the machine config.
+type VMExtension struct {
+	Name                  string
+	Publisher             string
+	Type                  string
+	TypeHandlerVersion    string
+	SettingsFile          string
+	ProtectedSettingsFile string
+}
+
+// parseVMExtension parses a VM extension spec given as comma separated
+// key=value pairs, e.g.
+// 'publisher=Microsoft.Azure.Extensions,type=CustomScript,version=2.1,settings=script.json'.
+// The publisher, type and version are mandatory, the name defaults to the type.
+func parseVMExtension(spec string) (VMExtension, error) {
+	ext := VMExtension{}
+
+	options, err := mcnutils.ParseOptions("VM extension", spec)
+	if err != nil {
+		return ext, err
+	}
+	for _, option := range options {
+		key, value := option.Key, option.Value
+		switch key {
+		case "name":
+			ext.Name = value
+		case "publisher":
+			ext.Publisher = value
+		case "type":
+			ext.Type = value
+		case "version":
+			ext.TypeHandlerVersion = value
+		case "settings":
+			ext.SettingsFile = value
+		case "protected-settings":
+			ext.ProtectedSettingsFile = value
+		default:
+			return ext, fmt.Errorf("unknown VM extension option %q in %q", key, spec)
+		}
+	}
+
+	if ext.Publisher == "" || ext.Type == "" || ext.TypeHandlerVersion == "" {
+		return ext, fmt.Errorf("VM extension %q requires a publisher, type and version", spec)
+	}
+	if ext.Name == "" {
+		ext.Name = ext.Type
+	}
+	return ext, nil
+}
+
+// load reads the settings files of the extension.
+func (e VMExtension) load() (azureutil.VMExtension, error) {
+	ext := azureutil.VMExtension{
+		Name:               e.Name,
+		Publisher:          e.Publisher,
+		Type:               e.Type,
+		TypeHandlerVersion: e.TypeHandlerVersion,
+	}
+
+	var err error
+	if ext.Settings, err = readExtensionSettings(e.SettingsFile); err != nil {
+		return ext, err
+	}
+	if ext.ProtectedSettings, err = readExtensionSettings(e.ProtectedSettingsFile); err != nil {
+		return ext, err
+	}
+	return ext, nil
+}
+
+// readExtensionSettings reads a JSON object from file, an empty path yields
+// no settings.
+func readExtensionSettings(file string) (map[string]interface{}, error) {
+	if file == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &settings); err != nil {
+		return nil, fmt.Errorf("VM extension settings in %s must be a JSON object: %v", file, err)
+	}
+	return settings, nil
+}
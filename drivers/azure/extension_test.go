@@ -0,0 +1,60 @@
+package azure
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVMExtension(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    VMExtension
+		expectedErr bool
+	}{
+		{
+			"publisher=Microsoft.Azure.Extensions,type=CustomScript,version=2.1,settings=script.json,protected-settings=secret.json",
+			VMExtension{Name: "CustomScript", Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript", TypeHandlerVersion: "2.1", SettingsFile: "script.json", ProtectedSettingsFile: "secret.json"},
+			false,
+		},
+		{
+			"name=aad,publisher=Microsoft.Azure.ActiveDirectory,type=AADSSHLoginForLinux,version=1.0",
+			VMExtension{Name: "aad", Publisher: "Microsoft.Azure.ActiveDirectory", Type: "AADSSHLoginForLinux", TypeHandlerVersion: "1.0"},
+			false,
+		},
+		{"publisher=Microsoft.Azure.Extensions,type=CustomScript", VMExtension{}, true},
+		{"publisher=Microsoft.Azure.Extensions,type=CustomScript,version", VMExtension{}, true},
+		{"publisher=Microsoft.Azure.Extensions,type=CustomScript,version=2.1,foo=bar", VMExtension{}, true},
+	}
+
+	for _, tc := range tests {
+		ext, err := parseVMExtension(tc.spec)
+		if tc.expectedErr {
+			assert.Error(t, err, tc.spec)
+		} else {
+			assert.NoError(t, err, tc.spec)
+			assert.Equal(t, tc.expected, ext)
+		}
+	}
+}
+
+func TestVMExtensionLoad(t *testing.T) {
+	dir := t.TempDir()
+	settings := filepath.Join(dir, "settings.json")
+	assert.NoError(t, ioutil.WriteFile(settings, []byte(`{"commandToExecute": "echo hello"}`), 0600))
+	invalid := filepath.Join(dir, "invalid.json")
+	assert.NoError(t, ioutil.WriteFile(invalid, []byte(`["echo hello"]`), 0600))
+
+	ext, err := VMExtension{Name: "CustomScript", SettingsFile: settings}.load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"commandToExecute": "echo hello"}, ext.Settings)
+	assert.Nil(t, ext.ProtectedSettings)
+
+	_, err = VMExtension{Name: "CustomScript", ProtectedSettingsFile: invalid}.load()
+	assert.Error(t, err)
+
+	_, err = VMExtension{Name: "CustomScript", SettingsFile: filepath.Join(dir, "missing.json")}.load()
+	assert.Error(t, err)
+}
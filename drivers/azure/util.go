@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
@@ -14,6 +16,7 @@ import (
 	"github.com/rancher/machine/drivers/azure/logutil"
 	"github.com/rancher/machine/drivers/driverutil"
 	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 )
@@ -47,7 +50,19 @@ func (d *Driver) newAzureClient(ctx context.Context) (*azureutil.AzureClient, er
 	var (
 		authorizer *autorest.BearerAuthorizer
 	)
-	if d.ClientID != "" && d.ClientSecret != "" { // use client credentials auth
+	if d.UseManagedIdentity { // use managed identity of the host
+		log.Debug("Using Azure managed identity.")
+		authorizer, err = azureutil.AuthenticateManagedIdentity(ctx, env, d.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to authenticate using managed identity: %+v", err)
+		}
+	} else if d.FederatedTokenFile != "" { // use workload identity
+		log.Debug("Using Azure workload identity.")
+		authorizer, err = azureutil.AuthenticateWorkloadIdentity(ctx, env, d.SubscriptionID, d.TenantID, d.ClientID, d.FederatedTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to authenticate using workload identity: %+v", err)
+		}
+	} else if d.ClientID != "" && d.ClientSecret != "" { // use client credentials auth
 		log.Debug("Using Azure client credentials.")
 		authorizer, err = azureutil.AuthenticateClientCredentials(ctx, env, d.SubscriptionID, d.TenantID, d.ClientID, d.ClientSecret)
 		if err != nil {
@@ -63,6 +78,21 @@ func (d *Driver) newAzureClient(ctx context.Context) (*azureutil.AzureClient, er
 	return azureutil.New(env, d.SubscriptionID, authorizer), nil
 }
 
+// validateAuthOptions makes sure at most one of the credential based
+// authentication methods is configured.
+func (d *Driver) validateAuthOptions() error {
+	if d.UseManagedIdentity && d.FederatedTokenFile != "" {
+		return fmt.Errorf("--%s and --%s cannot be used together", flAzureUseManagedIdentity, flAzureFederatedTokenFile)
+	}
+	if (d.UseManagedIdentity || d.FederatedTokenFile != "") && d.ClientSecret != "" {
+		return fmt.Errorf("--%s cannot be used with managed or workload identity authentication", flAzureClientSecret)
+	}
+	if d.FederatedTokenFile != "" && d.ClientID == "" {
+		return requiredOptionError(flAzureClientID)
+	}
+	return nil
+}
+
 // generateSSHKey creates a ssh key pair locally and saves the public key file
 // contents in OpenSSH format to the DeploymentContext.
 func (d *Driver) generateSSHKey(deploymentCtx *azureutil.DeploymentContext) error {
@@ -190,6 +220,92 @@ func (d *Driver) resolveNSGReference(nsg string) (azure.Resource, error) {
 	}, nil
 }
 
+// useAvailabilitySet reports whether the machine is placed in an availability
+// set, which is not the case when it is pinned to an availability zone.
+func (d *Driver) useAvailabilitySet() bool {
+	return d.AvailabilityZone == "" && d.AvailabilitySet != ""
+}
+
+// isResourceID reports whether name is an ARM resource identifier rather than
+// the name of a resource in the machine's resource group.
+func isResourceID(name string) bool {
+	return strings.Contains(name, "/")
+}
+
+// parseDataDisk parses a data disk spec given as comma separated key=value
+// pairs, e.g. 'size=256,type=UltraSSD_LRS,iops=5000,mbps=200'. The size is
+// mandatory, the type defaults to defaultType.
+func parseDataDisk(spec, defaultType string) (azureutil.DataDisk, error) {
+	disk := azureutil.DataDisk{StorageType: defaultType}
+
+	options, err := mcnutils.ParseOptions("data disk", spec)
+	if err != nil {
+		return disk, err
+	}
+	for _, option := range options {
+		key, value := option.Key, option.Value
+		var err error
+		switch key {
+		case "size":
+			var size int64
+			size, err = strconv.ParseInt(value, 10, 32)
+			disk.SizeGB = int32(size)
+		case "type":
+			disk.StorageType = value
+		case "iops":
+			disk.IOPS, err = strconv.ParseInt(value, 10, 64)
+		case "mbps":
+			disk.MBps, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return disk, fmt.Errorf("unknown data disk option %q in %q", key, spec)
+		}
+		if err != nil {
+			return disk, fmt.Errorf("invalid value for data disk option %q in %q: %s", key, spec, err)
+		}
+	}
+
+	if disk.SizeGB <= 0 {
+		return disk, fmt.Errorf("data disk %q requires a size greater than 0", spec)
+	}
+	if (disk.IOPS > 0 || disk.MBps > 0) && disk.StorageType != string(compute.StorageAccountTypesUltraSSDLRS) {
+		return disk, fmt.Errorf("data disk %q: iops and mbps can only be set for %s disks", spec, compute.StorageAccountTypesUltraSSDLRS)
+	}
+	return disk, nil
+}
+
+// spotOptions returns the Spot configuration of the virtual machine, or nil
+// if it should be created with regular priority.
+func (d *Driver) spotOptions() *azureutil.SpotOptions {
+	if !d.Spot {
+		return nil
+	}
+	return &azureutil.SpotOptions{
+		EvictionPolicy: d.SpotEvictionPolicy,
+		MaxPrice:       d.SpotMaxPrice,
+	}
+}
+
+// parseSpotEvictionPolicy matches the eviction policy case-insensitively
+// against the policies supported by Azure.
+func parseSpotEvictionPolicy(policy string) (string, error) {
+	for _, p := range compute.PossibleVirtualMachineEvictionPolicyTypesValues() {
+		if strings.EqualFold(policy, string(p)) {
+			return string(p), nil
+		}
+	}
+	return "", fmt.Errorf("invalid Spot eviction policy %q, supported values: Deallocate, Delete", policy)
+}
+
+// parseSpotMaxPrice parses the max price of a Spot VM, which is either -1 or
+// a price greater than zero.
+func parseSpotMaxPrice(price string) (float64, error) {
+	v, err := strconv.ParseFloat(price, 64)
+	if err != nil || (v != -1 && v <= 0) {
+		return 0, fmt.Errorf("invalid Spot max price %q, must be -1 or greater than 0", price)
+	}
+	return v, nil
+}
+
 func machineStateForVMPowerState(ps azureutil.VMPowerState) state.State {
 	m := map[azureutil.VMPowerState]state.State{
 		azureutil.Running:      state.Running,
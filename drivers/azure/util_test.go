@@ -1,9 +1,13 @@
 package azure
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/rancher/machine/drivers/azure/azureutil"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,3 +33,133 @@ func TestParseSecurityRuleProtocol(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSpotEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		raw         string
+		expected    string
+		expectedErr bool
+	}{
+		{"Deallocate", "Deallocate", false},
+		{"delete", "Delete", false},
+		{"Stop", "", true},
+		{"", "", true},
+	}
+
+	for _, tc := range tests {
+		policy, err := parseSpotEvictionPolicy(tc.raw)
+		assert.Equal(t, tc.expected, policy)
+		if tc.expectedErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestParseSpotMaxPrice(t *testing.T) {
+	tests := []struct {
+		raw         string
+		expected    float64
+		expectedErr bool
+	}{
+		{"-1", -1, false},
+		{"0.01538", 0.01538, false},
+		{"0", 0, true},
+		{"-0.5", 0, true},
+		{"cheap", 0, true},
+	}
+
+	for _, tc := range tests {
+		price, err := parseSpotMaxPrice(tc.raw)
+		assert.Equal(t, tc.expected, price)
+		if tc.expectedErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestMachineStateForVMPowerState(t *testing.T) {
+	// evicted Spot VMs with the Deallocate policy end up deallocated
+	assert.Equal(t, state.Stopped, machineStateForVMPowerState(azureutil.Deallocated))
+	assert.Equal(t, state.Stopping, machineStateForVMPowerState(azureutil.Deallocating))
+	assert.Equal(t, state.Running, machineStateForVMPowerState(azureutil.Running))
+}
+
+func TestValidateAuthOptions(t *testing.T) {
+	tests := []struct {
+		driver      Driver
+		expectedErr bool
+	}{
+		{Driver{}, false},
+		{Driver{ClientID: "id", ClientSecret: "secret"}, false},
+		{Driver{UseManagedIdentity: true}, false},
+		{Driver{UseManagedIdentity: true, ClientID: "user-assigned"}, false},
+		{Driver{ClientID: "id", FederatedTokenFile: "/var/run/token"}, false},
+		{Driver{FederatedTokenFile: "/var/run/token"}, true},
+		{Driver{UseManagedIdentity: true, FederatedTokenFile: "/var/run/token"}, true},
+		{Driver{UseManagedIdentity: true, ClientID: "id", ClientSecret: "secret"}, true},
+	}
+
+	for _, tc := range tests {
+		err := tc.driver.validateAuthOptions()
+		if tc.expectedErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestUseAvailabilitySet(t *testing.T) {
+	assert.True(t, (&Driver{AvailabilitySet: defaultAzureAvailabilitySet}).useAvailabilitySet())
+	assert.False(t, (&Driver{AvailabilitySet: defaultAzureAvailabilitySet, AvailabilityZone: "1"}).useAvailabilitySet())
+	assert.False(t, (&Driver{}).useAvailabilitySet())
+}
+
+func TestParseDataDisk(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    azureutil.DataDisk
+		expectedErr bool
+	}{
+		{"size=128", azureutil.DataDisk{SizeGB: 128, StorageType: "Premium_LRS"}, false},
+		{"size=64,type=StandardSSD_LRS", azureutil.DataDisk{SizeGB: 64, StorageType: "StandardSSD_LRS"}, false},
+		{"size=256,type=UltraSSD_LRS,iops=5000,mbps=200", azureutil.DataDisk{SizeGB: 256, StorageType: "UltraSSD_LRS", IOPS: 5000, MBps: 200}, false},
+		{"size=256,iops=5000", azureutil.DataDisk{}, true},
+		{"type=Premium_LRS", azureutil.DataDisk{}, true},
+		{"size=big", azureutil.DataDisk{}, true},
+		{"size=128,caching", azureutil.DataDisk{}, true},
+		{"size=128,lun=2", azureutil.DataDisk{}, true},
+	}
+
+	for _, tc := range tests {
+		disk, err := parseDataDisk(tc.spec, "Premium_LRS")
+		if tc.expectedErr {
+			assert.Error(t, err, tc.spec)
+		} else {
+			assert.NoError(t, err, tc.spec)
+			assert.Equal(t, tc.expected, disk)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	d := NewDriver("machine", "path").(*Driver)
+	d.Location = "westeurope"
+	d.ImagePlan = "publisher:product:plan"
+	d.NoPublicIP = true
+
+	items, err := d.Plan()
+
+	assert.NoError(t, err)
+	assert.Contains(t, items, drivers.PlanItem{Name: "Location", Value: "westeurope"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Public IP", Value: "none"})
+
+	// the image plan is kept as Plan in the configs
+	data, err := json.Marshal(d)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Plan":"publisher:product:plan"`)
+}
@@ -0,0 +1,406 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/cloudinit"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver creates machines as privileged containers running systemd, sshd and
+// cloud-init, in which the provisioners install Docker as on any host. They
+// boot in seconds and need no credentials, which makes them a good fit for
+// testing libmachine and the provisioners.
+type Driver struct {
+	*drivers.BaseDriver
+	cli               commander
+	Runtime           string
+	Image             string
+	Network           string
+	CPU               string
+	Memory            int
+	CloudInitUserData string
+	EnginePort        int
+}
+
+const (
+	defaultRuntime = "docker"
+	defaultImage   = "rancher/systemd-node"
+	defaultSSHUser = cloudinit.DefaultUser
+
+	// seedPath is where cloud-init reads the NoCloud seed from.
+	seedPath = "/var/lib/cloud/seed/nocloud"
+	// machineLabel marks the containers created by the driver.
+	machineLabel = "io.rancher.machine.name"
+)
+
+// commander runs the docker or podman CLI.
+type commander interface {
+	run(runtime string, stdin io.Reader, args ...string) (string, error)
+}
+
+type execCommander struct{}
+
+func (execCommander) run(runtime string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command(runtime, args...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	log.Debugf("executing: %s %s", runtime, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %s: %s", runtime, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_RUNTIME",
+			Name:   "container-runtime",
+			Usage:  "Container runtime CLI: docker or podman",
+			Value:  defaultRuntime,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_IMAGE",
+			Name:   "container-image",
+			Usage:  "Image running systemd, sshd and cloud-init",
+			Value:  defaultImage,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_NETWORK",
+			Name:   "container-network",
+			Usage:  "Network to connect the container to (default is the runtime's)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_CPU_COUNT",
+			Name:   "container-cpu-count",
+			Usage:  "CPUs available to the container, e.g. 1.5 (default is unlimited)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "CONTAINER_MEMORY",
+			Name:   "container-memory",
+			Usage:  "Memory available to the container in MB (default is unlimited)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_CLOUD_INIT_USER_DATA",
+			Name:   "container-cloud-init-user-data",
+			Usage:  "Path of a cloud-config or a script run on the first boot",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "CONTAINER_SSH_USER",
+			Name:   "container-ssh-user",
+			Usage:  "SSH user created by cloud-init",
+			Value:  defaultSSHUser,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		cli:     execCommander{},
+		Runtime: defaultRuntime,
+		Image:   defaultImage,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "container"
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Runtime = flags.String("container-runtime")
+	d.Image = flags.String("container-image")
+	d.Network = flags.String("container-network")
+	d.CPU = flags.String("container-cpu-count")
+	d.Memory = flags.Int("container-memory")
+	d.CloudInitUserData = flags.String("container-cloud-init-user-data")
+	d.SSHUser = flags.String("container-ssh-user")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.Runtime != "docker" && d.Runtime != "podman" {
+		return fmt.Errorf("--container-runtime must be docker or podman, not %q", d.Runtime)
+	}
+	if d.Image == "" {
+		return errors.New("container driver requires the --container-image option")
+	}
+	if d.CPU != "" {
+		if cpu, err := strconv.ParseFloat(d.CPU, 64); err != nil || cpu <= 0 {
+			return fmt.Errorf("--container-cpu-count must be a positive number, not %q", d.CPU)
+		}
+	}
+	if d.Memory < 0 {
+		return errors.New("--container-memory cannot be negative")
+	}
+	return nil
+}
+
+func (d *Driver) run(args ...string) (string, error) {
+	return d.runWithInput(nil, args...)
+}
+
+func (d *Driver) runWithInput(stdin io.Reader, args ...string) (string, error) {
+	if d.cli == nil {
+		d.cli = execCommander{}
+	}
+	return d.cli.run(d.Runtime, stdin, args...)
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if _, err := d.run("version"); err != nil {
+		return fmt.Errorf("%s is not available: %s", d.Runtime, err)
+	}
+
+	if _, err := d.run("image", "inspect", d.Image); err != nil {
+		log.Infof("Pulling %s...", d.Image)
+		if _, err := d.run("pull", d.Image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+
+	seed, err := cloudinit.NewSeed(d.MachineName, d.GetSSHUsername(), d.GetSSHKeyPath()+".pub", d.CloudInitUserData)
+	if err != nil {
+		return err
+	}
+	seedTar, err := seedArchive(seed)
+	if err != nil {
+		return err
+	}
+
+	if d.SSHPort, err = freePort(); err != nil {
+		return err
+	}
+	if d.EnginePort == 0 {
+		if d.EnginePort, err = freePort(); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Creating container %s...", d.MachineName)
+	if _, err := d.run(d.createArgs()...); err != nil {
+		return err
+	}
+
+	// copying the seed saves sharing the store with the runtime, as a bind
+	// mount would
+	if _, err := d.runWithInput(seedTar, "cp", "-", d.MachineName+":/"); err != nil {
+		return err
+	}
+
+	return d.Start()
+}
+
+// seedArchive returns a tar of the seed at seedPath, creating its parents
+// which the image may lack.
+func seedArchive(seed *cloudinit.Seed) (io.Reader, error) {
+	files, err := seed.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dir := ""
+	for _, name := range strings.Split(strings.Trim(seedPath, "/"), "/") {
+		dir = path.Join(dir, name)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755}); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{"meta-data", "user-data"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: path.Join(dir, name), Mode: 0600, Size: int64(len(files[name]))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (d *Driver) createArgs() []string {
+	args := []string{"create",
+		"--name", d.MachineName,
+		"--hostname", d.MachineName,
+		"--label", machineLabel + "=" + d.MachineName,
+		"--privileged",
+		"--tmpfs", "/run",
+		"--tmpfs", "/tmp",
+		// overlayfs cannot be stacked, Docker stores its data on a volume
+		"--volume", "/var/lib/docker",
+		"--publish", fmt.Sprintf("127.0.0.1:%d:22", d.SSHPort),
+		"--publish", fmt.Sprintf("127.0.0.1:%d:%d", d.EnginePort, d.EnginePort),
+	}
+	if d.Network != "" {
+		args = append(args, "--network", d.Network)
+	}
+	if d.CPU != "" {
+		args = append(args, "--cpus", d.CPU)
+	}
+	if d.Memory > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", d.Memory))
+	}
+	return append(args, d.Image)
+}
+
+// GetState returns the state that the host is in (running, stopped, etc)
+func (d *Driver) GetState() (state.State, error) {
+	status, err := d.run("inspect", "--format", "{{.State.Status}}", d.MachineName)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "no such") {
+			return state.NotFound, nil
+		}
+		return state.Error, err
+	}
+
+	switch status {
+	case "running":
+		return state.Running, nil
+	case "paused":
+		return state.Paused, nil
+	case "restarting":
+		return state.Starting, nil
+	case "removing":
+		return state.Stopping, nil
+	case "created", "exited", "stopped", "configured":
+		return state.Stopped, nil
+	case "dead":
+		return state.Error, nil
+	}
+	return state.None, nil
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	if _, err := d.run("start", d.MachineName); err != nil {
+		return err
+	}
+	d.IPAddress = "127.0.0.1"
+	return nil
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	if _, err := d.run("stop", d.MachineName); err != nil {
+		return err
+	}
+	d.IPAddress = ""
+	return nil
+}
+
+// Restart a host.
+func (d *Driver) Restart() error {
+	_, err := d.run("restart", d.MachineName)
+	return err
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	if _, err := d.run("kill", d.MachineName); err != nil {
+		return err
+	}
+	d.IPAddress = ""
+	return nil
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if s == state.NotFound {
+		log.Infof("Container %s not found, skipping removal", d.MachineName)
+		return nil
+	}
+
+	_, err = d.run("rm", "--force", "--volumes", d.MachineName)
+	return err
+}
+
+// GetIP returns an IP or hostname that this host is available at. The ports
+// are published on localhost, as container IPs are not reachable from the
+// host with Docker Desktop.
+func (d *Driver) GetIP() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+	return "127.0.0.1", nil
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.EnginePort))), nil
+}
+
+// SetEnginePort sets the port of the Docker daemon, published as is rather
+// than from a free port.
+func (d *Driver) SetEnginePort(port int) error {
+	d.EnginePort = port
+	return nil
+}
+
+// freePort returns a port of localhost which is not in use. The Docker daemon
+// of the machine listens on the same port as published, as the provisioner
+// configures it from the URL.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,147 @@
+package container
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/cloudinit"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCommander struct {
+	calls  []string
+	output string
+	err    error
+}
+
+func (c *fakeCommander) run(runtime string, stdin io.Reader, args ...string) (string, error) {
+	c.calls = append(c.calls, runtime+" "+strings.Join(args, " "))
+	return c.output, c.err
+}
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "docker", driver.GetSSHUsername())
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		return NewDriver("default", "path").(*Driver)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "defaults", modify: func(d *Driver) {}},
+		{name: "podman", modify: func(d *Driver) { d.Runtime, d.CPU, d.Memory = "podman", "1.5", 2048 }},
+		{name: "runtime", modify: func(d *Driver) { d.Runtime = "nerdctl" }, err: `--container-runtime must be docker or podman, not "nerdctl"`},
+		{name: "image", modify: func(d *Driver) { d.Image = "" }, err: "container driver requires the --container-image option"},
+		{name: "cpu", modify: func(d *Driver) { d.CPU = "all" }, err: `--container-cpu-count must be a positive number, not "all"`},
+		{name: "memory", modify: func(d *Driver) { d.Memory = -1 }, err: "--container-memory cannot be negative"},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestCreateArgs(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.SSHPort, d.EnginePort = 40022, 42376
+	d.Network, d.CPU, d.Memory = "machines", "2", 2048
+
+	assert.Equal(t, []string{"create",
+		"--name", "default",
+		"--hostname", "default",
+		"--label", "io.rancher.machine.name=default",
+		"--privileged",
+		"--tmpfs", "/run",
+		"--tmpfs", "/tmp",
+		"--volume", "/var/lib/docker",
+		"--publish", "127.0.0.1:40022:22",
+		"--publish", "127.0.0.1:42376:42376",
+		"--network", "machines",
+		"--cpus", "2",
+		"--memory", "2048m",
+		"rancher/systemd-node",
+	}, d.createArgs())
+}
+
+func TestSeedArchive(t *testing.T) {
+	archive, err := seedArchive(&cloudinit.Seed{Hostname: "default", PublicKey: "ssh-rsa AAAA"})
+	assert.NoError(t, err)
+
+	files := map[string]string{}
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+
+	assert.Contains(t, files, "var/lib/cloud/")
+	assert.Contains(t, files, "var/lib/cloud/seed/nocloud/")
+	assert.Equal(t, "instance-id: default\nlocal-hostname: default\n", files["var/lib/cloud/seed/nocloud/meta-data"])
+	assert.Contains(t, files["var/lib/cloud/seed/nocloud/user-data"], "#cloud-config")
+}
+
+func TestGetState(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	cli := &fakeCommander{output: "exited"}
+	d.cli = cli
+
+	s, err := d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, s)
+	assert.Equal(t, []string{"docker inspect --format {{.State.Status}} default"}, cli.calls)
+
+	cli.output = "running"
+	s, err = d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, s)
+
+	cli.err = errors.New("docker inspect: exit status 1: Error: No such object: default")
+	s, err = d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.NotFound, s)
+}
+
+func TestRemoveNotFound(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	cli := &fakeCommander{err: errors.New("podman inspect: exit status 125: Error: no such object: \"default\"")}
+	d.cli = cli
+	d.Runtime = "podman"
+
+	assert.NoError(t, d.Remove())
+	assert.Len(t, cli.calls, 1)
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,7 +26,7 @@ import (
 
 type Driver struct {
 	*drivers.BaseDriver
-	AccessToken       string
+	AccessToken       string `secret:"true"`
 	DropletID         int
 	DropletName       string
 	Image             string
@@ -41,6 +42,11 @@ type Driver struct {
 	Monitoring        bool
 	Tags              string
 	PrivateIPAddress  string
+	VPCUUID           string
+	VPCName           string
+	UsePrivateIP      bool
+	ReservedIP        string
+	Firewall          string
 }
 
 const (
@@ -130,6 +136,31 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "digitalocean-tags",
 			Usage:  "comma-separated list of tags to apply to the Droplet",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "DIGITALOCEAN_VPC_UUID",
+			Name:   "digitalocean-vpc-uuid",
+			Usage:  "UUID of the VPC to create the droplet in, the default VPC of the region is used otherwise",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "DIGITALOCEAN_VPC_NAME",
+			Name:   "digitalocean-vpc-name",
+			Usage:  "name of the VPC to create the droplet in, looked up in the droplet region",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "DIGITALOCEAN_USE_PRIVATE_IP",
+			Name:   "digitalocean-use-private-ip",
+			Usage:  "use the private VPC IP address of the droplet as the machine address",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "DIGITALOCEAN_RESERVED_IP",
+			Name:   "digitalocean-reserved-ip",
+			Usage:  "existing reserved IP to assign to the droplet, it becomes the machine address",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "DIGITALOCEAN_FIREWALL",
+			Name:   "digitalocean-firewall",
+			Usage:  "name of the cloud firewall to attach the droplet to, created with the docker and Kubernetes ports if missing",
+		},
 	}
 }
 
@@ -189,6 +220,11 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.SSHKey = flags.String("digitalocean-ssh-key-path")
 	d.Monitoring = flags.Bool("digitalocean-monitoring")
 	d.Tags = flags.String("digitalocean-tags")
+	d.VPCUUID = flags.String("digitalocean-vpc-uuid")
+	d.VPCName = flags.String("digitalocean-vpc-name")
+	d.UsePrivateIP = flags.Bool("digitalocean-use-private-ip")
+	d.ReservedIP = flags.String("digitalocean-reserved-ip")
+	d.Firewall = flags.String("digitalocean-firewall")
 
 	d.SetSwarmConfigFromFlags(flags)
 
@@ -196,6 +232,10 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		return fmt.Errorf("digitalocean driver requires the --digitalocean-access-token option")
 	}
 
+	if d.VPCUUID != "" && d.VPCName != "" {
+		return fmt.Errorf("--digitalocean-vpc-uuid and --digitalocean-vpc-name cannot be used together")
+	}
+
 	return nil
 }
 
@@ -221,13 +261,85 @@ func (d *Driver) PreCreateCheck() error {
 	if err != nil {
 		return err
 	}
+	validRegion := false
 	for _, region := range regions {
 		if region.Slug == d.Region {
-			return nil
+			validRegion = true
+			break
 		}
 	}
+	if !validRegion {
+		return fmt.Errorf("digitalocean requires a valid region")
+	}
 
-	return fmt.Errorf("digitalocean requires a valid region")
+	if err := d.checkReservedIP(); err != nil {
+		return err
+	}
+
+	return d.resolveVPC()
+}
+
+// Plan returns the droplet to create, with the VPC resolved by
+// PreCreateCheck.
+func (d *Driver) Plan() ([]drivers.PlanItem, error) {
+	items := []drivers.PlanItem{
+		{Name: "Region", Value: d.Region},
+		{Name: "Size", Value: d.Size},
+		{Name: "Image", Value: d.Image},
+		{Name: "VPC", Value: d.VPCUUID},
+		{Name: "Reserved IP", Value: d.ReservedIP},
+		{Name: "Firewall", Value: d.Firewall},
+		{Name: "Tags", Value: d.Tags},
+	}
+	if d.IPv6 {
+		items = append(items, drivers.PlanItem{Name: "IPv6", Value: "true"})
+	}
+	if d.PrivateNetworking {
+		items = append(items, drivers.PlanItem{Name: "Private networking", Value: "true"})
+	}
+	if d.Backups {
+		items = append(items, drivers.PlanItem{Name: "Backups", Value: "true"})
+	}
+	return items, nil
+}
+
+// resolveVPC looks up the VPC given by name and makes sure that the VPC is in
+// the region the droplet is created in.
+func (d *Driver) resolveVPC() error {
+	client := d.getClient()
+
+	if d.VPCUUID != "" {
+		vpc, _, err := client.VPCs.Get(context.TODO(), d.VPCUUID)
+		if err != nil {
+			return fmt.Errorf("unable to get VPC %s: %s", d.VPCUUID, err)
+		}
+		if vpc.RegionSlug != d.Region {
+			return fmt.Errorf("VPC %s is in region %s, not in %s", d.VPCUUID, vpc.RegionSlug, d.Region)
+		}
+		return nil
+	}
+
+	if d.VPCName == "" {
+		return nil
+	}
+
+	var vpcs []*godo.VPC
+	err := forEachPage(func(opt *godo.ListOptions) (*godo.Response, error) {
+		page, resp, err := client.VPCs.List(context.TODO(), opt)
+		vpcs = append(vpcs, page...)
+		return resp, err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list VPCs: %s", err)
+	}
+
+	vpc := findVPC(vpcs, d.VPCName, d.Region)
+	if vpc == nil {
+		return fmt.Errorf("VPC %q not found in region %s", d.VPCName, d.Region)
+	}
+	log.Debugf("Resolved VPC %q to %s", d.VPCName, vpc.ID)
+	d.VPCUUID = vpc.ID
+	return nil
 }
 
 func (d *Driver) Create() error {
@@ -249,10 +361,17 @@ func (d *Driver) Create() error {
 
 	d.SSHKeyID = key.ID
 
-	log.Infof("Creating Digital Ocean droplet...")
-
 	client := d.getClient()
 
+	var firewall *godo.Firewall
+	if d.Firewall != "" {
+		if firewall, err = d.findOrCreateFirewall(); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Creating Digital Ocean droplet...")
+
 	createRequest := &godo.DropletCreateRequest{
 		Image:             godo.DropletCreateImage{Slug: d.Image},
 		Name:              d.MachineName,
@@ -265,6 +384,7 @@ func (d *Driver) Create() error {
 		SSHKeys:           []godo.DropletCreateSSHKey{{ID: d.SSHKeyID}},
 		Monitoring:        d.Monitoring,
 		Tags:              d.getTags(),
+		VPCUUID:           d.VPCUUID,
 	}
 
 	newDroplet, _, err := client.Droplets.Create(context.TODO(), createRequest)
@@ -283,22 +403,42 @@ func (d *Driver) Create() error {
 			}
 			return err
 		}
+		needPrivateIP := d.PrivateNetworking || d.UsePrivateIP
 		for _, network := range newDroplet.Networks.V4 {
 			if network.Type == "public" {
 				d.IPAddress = network.IPAddress
 			}
-			if d.PrivateNetworking && network.Type == "private" {
+			if needPrivateIP && network.Type == "private" {
 				d.PrivateIPAddress = network.IPAddress
 			}
 		}
+		if d.UsePrivateIP {
+			d.IPAddress = d.PrivateIPAddress
+		}
 
-		if d.IPAddress != "" && (!d.PrivateNetworking || d.PrivateIPAddress != "") {
+		if d.IPAddress != "" && (!needPrivateIP || d.PrivateIPAddress != "") {
 			break
 		}
 
 		time.Sleep(5 * time.Second)
 	}
 
+	if firewall != nil && !firewallAppliesToTags(firewall, d.getTags()) {
+		log.Infof("Adding droplet to firewall %s...", d.Firewall)
+		if _, err := client.Firewalls.AddDroplets(context.TODO(), firewall.ID, d.DropletID); err != nil {
+			return fmt.Errorf("unable to add droplet to firewall %s: %s", d.Firewall, err)
+		}
+	}
+
+	if d.ReservedIP != "" {
+		if err := d.assignReservedIP(); err != nil {
+			return err
+		}
+		if !d.UsePrivateIP {
+			d.IPAddress = d.ReservedIP
+		}
+	}
+
 	log.Debugf("Created droplet ID %d, IP address %s, Private IP address %s",
 		newDroplet.ID,
 		d.IPAddress,
@@ -359,7 +499,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
@@ -432,9 +572,25 @@ func (d *Driver) getClient() *godo.Client {
 	return godo.NewClient(client)
 }
 
+// findVPC returns the VPC with the given name in the region, or nil.
+func findVPC(vpcs []*godo.VPC, name, region string) *godo.VPC {
+	for _, vpc := range vpcs {
+		if vpc.Name == name && vpc.RegionSlug == region {
+			return vpc
+		}
+	}
+	return nil
+}
+
 func (d *Driver) getTags() []string {
 	var tagList []string
 
+	// droplets are attached to a firewall created by the driver through a tag
+	// named after the firewall
+	if d.Firewall != "" {
+		tagList = append(tagList, d.Firewall)
+	}
+
 	for _, t := range strings.Split(d.Tags, ",") {
 		t = strings.TrimSpace(t)
 		if t != "" {
@@ -469,3 +625,24 @@ func copySSHKey(src, dst string) error {
 
 	return nil
 }
+
+// forEachPage calls list with the options of every page of a paginated
+// listing until the last page has been fetched.
+func forEachPage(list func(opt *godo.ListOptions) (*godo.Response, error)) error {
+	opt := &godo.ListOptions{PerPage: 200}
+	for {
+		resp, err := list(opt)
+		if err != nil {
+			return err
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return nil
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return err
+		}
+		opt.Page = current + 1
+	}
+}
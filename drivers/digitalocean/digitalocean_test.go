@@ -3,6 +3,7 @@ package digitalocean
 import (
 	"testing"
 
+	"github.com/digitalocean/godo"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/stretchr/testify/assert"
 )
@@ -113,3 +114,82 @@ func TestTagsEmpty(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, driver.getTags())
 }
+
+func TestVPCUUIDAndNameExclusive(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"digitalocean-access-token": "TOKEN",
+			"digitalocean-vpc-uuid":     "5a4981aa-9653-4bd1-bef5-d6bff52042e4",
+			"digitalocean-vpc-name":     "cluster",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+}
+
+func TestFindVPC(t *testing.T) {
+	vpcs := []*godo.VPC{
+		{ID: "nyc3-default", Name: "default-nyc3", RegionSlug: "nyc3"},
+		{ID: "ams3-cluster", Name: "cluster", RegionSlug: "ams3"},
+		{ID: "nyc3-cluster", Name: "cluster", RegionSlug: "nyc3"},
+	}
+
+	assert.Equal(t, "nyc3-cluster", findVPC(vpcs, "cluster", "nyc3").ID)
+	assert.Equal(t, "ams3-cluster", findVPC(vpcs, "cluster", "ams3").ID)
+	assert.Nil(t, findVPC(vpcs, "cluster", "sfo3"))
+}
+
+func TestTagsWithFirewall(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"digitalocean-access-token": "TOKEN",
+			"digitalocean-tags":         "docker",
+			"digitalocean-firewall":     "machines",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"machines", "docker"}, driver.getTags())
+}
+
+func TestDefaultFirewallRequest(t *testing.T) {
+	request := defaultFirewallRequest("machines", 2376)
+
+	assert.Equal(t, "machines", request.Name)
+	assert.Equal(t, []string{"machines"}, request.Tags)
+	for _, rule := range request.InboundRules {
+		if rule.PortRange == "22" || rule.PortRange == "2376" {
+			assert.Equal(t, anywhere, rule.Sources.Addresses)
+		}
+		if rule.PortRange == "2377" || rule.PortRange == "10250" {
+			assert.Equal(t, []string{"machines"}, rule.Sources.Tags)
+			assert.Empty(t, rule.Sources.Addresses)
+		}
+	}
+
+	assert.True(t, firewallAppliesToTags(&godo.Firewall{Tags: request.Tags}, []string{"machines", "docker"}))
+	assert.False(t, firewallAppliesToTags(&godo.Firewall{Tags: []string{"web"}}, []string{"machines"}))
+}
+
+func TestPlan(t *testing.T) {
+	driver := NewDriver("default", "path")
+	driver.Region = "ams3"
+	driver.Size = "s-2vcpu-4gb"
+	driver.VPCUUID = "vpc-1"
+
+	items, err := driver.Plan()
+
+	assert.NoError(t, err)
+	assert.Contains(t, items, drivers.PlanItem{Name: "Region", Value: "ams3"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "VPC", Value: "vpc-1"})
+	assert.NotContains(t, items, drivers.PlanItem{Name: "Backups", Value: "true"})
+}
@@ -0,0 +1,156 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+)
+
+var (
+	anywhere = []string{"0.0.0.0/0", "::/0"}
+
+	reservedIPAssignTimeout = 2 * time.Minute
+)
+
+// checkReservedIP makes sure the reserved IP exists in the droplet region and
+// is not assigned to another droplet yet.
+func (d *Driver) checkReservedIP() error {
+	if d.ReservedIP == "" {
+		return nil
+	}
+
+	ip, _, err := d.getClient().ReservedIPs.Get(context.TODO(), d.ReservedIP)
+	if err != nil {
+		return fmt.Errorf("unable to get reserved IP %s: %s", d.ReservedIP, err)
+	}
+	if ip.Region != nil && ip.Region.Slug != d.Region {
+		return fmt.Errorf("reserved IP %s is in region %s, not in %s", d.ReservedIP, ip.Region.Slug, d.Region)
+	}
+	if ip.Droplet != nil {
+		return fmt.Errorf("reserved IP %s is already assigned to droplet %d", d.ReservedIP, ip.Droplet.ID)
+	}
+	return nil
+}
+
+// assignReservedIP assigns the reserved IP to the droplet once it is active
+// and waits for the assignment to complete.
+func (d *Driver) assignReservedIP() error {
+	client := d.getClient()
+
+	log.Infof("Waiting for the droplet to become active...")
+	err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		droplet, _, err := client.Droplets.Get(context.TODO(), d.DropletID)
+		if err != nil {
+			return false, err
+		}
+		return droplet.Status == "active", nil
+	}, int(reservedIPAssignTimeout/(5*time.Second)), 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("droplet did not become active: %s", err)
+	}
+
+	log.Infof("Assigning reserved IP %s to the droplet...", d.ReservedIP)
+	action, _, err := client.ReservedIPActions.Assign(context.TODO(), d.ReservedIP, d.DropletID)
+	if err != nil {
+		return fmt.Errorf("unable to assign reserved IP %s: %s", d.ReservedIP, err)
+	}
+
+	return mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		action, _, err = client.ReservedIPActions.Get(context.TODO(), d.ReservedIP, action.ID)
+		if err != nil {
+			return false, err
+		}
+		switch action.Status {
+		case godo.ActionCompleted:
+			return true, nil
+		case godo.ActionInProgress:
+			return false, nil
+		}
+		return false, fmt.Errorf("assigning reserved IP %s ended with status %s", d.ReservedIP, action.Status)
+	}, int(reservedIPAssignTimeout/time.Second), time.Second)
+}
+
+// findOrCreateFirewall returns the cloud firewall with the configured name,
+// creating it if it does not exist.
+func (d *Driver) findOrCreateFirewall() (*godo.Firewall, error) {
+	client := d.getClient()
+
+	var firewalls []godo.Firewall
+	err := forEachPage(func(opt *godo.ListOptions) (*godo.Response, error) {
+		page, resp, err := client.Firewalls.List(context.TODO(), opt)
+		firewalls = append(firewalls, page...)
+		return resp, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list firewalls: %s", err)
+	}
+
+	for i := range firewalls {
+		if firewalls[i].Name == d.Firewall {
+			log.Debugf("Firewall %s = %s", d.Firewall, firewalls[i].ID)
+			return &firewalls[i], nil
+		}
+	}
+
+	log.Infof("Firewall %s does not exist. Creating it...", d.Firewall)
+	firewall, _, err := client.Firewalls.Create(context.TODO(), defaultFirewallRequest(d.Firewall, d.GetEnginePort()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create firewall %s: %s", d.Firewall, err)
+	}
+	return firewall, nil
+}
+
+// defaultFirewallRequest opens SSH, the Docker and Kubernetes API ports to
+// anyone, and the ports used among cluster nodes to droplets with the tag of
+// the firewall. Outbound traffic is not restricted.
+func defaultFirewallRequest(name string, dockerPort int) *godo.FirewallRequest {
+	public := &godo.Sources{Addresses: anywhere}
+	nodes := &godo.Sources{Tags: []string{name}}
+
+	return &godo.FirewallRequest{
+		Name: name,
+		Tags: []string{name},
+		InboundRules: []godo.InboundRule{
+			{Protocol: "tcp", PortRange: "22", Sources: public},
+			{Protocol: "icmp", Sources: public},
+			// Docker and Swarm
+			{Protocol: "tcp", PortRange: strconv.Itoa(dockerPort), Sources: public},
+			{Protocol: "tcp", PortRange: "2377", Sources: nodes},
+			{Protocol: "tcp", PortRange: "7946", Sources: nodes},
+			{Protocol: "udp", PortRange: "7946", Sources: nodes},
+			{Protocol: "udp", PortRange: "4789", Sources: nodes},
+			// Kubernetes
+			{Protocol: "tcp", PortRange: "6443", Sources: public},
+			{Protocol: "tcp", PortRange: "80", Sources: public},
+			{Protocol: "tcp", PortRange: "443", Sources: public},
+			{Protocol: "tcp", PortRange: "2379-2380", Sources: nodes},
+			{Protocol: "tcp", PortRange: "9345", Sources: nodes},
+			{Protocol: "tcp", PortRange: "10250", Sources: nodes},
+			{Protocol: "udp", PortRange: "8472", Sources: nodes},
+			{Protocol: "tcp", PortRange: "30000-32767", Sources: public},
+		},
+		OutboundRules: []godo.OutboundRule{
+			{Protocol: "tcp", PortRange: "all", Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "udp", PortRange: "all", Destinations: &godo.Destinations{Addresses: anywhere}},
+			{Protocol: "icmp", Destinations: &godo.Destinations{Addresses: anywhere}},
+		},
+	}
+}
+
+// firewallAppliesToTags reports whether the firewall already applies to
+// droplets with any of the tags.
+func firewallAppliesToTags(firewall *godo.Firewall, tags []string) bool {
+	for _, fwTag := range firewall.Tags {
+		for _, tag := range tags {
+			if fwTag == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
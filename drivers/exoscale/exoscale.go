@@ -13,6 +13,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/exoscale/egoscale"
@@ -28,8 +29,8 @@ import (
 type Driver struct {
 	*drivers.BaseDriver
 	URL              string
-	APIKey           string `json:"ApiKey"`
-	APISecretKey     string `json:"ApiSecretKey"`
+	APIKey           string `json:"ApiKey" secret:"true"`
+	APISecretKey     string `json:"ApiSecretKey" secret:"true"`
 	InstanceProfile  string
 	DiskSize         int64
 	Image            string
@@ -38,11 +39,14 @@ type Driver struct {
 	AvailabilityZone string
 	SSHKey           string
 	KeyPair          string
-	Password         string
+	Password         string `secret:"true"`
 	PublicKey        string
 	UserDataFile     string
 	UserData         []byte
 	ID               *egoscale.UUID `json:"Id"`
+
+	// progress receives the progress of the async jobs, besides the logs
+	progress func(drivers.Progress)
 }
 
 const (
@@ -263,7 +267,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) client() *egoscale.Client {
@@ -276,13 +280,23 @@ func (d *Driver) virtualMachine() (*egoscale.VirtualMachine, error) {
 		ID: d.ID,
 	}
 
-	if err := cs.GetWithContext(context.TODO(), virtualMachine); err != nil {
+	if err := cs.GetWithContext(d.Context(), virtualMachine); err != nil {
 		return nil, err
 	}
 
 	return virtualMachine, nil
 }
 
+// GetStateContext returns the state of the host, aborting the API call in
+// progress when ctx is done.
+func (d *Driver) GetStateContext(ctx context.Context) (s state.State, err error) {
+	err = d.WithContext(ctx, func() error {
+		s, err = d.GetState()
+		return err
+	})
+	return s, err
+}
+
 // GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
 func (d *Driver) GetState() (state.State, error) {
 	vm, err := d.virtualMachine()
@@ -316,7 +330,7 @@ func (d *Driver) GetState() (state.State, error) {
 
 func (d *Driver) createDefaultSecurityGroup(group string) (*egoscale.SecurityGroup, error) {
 	cs := d.client()
-	resp, err := cs.RequestWithContext(context.TODO(), &egoscale.CreateSecurityGroup{
+	resp, err := cs.RequestWithContext(d.Context(), &egoscale.CreateSecurityGroup{
 		Name:        group,
 		Description: "created by docker-machine",
 	})
@@ -360,7 +374,15 @@ func (d *Driver) createDefaultSecurityGroup(group string) (*egoscale.SecurityGro
 			Description:     "Docker",
 			CIDRList:        cidrList,
 			Protocol:        "TCP",
-			StartPort:       2376,
+			StartPort:       uint16(d.GetEnginePort()),
+			EndPort:         uint16(d.GetEnginePort()),
+		},
+		{
+			SecurityGroupID: sg.ID,
+			Description:     "Swarm",
+			CIDRList:        cidrList,
+			Protocol:        "TCP",
+			StartPort:       2377,
 			EndPort:         2377,
 		},
 		{
@@ -404,7 +426,7 @@ func (d *Driver) createDefaultSecurityGroup(group string) (*egoscale.SecurityGro
 	}
 
 	for _, req := range requests {
-		_, err := cs.RequestWithContext(context.TODO(), &req)
+		_, err := cs.RequestWithContext(d.Context(), &req)
 		if err != nil {
 			return nil, err
 		}
@@ -415,7 +437,7 @@ func (d *Driver) createDefaultSecurityGroup(group string) (*egoscale.SecurityGro
 
 func (d *Driver) createDefaultAffinityGroup(group string) (*egoscale.AffinityGroup, error) {
 	cs := d.client()
-	resp, err := cs.RequestWithContext(context.TODO(), &egoscale.CreateAffinityGroup{
+	resp, err := cs.RequestWithContext(d.Context(), &egoscale.CreateAffinityGroup{
 		Name:        group,
 		Type:        defaultAffinityGroupType,
 		Description: "created by docker-machine",
@@ -429,6 +451,12 @@ func (d *Driver) createDefaultAffinityGroup(group string) (*egoscale.AffinityGro
 	return affinityGroup, nil
 }
 
+// CreateContext creates the VM instance, aborting the API calls and the
+// polling of the jobs in progress when ctx is done.
+func (d *Driver) CreateContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Create)
+}
+
 // Create creates the VM instance acting as the docker host
 func (d *Driver) Create() error {
 	cloudInit, err := d.getCloudInit()
@@ -439,7 +467,7 @@ func (d *Driver) Create() error {
 	log.Infof("Querying exoscale for the requested parameters...")
 	client := egoscale.NewClient(d.URL, d.APIKey, d.APISecretKey)
 
-	zones, err := client.ListWithContext(context.TODO(), &egoscale.Zone{
+	zones, err := client.ListWithContext(d.Context(), &egoscale.Zone{
 		Name: d.AvailabilityZone,
 	})
 	if err != nil {
@@ -459,7 +487,7 @@ func (d *Driver) Create() error {
 		ZoneID:     zone,
 	}
 
-	templates, err := client.ListWithContext(context.TODO(), &template)
+	templates, err := client.ListWithContext(d.Context(), &template)
 	if err != nil {
 		return err
 	}
@@ -504,7 +532,7 @@ func (d *Driver) Create() error {
 	log.Debugf("Image %v(10) = %s (%s)", d.Image, template.ID, d.SSHUser)
 
 	// Profile UUID
-	profiles, err := client.ListWithContext(context.TODO(), &egoscale.ServiceOffering{
+	profiles, err := client.ListWithContext(d.Context(), &egoscale.ServiceOffering{
 		Name: d.InstanceProfile,
 	})
 	if err != nil {
@@ -567,7 +595,7 @@ func (d *Driver) Create() error {
 	if d.SSHKey == "" {
 		keyPairName := fmt.Sprintf("docker-machine-%s", d.MachineName)
 		log.Infof("Generate an SSH keypair...")
-		resp, errCreate := client.RequestWithContext(context.TODO(), &egoscale.CreateSSHKeyPair{
+		resp, errCreate := client.RequestWithContext(d.Context(), &egoscale.CreateSSHKeyPair{
 			Name: keyPairName,
 		})
 		if errCreate != nil {
@@ -637,13 +665,11 @@ ssh_authorized_keys:
 		AffinityGroupIDs:  ags,
 	}
 	log.Infof("Deploying %s...", req.DisplayName)
-	resp, err := client.RequestWithContext(context.TODO(), req)
-	if err != nil {
+	vm := &egoscale.VirtualMachine{}
+	if err := d.asyncRequest("deploy", req, vm); err != nil {
 		return err
 	}
 
-	vm := resp.(*egoscale.VirtualMachine)
-
 	IPAddress := vm.IP()
 	if IPAddress != nil {
 		d.IPAddress = IPAddress.String()
@@ -664,7 +690,7 @@ ssh_authorized_keys:
 		key := &egoscale.SSHKeyPair{
 			Name: d.KeyPair,
 		}
-		if err := client.DeleteWithContext(context.TODO(), key); err != nil {
+		if err := client.DeleteWithContext(d.Context(), key); err != nil {
 			return err
 		}
 		d.KeyPair = ""
@@ -673,34 +699,37 @@ ssh_authorized_keys:
 	return nil
 }
 
+// StartContext starts the existing VM instance, aborting the polling of the
+// job when ctx is done.
+func (d *Driver) StartContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Start)
+}
+
+// StopContext stops the existing VM instance, aborting the polling of the
+// job when ctx is done.
+func (d *Driver) StopContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Stop)
+}
+
 // Start starts the existing VM instance.
 func (d *Driver) Start() error {
-	cs := d.client()
-	_, err := cs.RequestWithContext(context.TODO(), &egoscale.StartVirtualMachine{
+	return d.asyncRequest("start", &egoscale.StartVirtualMachine{
 		ID: d.ID,
-	})
-
-	return err
+	}, &egoscale.VirtualMachine{})
 }
 
 // Stop stops the existing VM instance.
 func (d *Driver) Stop() error {
-	cs := d.client()
-	_, err := cs.RequestWithContext(context.TODO(), &egoscale.StopVirtualMachine{
+	return d.asyncRequest("stop", &egoscale.StopVirtualMachine{
 		ID: d.ID,
-	})
-
-	return err
+	}, &egoscale.VirtualMachine{})
 }
 
 // Restart reboots the existing VM instance.
 func (d *Driver) Restart() error {
-	cs := d.client()
-	_, err := cs.RequestWithContext(context.TODO(), &egoscale.RebootVirtualMachine{
+	return d.asyncRequest("restart", &egoscale.RebootVirtualMachine{
 		ID: d.ID,
-	})
-
-	return err
+	}, &egoscale.VirtualMachine{})
 }
 
 // Kill stops a host forcefully (same as Stop)
@@ -708,6 +737,12 @@ func (d *Driver) Kill() error {
 	return d.Stop()
 }
 
+// RemoveContext destroys the VM instance, aborting the API calls in progress
+// when ctx is done.
+func (d *Driver) RemoveContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Remove)
+}
+
 // Remove destroys the VM instance and the associated SSH key.
 func (d *Driver) Remove() error {
 	client := d.client()
@@ -715,7 +750,7 @@ func (d *Driver) Remove() error {
 	// Destroy the SSH key from CloudStack
 	if d.KeyPair != "" {
 		key := &egoscale.SSHKeyPair{Name: d.KeyPair}
-		if err := client.DeleteWithContext(context.TODO(), key); err != nil {
+		if err := client.DeleteWithContext(d.Context(), key); err != nil {
 			return err
 		}
 	}
@@ -723,7 +758,7 @@ func (d *Driver) Remove() error {
 	// Destroy the virtual machine
 	if d.ID != nil {
 		vm := &egoscale.VirtualMachine{ID: d.ID}
-		if err := client.DeleteWithContext(context.TODO(), vm); err != nil {
+		if err := client.DeleteWithContext(d.Context(), vm); err != nil {
 			return err
 		}
 	}
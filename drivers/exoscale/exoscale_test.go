@@ -1,8 +1,11 @@
 package exoscale
 
 import (
+	"context"
+	"errors"
 	"testing"
 
+	"github.com/exoscale/egoscale"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/stretchr/testify/assert"
 )
@@ -23,3 +26,43 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestNewJobProgress(t *testing.T) {
+	queued := newJobProgress("default", "deploy", &egoscale.AsyncJobResult{JobStatus: egoscale.Pending})
+	assert.Equal(t, JobProgress{Machine: "default", Operation: "deploy", Status: JobQueued}, queued)
+
+	running := newJobProgress("default", "deploy", &egoscale.AsyncJobResult{JobStatus: egoscale.Pending, JobProcStatus: 40})
+	assert.Equal(t, JobInProgress, running.Status)
+	assert.Equal(t, 40, running.Percent)
+
+	done := newJobProgress("default", "deploy", &egoscale.AsyncJobResult{JobStatus: egoscale.Success})
+	assert.Equal(t, JobSucceeded, done.Status)
+	assert.Equal(t, 100, done.Percent)
+
+	failed := newJobProgress("default", "stop", &egoscale.AsyncJobResult{JobStatus: egoscale.Failure})
+	assert.Equal(t, JobFailed, failed.Status)
+}
+
+func TestSetProgressFunc(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	var reported []drivers.Progress
+	drivers.SetProgressFunc(driver, func(p drivers.Progress) {
+		reported = append(reported, p)
+	})
+
+	driver.reportJobProgress(JobProgress{Machine: "default", Operation: "start", Status: JobInProgress, Percent: 40})
+
+	assert.Equal(t, []drivers.Progress{{Operation: "start", Status: JobInProgress, Percent: 40}}, reported)
+}
+
+func TestStartContextStopsWhenCancelled(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+	driver.URL = "http://127.0.0.1:1/compute"
+	driver.ID = egoscale.MustParseUUID("5f2a4c5e-3e1c-4b5f-9b8e-2d7e5a1c9f00")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := driver.StartContext(ctx)
+
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+}
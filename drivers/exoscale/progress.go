@@ -0,0 +1,118 @@
+package exoscale
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/exoscale/egoscale"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// Status values reported for an async job.
+const (
+	JobQueued     = "queued"
+	JobInProgress = "in-progress"
+	JobSucceeded  = "success"
+	JobFailed     = "failure"
+)
+
+// JobProgress is a machine-readable snapshot of an Exoscale async job, emitted
+// every time the job is polled during Create, Start, Stop and Restart.
+type JobProgress struct {
+	Machine   string `json:"machine"`
+	Operation string `json:"operation"`
+	JobID     string `json:"jobId,omitempty"`
+	Status    string `json:"status"`
+	Percent   int    `json:"percent"`
+}
+
+func (p JobProgress) String() string {
+	return fmt.Sprintf("%s %s: %s (%d%%)", p.Machine, p.Operation, p.Status, p.Percent)
+}
+
+// logJobProgress logs the progress of a job. The JSON form goes to the
+// debug log so that it can be consumed by tooling.
+func logJobProgress(p JobProgress) {
+	log.Infof("%s", p)
+	if b, err := json.Marshal(p); err == nil {
+		log.Debugf("exoscale job progress: %s", b)
+	}
+}
+
+func newJobProgress(machine, operation string, job *egoscale.AsyncJobResult) JobProgress {
+	p := JobProgress{
+		Machine:   machine,
+		Operation: operation,
+	}
+	if job.JobID != nil {
+		p.JobID = job.JobID.String()
+	}
+
+	switch job.JobStatus {
+	case egoscale.Success:
+		p.Status = JobSucceeded
+		p.Percent = 100
+	case egoscale.Failure:
+		p.Status = JobFailed
+		p.Percent = 100
+	default:
+		// jobprocstatus stays at zero until the job has been picked up
+		p.Status = JobQueued
+		if job.JobProcStatus > 0 {
+			p.Status = JobInProgress
+			p.Percent = job.JobProcStatus
+			if p.Percent > 99 {
+				p.Percent = 99
+			}
+		}
+	}
+
+	return p
+}
+
+// SetProgressFunc sets the function the progress of the async jobs is
+// reported to, e.g. the event bus.
+func (d *Driver) SetProgressFunc(report func(drivers.Progress)) {
+	d.progress = report
+}
+
+// reportJobProgress logs p and reports it to the progress function, if any.
+func (d *Driver) reportJobProgress(p JobProgress) {
+	logJobProgress(p)
+	if d.progress != nil {
+		d.progress(drivers.Progress{
+			Operation: p.Operation,
+			Status:    p.Status,
+			Percent:   p.Percent,
+		})
+	}
+}
+
+// asyncRequest runs an async command, reporting every poll of the job, and
+// unmarshals the job result into resp. The polling stops when the context of
+// the operation is done.
+func (d *Driver) asyncRequest(operation string, cmd egoscale.AsyncCommand, resp interface{}) error {
+	var reqErr error
+	last := JobProgress{}
+	d.client().AsyncRequestWithContext(d.Context(), cmd, func(job *egoscale.AsyncJobResult, err error) bool {
+		if err != nil {
+			reqErr = err
+			return false
+		}
+
+		// only report changes, polling an idle job would otherwise flood the output
+		if p := newJobProgress(d.MachineName, operation, job); p != last {
+			d.reportJobProgress(p)
+			last = p
+		}
+
+		if job.JobStatus != egoscale.Pending {
+			reqErr = job.Result(resp)
+			return false
+		}
+		return true
+	})
+
+	return reqErr
+}
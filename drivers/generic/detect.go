@@ -0,0 +1,162 @@
+package generic
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/winrm"
+)
+
+// Environment is what was found on the host before provisioning it.
+type Environment struct {
+	OS         string
+	OSVersion  string
+	Docker     string
+	Containerd string
+	Firewall   string
+	Sudo       string
+}
+
+const (
+	sudoRoot     = "root"
+	sudoNoPasswd = "yes"
+	sudoPasswd   = "no"
+	sudoAdmin    = "admin"
+
+	firewallNone = "none"
+
+	osWindows = "windows"
+)
+
+// detectScript prints the environment as key=value lines in a single SSH
+// session. sudo is only used non-interactively, so a password prompt never
+// blocks it.
+const detectScript = `if [ -r /etc/os-release ]; then . /etc/os-release; fi
+echo "os=$ID"
+echo "os_version=$VERSION_ID"
+if [ "$(id -u)" = 0 ]; then SUDO=""; echo "sudo=root"; elif sudo -n true 2>/dev/null; then SUDO="sudo -n"; echo "sudo=yes"; else SUDO=""; echo "sudo=no"; fi
+if command -v docker >/dev/null 2>&1; then
+  echo "docker=$($SUDO docker version --format '{{.Server.Version}}' 2>/dev/null || docker --version 2>/dev/null | sed -e 's/^Docker version \([^,]*\).*/\1/')"
+fi
+if command -v containerd >/dev/null 2>&1; then
+  echo "containerd=$(containerd --version 2>/dev/null | awk '{print $3}')"
+fi
+if command -v ufw >/dev/null 2>&1 && $SUDO ufw status 2>/dev/null | grep -q 'Status: active'; then
+  echo "firewall=ufw"
+elif command -v firewall-cmd >/dev/null 2>&1 && $SUDO firewall-cmd --state 2>/dev/null | grep -q running; then
+  echo "firewall=firewalld"
+else
+  echo "firewall=none"
+fi
+`
+
+// detectWindowsScript is the PowerShell counterpart of detectScript, where
+// sudo tells whether the user is an administrator.
+const detectWindowsScript = `$os = Get-CimInstance -ClassName Win32_OperatingSystem
+"os=windows"
+"os_version=$($os.Version)"
+$principal = New-Object Security.Principal.WindowsPrincipal([Security.Principal.WindowsIdentity]::GetCurrent())
+if ($principal.IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator)) { "sudo=admin" } else { "sudo=no" }
+if (Get-Command docker -ErrorAction SilentlyContinue) {
+  $version = docker version --format '{{.Server.Version}}' 2>$null
+  if (-not $version) { $version = docker version --format '{{.Client.Version}}' 2>$null }
+  "docker=$version"
+}
+if (Get-Command containerd -ErrorAction SilentlyContinue) { "containerd=$((containerd --version) -split ' ' | Select-Object -Index 2)" }
+if (Get-NetFirewallProfile | Where-Object Enabled) { "firewall=windows" } else { "firewall=none" }
+`
+
+func detectEnvironment(run func(string) (string, error)) (*Environment, error) {
+	script := detectScript
+	// "ver" is a cmd builtin, so this works from cmd and PowerShell, over
+	// WinRM and OpenSSH, and fails on other hosts
+	if ver, err := run("cmd /c ver"); err == nil && strings.Contains(ver, "Microsoft Windows") {
+		script = winrm.PowerShell(detectWindowsScript)
+	}
+
+	out, err := run(script)
+	if err != nil {
+		return nil, fmt.Errorf("error detecting the host environment: %s", err)
+	}
+	return parseEnvironment(out), nil
+}
+
+func parseEnvironment(out string) *Environment {
+	env := &Environment{Firewall: firewallNone}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "os":
+			env.OS = value
+		case "os_version":
+			env.OSVersion = value
+		case "docker":
+			env.Docker = value
+		case "containerd":
+			env.Containerd = value
+		case "firewall":
+			env.Firewall = value
+		case "sudo":
+			env.Sudo = value
+		}
+	}
+
+	return env
+}
+
+func (e *Environment) String() string {
+	parts := []string{strings.TrimSpace(e.OS + " " + e.OSVersion)}
+	if e.Docker != "" {
+		parts = append(parts, "Docker "+e.Docker)
+	} else {
+		parts = append(parts, "no Docker")
+	}
+	if e.Containerd != "" {
+		parts = append(parts, "containerd "+e.Containerd)
+	}
+	if e.Firewall != firewallNone {
+		parts = append(parts, e.Firewall+" active")
+	}
+	switch e.Sudo {
+	case sudoRoot:
+		parts = append(parts, "root login")
+	case sudoNoPasswd:
+		parts = append(parts, "passwordless sudo")
+	case sudoAdmin:
+		parts = append(parts, "administrator")
+	default:
+		if e.OS == osWindows {
+			parts = append(parts, "not an administrator")
+			break
+		}
+		parts = append(parts, "no passwordless sudo")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// openPortsCommand returns the command allowing the TCP ports through the
+// firewall, or "" when there is no active firewall.
+func openPortsCommand(firewall string, ports ...int) string {
+	var cmds []string
+	for _, port := range ports {
+		switch firewall {
+		case "ufw":
+			cmds = append(cmds, fmt.Sprintf("sudo ufw allow %d/tcp", port))
+		case "firewalld":
+			cmds = append(cmds, fmt.Sprintf("sudo firewall-cmd --permanent --add-port=%d/tcp", port))
+		}
+	}
+	if len(cmds) == 0 {
+		return ""
+	}
+	if firewall == "firewalld" {
+		cmds = append(cmds, "sudo firewall-cmd --reload")
+	}
+	return strings.Join(cmds, " && ")
+}
@@ -15,16 +15,29 @@ import (
 	"github.com/rancher/machine/libmachine/mcnflag"
 	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/state"
+	"github.com/rancher/machine/libmachine/winrm"
 )
 
 type Driver struct {
 	*drivers.BaseDriver
-	EnginePort int
-	SSHKey     string
+	EnginePort   int
+	SSHKey       string
+	SSHPassword  string `secret:"true"`
+	OpenFirewall bool
+	Environment  *Environment
+
+	Transport     string
+	WinRMPort     int
+	WinRMUser     string
+	WinRMPassword string `secret:"true"`
+	WinRMHTTPS    bool
+	WinRMInsecure bool
 }
 
 const (
-	defaultTimeout = 15 * time.Second
+	defaultTimeout   = 15 * time.Second
+	defaultWinRMUser = "Administrator"
+	transportSSH     = "ssh"
 )
 
 // GetCreateFlags registers the flags this driver adds to
@@ -54,12 +67,54 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  "",
 			EnvVar: "GENERIC_SSH_KEY",
 		},
+		mcnflag.StringFlag{
+			Name:   "generic-ssh-password",
+			Usage:  "SSH password, tried when the SSH key is not accepted",
+			EnvVar: "GENERIC_SSH_PASSWORD",
+		},
 		mcnflag.IntFlag{
 			Name:   "generic-ssh-port",
 			Usage:  "SSH port",
 			Value:  drivers.DefaultSSHPort,
 			EnvVar: "GENERIC_SSH_PORT",
 		},
+		mcnflag.BoolFlag{
+			Name:   "generic-open-firewall",
+			Usage:  "Allow the SSH and Docker engine ports through an active ufw or firewalld firewall",
+			EnvVar: "GENERIC_OPEN_FIREWALL",
+		},
+		mcnflag.StringFlag{
+			Name:   "generic-transport",
+			Usage:  "Transport to the host, ssh or winrm (for Windows hosts without OpenSSH)",
+			Value:  transportSSH,
+			EnvVar: "GENERIC_TRANSPORT",
+		},
+		mcnflag.StringFlag{
+			Name:   "generic-winrm-user",
+			Usage:  "WinRM user",
+			Value:  defaultWinRMUser,
+			EnvVar: "GENERIC_WINRM_USER",
+		},
+		mcnflag.StringFlag{
+			Name:   "generic-winrm-password",
+			Usage:  "WinRM password",
+			EnvVar: "GENERIC_WINRM_PASSWORD",
+		},
+		mcnflag.IntFlag{
+			Name:   "generic-winrm-port",
+			Usage:  "WinRM port (default 5985, or 5986 with --generic-winrm-https)",
+			EnvVar: "GENERIC_WINRM_PORT",
+		},
+		mcnflag.BoolFlag{
+			Name:   "generic-winrm-https",
+			Usage:  "Use WinRM over HTTPS",
+			EnvVar: "GENERIC_WINRM_HTTPS",
+		},
+		mcnflag.BoolFlag{
+			Name:   "generic-winrm-insecure",
+			Usage:  "Do not verify the WinRM HTTPS certificate",
+			EnvVar: "GENERIC_WINRM_INSECURE",
+		},
 	}
 }
 
@@ -91,17 +146,47 @@ func (d *Driver) GetSSHKeyPath() string {
 	return d.SSHKeyPath
 }
 
+// GetSSHPassword returns the password of the SSH user, given by
+// --generic-ssh-password.
+func (d *Driver) GetSSHPassword() string {
+	return d.SSHPassword
+}
+
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.EnginePort = flags.Int("generic-engine-port")
 	d.IPAddress = flags.String("generic-ip-address")
 	d.SSHUser = flags.String("generic-ssh-user")
 	d.SSHKey = flags.String("generic-ssh-key")
+	d.SSHPassword = flags.String("generic-ssh-password")
 	d.SSHPort = flags.Int("generic-ssh-port")
+	d.OpenFirewall = flags.Bool("generic-open-firewall")
+	d.Transport = flags.String("generic-transport")
+	d.WinRMUser = flags.String("generic-winrm-user")
+	d.WinRMPassword = flags.String("generic-winrm-password")
+	d.WinRMPort = flags.Int("generic-winrm-port")
+	d.WinRMHTTPS = flags.Bool("generic-winrm-https")
+	d.WinRMInsecure = flags.Bool("generic-winrm-insecure")
 
 	if d.IPAddress == "" {
 		return errors.New("generic driver requires the --generic-ip-address option")
 	}
 
+	switch d.Transport {
+	case transportSSH:
+	case drivers.TransportWinRM:
+		if d.WinRMPassword == "" {
+			return errors.New("generic driver requires the --generic-winrm-password option with the winrm transport")
+		}
+		if d.WinRMPort == 0 {
+			d.WinRMPort = winrm.DefaultPort
+			if d.WinRMHTTPS {
+				d.WinRMPort = winrm.DefaultHTTPSPort
+			}
+		}
+	default:
+		return fmt.Errorf("--generic-transport must be ssh or winrm, not %q", d.Transport)
+	}
+
 	return nil
 }
 
@@ -118,7 +203,9 @@ func (d *Driver) PreCreateCheck() error {
 }
 
 func (d *Driver) Create() error {
-	if d.SSHKey == "" {
+	if d.SSHKey == "" && d.SSHPassword != "" {
+		log.Info("No SSH key specified. Using the SSH password.")
+	} else if d.SSHKey == "" {
 		log.Info("No SSH key specified. Assuming an existing key at the default location.")
 	} else {
 		log.Info("Importing SSH key...")
@@ -135,9 +222,68 @@ func (d *Driver) Create() error {
 
 	log.Debugf("IP: %s", d.IPAddress)
 
+	return d.adopt()
+}
+
+// adopt inspects the existing host so that problems show up before
+// provisioning, and prepares what provisioning cannot do by itself.
+func (d *Driver) adopt() error {
+	if d.Transport == drivers.TransportWinRM {
+		if err := drivers.WaitForWinRM(d); err != nil {
+			return err
+		}
+	} else if err := drivers.WaitForSSH(d); err != nil {
+		return err
+	}
+
+	log.Info("Detecting the host environment...")
+	env, err := detectEnvironment(d.run)
+	if err != nil {
+		return err
+	}
+	d.Environment = env
+	log.Infof("Found %s", env)
+
+	if env.OS == osWindows {
+		// the provisioner opens the engine port in the Windows firewall
+		if env.Sudo != sudoAdmin {
+			user := d.SSHUser
+			if d.Transport == drivers.TransportWinRM {
+				user = d.WinRMUser
+			}
+			return fmt.Errorf("user %s needs to be an administrator to provision the host", user)
+		}
+	} else if env.Sudo == sudoPasswd {
+		return fmt.Errorf("user %s needs passwordless sudo to provision the host", d.SSHUser)
+	}
+
+	if env.Docker != "" {
+		log.Infof("Keeping the installed Docker %s", env.Docker)
+	}
+
+	if env.OS != osWindows && env.Firewall != firewallNone {
+		if !d.OpenFirewall {
+			log.Warnf("The %s firewall is active, make sure it allows TCP port %d, or use --generic-open-firewall", env.Firewall, d.EnginePort)
+			return nil
+		}
+
+		log.Infof("Allowing ports %d and %d through %s...", d.SSHPort, d.EnginePort, env.Firewall)
+		if _, err := drivers.RunSSHCommandFromDriver(d, openPortsCommand(env.Firewall, d.SSHPort, d.EnginePort)); err != nil {
+			return fmt.Errorf("error opening the firewall: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// run runs a command on the host over the configured transport.
+func (d *Driver) run(command string) (string, error) {
+	if d.Transport == drivers.TransportWinRM {
+		return drivers.RunWinRMCommandFromDriver(d, command)
+	}
+	return drivers.RunSSHCommandFromDriver(d, command)
+}
+
 func (d *Driver) GetURL() (string, error) {
 	if err := drivers.MustBeRunning(d); err != nil {
 		return "", err
@@ -151,8 +297,19 @@ func (d *Driver) GetURL() (string, error) {
 	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.EnginePort))), nil
 }
 
+// SetEnginePort sets the port of the Docker daemon, overriding
+// --generic-engine-port.
+func (d *Driver) SetEnginePort(port int) error {
+	d.EnginePort = port
+	return nil
+}
+
 func (d *Driver) GetState() (state.State, error) {
-	address := net.JoinHostPort(d.IPAddress, strconv.Itoa(d.SSHPort))
+	port := d.SSHPort
+	if d.Transport == drivers.TransportWinRM {
+		port = d.WinRMPort
+	}
+	address := net.JoinHostPort(d.IPAddress, strconv.Itoa(port))
 
 	_, err := net.DialTimeout("tcp", address, defaultTimeout)
 	if err != nil {
@@ -171,7 +328,11 @@ func (d *Driver) Stop() error {
 }
 
 func (d *Driver) Restart() error {
-	_, err := drivers.RunSSHCommandFromDriver(d, "sudo shutdown -r now")
+	command := "sudo shutdown -r now"
+	if d.Environment != nil && d.Environment.OS == osWindows {
+		command = "shutdown /r /t 0"
+	}
+	_, err := d.run(command)
 	return err
 }
 
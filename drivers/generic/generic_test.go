@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,3 +25,101 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestSetConfigFromFlagsSSHPassword(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"generic-ip-address":   "10.0.0.5",
+			"generic-ssh-password": "secret",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(checkFlags))
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, &ssh.Auth{Passwords: []string{"secret"}, HostKeyAlias: "default"}, drivers.GetSSHAuth(driver))
+}
+
+func TestParseEnvironment(t *testing.T) {
+	env := parseEnvironment(`os=ubuntu
+os_version=22.04
+sudo=yes
+docker=24.0.5
+containerd=1.6.22
+firewall=ufw
+`)
+
+	assert.Equal(t, &Environment{
+		OS:         "ubuntu",
+		OSVersion:  "22.04",
+		Docker:     "24.0.5",
+		Containerd: "1.6.22",
+		Firewall:   "ufw",
+		Sudo:       sudoNoPasswd,
+	}, env)
+	assert.Equal(t, "ubuntu 22.04, Docker 24.0.5, containerd 1.6.22, ufw active, passwordless sudo", env.String())
+
+	env = parseEnvironment("os=rhel\nos_version=9.2\nsudo=no\n")
+	assert.Equal(t, firewallNone, env.Firewall)
+	assert.Equal(t, "rhel 9.2, no Docker, no passwordless sudo", env.String())
+}
+
+func TestOpenPortsCommand(t *testing.T) {
+	assert.Equal(t, "sudo ufw allow 22/tcp && sudo ufw allow 2376/tcp", openPortsCommand("ufw", 22, 2376))
+	assert.Equal(t, "sudo firewall-cmd --permanent --add-port=22/tcp && sudo firewall-cmd --permanent --add-port=2376/tcp && sudo firewall-cmd --reload", openPortsCommand("firewalld", 22, 2376))
+	assert.Equal(t, "", openPortsCommand(firewallNone, 22, 2376))
+}
+
+func TestSetConfigFromFlagsWinRM(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"generic-ip-address":     "10.0.0.5",
+			"generic-transport":      "winrm",
+			"generic-winrm-password": "secret",
+			"generic-winrm-https":    true,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	assert.NoError(t, driver.SetConfigFromFlags(checkFlags))
+	assert.Empty(t, checkFlags.InvalidFlags)
+
+	opts, err := drivers.GetWinRMOptions(driver)
+	assert.NoError(t, err)
+	assert.Equal(t, &drivers.WinRMOptions{
+		Transport:     "winrm",
+		WinRMPort:     5986,
+		WinRMUser:     "Administrator",
+		WinRMPassword: "secret",
+		WinRMHTTPS:    true,
+	}, opts)
+
+	delete(checkFlags.FlagsValues, "generic-winrm-password")
+	assert.EqualError(t, driver.SetConfigFromFlags(checkFlags), "generic driver requires the --generic-winrm-password option with the winrm transport")
+
+	checkFlags.FlagsValues["generic-transport"] = "telnet"
+	assert.EqualError(t, driver.SetConfigFromFlags(checkFlags), `--generic-transport must be ssh or winrm, not "telnet"`)
+}
+
+func TestDetectEnvironmentWindows(t *testing.T) {
+	var commands []string
+	env, err := detectEnvironment(func(cmd string) (string, error) {
+		commands = append(commands, cmd)
+		if cmd == "cmd /c ver" {
+			return "\r\nMicrosoft Windows [Version 10.0.20348.587]\r\n", nil
+		}
+		return "os=windows\r\nos_version=10.0.20348\r\nsudo=admin\r\nfirewall=windows\r\n", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, commands, 2)
+	assert.Contains(t, commands[1], "powershell -NoProfile")
+	assert.Equal(t, "windows 10.0.20348, no Docker, windows active, administrator", env.String())
+
+	env.Sudo = sudoPasswd
+	assert.Equal(t, "windows 10.0.20348, no Docker, windows active, not an administrator", env.String())
+}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"golang.org/x/oauth2/google"
 	raw "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
@@ -31,53 +34,66 @@ type ComputeUtil struct {
 	address           string
 	network           string
 	subnetwork        string
+	networkProject    string
+	tags              []string
 	preemptible       bool
+	spot              bool
+	spotTermination   string
+	spotAutoRestart   bool
+	localSSDCount     int
+	localSSDInterface string
+	localSSDMount     string
 	useInternalIP     bool
 	useInternalIPOnly bool
 	service           *raw.Service
 	zoneURL           string
-	globalURL         string
 	SwarmMaster       bool
 	SwarmHost         string
+	dockerPort        string
 	openPorts         []string
 }
 
 const (
 	apiURL            = "https://www.googleapis.com/compute/v1/projects/"
 	firewallRule      = "docker-machines"
-	dockerPort        = "2376"
+	defaultDockerPort = "2376"
 	firewallTargetTag = "docker-machine"
+
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
 )
 
+// contextTransport sends the requests with the context of the operation in
+// progress of the driver, which aborts them when done.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
 // NewComputeUtil creates and initializes a ComputeUtil.
 func newComputeUtil(driver *Driver) (*ComputeUtil, error) {
-	ctx := context.Background()
-	var client *http.Client
-
-	if driver.Auth != "" {
-		jsonCreds, err := base64.StdEncoding.DecodeString(driver.Auth)
-		if err != nil {
-			return nil, err
-		}
+	ctx := driver.Context()
 
-		creds, err := google.CredentialsFromJSON(ctx, jsonCreds, raw.ComputeScope)
-		if err != nil {
-			return nil, err
-		}
-		client = oauth2.NewClient(ctx, creds.TokenSource)
-	} else {
-		var err error
-		client, err = google.DefaultClient(ctx, raw.ComputeScope)
-		if err != nil {
-			return nil, err
-		}
+	tokenSource, err := newTokenSource(ctx, driver.Auth, driver.ImpersonateServiceAccount)
+	if err != nil {
+		return nil, err
 	}
 
+	client := oauth2.NewClient(ctx, tokenSource)
+	client.Transport = contextTransport{ctx: ctx, base: client.Transport}
 	service, err := raw.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		return nil, err
 	}
 
+	networkProject := driver.NetworkProject
+	if networkProject == "" {
+		networkProject = driver.Project
+	}
+
 	return &ComputeUtil{
 		zone:              driver.Zone,
 		instanceName:      driver.MachineName,
@@ -87,18 +103,78 @@ func newComputeUtil(driver *Driver) (*ComputeUtil, error) {
 		address:           driver.Address,
 		network:           driver.Network,
 		subnetwork:        driver.Subnetwork,
+		networkProject:    networkProject,
+		tags:              parseTags(driver),
 		preemptible:       driver.Preemptible,
+		spot:              driver.Spot,
+		spotTermination:   driver.SpotTermination,
+		spotAutoRestart:   driver.SpotAutoRestart,
+		localSSDCount:     driver.LocalSSDCount,
+		localSSDInterface: driver.LocalSSDInterface,
+		localSSDMount:     driver.LocalSSDMount,
 		useInternalIP:     driver.UseInternalIP,
 		useInternalIPOnly: driver.UseInternalIPOnly,
 		service:           service,
 		zoneURL:           apiURL + driver.Project + "/zones/" + driver.Zone,
-		globalURL:         apiURL + driver.Project + "/global",
 		SwarmMaster:       driver.SwarmMaster,
 		SwarmHost:         driver.SwarmHost,
+		dockerPort:        strconv.Itoa(driver.GetEnginePort()),
 		openPorts:         driver.OpenPorts,
 	}, nil
 }
 
+// newTokenSource returns the token source to call the compute API with. The
+// credentials are either given as base64 encoded JSON, which can be a service
+// account key or an external account configuration for workload identity
+// federation, or application default credentials. When impersonating, these
+// credentials only need to be allowed to create tokens for the service account.
+func newTokenSource(ctx context.Context, auth, serviceAccounts string) (oauth2.TokenSource, error) {
+	scope := raw.ComputeScope
+	if serviceAccounts != "" {
+		scope = cloudPlatformScope
+	}
+
+	var creds *google.Credentials
+	if auth != "" {
+		jsonCreds, err := base64.StdEncoding.DecodeString(auth)
+		if err != nil {
+			return nil, err
+		}
+
+		creds, err = google.CredentialsFromJSON(ctx, jsonCreds, scope)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		creds, err = google.FindDefaultCredentials(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if serviceAccounts == "" {
+		return creds.TokenSource, nil
+	}
+
+	target, delegates := impersonationChain(serviceAccounts)
+	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: target,
+		Delegates:       delegates,
+		Scopes:          []string{raw.ComputeScope},
+	}, option.WithTokenSource(creds.TokenSource))
+}
+
+// impersonationChain splits a comma-separated list of service accounts into
+// the target service account, which comes last, and its delegates.
+func impersonationChain(serviceAccounts string) (string, []string) {
+	chain := strings.Split(serviceAccounts, ",")
+	for i := range chain {
+		chain[i] = strings.TrimSpace(chain[i])
+	}
+	return chain[len(chain)-1], chain[:len(chain)-1]
+}
+
 func (c *ComputeUtil) diskName() string {
 	return c.instanceName + "-disk"
 }
@@ -154,8 +230,55 @@ func (c *ComputeUtil) region() string {
 	return c.zone[:len(c.zone)-2]
 }
 
-func (c *ComputeUtil) firewallRule() (*raw.Firewall, error) {
-	return c.service.Firewalls.Get(c.project, firewallRule).Do()
+func (c *ComputeUtil) firewallRule(project string) (*raw.Firewall, error) {
+	return c.service.Firewalls.Get(project, firewallRule).Do()
+}
+
+// networkURL returns the URL of a network given by name or URL, looking up
+// names in the network project.
+func (c *ComputeUtil) networkURL(network string) string {
+	if strings.Contains(network, "/networks/") {
+		return network
+	}
+	return apiURL + c.networkProject + "/global/networks/" + network
+}
+
+// subnetworkURL returns the URL of the subnetwork, which may be in another
+// project with a Shared VPC. Names are looked up in the network project.
+func (c *ComputeUtil) subnetworkURL() string {
+	if strings.Contains(c.subnetwork, "/subnetworks/") || c.subnetwork == "" {
+		return c.subnetwork
+	}
+	return "projects/" + c.networkProject + "/regions/" + c.region() + "/subnetworks/" + c.subnetwork
+}
+
+var (
+	subnetworkPathRe = regexp.MustCompile(`projects/([^/]+)/regions/([^/]+)/subnetworks/([^/]+)$`)
+	networkPathRe    = regexp.MustCompile(`projects/([^/]+)/global/networks/[^/]+$`)
+)
+
+// firewallNetwork returns the project and the URL of the network the
+// firewall rule is created for. Without a network given explicitly, this is
+// the network of the subnetwork, in the host project with a Shared VPC.
+func (c *ComputeUtil) firewallNetwork() (string, string, error) {
+	network := c.networkURL(c.network)
+	if c.subnetwork != "" && c.network == defaultNetwork {
+		m := subnetworkPathRe.FindStringSubmatch(c.subnetworkURL())
+		if m == nil {
+			return "", "", fmt.Errorf("invalid subnetwork %q", c.subnetwork)
+		}
+		subnetwork, err := c.service.Subnetworks.Get(m[1], m[2], m[3]).Do()
+		if err != nil {
+			return "", "", fmt.Errorf("unable to get subnetwork %q: %v", c.subnetwork, err)
+		}
+		network = subnetwork.Network
+	}
+
+	m := networkPathRe.FindStringSubmatch(network)
+	if m == nil {
+		return "", "", fmt.Errorf("invalid network %q", network)
+	}
+	return m[1], network, nil
 }
 
 func missingOpenedPorts(rule *raw.Firewall, ports []string) map[string][]string {
@@ -179,6 +302,10 @@ func missingOpenedPorts(rule *raw.Firewall, ports []string) map[string][]string
 }
 
 func (c *ComputeUtil) portsUsed() ([]string, error) {
+	dockerPort := c.dockerPort
+	if dockerPort == "" {
+		dockerPort = defaultDockerPort
+	}
 	ports := []string{dockerPort + "/tcp"}
 
 	if c.SwarmMaster {
@@ -202,8 +329,13 @@ func (c *ComputeUtil) portsUsed() ([]string, error) {
 func (c *ComputeUtil) openFirewallPorts(d *Driver) error {
 	log.Infof("Opening firewall ports")
 
+	project, network, err := c.firewallNetwork()
+	if err != nil {
+		return err
+	}
+
 	create := false
-	rule, _ := c.firewallRule()
+	rule, _ := c.firewallRule(project)
 	if rule == nil {
 		create = true
 		rule = &raw.Firewall{
@@ -211,7 +343,7 @@ func (c *ComputeUtil) openFirewallPorts(d *Driver) error {
 			Allowed:      []*raw.FirewallAllowed{},
 			SourceRanges: []string{"0.0.0.0/0"},
 			TargetTags:   []string{firewallTargetTag},
-			Network:      c.globalURL + "/networks/" + d.Network,
+			Network:      network,
 		}
 	}
 
@@ -233,16 +365,16 @@ func (c *ComputeUtil) openFirewallPorts(d *Driver) error {
 
 	var op *raw.Operation
 	if create {
-		op, err = c.service.Firewalls.Insert(c.project, rule).Do()
+		op, err = c.service.Firewalls.Insert(project, rule).Do()
 	} else {
-		op, err = c.service.Firewalls.Update(c.project, firewallRule, rule).Do()
+		op, err = c.service.Firewalls.Update(project, firewallRule, rule).Do()
 	}
 
 	if err != nil {
 		return err
 	}
 
-	return c.waitForGlobalOp(op.Name)
+	return c.waitForGlobalOp(project, op.Name)
 }
 
 // instance retrieves the instance.
@@ -254,13 +386,6 @@ func (c *ComputeUtil) instance() (*raw.Instance, error) {
 func (c *ComputeUtil) createInstance(d *Driver) error {
 	log.Infof("Creating instance")
 
-	var net string
-	if strings.Contains(d.Network, "/networks/") {
-		net = d.Network
-	} else {
-		net = c.globalURL + "/networks/" + d.Network
-	}
-
 	instance := &raw.Instance{
 		Name:        c.instanceName,
 		Description: "docker host vm",
@@ -268,18 +393,19 @@ func (c *ComputeUtil) createInstance(d *Driver) error {
 		Disks: []*raw.AttachedDisk{
 			{
 				Boot:       true,
-				AutoDelete: true,
+				AutoDelete: !c.keepsDiskOnPreemption(),
 				Type:       "PERSISTENT",
 				Mode:       "READ_WRITE",
 			},
 		},
 		NetworkInterfaces: []*raw.NetworkInterface{
 			{
-				Network: net,
+				Subnetwork:    c.subnetworkURL(),
+				AliasIpRanges: aliasIPRanges(d.AliasIPRanges),
 			},
 		},
 		Tags: &raw.Tags{
-			Items: parseTags(d),
+			Items: c.tags,
 		},
 		ServiceAccounts: []*raw.ServiceAccount{
 			{
@@ -287,15 +413,23 @@ func (c *ComputeUtil) createInstance(d *Driver) error {
 				Scopes: strings.Split(d.Scopes, ","),
 			},
 		},
-		Scheduling: &raw.Scheduling{
-			Preemptible: c.preemptible,
-		},
+		Scheduling: c.scheduling(),
+	}
+
+	instance.Disks = append(instance.Disks, c.localSSDs()...)
+	instance.ShieldedInstanceConfig = shieldedInstanceConfig(d)
+	if d.ConfidentialCompute {
+		instance.ConfidentialInstanceConfig = &raw.ConfidentialInstanceConfig{
+			EnableConfidentialCompute: true,
+		}
+		// Confidential VMs do not support live migration
+		instance.Scheduling.OnHostMaintenance = "TERMINATE"
 	}
 
-	if strings.Contains(c.subnetwork, "/subnetworks/") {
-		instance.NetworkInterfaces[0].Subnetwork = c.subnetwork
-	} else if c.subnetwork != "" {
-		instance.NetworkInterfaces[0].Subnetwork = "projects/" + c.project + "/regions/" + c.region() + "/subnetworks/" + c.subnetwork
+	// GCE infers the network from the subnetwork, which is in the host
+	// project with a Shared VPC
+	if c.subnetwork == "" || d.Network != defaultNetwork {
+		instance.NetworkInterfaces[0].Network = c.networkURL(d.Network)
 	}
 
 	if !c.useInternalIPOnly {
@@ -345,6 +479,95 @@ func (c *ComputeUtil) createInstance(d *Driver) error {
 	return c.uploadSSHKeyAndUserdata(instance, d.GetSSHKeyPath(), d.Userdata)
 }
 
+// scheduling returns the scheduling options of the instance. Spot and
+// preemptible VMs cannot be restarted automatically nor live migrated.
+func (c *ComputeUtil) scheduling() *raw.Scheduling {
+	switch {
+	case c.spot:
+		return &raw.Scheduling{
+			ProvisioningModel:         "SPOT",
+			InstanceTerminationAction: c.spotTermination,
+			AutomaticRestart:          googleapi.Bool(false),
+			OnHostMaintenance:         "TERMINATE",
+		}
+	case c.preemptible:
+		return &raw.Scheduling{
+			Preemptible:       true,
+			AutomaticRestart:  googleapi.Bool(false),
+			OnHostMaintenance: "TERMINATE",
+		}
+	}
+	return &raw.Scheduling{}
+}
+
+// localSSDs returns the local SSDs to attach to the instance.
+func (c *ComputeUtil) localSSDs() []*raw.AttachedDisk {
+	var disks []*raw.AttachedDisk
+	for i := 0; i < c.localSSDCount; i++ {
+		disks = append(disks, &raw.AttachedDisk{
+			Type:       "SCRATCH",
+			AutoDelete: true,
+			Interface:  c.localSSDInterface,
+			InitializeParams: &raw.AttachedDiskInitializeParams{
+				DiskType: c.zoneURL + "/diskTypes/local-ssd",
+			},
+		})
+	}
+	return disks
+}
+
+// localSSDMountScript returns a script formatting and mounting the local SSDs
+// on boot, or an empty string if they should not be mounted. Local SSDs are
+// blank again after the instance was stopped, so this is done on every boot.
+func (c *ComputeUtil) localSSDMountScript() string {
+	if c.localSSDCount == 0 || c.localSSDMount == "" {
+		return ""
+	}
+
+	devices := "/dev/disk/by-id/google-local-ssd-*"
+	if c.localSSDInterface == "NVME" {
+		devices = "/dev/disk/by-id/google-local-nvme-ssd-*"
+	}
+	return fmt.Sprintf(localSSDMountScript, c.localSSDMount, devices)
+}
+
+const localSSDMountScript = `#!/bin/bash
+set -e
+mount_point=%q
+if mountpoint -q "$mount_point"; then
+  exit 0
+fi
+disks=(%s)
+device=${disks[0]}
+if [ ${#disks[@]} -gt 1 ]; then
+  device=/dev/md0
+  mdadm --assemble "$device" "${disks[@]}" || mdadm --create "$device" --level=0 --raid-devices=${#disks[@]} --force --run "${disks[@]}"
+fi
+blkid "$device" || mkfs.ext4 -F "$device"
+mkdir -p "$mount_point"
+mount -o discard,defaults "$device" "$mount_point"
+`
+
+// shieldedInstanceConfig returns the Shielded VM options of the instance, or
+// nil to keep the defaults of the image when none were requested.
+func shieldedInstanceConfig(d *Driver) *raw.ShieldedInstanceConfig {
+	if !d.ShieldedSecureBoot && !d.ShieldedVTPM && !d.ShieldedIntegrityMonitoring {
+		return nil
+	}
+	return &raw.ShieldedInstanceConfig{
+		EnableSecureBoot:          d.ShieldedSecureBoot,
+		EnableVtpm:                d.ShieldedVTPM,
+		EnableIntegrityMonitoring: d.ShieldedIntegrityMonitoring,
+	}
+}
+
+// keepsDiskOnPreemption reports whether the boot disk must survive the
+// deletion of a preempted Spot VM, so that the instance can be recreated
+// from it.
+func (c *ComputeUtil) keepsDiskOnPreemption() bool {
+	return c.spot && c.spotTermination == "DELETE" && c.spotAutoRestart
+}
+
 // configureInstance configures an existing instance for use with Docker Machine.
 func (c *ComputeUtil) configureInstance(d *Driver) error {
 	log.Infof("Configuring instance")
@@ -354,25 +577,25 @@ func (c *ComputeUtil) configureInstance(d *Driver) error {
 		return err
 	}
 
-	if err := c.addFirewallTag(instance); err != nil {
+	if err := c.addTags(instance); err != nil {
 		return err
 	}
 
 	return c.uploadSSHKeyAndUserdata(instance, d.GetSSHKeyPath(), d.Userdata)
 }
 
-// addFirewallTag adds a tag to the instance to match the firewall rule.
-func (c *ComputeUtil) addFirewallTag(instance *raw.Instance) error {
-	log.Infof("Adding tag for the firewall rule")
+// addTags adds the tag matching the firewall rule and the network tags
+// given to an existing instance.
+func (c *ComputeUtil) addTags(instance *raw.Instance) error {
+	log.Infof("Adding network tags")
 
 	tags := instance.Tags
-	for _, tag := range tags.Items {
-		if tag == firewallTargetTag {
-			return nil
-		}
+	missing := missingTags(tags.Items, c.tags)
+	if len(missing) == 0 {
+		return nil
 	}
 
-	tags.Items = append(tags.Items, firewallTargetTag)
+	tags.Items = append(tags.Items, missing...)
 
 	op, err := c.service.Instances.SetTags(c.project, c.zone, instance.Name, tags).Do()
 	if err != nil {
@@ -409,6 +632,15 @@ func (c *ComputeUtil) uploadSSHKeyAndUserdata(instance *raw.Instance, sshKeyPath
 		})
 	}
 
+	// the startup script is run by the guest agent on every boot, independent
+	// of the cloud-init user-data
+	if script := c.localSSDMountScript(); script != "" {
+		metadata.Items = append(metadata.Items, &raw.MetadataItems{
+			Key:   "startup-script",
+			Value: &script,
+		})
+	}
+
 	op, err := c.service.Instances.SetMetadata(c.project, c.zone, c.instanceName, metadata).Do()
 
 	return c.waitForRegionalOp(op.Name)
@@ -419,10 +651,83 @@ func parseTags(d *Driver) []string {
 	tags := []string{firewallTargetTag}
 
 	if d.Tags != "" {
-		tags = append(tags, strings.Split(d.Tags, ",")...)
+		for _, tag := range strings.Split(d.Tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
 	}
 
-	return tags
+	return missingTags(nil, tags)
+}
+
+var tagRe = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// validateTags checks the comma-separated tags are valid network tags.
+func validateTags(tags string) error {
+	for _, tag := range parseTags(&Driver{Tags: tags}) {
+		if !tagRe.MatchString(tag) {
+			return fmt.Errorf("invalid network tag %q, tags must be 1-63 lowercase letters, digits or dashes, starting with a letter", tag)
+		}
+	}
+	return nil
+}
+
+// missingTags returns the tags not in existing, without duplicates.
+func missingTags(existing, tags []string) []string {
+	seen := map[string]bool{}
+	for _, tag := range existing {
+		seen[tag] = true
+	}
+
+	var missing []string
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// parseAliasIPRange parses an alias IP range given as an IP, a CIDR or a
+// netmask such as /24, optionally prefixed by the name of a secondary range
+// of the subnetwork to allocate it from, e.g. pods:/24.
+func parseAliasIPRange(spec string) (*raw.AliasIpRange, error) {
+	aliasRange := &raw.AliasIpRange{IpCidrRange: spec}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		aliasRange.SubnetworkRangeName = spec[:i]
+		aliasRange.IpCidrRange = spec[i+1:]
+	}
+
+	cidr := aliasRange.IpCidrRange
+	switch {
+	case strings.HasPrefix(cidr, "/"):
+		if n, err := strconv.Atoi(cidr[1:]); err != nil || n < 0 || n > 32 {
+			return nil, fmt.Errorf("invalid alias IP range %q, bad netmask", spec)
+		}
+	case strings.Contains(cidr, "/"):
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid alias IP range %q: %v", spec, err)
+		}
+	default:
+		if net.ParseIP(cidr) == nil {
+			return nil, fmt.Errorf("invalid alias IP range %q, must be an IP, a CIDR or a netmask", spec)
+		}
+	}
+	return aliasRange, nil
+}
+
+// aliasIPRanges returns the alias IP ranges of the instance, which are
+// validated with the flags.
+func aliasIPRanges(specs []string) []*raw.AliasIpRange {
+	var ranges []*raw.AliasIpRange
+	for _, spec := range specs {
+		if aliasRange, err := parseAliasIPRange(spec); err == nil {
+			ranges = append(ranges, aliasRange)
+		}
+	}
+	return ranges
 }
 
 // deleteInstance deletes the instance, leaving the persistent disk.
@@ -439,7 +744,12 @@ func (c *ComputeUtil) deleteInstance() error {
 
 // stopInstance stops the instance.
 func (c *ComputeUtil) stopInstance() error {
-	op, err := c.service.Instances.Stop(c.project, c.zone, c.instanceName).Do()
+	call := c.service.Instances.Stop(c.project, c.zone, c.instanceName)
+	if c.localSSDCount > 0 {
+		// the API refuses to stop instances with local SSDs otherwise
+		call = call.DiscardLocalSsd(true)
+	}
+	op, err := call.Do()
 	if err != nil {
 		return err
 	}
@@ -486,10 +796,10 @@ func (c *ComputeUtil) waitForRegionalOp(name string) error {
 	})
 }
 
-// waitForGlobalOp waits for the global operation to finish.
-func (c *ComputeUtil) waitForGlobalOp(name string) error {
+// waitForGlobalOp waits for the global operation of the project to finish.
+func (c *ComputeUtil) waitForGlobalOp(project, name string) error {
 	return c.waitForOp(func() (*raw.Operation, error) {
-		return c.service.GlobalOperations.Get(c.project, name).Do()
+		return c.service.GlobalOperations.Get(project, name).Do()
 	})
 }
 
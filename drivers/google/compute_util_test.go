@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	raw "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 func TestDefaultTag(t *testing.T) {
@@ -36,6 +37,7 @@ func TestPortsUsed(t *testing.T) {
 		{"use docker and swarm port", &ComputeUtil{SwarmMaster: true, SwarmHost: "tcp://host:3376"}, []string{"2376/tcp", "3376/tcp"}, nil},
 		{"use docker and non default swarm port", &ComputeUtil{SwarmMaster: true, SwarmHost: "tcp://host:4242"}, []string{"2376/tcp", "4242/tcp"}, nil},
 		{"include additional ports", &ComputeUtil{openPorts: []string{"80", "2377/udp"}}, []string{"2376/tcp", "80/tcp", "2377/udp"}, nil},
+		{"use non default docker port", &ComputeUtil{dockerPort: "12376"}, []string{"12376/tcp"}, nil},
 	}
 
 	for _, test := range tests {
@@ -70,3 +72,117 @@ func TestMissingOpenedPorts(t *testing.T) {
 		assert.Equal(t, test.expectedMissing, missingPorts, test.description)
 	}
 }
+
+func TestScheduling(t *testing.T) {
+	var tests = []struct {
+		description        string
+		computeUtil        *ComputeUtil
+		expectedScheduling *raw.Scheduling
+	}{
+		{"standard", &ComputeUtil{}, &raw.Scheduling{}},
+		{"preemptible", &ComputeUtil{preemptible: true}, &raw.Scheduling{Preemptible: true, AutomaticRestart: googleapi.Bool(false), OnHostMaintenance: "TERMINATE"}},
+		{"spot", &ComputeUtil{spot: true, spotTermination: "STOP"}, &raw.Scheduling{ProvisioningModel: "SPOT", InstanceTerminationAction: "STOP", AutomaticRestart: googleapi.Bool(false), OnHostMaintenance: "TERMINATE"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedScheduling, test.computeUtil.scheduling(), test.description)
+	}
+}
+
+func TestKeepsDiskOnPreemption(t *testing.T) {
+	assert.False(t, (&ComputeUtil{spot: true, spotTermination: "STOP", spotAutoRestart: true}).keepsDiskOnPreemption())
+	assert.False(t, (&ComputeUtil{spot: true, spotTermination: "DELETE"}).keepsDiskOnPreemption())
+	assert.True(t, (&ComputeUtil{spot: true, spotTermination: "DELETE", spotAutoRestart: true}).keepsDiskOnPreemption())
+}
+
+func TestShieldedInstanceConfig(t *testing.T) {
+	assert.Nil(t, shieldedInstanceConfig(&Driver{}))
+	assert.Equal(t, &raw.ShieldedInstanceConfig{EnableSecureBoot: true}, shieldedInstanceConfig(&Driver{ShieldedSecureBoot: true}))
+	assert.Equal(t, &raw.ShieldedInstanceConfig{EnableVtpm: true, EnableIntegrityMonitoring: true}, shieldedInstanceConfig(&Driver{ShieldedVTPM: true, ShieldedIntegrityMonitoring: true}))
+}
+
+func TestImpersonationChain(t *testing.T) {
+	target, delegates := impersonationChain("machine@project.iam.gserviceaccount.com")
+	assert.Equal(t, "machine@project.iam.gserviceaccount.com", target)
+	assert.Empty(t, delegates)
+
+	target, delegates = impersonationChain("first@project.iam.gserviceaccount.com, second@project.iam.gserviceaccount.com,machine@project.iam.gserviceaccount.com")
+	assert.Equal(t, "machine@project.iam.gserviceaccount.com", target)
+	assert.Equal(t, []string{"first@project.iam.gserviceaccount.com", "second@project.iam.gserviceaccount.com"}, delegates)
+}
+
+func TestLocalSSDs(t *testing.T) {
+	c := &ComputeUtil{localSSDCount: 2, localSSDInterface: "NVME", zoneURL: "https://zone"}
+
+	disks := c.localSSDs()
+
+	assert.Len(t, disks, 2)
+	assert.Equal(t, "SCRATCH", disks[0].Type)
+	assert.Equal(t, "NVME", disks[0].Interface)
+	assert.Equal(t, "https://zone/diskTypes/local-ssd", disks[0].InitializeParams.DiskType)
+	assert.Empty(t, (&ComputeUtil{}).localSSDs())
+}
+
+func TestLocalSSDMountScript(t *testing.T) {
+	assert.Empty(t, (&ComputeUtil{localSSDCount: 1}).localSSDMountScript())
+
+	script := (&ComputeUtil{localSSDCount: 2, localSSDInterface: "NVME", localSSDMount: "/mnt/disks/ssd"}).localSSDMountScript()
+	assert.Contains(t, script, `mount_point="/mnt/disks/ssd"`)
+	assert.Contains(t, script, "disks=(/dev/disk/by-id/google-local-nvme-ssd-*)")
+
+	script = (&ComputeUtil{localSSDCount: 1, localSSDInterface: "SCSI", localSSDMount: "/mnt/disks/ssd"}).localSSDMountScript()
+	assert.Contains(t, script, "disks=(/dev/disk/by-id/google-local-ssd-*)")
+}
+
+func TestTagsAreTrimmedAndDeduplicated(t *testing.T) {
+	tags := parseTags(&Driver{Tags: "tag1, tag2,,tag1,docker-machine"})
+
+	assert.Equal(t, []string{"docker-machine", "tag1", "tag2"}, tags)
+}
+
+func TestValidateTags(t *testing.T) {
+	assert.NoError(t, validateTags(""))
+	assert.NoError(t, validateTags("web,k8s-node-1"))
+	assert.Error(t, validateTags("Web"))
+	assert.Error(t, validateTags("1web"))
+	assert.Error(t, validateTags("web-"))
+}
+
+func TestMissingTags(t *testing.T) {
+	assert.Equal(t, []string{"tag2"}, missingTags([]string{"docker-machine", "tag1"}, []string{"docker-machine", "tag1", "tag2"}))
+	assert.Empty(t, missingTags([]string{"docker-machine"}, []string{"docker-machine"}))
+}
+
+func TestParseAliasIPRange(t *testing.T) {
+	var tests = []struct {
+		spec          string
+		expectedRange *raw.AliasIpRange
+		expectedError bool
+	}{
+		{"/24", &raw.AliasIpRange{IpCidrRange: "/24"}, false},
+		{"pods:/24", &raw.AliasIpRange{IpCidrRange: "/24", SubnetworkRangeName: "pods"}, false},
+		{"pods:10.4.0.0/24", &raw.AliasIpRange{IpCidrRange: "10.4.0.0/24", SubnetworkRangeName: "pods"}, false},
+		{"10.128.0.10", &raw.AliasIpRange{IpCidrRange: "10.128.0.10"}, false},
+		{"/33", nil, true},
+		{"pods:10.4.0.0/40", nil, true},
+		{"pods", nil, true},
+	}
+
+	for _, test := range tests {
+		aliasRange, err := parseAliasIPRange(test.spec)
+
+		assert.Equal(t, test.expectedRange, aliasRange, test.spec)
+		assert.Equal(t, test.expectedError, err != nil, test.spec)
+	}
+}
+
+func TestNetworkURLs(t *testing.T) {
+	c := &ComputeUtil{zone: "us-central1-a", networkProject: "host", subnetwork: "shared"}
+
+	assert.Equal(t, apiURL+"host/global/networks/vpc", c.networkURL("vpc"))
+	assert.Equal(t, "projects/other/global/networks/vpc", c.networkURL("projects/other/global/networks/vpc"))
+	assert.Equal(t, "projects/host/regions/us-central1/subnetworks/shared", c.subnetworkURL())
+
+	c.subnetwork = "https://www.googleapis.com/compute/v1/projects/host/regions/us-east1/subnetworks/shared"
+	assert.Equal(t, c.subnetwork, c.subnetworkURL())
+}
@@ -1,12 +1,14 @@
 package google
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/rancher/machine/libmachine/drivers"
@@ -28,7 +30,12 @@ type Driver struct {
 	Address           string
 	Network           string
 	Subnetwork        string
+	NetworkProject    string
+	AliasIPRanges     []string
 	Preemptible       bool
+	Spot              bool
+	SpotTermination   string
+	SpotAutoRestart   bool
 	UseInternalIP     bool
 	UseInternalIPOnly bool
 	Scopes            string
@@ -38,6 +45,17 @@ type Driver struct {
 	UseExisting       bool
 	OpenPorts         []string
 	Userdata          string
+
+	ShieldedSecureBoot          bool
+	ShieldedVTPM                bool
+	ShieldedIntegrityMonitoring bool
+	ConfidentialCompute         bool
+
+	ImpersonateServiceAccount string
+
+	LocalSSDCount     int
+	LocalSSDInterface string
+	LocalSSDMount     string
 }
 
 const (
@@ -50,6 +68,9 @@ const (
 	defaultDiskSize    = 10
 	defaultNetwork     = "default"
 	defaultSubnetwork  = ""
+
+	defaultSpotTermination = "STOP"
+	defaultLocalSSDIface   = "NVME"
 )
 
 // GetCreateFlags registers the flags this driver adds to
@@ -68,6 +89,21 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultMachineType,
 			EnvVar: "GOOGLE_MACHINE_TYPE",
 		},
+		mcnflag.IntFlag{
+			Name:   "google-custom-cpus",
+			Usage:  "Number of vCPUs of a custom machine type, overrides --google-machine-type",
+			EnvVar: "GOOGLE_CUSTOM_CPUS",
+		},
+		mcnflag.IntFlag{
+			Name:   "google-custom-memory",
+			Usage:  "Memory in MB of a custom machine type, a multiple of 256",
+			EnvVar: "GOOGLE_CUSTOM_MEMORY",
+		},
+		mcnflag.StringFlag{
+			Name:   "google-custom-machine-family",
+			Usage:  "Machine family of a custom machine type (e.g. n2, n2d, e2), N1 if empty",
+			EnvVar: "GOOGLE_CUSTOM_MACHINE_FAMILY",
+		},
 		mcnflag.StringFlag{
 			Name:   "google-machine-image",
 			Usage:  "GCE Machine Image Absolute URL",
@@ -82,9 +118,14 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.StringFlag{
 			Name:   "google-auth-encoded-json",
-			Usage:  "Base64 encoded GCE auth json",
+			Usage:  "Base64 encoded GCE auth json, either a service account key or an external account configuration (application default credentials are used otherwise)",
 			EnvVar: "GOOGLE_APPLICATION_CREDENTIALS_ENCODED_JSON",
 		},
+		mcnflag.StringFlag{
+			Name:   "google-impersonate-service-account",
+			Usage:  "Service account to impersonate, a comma-separated list is used as a delegation chain ending with the target service account",
+			EnvVar: "GOOGLE_IMPERSONATE_SERVICE_ACCOUNT",
+		},
 		mcnflag.StringFlag{
 			Name:   "google-project",
 			Usage:  "GCE Project",
@@ -108,6 +149,22 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultDiskType,
 			EnvVar: "GOOGLE_DISK_TYPE",
 		},
+		mcnflag.IntFlag{
+			Name:   "google-local-ssd-count",
+			Usage:  "Number of local SSDs (375GB each) to attach",
+			EnvVar: "GOOGLE_LOCAL_SSD_COUNT",
+		},
+		mcnflag.StringFlag{
+			Name:   "google-local-ssd-interface",
+			Usage:  "Interface of the local SSDs (NVME or SCSI)",
+			Value:  defaultLocalSSDIface,
+			EnvVar: "GOOGLE_LOCAL_SSD_INTERFACE",
+		},
+		mcnflag.StringFlag{
+			Name:   "google-local-ssd-mount",
+			Usage:  "Format and mount the local SSDs at this path on boot, multiple SSDs are combined into a RAID 0 array",
+			EnvVar: "GOOGLE_LOCAL_SSD_MOUNT",
+		},
 		mcnflag.StringFlag{
 			Name:   "google-network",
 			Usage:  "Specify network in which to provision vm",
@@ -116,10 +173,19 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.StringFlag{
 			Name:   "google-subnetwork",
-			Usage:  "Specify subnetwork in which to provision vm",
+			Usage:  "Specify subnetwork in which to provision vm, by name or as projects/PROJECT/regions/REGION/subnetworks/NAME or self-link",
 			Value:  defaultSubnetwork,
 			EnvVar: "GOOGLE_SUBNETWORK",
 		},
+		mcnflag.StringFlag{
+			Name:   "google-network-project",
+			Usage:  "Host project of a Shared VPC network, in which the network and subnetwork names are looked up and the firewall rule is created",
+			EnvVar: "GOOGLE_NETWORK_PROJECT",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "google-alias-ip-range",
+			Usage: "Alias IP range of the instance as CIDR or netmask, optionally prefixed by a secondary range of the subnetwork, e.g. pods:/24",
+		},
 		mcnflag.StringFlag{
 			Name:   "google-address",
 			Usage:  "GCE Instance External IP",
@@ -127,12 +193,48 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.BoolFlag{
 			Name:   "google-preemptible",
-			Usage:  "GCE Instance Preemptibility",
+			Usage:  "GCE Instance Preemptibility (legacy, prefer --google-spot)",
 			EnvVar: "GOOGLE_PREEMPTIBLE",
 		},
+		mcnflag.BoolFlag{
+			Name:   "google-spot",
+			Usage:  "Create a GCE Spot VM",
+			EnvVar: "GOOGLE_SPOT",
+		},
+		mcnflag.StringFlag{
+			Name:   "google-spot-termination-action",
+			Usage:  "What GCE does with a Spot VM when it is preempted (STOP or DELETE)",
+			Value:  defaultSpotTermination,
+			EnvVar: "GOOGLE_SPOT_TERMINATION_ACTION",
+		},
+		mcnflag.BoolFlag{
+			Name:   "google-spot-auto-restart",
+			Usage:  "Keep the disk of a Spot VM deleted on preemption so that starting the machine recreates the instance",
+			EnvVar: "GOOGLE_SPOT_AUTO_RESTART",
+		},
+		mcnflag.BoolFlag{
+			Name:   "google-shielded-secure-boot",
+			Usage:  "Enable Secure Boot on the Shielded VM",
+			EnvVar: "GOOGLE_SHIELDED_SECURE_BOOT",
+		},
+		mcnflag.BoolFlag{
+			Name:   "google-shielded-vtpm",
+			Usage:  "Enable the virtual Trusted Platform Module on the Shielded VM",
+			EnvVar: "GOOGLE_SHIELDED_VTPM",
+		},
+		mcnflag.BoolFlag{
+			Name:   "google-shielded-integrity-monitoring",
+			Usage:  "Enable integrity monitoring on the Shielded VM (requires a vTPM)",
+			EnvVar: "GOOGLE_SHIELDED_INTEGRITY_MONITORING",
+		},
+		mcnflag.BoolFlag{
+			Name:   "google-confidential-compute",
+			Usage:  "Create a Confidential VM, its memory is encrypted (requires a supported machine type, e.g. n2d)",
+			EnvVar: "GOOGLE_CONFIDENTIAL_COMPUTE",
+		},
 		mcnflag.StringFlag{
 			Name:   "google-tags",
-			Usage:  "GCE Instance Tags (comma-separated)",
+			Usage:  "GCE Instance network tags (comma-separated), also added to existing instances",
 			EnvVar: "GOOGLE_TAGS",
 			Value:  "",
 		},
@@ -228,25 +330,65 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		return errors.New("no Google Cloud Project name specified (--google-project)")
 	}
 	d.Auth = flags.String("google-auth-encoded-json")
+	d.ImpersonateServiceAccount = flags.String("google-impersonate-service-account")
 
 	d.Zone = flags.String("google-zone")
 	d.UseExisting = flags.Bool("google-use-existing")
 	if !d.UseExisting {
 		d.MachineType = flags.String("google-machine-type")
+		if cpus := flags.Int("google-custom-cpus"); cpus > 0 {
+			machineType, err := customMachineType(flags.String("google-custom-machine-family"), cpus, flags.Int("google-custom-memory"))
+			if err != nil {
+				return err
+			}
+			d.MachineType = machineType
+		}
 		d.MachineImage = flags.String("google-machine-image")
 		d.MachineImage = strings.TrimPrefix(d.MachineImage, "https://www.googleapis.com/compute/v1/projects/")
 		d.DiskSize = flags.Int("google-disk-size")
 		d.DiskType = flags.String("google-disk-type")
+		d.LocalSSDCount = flags.Int("google-local-ssd-count")
+		d.LocalSSDInterface = strings.ToUpper(flags.String("google-local-ssd-interface"))
+		d.LocalSSDMount = flags.String("google-local-ssd-mount")
+		if d.LocalSSDInterface != "NVME" && d.LocalSSDInterface != "SCSI" {
+			return fmt.Errorf("invalid --google-local-ssd-interface %q, must be NVME or SCSI", d.LocalSSDInterface)
+		}
+		if d.LocalSSDMount != "" && d.LocalSSDCount == 0 {
+			return errors.New("--google-local-ssd-mount requires --google-local-ssd-count")
+		}
 		d.Address = flags.String("google-address")
 		d.Network = flags.String("google-network")
 		d.Subnetwork = flags.String("google-subnetwork")
+		d.NetworkProject = flags.String("google-network-project")
+		d.AliasIPRanges = flags.StringSlice("google-alias-ip-range")
+		for _, spec := range d.AliasIPRanges {
+			if _, err := parseAliasIPRange(spec); err != nil {
+				return err
+			}
+		}
 		d.Preemptible = flags.Bool("google-preemptible")
+		d.Spot = flags.Bool("google-spot")
+		d.SpotTermination = strings.ToUpper(flags.String("google-spot-termination-action"))
+		d.SpotAutoRestart = flags.Bool("google-spot-auto-restart")
+		if d.Spot && d.Preemptible {
+			return errors.New("--google-spot and --google-preemptible cannot be used together")
+		}
+		if d.SpotTermination != "STOP" && d.SpotTermination != "DELETE" {
+			return fmt.Errorf("invalid --google-spot-termination-action %q, must be STOP or DELETE", d.SpotTermination)
+		}
+		d.ShieldedSecureBoot = flags.Bool("google-shielded-secure-boot")
+		d.ShieldedVTPM = flags.Bool("google-shielded-vtpm")
+		d.ShieldedIntegrityMonitoring = flags.Bool("google-shielded-integrity-monitoring")
+		d.ConfidentialCompute = flags.Bool("google-confidential-compute")
 		d.UseInternalIP = flags.Bool("google-use-internal-ip") || flags.Bool("google-use-internal-ip-only")
 		d.UseInternalIPOnly = flags.Bool("google-use-internal-ip-only")
 		d.Scopes = flags.String("google-scopes")
-		d.Tags = flags.String("google-tags")
 		d.OpenPorts = flags.StringSlice("google-open-port")
 	}
+	d.Tags = flags.String("google-tags")
+	if err := validateTags(d.Tags); err != nil {
+		return err
+	}
 	d.SSHUser = flags.String("google-username")
 	d.SSHPort = 22
 	d.Userdata = flags.String("google-userdata")
@@ -296,6 +438,48 @@ func (d *Driver) PreCreateCheck() error {
 	return nil
 }
 
+// Plan returns the instance to create, in the project PreCreateCheck checked.
+func (d *Driver) Plan() ([]drivers.PlanItem, error) {
+	items := []drivers.PlanItem{
+		{Name: "Project", Value: d.Project},
+		{Name: "Zone", Value: d.Zone},
+		{Name: "Machine type", Value: d.MachineType},
+		{Name: "Image", Value: d.MachineImage},
+		{Name: "Disk", Value: fmt.Sprintf("%s, %d GB", d.DiskType, d.DiskSize)},
+		{Name: "Network", Value: d.Network},
+		{Name: "Subnetwork", Value: d.Subnetwork},
+		{Name: "Address", Value: d.Address},
+		{Name: "Tags", Value: d.Tags},
+	}
+	if d.LocalSSDCount > 0 {
+		items = append(items, drivers.PlanItem{Name: "Local SSDs", Value: fmt.Sprintf("%d, %s", d.LocalSSDCount, d.LocalSSDInterface)})
+	}
+	switch {
+	case d.Spot:
+		items = append(items, drivers.PlanItem{Name: "Spot", Value: d.SpotTermination})
+	case d.Preemptible:
+		items = append(items, drivers.PlanItem{Name: "Preemptible", Value: "true"})
+	}
+	if d.UseExisting {
+		items = append(items, drivers.PlanItem{Name: "Existing instance", Value: d.MachineName})
+	}
+	return items, nil
+}
+
+// CreateContext creates a GCE VM instance acting as a docker host, aborting
+// the GCE calls in progress when ctx is done. The instance and disk created
+// are then removed.
+func (d *Driver) CreateContext(ctx context.Context) error {
+	err := d.WithContext(ctx, d.Create)
+	if err != nil && ctx.Err() != nil && !d.UseExisting {
+		log.Warnf("Creation of the instance interrupted, removing what was created: %s", err)
+		if err := d.Remove(); err != nil {
+			log.Warnf("Error removing the instance: %s", err)
+		}
+	}
+	return err
+}
+
 // Create creates a GCE VM instance acting as a docker host.
 func (d *Driver) Create() error {
 	log.Infof("Generating SSH Key")
@@ -328,7 +512,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 // GetIP returns the IP address of the GCE instance.
@@ -349,6 +533,16 @@ func (d *Driver) GetIP() (string, error) {
 	return ip, nil
 }
 
+// GetStateContext returns the current state of the host, aborting the GCE
+// calls in progress when ctx is done.
+func (d *Driver) GetStateContext(ctx context.Context) (s state.State, err error) {
+	err = d.WithContext(ctx, func() error {
+		s, err = d.GetState()
+		return err
+	})
+	return s, err
+}
+
 // GetState returns a docker.hosts.state.State value representing the current state of the host.
 func (d *Driver) GetState() (state.State, error) {
 	c, err := newComputeUtil(d)
@@ -360,7 +554,9 @@ func (d *Driver) GetState() (state.State, error) {
 	// There will be no error if disk is not nil.
 	instance, err := c.instance()
 	if instance == nil {
-		if err != nil && strings.Contains(err.Error(), "not found") {
+		// a Spot VM deleted on preemption leaves its disk behind when it
+		// should be recreated on start
+		if err != nil && strings.Contains(err.Error(), "not found") && !c.keepsDiskOnPreemption() {
 			return state.NotFound, nil
 		}
 		disk, _ := c.disk()
@@ -375,12 +571,31 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.Starting, nil
 	case "RUNNING":
 		return state.Running, nil
-	case "STOPPING", "STOPPED", "TERMINATED":
+	case "STOPPING", "STOPPED", "SUSPENDING", "SUSPENDED":
+		return state.Stopped, nil
+	case "TERMINATED":
+		// preempted Spot and preemptible VMs end up TERMINATED and can be
+		// started again
+		if instance.Scheduling != nil && (instance.Scheduling.ProvisioningModel == "SPOT" || instance.Scheduling.Preemptible) {
+			log.Debugf("Instance %q has been terminated, it might have been preempted", d.MachineName)
+		}
 		return state.Stopped, nil
 	}
 	return state.None, nil
 }
 
+// StartContext starts the GCE instance, aborting the GCE calls in progress
+// when ctx is done.
+func (d *Driver) StartContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Start)
+}
+
+// StopContext stops the GCE instance, aborting the GCE calls in progress when
+// ctx is done.
+func (d *Driver) StopContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Stop)
+}
+
 // Start starts an existing GCE instance or create an instance with an existing disk.
 func (d *Driver) Start() error {
 	c, err := newComputeUtil(d)
@@ -396,6 +611,9 @@ func (d *Driver) Start() error {
 	}
 
 	if instance == nil {
+		if d.Spot && !c.keepsDiskOnPreemption() {
+			return fmt.Errorf("instance %q does not exist, it might have been deleted on preemption (use --google-spot-auto-restart to recreate it on start)", d.MachineName)
+		}
 		if err = c.createInstance(d); err != nil {
 			return err
 		}
@@ -416,6 +634,9 @@ func (d *Driver) Stop() error {
 		return err
 	}
 
+	if d.LocalSSDCount > 0 {
+		log.Warn("The content of the local SSDs is discarded when the instance stops.")
+	}
 	if err := c.stopInstance(); err != nil {
 		return err
 	}
@@ -438,6 +659,12 @@ func (d *Driver) Kill() error {
 	return d.Stop()
 }
 
+// RemoveContext deletes the GCE instance and the disk, aborting the GCE calls
+// in progress when ctx is done.
+func (d *Driver) RemoveContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Remove)
+}
+
 // Remove deletes the GCE instance and the disk.
 func (d *Driver) Remove() error {
 	c, err := newComputeUtil(d)
@@ -463,3 +690,17 @@ func (d *Driver) Remove() error {
 
 	return nil
 }
+
+// customMachineType returns the name of a custom machine type, e.g.
+// custom-8-32768 or n2-custom-8-32768.
+func customMachineType(family string, cpus, memory int) (string, error) {
+	if memory <= 0 || memory%256 != 0 {
+		return "", fmt.Errorf("invalid --google-custom-memory %d, must be a multiple of 256 MB", memory)
+	}
+
+	machineType := fmt.Sprintf("custom-%d-%d", cpus, memory)
+	if family != "" && family != "n1" {
+		machineType = family + "-" + machineType
+	}
+	return machineType, nil
+}
@@ -22,3 +22,113 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestSetConfigFromFlagsSpot(t *testing.T) {
+	driver := NewDriver("", "")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"google-project":                 "PROJECT",
+			"google-spot":                    true,
+			"google-spot-termination-action": "delete",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.True(t, driver.Spot)
+	assert.Equal(t, "DELETE", driver.SpotTermination)
+}
+
+func TestSetConfigFromFlagsSpotAndPreemptible(t *testing.T) {
+	driver := NewDriver("", "")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"google-project":     "PROJECT",
+			"google-spot":        true,
+			"google-preemptible": true,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.Error(t, err)
+}
+
+func TestCustomMachineType(t *testing.T) {
+	var tests = []struct {
+		family      string
+		cpus        int
+		memory      int
+		expected    string
+		expectedErr bool
+	}{
+		{"", 8, 32768, "custom-8-32768", false},
+		{"n1", 2, 4096, "custom-2-4096", false},
+		{"n2", 8, 32768, "n2-custom-8-32768", false},
+		{"", 8, 1000, "", true},
+		{"", 8, 0, "", true},
+	}
+
+	for _, test := range tests {
+		machineType, err := customMachineType(test.family, test.cpus, test.memory)
+
+		assert.Equal(t, test.expected, machineType)
+		assert.Equal(t, test.expectedErr, err != nil)
+	}
+}
+
+func TestSetConfigFromFlagsNetworking(t *testing.T) {
+	driver := NewDriver("", "")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"google-project":         "PROJECT",
+			"google-network-project": "HOST",
+			"google-subnetwork":      "shared",
+			"google-alias-ip-range":  []string{"pods:/24"},
+			"google-tags":            "web,db",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "HOST", driver.NetworkProject)
+	assert.Equal(t, []string{"pods:/24"}, driver.AliasIPRanges)
+}
+
+func TestSetConfigFromFlagsInvalidNetworking(t *testing.T) {
+	for _, flags := range []map[string]interface{}{
+		{"google-project": "PROJECT", "google-alias-ip-range": []string{"pods:/64"}},
+		{"google-project": "PROJECT", "google-tags": "Web"},
+	} {
+		driver := NewDriver("", "")
+		checkFlags := &drivers.CheckDriverOptions{
+			FlagsValues: flags,
+			CreateFlags: driver.GetCreateFlags(),
+		}
+
+		assert.Error(t, driver.SetConfigFromFlags(checkFlags))
+	}
+}
+
+func TestPlan(t *testing.T) {
+	driver := NewDriver("machine", "")
+	driver.Project = "project"
+	driver.Spot = true
+	driver.SpotTermination = "DELETE"
+
+	items, err := driver.Plan()
+
+	assert.NoError(t, err)
+	assert.Contains(t, items, drivers.PlanItem{Name: "Zone", Value: defaultZone})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Disk", Value: "pd-standard, 10 GB"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Spot", Value: "DELETE"})
+}
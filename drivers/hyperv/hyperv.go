@@ -1,11 +1,16 @@
 package hyperv
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/rancher/machine/libmachine/cloudinit"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
@@ -24,6 +29,16 @@ type Driver struct {
 	MacAddr              string
 	VLanID               int
 	DisableDynamicMemory bool
+	MinMemSize           int
+	MaxMemSize           int
+	Generation           int
+	SecureBoot           bool
+	SecureBootTemplate   string
+	NestedVirtualization bool
+	ParentDisk           string
+	SSHKey               string
+	CloudImage           string
+	CloudInitUserData    string
 }
 
 const (
@@ -32,6 +47,9 @@ const (
 	defaultCPU                  = 1
 	defaultVLanID               = 0
 	defaultDisableDynamicMemory = false
+	defaultGeneration           = 1
+	defaultSecureBootTemplate   = "MicrosoftUEFICertificateAuthority"
+	defaultSSHUser              = "docker"
 )
 
 // NewDriver creates a new Hyper-v driver with default settings.
@@ -41,6 +59,8 @@ func NewDriver(hostName, storePath string) *Driver {
 		MemSize:              defaultMemory,
 		CPU:                  defaultCPU,
 		DisableDynamicMemory: defaultDisableDynamicMemory,
+		Generation:           defaultGeneration,
+		SecureBootTemplate:   defaultSecureBootTemplate,
 		BaseDriver: &drivers.BaseDriver{
 			MachineName: hostName,
 			StorePath:   storePath,
@@ -96,6 +116,64 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Disable dynamic memory management setting",
 			EnvVar: "HYPERV_DISABLE_DYNAMIC_MEMORY",
 		},
+		mcnflag.IntFlag{
+			Name:   "hyperv-memory-min",
+			Usage:  "Minimum dynamic memory size in MB. Defaults to the Hyper-V default.",
+			EnvVar: "HYPERV_MEMORY_MIN",
+		},
+		mcnflag.IntFlag{
+			Name:   "hyperv-memory-max",
+			Usage:  "Maximum dynamic memory size in MB. Defaults to the Hyper-V default.",
+			EnvVar: "HYPERV_MEMORY_MAX",
+		},
+		mcnflag.IntFlag{
+			Name:   "hyperv-generation",
+			Usage:  "VM generation, 1 (BIOS) or 2 (UEFI).",
+			Value:  defaultGeneration,
+			EnvVar: "HYPERV_GENERATION",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hyperv-secure-boot",
+			Usage:  "Enable secure boot on generation 2 VMs, the boot image must be signed.",
+			EnvVar: "HYPERV_SECURE_BOOT",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-secure-boot-template",
+			Usage:  "Secure boot template.",
+			Value:  defaultSecureBootTemplate,
+			EnvVar: "HYPERV_SECURE_BOOT_TEMPLATE",
+		},
+		mcnflag.BoolFlag{
+			Name:   "hyperv-nested-virtualization",
+			Usage:  "Expose the virtualization extensions to the VM, which disables dynamic memory.",
+			EnvVar: "HYPERV_NESTED_VIRTUALIZATION",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-parent-disk",
+			Usage:  "Boot from a differencing disk of this VHD or VHDX, which must have Docker and accept the SSH key, instead of the boot2docker ISO.",
+			EnvVar: "HYPERV_PARENT_DISK",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-cloud-image",
+			Usage:  "Boot from a copy of this VHD or VHDX cloud image, given by URL or path, configured by cloud-init instead of the boot2docker ISO.",
+			EnvVar: "HYPERV_CLOUD_IMAGE",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-cloud-init-user-data",
+			Usage:  "Path of a cloud-config or a script run on the first boot of the cloud image.",
+			EnvVar: "HYPERV_CLOUD_INIT_USER_DATA",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-ssh-user",
+			Usage:  "SSH user of the parent disk or the cloud image.",
+			Value:  defaultSSHUser,
+			EnvVar: "HYPERV_SSH_USER",
+		},
+		mcnflag.StringFlag{
+			Name:   "hyperv-ssh-key",
+			Usage:  "SSH private key path accepted by the parent disk image.",
+			EnvVar: "HYPERV_SSH_KEY",
+		},
 	}
 }
 
@@ -107,10 +185,65 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.CPU = flags.Int("hyperv-cpu-count")
 	d.MacAddr = flags.String("hyperv-static-macaddress")
 	d.VLanID = flags.Int("hyperv-vlan-id")
-	d.SSHUser = "docker"
+	d.SSHUser = flags.String("hyperv-ssh-user")
 	d.DisableDynamicMemory = flags.Bool("hyperv-disable-dynamic-memory")
+	d.MinMemSize = flags.Int("hyperv-memory-min")
+	d.MaxMemSize = flags.Int("hyperv-memory-max")
+	d.Generation = flags.Int("hyperv-generation")
+	d.SecureBoot = flags.Bool("hyperv-secure-boot")
+	d.SecureBootTemplate = flags.String("hyperv-secure-boot-template")
+	d.NestedVirtualization = flags.Bool("hyperv-nested-virtualization")
+	d.ParentDisk = flags.String("hyperv-parent-disk")
+	d.SSHKey = flags.String("hyperv-ssh-key")
+	d.CloudImage = flags.String("hyperv-cloud-image")
+	d.CloudInitUserData = flags.String("hyperv-cloud-init-user-data")
 	d.SetSwarmConfigFromFlags(flags)
 
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.Generation != 1 && d.Generation != 2 {
+		return fmt.Errorf("--hyperv-generation must be 1 or 2, not %d", d.Generation)
+	}
+	if d.SecureBoot && d.Generation != 2 {
+		return errors.New("--hyperv-secure-boot requires --hyperv-generation 2")
+	}
+
+	dynamicMemory := d.MinMemSize > 0 || d.MaxMemSize > 0
+	if dynamicMemory && (d.DisableDynamicMemory || d.NestedVirtualization) {
+		return errors.New("--hyperv-memory-min and --hyperv-memory-max need dynamic memory, which --hyperv-disable-dynamic-memory and --hyperv-nested-virtualization disable")
+	}
+	if d.MinMemSize > 0 && d.MinMemSize > d.MemSize {
+		return fmt.Errorf("--hyperv-memory-min %d is above --hyperv-memory %d", d.MinMemSize, d.MemSize)
+	}
+	if d.MaxMemSize > 0 && d.MaxMemSize < d.MemSize {
+		return fmt.Errorf("--hyperv-memory-max %d is below --hyperv-memory %d", d.MaxMemSize, d.MemSize)
+	}
+	if d.NestedVirtualization {
+		d.DisableDynamicMemory = true
+	}
+
+	if d.ParentDisk != "" {
+		if d.Boot2DockerURL != "" {
+			return errors.New("--hyperv-parent-disk and --hyperv-boot2docker-url are mutually exclusive")
+		}
+		if d.SSHKey == "" {
+			return errors.New("--hyperv-parent-disk requires --hyperv-ssh-key")
+		}
+		if d.Generation == 2 && !strings.EqualFold(filepath.Ext(d.ParentDisk), ".vhdx") {
+			return errors.New("generation 2 VMs require a VHDX --hyperv-parent-disk")
+		}
+	}
+
+	if d.CloudImage != "" {
+		if d.Boot2DockerURL != "" || d.ParentDisk != "" {
+			return errors.New("--hyperv-cloud-image cannot be used with --hyperv-boot2docker-url or --hyperv-parent-disk")
+		}
+	} else if d.CloudInitUserData != "" {
+		return errors.New("--hyperv-cloud-init-user-data requires --hyperv-cloud-image")
+	}
+
 	return nil
 }
 
@@ -133,7 +266,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", nil
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
@@ -183,22 +316,53 @@ func (d *Driver) PreCreateCheck() error {
 		return err
 	}
 
-	// Downloading boot2docker to cache should be done here to make sure
+	if d.ParentDisk != "" {
+		if _, err := os.Stat(d.ParentDisk); err != nil {
+			return fmt.Errorf("parent disk %q not found: %s", d.ParentDisk, err)
+		}
+		if _, err := os.Stat(d.SSHKey); err != nil {
+			return fmt.Errorf("SSH key %q not found: %s", d.SSHKey, err)
+		}
+		return nil
+	}
+
+	// Downloading the image to cache should be done here to make sure
 	// that a download failure will not leave a machine half created.
+	if d.CloudImage != "" {
+		_, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVHD)
+		return err
+	}
+
 	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
 	err = b2dutils.UpdateISOCache(d.Boot2DockerURL)
 	return err
 }
 
 func (d *Driver) Create() error {
-	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
-	if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
-		return err
-	}
+	if d.CloudImage != "" {
+		log.Infof("Creating SSH key...")
+		if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+			return err
+		}
+	} else if d.ParentDisk == "" {
+		b2dutils := mcnutils.NewB2dUtils(d.StorePath)
+		if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
+			return err
+		}
 
-	log.Infof("Creating SSH key...")
-	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
-		return err
+		log.Infof("Creating SSH key...")
+		if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("Importing SSH key...")
+		d.SSHKeyPath = d.ResolveStorePath(filepath.Base(d.SSHKey))
+		if err := mcnutils.CopyFile(d.SSHKey, d.SSHKeyPath); err != nil {
+			return fmt.Errorf("unable to copy ssh key: %s", err)
+		}
+		if err := os.Chmod(d.SSHKeyPath, 0600); err != nil {
+			return fmt.Errorf("unable to set permissions on the ssh key: %s", err)
+		}
 	}
 
 	log.Infof("Creating VM...")
@@ -209,7 +373,15 @@ func (d *Driver) Create() error {
 
 	log.Infof("Using switch %q", virtualSwitch)
 
-	diskImage, err := d.generateDiskImage()
+	var diskImage string
+	switch {
+	case d.CloudImage != "":
+		diskImage, err = d.generateCloudDisk()
+	case d.ParentDisk != "":
+		diskImage, err = d.generateDifferencingDisk()
+	default:
+		diskImage, err = d.generateDiskImage()
+	}
 	if err != nil {
 		return err
 	}
@@ -218,15 +390,38 @@ func (d *Driver) Create() error {
 		d.MachineName,
 		"-Path", fmt.Sprintf("'%s'", d.ResolveStorePath(".")),
 		"-SwitchName", quote(virtualSwitch),
-		"-MemoryStartupBytes", toMb(d.MemSize)); err != nil {
+		"-MemoryStartupBytes", toMb(d.MemSize),
+		"-Generation", fmt.Sprintf("%d", d.Generation)); err != nil {
 		return err
 	}
+
+	if d.Generation == 2 {
+		secureBoot := []string{"-EnableSecureBoot", "Off"}
+		if d.SecureBoot {
+			secureBoot = []string{"-EnableSecureBoot", "On", "-SecureBootTemplate", quote(d.SecureBootTemplate)}
+		}
+		if err := cmd(append([]string{"Hyper-V\\Set-VMFirmware", "-VMName", d.MachineName}, secureBoot...)...); err != nil {
+			return err
+		}
+	}
+
 	if d.DisableDynamicMemory {
 		if err := cmd("Hyper-V\\Set-VMMemory",
 			"-VMName", d.MachineName,
 			"-DynamicMemoryEnabled", "$false"); err != nil {
 			return err
 		}
+	} else if d.MinMemSize > 0 || d.MaxMemSize > 0 {
+		args := []string{"Hyper-V\\Set-VMMemory", "-VMName", d.MachineName, "-DynamicMemoryEnabled", "$true"}
+		if d.MinMemSize > 0 {
+			args = append(args, "-MinimumBytes", toMb(d.MinMemSize))
+		}
+		if d.MaxMemSize > 0 {
+			args = append(args, "-MaximumBytes", toMb(d.MaxMemSize))
+		}
+		if err := cmd(args...); err != nil {
+			return err
+		}
 	}
 
 	if d.CPU > 1 {
@@ -237,6 +432,21 @@ func (d *Driver) Create() error {
 		}
 	}
 
+	if d.NestedVirtualization {
+		if err := cmd("Hyper-V\\Set-VMProcessor",
+			d.MachineName,
+			"-ExposeVirtualizationExtensions", "$true"); err != nil {
+			return err
+		}
+
+		// nested VMs need MAC spoofing to reach the network
+		if err := cmd("Hyper-V\\Set-VMNetworkAdapter",
+			"-VMName", d.MachineName,
+			"-MacAddressSpoofing", "On"); err != nil {
+			return err
+		}
+	}
+
 	if d.MacAddr != "" {
 		if err := cmd("Hyper-V\\Set-VMNetworkAdapter",
 			"-VMName", d.MachineName,
@@ -254,10 +464,14 @@ func (d *Driver) Create() error {
 		}
 	}
 
-	if err := cmd("Hyper-V\\Set-VMDvdDrive",
-		"-VMName", d.MachineName,
-		"-Path", quote(d.ResolveStorePath("boot2docker.iso"))); err != nil {
-		return err
+	if d.CloudImage != "" {
+		if err := d.attachISO(d.ResolveStorePath(cloudinit.SeedISOFilename)); err != nil {
+			return err
+		}
+	} else if d.ParentDisk == "" {
+		if err := d.attachISO(d.ResolveStorePath("boot2docker.iso")); err != nil {
+			return err
+		}
 	}
 
 	if err := cmd("Hyper-V\\Add-VMHardDiskDrive",
@@ -266,10 +480,39 @@ func (d *Driver) Create() error {
 		return err
 	}
 
+	// the seed ISO only holds the configuration, disks boot first
+	if (d.ParentDisk != "" || d.CloudImage != "") && d.Generation == 2 {
+		if err := cmd("Hyper-V\\Set-VMFirmware",
+			"-VMName", d.MachineName,
+			"-FirstBootDevice", "(Hyper-V\\Get-VMHardDiskDrive", "-VMName", d.MachineName, ")"); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Starting VM...")
 	return d.Start()
 }
 
+// attachISO boots the VM from the ISO. Generation 1 VMs have a DVD drive,
+// generation 2 VMs get one on their SCSI controller.
+func (d *Driver) attachISO(path string) error {
+	if d.Generation != 2 {
+		return cmd("Hyper-V\\Set-VMDvdDrive",
+			"-VMName", d.MachineName,
+			"-Path", quote(path))
+	}
+
+	if err := cmd("Hyper-V\\Add-VMDvdDrive",
+		"-VMName", d.MachineName,
+		"-Path", quote(path)); err != nil {
+		return err
+	}
+
+	return cmd("Hyper-V\\Set-VMFirmware",
+		"-VMName", d.MachineName,
+		"-FirstBootDevice", "(Hyper-V\\Get-VMDvdDrive", "-VMName", d.MachineName, ")")
+}
+
 func (d *Driver) chooseVirtualSwitch() (string, error) {
 	if d.VSwitch == "" {
 		// Default to the first external switche and in the process avoid DockerNAT
@@ -439,9 +682,73 @@ func (d *Driver) publicSSHKeyPath() string {
 	return d.GetSSHKeyPath() + ".pub"
 }
 
+// generateDifferencingDisk creates a disk recording the changes to the
+// parent disk, which is much faster than copying it. The parent must not
+// change while machines use it.
+func (d *Driver) generateDifferencingDisk() (string, error) {
+	diskImage := d.ResolveStorePath("disk" + strings.ToLower(filepath.Ext(d.ParentDisk)))
+
+	log.Infof("Creating differencing disk of %s", d.ParentDisk)
+	if err := cmd("Hyper-V\\New-VHD", "-Path", quote(diskImage), "-ParentPath", quote(d.ParentDisk), "-Differencing"); err != nil {
+		return "", err
+	}
+
+	return diskImage, nil
+}
+
+// generateCloudDisk copies the cloud image to the disk of the VM, grown to
+// the disk size, and writes the seed ISO which configures it on the first
+// boot.
+func (d *Driver) generateCloudDisk() (string, error) {
+	image, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVHD)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert-VHD picks the format from the extension, generation 2 VMs
+	// only support VHDX
+	diskImage := d.ResolveStorePath("disk.vhd")
+	if d.Generation == 2 {
+		diskImage = d.ResolveStorePath("disk.vhdx")
+	}
+
+	log.Infof("Copying %s", image)
+	if err := cmd("Hyper-V\\Convert-VHD", "-Path", quote(image), "-DestinationPath", quote(diskImage), "-VHDType", "Dynamic"); err != nil {
+		return "", err
+	}
+
+	// Resizing vhds requires administrator privileges
+	isWindowsAdmin, err := isWindowsAdministrator()
+	if err != nil {
+		return "", err
+	}
+	if isWindowsAdmin {
+		if err := cmd("Hyper-V\\Resize-VHD", "-Path", quote(diskImage), "-SizeBytes", toMb(d.DiskSize)); err != nil {
+			return "", err
+		}
+	} else {
+		log.Warnf("Keeping the size of the cloud image, resizing disks requires administrator privileges")
+	}
+
+	seed, err := cloudinit.NewSeed(d.MachineName, d.GetSSHUsername(), d.publicSSHKeyPath(), d.CloudInitUserData)
+	if err != nil {
+		return "", err
+	}
+	if err := seed.WriteISO(d.ResolveStorePath(cloudinit.SeedISOFilename)); err != nil {
+		return "", err
+	}
+
+	return diskImage, nil
+}
+
 // generateDiskImage creates a small fixed vhd, put the tar in, convert to dynamic, then resize
 func (d *Driver) generateDiskImage() (string, error) {
+	// generation 2 VMs only support VHDX, which Convert-VHD picks from the
+	// extension
 	diskImage := d.ResolveStorePath("disk.vhd")
+	if d.Generation == 2 {
+		diskImage = d.ResolveStorePath("disk.vhdx")
+	}
 	fixed := d.ResolveStorePath("fixed.vhd")
 
 	// Resizing vhds requires administrator privileges
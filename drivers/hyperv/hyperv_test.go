@@ -69,3 +69,72 @@ func TestSetConfigFromCustomFlags(t *testing.T) {
 	assert.Equal(t, "docker", driver.GetSSHUsername())
 	assert.Equal(t, true, driver.DisableDynamicMemory)
 }
+
+func TestSetConfigFromModernFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"hyperv-memory":                4096,
+			"hyperv-generation":            2,
+			"hyperv-secure-boot":           true,
+			"hyperv-nested-virtualization": true,
+			"hyperv-parent-disk":           `C:\images\ubuntu.vhdx`,
+			"hyperv-ssh-user":              "ubuntu",
+			"hyperv-ssh-key":               `C:\keys\id_rsa`,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+
+	assert.Equal(t, 2, driver.Generation)
+	assert.True(t, driver.SecureBoot)
+	assert.Equal(t, defaultSecureBootTemplate, driver.SecureBootTemplate)
+	assert.True(t, driver.NestedVirtualization)
+	assert.True(t, driver.DisableDynamicMemory)
+	assert.Equal(t, `C:\images\ubuntu.vhdx`, driver.ParentDisk)
+	assert.Equal(t, "ubuntu", driver.GetSSHUsername())
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		d := NewDriver("default", "path")
+		d.MemSize = 2048
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "defaults", modify: func(d *Driver) {}},
+		{name: "dynamic memory", modify: func(d *Driver) { d.MinMemSize, d.MaxMemSize = 512, 8192 }},
+		{name: "generation", modify: func(d *Driver) { d.Generation = 3 }, err: "--hyperv-generation must be 1 or 2, not 3"},
+		{name: "secure boot", modify: func(d *Driver) { d.SecureBoot = true }, err: "--hyperv-secure-boot requires --hyperv-generation 2"},
+		{name: "static memory", modify: func(d *Driver) { d.MaxMemSize, d.DisableDynamicMemory = 8192, true }, err: "--hyperv-memory-min and --hyperv-memory-max need dynamic memory, which --hyperv-disable-dynamic-memory and --hyperv-nested-virtualization disable"},
+		{name: "min memory", modify: func(d *Driver) { d.MinMemSize = 4096 }, err: "--hyperv-memory-min 4096 is above --hyperv-memory 2048"},
+		{name: "max memory", modify: func(d *Driver) { d.MaxMemSize = 1024 }, err: "--hyperv-memory-max 1024 is below --hyperv-memory 2048"},
+		{name: "parent disk", modify: func(d *Driver) { d.ParentDisk, d.SSHKey = `C:\images\ubuntu.vhd`, "id_rsa" }},
+		{name: "parent disk and boot2docker", modify: func(d *Driver) { d.ParentDisk, d.SSHKey, d.Boot2DockerURL = "ubuntu.vhd", "id_rsa", "B2D_URL" }, err: "--hyperv-parent-disk and --hyperv-boot2docker-url are mutually exclusive"},
+		{name: "parent disk without key", modify: func(d *Driver) { d.ParentDisk = "ubuntu.vhd" }, err: "--hyperv-parent-disk requires --hyperv-ssh-key"},
+		{name: "generation 2 vhd", modify: func(d *Driver) { d.Generation, d.ParentDisk, d.SSHKey = 2, "ubuntu.vhd", "id_rsa" }, err: "generation 2 VMs require a VHDX --hyperv-parent-disk"},
+		{name: "cloud image", modify: func(d *Driver) { d.CloudImage, d.CloudInitUserData = "https://images.local/ubuntu.vhdx", "user-data" }},
+		{name: "cloud image and parent disk", modify: func(d *Driver) { d.CloudImage, d.ParentDisk, d.SSHKey = "ubuntu.vhdx", "ubuntu.vhd", "id_rsa" }, err: "--hyperv-cloud-image cannot be used with --hyperv-boot2docker-url or --hyperv-parent-disk"},
+		{name: "user data without cloud image", modify: func(d *Driver) { d.CloudInitUserData = "user-data" }, err: "--hyperv-cloud-init-user-data requires --hyperv-cloud-image"},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package ibmcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiVersion = "2023-08-08"
+
+	apiKeyGrantType  = "urn:ibm:params:oauth:grant-type:apikey"
+	crTokenGrantType = "urn:ibm:params:oauth:grant-type:cr-token"
+)
+
+var (
+	iamEndpoint              = "https://iam.cloud.ibm.com/identity/token"
+	resourceManagerEndpoint  = "https://resource-controller.cloud.ibm.com/v2"
+	vpcEndpointFormat        = "https://%s.iaas.cloud.ibm.com/v1"
+	errNotFound              = errors.New("not found")
+	vpcIDPattern             = regexp.MustCompile(`^[0-9a-z]{4}-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	resourceGroupIDPattern   = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tokenExpiryMargin        = 60 * time.Second
+	defaultHTTPClientTimeout = 60 * time.Second
+)
+
+// Authenticator gets IAM tokens, either with an API key or as a trusted
+// profile using the compute resource token of the workload.
+type Authenticator struct {
+	APIKey           string
+	TrustedProfileID string
+	CRTokenFile      string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	http    *http.Client
+}
+
+func (a *Authenticator) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Add(tokenExpiryMargin).Before(a.expires) {
+		return a.token, nil
+	}
+
+	form := url.Values{}
+	if a.APIKey != "" {
+		form.Set("grant_type", apiKeyGrantType)
+		form.Set("apikey", a.APIKey)
+	} else {
+		crToken, err := ioutil.ReadFile(a.CRTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read the compute resource token: %s", err)
+		}
+		form.Set("grant_type", crTokenGrantType)
+		form.Set("cr_token", strings.TrimSpace(string(crToken)))
+		form.Set("profile_id", a.TrustedProfileID)
+	}
+
+	req, err := http.NewRequest("POST", iamEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := a.http
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultHTTPClientTimeout}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		ErrorMessage string `json:"errorMessage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("cannot decode IAM token response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot get IAM token: %s: %s", resp.Status, token.ErrorMessage)
+	}
+
+	a.token = token.AccessToken
+	a.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return a.token, nil
+}
+
+// Client talks to the VPC API of one region.
+type Client struct {
+	Endpoint string
+	auth     *Authenticator
+	http     *http.Client
+}
+
+type Reference struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	CRN  string `json:"crn,omitempty"`
+}
+
+type Subnet struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	VPC  Reference `json:"vpc"`
+	Zone Reference `json:"zone"`
+}
+
+type NetworkInterface struct {
+	ID                 string      `json:"id,omitempty"`
+	PrimaryIP          *ReservedIP `json:"primary_ip,omitempty"`
+	PrimaryIPv4Address string      `json:"primary_ipv4_address,omitempty"`
+	Subnet             *Reference  `json:"subnet,omitempty"`
+	SecurityGroups     []Reference `json:"security_groups,omitempty"`
+}
+
+type ReservedIP struct {
+	ID      string `json:"id,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+type VolumeAttachment struct {
+	DeleteVolumeOnInstanceDelete bool            `json:"delete_volume_on_instance_delete"`
+	Volume                       VolumePrototype `json:"volume"`
+}
+
+type VolumePrototype struct {
+	Name     string    `json:"name,omitempty"`
+	Capacity int       `json:"capacity,omitempty"`
+	Profile  Reference `json:"profile"`
+}
+
+type InstancePrototype struct {
+	Name                    string            `json:"name"`
+	Profile                 Reference         `json:"profile"`
+	Image                   Reference         `json:"image"`
+	Zone                    Reference         `json:"zone"`
+	VPC                     Reference         `json:"vpc"`
+	Keys                    []Reference       `json:"keys"`
+	ResourceGroup           *Reference        `json:"resource_group,omitempty"`
+	PrimaryNetworkInterface NetworkInterface  `json:"primary_network_interface"`
+	BootVolumeAttachment    *VolumeAttachment `json:"boot_volume_attachment,omitempty"`
+	UserData                string            `json:"user_data,omitempty"`
+}
+
+type Instance struct {
+	ID                      string           `json:"id"`
+	Name                    string           `json:"name"`
+	Status                  string           `json:"status"`
+	PrimaryNetworkInterface NetworkInterface `json:"primary_network_interface"`
+}
+
+type FloatingIP struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+type apiError struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func NewClient(region string, auth *Authenticator) *Client {
+	httpClient := &http.Client{Timeout: defaultHTTPClientTimeout}
+	auth.http = httpClient
+	return &Client{
+		Endpoint: fmt.Sprintf(vpcEndpointFormat, region),
+		auth:     auth,
+		http:     httpClient,
+	}
+}
+
+// do sends a request to the VPC API, or to another IBM Cloud API if path is
+// an absolute URL.
+func (c *Client) do(method, path string, body, out interface{}) error {
+	u := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		u = c.Endpoint + path
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(u, c.Endpoint) {
+		q := parsed.Query()
+		q.Set("version", apiVersion)
+		q.Set("generation", "2")
+		parsed.RawQuery = q.Encode()
+	}
+
+	var reqBody *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, parsed.String(), reqBody)
+	if err != nil {
+		return err
+	}
+	token, err := c.auth.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr apiError
+		if json.Unmarshal(data, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			return fmt.Errorf("%s %s: %s: %s", method, path, apiErr.Errors[0].Code, apiErr.Errors[0].Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// findByName walks all pages of a collection and returns the ID of the
+// resource with that name. An ID is returned as is.
+func (c *Client) findByName(collection, name string) (string, error) {
+	if vpcIDPattern.MatchString(name) {
+		return name, nil
+	}
+
+	path := "/" + collection + "?limit=100"
+	for path != "" {
+		var page map[string]json.RawMessage
+		if err := c.do("GET", path, nil, &page); err != nil {
+			return "", err
+		}
+
+		var items []Reference
+		if err := json.Unmarshal(page[collection], &items); err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			if item.Name == name {
+				return item.ID, nil
+			}
+		}
+
+		var next struct {
+			HREF string `json:"href"`
+		}
+		path = ""
+		if raw, ok := page["next"]; ok && json.Unmarshal(raw, &next) == nil {
+			path = next.HREF
+		}
+	}
+
+	return "", fmt.Errorf("%s %q not found", strings.TrimSuffix(strings.ReplaceAll(collection, "_", " "), "s"), name)
+}
+
+func (c *Client) ImageID(name string) (string, error) {
+	return c.findByName("images", name)
+}
+
+func (c *Client) SecurityGroupID(name string) (string, error) {
+	return c.findByName("security_groups", name)
+}
+
+func (c *Client) Subnet(name string) (*Subnet, error) {
+	id, err := c.findByName("subnets", name)
+	if err != nil {
+		return nil, err
+	}
+
+	subnet := &Subnet{}
+	if err := c.do("GET", "/subnets/"+id, nil, subnet); err != nil {
+		return nil, err
+	}
+	return subnet, nil
+}
+
+func (c *Client) CheckProfile(name string) error {
+	if err := c.do("GET", "/instance/profiles/"+url.PathEscape(name), nil, nil); err != nil {
+		if err == errNotFound {
+			return fmt.Errorf("instance profile %q not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// ResourceGroupID resolves a resource group name through the resource
+// manager. An ID is returned as is.
+func (c *Client) ResourceGroupID(name string) (string, error) {
+	if resourceGroupIDPattern.MatchString(name) {
+		return name, nil
+	}
+
+	var groups struct {
+		Resources []Reference `json:"resources"`
+	}
+	if err := c.do("GET", resourceManagerEndpoint+"/resource_groups?name="+url.QueryEscape(name), nil, &groups); err != nil {
+		return "", err
+	}
+	for _, g := range groups.Resources {
+		if g.Name == name {
+			return g.ID, nil
+		}
+	}
+	return "", fmt.Errorf("resource group %q not found", name)
+}
+
+func (c *Client) CreateKey(name, publicKey string, resourceGroup *Reference) (string, error) {
+	body := map[string]interface{}{
+		"name":       name,
+		"public_key": publicKey,
+		"type":       "rsa",
+	}
+	if resourceGroup != nil {
+		body["resource_group"] = resourceGroup
+	}
+
+	var key Reference
+	if err := c.do("POST", "/keys", body, &key); err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+func (c *Client) CreateInstance(prototype *InstancePrototype) (*Instance, error) {
+	instance := &Instance{}
+	if err := c.do("POST", "/instances", prototype, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (c *Client) GetInstance(id string) (*Instance, error) {
+	instance := &Instance{}
+	if err := c.do("GET", "/instances/"+id, nil, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// InstanceAction starts, stops or reboots the instance.
+func (c *Client) InstanceAction(id, action string, force bool) error {
+	return c.do("POST", "/instances/"+id+"/actions", map[string]interface{}{"type": action, "force": force}, nil)
+}
+
+func (c *Client) CreateFloatingIP(name, networkInterfaceID string, resourceGroup *Reference) (*FloatingIP, error) {
+	body := map[string]interface{}{
+		"name":   name,
+		"target": Reference{ID: networkInterfaceID},
+	}
+	if resourceGroup != nil {
+		body["resource_group"] = resourceGroup
+	}
+
+	fip := &FloatingIP{}
+	if err := c.do("POST", "/floating_ips", body, fip); err != nil {
+		return nil, err
+	}
+	return fip, nil
+}
+
+func (c *Client) GetFloatingIP(id string) (*FloatingIP, error) {
+	fip := &FloatingIP{}
+	if err := c.do("GET", "/floating_ips/"+id, nil, fip); err != nil {
+		return nil, err
+	}
+	return fip, nil
+}
+
+// Delete deletes a resource, treating a resource that is already gone as deleted.
+func (c *Client) Delete(collection, id string) error {
+	if err := c.do("DELETE", "/"+collection+"/"+id, nil, nil); err != nil && err != errNotFound {
+		return err
+	}
+	return nil
+}
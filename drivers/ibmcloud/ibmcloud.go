@@ -0,0 +1,516 @@
+package ibmcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	APIKey           string `secret:"true"`
+	TrustedProfileID string
+	CRTokenFile      string
+	Region           string
+	ResourceGroup    string
+	Subnet           string
+	SecurityGroups   []string
+	Profile          string
+	Image            string
+	BootVolumeSize   int
+	PrivateIPOnly    bool
+	UserDataFile     string
+	InstanceID       string
+	KeyID            string
+	FloatingIPID     string
+}
+
+const (
+	defaultRegion        = "us-south"
+	defaultProfile       = "bx2-2x8"
+	defaultImage         = "ibm-ubuntu-22-04-3-minimal-amd64-1"
+	defaultCRTokenFile   = "/var/run/secrets/tokens/vault-token"
+	defaultSSHUser       = "root"
+	defaultSSHPort       = 22
+	bootVolumeProfile    = "general-purpose"
+	minBootVolumeSize    = 100
+	maxBootVolumeSize    = 250
+	instanceWaitAttempts = 120
+	instanceWaitInterval = 5 * time.Second
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_API_KEY",
+			Name:   "ibmcloud-api-key",
+			Usage:  "IBM Cloud API key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_TRUSTED_PROFILE_ID",
+			Name:   "ibmcloud-trusted-profile-id",
+			Usage:  "Trusted profile to authenticate as with the compute resource token, instead of an API key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_CR_TOKEN_FILE",
+			Name:   "ibmcloud-cr-token-file",
+			Usage:  "Compute resource token used with --ibmcloud-trusted-profile-id",
+			Value:  defaultCRTokenFile,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_REGION",
+			Name:   "ibmcloud-region",
+			Usage:  "VPC region",
+			Value:  defaultRegion,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_RESOURCE_GROUP",
+			Name:   "ibmcloud-resource-group",
+			Usage:  "Name or ID of the resource group of the created resources (default is the account's default resource group)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_SUBNET",
+			Name:   "ibmcloud-subnet",
+			Usage:  "Name or ID of the subnet, which also selects the VPC and zone",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "IBMCLOUD_SECURITY_GROUP",
+			Name:   "ibmcloud-security-group",
+			Usage:  "Name or ID of a security group of the instance (default is the VPC's default security group)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_PROFILE",
+			Name:   "ibmcloud-profile",
+			Usage:  "Virtual server instance profile",
+			Value:  defaultProfile,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_IMAGE",
+			Name:   "ibmcloud-image",
+			Usage:  "Name or ID of the image",
+			Value:  defaultImage,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "IBMCLOUD_BOOT_VOLUME_SIZE",
+			Name:   "ibmcloud-boot-volume-size",
+			Usage:  "Boot volume size in GB (default is the size of the image)",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "IBMCLOUD_PRIVATE_IP",
+			Name:   "ibmcloud-private-ip",
+			Usage:  "Don't reserve a floating IP and connect to the private IP",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_USERDATA",
+			Name:   "ibmcloud-userdata",
+			Usage:  "Path to file with cloud-init user-data",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "IBMCLOUD_SSH_USER",
+			Name:   "ibmcloud-ssh-user",
+			Usage:  "SSH user",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "IBMCLOUD_SSH_PORT",
+			Name:   "ibmcloud-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		CRTokenFile: defaultCRTokenFile,
+		Region:      defaultRegion,
+		Profile:     defaultProfile,
+		Image:       defaultImage,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "ibmcloud"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["ibmcloud-api-key"]; ok {
+		d.APIKey = driverOpts.String("ibmcloud-api-key")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.APIKey = flags.String("ibmcloud-api-key")
+	d.TrustedProfileID = flags.String("ibmcloud-trusted-profile-id")
+	d.CRTokenFile = flags.String("ibmcloud-cr-token-file")
+	d.Region = flags.String("ibmcloud-region")
+	d.ResourceGroup = flags.String("ibmcloud-resource-group")
+	d.Subnet = flags.String("ibmcloud-subnet")
+	d.SecurityGroups = flags.StringSlice("ibmcloud-security-group")
+	d.Profile = flags.String("ibmcloud-profile")
+	d.Image = flags.String("ibmcloud-image")
+	d.BootVolumeSize = flags.Int("ibmcloud-boot-volume-size")
+	d.PrivateIPOnly = flags.Bool("ibmcloud-private-ip")
+	d.UserDataFile = flags.String("ibmcloud-userdata")
+	d.SSHUser = flags.String("ibmcloud-ssh-user")
+	d.SSHPort = flags.Int("ibmcloud-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if (d.APIKey == "") == (d.TrustedProfileID == "") {
+		return errors.New("ibmcloud driver requires exactly one of --ibmcloud-api-key or --ibmcloud-trusted-profile-id")
+	}
+	if d.TrustedProfileID != "" && d.CRTokenFile == "" {
+		return errors.New("--ibmcloud-trusted-profile-id requires --ibmcloud-cr-token-file")
+	}
+	if d.Subnet == "" {
+		return errors.New("ibmcloud driver requires the --ibmcloud-subnet option")
+	}
+	if d.BootVolumeSize != 0 && (d.BootVolumeSize < minBootVolumeSize || d.BootVolumeSize > maxBootVolumeSize) {
+		return fmt.Errorf("--ibmcloud-boot-volume-size must be between %d and %d GB", minBootVolumeSize, maxBootVolumeSize)
+	}
+	return nil
+}
+
+func (d *Driver) client() *Client {
+	return NewClient(d.Region, &Authenticator{
+		APIKey:           d.APIKey,
+		TrustedProfileID: d.TrustedProfileID,
+		CRTokenFile:      d.CRTokenFile,
+	})
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("user-data file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	c := d.client()
+	if err := c.CheckProfile(d.Profile); err != nil {
+		return err
+	}
+	if _, err := c.ImageID(d.Image); err != nil {
+		return err
+	}
+	if _, err := c.Subnet(d.Subnet); err != nil {
+		return err
+	}
+	for _, sg := range d.SecurityGroups {
+		if _, err := c.SecurityGroupID(sg); err != nil {
+			return err
+		}
+	}
+	if d.ResourceGroup != "" {
+		if _, err := c.ResourceGroupID(d.ResourceGroup); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	c := d.client()
+
+	var resourceGroup *Reference
+	if d.ResourceGroup != "" {
+		id, err := c.ResourceGroupID(d.ResourceGroup)
+		if err != nil {
+			return err
+		}
+		resourceGroup = &Reference{ID: id}
+	}
+
+	d.KeyID, err = c.CreateKey(d.MachineName, strings.TrimSpace(string(publicKey)), resourceGroup)
+	if err != nil {
+		return fmt.Errorf("error uploading SSH key: %s", err)
+	}
+
+	prototype, err := d.instancePrototype(c, resourceGroup)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Creating instance %s (%s) in %s...", d.MachineName, d.Profile, prototype.Zone.Name)
+	instance, err := c.CreateInstance(prototype)
+	if err != nil {
+		return fmt.Errorf("error creating instance: %s", err)
+	}
+	d.InstanceID = instance.ID
+
+	log.Infof("Waiting for instance %s to be running...", d.InstanceID)
+	if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		if instance, err = c.GetInstance(d.InstanceID); err != nil {
+			return false, err
+		}
+		if instance.Status == "failed" {
+			return false, fmt.Errorf("instance %s failed to start", d.InstanceID)
+		}
+		return instance.Status == "running", nil
+	}, instanceWaitAttempts, instanceWaitInterval); err != nil {
+		return err
+	}
+
+	if !d.PrivateIPOnly {
+		log.Infof("Reserving a floating IP...")
+		fip, err := c.CreateFloatingIP(d.MachineName, instance.PrimaryNetworkInterface.ID, resourceGroup)
+		if err != nil {
+			return fmt.Errorf("error reserving floating IP: %s", err)
+		}
+		d.FloatingIPID = fip.ID
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+func (d *Driver) instancePrototype(c *Client, resourceGroup *Reference) (*InstancePrototype, error) {
+	subnet, err := c.Subnet(d.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	imageID, err := c.ImageID(d.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	var securityGroups []Reference
+	for _, sg := range d.SecurityGroups {
+		id, err := c.SecurityGroupID(sg)
+		if err != nil {
+			return nil, err
+		}
+		securityGroups = append(securityGroups, Reference{ID: id})
+	}
+
+	var userData []byte
+	if d.UserDataFile != "" {
+		if userData, err = ioutil.ReadFile(d.UserDataFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.newInstancePrototype(subnet, imageID, securityGroups, resourceGroup, string(userData)), nil
+}
+
+func (d *Driver) newInstancePrototype(subnet *Subnet, imageID string, securityGroups []Reference, resourceGroup *Reference, userData string) *InstancePrototype {
+	prototype := &InstancePrototype{
+		Name:          d.MachineName,
+		Profile:       Reference{Name: d.Profile},
+		Image:         Reference{ID: imageID},
+		Zone:          Reference{Name: subnet.Zone.Name},
+		VPC:           Reference{ID: subnet.VPC.ID},
+		Keys:          []Reference{{ID: d.KeyID}},
+		ResourceGroup: resourceGroup,
+		PrimaryNetworkInterface: NetworkInterface{
+			Subnet:         &Reference{ID: subnet.ID},
+			SecurityGroups: securityGroups,
+		},
+		UserData: userData,
+	}
+
+	if d.BootVolumeSize > 0 {
+		prototype.BootVolumeAttachment = &VolumeAttachment{
+			DeleteVolumeOnInstanceDelete: true,
+			Volume: VolumePrototype{
+				Name:     d.MachineName + "-boot",
+				Capacity: d.BootVolumeSize,
+				Profile:  Reference{Name: bootVolumeProfile},
+			},
+		}
+	}
+
+	return prototype
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the floating IP, or the private IP of the primary network
+// interface with --ibmcloud-private-ip
+func (d *Driver) GetIP() (string, error) {
+	if d.InstanceID == "" {
+		return "", errors.New("instance has not been created")
+	}
+
+	c := d.client()
+	if d.FloatingIPID != "" {
+		fip, err := c.GetFloatingIP(d.FloatingIPID)
+		if err != nil {
+			return "", err
+		}
+		return fip.Address, nil
+	}
+
+	instance, err := c.GetInstance(d.InstanceID)
+	if err != nil {
+		return "", err
+	}
+	return privateIP(instance)
+}
+
+func privateIP(instance *Instance) (string, error) {
+	nic := instance.PrimaryNetworkInterface
+	// 0.0.0.0 stands for an address that is not allocated yet
+	if nic.PrimaryIP != nil && nic.PrimaryIP.Address != "" && nic.PrimaryIP.Address != "0.0.0.0" {
+		return nic.PrimaryIP.Address, nil
+	}
+	if nic.PrimaryIPv4Address != "" && nic.PrimaryIPv4Address != "0.0.0.0" {
+		return nic.PrimaryIPv4Address, nil
+	}
+	return "", errors.New("instance has no private IP")
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	instance, err := d.client().GetInstance(d.InstanceID)
+	if err != nil {
+		return state.Error, err
+	}
+	return instanceState(instance.Status), nil
+}
+
+func instanceState(status string) state.State {
+	switch status {
+	case "pending", "starting", "restarting", "resuming":
+		return state.Starting
+	case "running":
+		return state.Running
+	case "stopping":
+		return state.Stopping
+	case "stopped":
+		return state.Stopped
+	case "pausing", "paused":
+		return state.Paused
+	case "failed", "deleting":
+		return state.Error
+	}
+	return state.None
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Starting %s...", d.MachineName)
+	return d.client().InstanceAction(d.InstanceID, "start", false)
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Stopping %s...", d.MachineName)
+	return d.client().InstanceAction(d.InstanceID, "stop", false)
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Killing %s...", d.MachineName)
+	return d.client().InstanceAction(d.InstanceID, "stop", true)
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	log.Infof("Restarting %s...", d.MachineName)
+	return d.client().InstanceAction(d.InstanceID, "reboot", false)
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	c := d.client()
+
+	if d.FloatingIPID != "" {
+		log.Infof("Releasing floating IP %s...", d.FloatingIPID)
+		if err := c.Delete("floating_ips", d.FloatingIPID); err != nil {
+			return err
+		}
+	}
+
+	if d.InstanceID != "" {
+		log.Infof("Deleting instance %s...", d.InstanceID)
+		if err := c.Delete("instances", d.InstanceID); err != nil {
+			return err
+		}
+	}
+
+	if d.KeyID != "" {
+		log.Debugf("Deleting SSH key %s...", d.KeyID)
+		if err := c.Delete("keys", d.KeyID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
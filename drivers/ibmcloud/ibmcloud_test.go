@@ -0,0 +1,161 @@
+package ibmcloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"ibmcloud-api-key": "KEY",
+			"ibmcloud-subnet":  "subnet",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Driver
+		err  string
+	}{
+		{name: "api key", d: Driver{APIKey: "KEY", Subnet: "subnet"}},
+		{name: "trusted profile", d: Driver{TrustedProfileID: "Profile-1", CRTokenFile: "token", Subnet: "subnet"}},
+		{name: "no credentials", d: Driver{Subnet: "subnet"}, err: "ibmcloud driver requires exactly one of --ibmcloud-api-key or --ibmcloud-trusted-profile-id"},
+		{name: "both credentials", d: Driver{APIKey: "KEY", TrustedProfileID: "Profile-1", Subnet: "subnet"}, err: "ibmcloud driver requires exactly one of --ibmcloud-api-key or --ibmcloud-trusted-profile-id"},
+		{name: "no subnet", d: Driver{APIKey: "KEY"}, err: "ibmcloud driver requires the --ibmcloud-subnet option"},
+		{name: "boot volume too small", d: Driver{APIKey: "KEY", Subnet: "subnet", BootVolumeSize: 10}, err: "--ibmcloud-boot-volume-size must be between 100 and 250 GB"},
+	}
+
+	for _, test := range tests {
+		err := test.d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestNewInstancePrototype(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.KeyID = "r006-key"
+	d.BootVolumeSize = 120
+
+	subnet := &Subnet{ID: "0717-subnet", VPC: Reference{ID: "r006-vpc"}, Zone: Reference{Name: "us-south-1"}}
+	prototype := d.newInstancePrototype(subnet, "r006-image", []Reference{{ID: "r006-sg"}}, &Reference{ID: "rg"}, "#cloud-config\n")
+
+	assert.Equal(t, "us-south-1", prototype.Zone.Name)
+	assert.Equal(t, "r006-vpc", prototype.VPC.ID)
+	assert.Equal(t, defaultProfile, prototype.Profile.Name)
+	assert.Equal(t, []Reference{{ID: "r006-key"}}, prototype.Keys)
+	assert.Equal(t, "0717-subnet", prototype.PrimaryNetworkInterface.Subnet.ID)
+	assert.Equal(t, 120, prototype.BootVolumeAttachment.Volume.Capacity)
+	assert.True(t, prototype.BootVolumeAttachment.DeleteVolumeOnInstanceDelete)
+
+	d.BootVolumeSize = 0
+	assert.Nil(t, d.newInstancePrototype(subnet, "r006-image", nil, nil, "").BootVolumeAttachment)
+}
+
+func TestInstanceState(t *testing.T) {
+	assert.Equal(t, state.Starting, instanceState("pending"))
+	assert.Equal(t, state.Running, instanceState("running"))
+	assert.Equal(t, state.Stopping, instanceState("stopping"))
+	assert.Equal(t, state.Stopped, instanceState("stopped"))
+	assert.Equal(t, state.Error, instanceState("failed"))
+}
+
+func TestPrivateIP(t *testing.T) {
+	instance := &Instance{}
+	_, err := privateIP(instance)
+	assert.Error(t, err)
+
+	instance.PrimaryNetworkInterface.PrimaryIP = &ReservedIP{Address: "0.0.0.0"}
+	_, err = privateIP(instance)
+	assert.Error(t, err)
+
+	instance.PrimaryNetworkInterface.PrimaryIPv4Address = "10.240.0.4"
+	ip, err := privateIP(instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.240.0.4", ip)
+
+	instance.PrimaryNetworkInterface.PrimaryIP = &ReservedIP{Address: "10.240.0.5"}
+	ip, err = privateIP(instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.240.0.5", ip)
+}
+
+func TestClient(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("CR-TOKEN\n"), 0600))
+
+	tokens := 0
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, crTokenGrantType, r.Form.Get("grant_type"))
+		assert.Equal(t, "CR-TOKEN", r.Form.Get("cr_token"))
+		assert.Equal(t, "Profile-1", r.Form.Get("profile_id"))
+		tokens++
+		fmt.Fprint(w, `{"access_token":"TOKEN","expires_in":3600}`)
+	})
+	mux.HandleFunc("/v1/subnets", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer TOKEN", r.Header.Get("Authorization"))
+		assert.Equal(t, apiVersion, r.URL.Query().Get("version"))
+		if r.URL.Query().Get("start") == "" {
+			fmt.Fprintf(w, `{"subnets":[{"id":"0717-aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa","name":"other"}],"next":{"href":"%s/v1/subnets?start=2"}}`, server.URL)
+			return
+		}
+		fmt.Fprint(w, `{"subnets":[{"id":"0717-bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb","name":"nodes"}]}`)
+	})
+	mux.HandleFunc("/v1/subnets/0717-bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"0717-bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb","name":"nodes","vpc":{"id":"r006-vpc"},"zone":{"name":"us-south-1"}}`)
+	})
+	mux.HandleFunc("/v1/floating_ips/r006-gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[{"code":"not_found","message":"Floating IP not found"}]}`)
+	})
+	mux.HandleFunc("/v1/instances/r006-bad/actions", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"errors":[{"code":"instance_busy","message":"Instance is busy"}]}`)
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	oldIAM := iamEndpoint
+	iamEndpoint = server.URL + "/identity/token"
+	defer func() { iamEndpoint = oldIAM }()
+
+	c := NewClient("us-south", &Authenticator{TrustedProfileID: "Profile-1", CRTokenFile: tokenFile})
+	c.Endpoint = server.URL + "/v1"
+
+	subnet, err := c.Subnet("nodes")
+	assert.NoError(t, err)
+	assert.Equal(t, "r006-vpc", subnet.VPC.ID)
+	assert.Equal(t, "us-south-1", subnet.Zone.Name)
+
+	_, err = c.Subnet("missing")
+	assert.EqualError(t, err, `subnet "missing" not found`)
+	assert.Equal(t, 1, tokens)
+
+	assert.NoError(t, c.Delete("floating_ips", "r006-gone"))
+	assert.EqualError(t, c.InstanceAction("r006-bad", "stop", false), "POST /instances/r006-bad/actions: instance_busy: Instance is busy")
+}
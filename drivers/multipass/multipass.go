@@ -0,0 +1,385 @@
+package multipass
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+	"gopkg.in/yaml.v2"
+)
+
+// Driver creates Ubuntu VMs with Multipass, which picks the hypervisor of
+// the platform.
+type Driver struct {
+	*drivers.BaseDriver
+	cli               commander
+	Binary            string
+	Image             string
+	CPU               int
+	Memory            int
+	DiskSize          int
+	Network           string
+	CloudInitUserData string
+}
+
+const (
+	defaultBinary   = "multipass"
+	defaultCPU      = 1
+	defaultMemory   = 1024
+	defaultDiskSize = 20000
+	defaultSSHUser  = "ubuntu"
+
+	cloudConfigFilename = "cloud-config.yaml"
+)
+
+// commander runs the multipass CLI.
+type commander interface {
+	run(binary string, args ...string) (string, error)
+}
+
+type execCommander struct{}
+
+func (execCommander) run(binary string, args ...string) (string, error) {
+	cmd := exec.Command(binary, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	log.Debugf("executing: %s %s", binary, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %s: %s", binary, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "MULTIPASS_BINARY",
+			Name:   "multipass-binary",
+			Usage:  "Path of the multipass CLI",
+			Value:  defaultBinary,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "MULTIPASS_IMAGE",
+			Name:   "multipass-image",
+			Usage:  "Image to launch, e.g. 22.04 or jammy (default is the latest LTS)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "MULTIPASS_CPU_COUNT",
+			Name:   "multipass-cpu-count",
+			Usage:  "Number of CPUs",
+			Value:  defaultCPU,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "MULTIPASS_MEMORY",
+			Name:   "multipass-memory",
+			Usage:  "Size of memory in MB",
+			Value:  defaultMemory,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "MULTIPASS_DISK_SIZE",
+			Name:   "multipass-disk-size",
+			Usage:  "Size of disk in MB",
+			Value:  defaultDiskSize,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "MULTIPASS_NETWORK",
+			Name:   "multipass-network",
+			Usage:  "Host network to bridge an extra interface to, as listed by \"multipass networks\"",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "MULTIPASS_CLOUD_INIT_USER_DATA",
+			Name:   "multipass-cloud-init-user-data",
+			Usage:  "Path of a cloud-config merged with the one adding the SSH key",
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		cli:      execCommander{},
+		Binary:   defaultBinary,
+		CPU:      defaultCPU,
+		Memory:   defaultMemory,
+		DiskSize: defaultDiskSize,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     22,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "multipass"
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Binary = flags.String("multipass-binary")
+	d.Image = flags.String("multipass-image")
+	d.CPU = flags.Int("multipass-cpu-count")
+	d.Memory = flags.Int("multipass-memory")
+	d.DiskSize = flags.Int("multipass-disk-size")
+	d.Network = flags.String("multipass-network")
+	d.CloudInitUserData = flags.String("multipass-cloud-init-user-data")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.CPU < 1 || d.Memory < 1 || d.DiskSize < 1 {
+		return errors.New("--multipass-cpu-count, --multipass-memory and --multipass-disk-size must be positive")
+	}
+	return nil
+}
+
+func (d *Driver) run(args ...string) (string, error) {
+	if d.cli == nil {
+		d.cli = execCommander{}
+	}
+	return d.cli.run(d.Binary, args...)
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if _, err := d.run("version"); err != nil {
+		return fmt.Errorf("multipass is not available: %s", err)
+	}
+
+	if d.CloudInitUserData != "" {
+		if _, err := d.cloudConfig(""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudConfig returns the cloud-config of the user with the SSH key added.
+// Multipass only accepts a cloud-config, not the other kinds of user data.
+func (d *Driver) cloudConfig(publicKey string) ([]byte, error) {
+	config := map[string]interface{}{}
+	if d.CloudInitUserData != "" {
+		data, err := ioutil.ReadFile(d.CloudInitUserData)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the cloud-init user data: %s", err)
+		}
+		if !bytes.HasPrefix(data, []byte("#cloud-config")) {
+			return nil, fmt.Errorf("%s is not a cloud-config, which multipass requires", d.CloudInitUserData)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", d.CloudInitUserData, err)
+		}
+	}
+
+	keys, _ := config["ssh_authorized_keys"].([]interface{})
+	config["ssh_authorized_keys"] = append(keys, strings.TrimSpace(publicKey))
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), data...), nil
+}
+
+func (d *Driver) launchArgs() []string {
+	args := []string{"launch",
+		"--name", d.MachineName,
+		"--cpus", fmt.Sprintf("%d", d.CPU),
+		"--memory", fmt.Sprintf("%dM", d.Memory),
+		"--disk", fmt.Sprintf("%dM", d.DiskSize),
+		"--cloud-init", d.ResolveStorePath(cloudConfigFilename),
+	}
+	if d.Network != "" {
+		args = append(args, "--network", d.Network)
+	}
+	if d.Image != "" {
+		args = append(args, d.Image)
+	}
+	return args
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	config, err := d.cloudConfig(string(publicKey))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(d.ResolveStorePath(cloudConfigFilename), config, 0600); err != nil {
+		return err
+	}
+
+	log.Infof("Launching %s...", d.MachineName)
+	if _, err := d.run(d.launchArgs()...); err != nil {
+		return err
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+// instance is the state of a VM in "multipass info --format json".
+type instance struct {
+	State string   `json:"state"`
+	IPv4  []string `json:"ipv4"`
+}
+
+func (d *Driver) info() (*instance, error) {
+	out, err := d.run("info", d.MachineName, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	info := struct {
+		Info map[string]*instance `json:"info"`
+	}{}
+	if err := json.Unmarshal([]byte(out), &info); err != nil {
+		return nil, fmt.Errorf("error parsing multipass info: %s", err)
+	}
+	i, ok := info.Info[d.MachineName]
+	if !ok {
+		return nil, fmt.Errorf("instance %s not found in multipass info", d.MachineName)
+	}
+	return i, nil
+}
+
+// GetState returns the state that the host is in (running, stopped, etc)
+func (d *Driver) GetState() (state.State, error) {
+	i, err := d.info()
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return state.NotFound, nil
+		}
+		return state.Error, err
+	}
+
+	switch i.State {
+	case "Running", "Delayed Shutdown":
+		return state.Running, nil
+	case "Starting", "Restarting":
+		return state.Starting, nil
+	case "Suspending":
+		return state.Stopping, nil
+	case "Suspended":
+		return state.Saved, nil
+	case "Stopped":
+		return state.Stopped, nil
+	case "Deleted":
+		return state.NotFound, nil
+	}
+	return state.None, nil
+}
+
+// GetIP returns an IP or hostname that this host is available at
+func (d *Driver) GetIP() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	i, err := d.info()
+	if err != nil {
+		return "", err
+	}
+	if len(i.IPv4) == 0 {
+		return "", fmt.Errorf("instance %s has no IP address", d.MachineName)
+	}
+	return i.IPv4[0], nil
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	if _, err := d.run("start", d.MachineName); err != nil {
+		return err
+	}
+
+	var err error
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	if _, err := d.run("stop", d.MachineName); err != nil {
+		return err
+	}
+	d.IPAddress = ""
+	return nil
+}
+
+// Restart a host.
+func (d *Driver) Restart() error {
+	if _, err := d.run("restart", d.MachineName); err != nil {
+		return err
+	}
+
+	var err error
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	if _, err := d.run("stop", "--force", d.MachineName); err != nil {
+		return err
+	}
+	d.IPAddress = ""
+	return nil
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if s == state.NotFound {
+		log.Infof("Instance %s not found, skipping removal", d.MachineName)
+		return nil
+	}
+
+	_, err = d.run("delete", "--purge", d.MachineName)
+	return err
+}
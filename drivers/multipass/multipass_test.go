@@ -0,0 +1,118 @@
+package multipass
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCommander struct {
+	calls  []string
+	output string
+	err    error
+}
+
+func (c *fakeCommander) run(binary string, args ...string) (string, error) {
+	c.calls = append(c.calls, binary+" "+strings.Join(args, " "))
+	return c.output, c.err
+}
+
+const runningInfo = `{
+    "errors": [],
+    "info": {
+        "default": {
+            "disks": {},
+            "image_release": "22.04 LTS",
+            "ipv4": ["10.118.105.21", "192.168.1.40"],
+            "state": "Running"
+        }
+    }
+}`
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "ubuntu", driver.GetSSHUsername())
+
+	checkFlags.FlagsValues["multipass-memory"] = 0
+	assert.EqualError(t, driver.SetConfigFromFlags(checkFlags), "--multipass-cpu-count, --multipass-memory and --multipass-disk-size must be positive")
+}
+
+func TestLaunchArgs(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.Image, d.Network = "22.04", "en0"
+
+	assert.Equal(t, []string{"launch",
+		"--name", "default",
+		"--cpus", "1",
+		"--memory", "1024M",
+		"--disk", "20000M",
+		"--cloud-init", "path/machines/default/cloud-config.yaml",
+		"--network", "en0",
+		"22.04",
+	}, d.launchArgs())
+}
+
+func TestCloudConfig(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+
+	config, err := d.cloudConfig("ssh-rsa AAAA\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nssh_authorized_keys:\n- ssh-rsa AAAA\n", string(config))
+
+	f, err := ioutil.TempFile("", "user-data")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("#cloud-config\npackages: [jq]\nssh_authorized_keys: [ssh-ed25519 BBBB]\n")
+	f.Close()
+
+	d.CloudInitUserData = f.Name()
+	config, err = d.cloudConfig("ssh-rsa AAAA")
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\npackages:\n- jq\nssh_authorized_keys:\n- ssh-ed25519 BBBB\n- ssh-rsa AAAA\n", string(config))
+
+	assert.NoError(t, ioutil.WriteFile(f.Name(), []byte("#!/bin/sh\n"), 0600))
+	_, err = d.cloudConfig("ssh-rsa AAAA")
+	assert.EqualError(t, err, f.Name()+" is not a cloud-config, which multipass requires")
+}
+
+func TestGetState(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	cli := &fakeCommander{output: runningInfo}
+	d.cli = cli
+
+	s, err := d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, s)
+	assert.Equal(t, []string{"multipass info default --format json"}, cli.calls)
+
+	ip, err := d.GetIP()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.118.105.21", ip)
+
+	cli.output = strings.Replace(runningInfo, "Running", "Stopped", 1)
+	s, err = d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, s)
+
+	cli.err = errors.New(`multipass info: exit status 2: info failed: The following errors occurred:` + "\n" + `instance "default" does not exist`)
+	s, err = d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.NotFound, s)
+	assert.NoError(t, d.Remove())
+}
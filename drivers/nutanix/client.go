@@ -0,0 +1,327 @@
+package nutanix
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const apiVersion = "3.1"
+
+// Task status values reported by Prism Central.
+const (
+	taskSucceeded = "SUCCEEDED"
+	taskFailed    = "FAILED"
+	taskAborted   = "ABORTED"
+)
+
+var (
+	errNotFound = errors.New("not found")
+
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	taskPollInterval = 2 * time.Second
+	taskTimeout      = 10 * time.Minute
+)
+
+// Client talks to the Prism Central v3 API.
+type Client struct {
+	Endpoint string
+	Username string
+	Password string
+	http     *http.Client
+}
+
+type Reference struct {
+	Kind string `json:"kind"`
+	UUID string `json:"uuid"`
+	Name string `json:"name,omitempty"`
+}
+
+type VMIntent struct {
+	Spec       VMSpec     `json:"spec"`
+	Metadata   VMMetadata `json:"metadata"`
+	APIVersion string     `json:"api_version"`
+}
+
+type VMSpec struct {
+	Name             string     `json:"name"`
+	Description      string     `json:"description,omitempty"`
+	Resources        VMRes      `json:"resources"`
+	ClusterReference *Reference `json:"cluster_reference,omitempty"`
+}
+
+type VMRes struct {
+	NumSockets         int                 `json:"num_sockets"`
+	NumVcpusPerSocket  int                 `json:"num_vcpus_per_socket"`
+	MemorySizeMib      int                 `json:"memory_size_mib"`
+	PowerState         string              `json:"power_state,omitempty"`
+	DiskList           []Disk              `json:"disk_list,omitempty"`
+	NicList            []NIC               `json:"nic_list,omitempty"`
+	GuestCustomization *GuestCustomization `json:"guest_customization,omitempty"`
+}
+
+type Disk struct {
+	DeviceProperties    *DeviceProperties `json:"device_properties,omitempty"`
+	DataSourceReference *Reference        `json:"data_source_reference,omitempty"`
+	DiskSizeMib         int               `json:"disk_size_mib,omitempty"`
+}
+
+type DeviceProperties struct {
+	DeviceType  string       `json:"device_type"`
+	DiskAddress *DiskAddress `json:"disk_address,omitempty"`
+}
+
+type DiskAddress struct {
+	AdapterType string `json:"adapter_type"`
+	DeviceIndex int    `json:"device_index"`
+}
+
+type NIC struct {
+	SubnetReference *Reference   `json:"subnet_reference,omitempty"`
+	IPEndpointList  []IPEndpoint `json:"ip_endpoint_list,omitempty"`
+}
+
+type IPEndpoint struct {
+	IP string `json:"ip"`
+}
+
+type GuestCustomization struct {
+	CloudInit *CloudInit `json:"cloud_init,omitempty"`
+}
+
+type CloudInit struct {
+	UserData string `json:"user_data,omitempty"`
+}
+
+type VMMetadata struct {
+	Kind       string            `json:"kind"`
+	UUID       string            `json:"uuid,omitempty"`
+	Categories map[string]string `json:"categories,omitempty"`
+}
+
+// VM is the part of a VM entity the driver reads back.
+type VM struct {
+	Metadata VMMetadata `json:"metadata"`
+	Status   struct {
+		State     string `json:"state"`
+		Resources struct {
+			PowerState string `json:"power_state"`
+			NicList    []NIC  `json:"nic_list"`
+		} `json:"resources"`
+	} `json:"status"`
+}
+
+type taskReference struct {
+	Status struct {
+		ExecutionContext struct {
+			TaskUUID string `json:"task_uuid"`
+		} `json:"execution_context"`
+	} `json:"status"`
+	Metadata VMMetadata `json:"metadata"`
+}
+
+type task struct {
+	Status             string `json:"status"`
+	ErrorDetail        string `json:"error_detail"`
+	PercentageComplete int    `json:"percentage_complete"`
+}
+
+type apiError struct {
+	MessageList []struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	} `json:"message_list"`
+}
+
+func NewClient(endpoint, username, password string, insecure bool) *Client {
+	return &Client{
+		Endpoint: endpoint,
+		Username: username,
+		Password: password,
+		http: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reqBody *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.Endpoint+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr apiError
+		if json.Unmarshal(data, &apiErr) == nil && len(apiErr.MessageList) > 0 {
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr.MessageList[0].Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// FindUUID resolves the name of a cluster, subnet or image to its UUID. A
+// UUID is returned as is.
+func (c *Client) FindUUID(kind, name string) (string, error) {
+	if uuidPattern.MatchString(name) {
+		return name, nil
+	}
+
+	var list struct {
+		Entities []struct {
+			Spec struct {
+				Name string `json:"name"`
+			} `json:"spec"`
+			Metadata VMMetadata `json:"metadata"`
+		} `json:"entities"`
+	}
+	req := map[string]interface{}{
+		"kind":   kind,
+		"filter": "name==" + name,
+		"length": 100,
+	}
+	if err := c.do("POST", "/"+kind+"s/list", req, &list); err != nil {
+		return "", err
+	}
+
+	var uuids []string
+	for _, e := range list.Entities {
+		if e.Spec.Name == name {
+			uuids = append(uuids, e.Metadata.UUID)
+		}
+	}
+	switch len(uuids) {
+	case 0:
+		return "", fmt.Errorf("%s %q not found", kind, name)
+	case 1:
+		return uuids[0], nil
+	}
+	return "", fmt.Errorf("%d %ss are named %q, use the UUID instead", len(uuids), kind, name)
+}
+
+// CreateVM creates the VM and returns its UUID together with the creation task.
+func (c *Client) CreateVM(intent *VMIntent) (string, string, error) {
+	intent.APIVersion = apiVersion
+	intent.Metadata.Kind = "vm"
+
+	var ref taskReference
+	if err := c.do("POST", "/vms", intent, &ref); err != nil {
+		return "", "", err
+	}
+	return ref.Metadata.UUID, ref.Status.ExecutionContext.TaskUUID, nil
+}
+
+func (c *Client) GetVM(uuid string) (*VM, error) {
+	vm := &VM{}
+	if err := c.do("GET", "/vms/"+uuid, nil, vm); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// SetPowerState changes the power state of the VM. The intent is sent back
+// as fetched so that nothing but the power state changes.
+func (c *Client) SetPowerState(uuid, powerState, mechanism string) (string, error) {
+	var intent map[string]interface{}
+	if err := c.do("GET", "/vms/"+uuid, nil, &intent); err != nil {
+		return "", err
+	}
+	delete(intent, "status")
+
+	spec, _ := intent["spec"].(map[string]interface{})
+	if spec == nil {
+		return "", fmt.Errorf("VM %s has no spec", uuid)
+	}
+	resources, _ := spec["resources"].(map[string]interface{})
+	if resources == nil {
+		return "", fmt.Errorf("VM %s has no resources", uuid)
+	}
+	resources["power_state"] = powerState
+	if mechanism != "" {
+		resources["power_state_mechanism"] = map[string]interface{}{"mechanism": mechanism}
+	}
+
+	var ref taskReference
+	if err := c.do("PUT", "/vms/"+uuid, intent, &ref); err != nil {
+		return "", err
+	}
+	return ref.Status.ExecutionContext.TaskUUID, nil
+}
+
+func (c *Client) DeleteVM(uuid string) (string, error) {
+	var ref taskReference
+	if err := c.do("DELETE", "/vms/"+uuid, nil, &ref); err != nil {
+		return "", err
+	}
+	return ref.Status.ExecutionContext.TaskUUID, nil
+}
+
+// WaitForTask polls the task until it has finished.
+func (c *Client) WaitForTask(uuid string) error {
+	if uuid == "" {
+		return nil
+	}
+
+	deadline := time.Now().Add(taskTimeout)
+	for {
+		var t task
+		if err := c.do("GET", "/tasks/"+uuid, nil, &t); err != nil {
+			return err
+		}
+
+		switch t.Status {
+		case taskSucceeded:
+			return nil
+		case taskFailed, taskAborted:
+			return fmt.Errorf("task %s %s: %s", uuid, t.Status, t.ErrorDetail)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("task %s did not finish within %s (%d%%)", uuid, taskTimeout, t.PercentageComplete)
+		}
+		time.Sleep(taskPollInterval)
+	}
+}
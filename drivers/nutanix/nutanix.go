@@ -0,0 +1,565 @@
+package nutanix
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+	"gopkg.in/yaml.v2"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	Endpoint   string
+	Port       int
+	Username   string
+	Password   string `secret:"true"`
+	Insecure   bool
+	Cluster    string
+	Subnets    []string
+	Image      string
+	DiskSize   int
+	Memory     int
+	CPUs       int
+	Cores      int
+	Categories map[string]string
+	CloudInit  string
+	VMUUID     string
+}
+
+const (
+	defaultPort    = 9440
+	defaultMemory  = 2048
+	defaultCPUs    = 2
+	defaultCores   = 1
+	defaultSSHUser = "docker"
+	defaultSSHPort = 22
+	apiPath        = "/api/nutanix/v3"
+	ipWaitAttempts = 120
+	ipWaitInterval = 5 * time.Second
+)
+
+// VM states and power states as reported in the VM status.
+const (
+	vmStateError     = "ERROR"
+	vmStatePending   = "PENDING"
+	powerStateOn     = "ON"
+	powerStateOff    = "OFF"
+	powerStatePaused = "PAUSED"
+	acpiMechanism    = "ACPI"
+	hardMechanism    = "HARD"
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_ENDPOINT",
+			Name:   "nutanix-endpoint",
+			Usage:  "Prism Central address",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_PORT",
+			Name:   "nutanix-port",
+			Usage:  "Prism Central port",
+			Value:  defaultPort,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_USERNAME",
+			Name:   "nutanix-username",
+			Usage:  "Prism Central username",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_PASSWORD",
+			Name:   "nutanix-password",
+			Usage:  "Prism Central password",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "NUTANIX_INSECURE",
+			Name:   "nutanix-insecure",
+			Usage:  "Skip verification of the Prism Central certificate",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_CLUSTER",
+			Name:   "nutanix-cluster",
+			Usage:  "Name or UUID of the cluster to create the VM on",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "NUTANIX_VM_NETWORK",
+			Name:   "nutanix-vm-network",
+			Usage:  "Name or UUID of a subnet to attach the VM to, the first one is used to reach the VM",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_VM_IMAGE",
+			Name:   "nutanix-vm-image",
+			Usage:  "Name or UUID of the disk image to clone the boot disk from",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_VM_IMAGE_SIZE",
+			Name:   "nutanix-vm-image-size",
+			Usage:  "Size of the boot disk in GiB (default is the size of the image)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_VM_MEM",
+			Name:   "nutanix-vm-mem",
+			Usage:  "Memory of the VM in MiB",
+			Value:  defaultMemory,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_VM_CPUS",
+			Name:   "nutanix-vm-cpus",
+			Usage:  "Number of CPU sockets of the VM",
+			Value:  defaultCPUs,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_VM_CORES",
+			Name:   "nutanix-vm-cores",
+			Usage:  "Number of cores per CPU socket",
+			Value:  defaultCores,
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "NUTANIX_VM_CATEGORIES",
+			Name:   "nutanix-vm-categories",
+			Usage:  "Category to assign to the VM, in the form key=value",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_VM_CLOUD_INIT",
+			Name:   "nutanix-vm-cloud-init",
+			Usage:  "Path to a cloud-config file, the SSH user is added to it",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "NUTANIX_SSH_USER",
+			Name:   "nutanix-ssh-user",
+			Usage:  "SSH user created through cloud-init",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "NUTANIX_SSH_PORT",
+			Name:   "nutanix-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Port:   defaultPort,
+		Memory: defaultMemory,
+		CPUs:   defaultCPUs,
+		Cores:  defaultCores,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "nutanix"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["nutanix-username"]; ok {
+		d.Username = driverOpts.String("nutanix-username")
+	}
+
+	if _, ok := driverOpts.Values["nutanix-password"]; ok {
+		d.Password = driverOpts.String("nutanix-password")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Endpoint = flags.String("nutanix-endpoint")
+	d.Port = flags.Int("nutanix-port")
+	d.Username = flags.String("nutanix-username")
+	d.Password = flags.String("nutanix-password")
+	d.Insecure = flags.Bool("nutanix-insecure")
+	d.Cluster = flags.String("nutanix-cluster")
+	d.Subnets = flags.StringSlice("nutanix-vm-network")
+	d.Image = flags.String("nutanix-vm-image")
+	d.DiskSize = flags.Int("nutanix-vm-image-size")
+	d.Memory = flags.Int("nutanix-vm-mem")
+	d.CPUs = flags.Int("nutanix-vm-cpus")
+	d.Cores = flags.Int("nutanix-vm-cores")
+	d.SSHUser = flags.String("nutanix-ssh-user")
+	d.SSHPort = flags.Int("nutanix-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	if d.Endpoint == "" || d.Username == "" || d.Password == "" {
+		return errors.New("nutanix driver requires the --nutanix-endpoint, --nutanix-username and --nutanix-password options")
+	}
+	if d.Cluster == "" || len(d.Subnets) == 0 || d.Image == "" {
+		return errors.New("nutanix driver requires the --nutanix-cluster, --nutanix-vm-network and --nutanix-vm-image options")
+	}
+	if d.CPUs < 1 || d.Cores < 1 || d.Memory < 1 {
+		return errors.New("--nutanix-vm-cpus, --nutanix-vm-cores and --nutanix-vm-mem must be positive")
+	}
+	if d.DiskSize < 0 {
+		return errors.New("--nutanix-vm-image-size must not be negative")
+	}
+
+	categories, err := parseCategories(flags.StringSlice("nutanix-vm-categories"))
+	if err != nil {
+		return err
+	}
+	d.Categories = categories
+
+	d.CloudInit = ""
+	if path := flags.String("nutanix-vm-cloud-init"); path != "" {
+		cloudInit, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read cloud-init file: %s", err)
+		}
+		d.CloudInit = string(cloudInit)
+	}
+
+	return nil
+}
+
+func parseCategories(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	categories := map[string]string{}
+	for _, spec := range specs {
+		key, value, ok := mcnutils.ParseKeyValue(spec)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid category %q, expected key=value", spec)
+		}
+		categories[key] = value
+	}
+	return categories, nil
+}
+
+func (d *Driver) client() *Client {
+	endpoint := d.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	if u, err := url.Parse(endpoint); err == nil && u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(d.Port))
+		endpoint = u.String()
+	}
+
+	return NewClient(strings.TrimSuffix(endpoint, "/")+apiPath, d.Username, d.Password, d.Insecure)
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	c := d.client()
+
+	log.Infof("Validating cluster %s, image %s and subnets...", d.Cluster, d.Image)
+	if _, err := c.FindUUID("cluster", d.Cluster); err != nil {
+		return err
+	}
+	if _, err := c.FindUUID("image", d.Image); err != nil {
+		return err
+	}
+	for _, subnet := range d.Subnets {
+		if _, err := c.FindUUID("subnet", subnet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+
+	userData, err := d.cloudInitUserData()
+	if err != nil {
+		return err
+	}
+
+	intent, err := d.vmIntent(userData)
+	if err != nil {
+		return err
+	}
+
+	c := d.client()
+	log.Infof("Creating VM %s...", d.MachineName)
+	uuid, taskUUID, err := c.CreateVM(intent)
+	if err != nil {
+		return fmt.Errorf("error creating VM: %s", err)
+	}
+	d.VMUUID = uuid
+
+	if err := c.WaitForTask(taskUUID); err != nil {
+		return fmt.Errorf("error creating VM: %s", err)
+	}
+
+	log.Infof("Waiting for VM %s to get an IP address...", d.MachineName)
+	if err := mcnutils.WaitForSpecific(func() bool {
+		ip, err := d.GetIP()
+		if err != nil {
+			log.Debugf("VM %s has no IP address yet: %s", d.MachineName, err)
+			return false
+		}
+		d.IPAddress = ip
+		return true
+	}, ipWaitAttempts, ipWaitInterval); err != nil {
+		return fmt.Errorf("VM %s did not report an IP address: %s", d.MachineName, err)
+	}
+
+	log.Debugf("VM %s (%s) has IP address %s", d.MachineName, d.VMUUID, d.IPAddress)
+	return nil
+}
+
+func (d *Driver) vmIntent(userData string) (*VMIntent, error) {
+	c := d.client()
+
+	clusterUUID, err := c.FindUUID("cluster", d.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	imageUUID, err := c.FindUUID("image", d.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	intent := &VMIntent{
+		Spec: VMSpec{
+			Name:             d.MachineName,
+			Description:      "Created by rancher-machine",
+			ClusterReference: &Reference{Kind: "cluster", UUID: clusterUUID},
+			Resources: VMRes{
+				NumSockets:        d.CPUs,
+				NumVcpusPerSocket: d.Cores,
+				MemorySizeMib:     d.Memory,
+				PowerState:        powerStateOn,
+				DiskList: []Disk{{
+					DeviceProperties: &DeviceProperties{
+						DeviceType:  "DISK",
+						DiskAddress: &DiskAddress{AdapterType: "SCSI", DeviceIndex: 0},
+					},
+					DataSourceReference: &Reference{Kind: "image", UUID: imageUUID},
+					DiskSizeMib:         d.DiskSize * 1024,
+				}},
+				GuestCustomization: &GuestCustomization{
+					CloudInit: &CloudInit{UserData: base64.StdEncoding.EncodeToString([]byte(userData))},
+				},
+			},
+		},
+		Metadata: VMMetadata{Categories: d.Categories},
+	}
+
+	for _, subnet := range d.Subnets {
+		subnetUUID, err := c.FindUUID("subnet", subnet)
+		if err != nil {
+			return nil, err
+		}
+		intent.Spec.Resources.NicList = append(intent.Spec.Resources.NicList, NIC{
+			SubnetReference: &Reference{Kind: "subnet", UUID: subnetUUID},
+		})
+	}
+
+	return intent, nil
+}
+
+// cloudInitUserData returns the cloud-config with the SSH user added.
+func (d *Driver) cloudInitUserData() (string, error) {
+	key, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return "", err
+	}
+
+	return addSSHUser(d.CloudInit, d.GetSSHUsername(), strings.TrimSpace(string(key)))
+}
+
+func addSSHUser(cloudConfig, user, key string) (string, error) {
+	cf := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal([]byte(cloudConfig), &cf); err != nil {
+		return "", fmt.Errorf("cannot parse cloud-init file: %s", err)
+	}
+
+	sshUser := map[interface{}]interface{}{
+		"name":                user,
+		"lock_passwd":         true,
+		"sudo":                "ALL=(ALL) NOPASSWD:ALL",
+		"shell":               "/bin/bash",
+		"ssh_authorized_keys": []string{key},
+	}
+
+	users, _ := cf["users"].([]interface{})
+	if len(users) == 0 {
+		// keep the image's default user around when none were configured
+		users = append(users, "default")
+	}
+	cf["users"] = append(users, sshUser)
+
+	out, err := yaml.Marshal(cf)
+	if err != nil {
+		return "", err
+	}
+	return "#cloud-config\n" + string(out), nil
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the first IP address of the NIC on the first subnet.
+func (d *Driver) GetIP() (string, error) {
+	if d.VMUUID == "" {
+		return "", errors.New("VM has not been created")
+	}
+
+	vm, err := d.client().GetVM(d.VMUUID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, nic := range vm.Status.Resources.NicList {
+		for _, ep := range nic.IPEndpointList {
+			if ep.IP != "" {
+				return ep.IP, nil
+			}
+		}
+	}
+	return "", errors.New("VM has no IP address")
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	vm, err := d.client().GetVM(d.VMUUID)
+	if err != nil {
+		return state.Error, err
+	}
+	return vmState(vm), nil
+}
+
+func vmState(vm *VM) state.State {
+	switch vm.Status.State {
+	case vmStateError:
+		return state.Error
+	case vmStatePending:
+		if vm.Status.Resources.PowerState == "" {
+			return state.Starting
+		}
+	}
+
+	switch vm.Status.Resources.PowerState {
+	case powerStateOn:
+		return state.Running
+	case powerStateOff:
+		return state.Stopped
+	case powerStatePaused:
+		return state.Paused
+	}
+	return state.None
+}
+
+func (d *Driver) setPowerState(powerState, mechanism string) error {
+	c := d.client()
+	taskUUID, err := c.SetPowerState(d.VMUUID, powerState, mechanism)
+	if err != nil {
+		return err
+	}
+	return c.WaitForTask(taskUUID)
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Powering on %s...", d.MachineName)
+	return d.setPowerState(powerStateOn, "")
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Shutting down %s...", d.MachineName)
+	return d.setPowerState(powerStateOff, acpiMechanism)
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Powering off %s...", d.MachineName)
+	return d.setPowerState(powerStateOff, hardMechanism)
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	if err := d.Stop(); err != nil {
+		return err
+	}
+	return d.Start()
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	if d.VMUUID == "" {
+		return nil
+	}
+
+	c := d.client()
+	log.Infof("Deleting VM %s...", d.MachineName)
+	taskUUID, err := c.DeleteVM(d.VMUUID)
+	if err == errNotFound {
+		log.Infof("VM %s doesn't exist, assuming it is already deleted", d.VMUUID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.WaitForTask(taskUUID)
+}
@@ -0,0 +1,165 @@
+package nutanix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"nutanix-endpoint":      "pc.example.com",
+			"nutanix-username":      "admin",
+			"nutanix-password":      "pwd",
+			"nutanix-cluster":       "cluster",
+			"nutanix-vm-network":    []string{"vlan0"},
+			"nutanix-vm-image":      "ubuntu",
+			"nutanix-vm-categories": []string{"Environment=Dev", "AppType=Kubernetes"},
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+
+	d := driver.(*Driver)
+	assert.Equal(t, map[string]string{"Environment": "Dev", "AppType": "Kubernetes"}, d.Categories)
+	assert.Equal(t, "https://pc.example.com:9440/api/nutanix/v3", d.client().Endpoint)
+}
+
+func TestSetConfigFromFlagsRequired(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"nutanix-endpoint": "pc.example.com",
+			"nutanix-username": "admin",
+			"nutanix-password": "pwd",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	assert.Error(t, driver.SetConfigFromFlags(checkFlags))
+}
+
+func TestParseCategories(t *testing.T) {
+	categories, err := parseCategories(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, categories)
+
+	_, err = parseCategories([]string{"Environment"})
+	assert.Error(t, err)
+
+	_, err = parseCategories([]string{"Environment="})
+	assert.Error(t, err)
+}
+
+func TestAddSSHUser(t *testing.T) {
+	userData, err := addSSHUser("packages:\n- curl\n", "docker", "ssh-rsa KEY")
+	assert.NoError(t, err)
+	assert.Regexp(t, "^#cloud-config\n", userData)
+
+	cf := map[string]interface{}{}
+	assert.NoError(t, yaml.Unmarshal([]byte(userData), &cf))
+	assert.Equal(t, []interface{}{"curl"}, cf["packages"])
+
+	users := cf["users"].([]interface{})
+	assert.Len(t, users, 2)
+	assert.Equal(t, "default", users[0])
+	user := users[1].(map[interface{}]interface{})
+	assert.Equal(t, "docker", user["name"])
+	assert.Equal(t, []interface{}{"ssh-rsa KEY"}, user["ssh_authorized_keys"])
+}
+
+func TestVMState(t *testing.T) {
+	vm := func(vmState, powerState string) *VM {
+		v := &VM{}
+		v.Status.State = vmState
+		v.Status.Resources.PowerState = powerState
+		return v
+	}
+
+	assert.Equal(t, state.Starting, vmState(vm("PENDING", "")))
+	assert.Equal(t, state.Running, vmState(vm("PENDING", "ON")))
+	assert.Equal(t, state.Running, vmState(vm("COMPLETE", "ON")))
+	assert.Equal(t, state.Stopped, vmState(vm("COMPLETE", "OFF")))
+	assert.Equal(t, state.Paused, vmState(vm("COMPLETE", "PAUSED")))
+	assert.Equal(t, state.Error, vmState(vm("ERROR", "ON")))
+}
+
+func TestClient(t *testing.T) {
+	var powerIntent map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subnets/list", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "pwd", pass)
+		w.Write([]byte(`{"entities":[
+			{"spec":{"name":"vlan0"},"metadata":{"uuid":"11111111-1111-1111-1111-111111111111"}},
+			{"spec":{"name":"vlan0-backup"},"metadata":{"uuid":"22222222-2222-2222-2222-222222222222"}}]}`))
+	})
+	mux.HandleFunc("/vms/33333333-3333-3333-3333-333333333333", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"spec":{"name":"vm","resources":{"power_state":"ON","num_sockets":2}},
+				"metadata":{"kind":"vm","uuid":"33333333-3333-3333-3333-333333333333","spec_version":4},
+				"status":{"state":"COMPLETE","resources":{"power_state":"ON","nic_list":[{"ip_endpoint_list":[{"ip":"10.0.0.5"}]}]}}}`))
+		case "PUT":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&powerIntent))
+			w.Write([]byte(`{"status":{"execution_context":{"task_uuid":"task"}}}`))
+		}
+	})
+	mux.HandleFunc("/tasks/task", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"SUCCEEDED","percentage_complete":100}`))
+	})
+	mux.HandleFunc("/tasks/failed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"FAILED","error_detail":"out of memory"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewClient(server.URL, "admin", "pwd", false)
+
+	uuid, err := c.FindUUID("subnet", "vlan0")
+	assert.NoError(t, err)
+	assert.Equal(t, "11111111-1111-1111-1111-111111111111", uuid)
+
+	_, err = c.FindUUID("subnet", "vlan1")
+	assert.EqualError(t, err, `subnet "vlan1" not found`)
+
+	uuid, err = c.FindUUID("subnet", "44444444-4444-4444-4444-444444444444")
+	assert.NoError(t, err)
+	assert.Equal(t, "44444444-4444-4444-4444-444444444444", uuid)
+
+	vm, err := c.GetVM("33333333-3333-3333-3333-333333333333")
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, vmState(vm))
+	assert.Equal(t, "10.0.0.5", vm.Status.Resources.NicList[0].IPEndpointList[0].IP)
+
+	_, err = c.GetVM("55555555-5555-5555-5555-555555555555")
+	assert.Equal(t, errNotFound, err)
+
+	taskUUID, err := c.SetPowerState("33333333-3333-3333-3333-333333333333", "OFF", "ACPI")
+	assert.NoError(t, err)
+	assert.NoError(t, c.WaitForTask(taskUUID))
+	assert.NotContains(t, powerIntent, "status")
+	assert.Equal(t, float64(4), powerIntent["metadata"].(map[string]interface{})["spec_version"])
+	resources := powerIntent["spec"].(map[string]interface{})["resources"].(map[string]interface{})
+	assert.Equal(t, "OFF", resources["power_state"])
+	assert.Equal(t, map[string]interface{}{"mechanism": "ACPI"}, resources["power_state_mechanism"])
+	assert.Equal(t, float64(2), resources["num_sockets"])
+
+	assert.EqualError(t, c.WaitForTask("failed"), "task failed FAILED: out of memory")
+}
@@ -0,0 +1,192 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+type client struct {
+	tenancyID string
+	compute   core.ComputeClient
+	network   core.VirtualNetworkClient
+	identity  identity.IdentityClient
+}
+
+// configProvider picks the authentication: instance principals, the API
+// signing key given on the command line, or else the OCI CLI config file.
+func (d *Driver) configProvider() (common.ConfigurationProvider, error) {
+	if d.InstancePrincipals {
+		if d.Region != "" {
+			return auth.InstancePrincipalConfigurationProviderForRegion(common.StringToRegion(d.Region))
+		}
+		return auth.InstancePrincipalConfigurationProvider()
+	}
+
+	if d.UserID == "" {
+		return common.DefaultConfigProvider(), nil
+	}
+
+	// the key is read on every call so that it never ends up in the machine config
+	key := d.PrivateKeyContents
+	if key == "" {
+		b, err := ioutil.ReadFile(d.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read API signing key: %s", err)
+		}
+		key = string(b)
+	}
+
+	var passphrase *string
+	if d.PrivateKeyPassphrase != "" {
+		passphrase = common.String(d.PrivateKeyPassphrase)
+	}
+	return common.NewRawConfigurationProvider(d.TenancyID, d.UserID, d.Region, d.Fingerprint, key, passphrase), nil
+}
+
+func (d *Driver) client() (*client, error) {
+	provider, err := d.configProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{}
+	if c.tenancyID, err = provider.TenancyOCID(); err != nil {
+		return nil, err
+	}
+	if c.compute, err = core.NewComputeClientWithConfigurationProvider(provider); err != nil {
+		return nil, err
+	}
+	if c.network, err = core.NewVirtualNetworkClientWithConfigurationProvider(provider); err != nil {
+		return nil, err
+	}
+	if c.identity, err = identity.NewIdentityClientWithConfigurationProvider(provider); err != nil {
+		return nil, err
+	}
+	if d.Region != "" {
+		c.compute.SetRegion(d.Region)
+		c.network.SetRegion(d.Region)
+		c.identity.SetRegion(d.Region)
+	}
+
+	return c, nil
+}
+
+// availabilityDomain resolves the availability domain given by full name or
+// by number to its full name.
+func (c *client) availabilityDomain(name string) (string, error) {
+	resp, err := c.identity.ListAvailabilityDomains(context.TODO(), identity.ListAvailabilityDomainsRequest{
+		CompartmentId: common.String(c.tenancyID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, ad := range resp.Items {
+		names = append(names, *ad.Name)
+	}
+	return matchAvailabilityDomain(names, name)
+}
+
+func matchAvailabilityDomain(names []string, name string) (string, error) {
+	suffix := strings.ToUpper(name)
+	if !strings.HasPrefix(suffix, "AD-") {
+		suffix = "AD-" + suffix
+	}
+
+	for _, n := range names {
+		if strings.EqualFold(n, name) || strings.HasSuffix(strings.ToUpper(n), "-"+suffix) {
+			return n, nil
+		}
+	}
+	return "", fmt.Errorf("availability domain %q not found, available: %s", name, strings.Join(names, ", "))
+}
+
+func (c *client) subnetID(subnetID, compartmentID, vcnName, subnetName string) (string, error) {
+	if subnetID != "" {
+		return subnetID, nil
+	}
+
+	vcns, err := c.network.ListVcns(context.TODO(), core.ListVcnsRequest{
+		CompartmentId: common.String(compartmentID),
+		DisplayName:   common.String(vcnName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(vcns.Items) != 1 {
+		return "", fmt.Errorf("expected one VCN named %q, found %d", vcnName, len(vcns.Items))
+	}
+
+	subnets, err := c.network.ListSubnets(context.TODO(), core.ListSubnetsRequest{
+		CompartmentId: common.String(compartmentID),
+		VcnId:         vcns.Items[0].Id,
+		DisplayName:   common.String(subnetName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(subnets.Items) != 1 {
+		return "", fmt.Errorf("expected one subnet named %q in VCN %q, found %d", subnetName, vcnName, len(subnets.Items))
+	}
+
+	return *subnets.Items[0].Id, nil
+}
+
+// imageID returns the latest platform image of the operating system that is
+// compatible with the shape, unless an image is given.
+func (c *client) imageID(imageID, compartmentID, os, version, shape string) (string, error) {
+	if imageID != "" {
+		return imageID, nil
+	}
+
+	resp, err := c.compute.ListImages(context.TODO(), core.ListImagesRequest{
+		CompartmentId:          common.String(compartmentID),
+		OperatingSystem:        common.String(os),
+		OperatingSystemVersion: common.String(version),
+		Shape:                  common.String(shape),
+		SortBy:                 core.ListImagesSortByTimecreated,
+		SortOrder:              core.ListImagesSortOrderDesc,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Items) == 0 {
+		return "", fmt.Errorf("no %s %s image found for shape %s", os, version, shape)
+	}
+
+	return *resp.Items[0].Id, nil
+}
+
+func (c *client) primaryVnic(compartmentID, instanceID string) (*core.Vnic, error) {
+	attachments, err := c.compute.ListVnicAttachments(context.TODO(), core.ListVnicAttachmentsRequest{
+		CompartmentId: common.String(compartmentID),
+		InstanceId:    common.String(instanceID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments.Items {
+		if attachment.LifecycleState != core.VnicAttachmentLifecycleStateAttached || attachment.VnicId == nil {
+			continue
+		}
+		resp, err := c.network.GetVnic(context.TODO(), core.GetVnicRequest{VnicId: attachment.VnicId})
+		if err != nil {
+			return nil, err
+		}
+		if resp.Vnic.IsPrimary != nil && *resp.Vnic.IsPrimary {
+			return &resp.Vnic, nil
+		}
+	}
+
+	return nil, errors.New("instance has no primary VNIC attached yet")
+}
@@ -0,0 +1,592 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	Region               string
+	TenancyID            string
+	UserID               string
+	Fingerprint          string
+	PrivateKeyPath       string
+	PrivateKeyContents   string `secret:"true"`
+	PrivateKeyPassphrase string `secret:"true"`
+	InstancePrincipals   bool
+	CompartmentID        string
+	AvailabilityDomain   string
+	Shape                string
+	OCPUs                float32
+	MemoryInGBs          int
+	ImageID              string
+	ImageOS              string
+	ImageOSVersion       string
+	BootVolumeSizeInGBs  int
+	SubnetID             string
+	VCN                  string
+	Subnet               string
+	NetworkCompartmentID string
+	PrivateIPOnly        bool
+	UserDataFile         string
+	InstanceID           string
+}
+
+const (
+	defaultShape          = "VM.Standard.E4.Flex"
+	defaultOCPUs          = "1"
+	defaultImageOS        = "Canonical Ubuntu"
+	defaultImageOSVersion = "22.04"
+	defaultSSHUser        = "ubuntu"
+	defaultSSHPort        = 22
+	minBootVolumeSize     = 50
+	instanceWaitAttempts  = 120
+	instanceWaitInterval  = 5 * time.Second
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "OCI_REGION",
+			Name:   "oci-region",
+			Usage:  "OCI region, e.g. us-ashburn-1",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_TENANCY_ID",
+			Name:   "oci-tenancy-id",
+			Usage:  "OCID of the tenancy",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_USER_ID",
+			Name:   "oci-user-id",
+			Usage:  "OCID of the user signing the API requests",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_FINGERPRINT",
+			Name:   "oci-fingerprint",
+			Usage:  "Fingerprint of the API signing key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_PRIVATE_KEY_PATH",
+			Name:   "oci-private-key-path",
+			Usage:  "Path to the API signing key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_PRIVATE_KEY_CONTENTS",
+			Name:   "oci-private-key-contents",
+			Usage:  "API signing key in PEM format, instead of --oci-private-key-path",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_PRIVATE_KEY_PASSPHRASE",
+			Name:   "oci-private-key-passphrase",
+			Usage:  "Passphrase of the API signing key",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "OCI_INSTANCE_PRINCIPALS",
+			Name:   "oci-instance-principals",
+			Usage:  "Authenticate as the OCI instance this runs on instead of with an API signing key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_COMPARTMENT_ID",
+			Name:   "oci-compartment-id",
+			Usage:  "OCID of the compartment to create the instance in",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_AVAILABILITY_DOMAIN",
+			Name:   "oci-availability-domain",
+			Usage:  "Availability domain, either its full name or its number (e.g. 1 or AD-1)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_SHAPE",
+			Name:   "oci-shape",
+			Usage:  "Instance shape",
+			Value:  defaultShape,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_OCPUS",
+			Name:   "oci-ocpus",
+			Usage:  "Number of OCPUs of a flexible shape",
+			Value:  defaultOCPUs,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "OCI_MEMORY_IN_GBS",
+			Name:   "oci-memory-in-gbs",
+			Usage:  "Memory in GB of a flexible shape (default is the shape's default for the OCPUs)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_IMAGE_ID",
+			Name:   "oci-image-id",
+			Usage:  "OCID of the image, replaces --oci-image-os and --oci-image-os-version",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_IMAGE_OS",
+			Name:   "oci-image-os",
+			Usage:  "Operating system of the platform image, the latest one compatible with the shape is used",
+			Value:  defaultImageOS,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_IMAGE_OS_VERSION",
+			Name:   "oci-image-os-version",
+			Usage:  "Operating system version of the platform image",
+			Value:  defaultImageOSVersion,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "OCI_BOOT_VOLUME_SIZE",
+			Name:   "oci-boot-volume-size",
+			Usage:  "Boot volume size in GB (default is the size of the image)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_SUBNET_ID",
+			Name:   "oci-subnet-id",
+			Usage:  "OCID of the subnet, replaces --oci-vcn and --oci-subnet",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_VCN",
+			Name:   "oci-vcn",
+			Usage:  "Display name of the VCN",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_SUBNET",
+			Name:   "oci-subnet",
+			Usage:  "Display name of the subnet in the VCN",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_NETWORK_COMPARTMENT_ID",
+			Name:   "oci-network-compartment-id",
+			Usage:  "OCID of the compartment of the VCN (default is --oci-compartment-id)",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "OCI_PRIVATE_IP",
+			Name:   "oci-private-ip",
+			Usage:  "Don't assign a public IP and connect to the private IP",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_USERDATA",
+			Name:   "oci-userdata",
+			Usage:  "Path to file with cloud-init user-data",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OCI_SSH_USER",
+			Name:   "oci-ssh-user",
+			Usage:  "SSH user",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "OCI_SSH_PORT",
+			Name:   "oci-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Shape:          defaultShape,
+		OCPUs:          1,
+		ImageOS:        defaultImageOS,
+		ImageOSVersion: defaultImageOSVersion,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "oci"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["oci-fingerprint"]; ok {
+		d.Fingerprint = driverOpts.String("oci-fingerprint")
+	}
+
+	if _, ok := driverOpts.Values["oci-private-key-contents"]; ok {
+		d.PrivateKeyContents = driverOpts.String("oci-private-key-contents")
+	}
+
+	if _, ok := driverOpts.Values["oci-private-key-passphrase"]; ok {
+		d.PrivateKeyPassphrase = driverOpts.String("oci-private-key-passphrase")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Region = flags.String("oci-region")
+	d.TenancyID = flags.String("oci-tenancy-id")
+	d.UserID = flags.String("oci-user-id")
+	d.Fingerprint = flags.String("oci-fingerprint")
+	d.PrivateKeyPath = flags.String("oci-private-key-path")
+	d.PrivateKeyContents = flags.String("oci-private-key-contents")
+	d.PrivateKeyPassphrase = flags.String("oci-private-key-passphrase")
+	d.InstancePrincipals = flags.Bool("oci-instance-principals")
+	d.CompartmentID = flags.String("oci-compartment-id")
+	d.AvailabilityDomain = flags.String("oci-availability-domain")
+	d.Shape = flags.String("oci-shape")
+	d.MemoryInGBs = flags.Int("oci-memory-in-gbs")
+	d.ImageID = flags.String("oci-image-id")
+	d.ImageOS = flags.String("oci-image-os")
+	d.ImageOSVersion = flags.String("oci-image-os-version")
+	d.BootVolumeSizeInGBs = flags.Int("oci-boot-volume-size")
+	d.SubnetID = flags.String("oci-subnet-id")
+	d.VCN = flags.String("oci-vcn")
+	d.Subnet = flags.String("oci-subnet")
+	d.NetworkCompartmentID = flags.String("oci-network-compartment-id")
+	d.PrivateIPOnly = flags.Bool("oci-private-ip")
+	d.UserDataFile = flags.String("oci-userdata")
+	d.SSHUser = flags.String("oci-ssh-user")
+	d.SSHPort = flags.Int("oci-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	ocpus, err := strconv.ParseFloat(flags.String("oci-ocpus"), 32)
+	if err != nil || ocpus <= 0 {
+		return fmt.Errorf("invalid --oci-ocpus %q, expected a positive number", flags.String("oci-ocpus"))
+	}
+	d.OCPUs = float32(ocpus)
+
+	if d.NetworkCompartmentID == "" {
+		d.NetworkCompartmentID = d.CompartmentID
+	}
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.CompartmentID == "" || d.AvailabilityDomain == "" {
+		return errors.New("oci driver requires the --oci-compartment-id and --oci-availability-domain options")
+	}
+
+	if !d.InstancePrincipals && d.UserID != "" {
+		if d.TenancyID == "" || d.Fingerprint == "" || d.Region == "" {
+			return errors.New("--oci-user-id requires --oci-tenancy-id, --oci-fingerprint and --oci-region")
+		}
+		if (d.PrivateKeyPath == "") == (d.PrivateKeyContents == "") {
+			return errors.New("--oci-user-id requires one of --oci-private-key-path or --oci-private-key-contents")
+		}
+	}
+
+	if d.SubnetID == "" && (d.VCN == "" || d.Subnet == "") {
+		return errors.New("oci driver requires either --oci-subnet-id or both --oci-vcn and --oci-subnet")
+	}
+
+	if d.MemoryInGBs < 0 {
+		return errors.New("--oci-memory-in-gbs must not be negative")
+	}
+	if d.BootVolumeSizeInGBs != 0 && d.BootVolumeSizeInGBs < minBootVolumeSize {
+		return fmt.Errorf("--oci-boot-volume-size must be at least %d GB", minBootVolumeSize)
+	}
+
+	return nil
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("user-data file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.availabilityDomain(d.AvailabilityDomain); err != nil {
+		return err
+	}
+	if _, err := c.subnetID(d.SubnetID, d.NetworkCompartmentID, d.VCN, d.Subnet); err != nil {
+		return err
+	}
+	if _, err := c.imageID(d.ImageID, d.CompartmentID, d.ImageOS, d.ImageOSVersion, d.Shape); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	var userData []byte
+	if d.UserDataFile != "" {
+		if userData, err = ioutil.ReadFile(d.UserDataFile); err != nil {
+			return err
+		}
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	ad, err := c.availabilityDomain(d.AvailabilityDomain)
+	if err != nil {
+		return err
+	}
+	subnetID, err := c.subnetID(d.SubnetID, d.NetworkCompartmentID, d.VCN, d.Subnet)
+	if err != nil {
+		return err
+	}
+	imageID, err := c.imageID(d.ImageID, d.CompartmentID, d.ImageOS, d.ImageOSVersion, d.Shape)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Launching instance %s (%s) in %s...", d.MachineName, d.Shape, ad)
+	resp, err := c.compute.LaunchInstance(context.TODO(), core.LaunchInstanceRequest{
+		LaunchInstanceDetails: d.launchDetails(ad, subnetID, imageID, string(publicKey), userData),
+	})
+	if err != nil {
+		return fmt.Errorf("error launching instance: %s", err)
+	}
+	d.InstanceID = *resp.Instance.Id
+
+	log.Infof("Waiting for instance %s to be running...", d.InstanceID)
+	if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		s, err := d.GetState()
+		if err != nil {
+			return false, err
+		}
+		if s == state.Error {
+			return false, fmt.Errorf("instance %s was terminated", d.InstanceID)
+		}
+		return s == state.Running, nil
+	}, instanceWaitAttempts, instanceWaitInterval); err != nil {
+		return err
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+func (d *Driver) launchDetails(ad, subnetID, imageID, publicKey string, userData []byte) core.LaunchInstanceDetails {
+	source := core.InstanceSourceViaImageDetails{ImageId: common.String(imageID)}
+	if d.BootVolumeSizeInGBs > 0 {
+		source.BootVolumeSizeInGBs = common.Int64(int64(d.BootVolumeSizeInGBs))
+	}
+
+	metadata := map[string]string{"ssh_authorized_keys": strings.TrimSpace(publicKey)}
+	if len(userData) > 0 {
+		metadata["user_data"] = base64.StdEncoding.EncodeToString(userData)
+	}
+
+	details := core.LaunchInstanceDetails{
+		AvailabilityDomain: common.String(ad),
+		CompartmentId:      common.String(d.CompartmentID),
+		DisplayName:        common.String(d.MachineName),
+		Shape:              common.String(d.Shape),
+		SourceDetails:      source,
+		Metadata:           metadata,
+		CreateVnicDetails: &core.CreateVnicDetails{
+			SubnetId:       common.String(subnetID),
+			AssignPublicIp: common.Bool(!d.PrivateIPOnly),
+		},
+		FreeformTags: map[string]string{"rancher-machine": d.MachineName},
+	}
+
+	// fixed shapes come with their own OCPUs and memory
+	if isFlexShape(d.Shape) {
+		details.ShapeConfig = &core.LaunchInstanceShapeConfigDetails{Ocpus: common.Float32(d.OCPUs)}
+		if d.MemoryInGBs > 0 {
+			details.ShapeConfig.MemoryInGBs = common.Float32(float32(d.MemoryInGBs))
+		}
+	}
+
+	return details
+}
+
+func isFlexShape(shape string) bool {
+	return strings.HasSuffix(shape, ".Flex")
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the public IP of the primary VNIC, or its private IP with
+// --oci-private-ip
+func (d *Driver) GetIP() (string, error) {
+	if d.InstanceID == "" {
+		return "", errors.New("instance has not been created")
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return "", err
+	}
+
+	vnic, err := c.primaryVnic(d.CompartmentID, d.InstanceID)
+	if err != nil {
+		return "", err
+	}
+
+	if d.PrivateIPOnly {
+		if vnic.PrivateIp == nil {
+			return "", errors.New("instance has no private IP")
+		}
+		return *vnic.PrivateIp, nil
+	}
+	if vnic.PublicIp == nil {
+		return "", errors.New("instance has no public IP")
+	}
+	return *vnic.PublicIp, nil
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	c, err := d.client()
+	if err != nil {
+		return state.Error, err
+	}
+
+	resp, err := c.compute.GetInstance(context.TODO(), core.GetInstanceRequest{InstanceId: common.String(d.InstanceID)})
+	if err != nil {
+		return state.Error, err
+	}
+	return instanceState(resp.Instance.LifecycleState), nil
+}
+
+func instanceState(s core.InstanceLifecycleStateEnum) state.State {
+	switch s {
+	case core.InstanceLifecycleStateProvisioning, core.InstanceLifecycleStateStarting:
+		return state.Starting
+	case core.InstanceLifecycleStateRunning, core.InstanceLifecycleStateCreatingImage, core.InstanceLifecycleStateMoving:
+		return state.Running
+	case core.InstanceLifecycleStateStopping:
+		return state.Stopping
+	case core.InstanceLifecycleStateStopped:
+		return state.Stopped
+	case core.InstanceLifecycleStateTerminating, core.InstanceLifecycleStateTerminated:
+		return state.Error
+	}
+	return state.None
+}
+
+func (d *Driver) instanceAction(action core.InstanceActionActionEnum) error {
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.compute.InstanceAction(context.TODO(), core.InstanceActionRequest{
+		InstanceId: common.String(d.InstanceID),
+		Action:     action,
+	})
+	return err
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Starting %s...", d.MachineName)
+	return d.instanceAction(core.InstanceActionActionStart)
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Stopping %s...", d.MachineName)
+	return d.instanceAction(core.InstanceActionActionSoftstop)
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Killing %s...", d.MachineName)
+	return d.instanceAction(core.InstanceActionActionStop)
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	log.Infof("Restarting %s...", d.MachineName)
+	return d.instanceAction(core.InstanceActionActionSoftreset)
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	if d.InstanceID == "" {
+		return nil
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Terminating instance %s...", d.InstanceID)
+	_, err = c.compute.TerminateInstance(context.TODO(), core.TerminateInstanceRequest{
+		InstanceId:         common.String(d.InstanceID),
+		PreserveBootVolume: common.Bool(false),
+	})
+	if serviceErr, ok := common.IsServiceError(err); ok && serviceErr.GetHTTPStatusCode() == 404 {
+		log.Infof("Instance %s doesn't exist, assuming it is already terminated", d.InstanceID)
+		return nil
+	}
+	return err
+}
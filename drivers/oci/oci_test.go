@@ -0,0 +1,126 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"oci-compartment-id":      "ocid1.compartment.oc1..aaa",
+			"oci-availability-domain": "1",
+			"oci-subnet-id":           "ocid1.subnet.oc1..aaa",
+			"oci-instance-principals": true,
+			"oci-ocpus":               "2",
+			"oci-memory-in-gbs":       32,
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+
+	d := driver.(*Driver)
+	assert.Equal(t, float32(2), d.OCPUs)
+	assert.Equal(t, "ocid1.compartment.oc1..aaa", d.NetworkCompartmentID)
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		return &Driver{
+			CompartmentID:      "ocid1.compartment.oc1..aaa",
+			AvailabilityDomain: "AD-1",
+			SubnetID:           "ocid1.subnet.oc1..aaa",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "config file auth", modify: func(d *Driver) {}},
+		{name: "vcn and subnet names", modify: func(d *Driver) { d.SubnetID, d.VCN, d.Subnet = "", "vcn", "subnet" }},
+		{name: "no subnet", modify: func(d *Driver) { d.SubnetID, d.VCN = "", "vcn" }, err: "oci driver requires either --oci-subnet-id or both --oci-vcn and --oci-subnet"},
+		{name: "incomplete API key", modify: func(d *Driver) { d.UserID = "ocid1.user.oc1..aaa" }, err: "--oci-user-id requires --oci-tenancy-id, --oci-fingerprint and --oci-region"},
+		{name: "API key", modify: func(d *Driver) {
+			d.UserID, d.TenancyID, d.Fingerprint, d.Region, d.PrivateKeyPath = "user", "tenancy", "aa:bb", "us-ashburn-1", "key.pem"
+		}},
+		{name: "both keys", modify: func(d *Driver) {
+			d.UserID, d.TenancyID, d.Fingerprint, d.Region, d.PrivateKeyPath, d.PrivateKeyContents = "user", "tenancy", "aa:bb", "us-ashburn-1", "key.pem", "PEM"
+		}, err: "--oci-user-id requires one of --oci-private-key-path or --oci-private-key-contents"},
+		{name: "small boot volume", modify: func(d *Driver) { d.BootVolumeSizeInGBs = 20 }, err: "--oci-boot-volume-size must be at least 50 GB"},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestMatchAvailabilityDomain(t *testing.T) {
+	names := []string{"Uocm:PHX-AD-1", "Uocm:PHX-AD-2", "Uocm:PHX-AD-3"}
+
+	for _, name := range []string{"2", "AD-2", "ad-2", "Uocm:PHX-AD-2"} {
+		ad, err := matchAvailabilityDomain(names, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "Uocm:PHX-AD-2", ad)
+	}
+
+	_, err := matchAvailabilityDomain(names, "4")
+	assert.Error(t, err)
+}
+
+func TestLaunchDetails(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.CompartmentID = "compartment"
+	d.OCPUs = 4
+	d.MemoryInGBs = 64
+	d.BootVolumeSizeInGBs = 100
+	d.PrivateIPOnly = true
+
+	details := d.launchDetails("AD-1", "subnet", "image", "ssh-rsa KEY\n", []byte("#cloud-config\n"))
+	assert.Equal(t, "AD-1", *details.AvailabilityDomain)
+	assert.Equal(t, float32(4), *details.ShapeConfig.Ocpus)
+	assert.Equal(t, float32(64), *details.ShapeConfig.MemoryInGBs)
+	assert.Equal(t, "subnet", *details.CreateVnicDetails.SubnetId)
+	assert.False(t, *details.CreateVnicDetails.AssignPublicIp)
+	assert.Equal(t, map[string]string{
+		"ssh_authorized_keys": "ssh-rsa KEY",
+		"user_data":           "I2Nsb3VkLWNvbmZpZwo=",
+	}, details.Metadata)
+
+	source := details.SourceDetails.(core.InstanceSourceViaImageDetails)
+	assert.Equal(t, "image", *source.ImageId)
+	assert.Equal(t, int64(100), *source.BootVolumeSizeInGBs)
+
+	d.Shape = "VM.Standard2.1"
+	d.BootVolumeSizeInGBs = 0
+	details = d.launchDetails("AD-1", "subnet", "image", "ssh-rsa KEY", nil)
+	assert.Nil(t, details.ShapeConfig)
+	assert.Nil(t, details.SourceDetails.(core.InstanceSourceViaImageDetails).BootVolumeSizeInGBs)
+	assert.NotContains(t, details.Metadata, "user_data")
+}
+
+func TestInstanceState(t *testing.T) {
+	assert.Equal(t, state.Starting, instanceState(core.InstanceLifecycleStateProvisioning))
+	assert.Equal(t, state.Running, instanceState(core.InstanceLifecycleStateRunning))
+	assert.Equal(t, state.Stopping, instanceState(core.InstanceLifecycleStateStopping))
+	assert.Equal(t, state.Stopped, instanceState(core.InstanceLifecycleStateStopped))
+	assert.Equal(t, state.Error, instanceState(core.InstanceLifecycleStateTerminated))
+}
@@ -0,0 +1,198 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kolo/xmlrpc"
+)
+
+// Client calls the OpenNebula XML-RPC API with a "user:password" session,
+// the password being a login token as well.
+type Client struct {
+	session string
+	rpc     *xmlrpc.Client
+}
+
+// VM is the part of the one.vm.info document the driver uses.
+type VM struct {
+	ID       int    `xml:"ID"`
+	Name     string `xml:"NAME"`
+	State    int    `xml:"STATE"`
+	LCMState int    `xml:"LCM_STATE"`
+	NICs     []struct {
+		IP  string `xml:"IP"`
+		IP6 string `xml:"IP6_GLOBAL"`
+	} `xml:"TEMPLATE>NIC"`
+}
+
+var kindNames = map[string]string{
+	"vn": "virtual network",
+}
+
+type poolElement struct {
+	ID   int    `xml:"ID"`
+	Name string `xml:"NAME"`
+}
+
+func NewClient(endpoint, user, password string) (*Client, error) {
+	rpc, err := xmlrpc.NewClient(endpoint, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{session: user + ":" + password, rpc: rpc}, nil
+}
+
+// call invokes an API method and returns the value of a successful
+// response, which OpenNebula wraps in [success, value, error code, ...].
+func (c *Client) call(method string, args ...interface{}) (interface{}, error) {
+	var result []interface{}
+	if err := c.rpc.Call(method, append([]interface{}{c.session}, args...), &result); err != nil {
+		return nil, fmt.Errorf("%s: %s", method, err)
+	}
+	if len(result) < 2 {
+		return nil, fmt.Errorf("%s: malformed response", method)
+	}
+	if ok, _ := result[0].(bool); !ok {
+		return nil, fmt.Errorf("%s: %v", method, result[1])
+	}
+	return result[1], nil
+}
+
+func (c *Client) callID(method string, args ...interface{}) (int, error) {
+	value, err := c.call(method, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: unexpected response %v", method, value)
+	}
+	return int(id), nil
+}
+
+// lookup resolves the name of a template, image, virtual network or
+// datastore to its ID. A number is returned as is.
+func (c *Client) lookup(kind, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	args := []interface{}{-2, -1, -1}
+	if kind == "datastore" {
+		// datastores are not owned by users, so their pool has no filter
+		args = nil
+	}
+	value, err := c.call("one."+kind+"pool.info", args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var pool struct {
+		Elements []poolElement `xml:",any"`
+	}
+	body, _ := value.(string)
+	if err := xml.Unmarshal([]byte(body), &pool); err != nil {
+		return 0, fmt.Errorf("cannot parse %s pool: %s", kind, err)
+	}
+
+	label := kind
+	if n, ok := kindNames[kind]; ok {
+		label = n
+	}
+
+	id := -1
+	for _, e := range pool.Elements {
+		if e.Name != name {
+			continue
+		}
+		if id >= 0 {
+			return 0, fmt.Errorf("%s name %q is ambiguous, use its ID", label, name)
+		}
+		id = e.ID
+	}
+	if id < 0 {
+		return 0, fmt.Errorf("%s %q not found", label, name)
+	}
+	return id, nil
+}
+
+func (c *Client) TemplateID(name string) (int, error) {
+	return c.lookup("template", name)
+}
+
+func (c *Client) ImageID(name string) (int, error) {
+	return c.lookup("image", name)
+}
+
+func (c *Client) NetworkID(name string) (int, error) {
+	return c.lookup("vn", name)
+}
+
+func (c *Client) DatastoreID(name string) (int, error) {
+	return c.lookup("datastore", name)
+}
+
+// InstantiateTemplate creates a VM from a template, merging the extra
+// template into it.
+func (c *Client) InstantiateTemplate(templateID int, name, extraTemplate string) (int, error) {
+	return c.callID("one.template.instantiate", templateID, name, false, extraTemplate, false)
+}
+
+// AllocateVM creates a VM from a complete template.
+func (c *Client) AllocateVM(template string) (int, error) {
+	return c.callID("one.vm.allocate", template, false)
+}
+
+func (c *Client) GetVM(id int) (*VM, error) {
+	value, err := c.call("one.vm.info", id)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _ := value.(string)
+	vm := &VM{}
+	if err := xml.Unmarshal([]byte(body), vm); err != nil {
+		return nil, fmt.Errorf("cannot parse VM %d: %s", id, err)
+	}
+	return vm, nil
+}
+
+// VMAction runs a lifecycle action, e.g. resume, poweroff or terminate-hard.
+func (c *Client) VMAction(id int, action string) error {
+	_, err := c.call("one.vm.action", action, id)
+	return err
+}
+
+// IP returns the address of the first NIC, preferring IPv4.
+func (vm *VM) IP() (string, error) {
+	for _, nic := range vm.NICs {
+		if nic.IP != "" {
+			return nic.IP, nil
+		}
+		if nic.IP6 != "" {
+			return nic.IP6, nil
+		}
+	}
+	return "", errors.New("VM has no IP address")
+}
+
+// templateValue quotes a value for an OpenNebula template.
+func templateValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// templateVector formats a vector attribute such as CONTEXT or NIC, keeping
+// the order of the keys.
+func templateVector(name string, pairs ...string) string {
+	var attrs []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		attrs = append(attrs, pairs[i]+"="+templateValue(pairs[i+1]))
+	}
+	return name + "=[\n  " + strings.Join(attrs, ",\n  ") + " ]\n"
+}
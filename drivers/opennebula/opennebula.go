@@ -0,0 +1,534 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	Endpoint     string
+	User         string
+	Password     string `secret:"true"`
+	Template     string
+	Image        string
+	Network      string
+	Datastore    string
+	CPU          string
+	VCPU         int
+	Memory       int
+	DiskSize     int
+	UserDataFile string
+	VMID         string
+}
+
+const (
+	defaultEndpoint  = "http://localhost:2633/RPC2"
+	defaultCPU       = "1"
+	defaultVCPU      = 1
+	defaultMemory    = 1024
+	defaultSSHUser   = "root"
+	defaultSSHPort   = 22
+	vmWaitAttempts   = 120
+	vmWaitInterval   = 5 * time.Second
+	terminateAction  = "terminate-hard"
+	vmStateActive    = 3
+	vmStateDone      = 6
+	lcmStateRunning  = 3
+	lcmStateUnknown  = 16
+	lcmStateShutdown = 12
+	// BOOT_FAILURE to PROLOG_UNDEPLOY_FAILURE
+	lcmFirstFailure = 36
+	lcmLastFailure  = 48
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "ONE_XMLRPC",
+			Name:   "opennebula-xmlrpcurl",
+			Usage:  "XML-RPC endpoint of OpenNebula",
+			Value:  defaultEndpoint,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_USER",
+			Name:   "opennebula-user",
+			Usage:  "OpenNebula user",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_PASSWORD",
+			Name:   "opennebula-password",
+			Usage:  "Password or login token of the OpenNebula user",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_TEMPLATE",
+			Name:   "opennebula-template",
+			Usage:  "Name or ID of the VM template to instantiate",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_IMAGE",
+			Name:   "opennebula-image",
+			Usage:  "Name or ID of the image to boot, instead of a template",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_NETWORK",
+			Name:   "opennebula-network",
+			Usage:  "Name or ID of the virtual network, replacing the NICs of the template",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_DATASTORE",
+			Name:   "opennebula-datastore",
+			Usage:  "Name or ID of the system datastore to deploy to",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_CPU",
+			Name:   "opennebula-cpu",
+			Usage:  "Physical CPU share of the VM, e.g. 0.5 (default is the template's, or 1)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ONE_VCPU",
+			Name:   "opennebula-vcpu",
+			Usage:  "Virtual CPUs of the VM (default is the template's, or 1)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ONE_MEMORY",
+			Name:   "opennebula-memory",
+			Usage:  "Memory of the VM in MB (default is the template's, or 1024)",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ONE_DISK_SIZE",
+			Name:   "opennebula-disk-size",
+			Usage:  "Size of the disk in MB with --opennebula-image (default is the size of the image)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_CLOUD_INIT",
+			Name:   "opennebula-cloud-init",
+			Usage:  "Path to file with cloud-init user-data, passed through the context",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "ONE_SSH_USER",
+			Name:   "opennebula-ssh-user",
+			Usage:  "SSH user, created by the contextualization unless it is root",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "ONE_SSH_PORT",
+			Name:   "opennebula-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Endpoint: defaultEndpoint,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "opennebula"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["opennebula-password"]; ok {
+		d.Password = driverOpts.String("opennebula-password")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Endpoint = flags.String("opennebula-xmlrpcurl")
+	d.User = flags.String("opennebula-user")
+	d.Password = flags.String("opennebula-password")
+	d.Template = flags.String("opennebula-template")
+	d.Image = flags.String("opennebula-image")
+	d.Network = flags.String("opennebula-network")
+	d.Datastore = flags.String("opennebula-datastore")
+	d.CPU = flags.String("opennebula-cpu")
+	d.VCPU = flags.Int("opennebula-vcpu")
+	d.Memory = flags.Int("opennebula-memory")
+	d.DiskSize = flags.Int("opennebula-disk-size")
+	d.UserDataFile = flags.String("opennebula-cloud-init")
+	d.SSHUser = flags.String("opennebula-ssh-user")
+	d.SSHPort = flags.Int("opennebula-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.User == "" || d.Password == "" {
+		return errors.New("opennebula driver requires the --opennebula-user and --opennebula-password options")
+	}
+	if (d.Template == "") == (d.Image == "") {
+		return errors.New("opennebula driver requires exactly one of --opennebula-template or --opennebula-image")
+	}
+	if d.Image != "" && d.Network == "" {
+		return errors.New("opennebula driver requires --opennebula-network with --opennebula-image")
+	}
+	if d.DiskSize != 0 && d.Image == "" {
+		return errors.New("--opennebula-disk-size requires --opennebula-image")
+	}
+	if d.CPU != "" {
+		if cpu, err := strconv.ParseFloat(d.CPU, 64); err != nil || cpu <= 0 {
+			return fmt.Errorf("--opennebula-cpu must be a positive number, not %q", d.CPU)
+		}
+	}
+	if d.VCPU < 0 || d.Memory < 0 || d.DiskSize < 0 {
+		return errors.New("--opennebula-vcpu, --opennebula-memory and --opennebula-disk-size cannot be negative")
+	}
+	return nil
+}
+
+func (d *Driver) client() (*Client, error) {
+	return NewClient(d.Endpoint, d.User, d.Password)
+}
+
+func (d *Driver) vmID() (int, error) {
+	if d.VMID == "" {
+		return 0, errors.New("VM has not been created")
+	}
+	return strconv.Atoi(d.VMID)
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("cloud-init file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+	_, err = d.resolve(c)
+	return err
+}
+
+// resources holds the IDs of the named resources, -1 when unset.
+type resources struct {
+	template, image, network, datastore int
+}
+
+func (d *Driver) resolve(c *Client) (*resources, error) {
+	r := &resources{template: -1, image: -1, network: -1, datastore: -1}
+
+	var err error
+	if d.Template != "" {
+		if r.template, err = c.TemplateID(d.Template); err != nil {
+			return nil, err
+		}
+	}
+	if d.Image != "" {
+		if r.image, err = c.ImageID(d.Image); err != nil {
+			return nil, err
+		}
+	}
+	if d.Network != "" {
+		if r.network, err = c.NetworkID(d.Network); err != nil {
+			return nil, err
+		}
+	}
+	if d.Datastore != "" {
+		if r.datastore, err = c.DatastoreID(d.Datastore); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	var userData []byte
+	if d.UserDataFile != "" {
+		if userData, err = ioutil.ReadFile(d.UserDataFile); err != nil {
+			return err
+		}
+	}
+
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+	r, err := d.resolve(c)
+	if err != nil {
+		return err
+	}
+
+	template := d.vmTemplate(r, strings.TrimSpace(string(publicKey)), userData)
+
+	var id int
+	if r.template >= 0 {
+		log.Infof("Instantiating template %s as %s...", d.Template, d.MachineName)
+		id, err = c.InstantiateTemplate(r.template, d.MachineName, template)
+	} else {
+		log.Infof("Creating VM %s from image %s...", d.MachineName, d.Image)
+		id, err = c.AllocateVM(template)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating VM: %s", err)
+	}
+	d.VMID = strconv.Itoa(id)
+
+	log.Infof("Waiting for VM %d to be running...", id)
+	if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		vm, err := c.GetVM(id)
+		if err != nil {
+			return false, err
+		}
+		if s := vmState(vm); s == state.Error {
+			return false, fmt.Errorf("VM %d failed to start (state %d, LCM state %d)", id, vm.State, vm.LCMState)
+		}
+		return vm.State == vmStateActive && vm.LCMState == lcmStateRunning, nil
+	}, vmWaitAttempts, vmWaitInterval); err != nil {
+		return err
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+// vmTemplate builds the extra template merged into the instantiated
+// template, or the complete template of a VM booting the image.
+func (d *Driver) vmTemplate(r *resources, publicKey string, userData []byte) string {
+	var b strings.Builder
+
+	cpu, vcpu, memory := d.CPU, d.VCPU, d.Memory
+	if r.template < 0 {
+		b.WriteString("NAME=" + templateValue(d.MachineName) + "\n")
+		if cpu == "" {
+			cpu = defaultCPU
+		}
+		if vcpu == 0 {
+			vcpu = defaultVCPU
+		}
+		if memory == 0 {
+			memory = defaultMemory
+		}
+
+		disk := []string{"IMAGE_ID", strconv.Itoa(r.image)}
+		if d.DiskSize > 0 {
+			disk = append(disk, "SIZE", strconv.Itoa(d.DiskSize))
+		}
+		b.WriteString(templateVector("DISK", disk...))
+		b.WriteString(templateVector("GRAPHICS", "TYPE", "VNC", "LISTEN", "0.0.0.0"))
+	}
+	if cpu != "" {
+		b.WriteString("CPU=" + templateValue(cpu) + "\n")
+	}
+	if vcpu > 0 {
+		b.WriteString("VCPU=" + templateValue(strconv.Itoa(vcpu)) + "\n")
+	}
+	if memory > 0 {
+		b.WriteString("MEMORY=" + templateValue(strconv.Itoa(memory)) + "\n")
+	}
+	if r.network >= 0 {
+		b.WriteString(templateVector("NIC", "NETWORK_ID", strconv.Itoa(r.network)))
+	}
+	if r.datastore >= 0 {
+		b.WriteString("SCHED_DS_REQUIREMENTS=" + templateValue(fmt.Sprintf(`ID="%d"`, r.datastore)) + "\n")
+	}
+
+	context := []string{
+		"NETWORK", "YES",
+		"SET_HOSTNAME", d.MachineName,
+		"SSH_PUBLIC_KEY", publicKey,
+	}
+	if d.SSHUser != "" && d.SSHUser != "root" {
+		context = append(context, "USERNAME", d.SSHUser)
+	}
+	if len(userData) > 0 {
+		context = append(context,
+			"USER_DATA", base64.StdEncoding.EncodeToString(userData),
+			"USER_DATA_ENCODING", "base64")
+	}
+	b.WriteString(templateVector("CONTEXT", context...))
+
+	return b.String()
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the IP of the first NIC of the VM
+func (d *Driver) GetIP() (string, error) {
+	vm, err := d.getVM()
+	if err != nil {
+		return "", err
+	}
+	return vm.IP()
+}
+
+func (d *Driver) getVM() (*VM, error) {
+	id, err := d.vmID()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetVM(id)
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	vm, err := d.getVM()
+	if err != nil {
+		return state.Error, err
+	}
+	return vmState(vm), nil
+}
+
+func vmState(vm *VM) state.State {
+	switch vm.State {
+	case 0, 1, 2, 10: // INIT, PENDING, HOLD, CLONING
+		return state.Starting
+	case vmStateActive:
+		switch vm.LCMState {
+		case lcmStateRunning:
+			return state.Running
+		case lcmStateShutdown, 18: // SHUTDOWN, SHUTDOWN_POWEROFF
+			return state.Stopping
+		case lcmStateUnknown:
+			return state.Error
+		}
+		if vm.LCMState >= lcmFirstFailure && vm.LCMState <= lcmLastFailure {
+			return state.Error
+		}
+		return state.Starting
+	case 4, 8, 9: // STOPPED, POWEROFF, UNDEPLOYED
+		return state.Stopped
+	case 5: // SUSPENDED
+		return state.Paused
+	case vmStateDone, 7, 11: // DONE, FAILED, CLONING_FAILURE
+		return state.Error
+	}
+	return state.None
+}
+
+func (d *Driver) action(action string) error {
+	id, err := d.vmID()
+	if err != nil {
+		return err
+	}
+	c, err := d.client()
+	if err != nil {
+		return err
+	}
+	return c.VMAction(id, action)
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Starting %s...", d.MachineName)
+	return d.action("resume")
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Stopping %s...", d.MachineName)
+	return d.action("poweroff")
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Killing %s...", d.MachineName)
+	return d.action("poweroff-hard")
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	log.Infof("Restarting %s...", d.MachineName)
+	return d.action("reboot")
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	if d.VMID == "" {
+		return nil
+	}
+
+	vm, err := d.getVM()
+	if err != nil {
+		return err
+	}
+	if vm.State == vmStateDone {
+		return nil
+	}
+
+	log.Infof("Terminating VM %s...", d.VMID)
+	return d.action(terminateAction)
+}
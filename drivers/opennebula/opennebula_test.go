@@ -0,0 +1,161 @@
+package opennebula
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"opennebula-user":     "oneadmin",
+			"opennebula-password": "secret",
+			"opennebula-template": "ubuntu",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		d := NewDriver("default", "path").(*Driver)
+		d.User, d.Password, d.Template = "oneadmin", "secret", "ubuntu"
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "template", modify: func(d *Driver) {}},
+		{name: "image", modify: func(d *Driver) { d.Template, d.Image, d.Network, d.DiskSize = "", "ubuntu", "public", 20480 }},
+		{name: "no password", modify: func(d *Driver) { d.Password = "" }, err: "opennebula driver requires the --opennebula-user and --opennebula-password options"},
+		{name: "template and image", modify: func(d *Driver) { d.Image = "ubuntu" }, err: "opennebula driver requires exactly one of --opennebula-template or --opennebula-image"},
+		{name: "image without network", modify: func(d *Driver) { d.Template, d.Image = "", "ubuntu" }, err: "opennebula driver requires --opennebula-network with --opennebula-image"},
+		{name: "disk size with template", modify: func(d *Driver) { d.DiskSize = 20480 }, err: "--opennebula-disk-size requires --opennebula-image"},
+		{name: "cpu", modify: func(d *Driver) { d.CPU = "half" }, err: `--opennebula-cpu must be a positive number, not "half"`},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestVMTemplate(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.Memory = 2048
+
+	template := d.vmTemplate(&resources{template: 4, image: -1, network: -1, datastore: 100}, "ssh-rsa KEY", nil)
+	assert.Equal(t, `MEMORY="2048"
+SCHED_DS_REQUIREMENTS="ID=\"100\""
+CONTEXT=[
+  NETWORK="YES",
+  SET_HOSTNAME="default",
+  SSH_PUBLIC_KEY="ssh-rsa KEY" ]
+`, template)
+
+	d.SSHUser = "docker"
+	d.DiskSize = 20480
+	template = d.vmTemplate(&resources{template: -1, image: 7, network: 2, datastore: -1}, "ssh-rsa KEY", []byte("#cloud-config\n"))
+	assert.Equal(t, `NAME="default"
+DISK=[
+  IMAGE_ID="7",
+  SIZE="20480" ]
+GRAPHICS=[
+  TYPE="VNC",
+  LISTEN="0.0.0.0" ]
+CPU="1"
+VCPU="1"
+MEMORY="2048"
+NIC=[
+  NETWORK_ID="2" ]
+CONTEXT=[
+  NETWORK="YES",
+  SET_HOSTNAME="default",
+  SSH_PUBLIC_KEY="ssh-rsa KEY",
+  USERNAME="docker",
+  USER_DATA="I2Nsb3VkLWNvbmZpZwo=",
+  USER_DATA_ENCODING="base64" ]
+`, template)
+}
+
+func TestVMState(t *testing.T) {
+	assert.Equal(t, state.Starting, vmState(&VM{State: 1}))
+	assert.Equal(t, state.Starting, vmState(&VM{State: 3, LCMState: 2}))
+	assert.Equal(t, state.Running, vmState(&VM{State: 3, LCMState: 3}))
+	assert.Equal(t, state.Stopping, vmState(&VM{State: 3, LCMState: 18}))
+	assert.Equal(t, state.Error, vmState(&VM{State: 3, LCMState: 36}))
+	assert.Equal(t, state.Stopped, vmState(&VM{State: 8}))
+	assert.Equal(t, state.Error, vmState(&VM{State: 6}))
+}
+
+func xmlrpcResponse(values ...string) string {
+	return `<?xml version="1.0"?><methodResponse><params><param><value><array><data>` +
+		strings.Join(values, "") + `</data></array></value></param></params></methodResponse>`
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Contains(t, string(body), "<string>oneadmin:secret</string>")
+
+		switch {
+		case strings.Contains(string(body), "one.vnpool.info"):
+			pool := `<VNET_POOL><VNET><ID>0</ID><NAME>private</NAME></VNET><VNET><ID>2</ID><NAME>public</NAME></VNET></VNET_POOL>`
+			fmt.Fprint(w, xmlrpcResponse("<value><boolean>1</boolean></value>", "<value><string>"+html.EscapeString(pool)+"</string></value>"))
+		case strings.Contains(string(body), "one.vm.info"):
+			vm := `<VM><ID>12</ID><NAME>default</NAME><STATE>3</STATE><LCM_STATE>3</LCM_STATE><TEMPLATE><NIC><IP>10.0.0.12</IP></NIC></TEMPLATE></VM>`
+			fmt.Fprint(w, xmlrpcResponse("<value><boolean>1</boolean></value>", "<value><string>"+html.EscapeString(vm)+"</string></value>"))
+		default:
+			fmt.Fprint(w, xmlrpcResponse("<value><boolean>0</boolean></value>", "<value><string>[one.vm.action] User couldn't be authenticated, aborting call.</string></value>", "<value><i4>256</i4></value>"))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "oneadmin", "secret")
+	assert.NoError(t, err)
+
+	id, err := c.NetworkID("public")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+
+	_, err = c.NetworkID("dmz")
+	assert.EqualError(t, err, `virtual network "dmz" not found`)
+
+	id, err = c.NetworkID("5")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, id)
+
+	vm, err := c.GetVM(12)
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, vmState(vm))
+	ip, err := vm.IP()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.12", ip)
+
+	assert.EqualError(t, c.VMAction(12, "resume"), "one.vm.action: [one.vm.action] User couldn't be authenticated, aborting call.")
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
@@ -14,14 +15,18 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
 	compute_ips "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/external"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	"github.com/gophercloud/gophercloud/pagination"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnutils"
@@ -48,11 +53,14 @@ type Client interface {
 	GetNetworkID(d *Driver) (string, error)
 	GetFlavorID(d *Driver) (string, error)
 	GetImageID(d *Driver) (string, error)
+	GetServerGroupID(d *Driver) (string, error)
+	CreateServerGroup(d *Driver) (string, error)
 	AssignFloatingIP(d *Driver, floatingIP *FloatingIP) error
 	DeleteFloatingIP(d *Driver, floatingIP *FloatingIP) error
 	GetFloatingIPs(d *Driver) ([]FloatingIP, error)
 	GetFloatingIP(d *Driver, ip string) (*FloatingIP, error)
 	GetFloatingIPPoolID(d *Driver) (string, error)
+	GetFloatingIPSubnetID(d *Driver) (string, error)
 	GetInstancePortID(d *Driver) (string, error)
 	VolumeCreate(d *Driver) (string, error)
 	WaitForVolumeStatus(d *Driver, status string) error
@@ -95,6 +103,13 @@ func (c *GenericClient) CreateInstance(d *Driver) (string, error) {
 		KeyName:           d.KeyPairName,
 	}
 
+	if d.ServerGroupId != "" {
+		serverOpts = &schedulerhints.CreateOptsExt{
+			CreateOptsBuilder: serverOpts,
+			SchedulerHints:    schedulerhints.SchedulerHints{Group: d.ServerGroupId},
+		}
+	}
+
 	log.Info("Creating machine...")
 
 	var server *servers.Server
@@ -104,7 +119,7 @@ func (c *GenericClient) CreateInstance(d *Driver) (string, error) {
 		blockDevices := []bootfromvolume.BlockDevice{
 			bootfromvolume.BlockDevice{
 				BootIndex:           0,
-				DeleteOnTermination: true,
+				DeleteOnTermination: !d.KeepBootVolume,
 				DestinationType:     bootfromvolume.DestinationVolume,
 				SourceType:          bootfromvolume.SourceImage,
 				UUID:                d.ImageId,
@@ -295,8 +310,100 @@ func (c *GenericClient) GetNetworkID(d *Driver) (string, error) {
 	return c.getNetworkID(d, d.NetworkName)
 }
 
+func (c *GenericClient) GetServerGroupID(d *Driver) (string, error) {
+	pager := servergroups.List(c.Compute)
+	groupID := ""
+
+	err := pager.EachPage(func(page pagination.Page) (bool, error) {
+		groupList, err := servergroups.ExtractServerGroups(page)
+		if err != nil {
+			return false, err
+		}
+
+		for _, g := range groupList {
+			if g.Name == d.ServerGroupName {
+				groupID = g.ID
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return groupID, err
+}
+
+func (c *GenericClient) CreateServerGroup(d *Driver) (string, error) {
+	compute := c.Compute
+	if strings.HasPrefix(d.ServerGroupPolicy, "soft-") {
+		// soft policies were introduced with compute microversion 2.15
+		sc := *c.Compute
+		sc.Microversion = "2.15"
+		compute = &sc
+	}
+
+	group, err := servergroups.Create(compute, servergroups.CreateOpts{
+		Name:     d.ServerGroupName,
+		Policies: []string{d.ServerGroupPolicy},
+	}).Extract()
+	if err != nil {
+		return "", err
+	}
+	return group.ID, nil
+}
+
+// GetFloatingIPPoolID returns the ID of the external network given by name or
+// ID as floating IP pool. Tenant networks of the same name are ignored.
 func (c *GenericClient) GetFloatingIPPoolID(d *Driver) (string, error) {
-	return c.getNetworkID(d, d.FloatingIpPool)
+	isExternal := true
+	opts := external.ListOptsExt{
+		ListOptsBuilder: networks.ListOpts{},
+		External:        &isExternal,
+	}
+	networkID := ""
+
+	err := networks.List(c.Network, opts).EachPage(func(page pagination.Page) (bool, error) {
+		networkList, err := networks.ExtractNetworks(page)
+		if err != nil {
+			return false, err
+		}
+
+		for _, n := range networkList {
+			if n.Name == d.FloatingIpPool || n.ID == d.FloatingIpPool {
+				networkID = n.ID
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return networkID, err
+}
+
+// GetFloatingIPSubnetID returns the ID of the subnet given by name or ID of
+// the floating IP pool, to get floating IPs from a given subnet of external
+// networks with several, e.g. dual-stack ones.
+func (c *GenericClient) GetFloatingIPSubnetID(d *Driver) (string, error) {
+	subnetID := ""
+
+	err := subnets.List(c.Network, subnets.ListOpts{NetworkID: d.FloatingIpPoolId}).EachPage(func(page pagination.Page) (bool, error) {
+		subnetList, err := subnets.ExtractSubnets(page)
+		if err != nil {
+			return false, err
+		}
+
+		for _, s := range subnetList {
+			if s.Name == d.FloatingIpSubnet || s.ID == d.FloatingIpSubnet {
+				subnetID = s.ID
+				return false, nil
+			}
+		}
+
+		return true, nil
+	})
+
+	return subnetID, err
 }
 
 func (c *GenericClient) getNetworkID(d *Driver, networkName string) (string, error) {
@@ -433,6 +540,7 @@ func (c *GenericClient) assignNeutronFloatingIP(d *Driver, floatingIP *FloatingI
 	if floatingIP.Id == "" {
 		f, err := floatingips.Create(c.Network, floatingips.CreateOpts{
 			FloatingNetworkID: d.FloatingIpPoolId,
+			SubnetID:          d.FloatingIpSubnetId,
 			PortID:            portID,
 		}).Extract()
 		if err != nil {
@@ -644,10 +752,13 @@ func (c *GenericClient) getEndpointType(d *Driver) gophercloud.Availability {
 
 func (c *GenericClient) Authenticate(d *Driver) error {
 	if c.Provider != nil {
+		// the calls are sent with the context of the operation in progress
+		c.Provider.Context = d.Context()
 		return nil
 	}
 
 	log.Debug("Authenticating...", map[string]interface{}{
+		"Cloud":                     d.Cloud,
 		"AuthUrl":                   d.AuthUrl,
 		"Insecure":                  d.Insecure,
 		"CaCert":                    d.CaCert,
@@ -679,6 +790,7 @@ func (c *GenericClient) Authenticate(d *Driver) error {
 	}
 
 	c.Provider = provider
+	c.Provider.Context = d.Context()
 
 	c.Provider.UserAgent.Prepend(fmt.Sprintf("docker-machine/v%d", version.APIVersion))
 
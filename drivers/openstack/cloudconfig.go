@@ -0,0 +1,86 @@
+package openstack
+
+import (
+	"strings"
+
+	"github.com/gophercloud/utils/openstack/clientconfig"
+)
+
+// getCloud loads the entry of the configured cloud from clouds.yaml, which is
+// looked up in OS_CLIENT_CONFIG_FILE, the current directory,
+// ~/.config/openstack and /etc/openstack. Credentials from secure.yaml are
+// merged in.
+func (d *Driver) getCloud() (*clientconfig.Cloud, error) {
+	return clientconfig.GetCloudFromYAML(&clientconfig.ClientOpts{
+		// this is needed to disable the OS_CLOUD env override
+		EnvPrefix: "_",
+		Cloud:     d.Cloud,
+	})
+}
+
+// applyCloudConfig fills the connection settings that were not given on the
+// command line from the clouds.yaml entry. Credentials are not copied, they
+// are read from clouds.yaml every time the driver authenticates so that they
+// are not stored in the machine config.
+func (d *Driver) applyCloudConfig() error {
+	cloud, err := d.getCloud()
+	if err != nil {
+		return err
+	}
+
+	if d.AuthUrl == "" && cloud.AuthInfo != nil {
+		d.AuthUrl = cloud.AuthInfo.AuthURL
+	}
+	if d.Region == "" {
+		d.Region = cloud.RegionName
+	}
+	if d.EndpointType == "" && cloud.EndpointType != "" {
+		d.EndpointType = endpointTypeFromInterface(cloud.EndpointType)
+	}
+	if d.CaCert == "" {
+		d.CaCert = cloud.CACertFile
+	}
+	if cloud.Verify != nil && !*cloud.Verify {
+		d.Insecure = true
+	}
+
+	return nil
+}
+
+// endpointTypeFromInterface converts the interface names used in clouds.yaml
+// (public, internal, admin) to the endpoint types of the driver.
+func endpointTypeFromInterface(iface string) string {
+	if strings.HasSuffix(iface, "URL") {
+		return iface
+	}
+	return iface + "URL"
+}
+
+// mergeAuthInfo overrides the auth settings of a clouds.yaml entry with the
+// ones given on the command line.
+func (d *Driver) mergeAuthInfo(auth *clientconfig.AuthInfo) *clientconfig.AuthInfo {
+	merged := *auth
+
+	override := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	override(&merged.AuthURL, d.AuthUrl)
+	override(&merged.UserID, d.UserId)
+	override(&merged.Username, d.Username)
+	override(&merged.Password, d.Password)
+	override(&merged.ProjectID, d.TenantId)
+	override(&merged.ProjectName, d.TenantName)
+	override(&merged.DomainID, d.DomainId)
+	override(&merged.DomainName, d.DomainName)
+	override(&merged.ProjectDomainID, d.TenantDomainId)
+	override(&merged.ProjectDomainName, d.TenantDomainName)
+	override(&merged.UserDomainID, d.UserDomainId)
+	override(&merged.UserDomainName, d.UserDomainName)
+	override(&merged.ApplicationCredentialID, d.ApplicationCredentialId)
+	override(&merged.ApplicationCredentialName, d.ApplicationCredentialName)
+	override(&merged.ApplicationCredentialSecret, d.ApplicationCredentialSecret)
+
+	return &merged
+}
@@ -1,6 +1,7 @@
 package openstack
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -25,6 +26,7 @@ import (
 
 type Driver struct {
 	*drivers.BaseDriver
+	Cloud                       string
 	AuthUrl                     string
 	ActiveTimeout               int
 	Insecure                    bool
@@ -33,16 +35,16 @@ type Driver struct {
 	DomainName                  string
 	UserId                      string
 	Username                    string
-	Password                    string
+	Password                    string `secret:"true"`
 	TenantName                  string
 	TenantId                    string
 	TenantDomainName            string
 	TenantDomainId              string
 	UserDomainName              string
 	UserDomainId                string
-	ApplicationCredentialId     string
-	ApplicationCredentialName   string
-	ApplicationCredentialSecret string
+	ApplicationCredentialId     string `secret:"false"`
+	ApplicationCredentialName   string `secret:"false"`
+	ApplicationCredentialSecret string `secret:"true"`
 	Region                      string
 	AvailabilityZone            string
 	EndpointType                string
@@ -60,9 +62,16 @@ type Driver struct {
 	FloatingIpPool              string
 	ComputeNetwork              bool
 	FloatingIpPoolId            string
+	FloatingIpSubnet            string
+	FloatingIpSubnetId          string
+	FloatingIpSkipRoutable      bool
 	IpVersion                   int
 	ConfigDrive                 bool
 	BootFromVolume              bool
+	KeepBootVolume              bool
+	ServerGroupName             string
+	ServerGroupPolicy           string
+	ServerGroupId               string
 	VolumeName                  string
 	VolumeDevicePath            string
 	VolumeId                    string
@@ -74,13 +83,22 @@ type Driver struct {
 }
 
 const (
-	defaultSSHUser       = "root"
-	defaultSSHPort       = 22
-	defaultActiveTimeout = 200
+	defaultSSHUser           = "root"
+	defaultSSHPort           = 22
+	defaultActiveTimeout     = 200
+	defaultServerGroupPolicy = "anti-affinity"
 )
 
+var serverGroupPolicies = []string{"affinity", "anti-affinity", "soft-affinity", "soft-anti-affinity"}
+
 func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "OS_CLOUD",
+			Name:   "openstack-cloud",
+			Usage:  "OpenStack cloud entry in clouds.yaml to read the connection settings and credentials from",
+			Value:  "",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "OS_AUTH_URL",
 			Name:   "openstack-auth-url",
@@ -268,13 +286,24 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "OS_FLOATINGIP_POOL",
 			Name:   "openstack-floatingip-pool",
-			Usage:  "OpenStack floating IP pool to get an IP from to assign to the instance",
+			Usage:  "OpenStack floating IP pool to get an IP from to assign to the instance, as the name or ID of an external network",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "OS_FLOATINGIP_SUBNET",
+			Name:   "openstack-floatingip-subnet",
+			Usage:  "Name or ID of the subnet of the floating IP pool to get the IP from",
+			Value:  "",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "OS_FLOATINGIP_SKIP_ROUTABLE",
+			Name:   "openstack-floatingip-skip-routable",
+			Usage:  "Do not assign a floating IP when the instance has a public fixed IP, e.g. on a directly routable tenant network",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "OS_IP_VERSION",
 			Name:   "openstack-ip-version",
-			Usage:  "OpenStack version of IP address assigned for the machine",
+			Usage:  "OpenStack version of IP address assigned for the machine: 4, 6, or 0 for IPv4 falling back to IPv6 on dual-stack and IPv6-only networks",
 			Value:  4,
 		},
 		mcnflag.StringFlag{
@@ -302,7 +331,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		},
 		mcnflag.BoolFlag{
 			Name:  "openstack-boot-from-volume",
-			Usage: "Enables Openstack instance to boot from volume as ROOT",
+			Usage: "Enables Openstack instance to boot from volume as ROOT, created from the image with --openstack-volume-size and --openstack-volume-type",
+		},
+		mcnflag.BoolFlag{
+			Name:  "openstack-keep-boot-volume",
+			Usage: "Keep the ROOT volume when the instance is deleted (with --openstack-boot-from-volume)",
 		},
 		mcnflag.StringFlag{
 			Name:  "openstack-volume-name",
@@ -324,6 +357,18 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage: "OpenStack volume type (ssd, ...)",
 			Value: "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "OS_SERVER_GROUP",
+			Name:   "openstack-server-group",
+			Usage:  "OpenStack server group to place the instance in, created if it does not exist",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OS_SERVER_GROUP_POLICY",
+			Name:   "openstack-server-group-policy",
+			Usage:  "Policy of the server group when it is created (affinity, anti-affinity, soft-affinity or soft-anti-affinity)",
+			Value:  defaultServerGroupPolicy,
+		},
 		mcnflag.IntFlag{
 			Name:  "openstack-volume-size",
 			Usage: "OpenStack volume size (GiB) when creating a volume",
@@ -395,6 +440,7 @@ func (d *Driver) UnmarshalJSON(data []byte) error {
 }
 
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Cloud = flags.String("openstack-cloud")
 	d.AuthUrl = flags.String("openstack-auth-url")
 	d.ActiveTimeout = flags.Int("openstack-active-timeout")
 	d.Insecure = flags.Bool("openstack-insecure")
@@ -426,6 +472,8 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		d.SecurityGroups = strings.Split(flags.String("openstack-sec-groups"), ",")
 	}
 	d.FloatingIpPool = flags.String("openstack-floatingip-pool")
+	d.FloatingIpSubnet = flags.String("openstack-floatingip-subnet")
+	d.FloatingIpSkipRoutable = flags.Bool("openstack-floatingip-skip-routable")
 	d.IpVersion = flags.Int("openstack-ip-version")
 	d.ComputeNetwork = flags.Bool("openstack-nova-network")
 	d.SSHUser = flags.String("openstack-ssh-user")
@@ -436,6 +484,9 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.ConfigDrive = flags.Bool("openstack-config-drive")
 
 	d.BootFromVolume = flags.Bool("openstack-boot-from-volume")
+	d.KeepBootVolume = flags.Bool("openstack-keep-boot-volume")
+	d.ServerGroupName = flags.String("openstack-server-group")
+	d.ServerGroupPolicy = flags.String("openstack-server-group-policy")
 	d.VolumeName = flags.String("openstack-volume-name")
 	d.VolumeDevicePath = flags.String("openstack-volume-device-path")
 	d.VolumeId = flags.String("openstack-volume-id")
@@ -453,6 +504,12 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 
 	d.SetSwarmConfigFromFlags(flags)
 
+	if d.Cloud != "" {
+		if err := d.applyCloudConfig(); err != nil {
+			return err
+		}
+	}
+
 	return d.checkConfig()
 }
 
@@ -469,7 +526,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", nil
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) GetIP() (string, error) {
@@ -483,27 +540,73 @@ func (d *Driver) GetIP() (string, error) {
 		return "", err
 	}
 
-	addressType := Fixed
-	if d.FloatingIpPool != "" {
-		addressType = Floating
-	}
-
 	// Looking for the IP address in a retry loop to deal with OpenStack latency
 	for retryCount := 0; retryCount < 5; retryCount++ {
 		addresses, err := d.client.GetInstanceIPAddresses(d)
 		if err != nil {
 			return "", err
 		}
-		for _, a := range addresses {
-			if a.AddressType == addressType && a.Version == d.IpVersion {
-				return a.Address, nil
-			}
+		if ip := d.selectIPAddress(addresses); ip != "" {
+			return ip, nil
 		}
 		time.Sleep(2 * time.Second)
 	}
 	return "", fmt.Errorf("No IP found for the machine")
 }
 
+// ipVersions returns the IP versions to look for, in order of preference.
+func (d *Driver) ipVersions() []int {
+	if d.IpVersion == 0 {
+		return []int{4, 6}
+	}
+	return []int{d.IpVersion}
+}
+
+// selectIPAddress returns the floating IP of the machine if it has a pool,
+// else its fixed IP of the configured version.
+func (d *Driver) selectIPAddress(addresses []IPAddress) string {
+	addressType := Fixed
+	if d.FloatingIpPool != "" {
+		addressType = Floating
+	}
+
+	for _, version := range d.ipVersions() {
+		for _, a := range addresses {
+			if a.AddressType == addressType && a.Version == version {
+				return a.Address
+			}
+		}
+	}
+	return ""
+}
+
+// routableFixedIP returns a public fixed IP of the machine, which makes a
+// floating IP unnecessary.
+func (d *Driver) routableFixedIP(addresses []IPAddress) string {
+	for _, version := range d.ipVersions() {
+		for _, a := range addresses {
+			if a.AddressType != Fixed || a.Version != version {
+				continue
+			}
+			ip := net.ParseIP(a.Address)
+			if ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+				return a.Address
+			}
+		}
+	}
+	return ""
+}
+
+// GetStateContext returns the state of the instance, aborting the OpenStack
+// calls in progress when ctx is done.
+func (d *Driver) GetStateContext(ctx context.Context) (s state.State, err error) {
+	err = d.WithContext(ctx, func() error {
+		s, err = d.GetState()
+		return err
+	})
+	return s, err
+}
+
 func (d *Driver) GetState() (state.State, error) {
 	log.Debug("Get status for OpenStack instance...", map[string]string{"MachineId": d.MachineId})
 	if err := d.initCompute(); err != nil {
@@ -544,10 +647,67 @@ func (d *Driver) failedToCreate(err error) error {
 	return err
 }
 
+// CreateContext creates the instance, aborting the OpenStack calls in
+// progress when ctx is done. The instance is then removed.
+func (d *Driver) CreateContext(ctx context.Context) error {
+	err := d.WithContext(ctx, d.Create)
+	if err != nil && ctx.Err() != nil && d.MachineId != "" {
+		log.Warnf("Creation of the instance interrupted, removing it: %s", err)
+		if err := d.Remove(); err != nil {
+			log.Warnf("Error removing the instance: %s", err)
+		}
+	}
+	return err
+}
+
+// Plan returns the server to create, with the ids of the network, the
+// flavor, the image and the floating IP pool given by name resolved.
+func (d *Driver) Plan() ([]drivers.PlanItem, error) {
+	if err := d.resolveIds(); err != nil {
+		return nil, err
+	}
+
+	items := []drivers.PlanItem{
+		{Name: "Region", Value: d.Region},
+		{Name: "Availability zone", Value: d.AvailabilityZone},
+		{Name: "Flavor", Value: planNameID(d.FlavorName, d.FlavorId)},
+		{Name: "Image", Value: planNameID(d.ImageName, d.ImageId)},
+		{Name: "Network", Value: planNameID(d.NetworkName, d.NetworkId)},
+		{Name: "Security groups", Value: strings.Join(d.SecurityGroups, ", ")},
+		{Name: "Floating IP pool", Value: planNameID(d.FloatingIpPool, d.FloatingIpPoolId)},
+		{Name: "Key pair", Value: d.KeyPairName},
+	}
+	if d.BootFromVolume {
+		items = append(items, drivers.PlanItem{Name: "Boot volume", Value: fmt.Sprintf("%d GB, %s", d.VolumeSize, d.VolumeType)})
+	} else if d.VolumeSize > 0 {
+		items = append(items, drivers.PlanItem{Name: "Volume", Value: fmt.Sprintf("%s, %d GB, %s", d.VolumeName, d.VolumeSize, d.VolumeType)})
+	}
+	if d.ServerGroupName != "" {
+		items = append(items, drivers.PlanItem{Name: "Server group", Value: fmt.Sprintf("%s, %s", d.ServerGroupName, d.ServerGroupPolicy)})
+	}
+	return items, nil
+}
+
+// planNameID returns the name of a resource with its id, either if unset.
+func planNameID(name, id string) string {
+	switch {
+	case name == "":
+		return id
+	case id == "":
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, id)
+}
+
 func (d *Driver) Create() error {
 	if err := d.resolveIds(); err != nil {
 		return err
 	}
+	if d.ServerGroupName != "" {
+		if err := d.resolveServerGroup(); err != nil {
+			return err
+		}
+	}
 	if d.KeyPairName != "" {
 		if err := d.loadSSHKey(); err != nil {
 			return err
@@ -577,6 +737,11 @@ func (d *Driver) Create() error {
 			return err
 		}
 	}
+	if d.FloatingIpPool != "" && d.FloatingIpSkipRoutable {
+		if err := d.skipFloatingIPIfRoutable(); err != nil {
+			return d.failedToCreate(err)
+		}
+	}
 	if d.FloatingIpPool != "" {
 		if err := d.assignFloatingIP(); err != nil {
 			return d.failedToCreate(err)
@@ -588,6 +753,18 @@ func (d *Driver) Create() error {
 	return nil
 }
 
+// StartContext starts the instance, aborting the OpenStack calls in progress
+// when ctx is done.
+func (d *Driver) StartContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Start)
+}
+
+// StopContext stops the instance, aborting the OpenStack calls in progress
+// when ctx is done.
+func (d *Driver) StopContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Stop)
+}
+
 func (d *Driver) Start() error {
 	if err := d.initCompute(); err != nil {
 		return err
@@ -616,6 +793,12 @@ func (d *Driver) Kill() error {
 	return d.Stop()
 }
 
+// RemoveContext removes the instance, aborting the OpenStack calls in
+// progress when ctx is done.
+func (d *Driver) RemoveContext(ctx context.Context) error {
+	return d.WithContext(ctx, d.Remove)
+}
+
 func (d *Driver) Remove() error {
 	log.Debug("deleting instance...", map[string]string{"MachineId": d.MachineId})
 	log.Info("Deleting OpenStack instance...")
@@ -652,6 +835,12 @@ func (d *Driver) Remove() error {
 			return err
 		}
 	}
+	if d.ServerGroupName != "" {
+		log.Infof("The server group %s was not removed", d.ServerGroupName)
+	}
+	if d.BootFromVolume && d.KeepBootVolume {
+		log.Infof("The boot volume of %s has been kept, it needs to be deleted separately", d.MachineName)
+	}
 	if !d.ExistingKey {
 		log.Debug("deleting key pair...", map[string]string{"Name": d.KeyPairName})
 		if err := d.client.DeleteKeyPair(d, d.KeyPairName); err != nil {
@@ -662,41 +851,54 @@ func (d *Driver) Remove() error {
 }
 
 const (
-	errorMandatoryEnvOrOption string = "%s must be specified either using the environment variable %s or the CLI option %s"
-	errorMandatoryOption      string = "%s must be specified using the CLI option %s"
-	errorExclusiveOptions     string = "Either %s or %s must be specified, not both"
-	errorBothOptions          string = "Both %s and %s must be specified"
-	errorWrongEndpointType    string = "Endpoint type must be 'publicURL', 'adminURL' or 'internalURL'"
-	errorUnknownFlavorName    string = "Unable to find flavor named %s"
-	errorUnknownImageName     string = "Unable to find image named %s"
-	errorUnknownNetworkName   string = "Unable to find network named %s"
-	errorUnknownTenantName    string = "Unable to find tenant named %s"
+	errorMandatoryEnvOrOption    string = "%s must be specified either using the environment variable %s or the CLI option %s"
+	errorMandatoryOption         string = "%s must be specified using the CLI option %s"
+	errorExclusiveOptions        string = "Either %s or %s must be specified, not both"
+	errorBothOptions             string = "Both %s and %s must be specified"
+	errorWrongEndpointType       string = "Endpoint type must be 'publicURL', 'adminURL' or 'internalURL'"
+	errorUnknownFlavorName       string = "Unable to find flavor named %s"
+	errorUnknownImageName        string = "Unable to find image named %s"
+	errorUnknownNetworkName      string = "Unable to find network named %s"
+	errorUnknownFloatingIPSubnet string = "Unable to find subnet %s of floating IP pool %s"
+	errorWrongIPVersion          string = "Invalid IP version %d, must be 4, 6 or 0"
+	errorUnknownTenantName       string = "Unable to find tenant named %s"
+	errorWrongServerGroupPolicy  string = "Server group policy %q must be 'affinity', 'anti-affinity', 'soft-affinity' or 'soft-anti-affinity'"
 )
 
 func (d *Driver) parseAuthConfig() (*gophercloud.AuthOptions, error) {
-	return clientconfig.AuthOptions(
-		&clientconfig.ClientOpts{
-			// this is needed to disable the clientconfig.AuthOptions func env detection
-			EnvPrefix: "_",
-			AuthInfo: &clientconfig.AuthInfo{
-				AuthURL:                     d.AuthUrl,
-				UserID:                      d.UserId,
-				Username:                    d.Username,
-				Password:                    d.Password,
-				ProjectID:                   d.TenantId,
-				ProjectName:                 d.TenantName,
-				DomainID:                    d.DomainId,
-				DomainName:                  d.DomainName,
-				ProjectDomainID:             d.TenantDomainId,
-				ProjectDomainName:           d.TenantDomainName,
-				UserDomainID:                d.UserDomainId,
-				UserDomainName:              d.UserDomainName,
-				ApplicationCredentialID:     d.ApplicationCredentialId,
-				ApplicationCredentialName:   d.ApplicationCredentialName,
-				ApplicationCredentialSecret: d.ApplicationCredentialSecret,
-			},
+	opts := &clientconfig.ClientOpts{
+		// this is needed to disable the clientconfig.AuthOptions func env detection
+		EnvPrefix: "_",
+		AuthInfo: &clientconfig.AuthInfo{
+			AuthURL:                     d.AuthUrl,
+			UserID:                      d.UserId,
+			Username:                    d.Username,
+			Password:                    d.Password,
+			ProjectID:                   d.TenantId,
+			ProjectName:                 d.TenantName,
+			DomainID:                    d.DomainId,
+			DomainName:                  d.DomainName,
+			ProjectDomainID:             d.TenantDomainId,
+			ProjectDomainName:           d.TenantDomainName,
+			UserDomainID:                d.UserDomainId,
+			UserDomainName:              d.UserDomainName,
+			ApplicationCredentialID:     d.ApplicationCredentialId,
+			ApplicationCredentialName:   d.ApplicationCredentialName,
+			ApplicationCredentialSecret: d.ApplicationCredentialSecret,
 		},
-	)
+	}
+
+	if d.Cloud != "" {
+		cloud, err := d.getCloud()
+		if err != nil {
+			return nil, err
+		}
+		if cloud.AuthInfo != nil {
+			opts.AuthInfo = d.mergeAuthInfo(cloud.AuthInfo)
+		}
+	}
+
+	return clientconfig.AuthOptions(opts)
 }
 
 func (d *Driver) checkConfig() error {
@@ -724,6 +926,30 @@ func (d *Driver) checkConfig() error {
 	if d.EndpointType != "" && (d.EndpointType != "publicURL" && d.EndpointType != "adminURL" && d.EndpointType != "internalURL") {
 		return fmt.Errorf(errorWrongEndpointType)
 	}
+	if d.BootFromVolume && d.VolumeSize <= 0 {
+		return fmt.Errorf(errorMandatoryOption, "Volume size", "--openstack-volume-size")
+	}
+	if d.BootFromVolume && d.VolumeId != "" {
+		return fmt.Errorf(errorExclusiveOptions, "Boot from volume", "Volume id")
+	}
+	if d.ServerGroupName != "" && !isServerGroupPolicy(d.ServerGroupPolicy) {
+		return fmt.Errorf(errorWrongServerGroupPolicy, d.ServerGroupPolicy)
+	}
+	if d.KeepBootVolume && !d.BootFromVolume {
+		return fmt.Errorf(errorBothOptions, "--openstack-keep-boot-volume", "--openstack-boot-from-volume")
+	}
+	if d.IpVersion != 0 && d.IpVersion != 4 && d.IpVersion != 6 {
+		return fmt.Errorf(errorWrongIPVersion, d.IpVersion)
+	}
+	if d.FloatingIpPool != "" && d.IpVersion == 6 {
+		return fmt.Errorf("Floating IPs are IPv4 addresses, use --openstack-ip-version 4 or 0 with --openstack-floatingip-pool")
+	}
+	if d.FloatingIpSubnet != "" && (d.FloatingIpPool == "" || d.ComputeNetwork) {
+		return fmt.Errorf(errorBothOptions, "--openstack-floatingip-subnet", "--openstack-floatingip-pool with neutron")
+	}
+	if d.FloatingIpSkipRoutable && d.FloatingIpPool == "" {
+		return fmt.Errorf(errorBothOptions, "--openstack-floatingip-skip-routable", "--openstack-floatingip-pool")
+	}
 	if (d.KeyPairName != "" && d.PrivateKeyFile == "") || (d.KeyPairName == "" && d.PrivateKeyFile != "") {
 		return fmt.Errorf(errorBothOptions, "KeyPairName", "PrivateKeyFile")
 	}
@@ -814,11 +1040,64 @@ func (d *Driver) resolveIds() error {
 			"Name": d.FloatingIpPool,
 			"ID":   d.FloatingIpPoolId,
 		})
+
+		if d.FloatingIpSubnet != "" {
+			s, err := d.client.GetFloatingIPSubnetID(d)
+			if err != nil {
+				return err
+			}
+			if s == "" {
+				return fmt.Errorf(errorUnknownFloatingIPSubnet, d.FloatingIpSubnet, d.FloatingIpPool)
+			}
+
+			d.FloatingIpSubnetId = s
+			log.Debug("Found floating IP subnet id using its name", map[string]string{
+				"Name": d.FloatingIpSubnet,
+				"ID":   d.FloatingIpSubnetId,
+			})
+		}
+	}
+
+	return nil
+}
+
+// resolveServerGroup looks up the server group by name, creating it with the
+// configured policy if it does not exist. Like the exoscale anti-affinity
+// groups, it is not removed with the machine as it is shared with others.
+func (d *Driver) resolveServerGroup() error {
+	if err := d.initCompute(); err != nil {
+		return err
+	}
+
+	groupID, err := d.client.GetServerGroupID(d)
+	if err != nil {
+		return err
+	}
+
+	if groupID == "" {
+		log.Infof("Server group %s does not exist, creating it with policy %s", d.ServerGroupName, d.ServerGroupPolicy)
+		if groupID, err = d.client.CreateServerGroup(d); err != nil {
+			return err
+		}
 	}
 
+	d.ServerGroupId = groupID
+	log.Debug("Found server group id using its name", map[string]string{
+		"Name": d.ServerGroupName,
+		"ID":   d.ServerGroupId,
+	})
 	return nil
 }
 
+func isServerGroupPolicy(policy string) bool {
+	for _, p := range serverGroupPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Driver) initCompute() error {
 	if err := d.client.Authenticate(d); err != nil {
 		return err
@@ -990,6 +1269,24 @@ func (d *Driver) assignFloatingIP() error {
 	return nil
 }
 
+// skipFloatingIPIfRoutable drops the floating IP pool of the machine when
+// the instance already has a public fixed IP, so that neither GetIP nor
+// Remove look for a floating IP.
+func (d *Driver) skipFloatingIPIfRoutable() error {
+	addresses, err := d.client.GetInstanceIPAddresses(d)
+	if err != nil {
+		return err
+	}
+
+	if ip := d.routableFixedIP(addresses); ip != "" {
+		log.Infof("The instance has the public IP address %s, not assigning a floating IP", ip)
+		d.FloatingIpPool = ""
+		d.FloatingIpPoolId = ""
+		d.FloatingIpSubnetId = ""
+	}
+	return nil
+}
+
 func (d *Driver) waitForInstanceActive() error {
 	log.Debug("Waiting for the OpenStack instance to be ACTIVE...", map[string]string{"MachineId": d.MachineId})
 	if err := d.client.WaitForInstanceStatus(d, "ACTIVE"); err != nil {
@@ -1,6 +1,8 @@
 package openstack
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/rancher/machine/libmachine/drivers"
@@ -27,3 +29,299 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+const testCloudsYAML = `clouds:
+  mycloud:
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+      application_credential_id: app-id
+      application_credential_secret: app-secret
+    region_name: RegionTwo
+    interface: internal
+    verify: false
+`
+
+func TestSetConfigFromCloudsYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "clouds.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(testCloudsYAML), 0600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+
+	driver := NewDerivedDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"openstack-cloud":     "mycloud",
+			"openstack-flavor-id": "ID",
+			"openstack-image-id":  "ID",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "https://keystone.example.com:5000/v3", driver.AuthUrl)
+	assert.Equal(t, "RegionTwo", driver.Region)
+	assert.Equal(t, "internalURL", driver.EndpointType)
+	assert.True(t, driver.Insecure)
+	assert.Empty(t, driver.ApplicationCredentialSecret)
+
+	ao, err := driver.parseAuthConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "app-id", ao.ApplicationCredentialID)
+	assert.Equal(t, "app-secret", ao.ApplicationCredentialSecret)
+
+	driver.ApplicationCredentialSecret = "override"
+	ao, err = driver.parseAuthConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "override", ao.ApplicationCredentialSecret)
+}
+
+func TestSetConfigFromUnknownCloud(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "clouds.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(testCloudsYAML), 0600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"openstack-cloud":     "other",
+			"openstack-flavor-id": "ID",
+			"openstack-image-id":  "ID",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+}
+
+func TestBootFromVolumeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "boot from volume",
+			flags: map[string]interface{}{
+				"openstack-boot-from-volume": true,
+				"openstack-volume-size":      40,
+				"openstack-volume-type":      "ssd",
+				"openstack-keep-boot-volume": true,
+			},
+		},
+		{
+			name: "missing volume size",
+			flags: map[string]interface{}{
+				"openstack-boot-from-volume": true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "existing volume",
+			flags: map[string]interface{}{
+				"openstack-boot-from-volume": true,
+				"openstack-volume-size":      40,
+				"openstack-volume-id":        "ID",
+			},
+			wantErr: true,
+		},
+		{
+			name: "keep without boot from volume",
+			flags: map[string]interface{}{
+				"openstack-keep-boot-volume": true,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := NewDerivedDriver("default", "path")
+
+			flags := map[string]interface{}{
+				"openstack-auth-url":  "http://url",
+				"openstack-username":  "user",
+				"openstack-password":  "pwd",
+				"openstack-tenant-id": "ID",
+				"openstack-flavor-id": "ID",
+				"openstack-image-id":  "ID",
+			}
+			for k, v := range test.flags {
+				flags[k] = v
+			}
+			checkFlags := &drivers.CheckDriverOptions{
+				FlagsValues: flags,
+				CreateFlags: driver.GetCreateFlags(),
+			}
+
+			err := driver.SetConfigFromFlags(checkFlags)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, driver.KeepBootVolume)
+		})
+	}
+}
+
+func TestServerGroupPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{
+		"anti-affinity":      true,
+		"soft-anti-affinity": true,
+		"affinity":           true,
+		"spread":             false,
+	} {
+		driver := NewDerivedDriver("default", "path")
+
+		checkFlags := &drivers.CheckDriverOptions{
+			FlagsValues: map[string]interface{}{
+				"openstack-auth-url":            "http://url",
+				"openstack-username":            "user",
+				"openstack-password":            "pwd",
+				"openstack-tenant-id":           "ID",
+				"openstack-flavor-id":           "ID",
+				"openstack-image-id":            "ID",
+				"openstack-server-group":        "workers",
+				"openstack-server-group-policy": policy,
+			},
+			CreateFlags: driver.GetCreateFlags(),
+		}
+
+		err := driver.SetConfigFromFlags(checkFlags)
+		if valid {
+			assert.NoError(t, err, policy)
+			assert.Equal(t, "workers", driver.ServerGroupName)
+		} else {
+			assert.Error(t, err, policy)
+		}
+	}
+}
+
+func TestFloatingIPConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "pool and subnet",
+			flags: map[string]interface{}{
+				"openstack-floatingip-pool":          "public",
+				"openstack-floatingip-subnet":        "public-v4",
+				"openstack-floatingip-skip-routable": true,
+				"openstack-ip-version":               0,
+			},
+		},
+		{
+			name: "subnet without pool",
+			flags: map[string]interface{}{
+				"openstack-floatingip-subnet": "public-v4",
+			},
+			wantErr: true,
+		},
+		{
+			name: "skip routable without pool",
+			flags: map[string]interface{}{
+				"openstack-floatingip-skip-routable": true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "floating IPv6",
+			flags: map[string]interface{}{
+				"openstack-floatingip-pool": "public",
+				"openstack-ip-version":      6,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown IP version",
+			flags: map[string]interface{}{
+				"openstack-ip-version": 5,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := NewDerivedDriver("default", "path")
+
+			flags := map[string]interface{}{
+				"openstack-auth-url":  "http://url",
+				"openstack-username":  "user",
+				"openstack-password":  "pwd",
+				"openstack-tenant-id": "ID",
+				"openstack-flavor-id": "ID",
+				"openstack-image-id":  "ID",
+			}
+			for k, v := range test.flags {
+				flags[k] = v
+			}
+			checkFlags := &drivers.CheckDriverOptions{
+				FlagsValues: flags,
+				CreateFlags: driver.GetCreateFlags(),
+			}
+
+			err := driver.SetConfigFromFlags(checkFlags)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSelectIPAddress(t *testing.T) {
+	addresses := []IPAddress{
+		{AddressType: Fixed, Address: "2001:db8::10", Version: 6},
+		{AddressType: Fixed, Address: "10.0.0.10", Version: 4},
+		{AddressType: Floating, Address: "203.0.113.10", Version: 4},
+	}
+
+	assert.Equal(t, "10.0.0.10", (&Driver{IpVersion: 4}).selectIPAddress(addresses))
+	assert.Equal(t, "2001:db8::10", (&Driver{IpVersion: 6}).selectIPAddress(addresses))
+	assert.Equal(t, "10.0.0.10", (&Driver{IpVersion: 0}).selectIPAddress(addresses))
+	assert.Equal(t, "203.0.113.10", (&Driver{IpVersion: 0, FloatingIpPool: "public"}).selectIPAddress(addresses))
+	assert.Equal(t, "2001:db8::10", (&Driver{IpVersion: 0}).selectIPAddress(addresses[:1]))
+	assert.Empty(t, (&Driver{IpVersion: 4}).selectIPAddress(addresses[:1]))
+}
+
+func TestRoutableFixedIP(t *testing.T) {
+	private := []IPAddress{
+		{AddressType: Fixed, Address: "10.0.0.10", Version: 4},
+		{AddressType: Fixed, Address: "fd00::10", Version: 6},
+		{AddressType: Floating, Address: "203.0.113.10", Version: 4},
+	}
+	public := append(private, IPAddress{AddressType: Fixed, Address: "2a01:4f8::10", Version: 6})
+
+	assert.Empty(t, (&Driver{IpVersion: 0}).routableFixedIP(private))
+	assert.Equal(t, "2a01:4f8::10", (&Driver{IpVersion: 0}).routableFixedIP(public))
+	assert.Empty(t, (&Driver{IpVersion: 4}).routableFixedIP(public))
+}
+
+func TestPlan(t *testing.T) {
+	driver := NewDerivedDriver("default", "path")
+	driver.FlavorId = "flavor-1"
+	driver.ImageId = "image-1"
+	driver.NetworkId = "network-1"
+	driver.BootFromVolume = true
+	driver.VolumeSize = 40
+	driver.VolumeType = "ssd"
+
+	// the resources are given by id, nothing is looked up
+	items, err := driver.Plan()
+
+	assert.NoError(t, err)
+	assert.Contains(t, items, drivers.PlanItem{Name: "Flavor", Value: "flavor-1"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Network", Value: "network-1"})
+	assert.Contains(t, items, drivers.PlanItem{Name: "Boot volume", Value: "40 GB, ssd"})
+	assert.Equal(t, "flavor (flavor-1)", planNameID("flavor", "flavor-1"))
+}
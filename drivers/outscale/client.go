@@ -0,0 +1,390 @@
+package outscale
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const signingService = "oapi"
+
+var (
+	endpointFormat           = "https://api.%s.outscale.com/api/v1"
+	defaultHTTPClientTimeout = 60 * time.Second
+	omiIDPrefix              = "ami-"
+	securityGroupIDPrefix    = "sg-"
+)
+
+// Client calls the OUTSCALE API of one region.
+type Client struct {
+	Endpoint string
+	// DockerPort is the port of the Docker daemon allowed by the security
+	// groups created
+	DockerPort int
+	region     string
+	signer     *v4.Signer
+	http       *http.Client
+}
+
+type Vm struct {
+	VmID      string `json:"VmId"`
+	State     string
+	PrivateIP string `json:"PrivateIp"`
+	PublicIP  string `json:"PublicIp"`
+	Placement struct {
+		SubregionName string
+	}
+}
+
+type Subnet struct {
+	SubnetID      string `json:"SubnetId"`
+	NetID         string `json:"NetId"`
+	SubregionName string
+}
+
+type PublicIP struct {
+	PublicIPID     string `json:"PublicIpId"`
+	PublicIP       string `json:"PublicIp"`
+	LinkPublicIPID string `json:"LinkPublicIpId"`
+}
+
+type BlockDeviceMapping struct {
+	DeviceName string
+	Bsu        Bsu
+}
+
+type Bsu struct {
+	VolumeSize         int    `json:",omitempty"`
+	VolumeType         string `json:",omitempty"`
+	DeleteOnVmDeletion bool
+}
+
+type CreateVmsRequest struct {
+	ImageID             string               `json:"ImageId"`
+	VmType              string               `json:",omitempty"`
+	KeypairName         string               `json:",omitempty"`
+	SecurityGroupIDs    []string             `json:"SecurityGroupIds,omitempty"`
+	SubnetID            string               `json:"SubnetId,omitempty"`
+	Placement           *Placement           `json:",omitempty"`
+	UserData            string               `json:",omitempty"`
+	BlockDeviceMappings []BlockDeviceMapping `json:",omitempty"`
+	MinVmsCount         int
+	MaxVmsCount         int
+}
+
+type Placement struct {
+	SubregionName string
+}
+
+type apiError struct {
+	Errors []struct {
+		Code    string
+		Type    string
+		Details string
+	}
+	ResponseContext struct {
+		RequestID string `json:"RequestId"`
+	}
+}
+
+func (e *apiError) Error() string {
+	if len(e.Errors) == 0 {
+		return "unknown error (request " + e.ResponseContext.RequestID + ")"
+	}
+	return fmt.Sprintf("%s %s: %s (request %s)", e.Errors[0].Code, e.Errors[0].Type, e.Errors[0].Details, e.ResponseContext.RequestID)
+}
+
+func NewClient(region, accessKey, secretKey string) *Client {
+	return &Client{
+		Endpoint:   fmt.Sprintf(endpointFormat, region),
+		DockerPort: dockerPort,
+		region:     region,
+		signer:     v4.NewSigner(credentials.NewStaticCredentials(accessKey, secretKey, "")),
+		http:       &http.Client{Timeout: defaultHTTPClientTimeout},
+	}
+}
+
+// call posts a request to an OUTSCALE API call, signed with AWS signature
+// version 4.
+func (c *Client) call(name string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.Endpoint+"/"+name, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := c.signer.Sign(req, bytes.NewReader(body), signingService, c.region, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &apiError{}
+		if json.Unmarshal(data, apiErr) == nil && len(apiErr.Errors) > 0 {
+			return fmt.Errorf("%s: %w", name, apiErr)
+		}
+		return fmt.Errorf("%s: %s", name, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func isErrorType(err error, types ...string) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || len(apiErr.Errors) == 0 {
+		return false
+	}
+	for _, t := range types {
+		if apiErr.Errors[0].Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageID resolves an OMI by name. An OMI ID is returned as is.
+func (c *Client) ImageID(name string) (string, error) {
+	filters := map[string][]string{"ImageNames": {name}}
+	if strings.HasPrefix(name, omiIDPrefix) {
+		filters = map[string][]string{"ImageIds": {name}}
+	}
+
+	var resp struct {
+		Images []struct {
+			ImageID string `json:"ImageId"`
+		}
+	}
+	if err := c.call("ReadImages", map[string]interface{}{"Filters": filters}, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Images) == 0 {
+		return "", fmt.Errorf("OMI %q not found", name)
+	}
+	return resp.Images[0].ImageID, nil
+}
+
+func (c *Client) Subnet(id string) (*Subnet, error) {
+	var resp struct {
+		Subnets []Subnet
+	}
+	if err := c.call("ReadSubnets", map[string]interface{}{
+		"Filters": map[string][]string{"SubnetIds": {id}},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Subnets) == 0 {
+		return nil, fmt.Errorf("subnet %s not found", id)
+	}
+	return &resp.Subnets[0], nil
+}
+
+// SecurityGroupID resolves a security group by ID or by name, within the Net
+// if netID is set. A group that is given by name and doesn't exist is
+// created when create is set, allowing SSH and Docker.
+func (c *Client) SecurityGroupID(name, netID string, create bool) (string, error) {
+	filters := map[string][]string{"SecurityGroupNames": {name}}
+	if strings.HasPrefix(name, securityGroupIDPrefix) {
+		filters = map[string][]string{"SecurityGroupIds": {name}}
+	}
+	if netID != "" {
+		filters["NetIds"] = []string{netID}
+	}
+
+	var resp struct {
+		SecurityGroups []struct {
+			SecurityGroupID string `json:"SecurityGroupId"`
+			NetID           string `json:"NetId"`
+		}
+	}
+	if err := c.call("ReadSecurityGroups", map[string]interface{}{"Filters": filters}, &resp); err != nil {
+		return "", err
+	}
+	for _, sg := range resp.SecurityGroups {
+		// without a Net, only the groups of the public cloud apply
+		if netID == "" && sg.NetID != "" {
+			continue
+		}
+		return sg.SecurityGroupID, nil
+	}
+
+	if !create || strings.HasPrefix(name, securityGroupIDPrefix) {
+		return "", fmt.Errorf("security group %q not found", name)
+	}
+	return c.createSecurityGroup(name, netID)
+}
+
+func (c *Client) createSecurityGroup(name, netID string) (string, error) {
+	in := map[string]interface{}{
+		"SecurityGroupName": name,
+		"Description":       "Created by rancher-machine",
+	}
+	if netID != "" {
+		in["NetId"] = netID
+	}
+
+	var resp struct {
+		SecurityGroup struct {
+			SecurityGroupID string `json:"SecurityGroupId"`
+		}
+	}
+	if err := c.call("CreateSecurityGroup", in, &resp); err != nil {
+		return "", err
+	}
+
+	id := resp.SecurityGroup.SecurityGroupID
+	for _, port := range []int{22, c.DockerPort} {
+		if err := c.call("CreateSecurityGroupRule", map[string]interface{}{
+			"SecurityGroupId": id,
+			"Flow":            "Inbound",
+			"IpProtocol":      "tcp",
+			"FromPortRange":   port,
+			"ToPortRange":     port,
+			"IpRange":         "0.0.0.0/0",
+		}, nil); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+func (c *Client) CreateKeypair(name string, publicKey []byte) error {
+	return c.call("CreateKeypair", map[string]string{
+		"KeypairName": name,
+		"PublicKey":   base64.StdEncoding.EncodeToString(publicKey),
+	}, nil)
+}
+
+func (c *Client) DeleteKeypair(name string) error {
+	return c.call("DeleteKeypair", map[string]string{"KeypairName": name}, nil)
+}
+
+func (c *Client) CreateVm(in *CreateVmsRequest) (*Vm, error) {
+	in.MinVmsCount, in.MaxVmsCount = 1, 1
+
+	var resp struct {
+		Vms []Vm
+	}
+	if err := c.call("CreateVms", in, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Vms) == 0 {
+		return nil, errors.New("CreateVms returned no VM")
+	}
+	return &resp.Vms[0], nil
+}
+
+func (c *Client) GetVm(id string) (*Vm, error) {
+	var resp struct {
+		Vms []Vm
+	}
+	if err := c.call("ReadVms", map[string]interface{}{
+		"Filters": map[string][]string{"VmIds": {id}},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Vms) == 0 {
+		return nil, fmt.Errorf("VM %s not found", id)
+	}
+	return &resp.Vms[0], nil
+}
+
+func (c *Client) TagVm(id, name string) error {
+	return c.call("CreateTags", map[string]interface{}{
+		"ResourceIds": []string{id},
+		"Tags":        []map[string]string{{"Key": "Name", "Value": name}},
+	}, nil)
+}
+
+func (c *Client) StartVm(id string) error {
+	return c.call("StartVms", map[string]interface{}{"VmIds": []string{id}}, nil)
+}
+
+func (c *Client) StopVm(id string, force bool) error {
+	return c.call("StopVms", map[string]interface{}{"VmIds": []string{id}, "ForceStop": force}, nil)
+}
+
+func (c *Client) RebootVm(id string) error {
+	return c.call("RebootVms", map[string]interface{}{"VmIds": []string{id}}, nil)
+}
+
+// DeleteVm terminates the VM, treating a VM that is already gone as deleted.
+func (c *Client) DeleteVm(id string) error {
+	err := c.call("DeleteVms", map[string]interface{}{"VmIds": []string{id}}, nil)
+	if isErrorType(err, "InvalidResource") {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) CreatePublicIP() (*PublicIP, error) {
+	var resp struct {
+		PublicIP PublicIP `json:"PublicIp"`
+	}
+	if err := c.call("CreatePublicIp", map[string]interface{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.PublicIP, nil
+}
+
+// LinkPublicIP links the public IP to the VM and returns the ID of the link.
+func (c *Client) LinkPublicIP(publicIPID, vmID string) (string, error) {
+	var resp struct {
+		LinkPublicIPID string `json:"LinkPublicIpId"`
+	}
+	if err := c.call("LinkPublicIp", map[string]string{"PublicIpId": publicIPID, "VmId": vmID}, &resp); err != nil {
+		return "", err
+	}
+	return resp.LinkPublicIPID, nil
+}
+
+func (c *Client) GetPublicIP(id string) (*PublicIP, error) {
+	var resp struct {
+		PublicIps []PublicIP
+	}
+	if err := c.call("ReadPublicIps", map[string]interface{}{
+		"Filters": map[string][]string{"PublicIpIds": {id}},
+	}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.PublicIps) == 0 {
+		return nil, fmt.Errorf("public IP %s not found", id)
+	}
+	return &resp.PublicIps[0], nil
+}
+
+// DeletePublicIP releases the public IP, treating an IP that is already gone
+// as released.
+func (c *Client) DeletePublicIP(id string) error {
+	err := c.call("DeletePublicIp", map[string]string{"PublicIpId": id}, nil)
+	if isErrorType(err, "InvalidResource") {
+		return nil
+	}
+	return err
+}
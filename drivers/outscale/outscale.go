@@ -0,0 +1,507 @@
+package outscale
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver is the struct compatible with github.com/rancher/machine/libmachine/drivers.Driver interface
+type Driver struct {
+	*drivers.BaseDriver
+	AccessKey      string `secret:"true"`
+	SecretKey      string `secret:"true"`
+	Region         string
+	Subregion      string
+	VmType         string
+	Image          string
+	SubnetID       string
+	SecurityGroups []string
+	VolumeSize     int
+	VolumeType     string
+	PrivateIPOnly  bool
+	UserDataFile   string
+	VmID           string
+	KeypairName    string
+	PublicIPID     string
+}
+
+const (
+	defaultRegion        = "eu-west-2"
+	defaultVmType        = "tinav5.c2r4p2"
+	defaultSecurityGroup = "docker-machine"
+	defaultVolumeType    = "gp2"
+	defaultSSHUser       = "outscale"
+	defaultSSHPort       = 22
+	dockerPort           = 2376
+	rootDeviceName       = "/dev/sda1"
+	vmWaitAttempts       = 120
+	vmWaitInterval       = 5 * time.Second
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "OSC_ACCESS_KEY",
+			Name:   "outscale-access-key",
+			Usage:  "Outscale access key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_SECRET_KEY",
+			Name:   "outscale-secret-key",
+			Usage:  "Outscale secret key",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_REGION",
+			Name:   "outscale-region",
+			Usage:  "Region",
+			Value:  defaultRegion,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_SUBREGION",
+			Name:   "outscale-subregion",
+			Usage:  "Subregion, e.g. eu-west-2a (default is the subregion of the subnet, or any subregion)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_VM_TYPE",
+			Name:   "outscale-vm-type",
+			Usage:  "VM type",
+			Value:  defaultVmType,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_IMAGE",
+			Name:   "outscale-image",
+			Usage:  "Name or ID of the OMI",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_SUBNET_ID",
+			Name:   "outscale-subnet-id",
+			Usage:  "Subnet of a Net to create the VM in (default is the public cloud)",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "OSC_SECURITY_GROUP",
+			Name:   "outscale-security-group",
+			Usage:  "Name or ID of a security group of the VM, a missing group given by name is created",
+			Value:  []string{defaultSecurityGroup},
+		},
+		mcnflag.IntFlag{
+			EnvVar: "OSC_VOLUME_SIZE",
+			Name:   "outscale-volume-size",
+			Usage:  "Root volume size in GiB (default is the size of the OMI)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_VOLUME_TYPE",
+			Name:   "outscale-volume-type",
+			Usage:  "Root volume type: standard, gp2 or io1",
+			Value:  defaultVolumeType,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "OSC_PRIVATE_IP_ONLY",
+			Name:   "outscale-private-ip-only",
+			Usage:  "Don't link a public IP and connect to the private IP",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_USERDATA",
+			Name:   "outscale-userdata",
+			Usage:  "Path to file with cloud-init user-data",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "OSC_SSH_USER",
+			Name:   "outscale-ssh-user",
+			Usage:  "SSH user",
+			Value:  defaultSSHUser,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "OSC_SSH_PORT",
+			Name:   "outscale-ssh-port",
+			Usage:  "SSH port",
+			Value:  defaultSSHPort,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Region:         defaultRegion,
+		VmType:         defaultVmType,
+		SecurityGroups: []string{defaultSecurityGroup},
+		VolumeType:     defaultVolumeType,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			SSHPort:     defaultSSHPort,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "outscale"
+}
+
+// UnmarshalJSON loads driver config from JSON.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	// Unmarshal driver config into an aliased type to prevent infinite recursion on UnmarshalJSON.
+	type targetDriver Driver
+	target := targetDriver{}
+	if err := json.Unmarshal(data, &target); err != nil {
+		return fmt.Errorf("error unmarshalling driver config from JSON: %w", err)
+	}
+
+	*d = Driver(target)
+
+	// Make sure to reload values that are subject to change from envvars and os.Args.
+	driverOpts := rpcdriver.GetDriverOpts(d.GetCreateFlags(), os.Args)
+	if _, ok := driverOpts.Values["outscale-access-key"]; ok {
+		d.AccessKey = driverOpts.String("outscale-access-key")
+	}
+	if _, ok := driverOpts.Values["outscale-secret-key"]; ok {
+		d.SecretKey = driverOpts.String("outscale-secret-key")
+	}
+
+	return nil
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.AccessKey = flags.String("outscale-access-key")
+	d.SecretKey = flags.String("outscale-secret-key")
+	d.Region = flags.String("outscale-region")
+	d.Subregion = flags.String("outscale-subregion")
+	d.VmType = flags.String("outscale-vm-type")
+	d.Image = flags.String("outscale-image")
+	d.SubnetID = flags.String("outscale-subnet-id")
+	d.SecurityGroups = flags.StringSlice("outscale-security-group")
+	d.VolumeSize = flags.Int("outscale-volume-size")
+	d.VolumeType = flags.String("outscale-volume-type")
+	d.PrivateIPOnly = flags.Bool("outscale-private-ip-only")
+	d.UserDataFile = flags.String("outscale-userdata")
+	d.SSHUser = flags.String("outscale-ssh-user")
+	d.SSHPort = flags.Int("outscale-ssh-port")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.AccessKey == "" || d.SecretKey == "" {
+		return errors.New("outscale driver requires the --outscale-access-key and --outscale-secret-key options")
+	}
+	if d.Image == "" {
+		return errors.New("outscale driver requires the --outscale-image option")
+	}
+	if d.PrivateIPOnly && d.SubnetID == "" {
+		return errors.New("--outscale-private-ip-only requires --outscale-subnet-id")
+	}
+	switch d.VolumeType {
+	case "standard", "gp2", "io1":
+	default:
+		return fmt.Errorf("--outscale-volume-type must be standard, gp2 or io1, not %q", d.VolumeType)
+	}
+	return nil
+}
+
+func (d *Driver) client() *Client {
+	c := NewClient(d.Region, d.AccessKey, d.SecretKey)
+	c.DockerPort = d.GetEnginePort()
+	return c
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("user-data file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	c := d.client()
+	if _, err := c.ImageID(d.Image); err != nil {
+		return err
+	}
+	if d.SubnetID != "" {
+		subnet, err := c.Subnet(d.SubnetID)
+		if err != nil {
+			return err
+		}
+		if d.Subregion != "" && d.Subregion != subnet.SubregionName {
+			return fmt.Errorf("subnet %s is in subregion %s, not %s", d.SubnetID, subnet.SubregionName, d.Subregion)
+		}
+	}
+
+	return nil
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+	publicKey, err := ioutil.ReadFile(d.GetSSHKeyPath() + ".pub")
+	if err != nil {
+		return err
+	}
+
+	c := d.client()
+
+	imageID, err := c.ImageID(d.Image)
+	if err != nil {
+		return err
+	}
+
+	var netID string
+	if d.SubnetID != "" {
+		subnet, err := c.Subnet(d.SubnetID)
+		if err != nil {
+			return err
+		}
+		netID = subnet.NetID
+	}
+
+	var securityGroupIDs []string
+	for _, sg := range d.SecurityGroups {
+		id, err := c.SecurityGroupID(sg, netID, true)
+		if err != nil {
+			return fmt.Errorf("error getting security group %s: %s", sg, err)
+		}
+		securityGroupIDs = append(securityGroupIDs, id)
+	}
+
+	var userData []byte
+	if d.UserDataFile != "" {
+		if userData, err = ioutil.ReadFile(d.UserDataFile); err != nil {
+			return err
+		}
+	}
+
+	d.KeypairName = d.MachineName
+	if err := c.CreateKeypair(d.KeypairName, publicKey); err != nil {
+		return fmt.Errorf("error creating keypair: %s", err)
+	}
+
+	log.Infof("Creating VM %s (%s)...", d.MachineName, d.VmType)
+	vm, err := c.CreateVm(d.createVmsRequest(imageID, securityGroupIDs, userData))
+	if err != nil {
+		return fmt.Errorf("error creating VM: %s", err)
+	}
+	d.VmID = vm.VmID
+
+	if err := c.TagVm(d.VmID, d.MachineName); err != nil {
+		log.Warnf("Failed to tag VM %s: %s", d.VmID, err)
+	}
+
+	log.Infof("Waiting for VM %s to be running...", d.VmID)
+	if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		vm, err := c.GetVm(d.VmID)
+		if err != nil {
+			return false, err
+		}
+		if vm.State == "terminated" || vm.State == "shutting-down" {
+			return false, fmt.Errorf("VM %s is %s", d.VmID, vm.State)
+		}
+		return vm.State == "running", nil
+	}, vmWaitAttempts, vmWaitInterval); err != nil {
+		return err
+	}
+
+	if !d.PrivateIPOnly {
+		log.Infof("Allocating a public IP...")
+		ip, err := c.CreatePublicIP()
+		if err != nil {
+			return fmt.Errorf("error allocating public IP: %s", err)
+		}
+		d.PublicIPID = ip.PublicIPID
+
+		log.Infof("Linking public IP %s to %s...", ip.PublicIP, d.VmID)
+		if _, err := c.LinkPublicIP(d.PublicIPID, d.VmID); err != nil {
+			return fmt.Errorf("error linking public IP: %s", err)
+		}
+	}
+
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+func (d *Driver) createVmsRequest(imageID string, securityGroupIDs []string, userData []byte) *CreateVmsRequest {
+	req := &CreateVmsRequest{
+		ImageID:          imageID,
+		VmType:           d.VmType,
+		KeypairName:      d.KeypairName,
+		SecurityGroupIDs: securityGroupIDs,
+		SubnetID:         d.SubnetID,
+		BlockDeviceMappings: []BlockDeviceMapping{{
+			DeviceName: rootDeviceName,
+			Bsu: Bsu{
+				VolumeSize:         d.VolumeSize,
+				VolumeType:         d.VolumeType,
+				DeleteOnVmDeletion: true,
+			},
+		}},
+	}
+	// the subregion of a VM in a Net is the one of its subnet
+	if d.Subregion != "" && d.SubnetID == "" {
+		req.Placement = &Placement{SubregionName: d.Subregion}
+	}
+	if len(userData) > 0 {
+		req.UserData = base64.StdEncoding.EncodeToString(userData)
+	}
+	return req
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
+}
+
+// GetIP returns the public IP of the VM, or its private IP with
+// --outscale-private-ip-only
+func (d *Driver) GetIP() (string, error) {
+	if d.VmID == "" {
+		return "", errors.New("VM has not been created")
+	}
+
+	vm, err := d.client().GetVm(d.VmID)
+	if err != nil {
+		return "", err
+	}
+	return vmIP(vm, d.PrivateIPOnly)
+}
+
+func vmIP(vm *Vm, private bool) (string, error) {
+	if private {
+		if vm.PrivateIP == "" {
+			return "", errors.New("VM has no private IP")
+		}
+		return vm.PrivateIP, nil
+	}
+	if vm.PublicIP == "" {
+		return "", errors.New("VM has no public IP")
+	}
+	return vm.PublicIP, nil
+}
+
+// GetState returns a github.com/machine/libmachine/state.State representing the state of the host (running, stopped, etc.)
+func (d *Driver) GetState() (state.State, error) {
+	vm, err := d.client().GetVm(d.VmID)
+	if err != nil {
+		return state.Error, err
+	}
+	return vmState(vm.State), nil
+}
+
+func vmState(s string) state.State {
+	switch s {
+	case "pending":
+		return state.Starting
+	case "running":
+		return state.Running
+	case "stopping", "shutting-down":
+		return state.Stopping
+	case "stopped":
+		return state.Stopped
+	case "terminated", "quarantine":
+		return state.Error
+	}
+	return state.None
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	log.Infof("Starting %s...", d.MachineName)
+	return d.client().StartVm(d.VmID)
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	log.Infof("Stopping %s...", d.MachineName)
+	return d.client().StopVm(d.VmID, false)
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	log.Infof("Killing %s...", d.MachineName)
+	return d.client().StopVm(d.VmID, true)
+}
+
+// Restart a host
+func (d *Driver) Restart() error {
+	log.Infof("Restarting %s...", d.MachineName)
+	return d.client().RebootVm(d.VmID)
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	c := d.client()
+
+	if d.VmID != "" {
+		log.Infof("Deleting VM %s...", d.VmID)
+		if err := c.DeleteVm(d.VmID); err != nil {
+			return err
+		}
+
+		// the public IP can only be released once the VM is gone
+		if d.PublicIPID != "" {
+			if err := mcnutils.WaitForSpecificOrError(func() (bool, error) {
+				vm, err := c.GetVm(d.VmID)
+				if err != nil {
+					return true, nil
+				}
+				return vm.State == "terminated", nil
+			}, vmWaitAttempts, vmWaitInterval); err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.PublicIPID != "" {
+		log.Infof("Releasing public IP %s...", d.PublicIPID)
+		if err := c.DeletePublicIP(d.PublicIPID); err != nil {
+			return err
+		}
+	}
+
+	if d.KeypairName != "" {
+		log.Debugf("Deleting keypair %s...", d.KeypairName)
+		if err := c.DeleteKeypair(d.KeypairName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package outscale
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"outscale-access-key": "AK",
+			"outscale-secret-key": "SK",
+			"outscale-image":      "Ubuntu-22.04",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		d := NewDriver("default", "path").(*Driver)
+		d.AccessKey, d.SecretKey, d.Image = "AK", "SK", "ami-12345678"
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "public cloud", modify: func(d *Driver) {}},
+		{name: "private in net", modify: func(d *Driver) { d.SubnetID, d.PrivateIPOnly = "subnet-1", true }},
+		{name: "no secret key", modify: func(d *Driver) { d.SecretKey = "" }, err: "outscale driver requires the --outscale-access-key and --outscale-secret-key options"},
+		{name: "no image", modify: func(d *Driver) { d.Image = "" }, err: "outscale driver requires the --outscale-image option"},
+		{name: "private in public cloud", modify: func(d *Driver) { d.PrivateIPOnly = true }, err: "--outscale-private-ip-only requires --outscale-subnet-id"},
+		{name: "volume type", modify: func(d *Driver) { d.VolumeType = "ssd" }, err: `--outscale-volume-type must be standard, gp2 or io1, not "ssd"`},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestCreateVmsRequest(t *testing.T) {
+	d := NewDriver("default", "path").(*Driver)
+	d.KeypairName = "default"
+	d.Subregion = "eu-west-2b"
+	d.VolumeSize = 30
+
+	req := d.createVmsRequest("ami-12345678", []string{"sg-1"}, []byte("#cloud-config\n"))
+	assert.Equal(t, "ami-12345678", req.ImageID)
+	assert.Equal(t, defaultVmType, req.VmType)
+	assert.Equal(t, []string{"sg-1"}, req.SecurityGroupIDs)
+	assert.Equal(t, &Placement{SubregionName: "eu-west-2b"}, req.Placement)
+	assert.Equal(t, 30, req.BlockDeviceMappings[0].Bsu.VolumeSize)
+	assert.True(t, req.BlockDeviceMappings[0].Bsu.DeleteOnVmDeletion)
+	assert.Equal(t, "I2Nsb3VkLWNvbmZpZwo=", req.UserData)
+
+	d.SubnetID = "subnet-1"
+	d.VolumeSize = 0
+	req = d.createVmsRequest("ami-12345678", nil, nil)
+	assert.Nil(t, req.Placement)
+	assert.Equal(t, "subnet-1", req.SubnetID)
+
+	b, err := json.Marshal(req)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "VolumeSize")
+	assert.NotContains(t, string(b), "UserData")
+}
+
+func TestVmIP(t *testing.T) {
+	vm := &Vm{}
+	_, err := vmIP(vm, false)
+	assert.Error(t, err)
+
+	vm.PrivateIP, vm.PublicIP = "10.0.0.4", "171.33.1.2"
+	ip, err := vmIP(vm, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "171.33.1.2", ip)
+	ip, err = vmIP(vm, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.4", ip)
+}
+
+func TestVmState(t *testing.T) {
+	assert.Equal(t, state.Starting, vmState("pending"))
+	assert.Equal(t, state.Running, vmState("running"))
+	assert.Equal(t, state.Stopping, vmState("stopping"))
+	assert.Equal(t, state.Stopped, vmState("stopped"))
+	assert.Equal(t, state.Error, vmState("terminated"))
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-2/oapi/aws4_request")
+
+		var in map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+
+		switch r.URL.Path {
+		case "/api/v1/ReadImages":
+			assert.Equal(t, map[string]interface{}{"ImageNames": []interface{}{"Ubuntu-22.04"}}, in["Filters"])
+			fmt.Fprint(w, `{"Images":[{"ImageId":"ami-12345678"}]}`)
+		case "/api/v1/ReadSecurityGroups":
+			fmt.Fprint(w, `{"SecurityGroups":[{"SecurityGroupId":"sg-net","NetId":"vpc-1"},{"SecurityGroupId":"sg-public"}]}`)
+		case "/api/v1/DeleteVms":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"Errors":[{"Code":"5063","Type":"InvalidResource","Details":""}],"ResponseContext":{"RequestId":"R1"}}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"Errors":[{"Code":"4000","Type":"AccessDenied","Details":"Invalid credentials"}],"ResponseContext":{"RequestId":"R2"}}`)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient("eu-west-2", "AK", "SK")
+	c.Endpoint = server.URL + "/api/v1"
+
+	id, err := c.ImageID("Ubuntu-22.04")
+	assert.NoError(t, err)
+	assert.Equal(t, "ami-12345678", id)
+
+	id, err = c.SecurityGroupID("docker-machine", "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "sg-public", id)
+
+	assert.NoError(t, c.DeleteVm("i-12345678"))
+	assert.EqualError(t, c.StartVm("i-12345678"), "StartVms: 4000 AccessDenied: Invalid credentials (request R2)")
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package qemu
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach runs qemu in its own session, so it outlives the driver.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,23 @@
+package qemu
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach runs qemu in its own process group, so it outlives the driver.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// processRunning reports whether the process exists, as opening a process
+// which exited fails.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,569 @@
+package qemu
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/cloudinit"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Driver runs the machine with qemu-system, booting a cloud image configured
+// by cloud-init.
+type Driver struct {
+	*drivers.BaseDriver
+	Binary            string
+	CPU               int
+	Memory            int
+	DiskSize          int
+	CloudImage        string
+	CloudInitUserData string
+	Network           string
+	Bridge            string
+	Accel             string
+	MACAddress        string
+	EnginePort        int
+}
+
+const (
+	defaultBinary     = "qemu-system-x86_64"
+	defaultCPU        = 1
+	defaultMemory     = 1024
+	defaultDiskSize   = 20000
+	defaultCloudImage = "ubuntu"
+	defaultNetwork    = networkUser
+	defaultBridge     = "br0"
+	defaultSSHUser    = cloudinit.DefaultUser
+	defaultEnginePort = 2376
+
+	networkUser   = "user"
+	networkBridge = "bridge"
+
+	qemuImg       = "qemu-img"
+	diskFilename  = "disk.qcow2"
+	pidFilename   = "qemu.pid"
+	logFilename   = "qemu.log"
+	monitorSocket = "monitor.sock"
+
+	stopAttempts = 60
+	stopInterval = time.Second
+)
+
+// GetCreateFlags registers the flags this driver adds to
+// "docker hosts create"
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_BINARY",
+			Name:   "qemu-binary",
+			Usage:  "qemu-system binary to run",
+			Value:  defaultBinary,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "QEMU_CPU_COUNT",
+			Name:   "qemu-cpu-count",
+			Usage:  "Number of CPUs",
+			Value:  defaultCPU,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "QEMU_MEMORY",
+			Name:   "qemu-memory",
+			Usage:  "Size of memory in MB",
+			Value:  defaultMemory,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "QEMU_DISK_SIZE",
+			Name:   "qemu-disk-size",
+			Usage:  "Size of disk in MB",
+			Value:  defaultDiskSize,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_CLOUD_IMAGE",
+			Name:   "qemu-cloud-image",
+			Usage:  "Cloud image with cloud-init: \"ubuntu\", or the URL or the path of a qcow2 or raw image. The disk is a qcow2 overlay backed by the cached image",
+			Value:  defaultCloudImage,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_CLOUD_INIT_USER_DATA",
+			Name:   "qemu-cloud-init-user-data",
+			Usage:  "Path of a cloud-config or a script run on the first boot",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_NETWORK",
+			Name:   "qemu-network",
+			Usage:  "Networking: \"user\" forwards SSH and Docker from localhost, \"bridge\" attaches the VM to --qemu-bridge",
+			Value:  defaultNetwork,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_BRIDGE",
+			Name:   "qemu-bridge",
+			Usage:  "Bridge of the host allowed in the qemu-bridge-helper ACL",
+			Value:  defaultBridge,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_ACCEL",
+			Name:   "qemu-accel",
+			Usage:  "Accelerator: kvm, hvf, whpx or tcg (default is kvm or hvf when available, tcg otherwise)",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "QEMU_SSH_USER",
+			Name:   "qemu-ssh-user",
+			Usage:  "SSH user created by cloud-init",
+			Value:  defaultSSHUser,
+		},
+	}
+}
+
+// NewDriver creates a Driver with the specified machineName and storePath.
+func NewDriver(machineName, storePath string) drivers.Driver {
+	return &Driver{
+		Binary:     defaultBinary,
+		CPU:        defaultCPU,
+		Memory:     defaultMemory,
+		DiskSize:   defaultDiskSize,
+		CloudImage: defaultCloudImage,
+		Network:    defaultNetwork,
+		Bridge:     defaultBridge,
+		EnginePort: defaultEnginePort,
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser:     defaultSSHUser,
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+	}
+}
+
+// DriverName returns the name of the driver
+func (d *Driver) DriverName() string {
+	return "qemu"
+}
+
+// SetConfigFromFlags configures the driver with the object that was returned
+// by RegisterCreateFlags
+func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
+	d.Binary = flags.String("qemu-binary")
+	d.CPU = flags.Int("qemu-cpu-count")
+	d.Memory = flags.Int("qemu-memory")
+	d.DiskSize = flags.Int("qemu-disk-size")
+	d.CloudImage = flags.String("qemu-cloud-image")
+	d.CloudInitUserData = flags.String("qemu-cloud-init-user-data")
+	d.Network = flags.String("qemu-network")
+	d.Bridge = flags.String("qemu-bridge")
+	d.Accel = flags.String("qemu-accel")
+	d.SSHUser = flags.String("qemu-ssh-user")
+	d.SetSwarmConfigFromFlags(flags)
+
+	return d.checkConfig()
+}
+
+func (d *Driver) checkConfig() error {
+	if d.Network != networkUser && d.Network != networkBridge {
+		return fmt.Errorf("--qemu-network must be %q or %q, not %q", networkUser, networkBridge, d.Network)
+	}
+	if d.Network == networkBridge && d.Bridge == "" {
+		return errors.New("--qemu-network bridge requires --qemu-bridge")
+	}
+	if d.CPU < 1 || d.Memory < 1 || d.DiskSize < 1 {
+		return errors.New("--qemu-cpu-count, --qemu-memory and --qemu-disk-size must be positive")
+	}
+	if d.CloudImage == "" {
+		return errors.New("qemu driver requires the --qemu-cloud-image option")
+	}
+	return nil
+}
+
+// PreCreateCheck allows for pre-create operations to make sure a driver is
+// ready for creation
+func (d *Driver) PreCreateCheck() error {
+	for _, binary := range []string{d.Binary, qemuImg} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("%s not found, is QEMU installed?", binary)
+		}
+	}
+	if d.Network == networkBridge && runtime.GOOS != "linux" {
+		return errors.New("--qemu-network bridge is only supported on Linux")
+	}
+	if d.CloudInitUserData != "" {
+		if _, err := os.Stat(d.CloudInitUserData); err != nil {
+			return fmt.Errorf("cloud-init user data %s could not be found", d.CloudInitUserData)
+		}
+	}
+
+	// Downloading the image to cache should be done here to make sure
+	// that a download failure will not leave a machine half created.
+	_, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatQCOW2)
+	return err
+}
+
+// Create a host using the driver's config
+func (d *Driver) Create() error {
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+
+	image, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatQCOW2)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Creating disk backed by %s...", image)
+	if err := d.createDisk(image); err != nil {
+		return err
+	}
+
+	seed, err := cloudinit.NewSeed(d.MachineName, d.GetSSHUsername(), d.GetSSHKeyPath()+".pub", d.CloudInitUserData)
+	if err != nil {
+		return err
+	}
+	if err := seed.WriteISO(d.ResolveStorePath(cloudinit.SeedISOFilename)); err != nil {
+		return err
+	}
+
+	if d.MACAddress, err = randomMACAddress(); err != nil {
+		return err
+	}
+	if d.Network == networkUser {
+		if d.SSHPort, err = freePort(); err != nil {
+			return err
+		}
+		if d.EnginePort == defaultEnginePort {
+			if d.EnginePort, err = freePort(); err != nil {
+				return err
+			}
+		}
+	} else {
+		d.SSHPort = 22
+	}
+
+	return d.Start()
+}
+
+// createDisk creates a qcow2 disk recording the changes to the image, which
+// is shared by the machines created from it and must not change.
+func (d *Driver) createDisk(image string) error {
+	out, err := exec.Command(qemuImg, "info", "--output=json", image).Output()
+	if err != nil {
+		return fmt.Errorf("error reading the image %s: %s", image, err)
+	}
+	info := struct {
+		Format string `json:"format"`
+	}{}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(qemuImg, "create",
+		"-f", "qcow2",
+		"-F", info.Format,
+		"-b", image,
+		d.ResolveStorePath(diskFilename),
+		fmt.Sprintf("%dM", d.DiskSize))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error creating the disk: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// accel returns the accelerator of the VM.
+func (d *Driver) accel() string {
+	if d.Accel != "" {
+		return d.Accel
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+			f.Close()
+			return "kvm"
+		}
+	case "darwin":
+		return "hvf"
+	}
+	return "tcg"
+}
+
+// escape escapes the commas of an option value.
+func escape(value string) string {
+	return strings.Replace(value, ",", ",,", -1)
+}
+
+func (d *Driver) qemuArgs() []string {
+	accel := d.accel()
+	cpu := "max"
+	if accel == "kvm" || accel == "hvf" {
+		cpu = "host"
+	}
+
+	netdev := fmt.Sprintf("bridge,id=net0,br=%s", d.Bridge)
+	if d.Network == networkUser {
+		netdev = fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:22,hostfwd=tcp:127.0.0.1:%d-:%d", d.SSHPort, d.EnginePort, d.EnginePort)
+	}
+
+	return []string{
+		"-name", d.MachineName,
+		"-accel", accel,
+		"-cpu", cpu,
+		"-smp", strconv.Itoa(d.CPU),
+		"-m", strconv.Itoa(d.Memory),
+		"-drive", "file=" + escape(d.ResolveStorePath(diskFilename)) + ",if=virtio,format=qcow2",
+		"-drive", "file=" + escape(d.ResolveStorePath(cloudinit.SeedISOFilename)) + ",media=cdrom,readonly=on",
+		"-netdev", netdev,
+		"-device", "virtio-net-pci,netdev=net0,mac=" + d.MACAddress,
+		"-display", "none",
+		"-serial", "file:" + d.ResolveStorePath("console.log"),
+		"-monitor", "unix:" + d.ResolveStorePath(monitorSocket) + ",server,nowait",
+		"-pidfile", d.ResolveStorePath(pidFilename),
+	}
+}
+
+// Start a host
+func (d *Driver) Start() error {
+	if s, err := d.GetState(); err != nil {
+		return err
+	} else if s == state.Running {
+		log.Infof("VM is already running")
+		return nil
+	}
+
+	logFile, err := os.Create(d.ResolveStorePath(logFilename))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	log.Infof("Starting %s...", d.MachineName)
+	cmd := exec.Command(d.Binary, d.qemuArgs()...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	log.Debugf("executing: %s %s", d.Binary, strings.Join(cmd.Args[1:], " "))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// qemu exits right away on invalid options or a busy port
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		out, _ := ioutil.ReadFile(d.ResolveStorePath(logFilename))
+		return fmt.Errorf("qemu exited: %v: %s", err, strings.TrimSpace(string(out)))
+	case <-time.After(2 * time.Second):
+	}
+
+	if d.Network == networkUser {
+		d.IPAddress = "127.0.0.1"
+		return nil
+	}
+
+	log.Infof("Waiting for an IP...")
+	if err := mcnutils.WaitFor(func() bool {
+		ip, err := d.GetIP()
+		return err == nil && ip != ""
+	}); err != nil {
+		return fmt.Errorf("no IP found for %s on bridge %s: %s", d.MACAddress, d.Bridge, err)
+	}
+	d.IPAddress, err = d.GetIP()
+	return err
+}
+
+// monitor runs a command in the QEMU monitor of the VM.
+func (d *Driver) monitor(command string) error {
+	conn, err := net.DialTimeout("unix", d.ResolveStorePath(monitorSocket), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Debugf("executing monitor command: %s", command)
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
+		return err
+	}
+
+	// wait for the command to be read, the monitor closes on quit
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(ioutil.Discard, conn)
+	return nil
+}
+
+func (d *Driver) waitStopped() error {
+	return mcnutils.WaitForSpecific(func() bool {
+		s, err := d.GetState()
+		return err == nil && s == state.Stopped
+	}, stopAttempts, stopInterval)
+}
+
+// Stop a host gracefully
+func (d *Driver) Stop() error {
+	if err := d.monitor("system_powerdown"); err != nil {
+		return err
+	}
+	if err := d.waitStopped(); err != nil {
+		return fmt.Errorf("VM did not stop: %s", err)
+	}
+	d.IPAddress = ""
+	return nil
+}
+
+// Restart a host.
+func (d *Driver) Restart() error {
+	if err := d.Stop(); err != nil {
+		return err
+	}
+	return d.Start()
+}
+
+// Kill stops a host forcefully
+func (d *Driver) Kill() error {
+	pid, err := d.pid()
+	if err != nil || pid == 0 {
+		return err
+	}
+
+	if err := d.monitor("quit"); err != nil {
+		log.Debugf("Killing qemu, the monitor is not available: %s", err)
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	}
+	d.IPAddress = ""
+	return d.waitStopped()
+}
+
+// Remove a host
+func (d *Driver) Remove() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if s == state.Running {
+		return d.Kill()
+	}
+	return nil
+}
+
+// pid returns the PID of qemu, or 0 if it is not running.
+func (d *Driver) pid() (int, error) {
+	data, err := ioutil.ReadFile(d.ResolveStorePath(pidFilename))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %s: %s", d.ResolveStorePath(pidFilename), err)
+	}
+	if !processRunning(pid) {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// GetState returns the state that the host is in (running, stopped, etc)
+func (d *Driver) GetState() (state.State, error) {
+	pid, err := d.pid()
+	if err != nil {
+		return state.Error, err
+	}
+	if pid == 0 {
+		return state.Stopped, nil
+	}
+	return state.Running, nil
+}
+
+// GetIP returns an IP or hostname that this host is available at
+func (d *Driver) GetIP() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	if d.Network == networkUser {
+		return "127.0.0.1", nil
+	}
+
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ipFromARP(f, d.MACAddress)
+}
+
+// ipFromARP returns the IP of the MAC address in /proc/net/arp.
+func ipFromARP(r io.Reader, mac string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// IP address, HW type, Flags, HW address, Mask, Device
+		if len(fields) >= 4 && strings.EqualFold(fields[3], mac) {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no IP found for %s", mac)
+}
+
+// GetSSHHostname returns the hostname to use with SSH
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetURL returns a Docker compatible host URL for connecting to this host
+// e.g tcp://10.1.2.3:2376
+func (d *Driver) GetURL() (string, error) {
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.EnginePort))), nil
+}
+
+// SetEnginePort sets the port of the Docker daemon, forwarded as is rather
+// than from a free port with the user network.
+func (d *Driver) SetEnginePort(port int) error {
+	d.EnginePort = port
+	return nil
+}
+
+// randomMACAddress returns a random address in the range of QEMU.
+func randomMACAddress() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", b[0], b[1], b[2]), nil
+}
+
+// freePort returns a port of localhost which is not in use.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
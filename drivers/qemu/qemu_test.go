@@ -0,0 +1,124 @@
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetConfigFromFlags(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "docker", driver.GetSSHUsername())
+}
+
+func TestCheckConfig(t *testing.T) {
+	base := func() *Driver {
+		return NewDriver("default", "path").(*Driver)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Driver)
+		err    string
+	}{
+		{name: "defaults", modify: func(d *Driver) {}},
+		{name: "bridge", modify: func(d *Driver) { d.Network = "bridge" }},
+		{name: "network", modify: func(d *Driver) { d.Network = "tap" }, err: `--qemu-network must be "user" or "bridge", not "tap"`},
+		{name: "bridge without name", modify: func(d *Driver) { d.Network, d.Bridge = "bridge", "" }, err: "--qemu-network bridge requires --qemu-bridge"},
+		{name: "memory", modify: func(d *Driver) { d.Memory = 0 }, err: "--qemu-cpu-count, --qemu-memory and --qemu-disk-size must be positive"},
+		{name: "no image", modify: func(d *Driver) { d.CloudImage = "" }, err: "qemu driver requires the --qemu-cloud-image option"},
+	}
+
+	for _, test := range tests {
+		d := base()
+		test.modify(d)
+		err := d.checkConfig()
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestQemuArgs(t *testing.T) {
+	d := NewDriver("default", "/store,1").(*Driver)
+	d.Accel = "kvm"
+	d.MACAddress = "52:54:00:12:34:56"
+	d.SSHPort = 40022
+	d.EnginePort = 42376
+
+	assert.Equal(t, []string{
+		"-name", "default",
+		"-accel", "kvm",
+		"-cpu", "host",
+		"-smp", "1",
+		"-m", "1024",
+		"-drive", "file=/store,,1/machines/default/disk.qcow2,if=virtio,format=qcow2",
+		"-drive", "file=/store,,1/machines/default/seed.iso,media=cdrom,readonly=on",
+		"-netdev", "user,id=net0,hostfwd=tcp:127.0.0.1:40022-:22,hostfwd=tcp:127.0.0.1:42376-:42376",
+		"-device", "virtio-net-pci,netdev=net0,mac=52:54:00:12:34:56",
+		"-display", "none",
+		"-serial", "file:/store,1/machines/default/console.log",
+		"-monitor", "unix:/store,1/machines/default/monitor.sock,server,nowait",
+		"-pidfile", "/store,1/machines/default/qemu.pid",
+	}, d.qemuArgs())
+
+	d.Network, d.Accel = "bridge", "tcg"
+	args := d.qemuArgs()
+	assert.Equal(t, "max", args[5])
+	assert.Equal(t, "bridge,id=net0,br=br0", args[15])
+}
+
+func TestIPFromARP(t *testing.T) {
+	arp := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        br0
+192.168.1.23     0x1         0x2         52:54:00:12:34:56     *        br0
+`
+	ip, err := ipFromARP(strings.NewReader(arp), "52:54:00:12:34:56")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.23", ip)
+
+	_, err = ipFromARP(strings.NewReader(arp), "52:54:00:00:00:01")
+	assert.EqualError(t, err, "no IP found for 52:54:00:00:00:01")
+}
+
+func TestGetState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qemu")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	d := NewDriver("default", dir).(*Driver)
+	s, err := d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Stopped, s)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "machines", "default"), 0700))
+	assert.NoError(t, ioutil.WriteFile(d.ResolveStorePath(pidFilename), []byte(strconv.Itoa(os.Getpid())+"\n"), 0600))
+	s, err = d.GetState()
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, s)
+}
+
+func TestRandomMACAddress(t *testing.T) {
+	mac, err := randomMACAddress()
+	assert.NoError(t, err)
+	assert.Regexp(t, "^52:54:00:[0-9a-f]{2}:[0-9a-f]{2}:[0-9a-f]{2}$", mac)
+}
@@ -16,7 +16,7 @@ import (
 type Driver struct {
 	*openstack.Driver
 
-	APIKey string
+	APIKey string `secret:"true"`
 }
 
 const (
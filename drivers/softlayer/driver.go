@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/rancher/machine/libmachine/drivers"
@@ -309,7 +310,7 @@ func (d *Driver) GetURL() (string, error) {
 		return "", nil
 	}
 
-	return "tcp://" + net.JoinHostPort(ip, "2376"), nil
+	return "tcp://" + net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort())), nil
 }
 
 func (d *Driver) GetIP() (string, error) {
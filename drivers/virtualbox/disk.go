@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 
+	"github.com/rancher/machine/libmachine/cloudinit"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnutils"
 )
@@ -39,6 +40,26 @@ func (c *defaultDiskCreator) Create(size int, publicSSHKeyPath, diskPath string)
 	return createDiskImage(diskPath, size, tarBuf)
 }
 
+// SeedCreator creates the cloud-init seed ISO of a VM booting a cloud image.
+type SeedCreator interface {
+	CreateSeed(hostname, publicSSHKeyPath, userDataPath, isoPath string) error
+}
+
+func NewSeedCreator() SeedCreator {
+	return &defaultSeedCreator{}
+}
+
+type defaultSeedCreator struct{}
+
+func (c *defaultSeedCreator) CreateSeed(hostname, publicSSHKeyPath, userDataPath, isoPath string) error {
+	seed, err := cloudinit.NewSeed(hostname, cloudinit.DefaultUser, publicSSHKeyPath, userDataPath)
+	if err != nil {
+		return err
+	}
+
+	return seed.WriteISO(isoPath)
+}
+
 // createDiskImage makes a disk image at dest with the given size in MB. If r is
 // not nil, it will be read as a raw disk image to convert from.
 func createDiskImage(dest string, size int, r io.Reader) error {
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rancher/machine/libmachine/cloudinit"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
@@ -50,6 +51,7 @@ type Driver struct {
 	b2dUpdater          B2DUpdater
 	sshKeyGenerator     SSHKeyGenerator
 	diskCreator         DiskCreator
+	seedCreator         SeedCreator
 	logsReader          LogsReader
 	ipWaiter            IPWaiter
 	randomInter         RandomInter
@@ -60,6 +62,8 @@ type Driver struct {
 	NatNicType          string
 	Boot2DockerURL      string
 	Boot2DockerImportVM string
+	CloudImage          string
+	CloudInitUserData   string
 	HostDNSResolver     bool
 	HostOnlyCIDR        string
 	HostOnlyNicType     string
@@ -79,6 +83,7 @@ func NewDriver(hostName, storePath string) *Driver {
 		b2dUpdater:          NewB2DUpdater(),
 		sshKeyGenerator:     NewSSHKeyGenerator(),
 		diskCreator:         NewDiskCreator(),
+		seedCreator:         NewSeedCreator(),
 		logsReader:          NewLogsReader(),
 		ipWaiter:            NewIPWaiter(),
 		randomInter:         NewRandomInter(),
@@ -136,6 +141,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Value:  defaultBoot2DockerImportVM,
 			EnvVar: "VIRTUALBOX_BOOT2DOCKER_IMPORT_VM",
 		},
+		mcnflag.StringFlag{
+			Name:   "virtualbox-cloud-image",
+			Usage:  "Boot a cloud image instead of boot2docker: \"ubuntu\", or the URL or the path of a VMDK, VDI or VHD image with cloud-init",
+			EnvVar: "VIRTUALBOX_CLOUD_IMAGE",
+		},
+		mcnflag.StringFlag{
+			Name:   "virtualbox-cloud-init-user-data",
+			Usage:  "Path of a cloud-config or a script run on the first boot of the cloud image",
+			EnvVar: "VIRTUALBOX_CLOUD_INIT_USER_DATA",
+		},
 		mcnflag.BoolFlag{
 			Name:   "virtualbox-host-dns-resolver",
 			Usage:  "Use the host DNS resolver",
@@ -224,7 +239,7 @@ func (d *Driver) GetURL() (string, error) {
 	if ip == "" {
 		return "", nil
 	}
-	return fmt.Sprintf("tcp://%s:2376", ip), nil
+	return mcnutils.DockerURL(ip, d.GetEnginePort()), nil
 }
 
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
@@ -235,6 +250,8 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.SetSwarmConfigFromFlags(flags)
 	d.SSHUser = "docker"
 	d.Boot2DockerImportVM = flags.String("virtualbox-import-boot2docker-vm")
+	d.CloudImage = flags.String("virtualbox-cloud-image")
+	d.CloudInitUserData = flags.String("virtualbox-cloud-init-user-data")
 	d.HostDNSResolver = flags.Bool("virtualbox-host-dns-resolver")
 	d.NatNicType = flags.String("virtualbox-nat-nictype")
 	d.HostOnlyCIDR = flags.String("virtualbox-hostonly-cidr")
@@ -247,6 +264,13 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.NoVTXCheck = flags.Bool("virtualbox-no-vtx-check")
 	d.ShareFolder = flags.String("virtualbox-share-folder")
 
+	if d.CloudImage != "" && (d.Boot2DockerURL != "" || d.Boot2DockerImportVM != "") {
+		return errors.New("--virtualbox-cloud-image cannot be used with --virtualbox-boot2docker-url or --virtualbox-import-boot2docker-vm")
+	}
+	if d.CloudInitUserData != "" && d.CloudImage == "" {
+		return errors.New("--virtualbox-cloud-init-user-data requires --virtualbox-cloud-image")
+	}
+
 	return nil
 }
 
@@ -273,9 +297,13 @@ func (d *Driver) PreCreateCheck() error {
 		}
 	}
 
-	// Downloading boot2docker to cache should be done here to make sure
+	// Downloading the image to cache should be done here to make sure
 	// that a download failure will not leave a machine half created.
-	if err := d.b2dUpdater.UpdateISOCache(d.StorePath, d.Boot2DockerURL); err != nil {
+	if d.CloudImage != "" {
+		if _, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVMDK); err != nil {
+			return err
+		}
+	} else if err := d.b2dUpdater.UpdateISOCache(d.StorePath, d.Boot2DockerURL); err != nil {
 		return err
 	}
 
@@ -297,14 +325,20 @@ func (d *Driver) Create() error {
 }
 
 func (d *Driver) CreateVM() error {
-	if err := d.b2dUpdater.CopyIsoToMachineDir(d.StorePath, d.MachineName, d.Boot2DockerURL); err != nil {
-		return err
+	if d.CloudImage == "" {
+		if err := d.b2dUpdater.CopyIsoToMachineDir(d.StorePath, d.MachineName, d.Boot2DockerURL); err != nil {
+			return err
+		}
 	}
 
 	log.Info("Creating VirtualBox VM...")
 
-	// import b2d VM if requested
-	if d.Boot2DockerImportVM != "" {
+	if d.CloudImage != "" {
+		if err := d.createCloudDisk(); err != nil {
+			return err
+		}
+	} else if d.Boot2DockerImportVM != "" {
+		// import b2d VM if requested
 		name := d.Boot2DockerImportVM
 
 		// make sure vm is stopped
@@ -399,8 +433,17 @@ func (d *Driver) CreateVM() error {
 		"--nestedpaging", "on",
 		"--largepages", "on",
 		"--vtxvpid", "on",
-		"--accelerate3d", "off",
-		"--boot1", "dvd"}
+		"--accelerate3d", "off"}
+
+	if d.CloudImage != "" {
+		// Cloud images log to the serial console and wait for it.
+		modifyFlags = append(modifyFlags,
+			"--boot1", "disk",
+			"--uart1", "0x3F8", "4",
+			"--uartmode1", "file", d.ResolveStorePath("console.log"))
+	} else {
+		modifyFlags = append(modifyFlags, "--boot1", "dvd")
+	}
 
 	if runtime.GOOS == "windows" && runtime.GOARCH == "386" {
 		modifyFlags = append(modifyFlags, "--longmode", "on")
@@ -429,7 +472,7 @@ func (d *Driver) CreateVM() error {
 		"--port", "0",
 		"--device", "0",
 		"--type", "dvddrive",
-		"--medium", d.ResolveStorePath("boot2docker.iso")); err != nil {
+		"--medium", d.isoPath()); err != nil {
 		return err
 	}
 
@@ -806,9 +849,45 @@ func (d *Driver) publicSSHKeyPath() string {
 }
 
 func (d *Driver) diskPath() string {
+	if d.CloudImage != "" {
+		// VirtualBox can only resize VDI and VHD disks.
+		return d.ResolveStorePath("disk.vdi")
+	}
 	return d.ResolveStorePath("disk.vmdk")
 }
 
+func (d *Driver) isoPath() string {
+	if d.CloudImage != "" {
+		return d.ResolveStorePath(cloudinit.SeedISOFilename)
+	}
+	return d.ResolveStorePath("boot2docker.iso")
+}
+
+// createCloudDisk clones the cloud image to the disk of the VM, grown to the
+// disk size, and writes the seed ISO which configures it on the first boot.
+func (d *Driver) createCloudDisk() error {
+	log.Infof("Creating SSH key...")
+	if err := d.sshKeyGenerator.Generate(d.GetSSHKeyPath()); err != nil {
+		return err
+	}
+
+	image, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVMDK)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Creating disk image from %s...", image)
+	if err := d.vbm("clonemedium", "disk", image, d.diskPath(), "--format", "VDI"); err != nil {
+		return err
+	}
+	if err := d.vbm("modifymedium", "disk", d.diskPath(), "--resize", strconv.Itoa(d.DiskSize)); err != nil {
+		return err
+	}
+
+	log.Debugf("Creating cloud-init seed...")
+	return d.seedCreator.CreateSeed(d.MachineName, d.publicSSHKeyPath(), d.CloudInitUserData, d.isoPath())
+}
+
 func (d *Driver) setupHostOnlyNetwork(machineName string) (*hostOnlyNetwork, error) {
 	hostOnlyCIDR := d.HostOnlyCIDR
 
@@ -443,6 +443,11 @@ func (v *MockCreateOperations) Create(size int, publicSSHKeyPath, diskPath strin
 	return err
 }
 
+func (v *MockCreateOperations) CreateSeed(hostname, publicSSHKeyPath, userDataPath, isoPath string) error {
+	_, err := v.doCall("CreateSeed " + fmt.Sprintf("%s %s %s %s", hostname, publicSSHKeyPath, userDataPath, isoPath))
+	return err
+}
+
 func (v *MockCreateOperations) Read(path string) ([]string, error) {
 	_, err := v.doCall("Read " + path)
 	return []string{}, err
@@ -506,6 +511,7 @@ func mockCalls(t *testing.T, driver *Driver, expectedCalls []Call) {
 	driver.b2dUpdater = mockOperations
 	driver.sshKeyGenerator = mockOperations
 	driver.diskCreator = mockOperations
+	driver.seedCreator = mockOperations
 	driver.logsReader = mockOperations
 	driver.ipWaiter = mockOperations
 	driver.randomInter = mockOperations
@@ -574,6 +580,62 @@ func TestCreateVMWithSpecificNatNicType(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCreateVMFromCloudImage(t *testing.T) {
+	shareName, shareDir := getShareDriveAndName()
+
+	modifyVMcommand := "vbm modifyvm default --firmware bios --bioslogofadein off --bioslogofadeout off --bioslogodisplaytime 0 --biosbootmenu disabled --ostype Linux26_64 --cpus 1 --memory 1024 --acpi on --ioapic on --rtcuseutc on --natdnshostresolver1 off --natdnsproxy1 on --cpuhotplug off --pae on --hpet on --hwvirtex on --nestedpaging on --largepages on --vtxvpid on --accelerate3d off --boot1 disk --uart1 0x3F8 4 --uartmode1 file path/machines/default/console.log"
+	if runtime.GOOS == "windows" && runtime.GOARCH == "386" {
+		modifyVMcommand += " --longmode on"
+	}
+
+	driver := NewDriver("default", "path")
+	mockCalls(t, driver, []Call{
+		{"Generate path/machines/default/id_rsa", "", nil},
+		{"vbm clonemedium disk /images/ubuntu.vmdk path/machines/default/disk.vdi --format VDI", "", nil},
+		{"vbm modifymedium disk path/machines/default/disk.vdi --resize 20000", "", nil},
+		{"CreateSeed default path/machines/default/id_rsa.pub /images/user-data path/machines/default/seed.iso", "", nil},
+		{"vbm createvm --basefolder path/machines/default --name default --register", "", nil},
+		{modifyVMcommand, "", nil},
+		{"vbm modifyvm default --nic1 nat --nictype1 82540EM --cableconnected1 on", "", nil},
+		{"vbm storagectl default --name SATA --add sata --hostiocache on", "", nil},
+		{"vbm storageattach default --storagectl SATA --port 0 --device 0 --type dvddrive --medium path/machines/default/seed.iso", "", nil},
+		{"vbm storageattach default --storagectl SATA --port 1 --device 0 --type hdd --medium path/machines/default/disk.vdi", "", nil},
+		{"vbm guestproperty set default /VirtualBox/GuestAdd/SharedFolders/MountPrefix /", "", nil},
+		{"vbm guestproperty set default /VirtualBox/GuestAdd/SharedFolders/MountDir /", "", nil},
+		{"vbm sharedfolder add default --name " + shareName + " --hostpath " + shareDir + " --automount", "", nil},
+		{"vbm setextradata default VBoxInternal2/SharedFoldersEnableSymlinksCreate/" + shareName + " 1", "", nil},
+	})
+	driver.Boot2DockerURL = ""
+	driver.CloudImage = "/images/ubuntu.vmdk"
+	driver.CloudInitUserData = "/images/user-data"
+
+	err := driver.CreateVM()
+
+	assert.NoError(t, err)
+}
+
+func TestSetConfigFromFlagsCloudImage(t *testing.T) {
+	driver := newTestDriver("default")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"virtualbox-cloud-image":          "ubuntu",
+			"virtualbox-cloud-init-user-data": "/images/user-data",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "ubuntu", driver.CloudImage)
+	assert.Equal(t, "/images/user-data", driver.CloudInitUserData)
+
+	checkFlags.FlagsValues["virtualbox-boot2docker-url"] = "http://b2d.org"
+	assert.EqualError(t, driver.SetConfigFromFlags(checkFlags), "--virtualbox-cloud-image cannot be used with --virtualbox-boot2docker-url or --virtualbox-import-boot2docker-vm")
+}
+
 func TestStart(t *testing.T) {
 	driver := NewDriver("default", "path")
 	mockCalls(t, driver, []Call{
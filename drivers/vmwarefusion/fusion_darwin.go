@@ -8,6 +8,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -15,10 +16,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/rancher/machine/libmachine/cloudinit"
 	"github.com/rancher/machine/libmachine/drivers"
 	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
 	"github.com/rancher/machine/libmachine/log"
@@ -45,10 +48,13 @@ type Driver struct {
 	ISO            string
 	Boot2DockerURL string
 
-	SSHPassword    string
+	SSHPassword    string `secret:"true"`
 	ConfigDriveISO string
 	ConfigDriveURL string
 	NoShare        bool
+
+	CloudImage        string
+	CloudInitUserData string
 }
 
 const (
@@ -75,6 +81,18 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Fusion URL for cloud-init configdrive",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "FUSION_CLOUD_IMAGE",
+			Name:   "vmwarefusion-cloud-image",
+			Usage:  "Fusion cloud image booted instead of boot2docker: \"ubuntu\", or the URL or the path of a VMDK image with cloud-init",
+			Value:  "",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "FUSION_CLOUD_INIT_USER_DATA",
+			Name:   "vmwarefusion-cloud-init-user-data",
+			Usage:  "Path of a cloud-config or a script run on the first boot of the cloud image",
+			Value:  "",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "FUSION_CPU_COUNT",
 			Name:   "vmwarefusion-cpu-count",
@@ -174,7 +192,12 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.DiskSize = flags.Int("vmwarefusion-disk-size")
 	d.Boot2DockerURL = flags.String("vmwarefusion-boot2docker-url")
 	d.ConfigDriveURL = flags.String("vmwarefusion-configdrive-url")
+	d.CloudImage = flags.String("vmwarefusion-cloud-image")
+	d.CloudInitUserData = flags.String("vmwarefusion-cloud-init-user-data")
 	d.ISO = d.ResolveStorePath(isoFilename)
+	if d.CloudImage != "" {
+		d.ISO = d.ResolveStorePath(cloudinit.SeedISOFilename)
+	}
 	d.ConfigDriveISO = d.ResolveStorePath(isoConfigDrive)
 	d.SetSwarmConfigFromFlags(flags)
 	d.SSHUser = flags.String("vmwarefusion-ssh-user")
@@ -191,6 +214,13 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		d.CPU = 16
 	}
 
+	if d.CloudImage != "" && (d.Boot2DockerURL != "" || d.ConfigDriveURL != "") {
+		return errors.New("--vmwarefusion-cloud-image cannot be used with --vmwarefusion-boot2docker-url or --vmwarefusion-configdrive-url")
+	}
+	if d.CloudInitUserData != "" && d.CloudImage == "" {
+		return errors.New("--vmwarefusion-cloud-init-user-data requires --vmwarefusion-cloud-image")
+	}
+
 	return nil
 }
 
@@ -202,7 +232,7 @@ func (d *Driver) GetURL() (string, error) {
 	if ip == "" {
 		return "", nil
 	}
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) GetIP() (string, error) {
@@ -248,8 +278,13 @@ func (d *Driver) GetState() (state.State, error) {
 
 // PreCreateCheck checks that the machine creation process can be started safely.
 func (d *Driver) PreCreateCheck() error {
-	// Downloading boot2docker to cache should be done here to make sure
+	// Downloading the image to cache should be done here to make sure
 	// that a download failure will not leave a machine half created.
+	if d.CloudImage != "" {
+		_, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVMDK)
+		return err
+	}
+
 	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
 
 	return b2dutils.UpdateISOCache(d.Boot2DockerURL)
@@ -257,8 +292,10 @@ func (d *Driver) PreCreateCheck() error {
 
 func (d *Driver) Create() error {
 	b2dutils := mcnutils.NewB2dUtils(d.StorePath)
-	if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
-		return err
+	if d.CloudImage == "" {
+		if err := b2dutils.CopyIsoToMachineDir(d.Boot2DockerURL, d.MachineName); err != nil {
+			return err
+		}
 	}
 
 	// download cloud-init config drive
@@ -282,6 +319,16 @@ func (d *Driver) Create() error {
 		return ErrMachineExist
 	}
 
+	if d.CloudImage != "" {
+		seed, err := cloudinit.NewSeed(d.MachineName, d.GetSSHUsername(), d.publicSSHKeyPath(), d.CloudInitUserData)
+		if err != nil {
+			return err
+		}
+		if err := seed.WriteISO(d.ISO); err != nil {
+			return err
+		}
+	}
+
 	// Generate vmx config file from template
 	vmxt := template.Must(template.New("vmx").Parse(vmx))
 	vmxfile, err := os.Create(d.vmxPath())
@@ -297,7 +344,15 @@ func (d *Driver) Create() error {
 			return err
 		}
 
-		if err := vdiskmanager(diskImg, d.DiskSize); err != nil {
+		if d.CloudImage != "" {
+			image, err := cloudinit.CacheImage(d.StorePath, d.CloudImage, cloudinit.FormatVMDK)
+			if err != nil {
+				return err
+			}
+			if err := vdiskmanagerCopy(image, diskImg, d.DiskSize); err != nil {
+				return err
+			}
+		} else if err := vdiskmanager(diskImg, d.DiskSize); err != nil {
 			return err
 		}
 	}
@@ -321,7 +376,7 @@ func (d *Driver) Create() error {
 
 		if ip != "" {
 			log.Debugf("Got an ip: %s", ip)
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, 22), time.Duration(2*time.Second))
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, "22"), time.Duration(2*time.Second))
 			if err != nil {
 				log.Debugf("SSH Daemon not responding yet: %s", err)
 				time.Sleep(2 * time.Second)
@@ -339,6 +394,12 @@ func (d *Driver) Create() error {
 	// we got an IP, let's copy ssh keys over
 	d.IPAddress = ip
 
+	// cloud-init installed the ssh key already
+	if d.CloudImage != "" {
+		log.Debugf("Leaving create sequence early, cloud image found")
+		return nil
+	}
+
 	// Do not execute the rest of boot2docker specific configuration
 	// The upload of the public ssh key uses a ssh connection,
 	// this works without installed vmware client tools
@@ -443,7 +504,7 @@ func (d *Driver) Start() error {
 	vmrun("start", d.vmxPath(), "nogui")
 
 	// Do not execute the rest of boot2docker specific configuration, exit here
-	if d.ConfigDriveURL != "" {
+	if d.ConfigDriveURL != "" || d.CloudImage != "" {
 		log.Debugf("Leaving start sequence early, configdrive found")
 		return nil
 	}
@@ -785,7 +846,7 @@ func executeSSHCommand(command string, d *Driver) error {
 		},
 	}
 
-	client, err := cryptossh.Dial("tcp", fmt.Sprintf("%s:%d", d.IPAddress, d.SSHPort), config)
+	client, err := cryptossh.Dial("tcp", net.JoinHostPort(d.IPAddress, strconv.Itoa(d.SSHPort)), config)
 	if err != nil {
 		log.Debugf("Failed to dial:", err)
 		return err
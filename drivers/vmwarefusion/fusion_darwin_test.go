@@ -20,3 +20,23 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestSetConfigFromFlagsCloudImage(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarefusion-cloud-image": "ubuntu",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+	assert.Equal(t, "path/machines/default/seed.iso", driver.(*Driver).ISO)
+
+	checkFlags.FlagsValues["vmwarefusion-configdrive-url"] = "http://configdrive.local/configdrive.iso"
+	assert.EqualError(t, driver.SetConfigFromFlags(checkFlags), "--vmwarefusion-cloud-image cannot be used with --vmwarefusion-boot2docker-url or --vmwarefusion-configdrive-url")
+}
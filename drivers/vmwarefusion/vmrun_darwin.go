@@ -76,3 +76,26 @@ func vdiskmanager(dest string, size int) error {
 	}
 	return nil
 }
+
+// Copy the vmdk disk image src to dest and grow it to the given size (in MB).
+func vdiskmanagerCopy(src, dest string, size int) error {
+	for _, args := range [][]string{
+		{"-r", src, "-t", "0", dest},
+		{"-x", fmt.Sprintf("%dMB", size), dest},
+	} {
+		cmd := exec.Command(vdiskmanbin, args...)
+		if os.Getenv("MACHINE_DEBUG") != "" {
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		}
+
+		log.Debugf("executing: %v %v", vdiskmanbin, strings.Join(args, " "))
+		if err := cmd.Run(); err != nil {
+			if ee, ok := err.(*exec.Error); ok && ee == exec.ErrNotFound {
+				return ErrVMRUNNotFound
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,514 @@
+package vmwarevcloudair
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/vmware/govcloudair"
+	types "github.com/vmware/govcloudair/types/v56"
+)
+
+const (
+	defaultAPIVersion = "33.0"
+
+	// govcloudair pins every request to the vCloud Air API version
+	govcloudairAPIVersion = "version=5.6"
+
+	vcdAuthHeader = "x-vcloud-authorization"
+
+	orgListType = "application/vnd.vmware.vcloud.orgList+xml"
+	vdcType     = "application/vnd.vmware.vcloud.vdc+xml"
+	diskType    = "application/vnd.vmware.vcloud.disk+xml"
+)
+
+// apiVersionTransport rewrites the API version govcloudair sends in the
+// Accept header, modern vCloud Director releases no longer serve 5.6.
+type apiVersionTransport struct {
+	version string
+	base    http.RoundTripper
+}
+
+func (t *apiVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if accept := req.Header.Get("Accept"); strings.Contains(accept, govcloudairAPIVersion) {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept", strings.Replace(accept, govcloudairAPIVersion, "version="+t.version, 1))
+	}
+	return t.base.RoundTrip(req)
+}
+
+type vcdSession struct {
+	Link []*types.Link `xml:"Link"`
+}
+
+type vcdOrgList struct {
+	Org []*types.Reference `xml:"Org"`
+}
+
+// authenticate logs in to vCloud Air or, when a vCloud Director URL is set,
+// straight to the organization on that vCloud Director.
+func (d *Driver) authenticate() (*govcloudair.Client, govcloudair.Vdc, error) {
+	p, err := govcloudair.NewClient()
+	if err != nil {
+		return nil, govcloudair.Vdc{}, err
+	}
+
+	if d.URL == "" {
+		v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+		return p, v, err
+	}
+
+	v, err := d.authenticateVCD(p)
+	return p, v, err
+}
+
+func (d *Driver) authenticateVCD(p *govcloudair.Client) (govcloudair.Vdc, error) {
+	base, err := url.ParseRequestURI(strings.TrimSuffix(d.URL, "/"))
+	if err != nil {
+		return govcloudair.Vdc{}, fmt.Errorf("cannot parse vCloud Director URL %q: %s", d.URL, err)
+	}
+
+	p.Http.Transport = &apiVersionTransport{version: d.APIVersion, base: p.Http.Transport}
+
+	s := *base
+	s.Path += "/sessions"
+	req := p.NewRequest(map[string]string{}, "POST", s, nil)
+	req.SetBasicAuth(d.UserName+"@"+d.Org, d.UserPassword)
+	req.Header.Add("Accept", "application/*+xml;"+govcloudairAPIVersion)
+
+	session := &vcdSession{}
+	resp, err := vcdCheckResp(p.Http.Do(req))
+	if err != nil {
+		return govcloudair.Vdc{}, fmt.Errorf("error logging in to vCloud Director: %s", err)
+	}
+	p.VCDAuthHeader = vcdAuthHeader
+	p.VCDToken = resp.Header.Get(vcdAuthHeader)
+	if err := vcdDecodeBody(resp, session); err != nil {
+		return govcloudair.Vdc{}, err
+	}
+
+	orgs := &vcdOrgList{}
+	if err := vcdGet(p, findLink(session.Link, orgListType, ""), orgs); err != nil {
+		return govcloudair.Vdc{}, fmt.Errorf("error listing organizations: %s", err)
+	}
+
+	var orgHREF string
+	for _, o := range orgs.Org {
+		if strings.EqualFold(o.Name, d.Org) {
+			orgHREF = o.HREF
+		}
+	}
+	if orgHREF == "" {
+		return govcloudair.Vdc{}, fmt.Errorf("organization %q not found", d.Org)
+	}
+
+	org := &types.Org{}
+	if err := vcdGet(p, orgHREF, org); err != nil {
+		return govcloudair.Vdc{}, fmt.Errorf("error retrieving organization: %s", err)
+	}
+
+	vdcHREF := findLink(org.Link, vdcType, d.VDCID)
+	if vdcHREF == "" {
+		return govcloudair.Vdc{}, fmt.Errorf("VDC %q not found in organization %q", d.VDCID, d.Org)
+	}
+	u, err := url.ParseRequestURI(vdcHREF)
+	if err != nil {
+		return govcloudair.Vdc{}, err
+	}
+	p.VCDVDCHREF = *u
+
+	v := govcloudair.NewVdc(p)
+	v.Vdc.HREF = vdcHREF
+	if err := v.Refresh(); err != nil {
+		return govcloudair.Vdc{}, fmt.Errorf("error Acquiring VDC: %s", err)
+	}
+
+	return *v, nil
+}
+
+func (d *Driver) endpointName() string {
+	if d.URL == "" {
+		return "vCloud Air"
+	}
+	return "vCloud Director"
+}
+
+// disconnect ends the session opened by authenticate.
+func (d *Driver) disconnect(p *govcloudair.Client) error {
+	if d.URL == "" {
+		return p.Disconnect()
+	}
+
+	u, err := url.ParseRequestURI(strings.TrimSuffix(d.URL, "/") + "/session")
+	if err != nil {
+		return err
+	}
+	if _, err := vcdCheckResp(p.Http.Do(p.NewRequest(map[string]string{}, "DELETE", *u, nil))); err != nil {
+		return fmt.Errorf("error processing session delete for vCloud Director: %s", err)
+	}
+	return nil
+}
+
+func findLink(links []*types.Link, linkType, name string) string {
+	for _, l := range links {
+		if l.Type == linkType && (name == "" || l.Name == name) {
+			return l.HREF
+		}
+	}
+	return ""
+}
+
+// vcdRequest sends body to href and decodes the XML answer into out, if any.
+func vcdRequest(p *govcloudair.Client, method, href, contentType string, body io.Reader, out interface{}) error {
+	u, err := url.ParseRequestURI(href)
+	if err != nil {
+		return fmt.Errorf("cannot parse %q: %s", href, err)
+	}
+
+	req := p.NewRequest(map[string]string{}, method, *u, body)
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	resp, err := vcdCheckResp(p.Http.Do(req))
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		resp.Body.Close()
+		return nil
+	}
+	return vcdDecodeBody(resp, out)
+}
+
+func vcdGet(p *govcloudair.Client, href string, out interface{}) error {
+	if href == "" {
+		return fmt.Errorf("link not found")
+	}
+	return vcdRequest(p, "GET", href, "", nil, out)
+}
+
+// vcdTask sends a request that answers with a task and waits for the task.
+func vcdTask(p *govcloudair.Client, method, href, contentType string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := xml.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewBufferString(xml.Header + string(b))
+	}
+
+	task := govcloudair.NewTask(p)
+	if err := vcdRequest(p, method, href, contentType, r, task.Task); err != nil {
+		return err
+	}
+	return task.WaitTaskCompletion()
+}
+
+func vcdCheckResp(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	vcdErr := &types.Error{}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if xml.Unmarshal(body, vcdErr) == nil && vcdErr.Message != "" {
+		return nil, fmt.Errorf("API Error: %d: %s", vcdErr.MajorErrorCode, vcdErr.Message)
+	}
+	return nil, fmt.Errorf("API Error: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+func vcdDecodeBody(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+// Sizing policies
+
+type computePolicy struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type computePolicyPage struct {
+	Values []computePolicy `json:"values"`
+}
+
+var vmSizingPolicyElement = regexp.MustCompile(`(?s)<(\w+:)?VmSizingPolicy\b[^>]*?(/>|>.*?</(\w+:)?VmSizingPolicy>)`)
+
+// findSizingPolicy looks up the VM sizing policy of the VDC by name through
+// the cloudapi, which is where compute policies live since API version 33.
+func (d *Driver) findSizingPolicy(p *govcloudair.Client, v govcloudair.Vdc) (computePolicy, string, error) {
+	base, err := url.ParseRequestURI(d.URL)
+	if err != nil {
+		return computePolicy{}, "", err
+	}
+	base.Path = "/cloudapi/2.0.0/vdcs/" + v.Vdc.ID + "/computePolicies"
+	base.RawQuery = url.Values{"filter": {"name==" + d.SizingPolicy}}.Encode()
+
+	req, err := http.NewRequest("GET", base.String(), nil)
+	if err != nil {
+		return computePolicy{}, "", err
+	}
+	req.Header.Add(p.VCDAuthHeader, p.VCDToken)
+	req.Header.Add("Accept", "application/json;version="+d.APIVersion)
+
+	resp, err := p.Http.Do(req)
+	if err != nil {
+		return computePolicy{}, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return computePolicy{}, "", fmt.Errorf("error listing compute policies of VDC %q: %s", d.VDCID, resp.Status)
+	}
+
+	page := &computePolicyPage{}
+	if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return computePolicy{}, "", err
+	}
+	for _, policy := range page.Values {
+		if policy.Name == d.SizingPolicy {
+			base.Path = "/cloudapi/1.0.0/vdcComputePolicies/" + policy.ID
+			base.RawQuery = ""
+			return policy, base.String(), nil
+		}
+	}
+
+	return computePolicy{}, "", fmt.Errorf("sizing policy %q is not available in VDC %q", d.SizingPolicy, d.VDCID)
+}
+
+// applySizingPolicy reconfigures the VM with the sizing policy. The VM is sent
+// back as fetched so that no section govcloudair does not model gets lost.
+func (d *Driver) applySizingPolicy(p *govcloudair.Client, v govcloudair.Vdc, vmHREF string) error {
+	policy, policyHREF, err := d.findSizingPolicy(p, v)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.ParseRequestURI(vmHREF)
+	if err != nil {
+		return err
+	}
+	resp, err := vcdCheckResp(p.Http.Do(p.NewRequest(map[string]string{}, "GET", *u, nil)))
+	if err != nil {
+		return fmt.Errorf("error retrieving VM: %s", err)
+	}
+	defer resp.Body.Close()
+	vm, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	reconfigured, err := setSizingPolicy(vm, policy, policyHREF)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Applying sizing policy %s...", d.SizingPolicy)
+	task := govcloudair.NewTask(p)
+	u.Path += "/action/reconfigureVm"
+	if err := vcdRequest(p, "POST", u.String(), "application/vnd.vmware.vcloud.vm+xml", bytes.NewReader(reconfigured), task.Task); err != nil {
+		return fmt.Errorf("error applying sizing policy: %s", err)
+	}
+	return task.WaitTaskCompletion()
+}
+
+// setSizingPolicy swaps the VmSizingPolicy reference in the VM document.
+func setSizingPolicy(vm []byte, policy computePolicy, href string) ([]byte, error) {
+	loc := vmSizingPolicyElement.FindSubmatchIndex(vm)
+	if loc == nil {
+		return nil, fmt.Errorf("the VM has no sizing policy reference, sizing policies require API version %s or later", defaultAPIVersion)
+	}
+
+	prefix := ""
+	if loc[2] >= 0 {
+		prefix = string(vm[loc[2]:loc[3]])
+	}
+	ref := fmt.Sprintf(`<%sVmSizingPolicy href="%s" id="%s" name="%s" type="application/json"/>`,
+		prefix, xmlEscape(href), xmlEscape(policy.ID), xmlEscape(policy.Name))
+
+	out := append([]byte{}, vm[:loc[0]]...)
+	out = append(out, ref...)
+	return append(out, vm[loc[1]:]...), nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Named disks
+
+// NamedDisk is an independent disk of the VDC attached to the VM. It outlives
+// the vApp in vCloud Director but is removed together with the machine.
+type NamedDisk struct {
+	Name   string
+	SizeMB int64
+	HREF   string
+}
+
+type diskCreateParams struct {
+	XMLName xml.Name `xml:"DiskCreateParams"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Disk    struct {
+		Name string `xml:"name,attr"`
+		Size int64  `xml:"size,attr"`
+	} `xml:"Disk"`
+}
+
+type diskAttachOrDetachParams struct {
+	XMLName xml.Name `xml:"DiskAttachOrDetachParams"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Disk    struct {
+		HREF string `xml:"href,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"Disk"`
+}
+
+type diskResponse struct {
+	HREF  string                 `xml:"href,attr"`
+	Tasks *types.TasksInProgress `xml:"Tasks"`
+}
+
+// createDisks creates the named disks in the VDC and attaches them to the VM.
+func (d *Driver) createDisks(p *govcloudair.Client, v govcloudair.Vdc, vmHREF string) error {
+	for i := range d.Disks {
+		disk := &d.Disks[i]
+
+		log.Infof("Creating disk %s (%d MB)...", disk.Name, disk.SizeMB)
+		params := diskCreateParams{Xmlns: "http://www.vmware.com/vcloud/v1.5"}
+		params.Disk.Name = disk.Name
+		params.Disk.Size = disk.SizeMB * 1024 * 1024
+
+		b, err := xml.Marshal(params)
+		if err != nil {
+			return err
+		}
+
+		created := &diskResponse{}
+		if err := vcdRequest(p, "POST", v.Vdc.HREF+"/disk", "application/vnd.vmware.vcloud.diskCreateParams+xml", bytes.NewBufferString(xml.Header+string(b)), created); err != nil {
+			return fmt.Errorf("error creating disk %s: %s", disk.Name, err)
+		}
+		disk.HREF = created.HREF
+
+		if created.Tasks != nil {
+			for _, t := range created.Tasks.Task {
+				task := govcloudair.NewTask(p)
+				task.Task = t
+				if err := task.WaitTaskCompletion(); err != nil {
+					return err
+				}
+			}
+		}
+
+		log.Debugf("Attaching disk %s...", disk.Name)
+		if err := vcdTask(p, "POST", vmHREF+"/disk/action/attach", "application/vnd.vmware.vcloud.diskAttachOrDetachParams+xml", diskAttachParams(disk.HREF)); err != nil {
+			return fmt.Errorf("error attaching disk %s: %s", disk.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// removeDisks detaches the named disks from the VM, which vCloud Director
+// requires before deleting the vApp, and returns a function deleting them.
+func (d *Driver) removeDisks(p *govcloudair.Client, vmHREF string) (func() error, error) {
+	for _, disk := range d.Disks {
+		if disk.HREF == "" {
+			continue
+		}
+		log.Debugf("Detaching disk %s...", disk.Name)
+		if err := vcdTask(p, "POST", vmHREF+"/disk/action/detach", "application/vnd.vmware.vcloud.diskAttachOrDetachParams+xml", diskAttachParams(disk.HREF)); err != nil {
+			return nil, fmt.Errorf("error detaching disk %s: %s", disk.Name, err)
+		}
+	}
+
+	return func() error {
+		for _, disk := range d.Disks {
+			if disk.HREF == "" {
+				continue
+			}
+			log.Infof("Deleting disk %s...", disk.Name)
+			if err := vcdTask(p, "DELETE", disk.HREF, "", nil); err != nil {
+				return fmt.Errorf("error deleting disk %s: %s", disk.Name, err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+func diskAttachParams(href string) diskAttachOrDetachParams {
+	params := diskAttachOrDetachParams{Xmlns: "http://www.vmware.com/vcloud/v1.5"}
+	params.Disk.HREF = href
+	params.Disk.Type = diskType
+	return params
+}
+
+// parseNamedDisk parses a disk given as name=NAME,size=MB.
+func parseNamedDisk(spec string) (NamedDisk, error) {
+	disk := NamedDisk{}
+	options, err := mcnutils.ParseOptions("disk", spec)
+	if err != nil {
+		return NamedDisk{}, err
+	}
+	for _, option := range options {
+		switch option.Key {
+		case "name":
+			disk.Name = option.Value
+		case "size":
+			if _, err := fmt.Sscanf(option.Value, "%d", &disk.SizeMB); err != nil || disk.SizeMB <= 0 {
+				return NamedDisk{}, fmt.Errorf("invalid disk size %q in %q, expected a size in MB", option.Value, spec)
+			}
+		default:
+			return NamedDisk{}, fmt.Errorf("unknown disk option %q in %q", option.Key, spec)
+		}
+	}
+
+	if disk.Name == "" || disk.SizeMB == 0 {
+		return NamedDisk{}, fmt.Errorf("disk %q needs both a name and a size", spec)
+	}
+	return disk, nil
+}
+
+// Guest customization
+
+// customizationScript builds the guest customization script. It installs the
+// SSH key and, if given, seeds cloud-init's NoCloud datasource with the
+// user-data so that cloud-init picks it up on first boot.
+func customizationScript(key, userData, hostname string) string {
+	script := "#!/bin/sh\n" +
+		"mkdir -p /root/.ssh\n" +
+		"echo \"" + strings.TrimSpace(key) + "\" > /root/.ssh/authorized_keys\n"
+	if userData == "" {
+		return script
+	}
+
+	// The heredoc delimiter can't collide with the user-data as long as it
+	// is not a line of its own there.
+	delimiter := "MACHINE_USER_DATA"
+	for strings.Contains("\n"+userData+"\n", "\n"+delimiter+"\n") {
+		delimiter += "_"
+	}
+
+	return script +
+		"mkdir -p /var/lib/cloud/seed/nocloud\n" +
+		"cat > /var/lib/cloud/seed/nocloud/user-data <<'" + delimiter + "'\n" +
+		strings.TrimSuffix(userData, "\n") + "\n" +
+		delimiter + "\n" +
+		"printf 'instance-id: %s\\nlocal-hostname: %s\\n' \"" + hostname + "\" \"" + hostname + "\" > /var/lib/cloud/seed/nocloud/meta-data\n"
+}
@@ -11,7 +11,6 @@ import (
 	"net"
 	"os"
 	"strconv"
-	"strings"
 
 	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
 	"github.com/vmware/govcloudair"
@@ -27,7 +26,7 @@ import (
 type Driver struct {
 	*drivers.BaseDriver
 	UserName     string
-	UserPassword string
+	UserPassword string `secret:"true"`
 	ComputeID    string
 	VDCID        string
 	OrgVDCNet    string
@@ -39,6 +38,12 @@ type Driver struct {
 	CPUCount     int
 	MemorySize   int
 	VAppID       string
+	URL          string
+	Org          string
+	APIVersion   string
+	SizingPolicy string
+	Disks        []NamedDisk
+	UserData     string
 }
 
 const (
@@ -64,6 +69,22 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwarevcloudair-password",
 			Usage:  "vCloud Air password",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "VCLOUDAIR_URL",
+			Name:   "vmwarevcloudair-url",
+			Usage:  "vCloud Director API URL (e.g. https://vcd.example.com/api), logs in to the organization directly instead of through vCloud Air",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VCLOUDAIR_ORG",
+			Name:   "vmwarevcloudair-org",
+			Usage:  "vCloud Director organization, required with --vmwarevcloudair-url",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VCLOUDAIR_API_VERSION",
+			Name:   "vmwarevcloudair-api-version",
+			Usage:  "vCloud Director API version used with --vmwarevcloudair-url",
+			Value:  defaultAPIVersion,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "VCLOUDAIR_COMPUTEID",
 			Name:   "vmwarevcloudair-computeid",
@@ -113,6 +134,21 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "vCloud Air VM Memory Size in MB (default 2048)",
 			Value:  defaultMemory,
 		},
+		mcnflag.StringFlag{
+			EnvVar: "VCLOUDAIR_SIZING_POLICY",
+			Name:   "vmwarevcloudair-sizing-policy",
+			Usage:  "vCloud Director VM sizing policy of the VDC, replaces the CPU count and memory size (requires --vmwarevcloudair-url)",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "VCLOUDAIR_DISK",
+			Name:   "vmwarevcloudair-disk",
+			Usage:  "Named disk to create and attach to the VM, in the form name=NAME,size=MB",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VCLOUDAIR_CLOUD_INIT",
+			Name:   "vmwarevcloudair-cloud-init",
+			Usage:  "Path to a cloud-init user-data file, seeded through guest customization",
+		},
 		mcnflag.IntFlag{
 			EnvVar: "VCLOUDAIR_SSH_PORT",
 			Name:   "vmwarevcloudair-ssh-port",
@@ -130,6 +166,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 
 func NewDriver(hostName, storePath string) drivers.Driver {
 	return &Driver{
+		APIVersion:  defaultAPIVersion,
 		Catalog:     defaultCatalog,
 		CatalogItem: defaultCatalogItem,
 		CPUCount:    defaultCpus,
@@ -182,6 +219,10 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.UserPassword = flags.String("vmwarevcloudair-password")
 	d.VDCID = flags.String("vmwarevcloudair-vdcid")
 	d.PublicIP = flags.String("vmwarevcloudair-publicip")
+	d.URL = flags.String("vmwarevcloudair-url")
+	d.Org = flags.String("vmwarevcloudair-org")
+	d.APIVersion = flags.String("vmwarevcloudair-api-version")
+	d.SizingPolicy = flags.String("vmwarevcloudair-sizing-policy")
 	d.SetSwarmConfigFromFlags(flags)
 
 	// Check for required Params
@@ -189,6 +230,32 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		return fmt.Errorf("Please specify vcloudair mandatory params using options: -vmwarevcloudair-username -vmwarevcloudair-password -vmwarevcloudair-vdcid and -vmwarevcloudair-publicip")
 	}
 
+	if d.URL != "" && d.Org == "" {
+		return fmt.Errorf("--vmwarevcloudair-org is required with --vmwarevcloudair-url")
+	}
+
+	if d.SizingPolicy != "" && d.URL == "" {
+		return fmt.Errorf("--vmwarevcloudair-sizing-policy requires --vmwarevcloudair-url, vCloud Air does not support sizing policies")
+	}
+
+	d.Disks = nil
+	for _, spec := range flags.StringSlice("vmwarevcloudair-disk") {
+		disk, err := parseNamedDisk(spec)
+		if err != nil {
+			return err
+		}
+		d.Disks = append(d.Disks, disk)
+	}
+
+	d.UserData = ""
+	if path := flags.String("vmwarevcloudair-cloud-init"); path != "" {
+		userData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read cloud-init file: %s", err)
+		}
+		d.UserData = string(userData)
+	}
+
 	// If ComputeID is not set we're using a VPC, hence setting ComputeID = VDCID
 	if flags.String("vmwarevcloudair-computeid") == "" {
 		d.ComputeID = flags.String("vmwarevcloudair-vdcid")
@@ -230,19 +297,20 @@ func (d *Driver) GetURL() (string, error) {
 	return fmt.Sprintf("tcp://%s", net.JoinHostPort(d.PublicIP, strconv.Itoa(d.DockerPort))), nil
 }
 
+// SetEnginePort sets the port of the Docker daemon, overriding
+// --vmwarevcloudair-docker-port.
+func (d *Driver) SetEnginePort(port int) error {
+	d.DockerPort = port
+	return nil
+}
+
 func (d *Driver) GetIP() (string, error) {
 	return d.PublicIP, nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return state.Error, err
-	}
-
-	log.Debug("Connecting to vCloud Air to fetch vApp Status...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Debugf("Connecting to %s to fetch vApp Status...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return state.Error, err
 	}
@@ -257,7 +325,7 @@ func (d *Driver) GetState() (state.State, error) {
 		return state.Error, err
 	}
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return state.Error, err
 	}
 
@@ -276,14 +344,8 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -339,27 +401,46 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	task, err = vapp.ChangeCPUcount(d.CPUCount)
-	if err != nil {
-		return err
-	}
+	// Set VAppID right away so that a failure below can still be cleaned up
+	d.VAppID = vapp.VApp.ID
 
-	if err = task.WaitTaskCompletion(); err != nil {
+	if err = vapp.Refresh(); err != nil {
 		return err
 	}
+	if vapp.VApp.Children == nil || len(vapp.VApp.Children.VM) == 0 {
+		return fmt.Errorf("vApp %s doesn't contain any VM", d.MachineName)
+	}
+	vmHREF := vapp.VApp.Children.VM[0].HREF
 
-	task, err = vapp.ChangeMemorySize(d.MemorySize)
-	if err != nil {
-		return err
+	if d.SizingPolicy != "" {
+		if err = d.applySizingPolicy(p, v, vmHREF); err != nil {
+			return err
+		}
+	} else {
+		task, err = vapp.ChangeCPUcount(d.CPUCount)
+		if err != nil {
+			return err
+		}
+
+		if err = task.WaitTaskCompletion(); err != nil {
+			return err
+		}
+
+		task, err = vapp.ChangeMemorySize(d.MemorySize)
+		if err != nil {
+			return err
+		}
+
+		if err = task.WaitTaskCompletion(); err != nil {
+			return err
+		}
 	}
 
-	if err = task.WaitTaskCompletion(); err != nil {
+	if err = d.createDisks(p, v, vmHREF); err != nil {
 		return err
 	}
 
-	sshCustomScript := "echo \"" + strings.TrimSpace(key) + "\" > /root/.ssh/authorized_keys"
-
-	task, err = vapp.RunCustomizationScript(d.MachineName, sshCustomScript)
+	task, err = vapp.RunCustomizationScript(d.MachineName, customizationScript(key, d.UserData, d.MachineName))
 	if err != nil {
 		return err
 	}
@@ -389,28 +470,19 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	log.Debugf("Disconnecting from vCloud Air...")
+	log.Debugf("Disconnecting from %s...", d.endpointName())
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return err
 	}
 
-	// Set VAppID with ID of the created VApp
-	d.VAppID = vapp.VApp.ID
-
 	d.IPAddress, err = d.GetIP()
 	return err
 }
 
 func (d *Driver) Remove() error {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -463,6 +535,11 @@ func (d *Driver) Remove() error {
 		return err
 	}
 
+	deleteDisks, err := d.removeDisks(p, vapp.VApp.Children.VM[0].HREF)
+	if err != nil {
+		return err
+	}
+
 	log.Infof("Deleting %s...", d.MachineName)
 	task, err = vapp.Delete()
 	if err != nil {
@@ -472,19 +549,17 @@ func (d *Driver) Remove() error {
 		return err
 	}
 
-	err = p.Disconnect()
+	if err = deleteDisks(); err != nil {
+		return err
+	}
+
+	err = d.disconnect(p)
 	return err
 }
 
 func (d *Driver) Start() error {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -511,7 +586,7 @@ func (d *Driver) Start() error {
 
 	}
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return err
 	}
 
@@ -520,14 +595,8 @@ func (d *Driver) Start() error {
 }
 
 func (d *Driver) Stop() error {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -545,7 +614,7 @@ func (d *Driver) Stop() error {
 		return err
 	}
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return err
 	}
 
@@ -555,14 +624,8 @@ func (d *Driver) Stop() error {
 }
 
 func (d *Driver) Restart() error {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -580,7 +643,7 @@ func (d *Driver) Restart() error {
 		return err
 	}
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return err
 	}
 
@@ -589,14 +652,8 @@ func (d *Driver) Restart() error {
 }
 
 func (d *Driver) Kill() error {
-	p, err := govcloudair.NewClient()
-	if err != nil {
-		return err
-	}
-
-	log.Infof("Connecting to vCloud Air...")
-	// Authenticate to vCloud Air
-	v, err := p.Authenticate(d.UserName, d.UserPassword, d.ComputeID, d.VDCID)
+	log.Infof("Connecting to %s...", d.endpointName())
+	p, v, err := d.authenticate()
 	if err != nil {
 		return err
 	}
@@ -614,7 +671,7 @@ func (d *Driver) Kill() error {
 		return err
 	}
 
-	if err = p.Disconnect(); err != nil {
+	if err = d.disconnect(p); err != nil {
 		return err
 	}
 
@@ -25,3 +25,102 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestSetConfigFromFlagsVCD(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevcloudair-username":      "root",
+			"vmwarevcloudair-password":      "pwd",
+			"vmwarevcloudair-vdcid":         "ID",
+			"vmwarevcloudair-publicip":      "IP",
+			"vmwarevcloudair-url":           "https://vcd.example.com/api",
+			"vmwarevcloudair-sizing-policy": "small",
+			"vmwarevcloudair-disk":          []string{"name=data,size=10240"},
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.EqualError(t, err, "--vmwarevcloudair-org is required with --vmwarevcloudair-url")
+
+	checkFlags.FlagsValues["vmwarevcloudair-org"] = "tenant"
+	err = driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+	assert.Empty(t, checkFlags.InvalidFlags)
+
+	d := driver.(*Driver)
+	assert.Equal(t, defaultAPIVersion, d.APIVersion)
+	assert.Equal(t, []NamedDisk{{Name: "data", SizeMB: 10240}}, d.Disks)
+}
+
+func TestSizingPolicyRequiresVCD(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevcloudair-username":      "root",
+			"vmwarevcloudair-password":      "pwd",
+			"vmwarevcloudair-vdcid":         "ID",
+			"vmwarevcloudair-publicip":      "IP",
+			"vmwarevcloudair-sizing-policy": "small",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	assert.Error(t, driver.SetConfigFromFlags(checkFlags))
+}
+
+func TestParseNamedDisk(t *testing.T) {
+	tests := []struct {
+		spec    string
+		disk    NamedDisk
+		wantErr bool
+	}{
+		{spec: "name=data,size=2048", disk: NamedDisk{Name: "data", SizeMB: 2048}},
+		{spec: "size=2048, name=logs", disk: NamedDisk{Name: "logs", SizeMB: 2048}},
+		{spec: "name=data", wantErr: true},
+		{spec: "name=data,size=-1", wantErr: true},
+		{spec: "name=data,size=big", wantErr: true},
+		{spec: "name=data,size=1,bus=ide", wantErr: true},
+		{spec: "data", wantErr: true},
+	}
+
+	for _, test := range tests {
+		disk, err := parseNamedDisk(test.spec)
+		if test.wantErr {
+			assert.Error(t, err, test.spec)
+			continue
+		}
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.disk, disk)
+	}
+}
+
+func TestSetSizingPolicy(t *testing.T) {
+	policy := computePolicy{ID: "urn:vcloud:vdcComputePolicy:2", Name: "large"}
+	href := "https://vcd.example.com/cloudapi/1.0.0/vdcComputePolicies/urn:vcloud:vdcComputePolicy:2"
+
+	vm := `<Vm name="vm"><ComputePolicy><VmPlacementPolicyFinal>false</VmPlacementPolicyFinal>` +
+		`<VmSizingPolicy href="old" id="urn:vcloud:vdcComputePolicy:1" name="System Default" type="application/json"/>` +
+		`<VmSizingPolicyFinal>false</VmSizingPolicyFinal></ComputePolicy></Vm>`
+
+	out, err := setSizingPolicy([]byte(vm), policy, href)
+	assert.NoError(t, err)
+	assert.Equal(t, `<Vm name="vm"><ComputePolicy><VmPlacementPolicyFinal>false</VmPlacementPolicyFinal>`+
+		`<VmSizingPolicy href="`+href+`" id="urn:vcloud:vdcComputePolicy:2" name="large" type="application/json"/>`+
+		`<VmSizingPolicyFinal>false</VmSizingPolicyFinal></ComputePolicy></Vm>`, string(out))
+
+	_, err = setSizingPolicy([]byte(`<Vm name="vm"></Vm>`), policy, href)
+	assert.Error(t, err)
+}
+
+func TestCustomizationScript(t *testing.T) {
+	script := customizationScript("ssh-rsa KEY\n", "", "host")
+	assert.Equal(t, "#!/bin/sh\nmkdir -p /root/.ssh\necho \"ssh-rsa KEY\" > /root/.ssh/authorized_keys\n", script)
+
+	script = customizationScript("ssh-rsa KEY", "#cloud-config\nMACHINE_USER_DATA\n", "host")
+	assert.Contains(t, script, "cat > /var/lib/cloud/seed/nocloud/user-data <<'MACHINE_USER_DATA_'\n#cloud-config\nMACHINE_USER_DATA\nMACHINE_USER_DATA_\n")
+	assert.Contains(t, script, "instance-id: %s\\nlocal-hostname: %s\\n' \"host\" \"host\"")
+}
@@ -20,6 +20,9 @@ const (
 	isoName     = "user-data.iso"
 	isoDir      = "cloudinit"
 	mkisofsName = "mkisofs"
+
+	cloudInitModeISO       = "iso"
+	cloudInitModeGuestInfo = "guestinfo"
 )
 
 func (d *Driver) cloudInit(vm *object.VirtualMachine) error {
@@ -27,7 +30,11 @@ func (d *Driver) cloudInit(vm *object.VirtualMachine) error {
 		return d.cloudInitGuestInfo(vm)
 	}
 
-	if err := d.createCloudInitIso(); err != nil {
+	if d.CloudInitMode == cloudInitModeGuestInfo {
+		return d.cloudInitDatasource(vm)
+	}
+
+	if err := d.createCloudInitIso(vm); err != nil {
 		return err
 	}
 
@@ -75,6 +82,62 @@ func (d *Driver) cloudInitGuestInfo(vm *object.VirtualMachine) error {
 	return nil
 }
 
+// cloudInitDatasource passes the same user-data and meta-data that would be put
+// in the ISO through the guestinfo keys read by the VMware cloud-init datasource.
+func (d *Driver) cloudInitDatasource(vm *object.VirtualMachine) error {
+	userdata, err := d.cloudInitUserData()
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]interface{}{
+		"local-hostname": d.MachineName,
+	}
+	network, err := d.cloudInitNetworkConfig(vm)
+	if err != nil {
+		return err
+	}
+	if network != nil {
+		metadata["network"] = network
+	}
+	md, err := yaml.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("setting guestinfo.userdata and guestinfo.metadata")
+	return d.applyOpts(vm, guestInfoOpts(userdata, string(md)))
+}
+
+func guestInfoOpts(userdata, metadata string) []types.BaseOptionValue {
+	return []types.BaseOptionValue{
+		&types.OptionValue{Key: "guestinfo.userdata", Value: base64.StdEncoding.EncodeToString([]byte(userdata))},
+		&types.OptionValue{Key: "guestinfo.userdata.encoding", Value: "base64"},
+		&types.OptionValue{Key: "guestinfo.metadata", Value: base64.StdEncoding.EncodeToString([]byte(metadata))},
+		&types.OptionValue{Key: "guestinfo.metadata.encoding", Value: "base64"},
+	}
+}
+
+// cloudInitUserData returns the cloud-config with the SSH user added.
+func (d *Driver) cloudInitUserData() (string, error) {
+	//d.CloudConfig stat'ed and loaded in flag load.
+	sshkey, err := ioutil.ReadFile(d.publicSSHKeyPath())
+	if err != nil {
+		return "", err
+	}
+
+	userdatacontent, err := d.addSSHUserToYaml(string(sshkey))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("#cloud-config\n%s", userdatacontent), nil
+}
+
+func (d *Driver) cloudInitMetaData() string {
+	return fmt.Sprintf("local-hostname: %s\n", d.MachineName)
+}
+
 func (d *Driver) uploadCloudInitIso(vm *object.VirtualMachine, dc *object.Datacenter, ds *object.Datastore) error {
 	log.Infof("Uploading %s", isoName)
 	path, err := d.getVmFolder(vm)
@@ -126,15 +189,9 @@ func (d *Driver) removeCloudInitIso(vm *object.VirtualMachine, dc *object.Datace
 	return nil
 }
 
-func (d *Driver) createCloudInitIso() error {
+func (d *Driver) createCloudInitIso(vm *object.VirtualMachine) error {
 	log.Infof("Creating cloud-init.iso")
-	//d.CloudConfig stat'ed and loaded in flag load.
-	sshkey, err := ioutil.ReadFile(d.publicSSHKeyPath())
-	if err != nil {
-		return err
-	}
-
-	userdatacontent, err := d.addSSHUserToYaml(string(sshkey))
+	writeYaml, err := d.cloudInitUserData()
 	if err != nil {
 		return err
 	}
@@ -150,16 +207,29 @@ func (d *Driver) createCloudInitIso() error {
 		return err
 	}
 
-	writeYaml := fmt.Sprintf("#cloud-config\n%s", userdatacontent)
 	if err = ioutil.WriteFile(userdata, []byte(writeYaml), perm); err != nil {
 		return err
 	}
 
-	md := []byte(fmt.Sprintf("local-hostname: %s\n", d.MachineName))
+	md := []byte(d.cloudInitMetaData())
 	if err = ioutil.WriteFile(metadata, md, perm); err != nil {
 		return err
 	}
 
+	network, err := d.cloudInitNetworkConfig(vm)
+	if err != nil {
+		return err
+	}
+	if network != nil {
+		nc, err := yaml.Marshal(network)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filepath.Join(dataDir, "network-config"), nc, perm); err != nil {
+			return err
+		}
+	}
+
 	// validate that our files are present in the isoDir before creating the ISO
 	for filename, filepath := range map[string]string{"user-data": userdata, "meta-data": metadata} {
 		_, err = os.Stat(filepath)
@@ -32,7 +32,7 @@ func (d *Driver) preCreate() error {
 	log.Infof("Using datacenter %s", d.datacenter.InventoryPath)
 	d.finder.SetDatacenter(d.datacenter)
 	for _, netName := range d.Networks {
-		net, err := d.finder.NetworkOrDefault(d.getCtx(), netName)
+		net, err := d.findNetwork(netName)
 		if err != nil {
 			return err
 		}
@@ -80,6 +80,10 @@ func (d *Driver) postCreate(vm *object.VirtualMachine) error {
 		return err
 	}
 
+	if err := d.applyStoragePolicy(vm); err != nil {
+		return err
+	}
+
 	if err := d.cloudInit(vm); err != nil {
 		return err
 	}
@@ -174,6 +178,7 @@ func (d *Driver) createLegacy() error {
 
 	// Convert MB to KB
 	disk.CapacityInKB = int64(d.DiskSize) * 1024
+	setDiskProvisioning(disk.Backing, d.DiskProvisioning)
 	add = append(add, disk)
 	ide, err := devices.FindIDEController("")
 	if err != nil {
@@ -251,6 +256,12 @@ func (d *Driver) createFromVmName() error {
 		return err
 	}
 
+	if d.DiskProvisioning != "" {
+		if spec.Location.Disk, err = d.diskLocators(vm2Clone, dsref); err != nil {
+			return err
+		}
+	}
+
 	var o mo.VirtualMachine
 
 	if err = vm2Clone.Properties(d.getCtx(), vm2Clone.Reference(), []string{"summary.config.guestId"}, &o); err != nil {
@@ -334,22 +345,62 @@ func (d *Driver) createFromLibraryName() error {
 
 	m := vcenter.NewManager(libManager.Client)
 
-	deploy := vcenter.Deploy{
-		DeploymentSpec: vcenter.DeploymentSpec{
-			Name:                d.MachineName,
-			DefaultDatastoreID:  ds.Reference().Value,
-			AcceptAllEULA:       true,
-			StorageProvisioning: "thin",
-		},
-		Target: vcenter.Target{
-			ResourcePoolID: d.resourcepool.Reference().Value,
-			HostID:         hostId,
-			FolderID:       folder.Reference().Value,
-		},
-	}
-	ref, err := m.DeployLibraryItem(d.getCtx(), item.ID, deploy)
-	if err != nil {
-		return err
+	var ref *types.ManagedObjectReference
+	switch item.Type {
+	case library.ItemTypeOVF:
+		properties, err := parseOvfProperties(d.OvfProperties)
+		if err != nil {
+			return err
+		}
+
+		deploy := vcenter.Deploy{
+			DeploymentSpec: vcenter.DeploymentSpec{
+				Name:                d.MachineName,
+				DefaultDatastoreID:  ds.Reference().Value,
+				AcceptAllEULA:       true,
+				StorageProvisioning: d.ovfStorageProvisioning(),
+			},
+			Target: vcenter.Target{
+				ResourcePoolID: d.resourcepool.Reference().Value,
+				HostID:         hostId,
+				FolderID:       folder.Reference().Value,
+			},
+		}
+		if len(properties) > 0 {
+			deploy.AdditionalParams = []vcenter.AdditionalParams{{
+				Class:      vcenter.ClassPropertyParams,
+				Type:       vcenter.TypePropertyParams,
+				Properties: properties,
+			}}
+		}
+		ref, err = m.DeployLibraryItem(d.getCtx(), item.ID, deploy)
+		if err != nil {
+			return err
+		}
+	case library.ItemTypeVMTX:
+		if len(d.OvfProperties) > 0 {
+			return fmt.Errorf("OVF properties can not be set on %q, it is a VM template and not an OVF template", d.CloneFrom)
+		}
+		if d.DiskProvisioning != "" {
+			return fmt.Errorf("disk provisioning can not be set on %q, VM templates keep the provisioning of their disks", d.CloneFrom)
+		}
+
+		deploy := vcenter.DeployTemplate{
+			Name: d.MachineName,
+			Placement: &vcenter.Placement{
+				ResourcePool: d.resourcepool.Reference().Value,
+				Host:         hostId,
+				Folder:       folder.Reference().Value,
+			},
+			VMHomeStorage: &vcenter.DiskStorage{Datastore: ds.Reference().Value},
+			DiskStorage:   &vcenter.DiskStorage{Datastore: ds.Reference().Value},
+		}
+		ref, err = m.DeployTemplateLibraryItem(d.getCtx(), item.ID, deploy)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Content Library item %q of type %s can not be deployed", d.CloneFrom, item.Type)
 	}
 
 	obj, err := d.finder.ObjectReference(d.getCtx(), *ref)
@@ -78,6 +78,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwarevsphere-network",
 			Usage:  "vSphere network where the virtual machine will be attached",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "VSPHERE_NIC",
+			Name:   "vmwarevsphere-nic",
+			Usage:  "vSphere network adapter in the form network=NAME[,type=standard|distributed][,ip=ADDR/PREFIX][,gateway=ADDR], replaces --vmwarevsphere-network",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "VSPHERE_NAMESERVER",
+			Name:   "vmwarevsphere-nameserver",
+			Usage:  "DNS server for the network adapters with a static ip",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "VSPHERE_DATASTORE",
 			Name:   "vmwarevsphere-datastore",
@@ -88,6 +98,16 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwarevsphere-datastore-cluster",
 			Usage:  "vSphere datastore cluster for virtual machine",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "VSPHERE_STORAGE_POLICY",
+			Name:   "vmwarevsphere-storage-policy",
+			Usage:  "vSphere storage policy for the virtual machine, used to pick a compatible datastore when no datastore or datastore cluster is set",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "VSPHERE_DISK_PROVISIONING",
+			Name:   "vmwarevsphere-disk-provisioning",
+			Usage:  "vSphere disk provisioning of the virtual machine disks. Supported values: thin, thick and eagerZeroedThick",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "VSPHERE_DATACENTER",
 			Name:   "vmwarevsphere-datacenter",
@@ -123,6 +143,12 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwarevsphere-cloud-config",
 			Usage:  "Filepath to a cloud-config yaml file to put into the ISO user-data",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "VSPHERE_CLOUD_INIT_MODE",
+			Name:   "vmwarevsphere-cloud-init-mode",
+			Usage:  "How the cloud-config user-data and meta-data are passed to the VM. Supported values: iso (NoCloud ISO) and guestinfo (VMware datasource guestinfo keys)",
+			Value:  cloudInitModeISO,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "VSPHERE_VAPP_IPPROTOCOL",
 			Name:   "vmwarevsphere-vapp-ipprotocol",
@@ -159,6 +185,11 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "vmwarevsphere-content-library",
 			Usage:  "If you choose to clone from a content library template specify the name of the library",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "VSPHERE_OVF_PROPERTY",
+			Name:   "vmwarevsphere-ovf-property",
+			Usage:  "OVF property to set when deploying an OVF template from a content library, in the form key=value",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "VSPHERE_SSH_USER",
 			Name:   "vmwarevsphere-ssh-user",
@@ -257,10 +288,33 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.Username = flags.String("vmwarevsphere-username")
 	d.Password = flags.String("vmwarevsphere-password")
 	d.Networks = flags.StringSlice("vmwarevsphere-network")
+	d.NICs = nil
+	for _, spec := range flags.StringSlice("vmwarevsphere-nic") {
+		nic, err := parseNIC(spec)
+		if err != nil {
+			return err
+		}
+		d.NICs = append(d.NICs, nic)
+	}
+	if len(d.NICs) > 0 {
+		if len(d.Networks) > 0 {
+			return errors.New("--vmwarevsphere-network and --vmwarevsphere-nic can not be used together")
+		}
+		for _, nic := range d.NICs {
+			d.Networks = append(d.Networks, nic.Network)
+		}
+	}
+	d.Nameservers = flags.StringSlice("vmwarevsphere-nameserver")
 	d.Tags = flags.StringSlice("vmwarevsphere-tag")
 	d.CustomAttributes = flags.StringSlice("vmwarevsphere-custom-attribute")
 	d.Datastore = flags.String("vmwarevsphere-datastore")
 	d.DatastoreCluster = flags.String("vmwarevsphere-datastore-cluster")
+	d.StoragePolicy = flags.String("vmwarevsphere-storage-policy")
+	d.DiskProvisioning = flags.String("vmwarevsphere-disk-provisioning")
+	if d.DiskProvisioning != "" && !supportedDiskProvisioning[d.DiskProvisioning] {
+		return fmt.Errorf("disk provisioning %s not supported, use %s, %s or %s", d.DiskProvisioning,
+			diskProvisioningThin, diskProvisioningThick, diskProvisioningEagerZeroedThick)
+	}
 	d.Datacenter = flags.String("vmwarevsphere-datacenter")
 	// Sanitize input on ingress.
 	d.Folder = flags.String("vmwarevsphere-folder")
@@ -280,6 +334,11 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		d.CloudConfig = string(ud)
 	}
 
+	d.CloudInitMode = flags.String("vmwarevsphere-cloud-init-mode")
+	if d.CloudInitMode != cloudInitModeISO && d.CloudInitMode != cloudInitModeGuestInfo {
+		return fmt.Errorf("cloud-init mode %s not supported, use %s or %s", d.CloudInitMode, cloudInitModeISO, cloudInitModeGuestInfo)
+	}
+
 	d.VAppIpProtocol = flags.String("vmwarevsphere-vapp-ipprotocol")
 	d.VAppIpAllocationPolicy = flags.String("vmwarevsphere-vapp-ipallocationpolicy")
 	d.VAppTransport = flags.String("vmwarevsphere-vapp-transport")
@@ -304,6 +363,20 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 		}
 	}
 
+	if d.hasStaticIPs() && d.CreationType == creationTypeLegacy {
+		return fmt.Errorf("static NIC addresses are set through cloud-init and are not supported with creation type %s", creationTypeLegacy)
+	}
+
+	d.OvfProperties = flags.StringSlice("vmwarevsphere-ovf-property")
+	if len(d.OvfProperties) > 0 {
+		if d.CreationType != creationTypeLibrary {
+			return fmt.Errorf("--vmwarevsphere-ovf-property requires creation type %s", creationTypeLibrary)
+		}
+		if _, err := parseOvfProperties(d.OvfProperties); err != nil {
+			return err
+		}
+	}
+
 	d.GracefulShutdownTimeout = flags.Int("vmwarevsphere-graceful-shutdown-timeout")
 	if d.GracefulShutdownTimeout < 0 {
 		return errors.New("vmwarevsphere-graceful-shutdown-timeout can not be negative")
@@ -0,0 +1,176 @@
+package vmwarevsphere
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	networkTypeStandard    = "standard"
+	networkTypeDistributed = "distributed"
+)
+
+// NIC is a network adapter of the VM, attached to a standard or distributed
+// port group and optionally configured with a static address through the
+// cloud-init network config.
+type NIC struct {
+	Network string
+	Type    string
+	IP      string
+	Gateway string
+}
+
+// parseNIC parses a NIC given as network=NAME[,type=standard|distributed][,ip=ADDR/PREFIX][,gateway=ADDR].
+func parseNIC(spec string) (NIC, error) {
+	var nic NIC
+	options, err := mcnutils.ParseOptions("NIC", spec)
+	if err != nil {
+		return nic, err
+	}
+	for _, option := range options {
+		key, value := option.Key, option.Value
+		switch key {
+		case "network":
+			nic.Network = value
+		case "type":
+			if value != networkTypeStandard && value != networkTypeDistributed {
+				return nic, fmt.Errorf("invalid NIC type %q in %q, expected %s or %s", value, spec, networkTypeStandard, networkTypeDistributed)
+			}
+			nic.Type = value
+		case "ip":
+			if _, _, err := net.ParseCIDR(value); err != nil {
+				return nic, fmt.Errorf("invalid NIC ip %q in %q, expected an address with a prefix length: %s", value, spec, err)
+			}
+			nic.IP = value
+		case "gateway":
+			if net.ParseIP(value) == nil {
+				return nic, fmt.Errorf("invalid NIC gateway %q in %q", value, spec)
+			}
+			nic.Gateway = value
+		default:
+			return nic, fmt.Errorf("unknown NIC option %q in %q", key, spec)
+		}
+	}
+
+	if nic.Network == "" {
+		return nic, fmt.Errorf("NIC %q has no network", spec)
+	}
+	if nic.Gateway != "" && nic.IP == "" {
+		return nic, fmt.Errorf("NIC %q has a gateway but no ip", spec)
+	}
+	return nic, nil
+}
+
+func (d *Driver) hasStaticIPs() bool {
+	for _, nic := range d.NICs {
+		if nic.IP != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// findNetwork looks up a network by name, restricted to standard or
+// distributed port groups when the NIC attached to it asks for one.
+func (d *Driver) findNetwork(name string) (object.NetworkReference, error) {
+	var networkType string
+	for _, nic := range d.NICs {
+		if nic.Network == name {
+			networkType = nic.Type
+			break
+		}
+	}
+	if networkType == "" {
+		return d.finder.NetworkOrDefault(d.getCtx(), name)
+	}
+
+	networks, err := d.finder.NetworkList(d.getCtx(), name)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		switch n.(type) {
+		case *object.Network:
+			if networkType == networkTypeStandard {
+				return n, nil
+			}
+		case *object.DistributedVirtualPortgroup:
+			if networkType == networkTypeDistributed {
+				return n, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %s port group named %s found", networkType, name)
+}
+
+// nicMACs returns the MAC addresses of the network adapters of the VM, in the
+// order they were added.
+func (d *Driver) nicMACs(vm *object.VirtualMachine) ([]string, error) {
+	devices, err := vm.Device(d.getCtx())
+	if err != nil {
+		return nil, err
+	}
+
+	var macs []string
+	for _, dev := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		if card, ok := dev.(types.BaseVirtualEthernetCard); ok {
+			macs = append(macs, card.GetVirtualEthernetCard().MacAddress)
+		}
+	}
+	return macs, nil
+}
+
+// networkConfig renders a cloud-init network config (version 2) matching the
+// adapters by MAC address. NICs without a static address use DHCP.
+func networkConfig(nics []NIC, macs []string, nameservers []string) (map[string]interface{}, error) {
+	if len(macs) < len(nics) {
+		return nil, fmt.Errorf("the VM has %d network adapters, expected %d", len(macs), len(nics))
+	}
+
+	ethernets := map[string]interface{}{}
+	for i, nic := range nics {
+		eth := map[string]interface{}{
+			"match": map[string]interface{}{"macaddress": macs[i]},
+		}
+		if nic.IP == "" {
+			eth["dhcp4"] = true
+		} else {
+			eth["addresses"] = []string{nic.IP}
+			if nic.Gateway != "" {
+				if strings.Contains(nic.Gateway, ":") {
+					eth["gateway6"] = nic.Gateway
+				} else {
+					eth["gateway4"] = nic.Gateway
+				}
+			}
+			if len(nameservers) > 0 {
+				eth["nameservers"] = map[string]interface{}{"addresses": nameservers}
+			}
+		}
+		ethernets[fmt.Sprintf("nic%d", i)] = eth
+	}
+
+	return map[string]interface{}{
+		"version":   2,
+		"ethernets": ethernets,
+	}, nil
+}
+
+// cloudInitNetworkConfig returns the network config of the VM, or nil when no
+// NIC has a static address and the image defaults apply.
+func (d *Driver) cloudInitNetworkConfig(vm *object.VirtualMachine) (map[string]interface{}, error) {
+	if !d.hasStaticIPs() {
+		return nil, nil
+	}
+
+	macs, err := d.nicMACs(vm)
+	if err != nil {
+		return nil, err
+	}
+	return networkConfig(d.NICs, macs, d.Nameservers)
+}
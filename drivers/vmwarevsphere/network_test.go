@@ -0,0 +1,88 @@
+package vmwarevsphere
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNIC(t *testing.T) {
+	tests := []struct {
+		spec    string
+		nic     NIC
+		wantErr bool
+	}{
+		{spec: "network=VM Network", nic: NIC{Network: "VM Network"}},
+		{
+			spec: "network=dvpg-app,type=distributed,ip=10.0.0.5/24,gateway=10.0.0.1",
+			nic:  NIC{Network: "dvpg-app", Type: "distributed", IP: "10.0.0.5/24", Gateway: "10.0.0.1"},
+		},
+		{spec: "type=standard", wantErr: true},
+		{spec: "network=app,type=nsx", wantErr: true},
+		{spec: "network=app,ip=10.0.0.5", wantErr: true},
+		{spec: "network=app,gateway=10.0.0.1", wantErr: true},
+		{spec: "network=app,mtu=9000", wantErr: true},
+	}
+
+	for _, test := range tests {
+		nic, err := parseNIC(test.spec)
+		if test.wantErr {
+			assert.Error(t, err, test.spec)
+			continue
+		}
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.nic, nic)
+	}
+}
+
+func TestNICsReplaceNetworks(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevsphere-creation-type": "template",
+			"vmwarevsphere-clone-from":    "ubuntu",
+			"vmwarevsphere-nic":           []string{"network=mgmt", "network=app,type=distributed,ip=10.0.0.5/24"},
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mgmt", "app"}, driver.Networks)
+	assert.True(t, driver.hasStaticIPs())
+
+	checkFlags.FlagsValues["vmwarevsphere-network"] = []string{"VM Network"}
+	err = driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+}
+
+func TestNetworkConfig(t *testing.T) {
+	nics := []NIC{
+		{Network: "mgmt"},
+		{Network: "app", IP: "10.0.0.5/24", Gateway: "10.0.0.1"},
+	}
+	macs := []string{"00:50:56:00:00:01", "00:50:56:00:00:02"}
+
+	config, err := networkConfig(nics, macs, []string{"10.0.0.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"version": 2,
+		"ethernets": map[string]interface{}{
+			"nic0": map[string]interface{}{
+				"match": map[string]interface{}{"macaddress": "00:50:56:00:00:01"},
+				"dhcp4": true,
+			},
+			"nic1": map[string]interface{}{
+				"match":       map[string]interface{}{"macaddress": "00:50:56:00:00:02"},
+				"addresses":   []string{"10.0.0.5/24"},
+				"gateway4":    "10.0.0.1",
+				"nameservers": map[string]interface{}{"addresses": []string{"10.0.0.2"}},
+			},
+		},
+	}, config)
+
+	_, err = networkConfig(nics, macs[:1], nil)
+	assert.Error(t, err)
+}
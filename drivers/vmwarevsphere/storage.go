@@ -0,0 +1,209 @@
+package vmwarevsphere
+
+import (
+	"fmt"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/pbm"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	diskProvisioningThin             = "thin"
+	diskProvisioningThick            = "thick"
+	diskProvisioningEagerZeroedThick = "eagerZeroedThick"
+)
+
+var supportedDiskProvisioning = map[string]bool{
+	diskProvisioningThin:             true,
+	diskProvisioningThick:            true,
+	diskProvisioningEagerZeroedThick: true,
+}
+
+func (d *Driver) getPbmClient() (*pbm.Client, error) {
+	c, err := d.getSoapClient()
+	if err != nil {
+		return nil, err
+	}
+	return pbm.NewClient(d.getCtx(), c.Client)
+}
+
+// getStorageProfileID resolves the ID of the storage policy.
+func (d *Driver) getStorageProfileID() (string, error) {
+	if d.storageProfileID != "" {
+		return d.storageProfileID, nil
+	}
+
+	pc, err := d.getPbmClient()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := pc.ProfileIDByName(d.getCtx(), d.StoragePolicy)
+	if err != nil {
+		return "", fmt.Errorf("unable to find storage policy %s: %s", d.StoragePolicy, err)
+	}
+	d.storageProfileID = id
+	return id, nil
+}
+
+// compatibleDatastore picks the datastore with the most free space among the
+// ones compatible with the storage policy.
+func (d *Driver) compatibleDatastore() (*object.Datastore, error) {
+	profileID, err := d.getStorageProfileID()
+	if err != nil {
+		return nil, err
+	}
+
+	datastores, err := d.finder.DatastoreList(d.getCtx(), "*")
+	if err != nil {
+		return nil, err
+	}
+
+	var hubs []pbmtypes.PbmPlacementHub
+	for _, ds := range datastores {
+		hubs = append(hubs, pbmtypes.PbmPlacementHub{
+			HubType: ds.Reference().Type,
+			HubId:   ds.Reference().Value,
+		})
+	}
+
+	pc, err := d.getPbmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req := []pbmtypes.BasePbmPlacementRequirement{
+		&pbmtypes.PbmPlacementCapabilityProfileRequirement{
+			ProfileId: pbmtypes.PbmProfileId{UniqueId: profileID},
+		},
+	}
+	result, err := pc.CheckRequirements(d.getCtx(), hubs, nil, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, hub := range result.CompatibleDatastores() {
+		refs = append(refs, types.ManagedObjectReference{Type: hub.HubType, Value: hub.HubId})
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no datastore is compatible with storage policy %s", d.StoragePolicy)
+	}
+
+	c, err := d.getSoapClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var mds []mo.Datastore
+	if err := property.DefaultCollector(c.Client).Retrieve(d.getCtx(), refs, []string{"summary"}, &mds); err != nil {
+		return nil, err
+	}
+	if len(mds) == 0 {
+		return nil, fmt.Errorf("no datastore is compatible with storage policy %s", d.StoragePolicy)
+	}
+
+	best := mds[0]
+	for _, ds := range mds[1:] {
+		if ds.Summary.FreeSpace > best.Summary.FreeSpace {
+			best = ds
+		}
+	}
+
+	log.Infof("Using datastore %s compatible with storage policy %s", best.Summary.Name, d.StoragePolicy)
+	return object.NewDatastore(c.Client, best.Reference()), nil
+}
+
+// applyStoragePolicy assigns the storage policy to the VM home and all its
+// disks.
+func (d *Driver) applyStoragePolicy(vm *object.VirtualMachine) error {
+	if d.StoragePolicy == "" {
+		return nil
+	}
+
+	profileID, err := d.getStorageProfileID()
+	if err != nil {
+		return err
+	}
+	profile := []types.BaseVirtualMachineProfileSpec{
+		&types.VirtualMachineDefinedProfileSpec{ProfileId: profileID},
+	}
+
+	devices, err := vm.Device(d.getCtx())
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{VmProfile: profile}
+	for _, disk := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationEdit,
+			Device:    disk,
+			Profile:   profile,
+		})
+	}
+
+	log.Infof("Applying storage policy %s", d.StoragePolicy)
+	task, err := vm.Reconfigure(d.getCtx(), spec)
+	if err != nil {
+		return err
+	}
+	return task.Wait(d.getCtx())
+}
+
+// setDiskProvisioning sets the thin/thick provisioning of a disk backing.
+func setDiskProvisioning(backing types.BaseVirtualDeviceBackingInfo, provisioning string) {
+	b, ok := backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if !ok {
+		return
+	}
+
+	switch provisioning {
+	case diskProvisioningThin:
+		b.ThinProvisioned = types.NewBool(true)
+		b.EagerlyScrub = types.NewBool(false)
+	case diskProvisioningThick:
+		b.ThinProvisioned = types.NewBool(false)
+		b.EagerlyScrub = types.NewBool(false)
+	case diskProvisioningEagerZeroedThick:
+		b.ThinProvisioned = types.NewBool(false)
+		b.EagerlyScrub = types.NewBool(true)
+	}
+}
+
+// diskLocators converts the disks of the VM to clone to the provisioning type
+// while relocating them to the datastore.
+func (d *Driver) diskLocators(vm *object.VirtualMachine, ds types.ManagedObjectReference) ([]types.VirtualMachineRelocateSpecDiskLocator, error) {
+	devices, err := vm.Device(d.getCtx())
+	if err != nil {
+		return nil, err
+	}
+
+	var locators []types.VirtualMachineRelocateSpecDiskLocator
+	for _, disk := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		backing := &types.VirtualDiskFlatVer2BackingInfo{
+			DiskMode: string(types.VirtualDiskModePersistent),
+		}
+		setDiskProvisioning(backing, d.DiskProvisioning)
+		locators = append(locators, types.VirtualMachineRelocateSpecDiskLocator{
+			DiskId:          disk.GetVirtualDevice().Key,
+			Datastore:       ds,
+			DiskBackingInfo: backing,
+		})
+	}
+	return locators, nil
+}
+
+// ovfStorageProvisioning returns the disk provisioning of OVF deployments,
+// which are thin provisioned unless told otherwise.
+func (d *Driver) ovfStorageProvisioning() string {
+	if d.DiskProvisioning == "" {
+		return diskProvisioningThin
+	}
+	return d.DiskProvisioning
+}
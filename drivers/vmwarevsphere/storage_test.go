@@ -0,0 +1,49 @@
+package vmwarevsphere
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestSetDiskProvisioning(t *testing.T) {
+	tests := []struct {
+		provisioning string
+		thin         bool
+		eager        bool
+	}{
+		{provisioning: diskProvisioningThin, thin: true},
+		{provisioning: diskProvisioningThick},
+		{provisioning: diskProvisioningEagerZeroedThick, eager: true},
+	}
+
+	for _, test := range tests {
+		backing := &types.VirtualDiskFlatVer2BackingInfo{}
+		setDiskProvisioning(backing, test.provisioning)
+		assert.Equal(t, test.thin, *backing.ThinProvisioned, test.provisioning)
+		assert.Equal(t, test.eager, *backing.EagerlyScrub, test.provisioning)
+	}
+}
+
+func TestDiskProvisioningFlag(t *testing.T) {
+	driver := NewDriver("default", "path").(*Driver)
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevsphere-disk-provisioning": "sparse",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+
+	checkFlags.FlagsValues["vmwarevsphere-disk-provisioning"] = diskProvisioningEagerZeroedThick
+	checkFlags.FlagsValues["vmwarevsphere-storage-policy"] = "vSAN Default Storage Policy"
+	err = driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+	assert.Equal(t, diskProvisioningEagerZeroedThick, driver.ovfStorageProvisioning())
+	assert.Equal(t, "vSAN Default Storage Policy", driver.StoragePolicy)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vapi/library"
 	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/vcenter"
 	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/debug"
 	"github.com/vmware/govmomi/vim25/mo"
@@ -59,6 +60,11 @@ func (d *Driver) getDatastore(spec *types.VirtualMachineConfigSpec) (*object.Dat
 		return d.finder.Datastore(d.getCtx(), d.Datastore)
 	}
 
+	if d.StoragePolicy != "" {
+		log.Infof("Finding datastore compatible with storage policy %s", d.StoragePolicy)
+		return d.compatibleDatastore()
+	}
+
 	//nothing set, try default ds cluster then default ds
 	log.Infof("Finding default datastore cluster")
 	sp, err := d.finder.DefaultDatastoreCluster(d.getCtx())
@@ -292,6 +298,20 @@ func (d *Driver) findFolder() (*object.Folder, error) {
 	return folders.VmFolder, nil
 }
 
+// parseOvfProperties converts key=value pairs to the properties set when
+// deploying an OVF template.
+func parseOvfProperties(props []string) ([]vcenter.Property, error) {
+	var properties []vcenter.Property
+	for _, prop := range props {
+		key, value, ok := strings.Cut(prop, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid OVF property %q, expected key=value", prop)
+		}
+		properties = append(properties, vcenter.Property{ID: key, Value: value})
+	}
+	return properties, nil
+}
+
 func (d *Driver) getVAppConfig() *types.VmConfigSpec {
 	if d.VAppTransport != "com.vmware.guestInfo" && d.VAppTransport != "iso" {
 		return nil
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -51,13 +52,17 @@ type Driver struct {
 	IP                      string
 	Port                    int
 	Username                string
-	Password                string
+	Password                string `secret:"true"`
 	Network                 string
 	Networks                []string
+	NICs                    []NIC
+	Nameservers             []string
 	Tags                    []string
 	CustomAttributes        []string
 	Datastore               string
 	DatastoreCluster        string
+	StoragePolicy           string
+	DiskProvisioning        string
 	Datacenter              string
 	Folder                  string
 	Pool                    string
@@ -65,14 +70,16 @@ type Driver struct {
 	CfgParams               []string
 	CloudInit               string
 	CloudConfig             string
+	CloudInitMode           string
 	VAppIpProtocol          string
 	VAppIpAllocationPolicy  string
 	VAppTransport           string
 	VAppProperties          []string
+	OvfProperties           []string
 	CreationType            string
 	ContentLibrary          string
 	CloneFrom               string
-	SSHPassword             string
+	SSHPassword             string `secret:"true"`
 	SSHUserGroup            string
 	OS                      string
 	GracefulShutdownTimeout int
@@ -84,6 +91,7 @@ type Driver struct {
 	networks                map[string]object.NetworkReference
 	hostsystem              *object.HostSystem
 	resourcepool            *object.ResourcePool
+	storageProfileID        string
 }
 
 const (
@@ -150,7 +158,7 @@ func (d *Driver) GetURL() (string, error) {
 	if ip == "" {
 		return "", nil
 	}
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(d.GetEnginePort()))), nil
 }
 
 func (d *Driver) GetMachineId() (string, error) {
@@ -270,7 +278,7 @@ func (d *Driver) PreCreateCheck() error {
 		d.Networks = append(d.Networks, "VM Network")
 	}
 	for _, netName := range d.Networks {
-		if _, err := d.finder.NetworkOrDefault(d.getCtx(), netName); err != nil {
+		if _, err := d.findNetwork(netName); err != nil {
 			return err
 		}
 	}
@@ -533,7 +541,7 @@ func (d *Driver) Remove() error {
 				return nil
 			}
 		}
-	} else {
+	} else if d.CloudInitMode != cloudInitModeGuestInfo {
 		if err = d.removeCloudInitIso(vm, d.datacenter, ds); err != nil {
 			return err
 		}
@@ -20,3 +20,80 @@ func TestSetConfigFromFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, checkFlags.InvalidFlags)
 }
+
+func TestOvfProperties(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevsphere-creation-type":   "library",
+			"vmwarevsphere-content-library": "images",
+			"vmwarevsphere-clone-from":      "ubuntu",
+			"vmwarevsphere-ovf-property":    []string{"hostname=node-1", "user-data=I2Nsb3VkLWNvbmZpZw=="},
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.NoError(t, err)
+
+	properties, err := parseOvfProperties(driver.(*Driver).OvfProperties)
+	assert.NoError(t, err)
+	assert.Equal(t, "hostname", properties[0].ID)
+	assert.Equal(t, "node-1", properties[0].Value)
+	assert.Equal(t, "I2Nsb3VkLWNvbmZpZw==", properties[1].Value)
+
+	// the values are set as given, spaces included
+	properties, err = parseOvfProperties([]string{"motd= welcome "})
+	assert.NoError(t, err)
+	assert.Equal(t, " welcome ", properties[0].Value)
+
+	_, err = parseOvfProperties([]string{"hostname"})
+	assert.Error(t, err)
+}
+
+func TestOvfPropertiesRequireLibrary(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevsphere-creation-type": "template",
+			"vmwarevsphere-clone-from":    "ubuntu",
+			"vmwarevsphere-ovf-property":  []string{"hostname=node-1"},
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+}
+
+func TestCloudInitMode(t *testing.T) {
+	driver := NewDriver("default", "path")
+
+	checkFlags := &drivers.CheckDriverOptions{
+		FlagsValues: map[string]interface{}{
+			"vmwarevsphere-cloud-init-mode": "floppy",
+		},
+		CreateFlags: driver.GetCreateFlags(),
+	}
+
+	err := driver.SetConfigFromFlags(checkFlags)
+	assert.Error(t, err)
+}
+
+func TestGuestInfoOpts(t *testing.T) {
+	opts := guestInfoOpts("#cloud-config\n", "local-hostname: default\n")
+
+	values := map[string]interface{}{}
+	for _, opt := range opts {
+		values[opt.GetOptionValue().Key] = opt.GetOptionValue().Value
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"guestinfo.userdata":          "I2Nsb3VkLWNvbmZpZwo=",
+		"guestinfo.userdata.encoding": "base64",
+		"guestinfo.metadata":          "bG9jYWwtaG9zdG5hbWU6IGRlZmF1bHQK",
+		"guestinfo.metadata.encoding": "base64",
+	}, values)
+}
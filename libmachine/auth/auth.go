@@ -1,5 +1,7 @@
 package auth
 
+import "time"
+
 type Options struct {
 	CertDir              string
 	CaCertPath           string
@@ -12,7 +14,41 @@ type Options struct {
 	ServerKeyRemotePath  string
 	ClientCertPath       string
 	ServerCertSANs       []string
+	// ServerCertSubject is the subject of the server certificate, see
+	// cert.ParseSubject, the organization of the user and machine if empty
+	ServerCertSubject string `json:",omitempty"`
+	// KeyAlgorithm is the algorithm of the keys of the certificates, see
+	// cert.KeyAlgorithms, RSA if empty. The CA and client certificates, shared
+	// by the machines, only use it when they are generated.
+	KeyAlgorithm string `json:",omitempty"`
 	// StorePath is left in for historical reasons, but not really meant to
 	// be used directly.
 	StorePath string
+	// ExternalCA tells whether the CA is given rather than generated, in
+	// which case it is never generated or regenerated.
+	ExternalCA bool `json:",omitempty"`
+	// CaSignerCommand, if set, signs the certificates in place of the
+	// private key of the CA, e.g. through the PKI of a company.
+	CaSignerCommand string `json:",omitempty"`
+	// CertExpiry records when the certificates expire, as of their last
+	// generation.
+	CertExpiry *CertExpiry `json:",omitempty"`
+}
+
+// CertExpiry is when the certificates of a host expire.
+type CertExpiry struct {
+	CA     time.Time
+	Client time.Time
+	Server time.Time
+}
+
+// Earliest returns when the first of the certificates expires.
+func (e *CertExpiry) Earliest() time.Time {
+	earliest := e.CA
+	for _, t := range []time.Time{e.Client, e.Server} {
+		if t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
 }
@@ -0,0 +1,131 @@
+package libmachine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/host"
+)
+
+// CreateState is the progress of the creation of a host in a batch.
+type CreateState string
+
+const (
+	CreatePending   CreateState = "Pending"
+	CreateRunning   CreateState = "Creating"
+	CreateSucceeded CreateState = "Created"
+	CreateFailed    CreateState = "Failed"
+	CreateSkipped   CreateState = "Skipped"
+)
+
+// CreateStatus reports the creation of a host in a batch.
+type CreateStatus struct {
+	Name     string
+	State    CreateState
+	Err      error
+	Duration time.Duration
+}
+
+// BatchOptions configures BatchCreate.
+type BatchOptions struct {
+	// Parallelism is the number of hosts created at once, all of them if 0.
+	Parallelism int
+
+	// Progress, if not nil, is called when the creation of a host starts
+	// and when it ends. It may be called from several goroutines at once.
+	Progress func(CreateStatus)
+}
+
+// BatchCreate creates hosts concurrently with a pool of
+// opts.Parallelism workers. It returns the status of each host, in the
+// order of hosts; a host fails without affecting the others. When ctx is
+// done, the hosts being created are aborted and those not started are
+// skipped.
+func BatchCreate(ctx context.Context, api API, hosts []*host.Host, opts BatchOptions) []CreateStatus {
+	statuses := make([]CreateStatus, len(hosts))
+	for i, h := range hosts {
+		statuses[i] = CreateStatus{Name: h.Name, State: CreatePending}
+	}
+
+	// the hosts share the CA and client certificates, which would be
+	// generated concurrently otherwise
+	if err := bootstrapCertificates(hosts); err != nil {
+		for i := range statuses {
+			statuses[i].State, statuses[i].Err = CreateFailed, err
+		}
+		return statuses
+	}
+
+	workers := opts.Parallelism
+	if workers <= 0 || workers > len(hosts) {
+		workers = len(hosts)
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(CreateStatus) {}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				statuses[i] = batchCreateHost(ctx, api, hosts[i], progress)
+			}
+		}()
+	}
+
+	for i := range hosts {
+		if ctx.Err() == nil {
+			select {
+			case indexes <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		statuses[i].State, statuses[i].Err = CreateSkipped, ctx.Err()
+		progress(statuses[i])
+	}
+	close(indexes)
+	wg.Wait()
+
+	return statuses
+}
+
+func batchCreateHost(ctx context.Context, api API, h *host.Host, progress func(CreateStatus)) CreateStatus {
+	status := CreateStatus{Name: h.Name, State: CreateRunning}
+	progress(status)
+
+	start := time.Now()
+	err := api.CreateContext(ctx, h)
+	if err == nil {
+		err = api.Save(h)
+	}
+
+	status.Duration = time.Since(start)
+	if err != nil {
+		status.State, status.Err = CreateFailed, err
+	} else {
+		status.State = CreateSucceeded
+	}
+	progress(status)
+	return status
+}
+
+func bootstrapCertificates(hosts []*host.Host) error {
+	for _, h := range hosts {
+		if h.HostOptions == nil || h.HostOptions.CustomInstallScript != "" || h.HostOptions.AuthOptions == nil {
+			continue
+		}
+		if err := cert.BootstrapCertificates(h.AuthOptions()); err != nil {
+			return fmt.Errorf("Error generating certificates: %s", err)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package libmachine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCreator creates hosts, failing those in fail and blocking those in
+// block until the context is done.
+type fakeCreator struct {
+	API
+	fail  map[string]bool
+	block map[string]bool
+
+	lock    sync.Mutex
+	running int
+	maxRun  int
+	saved   []string
+}
+
+func (f *fakeCreator) CreateContext(ctx context.Context, h *host.Host) error {
+	f.lock.Lock()
+	f.running++
+	if f.running > f.maxRun {
+		f.maxRun = f.running
+	}
+	f.lock.Unlock()
+
+	defer func() {
+		f.lock.Lock()
+		f.running--
+		f.lock.Unlock()
+	}()
+
+	if f.block[h.Name] {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if f.fail[h.Name] {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (f *fakeCreator) Save(h *host.Host) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.saved = append(f.saved, h.Name)
+	return nil
+}
+
+func batchHosts(names ...string) []*host.Host {
+	hosts := []*host.Host{}
+	for _, name := range names {
+		hosts = append(hosts, &host.Host{Name: name})
+	}
+	return hosts
+}
+
+func TestBatchCreate(t *testing.T) {
+	api := &fakeCreator{fail: map[string]bool{"b": true}}
+	var lock sync.Mutex
+	var reported []CreateState
+
+	statuses := BatchCreate(context.Background(), api, batchHosts("a", "b", "c", "d"), BatchOptions{
+		Parallelism: 2,
+		Progress: func(s CreateStatus) {
+			lock.Lock()
+			defer lock.Unlock()
+			reported = append(reported, s.State)
+		},
+	})
+
+	assert.Len(t, statuses, 4)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		assert.Equal(t, name, statuses[i].Name)
+	}
+	assert.Equal(t, CreateSucceeded, statuses[0].State)
+	assert.Equal(t, CreateFailed, statuses[1].State)
+	assert.EqualError(t, statuses[1].Err, "boom")
+	assert.Equal(t, CreateSucceeded, statuses[2].State)
+	assert.Equal(t, CreateSucceeded, statuses[3].State)
+	assert.ElementsMatch(t, []string{"a", "c", "d"}, api.saved)
+	assert.True(t, api.maxRun <= 2)
+	assert.Len(t, reported, 8)
+}
+
+func TestBatchCreateSkipsHostsWhenCancelled(t *testing.T) {
+	api := &fakeCreator{block: map[string]bool{"a": true}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	statuses := BatchCreate(ctx, api, batchHosts("a", "b"), BatchOptions{
+		Parallelism: 1,
+		Progress: func(s CreateStatus) {
+			if s.Name == "a" && s.State == CreateRunning {
+				cancel()
+			}
+		},
+	})
+
+	assert.Equal(t, CreateFailed, statuses[0].State)
+	assert.Equal(t, context.Canceled, statuses[0].Err)
+	assert.Equal(t, CreateSkipped, statuses[1].State)
+	assert.Empty(t, api.saved)
+}
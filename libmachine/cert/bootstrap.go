@@ -21,7 +21,7 @@ func createCACert(authOptions *auth.Options, caOrg string, bits int) error {
 		return errors.New("certificate authority key already exists")
 	}
 
-	if err := GenerateCACertificate(caCertPath, caPrivateKeyPath, caOrg, bits); err != nil {
+	if err := GenerateCACertificateKey(caCertPath, caPrivateKeyPath, caOrg, KeyAlgorithm(authOptions.KeyAlgorithm), bits); err != nil {
 		return fmt.Errorf("generating CA certificate failed: %s", err)
 	}
 
@@ -62,6 +62,9 @@ func createCert(authOptions *auth.Options, org string, bits int) error {
 		Org:         org,
 		Bits:        bits,
 		SwarmMaster: false,
+
+		SignerCommand: authOptions.CaSignerCommand,
+		KeyAlgorithm:  KeyAlgorithm(authOptions.KeyAlgorithm),
 	}
 
 	if err := GenerateCert(certOptions); err != nil {
@@ -71,6 +74,17 @@ func createCert(authOptions *auth.Options, org string, bits int) error {
 	return nil
 }
 
+// RenewClientCertificate generates the client certificate again, signed by
+// the CA, e.g. before it expires.
+func RenewClientCertificate(authOptions *auth.Options) error {
+	org := mcnutils.GetUsername() + ".<bootstrap>"
+
+	if err := os.Remove(authOptions.ClientKeyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return createCert(authOptions, org, 2048)
+}
+
 func BootstrapCertificates(authOptions *auth.Options) error {
 	certDir := authOptions.CertDir
 	caCertPath := authOptions.CaCertPath
@@ -96,7 +110,16 @@ func BootstrapCertificates(authOptions *auth.Options) error {
 		}
 	}
 
-	if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
+	if authOptions.ExternalCA {
+		// the CA of a company cannot be regenerated by machine
+		current, err := CheckCertificateDate(caCertPath)
+		if err != nil {
+			return fmt.Errorf("reading the external CA certificate failed: %s", err)
+		}
+		if !current {
+			return fmt.Errorf("the external CA certificate %s has expired", caCertPath)
+		}
+	} else if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
 		if err := createCACert(authOptions, caOrg, bits); err != nil {
 			return err
 		}
@@ -2,7 +2,6 @@ package cert
 
 import (
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -12,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"errors"
@@ -27,6 +27,14 @@ type Options struct {
 	CertFile, KeyFile, CAFile, CAKeyFile, Org string
 	Bits                                      int
 	SwarmMaster                               bool
+	// SignerCommand, if set, signs the certificate in place of CAKeyFile,
+	// see signWithCommand
+	SignerCommand string
+	// Subject, if set, is the subject of the certificate in place of Org,
+	// see ParseSubject
+	Subject string
+	// KeyAlgorithm, if set, is the algorithm of the key in place of Bits
+	KeyAlgorithm KeyAlgorithm
 }
 
 type Generator interface {
@@ -36,6 +44,12 @@ type Generator interface {
 	ValidateCertificate(addr string, authOptions *auth.Options) (bool, error)
 }
 
+// KeyGenerator is implemented by the generators supporting the key
+// algorithms other than RSA.
+type KeyGenerator interface {
+	GenerateCACertificateKey(certFile, keyFile, org string, algorithm KeyAlgorithm) error
+}
+
 type X509CertGenerator struct{}
 
 func NewX509CertGenerator() Generator {
@@ -46,6 +60,16 @@ func GenerateCACertificate(certFile, keyFile, org string, bits int) error {
 	return defaultGenerator.GenerateCACertificate(certFile, keyFile, org, bits)
 }
 
+// GenerateCACertificateKey generates a certificate authority with a key of
+// algorithm, falling back to an RSA key of bits if the generator does not
+// support the algorithms.
+func GenerateCACertificateKey(certFile, keyFile, org string, algorithm KeyAlgorithm, bits int) error {
+	if kg, ok := defaultGenerator.(KeyGenerator); ok && algorithm != "" {
+		return kg.GenerateCACertificateKey(certFile, keyFile, org, algorithm)
+	}
+	return defaultGenerator.GenerateCACertificate(certFile, keyFile, org, bits)
+}
+
 func GenerateCert(opts *Options) error {
 	return defaultGenerator.GenerateCert(opts)
 }
@@ -114,6 +138,16 @@ func (xcg *X509CertGenerator) newCertificate(org string) (*x509.Certificate, err
 // and bit size and stores the resulting certificate and key file
 // in the arguments.
 func (xcg *X509CertGenerator) GenerateCACertificate(certFile, keyFile, org string, bits int) error {
+	return xcg.generateCACertificate(certFile, keyFile, org, "", bits)
+}
+
+// GenerateCACertificateKey generates a new certificate authority like
+// GenerateCACertificate, with a key of algorithm.
+func (xcg *X509CertGenerator) GenerateCACertificateKey(certFile, keyFile, org string, algorithm KeyAlgorithm) error {
+	return xcg.generateCACertificate(certFile, keyFile, org, algorithm, 0)
+}
+
+func (xcg *X509CertGenerator) generateCACertificate(certFile, keyFile, org string, algorithm KeyAlgorithm, bits int) error {
 	template, err := xcg.newCertificate(org)
 	if err != nil {
 		return err
@@ -124,12 +158,12 @@ func (xcg *X509CertGenerator) GenerateCACertificate(certFile, keyFile, org strin
 	template.KeyUsage |= x509.KeyUsageKeyEncipherment
 	template.KeyUsage |= x509.KeyUsageKeyAgreement
 
-	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	priv, err := newKey(algorithm, bits)
 	if err != nil {
 		return err
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
 	if err != nil {
 		return err
 	}
@@ -142,16 +176,7 @@ func (xcg *X509CertGenerator) GenerateCACertificate(certFile, keyFile, org strin
 	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	certOut.Close()
 
-	keyOut, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
-
-	}
-
-	pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-	keyOut.Close()
-
-	return nil
+	return writeKey(keyFile, priv)
 }
 
 // GenerateCert generates a new certificate signed using the provided
@@ -163,6 +188,11 @@ func (xcg *X509CertGenerator) GenerateCert(opts *Options) error {
 	if err != nil {
 		return err
 	}
+	if opts.Subject != "" {
+		if template.Subject, err = ParseSubject(opts.Subject); err != nil {
+			return err
+		}
+	}
 	// client
 	if len(opts.Hosts) == 1 && opts.Hosts[0] == "" {
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
@@ -175,8 +205,18 @@ func (xcg *X509CertGenerator) GenerateCert(opts *Options) error {
 			// nodes as a client.
 			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
 		}
-		for _, h := range opts.Hosts {
-			if ip := net.ParseIP(h); ip != nil {
+		hosts := opts.Hosts
+		// the clients ignore the common name, which has to be in the SANs
+		if cn := template.Subject.CommonName; cn != "" {
+			hosts = append([]string{cn}, hosts...)
+		}
+		seen := map[string]bool{}
+		for _, h := range hosts {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			if ip := parseSANIP(h); ip != nil {
 				template.IPAddresses = append(template.IPAddresses, ip)
 			} else {
 				template.DNSNames = append(template.DNSNames, h)
@@ -184,43 +224,50 @@ func (xcg *X509CertGenerator) GenerateCert(opts *Options) error {
 		}
 	}
 
-	tlsCert, err := tls.LoadX509KeyPair(opts.CAFile, opts.CAKeyFile)
+	priv, err := newKey(opts.KeyAlgorithm, opts.Bits)
 	if err != nil {
 		return err
 	}
 
-	priv, err := rsa.GenerateKey(rand.Reader, opts.Bits)
-	if err != nil {
-		return err
-	}
+	var certPEM []byte
+	if opts.SignerCommand != "" {
+		if certPEM, err = signWithCommand(opts.SignerCommand, template, priv); err != nil {
+			return err
+		}
+	} else {
+		tlsCert, err := tls.LoadX509KeyPair(opts.CAFile, opts.CAKeyFile)
+		if err != nil {
+			return err
+		}
 
-	x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
-	if err != nil {
-		return err
-	}
+		x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			return err
+		}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, x509Cert, &priv.PublicKey, tlsCert.PrivateKey)
-	if err != nil {
-		return err
+		derBytes, err := x509.CreateCertificate(rand.Reader, template, x509Cert, priv.Public(), tlsCert.PrivateKey)
+		if err != nil {
+			return err
+		}
+		certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	}
 
-	certOut, err := os.Create(opts.CertFile)
-	if err != nil {
+	if err := ioutil.WriteFile(opts.CertFile, certPEM, 0644); err != nil {
 		return err
 	}
 
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	certOut.Close()
+	return writeKey(opts.KeyFile, priv)
+}
 
-	keyOut, err := os.OpenFile(opts.KeyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+// parseSANIP parses a host of the SANs as an IP, IPv6 addresses being
+// possibly bracketed or scoped, e.g. [fe80::1%eth0], which a certificate
+// cannot hold.
+func parseSANIP(host string) net.IP {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i > 0 {
+		host = host[:i]
 	}
-
-	pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-	keyOut.Close()
-
-	return nil
+	return net.ParseIP(host)
 }
 
 // ReadTLSConfig reads the tls config for a machine.
@@ -277,26 +324,36 @@ func (xcg *X509CertGenerator) ValidateCertificate(addr string, authOptions *auth
 }
 
 func CheckCertificateDate(certPath string) (bool, error) {
+	notAfter, err := CertificateNotAfter(certPath)
+	if err != nil {
+		return false, err
+	}
+	if time.Now().After(notAfter) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// CertificateNotAfter returns when the certificate at certPath expires.
+func CertificateNotAfter(certPath string) (time.Time, error) {
 	log.Debugf("Reading certificate data from %s", certPath)
 	certBytes, err := ioutil.ReadFile(certPath)
 	if err != nil {
-		return false, err
+		return time.Time{}, err
 	}
 
 	log.Debug("Decoding PEM data...")
 	pemBlock, _ := pem.Decode(certBytes)
 	if pemBlock == nil {
-		return false, errors.New("Failed to decode PEM data")
+		return time.Time{}, errors.New("Failed to decode PEM data")
 	}
 
 	log.Debug("Parsing certificate...")
 	cert, err := x509.ParseCertificate(pemBlock.Bytes)
 	if err != nil {
-		return false, err
-	}
-	if time.Now().After(cert.NotAfter) {
-		return false, nil
+		return time.Time{}, err
 	}
 
-	return true, nil
+	return cert.NotAfter, nil
 }
@@ -1,10 +1,19 @@
 package cert
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/rancher/machine/libmachine/auth"
 )
 
 func TestGenerateCACertificate(t *testing.T) {
@@ -79,3 +88,300 @@ func TestGenerateCert(t *testing.T) {
 		t.Fatalf("key not created at %s", keyPath)
 	}
 }
+
+func TestGenerateCertIPv6SANs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cleanup
+	defer os.RemoveAll(tmpDir)
+
+	caCertPath := filepath.Join(tmpDir, "ca.pem")
+	caKeyPath := filepath.Join(tmpDir, "key.pem")
+	certPath := filepath.Join(tmpDir, "cert.pem")
+	if err := GenerateCACertificate(caCertPath, caKeyPath, "test-org", 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{
+		Hosts:     []string{"[2001:db8::1]", "fe80::1%eth0", "localhost"},
+		CertFile:  certPath,
+		CAKeyFile: caKeyPath,
+		CAFile:    caCertPath,
+		KeyFile:   filepath.Join(tmpDir, "cert-key.pem"),
+		Org:       "test-org",
+		Bits:      2048,
+	}
+	if err := GenerateCert(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cert.IPAddresses) != 2 || cert.IPAddresses[0].String() != "2001:db8::1" || cert.IPAddresses[1].String() != "fe80::1" {
+		t.Fatalf("Expected the IPv6 addresses in the SANs, got %v", cert.IPAddresses)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "localhost" {
+		t.Fatalf("Expected localhost in the DNS SANs, got %v", cert.DNSNames)
+	}
+}
+
+func TestCertificateNotAfter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	caCertPath := filepath.Join(tmpDir, "ca.pem")
+	if err := GenerateCACertificate(caCertPath, filepath.Join(tmpDir, "key.pem"), "test-org", 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter, err := CertificateNotAfter(caCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notAfter.Before(time.Now().Add(1000 * 24 * time.Hour)) {
+		t.Fatalf("Expected the certificate to expire in 1080 days, got %s", notAfter)
+	}
+}
+
+// TestSignerHelper is the CA signer run by TestGenerateCertWithSigner, signing
+// the CSR read from stdin with the CA of MACHINE_TEST_SIGNER_CA.
+func TestSignerHelper(t *testing.T) {
+	caPath := os.Getenv("MACHINE_TEST_SIGNER_CA")
+	if caPath == "" {
+		return
+	}
+
+	in, _ := ioutil.ReadAll(os.Stdin)
+	block, _ := pem.Decode(in)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		os.Exit(1)
+	}
+	ca, err := tls.LoadX509KeyPair(caPath, caPath+".key")
+	if err != nil {
+		os.Exit(1)
+	}
+	caCert, _ := x509.ParseCertificate(ca.Certificate[0])
+
+	usage := x509.ExtKeyUsageServerAuth
+	if os.Getenv("MACHINE_CERT_USAGE") == "client" {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, ca.PrivateKey)
+	if err != nil {
+		os.Exit(1)
+	}
+	pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	os.Exit(0)
+}
+
+func TestGenerateCertWithSigner(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	caCertPath := filepath.Join(tmpDir, "ca.pem")
+	if err := GenerateCACertificate(caCertPath, caCertPath+".key", "test-org", 2048); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("MACHINE_TEST_SIGNER_CA", caCertPath)
+	defer os.Unsetenv("MACHINE_TEST_SIGNER_CA")
+
+	certPath := filepath.Join(tmpDir, "cert.pem")
+	opts := &Options{
+		Hosts:         []string{"10.0.0.1", "machine.example.com"},
+		CertFile:      certPath,
+		KeyFile:       filepath.Join(tmpDir, "key.pem"),
+		CAFile:        caCertPath,
+		Org:           "test-org",
+		Bits:          2048,
+		SignerCommand: fmt.Sprintf("'%s' -test.run '^TestSignerHelper$'", os.Args[0]),
+	}
+	if err := GenerateCert(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	certBytes, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certBytes)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.IPAddresses) != 1 || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "machine.example.com" {
+		t.Fatalf("Expected the SANs of the CSR, got %v %v", cert.IPAddresses, cert.DNSNames)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, opts.KeyFile); err != nil {
+		t.Fatalf("Expected the certificate to match the key: %s", err)
+	}
+
+	opts.SignerCommand = "echo nope"
+	if err := GenerateCert(opts); err == nil {
+		t.Fatal("Expected an error with a signer returning no certificate")
+	}
+}
+
+func TestBootstrapCertificatesExternalCA(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	authOptions := &auth.Options{
+		CertDir:          tmpDir,
+		CaCertPath:       filepath.Join(tmpDir, "ca.pem"),
+		CaPrivateKeyPath: filepath.Join(tmpDir, "ca-key.pem"),
+		ClientCertPath:   filepath.Join(tmpDir, "cert.pem"),
+		ClientKeyPath:    filepath.Join(tmpDir, "key.pem"),
+		ExternalCA:       true,
+	}
+
+	if err := BootstrapCertificates(authOptions); err == nil {
+		t.Fatal("Expected an error without the external CA")
+	}
+	if _, err := os.Stat(authOptions.CaCertPath); !os.IsNotExist(err) {
+		t.Fatal("Expected the external CA not to be generated")
+	}
+
+	if err := GenerateCACertificate(authOptions.CaCertPath, authOptions.CaPrivateKeyPath, "corp", 2048); err != nil {
+		t.Fatal(err)
+	}
+	if err := BootstrapCertificates(authOptions); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(authOptions.ClientCertPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	name, err := ParseSubject("CN=docker.example.com, O=Example,OU=Infra,OU=Docker,C=NL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.CommonName != "docker.example.com" || len(name.Organization) != 1 || len(name.OrganizationalUnit) != 2 || name.Country[0] != "NL" {
+		t.Fatalf("Unexpected subject %+v", name)
+	}
+
+	for _, subject := range []string{"CN", "CN=", "XX=1", "CN=a,CN=b"} {
+		if _, err := ParseSubject(subject); err == nil {
+			t.Fatalf("Expected an error parsing %q", subject)
+		}
+	}
+}
+
+func TestGenerateCertSubject(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	caCertPath := filepath.Join(tmpDir, "ca.pem")
+	caKeyPath := filepath.Join(tmpDir, "ca-key.pem")
+	if err := GenerateCACertificate(caCertPath, caKeyPath, "test-org", 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(tmpDir, "cert.pem")
+	if err := GenerateCert(&Options{
+		Hosts:     []string{"10.0.0.1", "docker.example.com"},
+		CertFile:  certPath,
+		KeyFile:   filepath.Join(tmpDir, "key.pem"),
+		CAFile:    caCertPath,
+		CAKeyFile: caKeyPath,
+		Org:       "test-org",
+		Bits:      2048,
+		Subject:   "CN=docker.example.com,O=Example",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	certBytes, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certBytes)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "docker.example.com" || cert.Subject.Organization[0] != "Example" {
+		t.Fatalf("Expected the subject given, got %s", cert.Subject)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "docker.example.com" {
+		t.Fatalf("Expected the common name once in the SANs, got %v", cert.DNSNames)
+	}
+}
+
+func TestGenerateCertKeyAlgorithms(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{ECDSAP256, Ed25519} {
+		tmpDir, err := ioutil.TempDir("", "machine-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		caCertPath := filepath.Join(tmpDir, "ca.pem")
+		caKeyPath := filepath.Join(tmpDir, "ca-key.pem")
+		if err := GenerateCACertificateKey(caCertPath, caKeyPath, "test-org", algorithm, 2048); err != nil {
+			t.Fatal(err)
+		}
+
+		certPath := filepath.Join(tmpDir, "cert.pem")
+		keyPath := filepath.Join(tmpDir, "key.pem")
+		if err := GenerateCert(&Options{
+			Hosts:        []string{"10.0.0.1"},
+			CertFile:     certPath,
+			KeyFile:      keyPath,
+			CAFile:       caCertPath,
+			CAKeyFile:    caKeyPath,
+			Org:          "test-org",
+			KeyAlgorithm: algorithm,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			t.Fatalf("%s: %s", algorithm, err)
+		}
+		ed25519, err := UsesEd25519(caCertPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ed25519 != (algorithm == Ed25519) {
+			t.Fatalf("%s: unexpected Ed25519 detection %t", algorithm, ed25519)
+		}
+	}
+
+	if _, err := ParseKeyAlgorithm("dsa-1024"); err == nil {
+		t.Fatal("Expected an error parsing an unsupported algorithm")
+	}
+}
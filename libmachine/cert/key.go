@@ -0,0 +1,114 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// KeyAlgorithm is the algorithm of the keys of the certificates.
+type KeyAlgorithm string
+
+const (
+	RSA2048   KeyAlgorithm = "rsa-2048"
+	RSA4096   KeyAlgorithm = "rsa-4096"
+	ECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	// Ed25519 keys are not supported by the engines built with Go before
+	// 1.13, e.g. Docker 19.03
+	Ed25519 KeyAlgorithm = "ed25519"
+
+	DefaultKeyAlgorithm = RSA2048
+)
+
+// KeyAlgorithms are the algorithms supported, in the order of their
+// compatibility.
+var KeyAlgorithms = []KeyAlgorithm{RSA2048, RSA4096, ECDSAP256, Ed25519}
+
+// ParseKeyAlgorithm returns the algorithm named s, the default one if s is
+// empty.
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	if s == "" {
+		return DefaultKeyAlgorithm, nil
+	}
+	for _, algorithm := range KeyAlgorithms {
+		if KeyAlgorithm(s) == algorithm {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported key algorithm %q, expected one of %v", s, KeyAlgorithms)
+}
+
+// newKey generates a key of algorithm, or an RSA key of bits if algorithm is
+// empty.
+func newKey(algorithm KeyAlgorithm, bits int) (crypto.Signer, error) {
+	switch algorithm {
+	case "":
+		return rsa.GenerateKey(rand.Reader, bits)
+	case RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+// encodeKey encodes key in PEM, the RSA keys as PKCS #1 for the engines
+// predating PKCS #8.
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// writeKey writes key to keyFile, readable by its owner only.
+func writeKey(keyFile string, key crypto.Signer) error {
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
+
+// UsesEd25519 tells whether the certificate at certPath has an Ed25519 key.
+func UsesEd25519(certPath string) (bool, error) {
+	certBytes, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(certBytes)
+	if block == nil {
+		return false, fmt.Errorf("failed to decode the PEM data of %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false, err
+	}
+	return cert.PublicKeyAlgorithm == x509.Ed25519, nil
+}
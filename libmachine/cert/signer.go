@@ -0,0 +1,66 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// signWithCommand has the certificate of template signed by an external CA
+// through command, run by the shell. The command reads a PEM encoded
+// certificate signing request from stdin and writes the PEM encoded
+// certificate to stdout, possibly followed by the intermediate certificates.
+// MACHINE_CERT_USAGE tells it whether the certificate is for a server or a
+// client.
+func signWithCommand(command string, template *x509.Certificate, priv crypto.Signer) ([]byte, error) {
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     template.Subject,
+		DNSNames:    template.DNSNames,
+		IPAddresses: template.IPAddresses,
+	}, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := "server"
+	if len(template.ExtKeyUsage) == 1 && template.ExtKeyUsage[0] == x509.ExtKeyUsageClientAuth {
+		usage = "client"
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "MACHINE_CERT_USAGE="+usage)
+	cmd.Stdin = bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("CA signer failed to sign the %s certificate: %s: %s", usage, err, strings.TrimSpace(stderr.String()))
+	}
+
+	block, _ := pem.Decode(stdout.Bytes())
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("CA signer returned no PEM encoded certificate")
+	}
+	signed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("CA signer returned an invalid certificate: %s", err)
+	}
+	if pub, ok := signed.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(priv.Public()) {
+		return nil, errors.New("CA signer returned a certificate for another key")
+	}
+
+	return stdout.Bytes(), nil
+}
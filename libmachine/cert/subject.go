@@ -0,0 +1,42 @@
+package cert
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+)
+
+// ParseSubject parses the subject of a certificate given as comma separated
+// attributes, e.g. CN=docker.example.com,O=Example,OU=Infra. The attributes
+// are CN, O, OU, L, ST and C, all but CN may be given several times.
+func ParseSubject(subject string) (pkix.Name, error) {
+	name := pkix.Name{}
+	for _, attribute := range strings.Split(subject, ",") {
+		kv := strings.SplitN(strings.TrimSpace(attribute), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return name, fmt.Errorf("invalid attribute %q of the subject, expected key=value", attribute)
+		}
+
+		key, value := strings.ToUpper(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		switch key {
+		case "CN":
+			if name.CommonName != "" {
+				return name, fmt.Errorf("CN given several times in the subject")
+			}
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		default:
+			return name, fmt.Errorf("unsupported attribute %s of the subject, expected CN, O, OU, L, ST or C", key)
+		}
+	}
+	return name, nil
+}
@@ -3,11 +3,12 @@ package check
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
-	"strings"
 
 	"github.com/rancher/machine/libmachine/auth"
 	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/host"
 )
 
@@ -40,7 +41,11 @@ type ConnChecker interface {
 type MachineConnChecker struct{}
 
 func (mcc *MachineConnChecker) Check(h *host.Host, swarm bool) (string, *auth.Options, error) {
-	dockerHost, err := h.Driver.GetURL()
+	if h.HostOptions != nil && h.HostOptions.EngineOptions.IsContainerd() {
+		return "", &auth.Options{}, fmt.Errorf("%s runs containerd, which has no Docker API", h.Name)
+	}
+
+	dockerHost, err := h.URL()
 	if err != nil {
 		return "", &auth.Options{}, err
 	}
@@ -61,6 +66,11 @@ func (mcc *MachineConnChecker) Check(h *host.Host, swarm bool) (string, *auth.Op
 	authOptions := h.AuthOptions()
 
 	if err := checkCert(u.Host, authOptions); err != nil {
+		if certErr, ok := err.(ErrCertInvalid); ok && certErr.wrappedErr != nil && drivers.IsEnginePrivate(h.Driver) {
+			// regenerating the certificates would not help
+			return "", &auth.Options{}, fmt.Errorf("The Docker daemon of %s is reachable from its private network only, %s cannot be checked from outside of it: %s", h.Name, u.Host, certErr.wrappedErr)
+		}
+
 		if swarm {
 			// Connection to the swarm port cannot be checked. Maybe it's just the swarm containers that are down
 			// TODO: check the containers and restart them
@@ -100,8 +110,7 @@ func parseSwarm(hostURL string, h *host.Host) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("There was an error parsing the url: %s", err)
 	}
-	parts := strings.Split(u.Host, ":")
-	swarmPort := parts[1]
+	swarmPort := u.Port()
 
 	// get IP of machine to replace in case swarm host is 0.0.0.0
 	mURL, err := url.Parse(hostURL)
@@ -109,10 +118,7 @@ func parseSwarm(hostURL string, h *host.Host) (string, error) {
 		return "", fmt.Errorf("There was an error parsing the url: %s", err)
 	}
 
-	mParts := strings.Split(mURL.Host, ":")
-	machineIP := mParts[0]
-
-	hostURL = fmt.Sprintf("tcp://%s:%s", machineIP, swarmPort)
+	hostURL = fmt.Sprintf("tcp://%s", net.JoinHostPort(mURL.Hostname(), swarmPort))
 
 	return hostURL, nil
 }
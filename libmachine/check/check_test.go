@@ -6,8 +6,12 @@ import (
 
 	"crypto/tls"
 
+	"github.com/rancher/machine/drivers/fakedriver"
 	"github.com/rancher/machine/libmachine/auth"
 	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/state"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,3 +62,35 @@ func TestCheckCert(t *testing.T) {
 		assert.Equal(t, c.expectedErr, err)
 	}
 }
+
+func TestCheckContainerdHost(t *testing.T) {
+	h := &host.Host{
+		Name: "k3s",
+		HostOptions: &host.Options{
+			EngineOptions: &engine.Options{Runtime: engine.RuntimeContainerd},
+		},
+	}
+
+	_, _, err := DefaultConnChecker.Check(h, false)
+	assert.EqualError(t, err, "k3s runs containerd, which has no Docker API")
+}
+
+// privateEngineDriver has a Docker daemon reachable from its private network
+// only.
+type privateEngineDriver struct {
+	*fakedriver.Driver
+}
+
+func (d *privateEngineDriver) IsEnginePrivate() bool { return true }
+
+func TestCheckPrivateEngine(t *testing.T) {
+	cert.SetCertGenerator(FakeCertGenerator{fakeValidateCertificate: &FakeValidateCertificate{false, errors.New("i/o timeout")}})
+	h := &host.Host{
+		Name:        "ssm",
+		Driver:      &privateEngineDriver{Driver: &fakedriver.Driver{MockIP: "10.0.0.5", MockState: state.Running}},
+		HostOptions: &host.Options{EngineOptions: &engine.Options{}, AuthOptions: &auth.Options{}},
+	}
+
+	_, _, err := DefaultConnChecker.Check(h, false)
+	assert.EqualError(t, err, "The Docker daemon of ssm is reachable from its private network only, 10.0.0.5:2376 cannot be checked from outside of it: i/o timeout")
+}
@@ -0,0 +1,140 @@
+package cloudinit
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// Disk formats of the images, as used by the drivers.
+const (
+	FormatVMDK  = "vmdk"
+	FormatVHD   = "vhd"
+	FormatQCOW2 = "qcow2"
+)
+
+// images are the aliases of cloud images known to work with a NoCloud seed,
+// by disk format. Other images are given by URL or path.
+var images = map[string]map[string]string{
+	"ubuntu": {
+		FormatVMDK:  "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-amd64.vmdk",
+		FormatQCOW2: "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-amd64.img",
+	},
+}
+
+// ResolveImage returns the URL or the path of an image given by alias, URL
+// or path.
+func ResolveImage(image, format string) (string, error) {
+	if formats, ok := images[image]; ok {
+		if u, ok := formats[format]; ok {
+			return u, nil
+		}
+		return "", fmt.Errorf("no %s image for %q, use the URL or the path of an image instead", format, image)
+	}
+	return image, nil
+}
+
+func isRemote(image string) bool {
+	u, err := url.Parse(image)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// CacheImage returns the path of an uncompressed image, downloading it to
+// the cache directory of the store first if needed. Images are cached by
+// file name, so a new release must be published under a new name.
+func CacheImage(storePath, image, format string) (string, error) {
+	image, err := ResolveImage(image, format)
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir := filepath.Join(storePath, "cache")
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", err
+	}
+
+	name := path.Base(image)
+	if !isRemote(image) {
+		image = strings.TrimPrefix(image, "file://")
+		name = filepath.Base(image)
+	}
+	ext := filepath.Ext(name)
+	if ext == ".gz" || ext == ".bz2" {
+		name = strings.TrimSuffix(name, ext)
+	} else if !isRemote(image) {
+		// Local images are used in place.
+		return image, nil
+	}
+
+	dest := filepath.Join(cacheDir, name)
+	if _, err := os.Stat(dest); err == nil {
+		log.Debugf("Using cached image %s", dest)
+		return dest, nil
+	}
+
+	log.Infof("Downloading %s to %s...", image, dest)
+	if err := download(image, ext, dest); err != nil {
+		return "", fmt.Errorf("error downloading the image %s: %s", image, err)
+	}
+	return dest, nil
+}
+
+func download(image, ext, dest string) error {
+	var src io.ReadCloser
+	if isRemote(image) {
+		resp, err := http.Get(image)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		src = resp.Body
+	} else {
+		f, err := os.Open(image)
+		if err != nil {
+			return err
+		}
+		src = f
+	}
+	defer src.Close()
+
+	var r io.Reader = src
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		r = gz
+	case ".bz2":
+		r = bzip2.NewReader(src)
+	}
+
+	// Download to a temp file first then rename it to avoid partial download.
+	f, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
@@ -0,0 +1,50 @@
+package cloudinit
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveImage(t *testing.T) {
+	image, err := ResolveImage("ubuntu", FormatVMDK)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-amd64.vmdk", image)
+
+	_, err = ResolveImage("ubuntu", FormatVHD)
+	assert.EqualError(t, err, `no vhd image for "ubuntu", use the URL or the path of an image instead`)
+
+	image, err = ResolveImage("/images/disk.vhdx", FormatVHD)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/disk.vhdx", image)
+}
+
+func TestCacheImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudinit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "disk.vmdk")
+	path, err := CacheImage(dir, local, FormatVMDK)
+	assert.NoError(t, err)
+	assert.Equal(t, local, path)
+
+	f, err := os.Create(filepath.Join(dir, "disk.vmdk.gz"))
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("disk"))
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, f.Close())
+
+	path, err = CacheImage(dir, "file://"+f.Name(), FormatVMDK)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "cache", "disk.vmdk"), path)
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "disk", string(content))
+}
@@ -0,0 +1,187 @@
+// Package cloudinit boots standard cloud images on local hypervisors: it
+// caches the images and generates the NoCloud seed ISO cloud-init reads
+// its configuration from.
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+)
+
+const (
+	// SeedISOFilename is the name of the seed ISO in the machine directory.
+	SeedISOFilename = "seed.iso"
+
+	// DefaultUser is the user created with the SSH key, as on boot2docker.
+	DefaultUser = "docker"
+
+	// seedVolumeID is the label cloud-init looks for.
+	seedVolumeID = "cidata"
+)
+
+// networkConfig enables DHCP on every ethernet interface, as some drivers
+// add a second NIC which cloud-init would leave down otherwise.
+const networkConfig = `version: 2
+ethernets:
+  all:
+    match:
+      name: "e*"
+    dhcp4: true
+`
+
+// Seed is the configuration of a new machine.
+type Seed struct {
+	Hostname  string
+	User      string
+	PublicKey string
+
+	// UserData is an optional cloud-config document or script run after
+	// the machine configuration.
+	UserData []byte
+}
+
+// NewSeed returns the configuration of a new machine, reading the SSH public
+// key and the optional user data from files.
+func NewSeed(hostname, user, publicKeyPath, userDataPath string) (*Seed, error) {
+	publicKey, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := &Seed{
+		Hostname:  hostname,
+		User:      user,
+		PublicKey: string(publicKey),
+	}
+	if userDataPath != "" {
+		if seed.UserData, err = ioutil.ReadFile(userDataPath); err != nil {
+			return nil, fmt.Errorf("error reading the cloud-init user data: %s", err)
+		}
+	}
+	return seed, nil
+}
+
+func (s *Seed) user() string {
+	if s.User == "" {
+		return DefaultUser
+	}
+	return s.User
+}
+
+func (s *Seed) metaData() string {
+	return fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", s.Hostname, s.Hostname)
+}
+
+func (s *Seed) cloudConfig() string {
+	return fmt.Sprintf(`#cloud-config
+hostname: %q
+users:
+  - default
+  - name: %q
+    sudo: "ALL=(ALL) NOPASSWD:ALL"
+    lock_passwd: true
+    ssh_authorized_keys:
+      - %q
+`, s.Hostname, s.user(), strings.TrimSpace(s.PublicKey))
+}
+
+// userData returns the machine cloud-config, followed by the user data of
+// the user if any in a MIME multipart document.
+func (s *Seed) userData() ([]byte, error) {
+	if len(s.UserData) == 0 {
+		return []byte(s.cloudConfig()), nil
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range [][]byte{[]byte(s.cloudConfig()), s.UserData} {
+		p, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {contentType(part)},
+			"MIME-Version":        {"1.0"},
+			"Content-Disposition": {"attachment"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.Write(part); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", w.Boundary())
+	return append([]byte(header), body.Bytes()...), nil
+}
+
+// contentType tells cloud-init how to handle a user data part.
+func contentType(part []byte) string {
+	switch {
+	case bytes.HasPrefix(part, []byte("#cloud-config")):
+		return "text/cloud-config"
+	case bytes.HasPrefix(part, []byte("#!")):
+		return "text/x-shellscript"
+	case bytes.HasPrefix(part, []byte("#cloud-boothook")):
+		return "text/cloud-boothook"
+	default:
+		return "text/plain"
+	}
+}
+
+// Files returns the seed files by name, for machines reading them from the
+// filesystem, e.g. from /var/lib/cloud/seed/nocloud. Their network is
+// configured by their host.
+func (s *Seed) Files() (map[string][]byte, error) {
+	userData, err := s.userData()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"meta-data": []byte(s.metaData()),
+		"user-data": userData,
+	}, nil
+}
+
+// WriteISO writes the seed ISO to path.
+func (s *Seed) WriteISO(path string) error {
+	userData, err := s.userData()
+	if err != nil {
+		return err
+	}
+
+	w, err := iso9660.NewWriter()
+	if err != nil {
+		return err
+	}
+	defer w.Cleanup()
+
+	for name, content := range map[string][]byte{
+		"meta-data":      []byte(s.metaData()),
+		"user-data":      userData,
+		"network-config": []byte(networkConfig),
+	} {
+		if err := w.AddFile(bytes.NewReader(content), name); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := w.WriteTo(f, seedVolumeID); err != nil {
+		return fmt.Errorf("error writing the cloud-init seed ISO: %s", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,87 @@
+package cloudinit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kdomanski/iso9660"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserData(t *testing.T) {
+	seed := &Seed{Hostname: "machine", PublicKey: "ssh-rsa AAAA test\n"}
+
+	userData, err := seed.userData()
+	assert.NoError(t, err)
+	assert.Equal(t, `#cloud-config
+hostname: "machine"
+users:
+  - default
+  - name: "docker"
+    sudo: "ALL=(ALL) NOPASSWD:ALL"
+    lock_passwd: true
+    ssh_authorized_keys:
+      - "ssh-rsa AAAA test"
+`, string(userData))
+}
+
+func TestUserDataMultipart(t *testing.T) {
+	seed := &Seed{Hostname: "machine", User: "ubuntu", PublicKey: "ssh-rsa AAAA", UserData: []byte("#!/bin/sh\necho hello\n")}
+
+	userData, err := seed.userData()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(userData), "Content-Type: multipart/mixed; boundary="))
+	assert.Contains(t, string(userData), "Content-Type: text/cloud-config")
+	assert.Contains(t, string(userData), `  - name: "ubuntu"`)
+	assert.Contains(t, string(userData), "Content-Type: text/x-shellscript")
+	assert.Contains(t, string(userData), "echo hello")
+}
+
+func TestWriteISO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudinit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	isoPath := filepath.Join(dir, SeedISOFilename)
+	seed := &Seed{Hostname: "machine", PublicKey: "ssh-rsa AAAA"}
+	assert.NoError(t, seed.WriteISO(isoPath))
+
+	f, err := os.Open(isoPath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	image, err := iso9660.OpenImage(f)
+	assert.NoError(t, err)
+
+	label, err := image.Label()
+	assert.NoError(t, err)
+	assert.Equal(t, "cidata", label)
+
+	root, err := image.RootDir()
+	assert.NoError(t, err)
+	children, err := root.GetChildren()
+	assert.NoError(t, err)
+
+	files := map[string]string{}
+	for _, child := range children {
+		content, err := ioutil.ReadAll(child.Reader())
+		assert.NoError(t, err)
+		files[child.Name()] = string(content)
+	}
+	assert.Equal(t, "instance-id: machine\nlocal-hostname: machine\n", files["meta-data"])
+	assert.Equal(t, networkConfig, files["network-config"])
+	assert.Contains(t, files["user-data"], "#cloud-config")
+}
+
+func TestFiles(t *testing.T) {
+	seed := &Seed{Hostname: "machine", PublicKey: "ssh-rsa AAAA"}
+
+	files, err := seed.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "instance-id: machine\nlocal-hostname: machine\n", string(files["meta-data"]))
+	assert.Contains(t, string(files["user-data"]), `  - name: "docker"`)
+}
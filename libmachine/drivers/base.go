@@ -1,8 +1,12 @@
 package drivers
 
 import (
+	"context"
 	"errors"
 	"path/filepath"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/ssh"
 )
 
 const (
@@ -23,6 +27,12 @@ type BaseDriver struct {
 	SwarmMaster    bool
 	SwarmHost      string
 	SwarmDiscovery string
+	EnginePort     int `json:",omitempty"`
+	// SSHBastion is the jump host the SSH connections tunnel through, if any
+	SSHBastion *ssh.Bastion `json:",omitempty"`
+
+	// ctx is the context of the operation in progress, see WithContext
+	ctx context.Context
 }
 
 // DriverName returns the name of the driver
@@ -60,6 +70,50 @@ func (d *BaseDriver) GetSSHPort() (int, error) {
 	return d.SSHPort, nil
 }
 
+// GetEnginePort returns the port of the Docker daemon, engine.DefaultPort if
+// not specified
+func (d *BaseDriver) GetEnginePort() int {
+	if d.EnginePort == 0 {
+		return engine.DefaultPort
+	}
+	return d.EnginePort
+}
+
+// SetEnginePort sets the port of the Docker daemon
+func (d *BaseDriver) SetEnginePort(port int) error {
+	d.EnginePort = port
+	return nil
+}
+
+// GetSSHBastion returns the jump host of the SSH connections, nil if none
+func (d *BaseDriver) GetSSHBastion() *ssh.Bastion {
+	return d.SSHBastion
+}
+
+// SetSSHBastion sets the jump host of the SSH connections
+func (d *BaseDriver) SetSSHBastion(bastion *ssh.Bastion) error {
+	d.SSHBastion = bastion
+	return nil
+}
+
+// Context returns the context of the operation in progress, which the
+// drivers implementing ContextDriver abort their cloud API calls with.
+func (d *BaseDriver) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// WithContext calls f with ctx as the context of the operation in progress.
+func (d *BaseDriver) WithContext(ctx context.Context, f func() error) error {
+	d.ctx = ctx
+	defer func() {
+		d.ctx = nil
+	}()
+	return f()
+}
+
 // GetSSHUsername returns the ssh user name, root if not specified
 func (d *BaseDriver) GetSSHUsername() string {
 	if d.SSHUser == "" {
@@ -28,6 +28,14 @@ func TestIP(t *testing.T) {
 	}
 }
 
+func TestEnginePort(t *testing.T) {
+	d := &BaseDriver{}
+	assert.Equal(t, 2376, d.GetEnginePort())
+
+	assert.NoError(t, d.SetEnginePort(12376))
+	assert.Equal(t, 12376, d.GetEnginePort())
+}
+
 func TestEngineInstallUrlFlagEmpty(t *testing.T) {
 	assert.False(t, EngineInstallURLFlagSet(&CheckDriverOptions{}))
 }
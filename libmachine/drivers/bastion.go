@@ -0,0 +1,40 @@
+package drivers
+
+import (
+	"errors"
+
+	"github.com/rancher/machine/libmachine/ssh"
+)
+
+// ErrSSHBastionNotSupported is returned by SetSSHBastion for the drivers
+// which cannot tunnel their SSH connections through a jump host.
+var ErrSSHBastionNotSupported = errors.New("the driver does not support an SSH bastion")
+
+// SSHBastioner is implemented by the drivers whose SSH connections can tunnel
+// through a jump host, given by --ssh-bastion-host. BaseDriver implements it,
+// for WaitForSSH to reach the machines with only private IPs.
+type SSHBastioner interface {
+	// GetSSHBastion returns the jump host, nil if none
+	GetSSHBastion() *ssh.Bastion
+	// SetSSHBastion sets the jump host. It is called after
+	// SetConfigFromFlags
+	SetSSHBastion(bastion *ssh.Bastion) error
+}
+
+// GetSSHBastion returns the jump host of the SSH connections to the machine
+// of d, nil if none.
+func GetSSHBastion(d Driver) *ssh.Bastion {
+	if b, ok := d.(SSHBastioner); ok {
+		return b.GetSSHBastion()
+	}
+	return nil
+}
+
+// SetSSHBastion gives the jump host of the SSH connections to the machine to
+// d.
+func SetSSHBastion(d Driver, bastion *ssh.Bastion) error {
+	if b, ok := d.(SSHBastioner); ok {
+		return b.SetSSHBastion(bastion)
+	}
+	return ErrSSHBastionNotSupported
+}
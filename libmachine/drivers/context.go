@@ -0,0 +1,89 @@
+package drivers
+
+import (
+	"context"
+
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// ContextDriver is version 2 of the driver API. Its operations take a context
+// so that a timeout or an interrupt aborts the cloud API calls in flight, the
+// driver then cleaning up what it created instead of leaking it.
+//
+// Drivers implement it in addition to Driver, whose methods remain for the
+// plugins built against version 1. The functions below call the context
+// methods when a driver has them and fall back to the version 1 methods
+// otherwise.
+type ContextDriver interface {
+	Driver
+
+	// CreateContext creates a host using the driver's config
+	CreateContext(ctx context.Context) error
+
+	// GetStateContext returns the state that the host is in
+	GetStateContext(ctx context.Context) (state.State, error)
+
+	// RemoveContext removes a host
+	RemoveContext(ctx context.Context) error
+
+	// StartContext starts a host
+	StartContext(ctx context.Context) error
+
+	// StopContext stops a host gracefully
+	StopContext(ctx context.Context) error
+}
+
+// Create creates the host of d, with ctx if d supports it.
+func Create(ctx context.Context, d Driver) error {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.CreateContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.Create()
+}
+
+// GetState returns the state of the host of d, with ctx if d supports it.
+func GetState(ctx context.Context, d Driver) (state.State, error) {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.GetStateContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return state.Error, err
+	}
+	return d.GetState()
+}
+
+// Remove removes the host of d, with ctx if d supports it.
+func Remove(ctx context.Context, d Driver) error {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.RemoveContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.Remove()
+}
+
+// Start starts the host of d, with ctx if d supports it.
+func Start(ctx context.Context, d Driver) error {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.StartContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.Start()
+}
+
+// Stop stops the host of d, with ctx if d supports it.
+func Stop(ctx context.Context, d Driver) error {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd.StopContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.Stop()
+}
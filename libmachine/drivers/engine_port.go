@@ -0,0 +1,32 @@
+package drivers
+
+import (
+	"errors"
+
+	"github.com/rancher/machine/libmachine/engine"
+)
+
+// ErrEnginePortNotSupported is returned by SetEnginePort for the drivers
+// which cannot use another port than engine.DefaultPort.
+var ErrEnginePortNotSupported = errors.New("the driver does not support another engine port than 2376")
+
+// EnginePortSetter is implemented by the drivers which can reach the Docker
+// daemon on another port than engine.DefaultPort, given by --engine-port.
+// BaseDriver implements it, for the drivers to use GetEnginePort in GetURL
+// and in the firewall rules they create.
+type EnginePortSetter interface {
+	// SetEnginePort sets the port of the Docker daemon. It is called after
+	// SetConfigFromFlags
+	SetEnginePort(port int) error
+}
+
+// SetEnginePort gives the port of the Docker daemon of the host to d.
+func SetEnginePort(d Driver, port int) error {
+	if s, ok := d.(EnginePortSetter); ok {
+		return s.SetEnginePort(port)
+	}
+	if port != engine.DefaultPort {
+		return ErrEnginePortNotSupported
+	}
+	return nil
+}
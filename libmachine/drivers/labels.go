@@ -0,0 +1,18 @@
+package drivers
+
+// Labeler is implemented by the drivers which tag the resources of the hosts
+// with the labels of the hosts, e.g. as cloud tags.
+type Labeler interface {
+	// SetLabels sets the labels of the host. It is called after
+	// SetConfigFromFlags
+	SetLabels(labels map[string]string) error
+}
+
+// SetLabels gives the labels of the host of d to d, if it tags its resources
+// with them.
+func SetLabels(d Driver, labels map[string]string) error {
+	if l, ok := d.(Labeler); ok {
+		return l.SetLabels(labels)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package drivers
+
+import "github.com/rancher/machine/libmachine/ssh"
+
+// SSHPassworder is implemented by the drivers whose machines accept a
+// password for the SSH user, e.g. the hosts without a key installed. The
+// password is tried when the key is not accepted, with the native client.
+type SSHPassworder interface {
+	// GetSSHPassword returns the password of the SSH user, empty if none
+	GetSSHPassword() string
+}
+
+// GetSSHPassword returns the password of the SSH user of the machine of d,
+// empty if none.
+func GetSSHPassword(d Driver) string {
+	if p, ok := d.(SSHPassworder); ok {
+		return p.GetSSHPassword()
+	}
+	return ""
+}
+
+// GetSSHAuth returns the authentication of the SSH connections to the
+// machine of d, with its key and password.
+func GetSSHAuth(d Driver) *ssh.Auth {
+	// the address of the machine may change, e.g. that of a tunnel
+	auth := &ssh.Auth{HostKeyAlias: d.GetMachineName()}
+	if d.GetSSHKeyPath() != "" {
+		auth.Keys = []string{d.GetSSHKeyPath()}
+	}
+	if password := GetSSHPassword(d); password != "" {
+		auth.Passwords = []string{password}
+	}
+	return auth
+}
@@ -0,0 +1,24 @@
+package drivers
+
+// PlanItem is a resource or a setting of the host a driver would create.
+type PlanItem struct {
+	Name  string
+	Value string
+}
+
+// Planner is implemented by the drivers which can tell what they would
+// create, with the cloud lookups of the zone, the image or the networks
+// resolved, without creating anything.
+type Planner interface {
+	// Plan returns the resources of the host to create. It is called after
+	// PreCreateCheck
+	Plan() ([]PlanItem, error)
+}
+
+// Plan returns the plan of the host of d, or nil if d cannot tell it.
+func Plan(d Driver) ([]PlanItem, error) {
+	if p, ok := d.(Planner); ok {
+		return p.Plan()
+	}
+	return nil, nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,15 +19,24 @@ var (
 	defaultTimeout               = 10 * time.Second
 	CurrentBinaryIsDockerMachine = false
 	CoreDrivers                  = []string{
+		"aliyunecs",
 		"amazonec2",
 		"azure",
+		"container",
 		"digitalocean",
 		"exoscale",
 		"generic",
 		"google",
 		"hyperv",
+		"ibmcloud",
+		"multipass",
 		"none",
+		"nutanix",
+		"oci",
+		"opennebula",
 		"openstack",
+		"outscale",
+		"qemu",
 		"rackspace",
 		"softlayer",
 		"virtualbox",
@@ -44,6 +54,11 @@ const (
 	PluginEnvKey        = "MACHINE_PLUGIN_TOKEN"
 	PluginEnvVal        = "42"
 	PluginEnvDriverName = "MACHINE_PLUGIN_DRIVER_NAME"
+	PluginEnvSocket     = "MACHINE_PLUGIN_SOCKET"
+
+	// UnixAddrPrefix marks a plugin address as a unix domain socket path
+	// rather than a TCP host:port pair.
+	UnixAddrPrefix = "unix://"
 )
 
 type PluginStreamer interface {
@@ -96,6 +111,7 @@ type Executor struct {
 	DriverName                 string
 	cmd                        *exec.Cmd
 	binaryPath                 string
+	socketDir                  string
 }
 
 type ErrPluginBinaryNotFound struct {
@@ -169,10 +185,22 @@ func (lbe *Executor) Start() (*bufio.Scanner, *bufio.Scanner, error) {
 	outScanner := bufio.NewScanner(lbe.pluginStdout)
 	errScanner := bufio.NewScanner(lbe.pluginStderr)
 
-	os.Setenv(PluginEnvKey, PluginEnvVal)
-	os.Setenv(PluginEnvDriverName, lbe.DriverName)
+	// Each plugin gets its own socket path so that parallel operations never
+	// race for the same address. Plugins which don't know about the socket
+	// env var simply ignore it and keep listening on a TCP port.
+	lbe.socketDir, err = os.MkdirTemp("", "machine-plugin-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating plugin socket directory: %s", err)
+	}
+
+	lbe.cmd.Env = append(os.Environ(),
+		PluginEnvKey+"="+PluginEnvVal,
+		PluginEnvDriverName+"="+lbe.DriverName,
+		PluginEnvSocket+"="+filepath.Join(lbe.socketDir, "rpc.sock"),
+	)
 
 	if err := lbe.cmd.Start(); err != nil {
+		os.RemoveAll(lbe.socketDir)
 		return nil, nil, fmt.Errorf("Error starting plugin binary: %s", err)
 	}
 
@@ -180,6 +208,10 @@ func (lbe *Executor) Start() (*bufio.Scanner, *bufio.Scanner, error) {
 }
 
 func (lbe *Executor) Close() error {
+	if lbe.socketDir != "" {
+		defer os.RemoveAll(lbe.socketDir)
+	}
+
 	if err := lbe.cmd.Wait(); err != nil {
 		return fmt.Errorf("Error waiting for binary close: %s", err)
 	}
@@ -187,6 +219,16 @@ func (lbe *Executor) Close() error {
 	return nil
 }
 
+// ParseAddress splits an address announced by a plugin server into the
+// network and address to pass to net.Dial. Addresses without the unix prefix
+// are TCP addresses, as announced by older plugins.
+func ParseAddress(addr string) (string, string) {
+	if strings.HasPrefix(addr, UnixAddrPrefix) {
+		return "unix", strings.TrimPrefix(addr, UnixAddrPrefix)
+	}
+	return "tcp", addr
+}
+
 func stream(scanner *bufio.Scanner, streamOutCh chan<- string) {
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -225,9 +267,13 @@ func (lbp *Plugin) execServer() error {
 	for {
 		select {
 		case out := <-stdOutCh:
-			log.Infof(pluginOut, lbp.MachineName, out)
+			if !lbp.logEntry(out) {
+				log.Infof(pluginOut, lbp.MachineName, out)
+			}
 		case err := <-stdErrCh:
-			log.Debugf(pluginErr, lbp.MachineName, err)
+			if !lbp.logEntry(err) {
+				log.Debugf(pluginErr, lbp.MachineName, err)
+			}
 		case <-lbp.stopCh:
 			if err := lbp.Executor.Close(); err != nil {
 				return fmt.Errorf("Error closing local plugin binary: %s", err)
@@ -237,6 +283,18 @@ func (lbp *Plugin) execServer() error {
 	}
 }
 
+// logEntry logs a line of the plugin in the JSON format, which it outputs
+// when it inherits MACHINE_LOG_FORMAT=json, as a line of its machine.
+func (lbp *Plugin) logEntry(line string) bool {
+	entry, ok := log.ParseEntry(line)
+	if !ok {
+		return false
+	}
+	entry.Host = lbp.MachineName
+	log.LogEntry(entry)
+	return true
+}
+
 func (lbp *Plugin) Serve() error {
 	return lbp.execServer()
 }
@@ -157,3 +157,13 @@ func TestExecServer(t *testing.T) {
 		t.Fatalf("Error serving: %s", err)
 	}
 }
+
+func TestParseAddress(t *testing.T) {
+	network, addr := ParseAddress("127.0.0.1:12345")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "127.0.0.1:12345", addr)
+
+	network, addr = ParseAddress("unix:///tmp/machine-plugin-1/rpc.sock")
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/tmp/machine-plugin-1/rpc.sock", addr)
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/rancher/machine/libmachine/drivers"
@@ -29,22 +31,35 @@ Please use this plugin through the main 'docker-machine' binary.
 		os.Exit(1)
 	}
 
+	if err := log.SetFormat(os.Getenv(log.FormatEnvVar)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting the log format: %s\n", err)
+		os.Exit(1)
+	}
 	log.SetDebug(true)
 	os.Setenv("MACHINE_DEBUG", "1")
+	if show, err := strconv.ParseBool(os.Getenv(log.ShowSecretsEnvVar)); err == nil {
+		log.SetShowSecrets(show)
+	}
+
+	if _, ok := d.(drivers.ContextDriver); ok {
+		// the interrupts of the terminal reach the plugin too, the client
+		// cancels the calls in progress instead for the driver to clean up
+		signal.Ignore(os.Interrupt)
+	}
 
 	rpcd := rpcdriver.NewRPCServerDriver(d)
 	rpc.RegisterName(rpcdriver.RPCServiceNameV0, rpcd)
 	rpc.RegisterName(rpcdriver.RPCServiceNameV1, rpcd)
 	rpc.HandleHTTP()
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	listener, addr, err := listen()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading RPC server: %s\n", err)
 		os.Exit(1)
 	}
 	defer listener.Close()
 
-	fmt.Println(listener.Addr())
+	fmt.Println(addr)
 
 	go http.Serve(listener, nil)
 
@@ -61,3 +76,23 @@ Please use this plugin through the main 'docker-machine' binary.
 		}
 	}
 }
+
+// listen opens the RPC listener on the unix socket requested by the client,
+// falling back to an ephemeral localhost TCP port when the client didn't ask
+// for one (older clients) or the platform can't bind it.
+func listen() (net.Listener, string, error) {
+	if socketPath := os.Getenv(localbinary.PluginEnvSocket); socketPath != "" {
+		listener, err := net.Listen("unix", socketPath)
+		if err == nil {
+			return listener, localbinary.UnixAddrPrefix + socketPath, nil
+		}
+		log.Debugf("Unable to listen on unix socket %s, falling back to TCP: %s", socketPath, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listener, listener.Addr().String(), nil
+}
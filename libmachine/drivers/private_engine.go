@@ -0,0 +1,20 @@
+package drivers
+
+// PrivateEngineReporter is implemented by the drivers whose hosts may have a
+// Docker daemon reachable from their private network only, e.g. when SSH
+// goes through a tunnel instead.
+type PrivateEngineReporter interface {
+	// IsEnginePrivate tells whether the Docker daemon of the host is
+	// reachable from its private network only
+	IsEnginePrivate() bool
+}
+
+// IsEnginePrivate tells whether the Docker daemon of the host of d is
+// reachable from its private network only, in which case the connection to
+// it cannot be checked from here.
+func IsEnginePrivate(d Driver) bool {
+	if r, ok := d.(PrivateEngineReporter); ok {
+		return r.IsEnginePrivate()
+	}
+	return false
+}
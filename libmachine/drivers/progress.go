@@ -0,0 +1,24 @@
+package drivers
+
+// Progress is the progress of a long operation of a driver, e.g. of the job
+// of the cloud API starting the host.
+type Progress struct {
+	Operation string
+	Status    string
+	Percent   int
+}
+
+// ProgressReporter is implemented by the drivers which report the progress
+// of their long operations.
+type ProgressReporter interface {
+	// SetProgressFunc sets the function the progress is reported to
+	SetProgressFunc(report func(Progress))
+}
+
+// SetProgressFunc has d report the progress of its operations to report, if
+// it reports it.
+func SetProgressFunc(d Driver, report func(Progress)) {
+	if r, ok := d.(ProgressReporter); ok {
+		r.SetProgressFunc(report)
+	}
+}
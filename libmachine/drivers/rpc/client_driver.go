@@ -1,22 +1,28 @@
 package rpcdriver
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/rpc"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/rancher/machine/libmachine/engine"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 	"github.com/rancher/machine/libmachine/version"
 )
 
 var (
 	heartbeatInterval = 5 * time.Second
+	progressInterval  = 1 * time.Second
 )
 
 type RPCClientDriverFactory interface {
@@ -40,6 +46,13 @@ type RPCClientDriver struct {
 	plugin          localbinary.DriverPlugin
 	heartbeatDoneCh chan bool
 	Client          *InternalClient
+
+	// secretFields caches the secret fields of the config of the driver
+	secretFields []string
+
+	// progress receives the progress the driver reports during the calls
+	// taking a context, if set
+	progress func(drivers.Progress)
 }
 
 type RPCCall struct {
@@ -52,6 +65,11 @@ type InternalClient struct {
 	MachineName    string
 	RPCClient      *rpc.Client
 	rpcServiceName string
+
+	lastCallID uint64
+	// noContextMethods is set for the plugins built before the methods
+	// taking a context were added.
+	noContextMethods bool
 }
 
 const (
@@ -82,15 +100,65 @@ const (
 	RestartMethod            = `.Restart`
 	KillMethod               = `.Kill`
 	UpgradeMethod            = `.Upgrade`
+	CancelMethod             = `.Cancel`
+	CreateContextMethod      = `.CreateContext`
+	GetStateContextMethod    = `.GetStateContext`
+	RemoveContextMethod      = `.RemoveContext`
+	StartContextMethod       = `.StartContext`
+	StopContextMethod        = `.StopContext`
+	PlanMethod               = `.Plan`
+	SecretFieldsMethod       = `.SecretFields`
+	SetLabelsMethod          = `.SetLabels`
+	SetEnginePortMethod      = `.SetEnginePort`
+	GetSSHBastionMethod      = `.GetSSHBastion`
+	SetSSHBastionMethod      = `.SetSSHBastion`
+	GetSSHPasswordMethod     = `.GetSSHPassword`
+	ProgressMethod           = `.Progress`
+	IsEnginePrivateMethod    = `.IsEnginePrivate`
 )
 
 func (ic *InternalClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	if serviceMethod != HeartbeatMethod {
+	if serviceMethod != HeartbeatMethod && serviceMethod != ProgressMethod {
 		log.Debugf("(%s) Calling %+v", ic.MachineName, serviceMethod)
 	}
 	return ic.RPCClient.Call(ic.rpcServiceName+serviceMethod, args, reply)
 }
 
+// CallContext calls a method taking a context. When ctx is done before the
+// call returns, the call is cancelled on the server and waited for, so that
+// the driver can clean up. The calls of the drivers which cannot cancel them
+// are not waited for, the command then being interrupted as before.
+func (ic *InternalClient) CallContext(ctx context.Context, serviceMethod string, reply interface{}) error {
+	args := ContextArgs{CallID: atomic.AddUint64(&ic.lastCallID, 1)}
+	if deadline, ok := ctx.Deadline(); ok {
+		args.Deadline = deadline
+	}
+
+	log.Debugf("(%s) Calling %+v", ic.MachineName, serviceMethod)
+	call := ic.RPCClient.Go(ic.rpcServiceName+serviceMethod, &args, reply, nil)
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		log.Debugf("(%s) Cancelling %+v: %s", ic.MachineName, serviceMethod, ctx.Err())
+		if err := ic.Call(CancelMethod, &args.CallID, nil); err != nil {
+			log.Debugf("(%s) Failed to cancel %+v: %s", ic.MachineName, serviceMethod, err)
+			return ctx.Err()
+		}
+		<-call.Done
+		// the server may see the cancellation before its deadline
+		if call.Error != nil {
+			return ctx.Err()
+		}
+	}
+	return call.Error
+}
+
+// isMissingMethod tells whether err is returned by a plugin which lacks the
+// method called.
+func isMissingMethod(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "rpc: can't find method")
+}
+
 func (ic *InternalClient) switchToV0() {
 	ic.rpcServiceName = RPCServiceNameV0
 }
@@ -138,7 +206,7 @@ func (f *DefaultRPCClientDriverFactory) NewRPCClientDriver(driverName string, ra
 		return nil, fmt.Errorf("Error attempting to get plugin server address for RPC: %s", err)
 	}
 
-	rpcclient, err := rpc.DialHTTP("tcp", addr)
+	rpcclient, err := rpc.DialHTTP(localbinary.ParseAddress(addr))
 	if err != nil {
 		return nil, err
 	}
@@ -245,7 +313,12 @@ func (c *RPCClientDriver) GetCreateFlags() []mcnflag.Flag {
 }
 
 func (c *RPCClientDriver) SetConfigRaw(data []byte) error {
-	return c.Client.Call(SetConfigRawMethod, data, nil)
+	if err := c.Client.Call(SetConfigRawMethod, data, nil); err != nil {
+		return err
+	}
+
+	c.registerSecrets(data)
+	return nil
 }
 
 func (c *RPCClientDriver) GetConfigRaw() ([]byte, error) {
@@ -255,9 +328,35 @@ func (c *RPCClientDriver) GetConfigRaw() ([]byte, error) {
 		return nil, err
 	}
 
+	c.registerSecrets(data)
 	return data, nil
 }
 
+// registerSecrets registers the secrets of the config of the driver, for the
+// logs of this process to redact them too.
+func (c *RPCClientDriver) registerSecrets(config []byte) {
+	drivers.RegisterSecrets(config, drivers.SecretFields(c))
+}
+
+// SecretFields returns the secret fields of the config of the driver, or nil
+// with the plugins which don't support it.
+func (c *RPCClientDriver) SecretFields() ([]string, error) {
+	if c.secretFields != nil {
+		return c.secretFields, nil
+	}
+
+	var fields []string
+	err := c.Client.Call(SecretFieldsMethod, struct{}{}, &fields)
+	if isMissingMethod(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.secretFields = append([]string{}, fields...)
+	return c.secretFields, nil
+}
+
 // DriverName returns the name of the driver
 func (c *RPCClientDriver) DriverName() string {
 	driverName, err := c.rpcStringCall(DriverNameMethod)
@@ -269,7 +368,13 @@ func (c *RPCClientDriver) DriverName() string {
 }
 
 func (c *RPCClientDriver) SetConfigFromFlags(flags drivers.DriverOptions) error {
-	return c.Client.Call(SetConfigFromFlagsMethod, &flags, nil)
+	if err := c.Client.Call(SetConfigFromFlagsMethod, &flags, nil); err != nil {
+		return err
+	}
+
+	// the config holds the secrets given by the flags now
+	_, err := c.GetConfigRaw()
+	return err
 }
 
 func (c *RPCClientDriver) GetURL() (string, error) {
@@ -361,6 +466,202 @@ func (c *RPCClientDriver) Kill() error {
 	return c.Client.Call(KillMethod, struct{}{}, nil)
 }
 
+// contextCall calls a method taking a context, or the method of the version 1
+// API with old plugins.
+func (c *RPCClientDriver) contextCall(ctx context.Context, method string, reply interface{}, fallback func() error) error {
+	if !c.Client.noContextMethods {
+		stop := c.forwardProgress()
+		err := c.Client.CallContext(ctx, method, reply)
+		stop()
+		if !isMissingMethod(err) {
+			return err
+		}
+		log.Debugf("(%s) The driver does not support contexts, falling back to the version 1 API", c.Client.MachineName)
+		c.Client.noContextMethods = true
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fallback()
+}
+
+// SetProgressFunc sets the function the progress reported by the driver
+// during the calls taking a context is forwarded to.
+func (c *RPCClientDriver) SetProgressFunc(report func(drivers.Progress)) {
+	c.progress = report
+}
+
+// forwardProgress polls the progress reported by the driver and forwards it
+// until stop is called, which forwards the last of it.
+func (c *RPCClientDriver) forwardProgress() (stop func()) {
+	if c.progress == nil {
+		return func() {}
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !c.pollProgress() {
+					return
+				}
+			case <-done:
+				c.pollProgress()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// pollProgress forwards the progress reported by the driver since the last
+// poll, returning false when it cannot be polled.
+func (c *RPCClientDriver) pollProgress() bool {
+	var progress []drivers.Progress
+	if err := c.Client.Call(ProgressMethod, struct{}{}, &progress); err != nil {
+		if !isMissingMethod(err) {
+			log.Debugf("(%s) Failed to poll the progress: %s", c.Client.MachineName, err)
+		}
+		return false
+	}
+
+	for _, p := range progress {
+		c.progress(p)
+	}
+	return true
+}
+
+func (c *RPCClientDriver) CreateContext(ctx context.Context) error {
+	return c.contextCall(ctx, CreateContextMethod, nil, c.Create)
+}
+
+func (c *RPCClientDriver) GetStateContext(ctx context.Context) (state.State, error) {
+	var s state.State
+
+	err := c.contextCall(ctx, GetStateContextMethod, &s, func() (err error) {
+		s, err = c.GetState()
+		return err
+	})
+	if err != nil {
+		return state.Error, err
+	}
+
+	return s, nil
+}
+
+func (c *RPCClientDriver) RemoveContext(ctx context.Context) error {
+	return c.contextCall(ctx, RemoveContextMethod, nil, c.Remove)
+}
+
+func (c *RPCClientDriver) StartContext(ctx context.Context) error {
+	return c.contextCall(ctx, StartContextMethod, nil, c.Start)
+}
+
+func (c *RPCClientDriver) StopContext(ctx context.Context) error {
+	return c.contextCall(ctx, StopContextMethod, nil, c.Stop)
+}
+
+// Plan returns the plan of the driver, or nil with the plugins which don't
+// support it.
+func (c *RPCClientDriver) Plan() ([]drivers.PlanItem, error) {
+	var items []drivers.PlanItem
+	err := c.Client.Call(PlanMethod, struct{}{}, &items)
+	if isMissingMethod(err) {
+		return nil, nil
+	}
+	return items, err
+}
+
+// SetLabels sets the labels of the host, ignored by the plugins which don't
+// support them.
+func (c *RPCClientDriver) SetLabels(labels map[string]string) error {
+	err := c.Client.Call(SetLabelsMethod, labels, nil)
+	if isMissingMethod(err) {
+		log.Debugf("(%s) The driver does not tag its resources with the labels", c.Client.MachineName)
+		return nil
+	}
+	return err
+}
+
+// SetEnginePort sets the port of the Docker daemon, failing with the plugins
+// which don't support another port than the default one.
+func (c *RPCClientDriver) SetEnginePort(port int) error {
+	err := c.Client.Call(SetEnginePortMethod, port, nil)
+	if isMissingMethod(err) {
+		if port == engine.DefaultPort {
+			return nil
+		}
+		return drivers.ErrEnginePortNotSupported
+	}
+	return err
+}
+
+// IsEnginePrivate tells whether the Docker daemon is reachable from the
+// private network of the host only, false with the plugins which don't tell.
+func (c *RPCClientDriver) IsEnginePrivate() bool {
+	var private bool
+	if err := c.Client.Call(IsEnginePrivateMethod, struct{}{}, &private); err != nil {
+		if !isMissingMethod(err) {
+			log.Warnf("Error attempting call to tell whether the engine is private: %s", err)
+		}
+		return false
+	}
+	return private
+}
+
+// GetSSHBastion returns the jump host of the SSH connections, nil with the
+// plugins which don't support one.
+func (c *RPCClientDriver) GetSSHBastion() *ssh.Bastion {
+	// gob cannot encode nil pointers, no bastion is sent as a zero one
+	var bastion ssh.Bastion
+	if err := c.Client.Call(GetSSHBastionMethod, struct{}{}, &bastion); err != nil {
+		if !isMissingMethod(err) {
+			log.Warnf("Error attempting call to get the SSH bastion: %s", err)
+		}
+		return nil
+	}
+	if bastion.Host == "" {
+		return nil
+	}
+	return &bastion
+}
+
+// SetSSHBastion sets the jump host of the SSH connections, failing with the
+// plugins which don't support one.
+func (c *RPCClientDriver) SetSSHBastion(bastion *ssh.Bastion) error {
+	if bastion == nil {
+		bastion = &ssh.Bastion{}
+	}
+	err := c.Client.Call(SetSSHBastionMethod, *bastion, nil)
+	if isMissingMethod(err) {
+		return drivers.ErrSSHBastionNotSupported
+	}
+	return err
+}
+
+// GetSSHPassword returns the password of the SSH user, empty with the plugins
+// which don't support one.
+func (c *RPCClientDriver) GetSSHPassword() string {
+	password, err := c.rpcStringCall(GetSSHPasswordMethod)
+	if err != nil {
+		if !isMissingMethod(err) {
+			log.Warnf("Error attempting call to get the SSH password: %s", err)
+		}
+		return ""
+	}
+	return password
+}
+
 func (c *RPCClientDriver) Upgrade() error {
 	return c.Client.Call(UpgradeMethod, struct{}{}, nil)
 }
@@ -0,0 +1,334 @@
+package rpcdriver
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingDriver creates hosts until its context is done.
+type blockingDriver struct {
+	*fakedriver.Driver
+	started  chan bool
+	deadline time.Time
+}
+
+func (d *blockingDriver) CreateContext(ctx context.Context) error {
+	d.deadline, _ = ctx.Deadline()
+	close(d.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *blockingDriver) GetStateContext(ctx context.Context) (state.State, error) {
+	return state.Running, nil
+}
+
+func (d *blockingDriver) RemoveContext(ctx context.Context) error { return nil }
+func (d *blockingDriver) StartContext(ctx context.Context) error  { return nil }
+func (d *blockingDriver) StopContext(ctx context.Context) error   { return nil }
+
+func (d *blockingDriver) Plan() ([]drivers.PlanItem, error) {
+	return []drivers.PlanItem{{Name: "Zone", Value: "eu-west-1a"}}, nil
+}
+
+// v1ServerDriver serves the version 1 API only, as old plugins do.
+type v1ServerDriver struct {
+	created bool
+}
+
+func (r *v1ServerDriver) Create(_, _ *struct{}) error {
+	r.created = true
+	return nil
+}
+
+// blockingV1Driver creates hosts until released, without a context.
+type blockingV1Driver struct {
+	*fakedriver.Driver
+	started, release chan bool
+}
+
+func (d *blockingV1Driver) Create() error {
+	close(d.started)
+	<-d.release
+	return nil
+}
+
+func newTestClientDriver(t *testing.T, server interface{}) *RPCClientDriver {
+	s := rpc.NewServer()
+	assert.NoError(t, s.RegisterName(RPCServiceNameV1, server))
+
+	serverConn, clientConn := net.Pipe()
+	go s.ServeConn(serverConn)
+
+	client := rpc.NewClient(clientConn)
+	t.Cleanup(func() { client.Close() })
+
+	return &RPCClientDriver{Client: NewInternalClient(client)}
+}
+
+func TestCreateContextIsCancelledOnTheServer(t *testing.T) {
+	driver := &blockingDriver{Driver: &fakedriver.Driver{}, started: make(chan bool)}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-driver.started
+		cancel()
+	}()
+
+	err := c.CreateContext(ctx)
+
+	assert.EqualError(t, err, context.Canceled.Error())
+}
+
+func TestCreateContextIsNotWaitedForWithV1Drivers(t *testing.T) {
+	driver := &blockingV1Driver{Driver: &fakedriver.Driver{}, started: make(chan bool), release: make(chan bool)}
+	defer close(driver.release)
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-driver.started
+		cancel()
+	}()
+
+	err := c.CreateContext(ctx)
+
+	assert.EqualError(t, err, context.Canceled.Error())
+}
+
+func TestCreateContextSendsTheDeadline(t *testing.T) {
+	driver := &blockingDriver{Driver: &fakedriver.Driver{}, started: make(chan bool)}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	err := c.CreateContext(ctx)
+
+	assert.EqualError(t, err, context.DeadlineExceeded.Error())
+	assert.True(t, deadline.Equal(driver.deadline))
+}
+
+func TestGetStateContext(t *testing.T) {
+	driver := &blockingDriver{Driver: &fakedriver.Driver{}, started: make(chan bool)}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	s, err := c.GetStateContext(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, state.Running, s)
+}
+
+func TestCreateContextFallsBackToV1(t *testing.T) {
+	server := &v1ServerDriver{}
+	c := newTestClientDriver(t, server)
+
+	err := c.CreateContext(context.Background())
+
+	assert.NoError(t, err)
+	assert.True(t, server.created)
+	assert.True(t, c.Client.noContextMethods)
+}
+
+func TestPlan(t *testing.T) {
+	driver := &blockingDriver{Driver: &fakedriver.Driver{}, started: make(chan bool)}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	items, err := c.Plan()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []drivers.PlanItem{{Name: "Zone", Value: "eu-west-1a"}}, items)
+}
+
+func TestPlanWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	items, err := c.Plan()
+
+	assert.NoError(t, err)
+	assert.Nil(t, items)
+}
+
+// secretDriver holds a secret in its config.
+type secretDriver struct {
+	*fakedriver.Driver
+	AccessToken string
+}
+
+func TestSecretFields(t *testing.T) {
+	c := newTestClientDriver(t, NewRPCServerDriver(&secretDriver{Driver: &fakedriver.Driver{}}))
+
+	fields, err := c.SecretFields()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AccessToken"}, fields)
+}
+
+func TestSecretFieldsWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	fields, err := c.SecretFields()
+
+	assert.NoError(t, err)
+	assert.Nil(t, fields)
+}
+
+// labelDriver tags its resources with the labels of the host.
+type labelDriver struct {
+	*fakedriver.Driver
+	Labels map[string]string
+}
+
+func (d *labelDriver) SetLabels(labels map[string]string) error {
+	d.Labels = labels
+	return nil
+}
+
+func TestSetLabels(t *testing.T) {
+	driver := &labelDriver{Driver: &fakedriver.Driver{}}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	assert.NoError(t, c.SetLabels(map[string]string{"env": "prod"}))
+	assert.Equal(t, map[string]string{"env": "prod"}, driver.Labels)
+}
+
+func TestSetLabelsWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	assert.NoError(t, c.SetLabels(map[string]string{"env": "prod"}))
+}
+
+func TestSetEnginePort(t *testing.T) {
+	driver := &fakedriver.Driver{BaseDriver: &drivers.BaseDriver{}}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	assert.NoError(t, c.SetEnginePort(12376))
+	assert.Equal(t, 12376, driver.GetEnginePort())
+}
+
+func TestSetEnginePortWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	assert.NoError(t, c.SetEnginePort(2376))
+	assert.Equal(t, drivers.ErrEnginePortNotSupported, c.SetEnginePort(12376))
+}
+
+func TestSSHBastion(t *testing.T) {
+	driver := &fakedriver.Driver{BaseDriver: &drivers.BaseDriver{}}
+	c := newTestClientDriver(t, NewRPCServerDriver(driver))
+
+	assert.Nil(t, c.GetSSHBastion())
+
+	bastion := &ssh.Bastion{Host: "bastion.example.com", User: "jump"}
+	assert.NoError(t, c.SetSSHBastion(bastion))
+	assert.Equal(t, bastion, driver.GetSSHBastion())
+	assert.Equal(t, bastion, c.GetSSHBastion())
+}
+
+func TestSSHBastionWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	assert.Nil(t, c.GetSSHBastion())
+	assert.Equal(t, drivers.ErrSSHBastionNotSupported, c.SetSSHBastion(&ssh.Bastion{Host: "bastion.example.com"}))
+}
+
+// passwordDriver reaches hosts accepting a password for the SSH user.
+type passwordDriver struct {
+	*fakedriver.Driver
+}
+
+func (d *passwordDriver) GetSSHPassword() string {
+	return "secret"
+}
+
+func TestGetSSHPassword(t *testing.T) {
+	c := newTestClientDriver(t, NewRPCServerDriver(&passwordDriver{Driver: &fakedriver.Driver{}}))
+
+	assert.Equal(t, "secret", c.GetSSHPassword())
+}
+
+func TestGetSSHPasswordWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	assert.Equal(t, "", c.GetSSHPassword())
+}
+
+// progressDriver reports the progress of the creation of its hosts.
+type progressDriver struct {
+	*fakedriver.Driver
+	report func(drivers.Progress)
+}
+
+func (d *progressDriver) SetProgressFunc(report func(drivers.Progress)) {
+	d.report = report
+}
+
+func (d *progressDriver) CreateContext(ctx context.Context) error {
+	d.report(drivers.Progress{Operation: "deploy", Status: "in-progress", Percent: 50})
+	d.report(drivers.Progress{Operation: "deploy", Status: "success", Percent: 100})
+	return nil
+}
+
+func (d *progressDriver) GetStateContext(ctx context.Context) (state.State, error) {
+	return state.Running, nil
+}
+
+func (d *progressDriver) RemoveContext(ctx context.Context) error { return nil }
+func (d *progressDriver) StartContext(ctx context.Context) error  { return nil }
+func (d *progressDriver) StopContext(ctx context.Context) error   { return nil }
+
+func TestCreateContextForwardsTheProgress(t *testing.T) {
+	c := newTestClientDriver(t, NewRPCServerDriver(&progressDriver{Driver: &fakedriver.Driver{}}))
+	var reported []drivers.Progress
+	drivers.SetProgressFunc(c, func(p drivers.Progress) {
+		reported = append(reported, p)
+	})
+
+	assert.NoError(t, c.CreateContext(context.Background()))
+	assert.Equal(t, []drivers.Progress{
+		{Operation: "deploy", Status: "in-progress", Percent: 50},
+		{Operation: "deploy", Status: "success", Percent: 100},
+	}, reported)
+}
+
+func TestCreateContextWithoutProgressWithOldPlugins(t *testing.T) {
+	server := &v1ServerDriver{}
+	c := newTestClientDriver(t, server)
+	drivers.SetProgressFunc(c, func(p drivers.Progress) {
+		t.Errorf("unexpected progress %+v", p)
+	})
+
+	assert.NoError(t, c.CreateContext(context.Background()))
+	assert.True(t, server.created)
+}
+
+// privateEngineDriver has a Docker daemon reachable from its private network
+// only.
+type privateEngineDriver struct {
+	*fakedriver.Driver
+}
+
+func (d *privateEngineDriver) IsEnginePrivate() bool { return true }
+
+func TestIsEnginePrivate(t *testing.T) {
+	c := newTestClientDriver(t, NewRPCServerDriver(&privateEngineDriver{Driver: &fakedriver.Driver{}}))
+
+	assert.True(t, c.IsEnginePrivate())
+}
+
+func TestIsEnginePrivateWithOldPlugins(t *testing.T) {
+	c := newTestClientDriver(t, &v1ServerDriver{})
+
+	assert.False(t, c.IsEnginePrivate())
+}
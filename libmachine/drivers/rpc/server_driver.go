@@ -1,14 +1,19 @@
 package rpcdriver
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
+	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
 	"github.com/rancher/machine/libmachine/version"
 )
@@ -23,8 +28,16 @@ func (ss *StandardStack) Stack() []byte {
 	return debug.Stack()
 }
 
+// maxQueuedProgress bounds the progress kept for the clients which don't poll
+// it, the oldest being dropped.
+const maxQueuedProgress = 100
+
 var (
 	stdStacker Stacker = &StandardStack{}
+
+	// errNotCancellable is returned by Cancel when the driver only has the
+	// version 1 methods, which cannot be aborted
+	errNotCancellable = errors.New("the driver cannot cancel its calls")
 )
 
 func init() {
@@ -84,14 +97,53 @@ type RPCServerDriver struct {
 	ActualDriver drivers.Driver
 	CloseCh      chan bool
 	HeartbeatCh  chan bool
+
+	cancelsLock sync.Mutex
+	// cancels holds the cancel functions of the calls in progress by call
+	// ID, and nil for the calls cancelled before they started.
+	cancels map[uint64]context.CancelFunc
+
+	progressLock sync.Mutex
+	// progress holds the progress reported by the driver until the client
+	// polls it
+	progress []drivers.Progress
+}
+
+// ContextArgs are the arguments of the calls taking a context. The deadline
+// of the context is sent with the call, its cancellation with a call to
+// Cancel.
+type ContextArgs struct {
+	CallID   uint64
+	Deadline time.Time
 }
 
 func NewRPCServerDriver(d drivers.Driver) *RPCServerDriver {
-	return &RPCServerDriver{
+	r := &RPCServerDriver{
 		ActualDriver: d,
 		CloseCh:      make(chan bool),
 		HeartbeatCh:  make(chan bool),
 	}
+	drivers.SetProgressFunc(d, r.queueProgress)
+	return r
+}
+
+// queueProgress queues the progress reported by the driver for the client.
+func (r *RPCServerDriver) queueProgress(p drivers.Progress) {
+	r.progressLock.Lock()
+	defer r.progressLock.Unlock()
+	if len(r.progress) == maxQueuedProgress {
+		r.progress = r.progress[1:]
+	}
+	r.progress = append(r.progress, p)
+}
+
+// Progress returns the progress reported by the driver since the last call.
+func (r *RPCServerDriver) Progress(_ *struct{}, reply *[]drivers.Progress) error {
+	r.progressLock.Lock()
+	defer r.progressLock.Unlock()
+	*reply = r.progress
+	r.progress = nil
+	return nil
 }
 
 func (r *RPCServerDriver) Close(_, _ *struct{}) error {
@@ -121,7 +173,25 @@ func (r *RPCServerDriver) GetCreateFlags(_ *struct{}, reply *[]mcnflag.Flag) err
 }
 
 func (r *RPCServerDriver) SetConfigRaw(data []byte, _ *struct{}) error {
-	return json.Unmarshal(data, &r.ActualDriver)
+	if err := json.Unmarshal(data, &r.ActualDriver); err != nil {
+		return err
+	}
+
+	r.registerSecrets()
+	return nil
+}
+
+// registerSecrets registers the secrets of the config of the driver, for the
+// logs of the plugin to redact them.
+func (r *RPCServerDriver) registerSecrets() {
+	if data, err := json.Marshal(r.ActualDriver); err == nil {
+		drivers.RegisterSecrets(data, drivers.SecretFields(r.ActualDriver))
+	}
+}
+
+func (r *RPCServerDriver) SecretFields(_ *struct{}, reply *[]string) error {
+	*reply = drivers.SecretFields(r.ActualDriver)
+	return nil
 }
 
 func trapPanic(err *error) {
@@ -137,6 +207,9 @@ func (r *RPCServerDriver) Create(_, _ *struct{}) (err error) {
 	// during create.
 	defer trapPanic(&err)
 
+	// the driver may generate secrets, e.g. a password
+	defer r.registerSecrets()
+
 	err = r.ActualDriver.Create()
 
 	return err
@@ -210,7 +283,12 @@ func (r *RPCServerDriver) Restart(_ *struct{}, _ *struct{}) error {
 }
 
 func (r *RPCServerDriver) SetConfigFromFlags(flags *drivers.DriverOptions, _ *struct{}) error {
-	return r.ActualDriver.SetConfigFromFlags(*flags)
+	if err := r.ActualDriver.SetConfigFromFlags(*flags); err != nil {
+		return err
+	}
+
+	r.registerSecrets()
+	return nil
 }
 
 func (r *RPCServerDriver) Start(_ *struct{}, _ *struct{}) error {
@@ -221,6 +299,125 @@ func (r *RPCServerDriver) Stop(_ *struct{}, _ *struct{}) error {
 	return r.ActualDriver.Stop()
 }
 
+// context returns the context of a call and the function to call when done.
+func (r *RPCServerDriver) context(args *ContextArgs) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !args.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(context.Background(), args.Deadline)
+	}
+
+	r.cancelsLock.Lock()
+	defer r.cancelsLock.Unlock()
+	if r.cancels == nil {
+		r.cancels = map[uint64]context.CancelFunc{}
+	}
+	if c, ok := r.cancels[args.CallID]; ok && c == nil {
+		cancel()
+	}
+	r.cancels[args.CallID] = cancel
+
+	return ctx, func() {
+		r.cancelsLock.Lock()
+		delete(r.cancels, args.CallID)
+		r.cancelsLock.Unlock()
+		cancel()
+	}
+}
+
+// Cancel cancels the context of a call in progress.
+func (r *RPCServerDriver) Cancel(callID *uint64, _ *struct{}) error {
+	if _, ok := r.ActualDriver.(drivers.ContextDriver); !ok {
+		return errNotCancellable
+	}
+
+	r.cancelsLock.Lock()
+	defer r.cancelsLock.Unlock()
+	if r.cancels == nil {
+		r.cancels = map[uint64]context.CancelFunc{}
+	}
+
+	if cancel := r.cancels[*callID]; cancel != nil {
+		cancel()
+	} else {
+		// the cancellation overtook the call
+		r.cancels[*callID] = nil
+	}
+	return nil
+}
+
+func (r *RPCServerDriver) CreateContext(args *ContextArgs, _ *struct{}) (err error) {
+	defer trapPanic(&err)
+	defer r.registerSecrets()
+
+	ctx, done := r.context(args)
+	defer done()
+	return drivers.Create(ctx, r.ActualDriver)
+}
+
+func (r *RPCServerDriver) GetStateContext(args *ContextArgs, reply *state.State) error {
+	ctx, done := r.context(args)
+	defer done()
+	s, err := drivers.GetState(ctx, r.ActualDriver)
+	*reply = s
+	return err
+}
+
+func (r *RPCServerDriver) RemoveContext(args *ContextArgs, _ *struct{}) error {
+	ctx, done := r.context(args)
+	defer done()
+	return drivers.Remove(ctx, r.ActualDriver)
+}
+
+func (r *RPCServerDriver) StartContext(args *ContextArgs, _ *struct{}) error {
+	ctx, done := r.context(args)
+	defer done()
+	return drivers.Start(ctx, r.ActualDriver)
+}
+
+func (r *RPCServerDriver) StopContext(args *ContextArgs, _ *struct{}) error {
+	ctx, done := r.context(args)
+	defer done()
+	return drivers.Stop(ctx, r.ActualDriver)
+}
+
+func (r *RPCServerDriver) Plan(_ *struct{}, reply *[]drivers.PlanItem) error {
+	items, err := drivers.Plan(r.ActualDriver)
+	*reply = items
+	return err
+}
+
+func (r *RPCServerDriver) SetLabels(labels map[string]string, _ *struct{}) error {
+	return drivers.SetLabels(r.ActualDriver, labels)
+}
+
+func (r *RPCServerDriver) SetEnginePort(port int, _ *struct{}) error {
+	return drivers.SetEnginePort(r.ActualDriver, port)
+}
+
+func (r *RPCServerDriver) IsEnginePrivate(_ *struct{}, reply *bool) error {
+	*reply = drivers.IsEnginePrivate(r.ActualDriver)
+	return nil
+}
+
+func (r *RPCServerDriver) GetSSHBastion(_ *struct{}, reply *ssh.Bastion) error {
+	if bastion := drivers.GetSSHBastion(r.ActualDriver); bastion != nil {
+		*reply = *bastion
+	}
+	return nil
+}
+
+func (r *RPCServerDriver) SetSSHBastion(bastion *ssh.Bastion, _ *struct{}) error {
+	if bastion.Host == "" {
+		bastion = nil
+	}
+	return drivers.SetSSHBastion(r.ActualDriver, bastion)
+}
+
+func (r *RPCServerDriver) GetSSHPassword(_ *struct{}, reply *string) error {
+	*reply = drivers.GetSSHPassword(r.ActualDriver)
+	return nil
+}
+
 func (r *RPCServerDriver) Heartbeat(_ *struct{}, _ *struct{}) error {
 	r.HeartbeatCh <- true
 	return nil
@@ -1,6 +1,7 @@
 package rpcdriver
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -73,3 +74,21 @@ func TestRPCServerDriverCreate(t *testing.T) {
 		assert.Equal(t, tc.expectedErr, tc.serverDriver.Create(nil, nil))
 	}
 }
+
+func TestRPCServerDriverCancelBeforeCall(t *testing.T) {
+	r := NewRPCServerDriver(&blockingDriver{Driver: &fakedriver.Driver{}})
+	callID := uint64(1)
+
+	assert.NoError(t, r.Cancel(&callID, nil))
+	ctx, done := r.context(&ContextArgs{CallID: callID})
+	defer done()
+
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestRPCServerDriverCancelV1(t *testing.T) {
+	r := NewRPCServerDriver(&fakedriver.Driver{})
+	callID := uint64(1)
+
+	assert.Equal(t, errNotCancellable, r.Cancel(&callID, nil))
+}
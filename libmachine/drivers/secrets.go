@@ -0,0 +1,198 @@
+package drivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+var (
+	// secretFieldRe matches the names of the driver fields holding secrets,
+	// e.g. SecretKey, Password, AccessToken or ClientSecret.
+	secretFieldRe = regexp.MustCompile(`(?i)(password|passwd|secret|token|apikey|accesskey|privatekey|credential)`)
+	// pathFieldRe matches the names of the fields holding the path of a
+	// secret rather than the secret, e.g. PrivateKeyPath.
+	pathFieldRe = regexp.MustCompile(`(?i)(path|file|filename|dir)$`)
+)
+
+// IsSecretFieldName tells whether a driver field holds a secret by its name.
+// The fields of the drivers are tagged `secret:"true"` or `secret:"false"`
+// where their name tells wrong.
+func IsSecretFieldName(name string) bool {
+	return secretFieldRe.MatchString(name) && !pathFieldRe.MatchString(name)
+}
+
+// IsSecretFlag tells whether a create flag, e.g. amazonec2-secret-key, holds
+// a secret.
+func IsSecretFlag(name string) bool {
+	return IsSecretFieldName(strings.Replace(name, "-", "", -1))
+}
+
+// SecretFielder is implemented by the drivers which tell their secret fields
+// otherwise than by their tags, like the client of the plugins.
+type SecretFielder interface {
+	// SecretFields returns the fields of the config of the driver holding
+	// secrets, or nil if it cannot tell them
+	SecretFields() ([]string, error)
+}
+
+// SecretFields returns the fields of the config of d holding secrets: those
+// tagged `secret:"true"`, and those named like secrets but not tagged
+// `secret:"false"`. The fields of nested structs are given by their path,
+// e.g. Client.APIKey. It is nil if d cannot tell them.
+func SecretFields(d Driver) []string {
+	if f, ok := d.(SecretFielder); ok {
+		fields, err := f.SecretFields()
+		if err != nil {
+			log.Debugf("Error getting the secret fields of the driver: %s", err)
+			return nil
+		}
+		return fields
+	}
+
+	fields := []string{}
+	appendSecretFields(reflect.TypeOf(d), "", map[reflect.Type]bool{}, &fields)
+	return fields
+}
+
+func appendSecretFields(t reflect.Type, prefix string, seen map[reflect.Type]bool, fields *[]string) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous {
+			appendSecretFields(field.Type, prefix, seen, fields)
+			continue
+		}
+
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		switch field.Tag.Get("secret") {
+		case "true":
+			*fields = append(*fields, prefix+name)
+		case "false":
+		default:
+			if IsSecretFieldName(field.Name) {
+				*fields = append(*fields, prefix+name)
+			} else {
+				appendSecretFields(field.Type, prefix+name+".", seen, fields)
+			}
+		}
+	}
+}
+
+// IsSecretField tells whether the field at path of the config of a driver,
+// e.g. Client.APIKey, is a secret. fields are the secret fields, as returned
+// by SecretFields; the field is told by its name if fields is nil.
+func IsSecretField(path, name string, fields []string) bool {
+	if fields == nil {
+		return IsSecretFieldName(name)
+	}
+	for _, field := range fields {
+		if field == path {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterSecrets registers the values of the secret fields of the config
+// of a driver in the log, which redacts them. fields are the secret fields,
+// as returned by SecretFields.
+func RegisterSecrets(config []byte, fields []string) {
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(config, &values); err != nil {
+		return
+	}
+	registerSecrets(values, "", fields)
+}
+
+func registerSecrets(values map[string]interface{}, prefix string, fields []string) {
+	for name, value := range values {
+		switch v := value.(type) {
+		case string:
+			if IsSecretField(prefix+name, name, fields) {
+				log.RegisterSecret(v)
+			}
+		case map[string]interface{}:
+			registerSecrets(v, prefix+name+".", fields)
+		}
+	}
+}
+
+// RedactConfig returns the config of a driver with the values of its secret
+// fields replaced by log.Redacted. fields are the secret fields, as returned
+// by SecretFields.
+func RedactConfig(config []byte, fields []string) ([]byte, error) {
+	return redactConfig(config, "", fields)
+}
+
+func redactConfig(config []byte, prefix string, fields []string) ([]byte, error) {
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(config, &values); err != nil {
+		return nil, err
+	}
+
+	redacted := json.RawMessage(`"` + log.Redacted + `"`)
+	for name, value := range values {
+		switch path := prefix + name; {
+		case IsSecretField(path, name, fields):
+			if string(value) != `""` && string(value) != "null" {
+				values[name] = redacted
+			}
+		case len(value) > 0 && value[0] == '{':
+			nested, err := redactConfig(value, path+".", fields)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = nested
+		}
+	}
+
+	// keep log.Redacted readable
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(values); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// redactedDriver is a driver marshalled with its secrets redacted.
+type redactedDriver struct {
+	Driver
+}
+
+// Redact returns d marshalled with the values of its secret fields replaced
+// by log.Redacted, e.g. for inspect.
+func Redact(d Driver) Driver {
+	return &redactedDriver{d}
+}
+
+func (d *redactedDriver) MarshalJSON() ([]byte, error) {
+	config, err := json.Marshal(d.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return RedactConfig(config, SecretFields(d.Driver))
+}
@@ -0,0 +1,76 @@
+package drivers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type secretClient struct {
+	User   string
+	APIKey string
+}
+
+type secretBase struct {
+	MachineName string
+	SSHPort     int
+}
+
+type secretDriver struct {
+	Driver `json:"-"`
+	*secretBase
+	WinRMOptions
+	AccessKeyID  string `secret:"false"`
+	SecretKey    string
+	Passphrase   string `json:"pass" secret:"true"`
+	HTTPTokens   string `secret:"false"`
+	PasswordFile string
+	Client       *secretClient
+}
+
+func TestSecretFields(t *testing.T) {
+	assert.Equal(t, []string{"WinRMPassword", "SecretKey", "pass", "Client.APIKey"}, SecretFields(&secretDriver{}))
+}
+
+func TestIsSecretFlag(t *testing.T) {
+	assert.True(t, IsSecretFlag("amazonec2-secret-key"))
+	assert.True(t, IsSecretFlag("digitalocean-access-token"))
+	assert.False(t, IsSecretFlag("google-application-credentials-file"))
+	assert.False(t, IsSecretFlag("amazonec2-region"))
+}
+
+func TestRedactConfig(t *testing.T) {
+	d := &secretDriver{
+		secretBase:  &secretBase{MachineName: "worker-1", SSHPort: 22},
+		AccessKeyID: "AKIDEXAMPLE",
+		SecretKey:   "wJalrXUtnFEMI",
+		Client:      &secretClient{User: "admin", APIKey: "0123456789abcdef"},
+	}
+	config, err := json.Marshal(d)
+	assert.NoError(t, err)
+
+	redacted, err := RedactConfig(config, SecretFields(d))
+	assert.NoError(t, err)
+	values := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(redacted, &values))
+
+	assert.Equal(t, "worker-1", values["MachineName"])
+	assert.Equal(t, float64(22), values["SSHPort"])
+	assert.Equal(t, "AKIDEXAMPLE", values["AccessKeyID"])
+	assert.Equal(t, log.Redacted, values["SecretKey"])
+	assert.Equal(t, "", values["pass"])
+	assert.Equal(t, map[string]interface{}{"User": "admin", "APIKey": log.Redacted}, values["Client"])
+
+	// without the secret fields, e.g. of an old plugin, by their names
+	redacted, err = RedactConfig(config, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, string(redacted), `"AccessKeyID":"<REDACTED>"`)
+}
+
+func TestRegisterSecrets(t *testing.T) {
+	RegisterSecrets([]byte(`{"SecretKey": "wJalrXUtnFEMI", "Region": "eu-west-1", "Client": {"APIKey": "0123456789abcdef"}}`), []string{"SecretKey", "Client.APIKey"})
+
+	assert.Equal(t, "key <REDACTED> in eu-west-1, api key <REDACTED>", log.Redact("key wJalrXUtnFEMI in eu-west-1, api key 0123456789abcdef"))
+}
@@ -1,6 +1,7 @@
 package drivers
 
 import (
+	"context"
 	"sync"
 
 	"encoding/json"
@@ -167,6 +168,42 @@ func (d *SerialDriver) Stop() error {
 	return d.Driver.Stop()
 }
 
+// CreateContext creates a host, with ctx if the driver supports it
+func (d *SerialDriver) CreateContext(ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	return Create(ctx, d.Driver)
+}
+
+// GetStateContext returns the state of the host, with ctx if the driver
+// supports it
+func (d *SerialDriver) GetStateContext(ctx context.Context) (state.State, error) {
+	d.Lock()
+	defer d.Unlock()
+	return GetState(ctx, d.Driver)
+}
+
+// RemoveContext removes a host, with ctx if the driver supports it
+func (d *SerialDriver) RemoveContext(ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	return Remove(ctx, d.Driver)
+}
+
+// StartContext starts a host, with ctx if the driver supports it
+func (d *SerialDriver) StartContext(ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	return Start(ctx, d.Driver)
+}
+
+// StopContext stops a host, with ctx if the driver supports it
+func (d *SerialDriver) StopContext(ctx context.Context) error {
+	d.Lock()
+	defer d.Unlock()
+	return Stop(ctx, d.Driver)
+}
+
 func (d *SerialDriver) MarshalJSON() ([]byte, error) {
 	return json.Marshal(d.Driver)
 }
@@ -19,16 +19,7 @@ func GetSSHClientFromDriver(d Driver) (ssh.Client, error) {
 		return nil, err
 	}
 
-	var auth *ssh.Auth
-	if d.GetSSHKeyPath() == "" {
-		auth = &ssh.Auth{}
-	} else {
-		auth = &ssh.Auth{
-			Keys: []string{d.GetSSHKeyPath()},
-		}
-	}
-
-	client, err := ssh.NewClient(d.GetSSHUsername(), address, port, auth)
+	client, err := ssh.NewClientWithBastion(d.GetSSHUsername(), mcnutils.UnbracketHost(address), port, GetSSHAuth(d), GetSSHBastion(d))
 	return client, err
 
 }
@@ -61,10 +52,51 @@ func sshAvailableFunc(d Driver) func() bool {
 	}
 }
 
+// CloseSSHConnections closes the SSH connections kept to the machine of d,
+// as it reboots or stops.
+func CloseSSHConnections(d Driver) {
+	address, err := d.GetSSHHostname()
+	if err != nil {
+		return
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return
+	}
+	ssh.CloseConnectionsTo(mcnutils.UnbracketHost(address), port)
+}
+
 func WaitForSSH(d Driver) error {
-	// Try to dial SSH for 30 seconds before timing out.
-	if err := mcnutils.WaitFor(sshAvailableFunc(d)); err != nil {
+	// the connections kept are those before the machine rebooted
+	CloseSSHConnections(d)
+	if err := mcnutils.WaitForOperation(mcnutils.RetrySSH, sshAvailableFunc(d)); err != nil {
 		return fmt.Errorf("Too many retries waiting for SSH to be available.  Last error: %s", err)
 	}
 	return nil
 }
+
+// CopyToMachine copies the local file src to dest on the machine of d, with
+// the native client whichever SSH client is used for the commands.
+func CopyToMachine(d Driver, src, dest string) error {
+	address, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+
+	client, err := ssh.NewNativeClient(d.GetSSHUsername(), mcnutils.UnbracketHost(address), port, GetSSHAuth(d))
+	if err != nil {
+		return err
+	}
+	native := client.(*ssh.NativeClient)
+	if bastion := GetSSHBastion(d); bastion != nil {
+		if err := native.SetBastion(bastion); err != nil {
+			return err
+		}
+	}
+	return native.Upload(src, dest, ssh.CopyOptions{})
+}
@@ -0,0 +1,97 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/winrm"
+)
+
+const TransportWinRM = "winrm"
+
+// WinRMOptions are the settings of a driver reaching Windows hosts over
+// WinRM instead of SSH. They are read from the driver config, which is
+// also available over RPC, so a driver opts in with these fields and
+// Transport set to "winrm".
+type WinRMOptions struct {
+	Transport     string
+	WinRMPort     int
+	WinRMUser     string
+	WinRMPassword string `secret:"true"`
+	WinRMHTTPS    bool
+	WinRMInsecure bool
+}
+
+// GetWinRMOptions returns the WinRM settings of the driver, or nil if it
+// uses SSH.
+func GetWinRMOptions(d Driver) (*WinRMOptions, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &WinRMOptions{}
+	if err := json.Unmarshal(data, opts); err != nil {
+		return nil, err
+	}
+	if opts.Transport != TransportWinRM {
+		return nil, nil
+	}
+
+	if opts.WinRMPort == 0 {
+		opts.WinRMPort = winrm.DefaultPort
+		if opts.WinRMHTTPS {
+			opts.WinRMPort = winrm.DefaultHTTPSPort
+		}
+	}
+	return opts, nil
+}
+
+func GetWinRMClientFromDriver(d Driver) (*winrm.Client, error) {
+	opts, err := GetWinRMOptions(d)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		return nil, fmt.Errorf("driver %s does not use WinRM", d.DriverName())
+	}
+
+	address, err := d.GetIP()
+	if err != nil {
+		return nil, err
+	}
+
+	return winrm.NewClient(address, opts.WinRMPort, opts.WinRMHTTPS, opts.WinRMInsecure, opts.WinRMUser, opts.WinRMPassword), nil
+}
+
+func RunWinRMCommandFromDriver(d Driver, command string) (string, error) {
+	client, err := GetWinRMClientFromDriver(d)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("About to run WinRM command:\n%s", command)
+
+	output, err := client.Output(command)
+	log.Debugf("WinRM cmd err, output: %v: %s", err, output)
+	if err != nil {
+		return "", fmt.Errorf(`winrm command error: command: %s err: %v output: %s`, command, err, output)
+	}
+
+	return output, nil
+}
+
+func WaitForWinRM(d Driver) error {
+	if err := mcnutils.WaitForOperation(mcnutils.RetryWinRM, func() bool {
+		if _, err := RunWinRMCommandFromDriver(d, "exit 0"); err != nil {
+			log.Debugf("Error getting winrm command 'exit 0' : %s", err)
+			return false
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("Too many retries waiting for WinRM to be available.  Last error: %s", err)
+	}
+	return nil
+}
@@ -2,6 +2,14 @@ package engine
 
 const (
 	DefaultPort = 2376
+
+	// RuntimeDocker is the default runtime of the machines, dockerd
+	RuntimeDocker = "docker"
+	// RuntimeContainerd is containerd alone with its CRI plugin, for the
+	// tools bringing their own Kubernetes such as k3s and RKE2
+	RuntimeContainerd = "containerd"
+	// RuntimePodman is Podman serving its Docker-compatible API with TLS
+	RuntimePodman = "podman"
 )
 
 type Options struct {
@@ -17,5 +25,70 @@ type Options struct {
 	SelinuxEnabled   bool
 	TLSVerify        bool `json:"TlsVerify"`
 	RegistryMirror   []string
-	InstallURL       string
+	// RegistryCA maps registry hosts to the local files of the CA
+	// certificates the engine trusts them with
+	RegistryCA map[string]string `json:",omitempty"`
+	// RegistryAuth maps registry hosts to the username:password credentials
+	// of the engine, for the authenticated mirrors
+	RegistryAuth map[string]string `json:",omitempty"`
+	InstallURL   string
+	// PackageMirror replaces the scheme and host of the repositories of the
+	// distribution, for the machines with no access to the internet
+	PackageMirror string `json:",omitempty"`
+	// HTTPProxy, HTTPSProxy and NoProxy are the proxies the package managers,
+	// the install script and the engine download through
+	HTTPProxy  string `json:",omitempty"`
+	HTTPSProxy string `json:",omitempty"`
+	NoProxy    string `json:",omitempty"`
+	// DownloadURL replaces https://download.docker.com, serving the Docker
+	// packages and GPG keys, in the install script and repositories
+	DownloadURL string `json:",omitempty"`
+	// InstallTarball is the local engine bundle copied to the machine and
+	// installed rather than from InstallURL, for the offline machines
+	InstallTarball string `json:",omitempty"`
+	// Version is the Docker version installed and held back from the
+	// upgrades, e.g. 24.0.7, the latest if empty
+	Version string `json:",omitempty"`
+	// DaemonJSON holds dockerd settings written to its daemon.json, along
+	// with those the machine sets with the flags of the daemon
+	DaemonJSON string `json:",omitempty"`
+	// RootlessUser runs Docker in rootless mode as this user rather than
+	// root, created unless it exists
+	RootlessUser string `json:",omitempty"`
+	// GPURuntime installs the NVIDIA Container Toolkit and its nvidia runtime
+	// for the containers given the GPUs of the machine with --gpus
+	GPURuntime bool `json:",omitempty"`
+	// SELinuxEnforcing fails the provisioning rather than switching SELinux
+	// to permissive mode when it cannot be configured for the engine
+	SELinuxEnforcing bool `json:",omitempty"`
+	// Sysctl, KernelModules and DisableSwap tune the host for the
+	// containers, e.g. net.ipv4.ip_forward=1 and br_netfilter
+	Sysctl        map[string]string `json:",omitempty"`
+	KernelModules []string          `json:",omitempty"`
+	DisableSwap   bool              `json:",omitempty"`
+	// Port is the port the daemon listens on, DefaultPort if 0
+	Port int `json:",omitempty"`
+	// Runtime is the container runtime provisioned, RuntimeDocker if empty
+	Runtime string `json:",omitempty"`
+	// LocalOnly has containerd only listen on its local socket rather than
+	// also on Port with TLS
+	LocalOnly bool `json:",omitempty"`
+}
+
+// DockerPort returns the port the daemon listens on.
+func (o *Options) DockerPort() int {
+	if o == nil || o.Port == 0 {
+		return DefaultPort
+	}
+	return o.Port
+}
+
+// IsContainerd tells whether the machine runs containerd rather than Docker.
+func (o *Options) IsContainerd() bool {
+	return o != nil && o.Runtime == RuntimeContainerd
+}
+
+// IsPodman tells whether the machine runs Podman rather than Docker.
+func (o *Options) IsPodman() bool {
+	return o != nil && o.Runtime == RuntimePodman
 }
@@ -0,0 +1,173 @@
+// Package events publishes the transitions of the states of the machines,
+// e.g. Creating, Provisioned or Stopped, to the subscribers of a bus and to
+// a journal other processes can follow.
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	Creating     Type = "Creating"
+	Created      Type = "Created"
+	Provisioning Type = "Provisioning"
+	Provisioned  Type = "Provisioned"
+	Starting     Type = "Starting"
+	Started      Type = "Started"
+	Stopping     Type = "Stopping"
+	Stopped      Type = "Stopped"
+	Restarting   Type = "Restarting"
+	Restarted    Type = "Restarted"
+	Killing      Type = "Killing"
+	Killed       Type = "Killed"
+	Removing     Type = "Removing"
+	Removed      Type = "Removed"
+	Error        Type = "Error"
+	// Progress is the progress of a long operation of the driver, e.g. of
+	// the job of the cloud API starting the machine
+	Progress Type = "Progress"
+)
+
+// maxJournalSize is the size past which the journal is rotated, keeping a
+// single previous journal with the .1 suffix.
+const maxJournalSize = 10 * 1024 * 1024
+
+// Event is a transition of the state of a machine.
+type Event struct {
+	Time    time.Time
+	Machine string
+	Driver  string
+	Type    Type
+	// Error is the error of the events of type Error
+	Error string `json:",omitempty"`
+	// Progress is the progress of the events of type Progress
+	Progress *drivers.Progress `json:",omitempty"`
+}
+
+// New returns the event of type t of the machine h, now. err is the error
+// of the events of type Error.
+func New(h *host.Host, t Type, err error) Event {
+	e := Event{
+		Time:    time.Now().UTC(),
+		Machine: h.Name,
+		Driver:  h.DriverName,
+		Type:    t,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// NewProgress returns the event of the progress p of the driver of the
+// machine h, now.
+func NewProgress(h *host.Host, p drivers.Progress) Event {
+	e := New(h, Progress, nil)
+	e.Progress = &p
+	return e
+}
+
+// Bus publishes the events to its subscribers and journal. The nil bus
+// drops them.
+type Bus struct {
+	// Journal is the file the events are appended to as JSON lines, if
+	// any, so that other processes can follow them
+	Journal string
+
+	mu          sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// and the function unsubscribing it, which closes the channel. The events
+// are dropped while the buffer of the channel is full.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = map[chan Event]bool{}
+	}
+	b.subscribers[ch] = true
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+// Publish publishes an event.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	log.Debugf("Event: %s %s", e.Machine, e.Type)
+	if b.Journal != "" {
+		if err := b.appendToJournal(e); err != nil {
+			log.Debugf("Error appending the event to %s: %s", b.Journal, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Debugf("Dropping event %s of %s: the subscriber is not keeping up", e.Type, e.Machine)
+		}
+	}
+}
+
+// ProgressFunc returns the function publishing the progress reported by the
+// driver of h to b.
+func (b *Bus) ProgressFunc(h *host.Host) func(drivers.Progress) {
+	return func(p drivers.Progress) {
+		b.Publish(NewProgress(h, p))
+	}
+}
+
+func (b *Bus) appendToJournal(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if info, err := os.Stat(b.Journal); err == nil && info.Size() > maxJournalSize {
+		if err := os.Rename(b.Journal, b.Journal+".1"); err != nil {
+			return err
+		}
+	}
+
+	// a single write of O_APPEND keeps the lines of concurrent processes
+	// whole
+	f, err := os.OpenFile(b.Journal, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
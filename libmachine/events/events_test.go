@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/stretchr/testify/assert"
+)
+
+var testHost = &host.Host{Name: "test", DriverName: "fakedriver"}
+
+func TestBusSubscribe(t *testing.T) {
+	bus := &Bus{}
+	ch, unsubscribe := bus.Subscribe(2)
+
+	bus.Publish(New(testHost, Creating, nil))
+	bus.Publish(New(testHost, Error, errors.New("quota exceeded")))
+	// dropped, the buffer being full
+	bus.Publish(New(testHost, Removing, nil))
+
+	e := <-ch
+	assert.Equal(t, "test", e.Machine)
+	assert.Equal(t, "fakedriver", e.Driver)
+	assert.Equal(t, Creating, e.Type)
+	e = <-ch
+	assert.Equal(t, Error, e.Type)
+	assert.Equal(t, "quota exceeded", e.Error)
+
+	unsubscribe()
+	unsubscribe()
+	_, open := <-ch
+	assert.False(t, open)
+
+	bus.Publish(New(testHost, Removed, nil))
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+
+	bus.Publish(New(testHost, Creating, nil))
+}
+
+func TestReadJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-events-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bus := &Bus{Journal: filepath.Join(dir, "events.log")}
+	bus.Publish(New(testHost, Creating, nil))
+	bus.Publish(New(testHost, Created, nil))
+
+	types := []Type{}
+	err = ReadJournal(context.Background(), bus.Journal, false, func(e Event) error {
+		types = append(types, e.Type)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Type{Creating, Created}, types)
+}
+
+func TestReadJournalFollows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-events-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bus := &Bus{Journal: filepath.Join(dir, "events.log")}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan Type)
+	done := make(chan error)
+	go func() {
+		done <- ReadJournal(ctx, bus.Journal, true, func(e Event) error {
+			received <- e.Type
+			return nil
+		})
+	}()
+
+	bus.Publish(New(testHost, Stopping, nil))
+	assert.Equal(t, Stopping, <-received)
+
+	// the events of the rotated journal are followed
+	assert.NoError(t, os.Rename(bus.Journal, bus.Journal+".1"))
+	bus.Publish(New(testHost, Stopped, nil))
+	assert.Equal(t, Stopped, <-received)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestProgressFunc(t *testing.T) {
+	bus := &Bus{}
+	ch, unsubscribe := bus.Subscribe(1)
+	defer unsubscribe()
+
+	bus.ProgressFunc(testHost)(drivers.Progress{Operation: "start", Status: "in-progress", Percent: 40})
+
+	e := <-ch
+	assert.Equal(t, Progress, e.Type)
+	assert.Equal(t, &drivers.Progress{Operation: "start", Status: "in-progress", Percent: 40}, e.Progress)
+}
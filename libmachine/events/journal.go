@@ -0,0 +1,101 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// followInterval is the interval at which a followed journal is polled.
+const followInterval = 500 * time.Millisecond
+
+// ReadJournal calls fn with the events of the journal at path, in order.
+// With follow, it then waits for the events appended to the journal, even
+// once rotated, until ctx is done.
+func ReadJournal(ctx context.Context, path string, follow bool, fn func(Event) error) error {
+	var (
+		f       *os.File
+		reader  *bufio.Reader
+		offset  int64
+		pending []byte
+		// draining is set once the journal rotated, to read the events
+		// appended to f before switching to the new journal
+		draining bool
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for {
+		if f == nil {
+			var err error
+			f, err = os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil {
+				reader, offset, pending, draining = bufio.NewReader(f), 0, nil, false
+			}
+		}
+
+		if f != nil {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			pending = append(pending, line...)
+			if bytes.HasSuffix(pending, []byte("\n")) {
+				offset += int64(len(pending))
+				e := Event{}
+				if err := json.Unmarshal(pending, &e); err != nil {
+					log.Debugf("Skipping invalid event in %s: %s", path, err)
+				} else if err := fn(e); err != nil {
+					return err
+				}
+				pending = nil
+				continue
+			}
+
+			if follow && rotated(f, path, offset) {
+				if !draining {
+					draining = true
+					continue
+				}
+				f.Close()
+				f = nil
+				continue
+			}
+		}
+
+		if !follow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// rotated tells whether the journal at path is no longer the file f, read
+// up to offset.
+func rotated(f *os.File, path string, offset int64) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(info, current) || info.Size() < offset
+}
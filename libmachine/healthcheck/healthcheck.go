@@ -0,0 +1,220 @@
+// Package healthcheck checks the health of the machines: the state of their
+// driver, SSH, the Docker API, their certificates and the pressure on their
+// disk.
+package healthcheck
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/mcndockerclient"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// The names of the checks, in the order they run.
+const (
+	CheckState  = "state"
+	CheckSSH    = "ssh"
+	CheckDocker = "docker"
+	CheckCerts  = "certs"
+	CheckDisk   = "disk"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	// Skip is the status of the checks which cannot run, e.g. SSH when the
+	// machine is stopped
+	Skip Status = "skip"
+)
+
+// diskCommand prints the usage of the disk holding the data of Docker, or
+// of the root disk before Docker is installed.
+const diskCommand = "df -P /var/lib/docker 2>/dev/null || df -P /"
+
+// DiskPressureThreshold is the usage of the disk of the machines, in percent,
+// from which the disk check fails.
+var DiskPressureThreshold = 90
+
+// Result is the outcome of a check of a machine.
+type Result struct {
+	Name    string
+	Status  Status
+	Message string `json:",omitempty"`
+}
+
+// Report is the health of a machine.
+type Report struct {
+	Machine string
+	// Healthy tells whether none of the checks failed
+	Healthy bool
+	Checks  []Result
+}
+
+// Check checks the health of h. The checks needing the machine to be running
+// are skipped when it is not.
+func Check(h *host.Host) *Report {
+	r := &Report{Machine: h.Name, Healthy: true}
+	add := func(name string, status Status, format string, args ...interface{}) {
+		r.Checks = append(r.Checks, Result{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+		if status == Fail {
+			r.Healthy = false
+		}
+	}
+
+	running := checkState(h, add)
+
+	var client ssh.Client
+	if running {
+		client = checkSSH(h, add)
+	} else {
+		add(CheckSSH, Skip, "the machine is not running")
+	}
+
+	if running {
+		checkDocker(h, add)
+	} else {
+		add(CheckDocker, Skip, "the machine is not running")
+	}
+
+	checkCerts(h, running, add)
+
+	if client != nil {
+		checkDisk(h, client, add)
+	} else {
+		add(CheckDisk, Skip, "SSH is not reachable")
+	}
+
+	return r
+}
+
+type addFunc func(name string, status Status, format string, args ...interface{})
+
+func checkState(h *host.Host, add addFunc) bool {
+	s, err := h.Driver.GetState()
+	switch {
+	case err != nil:
+		add(CheckState, Fail, "error getting the state: %s", err)
+	case s != state.Running:
+		add(CheckState, Fail, "the machine is %s", s)
+	default:
+		add(CheckState, Pass, "%s", s)
+	}
+	return err == nil && s == state.Running
+}
+
+func checkSSH(h *host.Host, add addFunc) ssh.Client {
+	client, err := h.CreateSSHClient()
+	if err == nil {
+		_, err = client.Output("exit 0")
+	}
+	if err != nil {
+		add(CheckSSH, Fail, "error running an SSH command: %s", err)
+		return nil
+	}
+	add(CheckSSH, Pass, "")
+	return client
+}
+
+func checkDocker(h *host.Host, add addFunc) {
+	if h.AuthOptions() == nil {
+		add(CheckDocker, Skip, "Docker is not managed by machine")
+		return
+	}
+
+	version, err := mcndockerclient.DockerVersion(h)
+	if err != nil {
+		add(CheckDocker, Fail, "%s", err)
+		return
+	}
+	add(CheckDocker, Pass, "Docker %s", version)
+}
+
+func checkCerts(h *host.Host, running bool, add addFunc) {
+	authOptions := h.AuthOptions()
+	if authOptions == nil {
+		add(CheckCerts, Skip, "Docker is not managed by machine")
+		return
+	}
+
+	for _, path := range []string{authOptions.CaCertPath, authOptions.ClientCertPath, authOptions.ServerCertPath} {
+		valid, err := cert.CheckCertificateDate(path)
+		if err != nil {
+			add(CheckCerts, Fail, "error reading %s: %s", path, err)
+			return
+		}
+		if !valid {
+			add(CheckCerts, Fail, "%s has expired", path)
+			return
+		}
+	}
+
+	if !running {
+		add(CheckCerts, Pass, "not validated against the stopped machine")
+		return
+	}
+
+	dockerURL, err := h.URL()
+	if err != nil {
+		add(CheckCerts, Fail, "error getting the URL: %s", err)
+		return
+	}
+	u, err := url.Parse(dockerURL)
+	if err != nil {
+		add(CheckCerts, Fail, "error parsing the URL: %s", err)
+		return
+	}
+	if valid, err := cert.ValidateCertificate(u.Host, authOptions); !valid {
+		add(CheckCerts, Fail, "the certificates are not valid for %s: %v", u.Host, err)
+		return
+	}
+	add(CheckCerts, Pass, "")
+}
+
+func checkDisk(h *host.Host, client ssh.Client, add addFunc) {
+	if h.HostOptions != nil && h.HostOptions.MachineOS == "windows" {
+		add(CheckDisk, Skip, "not supported on Windows")
+		return
+	}
+
+	out, err := client.Output(diskCommand)
+	if err != nil {
+		add(CheckDisk, Fail, "error getting the disk usage: %s", err)
+		return
+	}
+
+	usage, mount, err := parseDiskUsage(out)
+	if err != nil {
+		add(CheckDisk, Fail, "%s", err)
+		return
+	}
+	if usage >= DiskPressureThreshold {
+		add(CheckDisk, Fail, "%s is %d%% full", mount, usage)
+		return
+	}
+	add(CheckDisk, Pass, "%s is %d%% full", mount, usage)
+}
+
+// parseDiskUsage parses the usage in percent and mount point of the disk
+// of the output of df -P.
+func parseDiskUsage(out string) (int, string, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 6 || !strings.HasSuffix(fields[4], "%") {
+		return 0, "", fmt.Errorf("cannot parse the disk usage from %q", out)
+	}
+
+	usage, err := strconv.Atoi(strings.TrimSuffix(fields[4], "%"))
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot parse the disk usage from %q", out)
+	}
+	return usage, fields[5], nil
+}
@@ -0,0 +1,116 @@
+package healthcheck
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/mcndockerclient"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/ssh/sshtest"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSSHClientCreator struct {
+	client ssh.Client
+}
+
+func (f *fakeSSHClientCreator) CreateSSHClient(d drivers.Driver) (ssh.Client, error) {
+	return f.client, nil
+}
+
+type fakeCertGenerator struct {
+	cert.Generator
+	valid bool
+}
+
+func (f *fakeCertGenerator) ValidateCertificate(addr string, authOptions *auth.Options) (bool, error) {
+	return f.valid, nil
+}
+
+func (f *fakeCertGenerator) ReadTLSConfig(addr string, authOptions *auth.Options) (*tls.Config, error) {
+	return nil, nil
+}
+
+const dfOutput = `Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         20509264 19483800   1025464      95% /var/lib/docker`
+
+func testHost(t *testing.T, machineState state.State) *host.Host {
+	dir, err := ioutil.TempDir("", "healthcheck-test")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	caCert, caKey := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	assert.NoError(t, cert.GenerateCACertificate(caCert, caKey, "test", 2048))
+
+	return &host.Host{
+		Name: "worker-1",
+		Driver: &fakedriver.Driver{
+			MockState: machineState,
+			MockIP:    "1.2.3.4",
+		},
+		HostOptions: &host.Options{
+			AuthOptions: &auth.Options{
+				CaCertPath:     caCert,
+				ClientCertPath: caCert,
+				ServerCertPath: caCert,
+			},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	h := testHost(t, state.Running)
+
+	defer host.SetSSHClientCreator(&host.StandardSSHClientCreator{})
+	host.SetSSHClientCreator(&fakeSSHClientCreator{&sshtest.FakeClient{
+		Outputs: map[string]sshtest.CmdResult{diskCommand: {Out: dfOutput}},
+	}})
+	defer func(v mcndockerclient.DockerVersioner) { mcndockerclient.CurrentDockerVersioner = v }(mcndockerclient.CurrentDockerVersioner)
+	mcndockerclient.CurrentDockerVersioner = &mcndockerclient.FakeDockerVersioner{Version: "24.0.7"}
+	defer cert.SetCertGenerator(cert.NewX509CertGenerator())
+	cert.SetCertGenerator(&fakeCertGenerator{valid: true})
+
+	r := Check(h)
+
+	assert.Equal(t, "worker-1", r.Machine)
+	assert.False(t, r.Healthy)
+	assert.Equal(t, []Result{
+		{Name: CheckState, Status: Pass, Message: "Running"},
+		{Name: CheckSSH, Status: Pass},
+		{Name: CheckDocker, Status: Pass, Message: "Docker 24.0.7"},
+		{Name: CheckCerts, Status: Pass},
+		{Name: CheckDisk, Status: Fail, Message: "/var/lib/docker is 95% full"},
+	}, r.Checks)
+}
+
+func TestCheckStoppedMachine(t *testing.T) {
+	r := Check(testHost(t, state.Stopped))
+
+	assert.False(t, r.Healthy)
+	assert.Equal(t, []Result{
+		{Name: CheckState, Status: Fail, Message: "the machine is Stopped"},
+		{Name: CheckSSH, Status: Skip, Message: "the machine is not running"},
+		{Name: CheckDocker, Status: Skip, Message: "the machine is not running"},
+		{Name: CheckCerts, Status: Pass, Message: "not validated against the stopped machine"},
+		{Name: CheckDisk, Status: Skip, Message: "SSH is not reachable"},
+	}, r.Checks)
+}
+
+func TestParseDiskUsage(t *testing.T) {
+	usage, mount, err := parseDiskUsage(dfOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, 95, usage)
+	assert.Equal(t, "/var/lib/docker", mount)
+
+	_, _, err = parseDiskUsage("df: /var/lib/docker: No such file or directory")
+	assert.Error(t, err)
+}
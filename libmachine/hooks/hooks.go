@@ -0,0 +1,214 @@
+// Package hooks runs the commands registered for the phases of the life of
+// the machines, e.g. to register them in a CMDB or to update a DNS zone.
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// Phase is a phase of the life of a machine.
+type Phase string
+
+const (
+	PreCreate     Phase = "pre-create"
+	PostCreate    Phase = "post-create"
+	PreProvision  Phase = "pre-provision"
+	PostProvision Phase = "post-provision"
+	PreRemove     Phase = "pre-remove"
+	PostRemove    Phase = "post-remove"
+)
+
+// Phases are the phases hooks can be registered for.
+var Phases = []Phase{PreCreate, PostCreate, PreProvision, PostProvision, PreRemove, PostRemove}
+
+// remoteExt is the extension of the scripts of a hooks directory run on the
+// machine rather than locally.
+const remoteExt = ".remote"
+
+// Hook is a command run for a phase, either locally by the shell or on the
+// machine over SSH.
+type Hook struct {
+	Phase   Phase
+	Command string
+	Remote  bool
+
+	// executable is set for the executables of the hooks directory, run
+	// without the shell
+	executable bool
+}
+
+// Parse parses a hook given as phase=command.
+func Parse(s string, remote bool) (Hook, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Hook{}, fmt.Errorf("invalid hook %q, expected phase=command", s)
+	}
+
+	hook := Hook{Phase: Phase(parts[0]), Command: parts[1], Remote: remote}
+	if !validPhase(hook.Phase) {
+		return Hook{}, fmt.Errorf("invalid hook %q, the phase must be one of %s", s, phaseNames())
+	}
+	if remote && !hasMachine(hook.Phase) {
+		return Hook{}, fmt.Errorf("invalid hook %q, no machine to run it on before it is created or after it is removed", s)
+	}
+	return hook, nil
+}
+
+func validPhase(phase Phase) bool {
+	for _, p := range Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func phaseNames() string {
+	names := []string{}
+	for _, p := range Phases {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ", ")
+}
+
+// hasMachine tells whether the machine exists during a phase, so that
+// remote hooks can run.
+func hasMachine(phase Phase) bool {
+	return phase != PreCreate && phase != PostRemove
+}
+
+// Runner runs the hooks of the phases.
+type Runner struct {
+	// Hooks are the hooks given on the command line, run first
+	Hooks []Hook
+
+	// Dir holds a directory per phase, e.g. hooks.d/post-create, of
+	// executables run in lexical order. The scripts with the .remote
+	// extension are run on the machine.
+	Dir string
+}
+
+// hooks returns the hooks of a phase.
+func (r *Runner) hooks(phase Phase) ([]Hook, error) {
+	hooks := []Hook{}
+	for _, hook := range r.Hooks {
+		if hook.Phase == phase {
+			hooks = append(hooks, hook)
+		}
+	}
+	if r.Dir == "" {
+		return hooks, nil
+	}
+
+	dir := filepath.Join(r.Dir, string(phase))
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return hooks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the %s hooks: %s", phase, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		switch {
+		case file.IsDir() || strings.HasPrefix(file.Name(), "."):
+			continue
+		case filepath.Ext(file.Name()) == remoteExt:
+			if !hasMachine(phase) {
+				log.Warnf("Skipping %s: no machine to run it on during %s", path, phase)
+				continue
+			}
+			script, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("error reading the %s hooks: %s", phase, err)
+			}
+			hooks = append(hooks, Hook{Phase: phase, Command: string(script), Remote: true})
+		case runtime.GOOS != "windows" && file.Mode()&0111 == 0:
+			log.Debugf("Skipping %s: not executable", path)
+		default:
+			hooks = append(hooks, Hook{Phase: phase, Command: path, executable: true})
+		}
+	}
+	return hooks, nil
+}
+
+// Run runs the hooks of a phase for the machine h, stopping at the first
+// one failing.
+func (r *Runner) Run(phase Phase, h *host.Host) error {
+	if r == nil {
+		return nil
+	}
+
+	hooks, err := r.hooks(phase)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		log.Infof("Running %s hook for %s...", phase, h.Name)
+		log.Debugf("Hook: %s", hook.Command)
+
+		var output string
+		if hook.Remote {
+			output, err = h.RunSSHCommand(hook.Command)
+		} else {
+			output, err = runLocal(hook, h)
+		}
+		if output != "" {
+			log.Debugf("Hook output: %s", output)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook failed: %s: %s", phase, err, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+func runLocal(hook Hook, h *host.Host) (string, error) {
+	var cmd *exec.Cmd
+	switch {
+	case hook.executable:
+		cmd = exec.Command(hook.Command)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("cmd", "/c", hook.Command)
+	default:
+		cmd = exec.Command("sh", "-c", hook.Command)
+	}
+
+	cmd.Env = append(os.Environ(),
+		"MACHINE_HOOK_PHASE="+string(hook.Phase),
+		"MACHINE_NAME="+h.Name,
+		"MACHINE_DRIVER="+h.DriverName,
+	)
+	if hasMachine(hook.Phase) {
+		if ip, err := h.Driver.GetIP(); err == nil {
+			cmd.Env = append(cmd.Env, "MACHINE_IP="+ip)
+		}
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err := cmd.Run()
+	return output.String(), err
+}
+
+// RunPost runs the hooks of a phase following an operation which succeeded,
+// so that their failure is reported without failing the operation.
+func (r *Runner) RunPost(phase Phase, h *host.Host) {
+	if err := r.Run(phase, h); err != nil {
+		log.Warnf("Error running the hooks of %s: %s", h.Name, err)
+	}
+}
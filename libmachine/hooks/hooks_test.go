@@ -0,0 +1,85 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	hook, err := Parse("post-create=register.sh --cmdb", false)
+	assert.NoError(t, err)
+	assert.Equal(t, Hook{Phase: PostCreate, Command: "register.sh --cmdb"}, hook)
+
+	hook, err = Parse("pre-remove=docker swarm leave", true)
+	assert.NoError(t, err)
+	assert.Equal(t, Hook{Phase: PreRemove, Command: "docker swarm leave", Remote: true}, hook)
+
+	for _, invalid := range []string{"post-create", "post-create=", "post-start=true"} {
+		_, err := Parse(invalid, false)
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = Parse("pre-create=hostname", true)
+	assert.EqualError(t, err, `invalid hook "pre-create=hostname", no machine to run it on before it is created or after it is removed`)
+}
+
+func testHost() *host.Host {
+	return &host.Host{
+		Name:       "test",
+		DriverName: "fakedriver",
+		Driver:     &fakedriver.Driver{MockIP: "1.2.3.4", MockState: state.Running},
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-hooks-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	phaseDir := filepath.Join(dir, string(PostCreate))
+	assert.NoError(t, os.MkdirAll(phaseDir, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(phaseDir, "20-second"), []byte("#!/bin/sh\necho second >> "+out+"\n"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(phaseDir, "10-first"), []byte("#!/bin/sh\necho first $MACHINE_NAME $MACHINE_DRIVER $MACHINE_IP >> "+out+"\n"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(phaseDir, "README"), []byte("not a hook"), 0600))
+
+	runner := &Runner{
+		Hooks: []Hook{
+			{Phase: PostCreate, Command: "echo $MACHINE_HOOK_PHASE >> " + out},
+			{Phase: PreRemove, Command: "echo pre-remove >> " + out},
+		},
+		Dir: dir,
+	}
+
+	assert.NoError(t, runner.Run(PostCreate, testHost()))
+
+	data, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "post-create\nfirst test fakedriver 1.2.3.4\nsecond\n", string(data))
+}
+
+func TestRunnerRunFails(t *testing.T) {
+	runner := &Runner{
+		Hooks: []Hook{
+			{Phase: PreCreate, Command: "echo no CMDB; exit 1"},
+			{Phase: PreCreate, Command: "echo never run; exit 1"},
+		},
+	}
+
+	err := runner.Run(PreCreate, testHost())
+
+	assert.EqualError(t, err, "pre-create hook failed: exit status 1: no CMDB")
+}
+
+func TestNilRunner(t *testing.T) {
+	var runner *Runner
+
+	assert.NoError(t, runner.Run(PreCreate, testHost()))
+}
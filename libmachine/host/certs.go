@@ -0,0 +1,107 @@
+package host
+
+import (
+	"time"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision"
+	"github.com/rancher/machine/libmachine/swarm"
+)
+
+// CertExpiryWarning is how long before their certificates expire the hosts
+// are reported as expiring.
+var CertExpiryWarning = 30 * 24 * time.Hour
+
+// UpdateCertExpiry records when the certificates of the host expire.
+func (h *Host) UpdateCertExpiry() error {
+	authOptions := h.AuthOptions()
+	if authOptions == nil {
+		return nil
+	}
+
+	expiry := &auth.CertExpiry{}
+	for path, notAfter := range map[string]*time.Time{
+		authOptions.CaCertPath:     &expiry.CA,
+		authOptions.ClientCertPath: &expiry.Client,
+		authOptions.ServerCertPath: &expiry.Server,
+	} {
+		var err error
+		if *notAfter, err = cert.CertificateNotAfter(path); err != nil {
+			return err
+		}
+	}
+
+	authOptions.CertExpiry = expiry
+	return nil
+}
+
+// recordCertExpiry records when the certificates of the host expire after
+// they were generated, which does not fail the provisioning.
+func (h *Host) recordCertExpiry() {
+	if err := h.UpdateCertExpiry(); err != nil {
+		log.Debugf("Error recording the expiry of the certificates of %s: %s", h.Name, err)
+	}
+}
+
+// CertsNotAfter returns when the first of the certificates of the host
+// expires, the zero time if Docker was not provisioned. The certificates are
+// read if their expiry was not recorded, e.g. for the hosts created before it
+// was.
+func (h *Host) CertsNotAfter() (time.Time, error) {
+	authOptions := h.AuthOptions()
+	if authOptions == nil || h.HostOptions.CustomInstallScript != "" {
+		return time.Time{}, nil
+	}
+
+	if authOptions.CertExpiry == nil {
+		if err := h.UpdateCertExpiry(); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return authOptions.CertExpiry.Earliest(), nil
+}
+
+// CertsExpiring tells whether a certificate of the host expires in less than
+// CertExpiryWarning.
+func (h *Host) CertsExpiring() (bool, error) {
+	notAfter, err := h.CertsNotAfter()
+	if err != nil || notAfter.IsZero() {
+		return false, err
+	}
+	return time.Until(notAfter) < CertExpiryWarning, nil
+}
+
+// RotateCerts generates the server certificate of the host again and restarts
+// the engine with it, without provisioning the host again.
+func (h *Host) RotateCerts() error {
+	if h.AuthOptions() == nil || h.HostOptions.CustomInstallScript != "" {
+		log.Warnf(noDockerError, h.Name, "cannot rotate the certificates")
+		return nil
+	}
+
+	provisioner, err := provision.DetectProvisioner(h.Driver)
+	if err != nil {
+		return err
+	}
+
+	swarmOptions := swarm.Options{}
+	if h.HostOptions.SwarmOptions != nil {
+		swarmOptions = *h.HostOptions.SwarmOptions
+	}
+
+	engineOptions := engine.Options{}
+	if h.HostOptions.EngineOptions != nil {
+		engineOptions = *h.HostOptions.EngineOptions
+	}
+
+	log.Infof("Rotating the certificates of %q...", h.Name)
+	if err := provision.RotateCerts(provisioner, swarmOptions, *h.HostOptions.AuthOptions, engineOptions); err != nil {
+		return err
+	}
+
+	h.recordCertExpiry()
+	return nil
+}
@@ -1,6 +1,8 @@
 package host
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 
 	"github.com/rancher/machine/libmachine/auth"
@@ -45,7 +47,17 @@ type Host struct {
 	DriverName    string
 	HostOptions   *Options
 	Name          string
-	RawDriver     []byte `json:"-"`
+	// CreatePhase is the last phase of the creation of the host completed,
+	// if the creation is in progress or failed.
+	CreatePhase CreatePhase `json:",omitempty"`
+	// Labels are the labels of the host given at its creation, which the
+	// drivers may tag its resources with
+	Labels map[string]string `json:",omitempty"`
+	// IPCache holds the IP and URL of the host last resolved by its driver
+	IPCache   *IPCache `json:",omitempty"`
+	RawDriver []byte   `json:"-"`
+
+	ipCacheUpdated bool
 }
 
 type Options struct {
@@ -55,9 +67,13 @@ type Options struct {
 	CustomInstallScript string
 	HostnameOverride    string
 	MachineOS           string
-	EngineOptions       *engine.Options
-	SwarmOptions        *swarm.Options
-	AuthOptions         *auth.Options
+	// KeyringAccount is the account of the driver in the OS keyring the
+	// credentials of the machine are read from, if any. The secrets of the
+	// driver are then kept in the keyring rather than in the store.
+	KeyringAccount string
+	EngineOptions  *engine.Options
+	SwarmOptions   *swarm.Options
+	AuthOptions    *auth.Options
 }
 
 type Metadata struct {
@@ -89,12 +105,25 @@ func (creator *StandardSSHClientCreator) CreateSSHClient(d drivers.Driver) (ssh.
 		return &ssh.ExternalClient{}, err
 	}
 
-	auth := &ssh.Auth{}
-	if d.GetSSHKeyPath() != "" {
-		auth.Keys = []string{d.GetSSHKeyPath()}
+	return ssh.NewClientWithBastion(d.GetSSHUsername(), addr, port, drivers.GetSSHAuth(d), drivers.GetSSHBastion(d))
+}
+
+// ForgetHostKey forgets the SSH host key recorded for the host, e.g. before
+// it is rebuilt, which the first connection to it records again.
+func (h *Host) ForgetHostKey() error {
+	// the keys recorded before the aliases are under the address
+	addr, err := h.Driver.GetSSHHostname()
+	if err != nil {
+		log.Debugf("Error getting the SSH address of %s, forgetting the host key of its name only: %s", h.Name, err)
+		return ssh.ForgetHostKey(h.Driver.GetMachineName(), "", 0)
+	}
+
+	port, err := h.Driver.GetSSHPort()
+	if err != nil {
+		return err
 	}
 
-	return ssh.NewClient(d.GetSSHUsername(), addr, port, auth)
+	return ssh.ForgetHostKey(h.Driver.GetMachineName(), mcnutils.UnbracketHost(addr), port)
 }
 
 func (h *Host) runActionForState(action func() error, desiredState state.State) error {
@@ -105,11 +134,16 @@ func (h *Host) runActionForState(action func() error, desiredState state.State)
 		}
 	}
 
+	// the machine may get another IP, and the connections kept are lost as
+	// it resets
+	drivers.CloseSSHConnections(h.Driver)
+	h.InvalidateIPCache()
+
 	if err := action(); err != nil {
 		return err
 	}
 
-	return mcnutils.WaitFor(drivers.MachineInState(h.Driver, desiredState))
+	return mcnutils.WaitForOperation(mcnutils.RetryState, drivers.MachineInState(h.Driver, desiredState))
 }
 
 func (h *Host) WaitForDocker() error {
@@ -118,12 +152,27 @@ func (h *Host) WaitForDocker() error {
 		return err
 	}
 
-	return provision.WaitForDocker(provisioner, engine.DefaultPort)
+	return provision.WaitForDocker(provisioner, h.EnginePort())
+}
+
+// EnginePort returns the port the Docker daemon of the host listens on.
+func (h *Host) EnginePort() int {
+	if h.HostOptions == nil {
+		return engine.DefaultPort
+	}
+	return h.HostOptions.EngineOptions.DockerPort()
 }
 
 func (h *Host) Start() error {
+	return h.StartContext(context.Background())
+}
+
+// StartContext starts the host as Start does, aborting the start in the
+// driver when ctx is done.
+func (h *Host) StartContext(ctx context.Context) error {
 	log.Infof("Starting %q...", h.Name)
-	if err := h.runActionForState(h.Driver.Start, state.Running); err != nil {
+	start := func() error { return drivers.Start(ctx, h.Driver) }
+	if err := h.runActionForState(start, state.Running); err != nil {
 		return err
 	}
 
@@ -133,8 +182,16 @@ func (h *Host) Start() error {
 }
 
 func (h *Host) Stop() error {
+	return h.StopContext(context.Background())
+}
+
+// StopContext stops the host as Stop does, aborting the stop in the driver
+// when ctx is done.
+func (h *Host) StopContext(ctx context.Context) error {
 	log.Infof("Stopping %q...", h.Name)
-	if err := h.runActionForState(h.Driver.Stop, state.Stopped); err != nil {
+	drivers.CloseSSHConnections(h.Driver)
+	stop := func() error { return drivers.Stop(ctx, h.Driver) }
+	if err := h.runActionForState(stop, state.Stopped); err != nil {
 		return err
 	}
 
@@ -144,6 +201,7 @@ func (h *Host) Stop() error {
 
 func (h *Host) Kill() error {
 	log.Infof("Killing %q...", h.Name)
+	drivers.CloseSSHConnections(h.Driver)
 	if err := h.runActionForState(h.Driver.Kill, state.Stopped); err != nil {
 		return err
 	}
@@ -154,6 +212,10 @@ func (h *Host) Kill() error {
 
 func (h *Host) Restart() error {
 	log.Infof("Restarting %q...", h.Name)
+	// the machine may get another IP, and the connections kept are lost as
+	// it resets
+	drivers.CloseSSHConnections(h.Driver)
+	h.InvalidateIPCache()
 	if drivers.MachineInState(h.Driver, state.Stopped)() {
 		if err := h.Start(); err != nil {
 			return err
@@ -162,7 +224,7 @@ func (h *Host) Restart() error {
 		if err := h.Driver.Restart(); err != nil {
 			return err
 		}
-		if err := mcnutils.WaitFor(drivers.MachineInState(h.Driver, state.Running)); err != nil {
+		if err := mcnutils.WaitForOperation(mcnutils.RetryState, drivers.MachineInState(h.Driver, state.Running)); err != nil {
 			return err
 		}
 	}
@@ -171,7 +233,7 @@ func (h *Host) Restart() error {
 }
 
 func (h *Host) DockerVersion() (string, error) {
-	url, err := h.Driver.GetURL()
+	url, err := h.URL()
 	if err != nil {
 		return "", err
 	}
@@ -194,6 +256,10 @@ func (h *Host) Upgrade() error {
 		return nil
 	}
 
+	if version := h.HostOptions.EngineOptions.Version; version != "" {
+		return fmt.Errorf("Docker is pinned to version %s on %s, not upgrading it", version, h.Name)
+	}
+
 	machineState, err := h.Driver.GetState()
 	if err != nil {
 		return err
@@ -211,6 +277,10 @@ func (h *Host) Upgrade() error {
 		return err
 	}
 
+	if h.HostOptions.EngineOptions.IsContainerd() || h.HostOptions.EngineOptions.IsPodman() {
+		return provision.UpgradeEngine(provisioner, *h.HostOptions.EngineOptions)
+	}
+
 	dockerVersion, err := h.DockerVersion()
 	if err != nil {
 		return err
@@ -253,10 +323,6 @@ func (h *Host) Upgrade() error {
 	return provisioner.Service("docker", serviceaction.Restart)
 }
 
-func (h *Host) URL() (string, error) {
-	return h.Driver.GetURL()
-}
-
 func (h *Host) AuthOptions() *auth.Options {
 	if h.HostOptions == nil {
 		return nil
@@ -280,7 +346,12 @@ func (h *Host) ConfigureAuth() error {
 	// and modularity of the provisioners should be).
 	//
 	// Call provision to re-provision the certs properly.
-	return provisioner.Provision(swarm.Options{}, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions)
+	if err := provision.ProvisionEngine(provisioner, swarm.Options{}, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions); err != nil {
+		return err
+	}
+
+	h.recordCertExpiry()
+	return nil
 }
 
 func (h *Host) ConfigureAllAuth() error {
@@ -307,5 +378,10 @@ func (h *Host) Provision() error {
 		return provision.WithCustomScript(provisioner, h.HostOptions.CustomInstallScript, h.HostOptions.HostnameOverride)
 	}
 
-	return provisioner.Provision(*h.HostOptions.SwarmOptions, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions)
+	if err := provision.ProvisionEngine(provisioner, *h.HostOptions.SwarmOptions, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions); err != nil {
+		return err
+	}
+
+	h.recordCertExpiry()
+	return nil
 }
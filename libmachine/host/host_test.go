@@ -1,10 +1,16 @@
 package host
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rancher/machine/drivers/fakedriver"
 	_ "github.com/rancher/machine/drivers/none"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/cert"
 	"github.com/rancher/machine/libmachine/provision"
 	"github.com/rancher/machine/libmachine/state"
 )
@@ -55,3 +61,131 @@ func TestStart(t *testing.T) {
 		t.Fatalf("Expected no error but got one: %s", err)
 	}
 }
+
+func TestCreatePhaseReached(t *testing.T) {
+	if !CreateSSHReady.Reached(CreateInstanceCreated) {
+		t.Fatal("Expected ssh-ready to come after instance-created")
+	}
+	if CreateStarted.Reached(CreateInstanceCreated) {
+		t.Fatal("Expected started to come before instance-created")
+	}
+	if !CreateComplete.Reached(CreateProvisioned) {
+		t.Fatal("Expected the hosts created to have reached all the phases")
+	}
+	if !CreateCertsInstalled.Reached(CreateProvisioned) || CreateCertsInstalled.Reached(CreateComplete) {
+		t.Fatal("Expected certs-installed to come between provisioned and the creation complete")
+	}
+	if CreatePhase("unknown").Reached(CreateInstanceCreated) {
+		t.Fatal("Expected an unknown phase to resume the creation from the start")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"env=prod", "team=web=front", "empty="})
+	if err != nil {
+		t.Fatalf("Expected no error but got one: %s", err)
+	}
+
+	h := &Host{Labels: labels}
+	if got := h.LabelsString(); got != "empty=,env=prod,team=web=front" {
+		t.Fatalf("Expected the labels sorted by key, got %q", got)
+	}
+
+	if _, err := ParseLabels([]string{"env"}); err == nil {
+		t.Fatal("Expected an error for a label without a value")
+	}
+	if _, err := ParseLabels([]string{"=prod"}); err == nil {
+		t.Fatal("Expected an error for a label without a key")
+	}
+}
+
+func TestIPCache(t *testing.T) {
+	defer func(ttl time.Duration) { IPCacheTTL = ttl }(IPCacheTTL)
+	IPCacheTTL = time.Minute
+
+	driver := &fakedriver.Driver{MockState: state.Running, MockIP: "1.2.3.4"}
+	host := &Host{Driver: driver}
+
+	if url, _ := host.URL(); url != "tcp://1.2.3.4:2376" {
+		t.Fatalf("Expected the URL of the driver, got %q", url)
+	}
+	if !host.IPCacheUpdated() {
+		t.Fatal("Expected the IP cache to be updated")
+	}
+
+	driver.MockIP = "5.6.7.8"
+	if ip, _ := host.IP(); ip != "5.6.7.8" {
+		t.Fatalf("Expected the IP of the driver, got %q", ip)
+	}
+	driver.MockIP = "9.9.9.9"
+	if ip, _ := host.IP(); ip != "5.6.7.8" {
+		t.Fatalf("Expected the IP cached, got %q", ip)
+	}
+	if url, _ := host.URL(); url != "tcp://9.9.9.9:2376" {
+		t.Fatalf("Expected the URL cached to be dropped with the former IP, got %q", url)
+	}
+	if ip, _ := host.RefreshIP(); ip != "9.9.9.9" {
+		t.Fatalf("Expected the IP refreshed, got %q", ip)
+	}
+
+	host.IPCache.IP.ResolvedAt = time.Now().Add(-2 * time.Minute)
+	driver.MockIP = "1.2.3.4"
+	if ip, _ := host.IP(); ip != "1.2.3.4" {
+		t.Fatalf("Expected the IP cached to expire, got %q", ip)
+	}
+
+	// the machines stopped out of band keep their addresses cached until
+	// they expire, the driver is not asked for their state
+	driver.MockState = state.Stopped
+	if ip, err := host.IP(); err != nil || ip != "1.2.3.4" {
+		t.Fatalf("Expected the IP cached, got %q, %v", ip, err)
+	}
+	driver.MockState = state.Running
+
+	if err := host.Stop(); err != nil {
+		t.Fatalf("Expected no error but got one: %s", err)
+	}
+	if host.IPCache != nil {
+		t.Fatal("Expected the IP cache to be dropped when stopping")
+	}
+
+	IPCacheTTL = 0
+	driver.MockState = state.Running
+	if _, err := host.IP(); err != nil || host.IPCache != nil {
+		t.Fatal("Expected no IP cache with a TTL of 0")
+	}
+}
+
+func TestCertsExpiring(t *testing.T) {
+	defer func(warning time.Duration) { CertExpiryWarning = warning }(CertExpiryWarning)
+
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := &auth.Options{
+		CaCertPath:     filepath.Join(dir, "ca.pem"),
+		ClientCertPath: filepath.Join(dir, "cert.pem"),
+		ServerCertPath: filepath.Join(dir, "server.pem"),
+	}
+	for _, path := range []string{authOptions.CaCertPath, authOptions.ClientCertPath, authOptions.ServerCertPath} {
+		if err := cert.GenerateCACertificate(path, path+".key", "org", 1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := &Host{HostOptions: &Options{AuthOptions: authOptions}}
+
+	if expiring, err := host.CertsExpiring(); err != nil || expiring {
+		t.Fatalf("Expected the certificates not to expire soon, got %t, %v", expiring, err)
+	}
+	if authOptions.CertExpiry == nil || authOptions.CertExpiry.Server.Before(time.Now()) {
+		t.Fatalf("Expected the expiry of the certificates to be recorded, got %+v", authOptions.CertExpiry)
+	}
+
+	CertExpiryWarning = 2000 * 24 * time.Hour
+	if expiring, err := host.CertsExpiring(); err != nil || !expiring {
+		t.Fatalf("Expected the certificates to expire soon, got %t, %v", expiring, err)
+	}
+}
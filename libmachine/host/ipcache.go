@@ -0,0 +1,91 @@
+package host
+
+import (
+	"time"
+)
+
+// IPCacheTTL is how long the IP and URL of a host resolved by its driver are
+// reused before asking the driver again, 0 to always ask it.
+var IPCacheTTL = 5 * time.Minute
+
+// IPCache holds the IP and URL of a host last resolved by its driver, sparing
+// the calls to the API of the cloud for the stable machines.
+type IPCache struct {
+	IP  *CachedAddress `json:",omitempty"`
+	URL *CachedAddress `json:",omitempty"`
+}
+
+// CachedAddress is an address of a host and when it was resolved.
+type CachedAddress struct {
+	Value      string
+	ResolvedAt time.Time
+}
+
+func (a *CachedAddress) fresh() bool {
+	return a != nil && IPCacheTTL > 0 && time.Since(a.ResolvedAt) < IPCacheTTL
+}
+
+// IP returns the IP of the host, from the cache if it was resolved less than
+// IPCacheTTL ago.
+func (h *Host) IP() (string, error) {
+	resolve := func() (string, error) {
+		ip, err := h.Driver.GetIP()
+		// the URL cached may hold another IP
+		if err == nil && h.IPCache != nil && (h.IPCache.IP == nil || h.IPCache.IP.Value != ip) {
+			h.IPCache.URL = nil
+		}
+		return ip, err
+	}
+	return h.cachedAddress(func(c *IPCache) **CachedAddress { return &c.IP }, resolve)
+}
+
+// RefreshIP returns the IP of the host as resolved by its driver, dropping
+// the addresses cached.
+func (h *Host) RefreshIP() (string, error) {
+	h.InvalidateIPCache()
+	return h.IP()
+}
+
+// URL returns the URL of the host, from the cache if it was resolved less
+// than IPCacheTTL ago.
+func (h *Host) URL() (string, error) {
+	return h.cachedAddress(func(c *IPCache) **CachedAddress { return &c.URL }, h.Driver.GetURL)
+}
+
+// InvalidateIPCache drops the addresses cached, e.g. when the machine is
+// started or stopped and may change its IP.
+func (h *Host) InvalidateIPCache() {
+	if h.IPCache != nil {
+		h.IPCache = nil
+		h.ipCacheUpdated = true
+	}
+}
+
+// IPCacheUpdated tells whether the addresses cached changed since the host
+// was loaded, and should be saved.
+func (h *Host) IPCacheUpdated() bool {
+	return h.ipCacheUpdated
+}
+
+func (h *Host) cachedAddress(entry func(*IPCache) **CachedAddress, resolve func() (string, error)) (string, error) {
+	if h.IPCache != nil {
+		if cached := *entry(h.IPCache); cached.fresh() {
+			return cached.Value, nil
+		}
+	}
+
+	value, err := resolve()
+	if err != nil {
+		return "", err
+	}
+
+	if IPCacheTTL > 0 {
+		if h.IPCache == nil {
+			h.IPCache = &IPCache{}
+		}
+		*entry(h.IPCache) = &CachedAddress{Value: value, ResolvedAt: time.Now()}
+		h.ipCacheUpdated = true
+	}
+
+	return value, nil
+}
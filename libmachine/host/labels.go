@@ -0,0 +1,31 @@
+package host
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseLabels parses the labels of a host given as key=value.
+func ParseLabels(labels []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		parsed[kv[0]] = kv[1]
+	}
+	return parsed, nil
+}
+
+// LabelsString returns the labels of h as key=value, sorted by key and comma
+// separated.
+func (h *Host) LabelsString() string {
+	labels := []string{}
+	for key, value := range h.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
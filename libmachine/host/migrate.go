@@ -29,6 +29,12 @@ func (r *RawDataDriver) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// SecretFields returns nil, the driver of the raw config being unknown, for
+// its secret fields to be told by their name.
+func (r *RawDataDriver) SecretFields() ([]string, error) {
+	return nil, nil
+}
+
 func getMigratedHostMetadata(data []byte) (*Metadata, error) {
 	// HostMetadata is for a "first pass" so we can then load the driver
 	var (
@@ -44,14 +50,9 @@ func getMigratedHostMetadata(data []byte) (*Metadata, error) {
 	return migratedHostMetadata, nil
 }
 
+// MigrateHost loads h from data, migrating it from an older config version
+// if needed, in which case the boolean returned is true.
 func MigrateHost(h *Host, data []byte) (*Host, bool, error) {
-	var (
-		migrationNeeded    = false
-		migrationPerformed = false
-		hostV1             *V1
-		hostV2             *V2
-	)
-
 	migratedHostMetadata, err := getMigratedHostMetadata(data)
 	if err != nil {
 		return nil, false, err
@@ -61,60 +62,86 @@ func MigrateHost(h *Host, data []byte) (*Host, bool, error) {
 
 	driver := &RawDataDriver{none.NewDriver(h.Name, globalStorePath), nil}
 
-	if migratedHostMetadata.ConfigVersion > version.ConfigVersion {
+	configVersion := migratedHostMetadata.ConfigVersion
+	if configVersion > version.ConfigVersion {
 		return nil, false, errConfigFromFuture
 	}
 
-	if migratedHostMetadata.ConfigVersion == version.ConfigVersion {
+	if configVersion == version.ConfigVersion {
 		h.Driver = driver
 		if err := json.Unmarshal(data, &h); err != nil {
-			return nil, migrationPerformed, fmt.Errorf("Error unmarshalling most recent host version: %s", err)
+			return nil, false, fmt.Errorf("Error unmarshalling most recent host version: %s", err)
 		}
-	} else {
-		migrationNeeded = true
+		h.RawDriver = driver.Data
+		return h, false, nil
 	}
 
-	if migrationNeeded {
-		migrationPerformed = true
-		for h.ConfigVersion = migratedHostMetadata.ConfigVersion; h.ConfigVersion < version.ConfigVersion; h.ConfigVersion++ {
-			log.Debugf("Migrating to config v%d", h.ConfigVersion)
-			switch h.ConfigVersion {
-			case 0:
-				hostV0 := &V0{
+	if configVersion < legacyConfigVersion {
+		if h, err = migrateLegacyHost(h, data, configVersion, driver, globalStorePath); err != nil {
+			return nil, true, err
+		}
+		if data, err = json.Marshal(h); err != nil {
+			return nil, true, fmt.Errorf("Error marshalling host config version %d: %s", legacyConfigVersion, err)
+		}
+		configVersion = legacyConfigVersion
+	}
+
+	if data, err = migrateConfig(data, configVersion); err != nil {
+		return nil, true, err
+	}
+
+	h.Driver = driver
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, true, fmt.Errorf("Error unmarshalling migrated host: %s", err)
+	}
+	h.RawDriver = driver.Data
+
+	return h, true, nil
+}
+
+// migrateLegacyHost migrates the configs older than legacyConfigVersion,
+// which are decoded in the structs of their version.
+func migrateLegacyHost(h *Host, data []byte, configVersion int, driver *RawDataDriver, globalStorePath string) (*Host, error) {
+	var (
+		hostV1 *V1
+		hostV2 *V2
+	)
+
+	for h.ConfigVersion = configVersion; h.ConfigVersion < legacyConfigVersion; h.ConfigVersion++ {
+		log.Debugf("Migrating to config v%d", h.ConfigVersion)
+		switch h.ConfigVersion {
+		case 0:
+			hostV0 := &V0{
+				Driver: driver,
+			}
+			if err := json.Unmarshal(data, &hostV0); err != nil {
+				return nil, fmt.Errorf("Error unmarshalling host config version 0: %s", err)
+			}
+			hostV1 = MigrateHostV0ToHostV1(hostV0)
+		case 1:
+			if hostV1 == nil {
+				hostV1 = &V1{
 					Driver: driver,
 				}
-				if err := json.Unmarshal(data, &hostV0); err != nil {
-					return nil, migrationPerformed, fmt.Errorf("Error unmarshalling host config version 0: %s", err)
+				if err := json.Unmarshal(data, &hostV1); err != nil {
+					return nil, fmt.Errorf("Error unmarshalling host config version 1: %s", err)
 				}
-				hostV1 = MigrateHostV0ToHostV1(hostV0)
-			case 1:
-				if hostV1 == nil {
-					hostV1 = &V1{
-						Driver: driver,
-					}
-					if err := json.Unmarshal(data, &hostV1); err != nil {
-						return nil, migrationPerformed, fmt.Errorf("Error unmarshalling host config version 1: %s", err)
-					}
+			}
+			hostV2 = MigrateHostV1ToHostV2(hostV1)
+		case 2:
+			if hostV2 == nil {
+				hostV2 = &V2{
+					Driver: driver,
 				}
-				hostV2 = MigrateHostV1ToHostV2(hostV1)
-			case 2:
-				if hostV2 == nil {
-					hostV2 = &V2{
-						Driver: driver,
-					}
-					if err := json.Unmarshal(data, &hostV2); err != nil {
-						return nil, migrationPerformed, fmt.Errorf("Error unmarshalling host config version 2: %s", err)
-					}
+				if err := json.Unmarshal(data, &hostV2); err != nil {
+					return nil, fmt.Errorf("Error unmarshalling host config version 2: %s", err)
 				}
-				h = MigrateHostV2ToHostV3(hostV2, data, globalStorePath)
-				driver.Data = h.RawDriver
-				h.Driver = driver
-			case 3:
 			}
+			h = MigrateHostV2ToHostV3(hostV2, data, globalStorePath)
+			driver.Data = h.RawDriver
+			h.Driver = driver
 		}
 	}
 
-	h.RawDriver = driver.Data
-
-	return h, migrationPerformed, nil
+	return h, nil
 }
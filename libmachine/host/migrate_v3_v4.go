@@ -0,0 +1,35 @@
+package host
+
+// DefaultMachineOS is the OS of the machines whose driver doesn't choose it.
+const DefaultMachineOS = "linux"
+
+func init() {
+	RegisterMigration(Migration{
+		From:        3,
+		Description: "record the OS of the machine in HostOptions.MachineOS",
+		Migrate:     migrateConfigV3ToV4,
+	})
+}
+
+// migrateConfigV3ToV4 records the OS of the machine, which only the drivers
+// with an OS flag kept, e.g. vmwarevsphere. The machines of the other ones,
+// including the ones created by docker-machine, run Linux.
+func migrateConfigV3ToV4(config map[string]interface{}) error {
+	hostOptions, ok := config["HostOptions"].(map[string]interface{})
+	if !ok {
+		hostOptions = map[string]interface{}{}
+		config["HostOptions"] = hostOptions
+	}
+	if machineOS, _ := hostOptions["MachineOS"].(string); machineOS != "" {
+		return nil
+	}
+
+	machineOS := DefaultMachineOS
+	if driver, ok := config["Driver"].(map[string]interface{}); ok {
+		if driverOS, _ := driver["OS"].(string); driverOS != "" {
+			machineOS = driverOS
+		}
+	}
+	hostOptions["MachineOS"] = machineOS
+	return nil
+}
@@ -0,0 +1,90 @@
+package host
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/version"
+)
+
+// legacyConfigVersion is the version up to which the configs are migrated
+// by migrateLegacyHost rather than by registered migrations.
+const legacyConfigVersion = 3
+
+// Migration upgrades the configs of version From to the next version.
+type Migration struct {
+	From        int
+	Description string
+
+	// Migrate upgrades a decoded config in place, the numbers of which
+	// are json.Number
+	Migrate func(config map[string]interface{}) error
+}
+
+var migrations = map[int]Migration{}
+
+func init() {
+	for _, m := range []Migration{
+		{From: 0, Description: "move the TLS settings of docker-machine v0.1 and v0.2 to HostOptions"},
+		{From: 1, Description: "move the store path of the machine to its AuthOptions"},
+		{From: 2, Description: "keep the raw driver config so that driver plugins can load it"},
+	} {
+		RegisterMigration(m)
+	}
+}
+
+// RegisterMigration registers the migration of the configs of version
+// m.From. Bumping version.ConfigVersion requires registering the migration
+// from the previous version.
+func RegisterMigration(m Migration) {
+	if _, ok := migrations[m.From]; ok {
+		panic(fmt.Sprintf("migration of config version %d registered twice", m.From))
+	}
+	migrations[m.From] = m
+}
+
+// migrateConfig applies the registered migrations to a config of version
+// configVersion, up to version.ConfigVersion.
+func migrateConfig(data []byte, configVersion int) ([]byte, error) {
+	config := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling host config version %d: %s", configVersion, err)
+	}
+
+	for ; configVersion < version.ConfigVersion; configVersion++ {
+		m, ok := migrations[configVersion]
+		if !ok || m.Migrate == nil {
+			return nil, fmt.Errorf("no migration of config version %d is registered", configVersion)
+		}
+
+		log.Debugf("Migrating to config v%d: %s", configVersion+1, m.Description)
+		if err := m.Migrate(config); err != nil {
+			return nil, fmt.Errorf("Error migrating host config version %d: %s", configVersion, err)
+		}
+		config["ConfigVersion"] = configVersion + 1
+	}
+
+	return json.Marshal(config)
+}
+
+// PendingMigrations returns the migrations MigrateHost would apply to a
+// config, in order.
+func PendingMigrations(data []byte) ([]Migration, error) {
+	metadata, err := getMigratedHostMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.ConfigVersion > version.ConfigVersion {
+		return nil, errConfigFromFuture
+	}
+
+	pending := []Migration{}
+	for v := metadata.ConfigVersion; v < version.ConfigVersion; v++ {
+		pending = append(pending, migrations[v])
+	}
+	return pending, nil
+}
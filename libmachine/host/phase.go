@@ -0,0 +1,48 @@
+package host
+
+// CreatePhase is a phase of the creation of a host. The phase reached is
+// saved with the host, so that a failed creation resumes after it.
+type CreatePhase string
+
+const (
+	// CreateComplete is the phase of the hosts created, and of those
+	// created before the phases were saved
+	CreateComplete CreatePhase = ""
+	// CreateStarted is the phase of the hosts saved before their driver
+	// creates them, and after it failed to, with the resources it created
+	CreateStarted         CreatePhase = "started"
+	CreateInstanceCreated CreatePhase = "instance-created"
+	CreateSSHReady        CreatePhase = "ssh-ready"
+	CreateProvisioned     CreatePhase = "provisioned"
+	// CreateCertsInstalled is the phase of the hosts whose engine answers
+	// with the certificates installed
+	CreateCertsInstalled CreatePhase = "certs-installed"
+)
+
+var createPhases = []CreatePhase{
+	CreateStarted,
+	CreateInstanceCreated,
+	CreateSSHReady,
+	CreateProvisioned,
+	CreateCertsInstalled,
+	CreateComplete,
+}
+
+func (p CreatePhase) index() int {
+	for i, phase := range createPhases {
+		if phase == p {
+			return i
+		}
+	}
+	return 0
+}
+
+// Reached tells whether the phase p is phase or one after it.
+func (p CreatePhase) Reached(phase CreatePhase) bool {
+	return p.index() >= phase.index()
+}
+
+// IsCreating tells whether the creation of the host is in progress or failed.
+func (h *Host) IsCreating() bool {
+	return h.CreatePhase != CreateComplete
+}
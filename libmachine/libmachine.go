@@ -1,9 +1,11 @@
 package libmachine
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"path/filepath"
+	"time"
 
 	"github.com/rancher/machine/drivers/errdriver"
 	"github.com/rancher/machine/libmachine/auth"
@@ -13,6 +15,8 @@ import (
 	"github.com/rancher/machine/libmachine/drivers/plugin/localbinary"
 	rpcdriver "github.com/rancher/machine/libmachine/drivers/rpc"
 	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/events"
+	"github.com/rancher/machine/libmachine/hooks"
 	"github.com/rancher/machine/libmachine/host"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnerror"
@@ -25,10 +29,14 @@ import (
 	"github.com/rancher/machine/libmachine/version"
 )
 
+// rollbackTimeout bounds the removal of a machine whose creation failed.
+const rollbackTimeout = 10 * time.Minute
+
 type API interface {
 	io.Closer
 	NewHost(driverName string, rawDriver []byte) (*host.Host, error)
 	Create(h *host.Host) error
+	CreateContext(ctx context.Context, h *host.Host) error
 	persist.Store
 	GetMachinesDir() string
 }
@@ -38,6 +46,17 @@ type Client struct {
 	IsDebug        bool
 	SSHClientType  ssh.ClientType
 	GithubAPIToken string
+	// LockTimeout bounds the wait for a host locked by another process,
+	// which is unbounded if 0.
+	LockTimeout time.Duration
+	// Hooks runs the commands registered for the phases of the life of
+	// the machines.
+	Hooks *hooks.Runner
+	// Events publishes the transitions of the states of the machines.
+	Events *events.Bus
+	// RollbackOnFailure removes the machines whose creation or
+	// provisioning failed, rather than leaving them to be removed.
+	RollbackOnFailure bool
 	persist.Store
 	clientDriverFactory rpcdriver.RPCClientDriverFactory
 }
@@ -48,6 +67,8 @@ func NewClient(storePath, certsDir string) *Client {
 		IsDebug:             false,
 		SSHClientType:       ssh.External,
 		Store:               persist.NewFilestore(storePath, certsDir, certsDir),
+		Hooks:               &hooks.Runner{Dir: filepath.Join(storePath, "hooks.d")},
+		Events:              &events.Bus{Journal: filepath.Join(storePath, "events.log")},
 		clientDriverFactory: rpcdriver.NewRPCClientDriverFactory(),
 	}
 }
@@ -115,51 +136,106 @@ func (api *Client) Load(name string) (*host.Host, error) {
 // Create is the wrapper method which covers all of the boilerplate around
 // actually creating, provisioning, and persisting an instance in the store.
 func (api *Client) Create(h *host.Host) error {
-	if h.HostOptions.CustomInstallScript == "" {
-		if err := cert.BootstrapCertificates(h.AuthOptions()); err != nil {
-			return fmt.Errorf("Error generating certificates: %s", err)
+	return api.CreateContext(context.Background(), h)
+}
+
+// CreateContext creates a host as Create does, aborting the creation in the
+// driver when ctx is done. The creation of a host which failed, loaded from
+// the store, resumes after the last phase it completed.
+func (api *Client) CreateContext(ctx context.Context, h *host.Host) error {
+	log.SetPhase(h.Name, "pre-create")
+	defer log.SetPhase(h.Name, "")
+
+	if h.IsCreating() {
+		log.Infof("Resuming the creation of %s after the phase %s...", h.Name, h.CreatePhase)
+	} else {
+		if h.HostOptions.CustomInstallScript == "" {
+			if err := cert.BootstrapCertificates(h.AuthOptions()); err != nil {
+				return fmt.Errorf("Error generating certificates: %s", err)
+			}
 		}
-	}
 
-	log.Info("Running pre-create checks...")
+		log.Info("Running pre-create checks...")
 
-	if err := h.Driver.PreCreateCheck(); err != nil {
-		return mcnerror.ErrDuringPreCreate{
-			Cause: err,
+		if err := h.Driver.PreCreateCheck(); err != nil {
+			return mcnerror.ErrDuringPreCreate{
+				Cause: err,
+			}
 		}
-	}
 
-	if err := api.Save(h); err != nil {
-		return fmt.Errorf("Error saving host to store before attempting creation: %s", err)
+		if err := api.Hooks.Run(hooks.PreCreate, h); err != nil {
+			return err
+		}
+
+		h.CreatePhase = host.CreateStarted
+		if err := api.Save(h); err != nil {
+			return fmt.Errorf("Error saving host to store before attempting creation: %s", err)
+		}
 	}
 
+	log.SetPhase(h.Name, "create")
 	log.Info("Creating machine...")
+	api.Events.Publish(events.New(h, events.Creating, nil))
+	drivers.SetProgressFunc(h.Driver, api.Events.ProgressFunc(h))
 
-	if err := api.performCreate(h); err != nil {
+	if err := api.performCreate(ctx, h); err != nil {
+		api.Events.Publish(events.New(h, events.Error, err))
+		if api.RollbackOnFailure {
+			api.rollbackCreate(h)
+		}
 		return fmt.Errorf("Error creating machine: %s", err)
 	}
 
+	if err := api.saveCreatePhase(h, host.CreateComplete); err != nil {
+		return err
+	}
+
+	api.Events.Publish(events.New(h, events.Created, nil))
+	api.Hooks.RunPost(hooks.PostCreate, h)
+
 	log.Debug("Reticulating splines...")
 
 	return nil
 }
 
-func (api *Client) performCreate(h *host.Host) error {
-	if err := h.Driver.Create(); err != nil {
-		return fmt.Errorf("Error in driver during machine creation: %s", err)
+// saveCreatePhase saves h with the phase of its creation completed.
+func (api *Client) saveCreatePhase(h *host.Host, phase host.CreatePhase) error {
+	h.CreatePhase = phase
+	if err := api.Save(h); err != nil {
+		return fmt.Errorf("Error saving host to store after the phase %s: %s", phase, err)
+	}
+	return nil
+}
+
+// performCreate creates h, skipping the phases its creation completed already.
+func (api *Client) performCreate(ctx context.Context, h *host.Host) error {
+	if !h.CreatePhase.Reached(host.CreateInstanceCreated) {
+		if err := drivers.Create(ctx, h.Driver); err != nil {
+			// the config of the driver records the resources it
+			// created already, for the creation to resume with them
+			if err := api.Save(h); err != nil {
+				log.Warnf("Error saving host to store after the creation failed: %s", err)
+			}
+			return fmt.Errorf("Error in driver during machine creation: %s", err)
+		}
+		h.CreatePhase = host.CreateInstanceCreated
 	}
 
 	if err := api.Save(h); err != nil {
 		return fmt.Errorf("Error saving host to store after attempting creation: %s", err)
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// TODO: Not really a fan of just checking "none" or "ci-test" here.
 	if h.Driver.DriverName() == "none" || h.Driver.DriverName() == "noop" || h.Driver.DriverName() == "ci-test" {
 		return nil
 	}
 
 	log.Info("Waiting for machine to be running, this may take a few minutes...")
-	if err := mcnutils.WaitFor(drivers.MachineInState(h.Driver, state.Running)); err != nil {
+	if err := mcnutils.WaitForOperation(mcnutils.RetryState, drivers.MachineInState(h.Driver, state.Running)); err != nil {
 		return fmt.Errorf("Error waiting for machine to be running: %s", err)
 	}
 
@@ -173,27 +249,110 @@ func (api *Client) performCreate(h *host.Host) error {
 	if err != nil {
 		return fmt.Errorf("Error detecting OS: %s", err)
 	}
+	if !h.CreatePhase.Reached(host.CreateSSHReady) {
+		if err := api.saveCreatePhase(h, host.CreateSSHReady); err != nil {
+			return err
+		}
+	}
 
-	log.Infof("Provisioning with %s...", provisioner.String())
-	if h.HostOptions.CustomInstallScript != "" {
-		log.Infof("Provisioning with custom install script via SSH, not installing Docker...")
-		return provision.WithCustomScript(provisioner, h.HostOptions.CustomInstallScript, h.HostOptions.HostnameOverride)
-	} else {
-		if err := provisioner.Provision(*h.HostOptions.SwarmOptions, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions); err != nil {
+	if !h.CreatePhase.Reached(host.CreateProvisioned) {
+		if err := api.Hooks.Run(hooks.PreProvision, h); err != nil {
+			return err
+		}
+		api.Events.Publish(events.New(h, events.Provisioning, nil))
+		log.SetPhase(h.Name, "provision")
+
+		log.Infof("Provisioning with %s...", provisioner.String())
+		if h.HostOptions.CustomInstallScript != "" {
+			log.Infof("Provisioning with custom install script via SSH, not installing Docker...")
+			if err := provision.WithCustomScript(provisioner, h.HostOptions.CustomInstallScript, h.HostOptions.HostnameOverride); err != nil {
+				return err
+			}
+		} else {
+			if err := provision.ProvisionEngine(provisioner, *h.HostOptions.SwarmOptions, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions); err != nil {
+				return err
+			}
+		}
+
+		if err := api.saveCreatePhase(h, host.CreateProvisioned); err != nil {
 			return err
 		}
+	} else if !h.CreatePhase.Reached(host.CreateCertsInstalled) && h.HostOptions.CustomInstallScript == "" {
+		// the engine was provisioned, but did not answer with the
+		// certificates installed then
+		log.Info("Installing the certificates again...")
+		if err := h.RotateCerts(); err != nil {
+			return err
+		}
+	}
+
+	if h.HostOptions.EngineOptions.IsContainerd() {
+		log.Info("containerd is up and running!")
+	} else if h.HostOptions.CustomInstallScript == "" && drivers.IsEnginePrivate(h.Driver) {
+		log.Infof("Skipping the connection check, the Docker daemon of %s is reachable from its private network only", h.Name)
+	} else if h.HostOptions.CustomInstallScript == "" {
+		// We should check the connection to docker here
+		log.Info("Checking connection to Docker...")
+		if _, _, err = check.DefaultConnChecker.Check(h, false); err != nil {
+			return fmt.Errorf("Error checking the host: %s", err)
+		}
+
+		if h.HostOptions.EngineOptions.IsPodman() {
+			log.Info("Podman is up and running!")
+		} else {
+			log.Info("Docker is up and running!")
+		}
 	}
 
-	// We should check the connection to docker here
-	log.Info("Checking connection to Docker...")
-	if _, _, err = check.DefaultConnChecker.Check(h, false); err != nil {
-		return fmt.Errorf("Error checking the host: %s", err)
+	if !h.CreatePhase.Reached(host.CreateCertsInstalled) {
+		if h.HostOptions.CustomInstallScript == "" {
+			if err := h.UpdateCertExpiry(); err != nil {
+				log.Debugf("Error recording the expiry of the certificates: %s", err)
+			}
+		}
+		if err := api.saveCreatePhase(h, host.CreateCertsInstalled); err != nil {
+			return err
+		}
 	}
 
-	log.Info("Docker is up and running!")
+	api.Events.Publish(events.New(h, events.Provisioned, nil))
+	api.Hooks.RunPost(hooks.PostProvision, h)
 	return nil
 }
 
+// rollbackCreate removes the resources created by the driver for a machine
+// whose creation failed, then the machine from the store. The machine is kept
+// in the store if the driver fails to remove them, for rm to try again.
+func (api *Client) rollbackCreate(h *host.Host) {
+	log.SetPhase(h.Name, "rollback")
+	log.Infof("Rolling back the creation of %s...", h.Name)
+
+	// the context of the creation may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	if err := drivers.Remove(ctx, h.Driver); err != nil {
+		log.Warnf("Error removing the resources created for %s, run rm -f %s to try again: %s", h.Name, h.Name, err)
+		return
+	}
+	if err := api.Remove(h.Name); err != nil {
+		log.Warnf("Error removing %s from the store: %s", h.Name, err)
+		return
+	}
+	api.Events.Publish(events.New(h, events.Removed, nil))
+}
+
+// LockHost locks the host against changes from other processes if the store
+// supports it, waiting at most LockTimeout for it.
+func (api *Client) LockHost(ctx context.Context, name string) (func() error, error) {
+	if api.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.LockTimeout)
+		defer cancel()
+	}
+	return persist.LockHost(ctx, api.Store, name)
+}
+
 func (api *Client) Close() error {
 	return api.clientDriverFactory.Close()
 }
@@ -0,0 +1,159 @@
+package libmachine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/persist/persisttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingDriver fails to create hosts, half created, and to remove them if
+// removeErr is set.
+type failingDriver struct {
+	*fakedriver.Driver
+	removed   bool
+	removeErr error
+}
+
+func (d *failingDriver) Create() error {
+	return errors.New("quota exceeded")
+}
+
+func (d *failingDriver) Remove() error {
+	d.removed = true
+	return d.removeErr
+}
+
+func newFailingHost(driver *failingDriver) *host.Host {
+	return &host.Host{
+		Name:        "node-7",
+		Driver:      driver,
+		HostOptions: &host.Options{CustomInstallScript: "install.sh"},
+	}
+}
+
+func TestCreateKeepsFailedMachines(t *testing.T) {
+	store := &persisttest.FakeStore{}
+	api := &Client{Store: store}
+	driver := &failingDriver{Driver: &fakedriver.Driver{}}
+
+	err := api.Create(newFailingHost(driver))
+
+	assert.EqualError(t, err, "Error creating machine: Error in driver during machine creation: quota exceeded")
+	assert.False(t, driver.removed)
+	assert.Len(t, store.Hosts, 1)
+}
+
+func TestCreateRollsBackFailedMachines(t *testing.T) {
+	store := &persisttest.FakeStore{}
+	api := &Client{Store: store, RollbackOnFailure: true}
+	driver := &failingDriver{Driver: &fakedriver.Driver{}}
+
+	err := api.Create(newFailingHost(driver))
+
+	assert.Error(t, err)
+	assert.True(t, driver.removed)
+	assert.Empty(t, store.Hosts)
+}
+
+func TestCreateKeepsMachinesWhoseRollbackFailed(t *testing.T) {
+	store := &persisttest.FakeStore{}
+	api := &Client{Store: store, RollbackOnFailure: true}
+	driver := &failingDriver{Driver: &fakedriver.Driver{}, removeErr: errors.New("throttled")}
+
+	err := api.Create(newFailingHost(driver))
+
+	assert.Error(t, err)
+	assert.True(t, driver.removed)
+	assert.Len(t, store.Hosts, 1)
+}
+
+// noneFailingDriver is a failingDriver which needs no provisioning, as the
+// none driver.
+type noneFailingDriver struct {
+	*failingDriver
+}
+
+func (d *noneFailingDriver) DriverName() string {
+	return "none"
+}
+
+func TestCreateResumesAfterTheLastPhase(t *testing.T) {
+	store := &persisttest.FakeStore{}
+	api := &Client{Store: store}
+	h := newFailingHost(&failingDriver{Driver: &fakedriver.Driver{}})
+	h.Driver = &noneFailingDriver{h.Driver.(*failingDriver)}
+	h.CreatePhase = host.CreateInstanceCreated
+
+	err := api.Create(h)
+
+	assert.NoError(t, err)
+	assert.Equal(t, host.CreateComplete, h.CreatePhase)
+	assert.False(t, h.IsCreating())
+}
+
+// resumableDriver creates its instance once, recording it in its config, and
+// fails the first creation after it did.
+type resumableDriver struct {
+	*fakedriver.Driver
+	InstanceID string
+	instances  int
+	fail       bool
+}
+
+func (d *resumableDriver) DriverName() string {
+	return "none"
+}
+
+func (d *resumableDriver) Create() error {
+	if d.InstanceID == "" {
+		d.instances++
+		d.InstanceID = fmt.Sprintf("i-%d", d.instances)
+	}
+	if d.fail {
+		d.fail = false
+		return errors.New("instance not ready")
+	}
+	return nil
+}
+
+// snapshotStore saves copies of the hosts and of their resumableDriver, as a
+// store serializing them does.
+type snapshotStore struct {
+	persisttest.FakeStore
+}
+
+func (s *snapshotStore) Save(h *host.Host) error {
+	saved := *h
+	driver := *h.Driver.(*resumableDriver)
+	saved.Driver = &driver
+	s.Hosts = []*host.Host{&saved}
+	return nil
+}
+
+func TestCreateResumesWithTheResourcesOfTheFailedDriver(t *testing.T) {
+	store := &snapshotStore{}
+	api := &Client{Store: store}
+	driver := &resumableDriver{Driver: &fakedriver.Driver{}, fail: true}
+	h := &host.Host{Name: "node-7", Driver: driver, HostOptions: &host.Options{CustomInstallScript: "install.sh"}}
+
+	err := api.Create(h)
+
+	assert.EqualError(t, err, "Error creating machine: Error in driver during machine creation: instance not ready")
+	saved, err := store.Load("node-7")
+	assert.NoError(t, err)
+	assert.Equal(t, host.CreateStarted, saved.CreatePhase)
+	assert.Equal(t, "i-1", saved.Driver.(*resumableDriver).InstanceID)
+
+	saved.Driver.(*resumableDriver).instances = driver.instances
+	err = api.Create(saved)
+
+	assert.NoError(t, err)
+	assert.Equal(t, host.CreateComplete, saved.CreatePhase)
+	assert.Equal(t, "i-1", saved.Driver.(*resumableDriver).InstanceID)
+	assert.Equal(t, 1, saved.Driver.(*resumableDriver).instances)
+}
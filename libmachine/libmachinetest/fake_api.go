@@ -1,6 +1,8 @@
 package libmachinetest
 
 import (
+	"context"
+
 	"github.com/rancher/machine/libmachine"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/host"
@@ -28,6 +30,10 @@ func (api *FakeAPI) Create(h *host.Host) error {
 	return nil
 }
 
+func (api *FakeAPI) CreateContext(ctx context.Context, h *host.Host) error {
+	return nil
+}
+
 func (api *FakeAPI) Exists(name string) (bool, error) {
 	for _, host := range api.Hosts {
 		if name == host.Name {
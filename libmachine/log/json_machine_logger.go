@@ -0,0 +1,153 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The levels of the entries.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Entry is a log line of the JSON format.
+type Entry struct {
+	Level     string    `json:"level"`
+	Timestamp time.Time `json:"timestamp"`
+	// Host is the machine the line is about, if known
+	Host string `json:"host,omitempty"`
+	// Phase is what is being done to the machine, e.g. create or provision
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message"`
+}
+
+// ParseEntry parses a line of the JSON format, e.g. output by the plugin of a
+// driver.
+func ParseEntry(line string) (Entry, bool) {
+	e := Entry{}
+	if !strings.HasPrefix(line, "{") {
+		return e, false
+	}
+	if err := json.Unmarshal([]byte(line), &e); err != nil || e.Level == "" {
+		return e, false
+	}
+	return e, true
+}
+
+// JSONMachineLogger logs a JSON object per line, for the log collectors to
+// parse.
+type JSONMachineLogger struct {
+	outWriter io.Writer
+	errWriter io.Writer
+	debug     bool
+	history   *HistoryRecorder
+	lock      sync.Mutex
+}
+
+// NewJSONMachineLogger creates a MachineLogger logging in the JSON format.
+func NewJSONMachineLogger() MachineLogger {
+	return &JSONMachineLogger{
+		outWriter: os.Stdout,
+		errWriter: os.Stderr,
+		debug:     false,
+		history:   NewHistoryRecorder(),
+	}
+}
+
+func (ml *JSONMachineLogger) SetDebug(debug bool) {
+	ml.debug = debug
+}
+
+func (ml *JSONMachineLogger) SetOutWriter(out io.Writer) {
+	ml.outWriter = out
+}
+
+func (ml *JSONMachineLogger) SetErrWriter(err io.Writer) {
+	ml.errWriter = err
+}
+
+// LogEntry logs e, filling its host and phase from those of SetPhase if it
+// has none.
+func (ml *JSONMachineLogger) LogEntry(e Entry) {
+	ml.history.Record(e.Message)
+	if e.Level == LevelDebug && !ml.debug {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	if e.Host == "" {
+		e.Host = currentHost()
+	}
+	if e.Phase == "" {
+		e.Phase = phaseOf(e.Host)
+	}
+	e.Message = Redact(e.Message)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	w := ml.outWriter
+	if e.Level == LevelError {
+		w = ml.errWriter
+	}
+
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	w.Write(append(line, '\n'))
+}
+
+func (ml *JSONMachineLogger) log(level string, message string) {
+	ml.LogEntry(Entry{Level: level, Message: message})
+}
+
+func (ml *JSONMachineLogger) Debug(args ...interface{}) {
+	ml.log(LevelDebug, sprintln(args...))
+}
+
+func (ml *JSONMachineLogger) Debugf(fmtString string, args ...interface{}) {
+	ml.log(LevelDebug, fmt.Sprintf(fmtString, args...))
+}
+
+func (ml *JSONMachineLogger) Error(args ...interface{}) {
+	ml.log(LevelError, sprintln(args...))
+}
+
+func (ml *JSONMachineLogger) Errorf(fmtString string, args ...interface{}) {
+	ml.log(LevelError, fmt.Sprintf(fmtString, args...))
+}
+
+func (ml *JSONMachineLogger) Info(args ...interface{}) {
+	ml.log(LevelInfo, sprintln(args...))
+}
+
+func (ml *JSONMachineLogger) Infof(fmtString string, args ...interface{}) {
+	ml.log(LevelInfo, fmt.Sprintf(fmtString, args...))
+}
+
+func (ml *JSONMachineLogger) Warn(args ...interface{}) {
+	ml.log(LevelWarn, sprintln(args...))
+}
+
+func (ml *JSONMachineLogger) Warnf(fmtString string, args ...interface{}) {
+	ml.log(LevelWarn, fmt.Sprintf(fmtString, args...))
+}
+
+func (ml *JSONMachineLogger) History() []string {
+	return ml.history.records
+}
+
+// sprintln formats args as the FmtMachineLogger does, without the newline.
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func jsonEntries(t *testing.T, out string) []Entry {
+	entries := []Entry{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		e := Entry{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestJSONMachineLogger(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	testLogger := NewJSONMachineLogger()
+	testLogger.SetOutWriter(out)
+	testLogger.SetErrWriter(errOut)
+
+	testLogger.Info("Creating", "machine...")
+	testLogger.Debugf("hidden %d", 1)
+	testLogger.SetDebug(true)
+	testLogger.Debugf("shown %d", 2)
+	testLogger.Warnf("careful")
+	testLogger.Error("failed")
+
+	entries := jsonEntries(t, out.String())
+	assert.Len(t, entries, 3)
+	assert.Equal(t, LevelInfo, entries[0].Level)
+	assert.Equal(t, "Creating machine...", entries[0].Message)
+	assert.False(t, entries[0].Timestamp.IsZero())
+	assert.Equal(t, LevelDebug, entries[1].Level)
+	assert.Equal(t, "shown 2", entries[1].Message)
+	assert.Equal(t, LevelWarn, entries[2].Level)
+
+	errEntries := jsonEntries(t, errOut.String())
+	assert.Equal(t, LevelError, errEntries[0].Level)
+	assert.Equal(t, "failed", errEntries[0].Message)
+
+	assert.Equal(t, []string{"Creating machine...", "hidden 1", "shown 2", "careful", "failed"}, testLogger.History())
+}
+
+func TestJSONMachineLoggerHostAndPhase(t *testing.T) {
+	out := &bytes.Buffer{}
+	testLogger := NewJSONMachineLogger().(*JSONMachineLogger)
+	testLogger.SetOutWriter(out)
+
+	SetPhase("worker-1", "create")
+	defer SetPhase("worker-1", "")
+
+	testLogger.Info("Creating machine...")
+	testLogger.LogEntry(Entry{Level: LevelInfo, Host: "worker-2", Phase: "provision", Message: "from the plugin"})
+
+	SetPhase("worker-2", "create")
+	testLogger.Info("Two machines")
+	SetPhase("worker-2", "")
+
+	entries := jsonEntries(t, out.String())
+	assert.Equal(t, "worker-1", entries[0].Host)
+	assert.Equal(t, "create", entries[0].Phase)
+	assert.Equal(t, "worker-2", entries[1].Host)
+	assert.Equal(t, "provision", entries[1].Phase)
+	assert.Equal(t, "", entries[2].Host)
+}
+
+func TestParseEntry(t *testing.T) {
+	e, ok := ParseEntry(`{"level":"debug","timestamp":"2020-01-02T03:04:05Z","phase":"create","message":"Launching instance"}`)
+	assert.True(t, ok)
+	assert.Equal(t, LevelDebug, e.Level)
+	assert.Equal(t, "create", e.Phase)
+	assert.Equal(t, "Launching instance", e.Message)
+	assert.Equal(t, 2020, e.Timestamp.Year())
+
+	_, ok = ParseEntry("Launching instance")
+	assert.False(t, ok)
+
+	_, ok = ParseEntry(`{"Image": "ami-123"}`)
+	assert.False(t, ok)
+}
+
+func TestSetFormat(t *testing.T) {
+	defer SetFormat(FormatText)
+
+	assert.NoError(t, SetFormat(FormatJSON))
+	assert.IsType(t, &JSONMachineLogger{}, logger)
+
+	assert.NoError(t, SetFormat(""))
+	assert.IsType(t, &FmtMachineLogger{}, logger)
+
+	assert.EqualError(t, SetFormat("xml"), `unknown log format "xml", expected text or json`)
+}
+
+func TestLogEntryInTextFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	defer SetFormat(FormatText)
+	SetOutWriter(out)
+
+	LogEntry(Entry{Level: LevelInfo, Host: "worker-1", Message: "Launching instance"})
+	assert.Equal(t, "(worker-1) Launching instance\n", out.String())
+}
@@ -28,6 +28,14 @@ func (e ErrHostAlreadyExists) Error() string {
 	return fmt.Sprintf("Docker machine %q already exists", e.Name)
 }
 
+type ErrHostBusy struct {
+	Name string
+}
+
+func (e ErrHostBusy) Error() string {
+	return fmt.Sprintf("Docker machine %q is busy: it is being used by another process. Retry later or raise --lock-timeout.", e.Name)
+}
+
 type ErrDuringPreCreate struct {
 	Cause error
 }
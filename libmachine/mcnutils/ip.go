@@ -0,0 +1,42 @@
+package mcnutils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// UnbracketHost returns host without the brackets of an IPv6 address, e.g.
+// ::1 for [::1], which net.JoinHostPort would double.
+func UnbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// IsIPv6 tells whether host is an IPv6 address, bracketed or not, with or
+// without a zone, e.g. fe80::1%eth0.
+func IsIPv6(host string) bool {
+	host = UnbracketHost(host)
+	if i := strings.LastIndex(host, "%"); i > 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// BracketHost returns host bracketed if it is an IPv6 address, e.g. for the
+// locations of scp, user@[::1]:path.
+func BracketHost(host string) string {
+	if IsIPv6(host) && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// DockerURL returns the URL of the Docker daemon listening on port of host,
+// e.g. tcp://[2001:db8::1]:2376 for an IPv6 address.
+func DockerURL(host string, port int) string {
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(UnbracketHost(host), fmt.Sprint(port)))
+}
@@ -0,0 +1,29 @@
+package mcnutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIPv6(t *testing.T) {
+	assert.True(t, IsIPv6("2001:db8::1"))
+	assert.True(t, IsIPv6("[2001:db8::1]"))
+	assert.True(t, IsIPv6("fe80::1%eth0"))
+	assert.False(t, IsIPv6("192.168.99.100"))
+	assert.False(t, IsIPv6("::ffff:192.168.99.100"))
+	assert.False(t, IsIPv6("example.com"))
+}
+
+func TestBracketHost(t *testing.T) {
+	assert.Equal(t, "[2001:db8::1]", BracketHost("2001:db8::1"))
+	assert.Equal(t, "[2001:db8::1]", BracketHost("[2001:db8::1]"))
+	assert.Equal(t, "192.168.99.100", BracketHost("192.168.99.100"))
+	assert.Equal(t, "2001:db8::1", UnbracketHost("[2001:db8::1]"))
+}
+
+func TestDockerURL(t *testing.T) {
+	assert.Equal(t, "tcp://192.168.99.100:2376", DockerURL("192.168.99.100", 2376))
+	assert.Equal(t, "tcp://[2001:db8::1]:2376", DockerURL("2001:db8::1", 2376))
+	assert.Equal(t, "tcp://[2001:db8::1]:2376", DockerURL("[2001:db8::1]", 2376))
+}
@@ -0,0 +1,34 @@
+package mcnutils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option is a key=value option of a spec parsed by ParseOptions.
+type Option struct {
+	Key   string
+	Value string
+}
+
+// ParseKeyValue splits s at its first "=" into a key and a value trimmed of
+// their spaces, reporting whether it has one.
+func ParseKeyValue(s string) (string, string, bool) {
+	key, value, found := strings.Cut(s, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value), found
+}
+
+// ParseOptions parses a spec given as comma separated key=value options, e.g.
+// 'size=256,type=gp3', keeping their order. what names the spec in the error,
+// e.g. "data disk".
+func ParseOptions(what, spec string) ([]Option, error) {
+	var options []Option
+	for _, option := range strings.Split(spec, ",") {
+		key, value, found := ParseKeyValue(option)
+		if !found {
+			return nil, fmt.Errorf("invalid %s option %q in %q, expected key=value", what, option, spec)
+		}
+		options = append(options, Option{Key: key, Value: value})
+	}
+	return options, nil
+}
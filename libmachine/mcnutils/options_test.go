@@ -0,0 +1,31 @@
+package mcnutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeyValue(t *testing.T) {
+	key, value, found := ParseKeyValue(" Environment = Production ")
+	assert.True(t, found)
+	assert.Equal(t, "Environment", key)
+	assert.Equal(t, "Production", value)
+
+	key, value, found = ParseKeyValue("url=http://host/?a=b")
+	assert.True(t, found)
+	assert.Equal(t, "url", key)
+	assert.Equal(t, "http://host/?a=b", value)
+
+	_, _, found = ParseKeyValue("Environment")
+	assert.False(t, found)
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions("data disk", "size=256, type=UltraSSD_LRS,iops=")
+	assert.NoError(t, err)
+	assert.Equal(t, []Option{{"size", "256"}, {"type", "UltraSSD_LRS"}, {"iops", ""}}, options)
+
+	_, err = ParseOptions("data disk", "size=256,type")
+	assert.EqualError(t, err, `invalid data disk option "type" in "size=256,type", expected key=value`)
+}
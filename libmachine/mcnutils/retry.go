@@ -0,0 +1,192 @@
+package mcnutils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// The operations retried with their own policy.
+const (
+	// RetryDefault is the operation of WaitFor, used by the drivers to poll
+	// their cloud
+	RetryDefault = "default"
+	RetrySSH     = "ssh"
+	RetryWinRM   = "winrm"
+	RetryDocker  = "docker"
+	// RetryState is the wait for a machine to be in a state
+	RetryState = "state"
+)
+
+// RetryPolicy tells how an operation is retried: MaxAttempts times at most,
+// waiting Interval after the first attempt, multiplied by Multiplier after
+// each next one up to MaxInterval. The waits vary randomly by up to Jitter,
+// a fraction of them.
+type RetryPolicy struct {
+	MaxAttempts int
+	Interval    time.Duration
+	Multiplier  float64
+	MaxInterval time.Duration
+	Jitter      float64
+}
+
+var (
+	defaultRetryPolicies = map[string]RetryPolicy{
+		RetryDefault: {MaxAttempts: 60, Interval: 3 * time.Second},
+		RetrySSH:     {MaxAttempts: 60, Interval: 3 * time.Second},
+		RetryWinRM:   {MaxAttempts: 60, Interval: 3 * time.Second},
+		RetryDocker:  {MaxAttempts: 10, Interval: 3 * time.Second},
+		RetryState:   {MaxAttempts: 60, Interval: 3 * time.Second},
+	}
+
+	retryPolicies     = map[string]RetryPolicy{}
+	retryPoliciesLock sync.RWMutex
+)
+
+// RetryPolicyEnvVar returns the environment variable overriding the policy of
+// op, e.g. MACHINE_RETRY_SSH. The plugins of the drivers inherit it.
+func RetryPolicyEnvVar(op string) string {
+	return "MACHINE_RETRY_" + strings.ToUpper(op)
+}
+
+// IsRetryOperation tells whether op is an operation retried with its own
+// policy.
+func IsRetryOperation(op string) bool {
+	_, ok := defaultRetryPolicies[op]
+	return ok
+}
+
+// SetRetryPolicy overrides the policy of op in this process.
+func SetRetryPolicy(op string, p RetryPolicy) {
+	retryPoliciesLock.Lock()
+	defer retryPoliciesLock.Unlock()
+	retryPolicies[op] = p
+}
+
+// RetryPolicyFor returns the policy of op: the one set by SetRetryPolicy, else
+// the default one with the settings of its environment variable.
+func RetryPolicyFor(op string) RetryPolicy {
+	retryPoliciesLock.RLock()
+	p, ok := retryPolicies[op]
+	retryPoliciesLock.RUnlock()
+	if ok {
+		return p
+	}
+
+	p, ok = defaultRetryPolicies[op]
+	if !ok {
+		p = defaultRetryPolicies[RetryDefault]
+	}
+	if spec := os.Getenv(RetryPolicyEnvVar(op)); spec != "" {
+		overridden, err := p.Override(spec)
+		if err != nil {
+			log.Warnf("Ignoring %s: %s", RetryPolicyEnvVar(op), err)
+			return p
+		}
+		p = overridden
+	}
+	return p
+}
+
+// Override returns p with the settings of spec, a comma separated list of
+// attempts=N, interval=DURATION, multiplier=X, max-interval=DURATION and
+// jitter=FRACTION.
+func (p RetryPolicy) Override(spec string) (RetryPolicy, error) {
+	for _, setting := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(parts) != 2 {
+			return p, fmt.Errorf("invalid retry setting %q, expected name=value", setting)
+		}
+
+		var err error
+		switch name, value := parts[0], parts[1]; name {
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+			if err == nil && p.MaxAttempts < 1 {
+				err = fmt.Errorf("at least 1 attempt is needed")
+			}
+		case "interval":
+			p.Interval, err = time.ParseDuration(value)
+		case "multiplier":
+			p.Multiplier, err = strconv.ParseFloat(value, 64)
+		case "max-interval":
+			p.MaxInterval, err = time.ParseDuration(value)
+		case "jitter":
+			p.Jitter, err = strconv.ParseFloat(value, 64)
+			if err == nil && (p.Jitter < 0 || p.Jitter > 1) {
+				err = fmt.Errorf("the jitter is a fraction between 0 and 1")
+			}
+		default:
+			return p, fmt.Errorf("unknown retry setting %q", name)
+		}
+		if err != nil {
+			return p, fmt.Errorf("invalid retry setting %q: %s", setting, err)
+		}
+	}
+	return p, nil
+}
+
+// Delay returns the wait after the attempt number attempt, counted from 1,
+// before jitter.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.Interval)
+	if p.Multiplier > 1 {
+		delay *= math.Pow(p.Multiplier, float64(attempt-1))
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) jittered(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// Retry calls f until it returns true or an error, or the attempts are
+// exhausted.
+func (p RetryPolicy) Retry(f func() (bool, error)) error {
+	return p.RetryContext(context.Background(), f)
+}
+
+// RetryContext is Retry, returning the error of ctx as soon as it is done.
+func (p RetryPolicy) RetryContext(ctx context.Context, f func() (bool, error)) error {
+	for attempt := 1; attempt <= p.MaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stop, err := f()
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+		if attempt < p.MaxAttempts {
+			timer := time.NewTimer(p.jittered(p.Delay(attempt)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return fmt.Errorf("Maximum number of retries (%d) exceeded", p.MaxAttempts)
+}
+
+// Retry calls f with the retry policy of op.
+func Retry(op string, f func() (bool, error)) error {
+	return RetryPolicyFor(op).Retry(f)
+}
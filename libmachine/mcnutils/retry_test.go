@@ -0,0 +1,100 @@
+package mcnutils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Interval: time.Second, Multiplier: 2, MaxInterval: 5 * time.Second}
+
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, 2*time.Second, p.Delay(2))
+	assert.Equal(t, 4*time.Second, p.Delay(3))
+	assert.Equal(t, 5*time.Second, p.Delay(4))
+
+	constant := RetryPolicy{Interval: time.Second}
+	assert.Equal(t, time.Second, constant.Delay(10))
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	p := RetryPolicy{Interval: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay := p.jittered(p.Delay(1))
+		assert.True(t, delay >= 500*time.Millisecond && delay <= 1500*time.Millisecond, "delay %s", delay)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Interval: time.Millisecond}
+
+	calls := 0
+	err := p.Retry(func() (bool, error) {
+		calls++
+		return calls == 2, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = p.Retry(func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	assert.EqualError(t, err, "Maximum number of retries (3) exceeded")
+	assert.Equal(t, 3, calls)
+
+	err = p.Retry(func() (bool, error) {
+		return false, errors.New("unauthorized")
+	})
+	assert.EqualError(t, err, "unauthorized")
+}
+
+func TestRetryContext(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 60, Interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := p.RetryContext(ctx, func() (bool, error) {
+		calls++
+		cancel()
+		return false, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicyOverride(t *testing.T) {
+	p, err := RetryPolicy{MaxAttempts: 60, Interval: 3 * time.Second}.Override("attempts=100, multiplier=1.5,max-interval=30s,jitter=0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, RetryPolicy{MaxAttempts: 100, Interval: 3 * time.Second, Multiplier: 1.5, MaxInterval: 30 * time.Second, Jitter: 0.2}, p)
+
+	for _, spec := range []string{"attempts", "attempts=0", "interval=soon", "jitter=2", "timeout=1m"} {
+		_, err := RetryPolicy{}.Override(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRetryPolicyFor(t *testing.T) {
+	assert.Equal(t, RetryPolicy{MaxAttempts: 10, Interval: 3 * time.Second}, RetryPolicyFor(RetryDocker))
+
+	os.Setenv("MACHINE_RETRY_SSH", "attempts=5")
+	defer os.Unsetenv("MACHINE_RETRY_SSH")
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, Interval: 3 * time.Second}, RetryPolicyFor(RetrySSH))
+
+	os.Setenv("MACHINE_RETRY_SSH", "attempts=none")
+	assert.Equal(t, RetryPolicy{MaxAttempts: 60, Interval: 3 * time.Second}, RetryPolicyFor(RetrySSH))
+
+	SetRetryPolicy(RetryState, RetryPolicy{MaxAttempts: 1})
+	defer func() {
+		retryPoliciesLock.Lock()
+		delete(retryPolicies, RetryState)
+		retryPoliciesLock.Unlock()
+	}()
+	assert.Equal(t, RetryPolicy{MaxAttempts: 1}, RetryPolicyFor(RetryState))
+}
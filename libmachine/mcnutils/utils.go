@@ -1,9 +1,9 @@
 package mcnutils
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
 	"io"
 	"os"
 	"runtime"
@@ -78,18 +78,10 @@ func CopyFile(src, dst string) error {
 	return os.Chmod(dst, fi.Mode())
 }
 
+// WaitForSpecificOrError calls f until it returns true or an error, at most
+// maxAttempts times every waitInterval.
 func WaitForSpecificOrError(f func() (bool, error), maxAttempts int, waitInterval time.Duration) error {
-	for i := 0; i < maxAttempts; i++ {
-		stop, err := f()
-		if err != nil {
-			return err
-		}
-		if stop {
-			return nil
-		}
-		time.Sleep(waitInterval)
-	}
-	return fmt.Errorf("Maximum number of retries (%d) exceeded", maxAttempts)
+	return RetryPolicy{MaxAttempts: maxAttempts, Interval: waitInterval}.Retry(f)
 }
 
 func WaitForSpecific(f func() bool, maxAttempts int, waitInterval time.Duration) error {
@@ -98,8 +90,23 @@ func WaitForSpecific(f func() bool, maxAttempts int, waitInterval time.Duration)
 	}, maxAttempts, waitInterval)
 }
 
+// WaitFor calls f until it returns true with the default retry policy.
 func WaitFor(f func() bool) error {
-	return WaitForSpecific(f, 60, 3*time.Second)
+	return WaitForOperation(RetryDefault, f)
+}
+
+// WaitForContext is WaitFor, returning the error of ctx as soon as it is done.
+func WaitForContext(ctx context.Context, f func() bool) error {
+	return RetryPolicyFor(RetryDefault).RetryContext(ctx, func() (bool, error) {
+		return f(), nil
+	})
+}
+
+// WaitForOperation calls f until it returns true with the retry policy of op.
+func WaitForOperation(op string, f func() bool) error {
+	return Retry(op, func() (bool, error) {
+		return f(), nil
+	})
 }
 
 // TruncateID returns a shorten id
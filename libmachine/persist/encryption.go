@@ -0,0 +1,365 @@
+package persist
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/drivers"
+)
+
+// encryptedPrefix marks the encrypted values of a config.
+const encryptedPrefix = "enc:v1:"
+
+var errNoEncryptionKey = errors.New("the machine config is encrypted: set MACHINE_ENCRYPTION_KEY, --encryption-key-file or --encryption-kms-plugin")
+
+// KeyWrapper encrypts the data keys of the configs with a master key, which
+// never leaves it. It is an envelope encryption: each config is encrypted
+// with its own data key, stored encrypted with the config.
+type KeyWrapper interface {
+	// ID identifies the master key, so that a config encrypted with
+	// another key is reported as such
+	ID() string
+
+	// Wrap encrypts a data key
+	Wrap(dataKey []byte) ([]byte, error)
+
+	// Unwrap decrypts a data key
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// encryptionEnvelope is the data key of a config, encrypted by the master
+// key KeyID.
+type encryptionEnvelope struct {
+	KeyID   string
+	DataKey string
+}
+
+// localKeyWrapper wraps the data keys with an AES-256 key.
+type localKeyWrapper struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// NewLocalKeyWrapper returns a KeyWrapper encrypting with key, a 32 bytes
+// AES-256 key given raw or base64 encoded.
+func NewLocalKeyWrapper(key []byte) (KeyWrapper, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key))); err == nil && len(decoded) == 32 {
+		key = decoded
+	}
+	if len(key) != 32 {
+		return nil, errors.New("the encryption key must be 32 bytes, raw or base64 encoded")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &localKeyWrapper{aead: aead, id: "local:" + hex.EncodeToString(sum[:8])}, nil
+}
+
+func (w *localKeyWrapper) ID() string {
+	return w.id
+}
+
+func (w *localKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	return seal(w.aead, dataKey, nil)
+}
+
+func (w *localKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	return open(w.aead, wrapped, nil)
+}
+
+// pluginKeyWrapper wraps the data keys with a KMS plugin, an executable
+// called with the argument wrap or unwrap, which reads the base64 encoded
+// key from stdin and writes the result base64 encoded to stdout.
+type pluginKeyWrapper struct {
+	path string
+}
+
+// NewPluginKeyWrapper returns a KeyWrapper calling the KMS plugin at path.
+func NewPluginKeyWrapper(path string) KeyWrapper {
+	return &pluginKeyWrapper{path: path}
+}
+
+func (w *pluginKeyWrapper) ID() string {
+	return "kms:" + filepath.Base(w.path)
+}
+
+func (w *pluginKeyWrapper) run(action string, in []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(w.path, action)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(in))
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("KMS plugin %s failed to %s the data key: %s: %s", w.path, action, err, strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("KMS plugin %s returned an invalid data key: %s", w.path, err)
+	}
+	return out, nil
+}
+
+func (w *pluginKeyWrapper) Wrap(dataKey []byte) ([]byte, error) {
+	return w.run("wrap", dataKey)
+}
+
+func (w *pluginKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	return w.run("unwrap", wrapped)
+}
+
+// NewKeyWrapper returns the KeyWrapper of the first of key, keyFile and
+// plugin given, or nil if none is.
+func NewKeyWrapper(key, keyFile, plugin string) (KeyWrapper, error) {
+	switch {
+	case key != "":
+		return NewLocalKeyWrapper([]byte(key))
+	case keyFile != "":
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the encryption key: %s", err)
+		}
+		return NewLocalKeyWrapper(data)
+	case plugin != "":
+		return NewPluginKeyWrapper(plugin), nil
+	}
+	return nil, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// isSensitiveField tells whether the driver field at path of a config, e.g.
+// Driver.SecretKey, holds a secret. fields are the secret fields of the
+// driver, as returned by drivers.SecretFields, or nil to tell it by name.
+func isSensitiveField(path, name string, fields []string) bool {
+	return drivers.IsSecretField(strings.TrimPrefix(path, "Driver."), name, fields)
+}
+
+// IsSensitiveFlag tells whether a create flag, e.g. amazonec2-secret-key,
+// holds a secret.
+func IsSensitiveFlag(name string) bool {
+	return drivers.IsSecretFlag(name)
+}
+
+// decodeConfig decodes a config, keeping its numbers as they are.
+func decodeConfig(data []byte) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return config, decoder.Decode(&config)
+}
+
+// hasPlaintextSecrets tells whether the driver of a config has sensitive
+// fields neither encrypted nor moved to the keyring.
+func hasPlaintextSecrets(data []byte, fields []string) bool {
+	config, err := decodeConfig(data)
+	if err != nil {
+		return false
+	}
+
+	var plaintext func(path string, value interface{}) bool
+	plaintext = func(path string, value interface{}) bool {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for name, field := range v {
+				fieldPath := path + "." + name
+				if s, ok := field.(string); ok && s != "" && isSensitiveField(fieldPath, name, fields) && !strings.HasPrefix(s, encryptedPrefix) {
+					return true
+				}
+				if plaintext(fieldPath, field) {
+					return true
+				}
+			}
+		case []interface{}:
+			for i, field := range v {
+				if plaintext(fmt.Sprintf("%s[%d]", path, i), field) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return plaintext("Driver", config["Driver"])
+}
+
+// isEncryptedConfig tells whether a config has encrypted values.
+func isEncryptedConfig(data []byte) bool {
+	config := struct {
+		Encryption *encryptionEnvelope
+	}{}
+	return json.Unmarshal(data, &config) == nil && config.Encryption != nil
+}
+
+// encryptConfig encrypts the sensitive fields of the driver of a config
+// with a new data key, wrapped by w. fields are the secret fields of the
+// driver, as returned by drivers.SecretFields.
+func encryptConfig(data []byte, w KeyWrapper, fields []string) ([]byte, error) {
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := false
+	var encrypt func(path string, value interface{}) (interface{}, error)
+	encrypt = func(path string, value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for name, field := range v {
+				fieldPath := path + "." + name
+				if s, ok := field.(string); ok && s != "" && isSensitiveField(fieldPath, name, fields) {
+					ciphertext, err := seal(aead, []byte(s), []byte(fieldPath))
+					if err != nil {
+						return nil, err
+					}
+					v[name] = encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext)
+					encrypted = true
+					continue
+				}
+				if v[name], err = encrypt(fieldPath, field); err != nil {
+					return nil, err
+				}
+			}
+		case []interface{}:
+			for i := range v {
+				if v[i], err = encrypt(fmt.Sprintf("%s[%d]", path, i), v[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return value, nil
+	}
+	if config["Driver"], err = encrypt("Driver", config["Driver"]); err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return data, nil
+	}
+
+	wrapped, err := w.Wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	config["Encryption"] = encryptionEnvelope{
+		KeyID:   w.ID(),
+		DataKey: base64.StdEncoding.EncodeToString(wrapped),
+	}
+	return json.MarshalIndent(config, "", "    ")
+}
+
+// decryptConfig decrypts the values of a config encrypted by
+// encryptConfig.
+func decryptConfig(data []byte, w KeyWrapper) ([]byte, error) {
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := encryptionEnvelope{}
+	raw, _ := json.Marshal(config["Encryption"])
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid encryption of the machine config: %s", err)
+	}
+	if w == nil {
+		return nil, errNoEncryptionKey
+	}
+	if envelope.KeyID != w.ID() {
+		return nil, fmt.Errorf("the machine config is encrypted with key %s, not %s", envelope.KeyID, w.ID())
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(envelope.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key of the machine config: %s", err)
+	}
+	dataKey, err := w.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting the data key of the machine config: %s", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var decrypt func(path string, value interface{}) (interface{}, error)
+	decrypt = func(path string, value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case string:
+			if !strings.HasPrefix(v, encryptedPrefix) {
+				return v, nil
+			}
+			ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, encryptedPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid encrypted value %s: %s", path, err)
+			}
+			plaintext, err := open(aead, ciphertext, []byte(path))
+			if err != nil {
+				return nil, fmt.Errorf("error decrypting %s: %s", path, err)
+			}
+			return string(plaintext), nil
+		case map[string]interface{}:
+			for name, field := range v {
+				if v[name], err = decrypt(path+"."+name, field); err != nil {
+					return nil, err
+				}
+			}
+		case []interface{}:
+			for i := range v {
+				if v[i], err = decrypt(fmt.Sprintf("%s[%d]", path, i), v[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return value, nil
+	}
+	if config["Driver"], err = decrypt("Driver", config["Driver"]); err != nil {
+		return nil, err
+	}
+
+	delete(config, "Encryption")
+	return json.MarshalIndent(config, "", "    ")
+}
@@ -0,0 +1,193 @@
+package persist
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/drivers/amazonec2"
+	"github.com/rancher/machine/drivers/none"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/hosttest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `{
+    "ConfigVersion": 3,
+    "Driver": {
+        "MachineName": "test",
+        "SSHKeyPath": "/store/machines/test/id_rsa",
+        "SecretKey": "s3cr3t",
+        "SessionToken": "",
+        "Nested": {"Password": "hunter2"},
+        "Port": 22
+    },
+    "DriverName": "amazonec2",
+    "Name": "test"
+}`
+
+func testKeyWrapper(t *testing.T, b byte) KeyWrapper {
+	w, err := NewLocalKeyWrapper([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))))
+	assert.NoError(t, err)
+	return w
+}
+
+func TestIsSensitiveField(t *testing.T) {
+	for name, sensitive := range map[string]bool{
+		"SecretKey":      true,
+		"AccessKey":      true,
+		"Password":       true,
+		"AccessToken":    true,
+		"ClientSecret":   true,
+		"APIKey":         true,
+		"PrivateKeyPath": false,
+		"SSHKeyPath":     false,
+		"SSHKey":         false,
+		"MachineName":    false,
+		"KeyPairName":    false,
+	} {
+		assert.Equal(t, sensitive, isSensitiveField("Driver."+name, name, nil), name)
+	}
+}
+
+func TestEncryptConfig(t *testing.T) {
+	w := testKeyWrapper(t, 'a')
+
+	encrypted, err := encryptConfig([]byte(testConfig), w, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "s3cr3t")
+	assert.NotContains(t, string(encrypted), "hunter2")
+	assert.Contains(t, string(encrypted), "/store/machines/test/id_rsa")
+	assert.True(t, isEncryptedConfig(encrypted))
+
+	decrypted, err := decryptConfig(encrypted, w)
+	assert.NoError(t, err)
+	assert.JSONEq(t, testConfig, string(decrypted))
+}
+
+func TestEncryptConfigSecretTags(t *testing.T) {
+	w := testKeyWrapper(t, 'a')
+	config := `{"Driver": {"MachineName": "test", "SecretKey": "s3cr3t", "HttpTokens": "required", "UserData": "#cloud-config"}, "Name": "test"}`
+
+	// HttpTokens is tagged secret:"false"
+	fields := drivers.SecretFields(amazonec2.NewDriver("test", "/store"))
+	assert.Contains(t, fields, "SecretKey")
+	assert.NotContains(t, fields, "HttpTokens")
+
+	encrypted, err := encryptConfig([]byte(config), w, fields)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "s3cr3t")
+	assert.Contains(t, string(encrypted), `"HttpTokens": "required"`)
+
+	// the fields of the config are secrets only if the driver tells so
+	encrypted, err = encryptConfig([]byte(config), w, []string{"UserData"})
+	assert.NoError(t, err)
+	assert.Contains(t, string(encrypted), "s3cr3t")
+	assert.NotContains(t, string(encrypted), "#cloud-config")
+	assert.False(t, hasPlaintextSecrets(encrypted, []string{"UserData"}))
+	assert.True(t, hasPlaintextSecrets(encrypted, nil))
+}
+
+func TestEncryptConfigWithoutSecrets(t *testing.T) {
+	config := `{"Driver": {"MachineName": "test"}, "Name": "test"}`
+
+	encrypted, err := encryptConfig([]byte(config), testKeyWrapper(t, 'a'), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, config, string(encrypted))
+}
+
+func TestDecryptConfigErrors(t *testing.T) {
+	encrypted, err := encryptConfig([]byte(testConfig), testKeyWrapper(t, 'a'), nil)
+	assert.NoError(t, err)
+
+	_, err = decryptConfig(encrypted, nil)
+	assert.Equal(t, errNoEncryptionKey, err)
+
+	_, err = decryptConfig(encrypted, testKeyWrapper(t, 'b'))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is encrypted with key local:")
+
+	// a value moved to another field doesn't decrypt
+	config := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(encrypted, &config))
+	driver := config["Driver"].(map[string]interface{})
+	driver["MachineName"] = driver["SecretKey"]
+	tampered, err := json.Marshal(config)
+	assert.NoError(t, err)
+
+	_, err = decryptConfig(tampered, testKeyWrapper(t, 'a'))
+	assert.EqualError(t, err, "error decrypting Driver.MachineName: cipher: message authentication failed")
+}
+
+func TestNewLocalKeyWrapperRejectsShortKeys(t *testing.T) {
+	_, err := NewLocalKeyWrapper([]byte("short"))
+
+	assert.Error(t, err)
+}
+
+func TestFilestoreEncryption(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+	store.Encryption = testKeyWrapper(t, 'a')
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	h.Driver = &host.RawDataDriver{
+		Driver: none.NewDriver(h.Name, store.Path),
+		Data:   []byte(`{"MachineName":"` + h.Name + `","URL":"tcp://1.2.3.4:2376","Password":"hunter2"}`),
+	}
+	assert.NoError(t, store.Save(h))
+
+	data, err := ioutil.ReadFile(filepath.Join(store.GetMachinesDir(), h.Name, "config.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	loaded, err := store.Load(h.Name)
+	assert.NoError(t, err)
+	assert.Contains(t, string(loaded.RawDriver), "hunter2")
+
+	store.Encryption = nil
+	_, err = store.Load(h.Name)
+	assert.Error(t, err)
+}
+
+func TestFilestoreEncryptionBackup(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	h.Driver = &host.RawDataDriver{
+		Driver: none.NewDriver(h.Name, store.Path),
+		Data:   []byte(`{"MachineName":"` + h.Name + `","URL":"tcp://1.2.3.4:2376","Password":"hunter2"}`),
+	}
+	assert.NoError(t, store.Save(h))
+	assert.NoError(t, store.Save(h))
+
+	configPath := filepath.Join(store.GetMachinesDir(), h.Name, "config.json")
+	backup, err := ioutil.ReadFile(configPath + ".bak")
+	assert.NoError(t, err)
+	assert.Contains(t, string(backup), "hunter2")
+
+	// as encrypt-store does
+	store.Encryption = testKeyWrapper(t, 'a')
+	loaded, err := store.Load(h.Name)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(loaded))
+
+	for _, file := range []string{configPath, configPath + ".bak"} {
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "hunter2", file)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnerror"
 )
 
@@ -16,6 +18,10 @@ type Filestore struct {
 	Path             string
 	CaCertPath       string
 	CaPrivateKeyPath string
+
+	// Encryption, if not nil, encrypts the secrets of the drivers in the
+	// configs saved. Encrypted configs are decrypted on load.
+	Encryption KeyWrapper
 }
 
 func NewFilestore(path, caCertPath, caPrivateKeyPath string) *Filestore {
@@ -30,31 +36,90 @@ func (s Filestore) GetMachinesDir() string {
 	return filepath.Join(s.Path, "machines")
 }
 
-func (s Filestore) saveToFile(data []byte, file string) error {
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		return ioutil.WriteFile(file, data, 0600)
-	}
-
-	tmpfi, err := ioutil.TempFile(filepath.Dir(file), "config.json.tmp")
+// writeFileAtomic replaces file with data so that a crash or a full disk
+// leaves either the previous or the new content, never a truncated file: the
+// data is written and fsynced to a temporary file of the same directory,
+// which is then renamed over file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(file)
+	tmpfi, err := ioutil.TempFile(dir, filepath.Base(file)+".tmp")
 	if err != nil {
 		return err
 	}
 	defer os.Remove(tmpfi.Name())
 
-	if err = ioutil.WriteFile(tmpfi.Name(), data, 0600); err != nil {
+	if _, err := tmpfi.Write(data); err != nil {
+		tmpfi.Close()
 		return err
 	}
-
-	if err = tmpfi.Close(); err != nil {
+	if err := tmpfi.Sync(); err != nil {
+		tmpfi.Close()
+		return err
+	}
+	if err := tmpfi.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpfi.Name(), perm); err != nil {
 		return err
 	}
 
-	if err = os.Remove(file); err != nil {
+	if err := os.Rename(tmpfi.Name(), file); err != nil {
 		return err
 	}
 
-	err = os.Rename(tmpfi.Name(), file)
-	return err
+	// Persist the rename. Directories can't be synced on Windows, where the
+	// rename is durable anyway.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// saveToFile saves a config, keeping the previous one as a .bak copy to
+// recover from if the config gets corrupted. A previous config holding
+// plaintext secrets which data encrypts or moves to the keyring is not kept,
+// the copy being data instead. fields are the secret fields of the driver,
+// as returned by drivers.SecretFields.
+func (s Filestore) saveToFile(data []byte, file string, fields []string) error {
+	if previous, err := ioutil.ReadFile(file); err == nil && json.Valid(previous) {
+		backup := previous
+		if hasPlaintextSecrets(previous, fields) && !hasPlaintextSecrets(data, fields) {
+			backup = data
+		}
+		if err := writeFileAtomic(file+".bak", backup, 0600); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(file, data, 0600)
+}
+
+// readConfig reads the config of a machine, falling back to its .bak copy
+// if it is missing or corrupt, in which case the config is restored.
+func (s Filestore) readConfig(name string) ([]byte, error) {
+	file := filepath.Join(s.GetMachinesDir(), name, "config.json")
+	data, err := ioutil.ReadFile(file)
+	if err == nil && json.Valid(data) {
+		return data, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	backup, bakErr := ioutil.ReadFile(file + ".bak")
+	if bakErr != nil || !json.Valid(backup) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error loading the config of %s: %s is corrupt and has no valid backup", name, file)
+	}
+
+	log.Warnf("The config of %s is missing or corrupt, restoring it from %s.bak", name, file)
+	if err := writeFileAtomic(file, backup, 0600); err != nil {
+		return nil, fmt.Errorf("Error restoring the config of %s: %s", name, err)
+	}
+	return backup, nil
 }
 
 func (s Filestore) Save(host *host.Host) error {
@@ -63,6 +128,22 @@ func (s Filestore) Save(host *host.Host) error {
 		return err
 	}
 
+	// the fields are told by their name when the driver is unknown
+	var fields []string
+	if host.Driver != nil {
+		fields = drivers.SecretFields(host.Driver)
+	}
+
+	if data, err = moveSecretsToKeyring(host.Name, data, fields); err != nil {
+		return err
+	}
+
+	if s.Encryption != nil {
+		if data, err = encryptConfig(data, s.Encryption, fields); err != nil {
+			return fmt.Errorf("Error encrypting the config of %s: %s", host.Name, err)
+		}
+	}
+
 	hostPath := filepath.Join(s.GetMachinesDir(), host.Name)
 
 	// Ensure that the directory we want to save to exists.
@@ -70,11 +151,14 @@ func (s Filestore) Save(host *host.Host) error {
 		return err
 	}
 
-	return s.saveToFile(data, filepath.Join(hostPath, "config.json"))
+	return s.saveToFile(data, filepath.Join(hostPath, "config.json"), fields)
 }
 
 func (s Filestore) Remove(name string) error {
 	hostPath := filepath.Join(s.GetMachinesDir(), name)
+	if data, err := ioutil.ReadFile(filepath.Join(hostPath, "config.json")); err == nil {
+		removeSecretsFromKeyring(name, data)
+	}
 	return os.RemoveAll(hostPath)
 }
 
@@ -108,16 +192,26 @@ func (s Filestore) Exists(name string) (bool, error) {
 }
 
 func (s Filestore) loadConfig(h *host.Host) error {
-	data, err := ioutil.ReadFile(filepath.Join(s.GetMachinesDir(), h.Name, "config.json"))
+	data, err := s.readConfig(h.Name)
 	if err != nil {
 		return err
 	}
 
+	config := data
+	if isEncryptedConfig(data) {
+		if config, err = decryptConfig(data, s.Encryption); err != nil {
+			return fmt.Errorf("Error decrypting the config of %s: %s", h.Name, err)
+		}
+	}
+	if config, err = loadSecretsFromKeyring(h.Name, config); err != nil {
+		return err
+	}
+
 	// Remember the machine name so we don't have to pass it through each
 	// struct in the migration.
 	name := h.Name
 
-	migratedHost, migrationPerformed, err := host.MigrateHost(h, data)
+	migratedHost, migrationPerformed, err := host.MigrateHost(h, config)
 	if err != nil {
 		return fmt.Errorf("Error getting migrated host: %s", err)
 	}
@@ -127,11 +221,8 @@ func (s Filestore) loadConfig(h *host.Host) error {
 	h.Name = name
 
 	// If we end up performing a migration, we should save afterwards so we don't have to do it again on subsequent invocations.
+	// The config before the migration is kept as the backup.
 	if migrationPerformed {
-		if err := s.saveToFile(data, filepath.Join(s.GetMachinesDir(), h.Name, "config.json.bak")); err != nil {
-			return fmt.Errorf("Error attempting to save backup after migration: %s", err)
-		}
-
 		if err := s.Save(h); err != nil {
 			return fmt.Errorf("Error saving config after migration was performed: %s", err)
 		}
@@ -247,3 +247,100 @@ func TestStoreLoad(t *testing.T) {
 		t.Fatalf("GetURL is not %q, got %q", expectedURL, actualURL)
 	}
 }
+
+func TestStoreSaveKeepsBackup(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+
+	h, err := hosttest.GetDefaultTestHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save(h); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(store.GetMachinesDir(), h.Name, "config.json")
+	if _, err := os.Stat(configPath + ".bak"); !os.IsNotExist(err) {
+		t.Fatal("Expected no backup after the first save")
+	}
+
+	previous, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.HostOptions.MachineOS = "windows"
+	if err := store.Save(h); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := ioutil.ReadFile(configPath + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != string(previous) {
+		t.Fatal("Expected the backup to hold the previous config")
+	}
+
+	files, err := filepath.Glob(configPath + ".tmp*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Temporary files left behind: %v", files)
+	}
+}
+
+func TestStoreLoadRecoversFromBackup(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+
+	h, err := hosttest.GetDefaultTestHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(h); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(store.GetMachinesDir(), h.Name, "config.json")
+	for _, corrupt := range [][]byte{{}, []byte(`{"ConfigVersion": 3, "Driver": {`)} {
+		if err := ioutil.WriteFile(configPath, corrupt, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := store.Load(h.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.DriverName != h.DriverName {
+			t.Fatalf("Expected driver %q, got %q", h.DriverName, loaded.DriverName)
+		}
+
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(data) {
+			t.Fatal("Expected the config to be restored from the backup")
+		}
+	}
+
+	if err := os.Remove(configPath + ".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(h.Name); err == nil {
+		t.Fatal("Expected an error loading a corrupt config without backup")
+	}
+}
@@ -0,0 +1,225 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/zalando/go-keyring"
+)
+
+// The OS keyring (macOS Keychain, Windows Credential Manager or the Secret
+// Service) holds the credentials of an account of a driver, as a JSON object
+// of create flags, e.g. {"amazonec2-access-key": "...", ...}, under the
+// service keyringService(driver) and the account name. The secrets of the
+// machines created with the account are not kept in config.json: those
+// holding the credentials of the account are references to its flags,
+// resolved on load for the rotations of the credentials to reach the
+// machines, and the others are kept under the same service, under
+// account/machine.
+
+// keyringService returns the keyring service holding the credentials of a
+// driver.
+func keyringService(driverName string) string {
+	return "rancher-machine:" + driverName
+}
+
+// KeyringCredentials returns the create flags of account in the keyring of
+// the OS for the driver.
+func KeyringCredentials(driverName, account string) (map[string]string, error) {
+	secret, err := keyring.Get(keyringService(driverName), account)
+	if err == keyring.ErrNotFound {
+		return nil, fmt.Errorf("no credentials for account %s of driver %s in the keyring, expected under service %s", account, driverName, keyringService(driverName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the keyring: %s", err)
+	}
+
+	credentials := map[string]string{}
+	if err := json.Unmarshal([]byte(secret), &credentials); err != nil {
+		return nil, fmt.Errorf("invalid credentials for account %s of driver %s in the keyring, expected a JSON object of flags: %s", account, driverName, err)
+	}
+	return credentials, nil
+}
+
+// keyringConfig is the part of a config locating its secrets in the keyring.
+type keyringConfig struct {
+	DriverName  string
+	HostOptions *struct {
+		KeyringAccount string
+	}
+	// KeyringReferences are the flags of the account holding the values of
+	// the sensitive fields, by path
+	KeyringReferences map[string]string `json:",omitempty"`
+}
+
+// keyringUser returns the keyring user holding the secrets of a config, or
+// "" if they are in the config.
+func (c keyringConfig) keyringUser(name string) string {
+	if c.HostOptions == nil || c.HostOptions.KeyringAccount == "" {
+		return ""
+	}
+	return c.HostOptions.KeyringAccount + "/" + name
+}
+
+// moveSecretsToKeyring removes the sensitive fields of the driver from a
+// config whose machine was created with a keyring account. The fields
+// holding a credential of the account are referenced to its flag, and the
+// others saved in the keyring. fields are the secret fields of the driver,
+// as returned by drivers.SecretFields.
+func moveSecretsToKeyring(name string, data []byte, fields []string) ([]byte, error) {
+	kc := keyringConfig{}
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return nil, err
+	}
+	user := kc.keyringUser(name)
+	if user == "" {
+		return data, nil
+	}
+
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	var strip func(path string, value interface{})
+	strip = func(path string, value interface{}) {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for field, v := range values {
+			fieldPath := path + "." + field
+			if s, ok := v.(string); ok && s != "" && isSensitiveField(fieldPath, field, fields) {
+				secrets[fieldPath] = s
+				delete(values, field)
+				continue
+			}
+			strip(fieldPath, v)
+		}
+	}
+	strip("Driver", config["Driver"])
+
+	credentials, err := KeyringCredentials(kc.DriverName, kc.HostOptions.KeyringAccount)
+	if err != nil {
+		log.Debugf("Keeping the secrets of %s in the keyring: %s", name, err)
+	}
+	references := map[string]string{}
+	for path, value := range secrets {
+		if flag := credentialFlag(credentials, value); flag != "" {
+			references[path] = flag
+			delete(secrets, path)
+		}
+	}
+	delete(config, "KeyringReferences")
+	if len(references) > 0 {
+		config["KeyringReferences"] = references
+	}
+
+	if len(secrets) == 0 {
+		if err := keyring.Delete(keyringService(kc.DriverName), user); err != nil && err != keyring.ErrNotFound {
+			return nil, fmt.Errorf("error removing the secrets of %s from the keyring: %s", name, err)
+		}
+		return json.MarshalIndent(config, "", "    ")
+	}
+
+	secret, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.Set(keyringService(kc.DriverName), user, string(secret)); err != nil {
+		return nil, fmt.Errorf("error saving the secrets of %s in the keyring: %s", name, err)
+	}
+	return json.MarshalIndent(config, "", "    ")
+}
+
+// credentialFlag returns the first flag of credentials, in order, whose
+// value is value, or "" if none is.
+func credentialFlag(credentials map[string]string, value string) string {
+	flags := make([]string, 0, len(credentials))
+	for flag := range credentials {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		if credentials[flag] == value {
+			return flag
+		}
+	}
+	return ""
+}
+
+// loadSecretsFromKeyring restores the secrets moved to the keyring by
+// moveSecretsToKeyring, with the current credentials of the account.
+func loadSecretsFromKeyring(name string, data []byte) ([]byte, error) {
+	kc := keyringConfig{}
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return nil, err
+	}
+	user := kc.keyringUser(name)
+	if user == "" {
+		return data, nil
+	}
+
+	// the machines whose secrets are all references have no entry
+	secrets := map[string]string{}
+	secret, err := keyring.Get(keyringService(kc.DriverName), user)
+	if err != nil && (err != keyring.ErrNotFound || len(kc.KeyringReferences) == 0) {
+		return nil, fmt.Errorf("error reading the secrets of %s from the keyring: %s", name, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal([]byte(secret), &secrets); err != nil {
+			return nil, fmt.Errorf("invalid secrets of %s in the keyring: %s", name, err)
+		}
+	}
+
+	if len(kc.KeyringReferences) > 0 {
+		credentials, err := KeyringCredentials(kc.DriverName, kc.HostOptions.KeyringAccount)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the secrets of %s: %s", name, err)
+		}
+		for path, flag := range kc.KeyringReferences {
+			value, ok := credentials[flag]
+			if !ok {
+				return nil, fmt.Errorf("error reading the secrets of %s: account %s of driver %s has no %s", name, kc.HostOptions.KeyringAccount, kc.DriverName, flag)
+			}
+			secrets[path] = value
+		}
+	}
+
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	delete(config, "KeyringReferences")
+	for path, value := range secrets {
+		fields := config
+		parts := strings.Split(path, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := fields[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				fields[part] = next
+			}
+			fields = next
+		}
+		fields[parts[len(parts)-1]] = value
+	}
+	return json.MarshalIndent(config, "", "    ")
+}
+
+// removeSecretsFromKeyring deletes the secrets of a config from the keyring.
+func removeSecretsFromKeyring(name string, data []byte) {
+	kc := keyringConfig{}
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return
+	}
+	if user := kc.keyringUser(name); user != "" {
+		if err := keyring.Delete(keyringService(kc.DriverName), user); err != nil && err != keyring.ErrNotFound {
+			log.Warnf("Error removing the secrets of %s from the keyring: %s", name, err)
+		}
+	}
+}
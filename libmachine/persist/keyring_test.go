@@ -0,0 +1,102 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/none"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/hosttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyringCredentials(t *testing.T) {
+	keyring.MockInit()
+	assert.NoError(t, keyring.Set("rancher-machine:amazonec2", "prod", `{"amazonec2-secret-key": "s3cr3t"}`))
+
+	credentials, err := KeyringCredentials("amazonec2", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"amazonec2-secret-key": "s3cr3t"}, credentials)
+
+	_, err = KeyringCredentials("amazonec2", "dev")
+	assert.EqualError(t, err, "no credentials for account dev of driver amazonec2 in the keyring, expected under service rancher-machine:amazonec2")
+
+	assert.NoError(t, keyring.Set("rancher-machine:amazonec2", "invalid", "s3cr3t"))
+	_, err = KeyringCredentials("amazonec2", "invalid")
+	assert.Error(t, err)
+}
+
+func TestFilestoreKeyring(t *testing.T) {
+	keyring.MockInit()
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	h.HostOptions.KeyringAccount = "prod"
+	h.Driver = &host.RawDataDriver{
+		Driver: none.NewDriver(h.Name, store.Path),
+		Data:   []byte(`{"MachineName":"` + h.Name + `","URL":"tcp://1.2.3.4:2376","Password":"hunter2"}`),
+	}
+	assert.NoError(t, store.Save(h))
+
+	data, err := ioutil.ReadFile(filepath.Join(store.GetMachinesDir(), h.Name, "config.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	secret, err := keyring.Get("rancher-machine:"+h.DriverName, "prod/"+h.Name)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Driver.Password": "hunter2"}`, secret)
+
+	loaded, err := store.Load(h.Name)
+	assert.NoError(t, err)
+	assert.Contains(t, string(loaded.RawDriver), "hunter2")
+
+	assert.NoError(t, store.Remove(h.Name))
+	_, err = keyring.Get("rancher-machine:"+h.DriverName, "prod/"+h.Name)
+	assert.Equal(t, keyring.ErrNotFound, err)
+}
+
+func TestFilestoreKeyringReferences(t *testing.T) {
+	keyring.MockInit()
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	assert.NoError(t, keyring.Set("rancher-machine:"+h.DriverName, "prod", `{"none-password": "hunter2"}`))
+	h.HostOptions.KeyringAccount = "prod"
+	h.Driver = &host.RawDataDriver{
+		Driver: none.NewDriver(h.Name, store.Path),
+		Data:   []byte(`{"MachineName":"` + h.Name + `","URL":"tcp://1.2.3.4:2376","Password":"hunter2","Token":"t0k3n"}`),
+	}
+	assert.NoError(t, store.Save(h))
+
+	data, err := ioutil.ReadFile(filepath.Join(store.GetMachinesDir(), h.Name, "config.json"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.Contains(t, string(data), `"Driver.Password": "none-password"`)
+
+	// only the secrets of the machine are kept under its own entry
+	secret, err := keyring.Get("rancher-machine:"+h.DriverName, "prod/"+h.Name)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Driver.Token": "t0k3n"}`, secret)
+
+	// the machine gets the rotated credentials of the account
+	assert.NoError(t, keyring.Set("rancher-machine:"+h.DriverName, "prod", `{"none-password": "rotated"}`))
+	loaded, err := store.Load(h.Name)
+	assert.NoError(t, err)
+	assert.Contains(t, string(loaded.RawDriver), `"Password": "rotated"`)
+	assert.Contains(t, string(loaded.RawDriver), `"Token": "t0k3n"`)
+
+	assert.NoError(t, keyring.Set("rancher-machine:"+h.DriverName, "prod", `{}`))
+	_, err = store.Load(h.Name)
+	assert.EqualError(t, err, "error reading the secrets of "+h.Name+": account prod of driver "+h.DriverName+" has no none-password")
+}
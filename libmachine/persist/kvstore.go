@@ -0,0 +1,228 @@
+package persist
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	kvTimeout = 30 * time.Second
+	// etcdLockTTL is the time in seconds after which the lock of a process
+	// which died is released.
+	etcdLockTTL = 30
+)
+
+// etcdBackend keeps the machines in etcd, locking them with its
+// concurrency API.
+type etcdBackend struct {
+	Client *clientv3.Client
+}
+
+// NewEtcdStore returns a store keeping the machines of store in etcd, given
+// by rawURL in the form etcd://[user:password@]host:port[,host:port]/prefix.
+// The query parameters ca-cert, cert and key enable TLS.
+func NewEtcdStore(store Store, rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", rawURL, err)
+	}
+	if u.Scheme != "etcd" || u.Host == "" {
+		return nil, fmt.Errorf("invalid store URL %q: expected etcd://host:port/prefix", rawURL)
+	}
+
+	query := u.Query()
+	scheme := "http"
+	var tlsConfig *tls.Config
+	if caCert, cert, key := query.Get("ca-cert"), query.Get("cert"), query.Get("key"); caCert != "" || cert != "" {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile: caCert,
+			CertFile:      cert,
+			KeyFile:       key,
+		}
+		if tlsConfig, err = tlsInfo.ClientConfig(); err != nil {
+			return nil, fmt.Errorf("error configuring TLS for etcd: %s", err)
+		}
+		scheme = "https"
+	}
+
+	endpoints := []string{}
+	for _, host := range strings.Split(u.Host, ",") {
+		endpoints = append(endpoints, scheme+"://"+host)
+	}
+	config := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+		TLS:         tlsConfig,
+	}
+	if u.User != nil {
+		config.Username = u.User.Username()
+		config.Password, _ = u.User.Password()
+	}
+
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to etcd: %s", err)
+	}
+
+	return &remoteStore{
+		Store:    store,
+		Backend:  &etcdBackend{Client: client},
+		Prefix:   strings.Trim(u.Path, "/"),
+		location: "etcd",
+	}, nil
+}
+
+func (b *etcdBackend) List(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+
+	resp, err := b.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+func (b *etcdBackend) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+
+	resp, err := b.Client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (b *etcdBackend) Put(key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+
+	_, err := b.Client.Put(ctx, key, string(value))
+	return err
+}
+
+func (b *etcdBackend) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+
+	_, err := b.Client.Delete(ctx, key)
+	return err
+}
+
+// Lock acquires a mutex held by a lease, which expires if the process dies.
+func (b *etcdBackend) Lock(ctx context.Context, key string) (func() error, error) {
+	session, err := concurrency.NewSession(b.Client, concurrency.WithTTL(etcdLockTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	mutex := concurrency.NewMutex(session, key)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer session.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+		defer cancel()
+		return mutex.Unlock(ctx)
+	}, nil
+}
+
+// consulBackend keeps the machines in the KV store of Consul, locking them
+// with sessions.
+type consulBackend struct {
+	Client *consul.Client
+}
+
+// NewConsulStore returns a store keeping the machines of store in the KV
+// store of Consul, given by rawURL in the form consul://host:port/prefix.
+// The address, token and TLS settings are otherwise read from the CONSUL_*
+// environment variables.
+func NewConsulStore(store Store, rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", rawURL, err)
+	}
+	if u.Scheme != "consul" {
+		return nil, fmt.Errorf("invalid store URL %q: expected consul://host:port/prefix", rawURL)
+	}
+
+	config := consul.DefaultConfig()
+	if u.Host != "" {
+		config.Address = u.Host
+	}
+	client, err := consul.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring the Consul client: %s", err)
+	}
+
+	return &remoteStore{
+		Store:    store,
+		Backend:  &consulBackend{Client: client},
+		Prefix:   strings.Trim(u.Path, "/"),
+		location: "consul",
+	}, nil
+}
+
+func (b *consulBackend) List(prefix string) ([]string, error) {
+	keys, _, err := b.Client.KV().Keys(prefix, "", nil)
+	return keys, err
+}
+
+func (b *consulBackend) Get(key string) ([]byte, error) {
+	pair, _, err := b.Client.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return pair.Value, nil
+}
+
+func (b *consulBackend) Put(key string, value []byte) error {
+	_, err := b.Client.KV().Put(&consul.KVPair{Key: key, Value: value}, nil)
+	return err
+}
+
+func (b *consulBackend) Delete(key string) error {
+	_, err := b.Client.KV().Delete(key, nil)
+	return err
+}
+
+// Lock acquires a lock held by a session, which is invalidated if the
+// process dies.
+func (b *consulBackend) Lock(ctx context.Context, key string) (func() error, error) {
+	lock, err := b.Client.LockKey(key)
+	if err != nil {
+		return nil, err
+	}
+	lost, err := lock.Lock(ctx.Done())
+	if err != nil {
+		return nil, err
+	}
+	if lost == nil {
+		return nil, ctx.Err()
+	}
+	return lock.Unlock, nil
+}
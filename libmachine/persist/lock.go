@@ -0,0 +1,65 @@
+package persist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnerror"
+)
+
+// lockRetryDelay is the interval at which a lock file held by another
+// process is polled.
+const lockRetryDelay = 100 * time.Millisecond
+
+// HostLocker is implemented by the stores which lock hosts across processes,
+// so that several of them can manage the same machines.
+type HostLocker interface {
+	// LockHost blocks until the lock of the host is acquired or ctx is
+	// done, and returns the function releasing it
+	LockHost(ctx context.Context, name string) (func() error, error)
+}
+
+// LockHost locks the host name if s supports it. It returns
+// mcnerror.ErrHostBusy if ctx is done before the lock is acquired.
+func LockHost(ctx context.Context, s interface{}, name string) (func() error, error) {
+	locker, ok := s.(HostLocker)
+	if !ok {
+		return func() error { return nil }, nil
+	}
+
+	unlock, err := locker.LockHost(ctx, name)
+	if err != nil && ctx.Err() != nil {
+		return nil, mcnerror.ErrHostBusy{Name: name}
+	}
+	return unlock, err
+}
+
+// lockPath returns the lock file of a machine, kept out of the machine
+// directory so that removing the machine doesn't release it.
+func (s Filestore) lockPath(name string) string {
+	return filepath.Join(s.Path, "locks", name+".lock")
+}
+
+// LockHost locks the host with an advisory lock on a file, released by the
+// OS if the process dies.
+func (s Filestore) LockHost(ctx context.Context, name string) (func() error, error) {
+	path := s.lockPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Locking %s", path)
+	lock := flock.New(path)
+	locked, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ctx.Err()
+	}
+	return lock.Unlock, nil
+}
@@ -0,0 +1,52 @@
+package persist
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/machine/libmachine/mcnerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilestoreLockHost(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+
+	unlock, err := LockHost(context.Background(), store, "host")
+	assert.NoError(t, err)
+
+	// the lock is held per open file, even within a process
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = LockHost(ctx, store, "host")
+	assert.Equal(t, mcnerror.ErrHostBusy{Name: "host"}, err)
+
+	other, err := LockHost(context.Background(), store, "other")
+	assert.NoError(t, err)
+	assert.NoError(t, other())
+
+	assert.NoError(t, unlock())
+
+	unlock, err = LockHost(context.Background(), store, "host")
+	assert.NoError(t, err)
+	assert.NoError(t, unlock())
+}
+
+func TestFilestoreLockHostIsNotAMachine(t *testing.T) {
+	defer cleanup()
+
+	store := getTestStore()
+	defer os.RemoveAll(store.Path)
+
+	unlock, err := LockHost(context.Background(), store, "host")
+	assert.NoError(t, err)
+	defer unlock()
+
+	names, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
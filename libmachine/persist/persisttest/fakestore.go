@@ -55,11 +55,17 @@ func (fs *FakeStore) Remove(name string) error {
 }
 
 func (fs *FakeStore) Save(host *host.Host) error {
-	if fs.SaveErr == nil {
-		fs.Hosts = append(fs.Hosts, host)
-		return nil
+	if fs.SaveErr != nil {
+		return fs.SaveErr
 	}
-	return fs.SaveErr
+	for i, h := range fs.Hosts {
+		if h.Name == host.Name {
+			fs.Hosts[i] = host
+			return nil
+		}
+	}
+	fs.Hosts = append(fs.Hosts, host)
+	return nil
 }
 
 func (fs *FakeStore) GetMachinesDir() string {
@@ -0,0 +1,267 @@
+package persist
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnerror"
+)
+
+// Backend keeps the files of the machine directories in a remote service,
+// by key.
+type Backend interface {
+	// List returns the keys starting with prefix
+	List(prefix string) ([]string, error)
+
+	// Get returns the value of key
+	Get(key string) ([]byte, error)
+
+	// Put sets the value of key
+	Put(key string, value []byte) error
+
+	// Delete deletes key
+	Delete(key string) error
+}
+
+// Locker is implemented by the backends which lock keys across processes.
+type Locker interface {
+	// Lock blocks until the lock of key is acquired or ctx is done, and
+	// returns the function releasing it
+	Lock(ctx context.Context, key string) (func() error, error)
+}
+
+// NewRemoteStore returns a store keeping the machines of store in the
+// backend given by rawURL: an S3-compatible bucket, etcd or Consul.
+func NewRemoteStore(store Store, rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return NewS3Store(store, rawURL)
+	case "etcd":
+		return NewEtcdStore(store, rawURL)
+	case "consul":
+		return NewConsulStore(store, rawURL)
+	}
+	return nil, fmt.Errorf("invalid store URL %q: the scheme must be s3, etcd or consul", rawURL)
+}
+
+// remoteStore keeps the machine directories of a local store in a backend,
+// so that the machines can be managed from several hosts. The directory of a
+// machine is downloaded when the machine is loaded and uploaded when it is
+// saved.
+type remoteStore struct {
+	Store
+	Backend Backend
+	Prefix  string
+	// location describes the backend in the messages
+	location string
+}
+
+// machinePrefix returns the prefix of the keys of a machine.
+func (s *remoteStore) machinePrefix(name string) string {
+	return path.Join(s.Prefix, name) + "/"
+}
+
+// lockKey returns the key locking a machine, hidden from List.
+func (s *remoteStore) lockKey(name string) string {
+	return path.Join(s.Prefix, ".locks", name)
+}
+
+func (s *remoteStore) Exists(name string) (bool, error) {
+	keys, err := s.Backend.List(s.machinePrefix(name))
+	if err != nil {
+		return false, fmt.Errorf("error looking up machine %s in %s: %s", name, s.location, err)
+	}
+	return len(keys) > 0, nil
+}
+
+func (s *remoteStore) List() ([]string, error) {
+	prefix := ""
+	if s.Prefix != "" {
+		prefix = s.Prefix + "/"
+	}
+
+	keys, err := s.Backend.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the machines in %s: %s", s.location, err)
+	}
+
+	hostNames := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, prefix), "/", 2)
+		if len(parts) < 2 || parts[0] == "" || strings.HasPrefix(parts[0], ".") || seen[parts[0]] {
+			continue
+		}
+		seen[parts[0]] = true
+		hostNames = append(hostNames, parts[0])
+	}
+	return hostNames, nil
+}
+
+// Load downloads the directory of the machine before loading it from the
+// local store.
+func (s *remoteStore) Load(name string) (*host.Host, error) {
+	prefix := s.machinePrefix(name)
+	keys, err := s.Backend.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up machine %s in %s: %s", name, s.location, err)
+	}
+	if len(keys) == 0 {
+		return nil, mcnerror.ErrHostDoesNotExist{
+			Name: name,
+		}
+	}
+
+	hostPath := filepath.Join(s.GetMachinesDir(), name)
+	for _, key := range keys {
+		relPath := strings.TrimPrefix(key, prefix)
+		if relPath == "" || strings.HasSuffix(relPath, "/") {
+			continue
+		}
+		file := filepath.Join(hostPath, filepath.FromSlash(relPath))
+		if !strings.HasPrefix(file, hostPath+string(filepath.Separator)) {
+			return nil, fmt.Errorf("invalid key %s in %s", key, s.location)
+		}
+		if err := s.download(key, file); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Store.Load(name)
+}
+
+func (s *remoteStore) download(key, file string) error {
+	log.Debugf("Downloading %s from %s", key, s.location)
+	data, err := s.Backend.Get(key)
+	if err != nil {
+		return fmt.Errorf("error downloading %s from %s: %s", key, s.location, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	// the directory holds the SSH and TLS keys of the machine
+	return writeFileAtomic(file, data, 0600)
+}
+
+// Save saves the machine in the local store and uploads its directory,
+// deleting the keys of the files removed since.
+func (s *remoteStore) Save(host *host.Host) error {
+	if err := s.Store.Save(host); err != nil {
+		return fmt.Errorf("error saving with file store: %v", err)
+	}
+
+	prefix := s.machinePrefix(host.Name)
+	stale, err := s.Backend.List(prefix)
+	if err != nil {
+		return fmt.Errorf("error looking up machine %s in %s: %s", host.Name, s.location, err)
+	}
+	uploaded := map[string]bool{}
+
+	hostPath := filepath.Join(s.GetMachinesDir(), host.Name)
+	err = filepath.Walk(hostPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !shouldUpload(info.Name()) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(hostPath, file)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(relPath)
+		uploaded[key] = true
+		return s.upload(file, key)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range stale {
+		if !uploaded[key] {
+			if err := s.delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shouldUpload tells whether a file of a machine directory is uploaded, the
+// disk images being too large and recreated by the drivers.
+func shouldUpload(name string) bool {
+	for _, ext := range []string{".iso", ".tar.gz", ".vmdk", ".img", ".qcow2", ".vhd", ".vhdx"} {
+		if strings.HasSuffix(name, ext) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *remoteStore) upload(file, key string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Uploading %s to %s", key, s.location)
+	if err := s.Backend.Put(key, data); err != nil {
+		return fmt.Errorf("error uploading %s to %s: %s", key, s.location, err)
+	}
+	return nil
+}
+
+func (s *remoteStore) delete(key string) error {
+	log.Debugf("Deleting %s from %s", key, s.location)
+	if err := s.Backend.Delete(key); err != nil {
+		return fmt.Errorf("error deleting %s from %s: %s", key, s.location, err)
+	}
+	return nil
+}
+
+func (s *remoteStore) Remove(name string) error {
+	if err := s.Store.Remove(name); err != nil {
+		return fmt.Errorf("error removing directories for host %v: %v", name, err)
+	}
+
+	keys, err := s.Backend.List(s.machinePrefix(name))
+	if err != nil {
+		return fmt.Errorf("error looking up machine %s in %s: %s", name, s.location, err)
+	}
+	for _, key := range keys {
+		if err := s.delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockHost locks the host in the backend if it supports it, and in the
+// local store otherwise.
+func (s *remoteStore) LockHost(ctx context.Context, name string) (func() error, error) {
+	locker, ok := s.Backend.(Locker)
+	if !ok {
+		return LockHost(ctx, s.Store, name)
+	}
+
+	log.Debugf("Locking %s in %s", name, s.location)
+	unlock, err := locker.Lock(ctx, s.lockKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("error locking machine %s in %s: %s", name, s.location, err)
+	}
+	return unlock, nil
+}
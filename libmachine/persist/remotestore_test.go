@@ -0,0 +1,98 @@
+package persist
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/libmachine/hosttest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKV is a key-value store in memory, with locks.
+type fakeKV struct {
+	values map[string][]byte
+	locked map[string]bool
+}
+
+func (f *fakeKV) List(prefix string) ([]string, error) {
+	keys := []string{}
+	for key := range f.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (f *fakeKV) Get(key string) ([]byte, error) {
+	return f.values[key], nil
+}
+
+func (f *fakeKV) Put(key string, value []byte) error {
+	f.values[key] = value
+	return nil
+}
+
+func (f *fakeKV) Delete(key string) error {
+	delete(f.values, key)
+	return nil
+}
+
+func (f *fakeKV) Lock(ctx context.Context, key string) (func() error, error) {
+	f.locked[key] = true
+	f.values[key] = []byte("session")
+	return func() error {
+		delete(f.locked, key)
+		delete(f.values, key)
+		return nil
+	}, nil
+}
+
+func TestRemoteStoreLockHost(t *testing.T) {
+	defer cleanup()
+
+	kv := &fakeKV{values: map[string][]byte{}, locked: map[string]bool{}}
+	store := &remoteStore{Store: getTestStore(), Backend: kv, Prefix: "fleet", location: "kv"}
+	defer os.RemoveAll(store.Store.(Filestore).Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(h))
+
+	unlock, err := LockHost(context.Background(), store, h.Name)
+	assert.NoError(t, err)
+	assert.True(t, kv.locked["fleet/.locks/"+h.Name])
+
+	// the lock is not a machine
+	names, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{h.Name}, names)
+
+	assert.NoError(t, unlock())
+	assert.Empty(t, kv.locked)
+}
+
+func TestLockHostWithoutLocker(t *testing.T) {
+	unlock, err := LockHost(context.Background(), struct{ Store }{}, "host")
+
+	assert.NoError(t, err)
+	assert.NoError(t, unlock())
+}
+
+func TestNewRemoteStore(t *testing.T) {
+	store, err := NewRemoteStore(Filestore{}, "consul://127.0.0.1:8500/fleet/")
+	assert.NoError(t, err)
+	assert.IsType(t, &consulBackend{}, store.(*remoteStore).Backend)
+	assert.Equal(t, "fleet", store.(*remoteStore).Prefix)
+
+	store, err = NewRemoteStore(Filestore{}, "etcd://127.0.0.1:2379,127.0.0.2:2379/fleet")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379"}, store.(*remoteStore).Backend.(*etcdBackend).Client.Endpoints())
+
+	_, err = NewRemoteStore(Filestore{}, "zk://127.0.0.1/fleet")
+	assert.EqualError(t, err, `invalid store URL "zk://127.0.0.1/fleet": the scheme must be s3, etcd or consul`)
+}
@@ -0,0 +1,115 @@
+package persist
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// s3Backend keeps the machines in a bucket of an S3-compatible object
+// storage, e.g. AWS S3 or Exoscale SOS.
+type s3Backend struct {
+	Bucket string
+	Client s3iface.S3API
+}
+
+// NewS3Store returns a store keeping the machines of store in the bucket
+// given by rawURL, in the form s3://bucket/prefix. The query parameters
+// endpoint, region and path-style configure S3-compatible services, e.g.
+// s3://machines?endpoint=https://sos-ch-gva-2.exo.io&region=ch-gva-2. The
+// credentials are read from the environment or the shared AWS config.
+func NewS3Store(store Store, rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %s", rawURL, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid store URL %q: expected s3://bucket/prefix", rawURL)
+	}
+
+	config := aws.NewConfig()
+	query := u.Query()
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if region := query.Get("region"); region != "" {
+		config = config.WithRegion(region)
+	}
+	if pathStyle := query.Get("path-style"); pathStyle != "" {
+		forcePathStyle, err := strconv.ParseBool(pathStyle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid store URL %q: path-style must be true or false", rawURL)
+		}
+		config = config.WithS3ForcePathStyle(forcePathStyle)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error configuring the object storage client: %s", err)
+	}
+
+	return &remoteStore{
+		Store: store,
+		Backend: &s3Backend{
+			Bucket: u.Host,
+			Client: s3.New(sess),
+		},
+		Prefix:   strings.Trim(u.Path, "/"),
+		location: "s3://" + u.Host,
+	}, nil
+}
+
+func (b *s3Backend) List(prefix string) ([]string, error) {
+	keys := []string{}
+	err := b.Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (b *s3Backend) Get(key string) ([]byte, error) {
+	out, err := b.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	return ioutil.ReadAll(out.Body)
+}
+
+func (b *s3Backend) Put(key string, value []byte) error {
+	_, err := b.Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+func (b *s3Backend) Delete(key string) error {
+	_, err := b.Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
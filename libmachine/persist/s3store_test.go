@@ -0,0 +1,166 @@
+package persist
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/rancher/machine/libmachine/hosttest"
+	"github.com/rancher/machine/libmachine/mcnerror"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is a bucket in memory.
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{}
+	err := f.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		out = page
+		return false
+	})
+	if input.MaxKeys != nil && int64(len(out.Contents)) > *input.MaxKeys {
+		out.Contents = out.Contents[:*input.MaxKeys]
+	}
+	return out, err
+}
+
+func (f *fakeS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	prefix, delimiter := aws.StringValue(input.Prefix), aws.StringValue(input.Delimiter)
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			common := key[:len(prefix)+i+1]
+			if !seen[common] {
+				seen[common] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+			}
+			continue
+		}
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(f.objects[*input.Key]))}, nil
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	f.objects[*input.Key] = data
+	return &s3.PutObjectOutput{}, err
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(f.objects, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func getTestS3Store(bucket *fakeS3) *remoteStore {
+	return &remoteStore{
+		Store:    getTestStore(),
+		Backend:  &s3Backend{Bucket: "machines", Client: bucket},
+		Prefix:   "ci",
+		location: "s3://machines",
+	}
+}
+
+func TestS3StoreSharesMachines(t *testing.T) {
+	defer cleanup()
+
+	bucket := &fakeS3{objects: map[string][]byte{}}
+	runner, workstation := getTestS3Store(bucket), getTestS3Store(bucket)
+	defer os.RemoveAll(runner.Store.(Filestore).Path)
+	defer os.RemoveAll(workstation.Store.(Filestore).Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+
+	hostPath := filepath.Join(runner.GetMachinesDir(), h.Name)
+	assert.NoError(t, os.MkdirAll(hostPath, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(hostPath, "id_rsa"), []byte("key"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(hostPath, "boot2docker.iso"), []byte("iso"), 0600))
+	assert.NoError(t, runner.Save(h))
+
+	assert.Contains(t, bucket.objects, "ci/"+h.Name+"/config.json")
+	assert.Contains(t, bucket.objects, "ci/"+h.Name+"/id_rsa")
+	assert.NotContains(t, bucket.objects, "ci/"+h.Name+"/boot2docker.iso")
+
+	names, err := workstation.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{h.Name}, names)
+
+	exists, err := workstation.Exists(h.Name)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	loaded, err := workstation.Load(h.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, h.Name, loaded.Name)
+	key, err := ioutil.ReadFile(filepath.Join(workstation.GetMachinesDir(), h.Name, "id_rsa"))
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(key))
+
+	assert.NoError(t, workstation.Remove(h.Name))
+	assert.Empty(t, bucket.objects)
+
+	_, err = runner.Load(h.Name)
+	assert.Equal(t, mcnerror.ErrHostDoesNotExist{Name: h.Name}, err)
+}
+
+func TestS3StoreSaveDeletesRemovedFiles(t *testing.T) {
+	defer cleanup()
+
+	bucket := &fakeS3{objects: map[string][]byte{}}
+	store := getTestS3Store(bucket)
+	defer os.RemoveAll(store.Store.(Filestore).Path)
+
+	h, err := hosttest.GetDefaultTestHost()
+	assert.NoError(t, err)
+	bucket.objects["ci/"+h.Name+"/stale"] = []byte("stale")
+
+	assert.NoError(t, store.Save(h))
+
+	assert.NotContains(t, bucket.objects, "ci/"+h.Name+"/stale")
+	assert.Contains(t, bucket.objects, "ci/"+h.Name+"/config.json")
+}
+
+func TestNewS3StoreRejectsInvalidURLs(t *testing.T) {
+	for _, rawURL := range []string{"machines", "gs://machines", "s3:///prefix", "s3://machines?path-style=maybe"} {
+		_, err := NewS3Store(Filestore{}, rawURL)
+		assert.Error(t, err, rawURL)
+	}
+}
+
+func TestNewS3Store(t *testing.T) {
+	store, err := NewS3Store(Filestore{}, "s3://machines/ci/?endpoint=https://sos-ch-gva-2.exo.io&region=ch-gva-2&path-style=true")
+	assert.NoError(t, err)
+
+	s := store.(*remoteStore)
+	backend := s.Backend.(*s3Backend)
+	assert.Equal(t, "machines", backend.Bucket)
+	assert.Equal(t, "ci", s.Prefix)
+	assert.Equal(t, "https://sos-ch-gva-2.exo.io", backend.Client.(*s3.S3).Endpoint)
+}
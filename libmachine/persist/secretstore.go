@@ -55,6 +55,11 @@ func NewSecretStore(store Store, secretName, secretNamespace, kubeConfigPath str
 	return s, nil
 }
 
+// LockHost locks the host in the local store.
+func (s *secretStore) LockHost(ctx context.Context, name string) (func() error, error) {
+	return LockHost(ctx, s.Store, name)
+}
+
 func (s *secretStore) Remove(name string) error {
 	if err := s.Store.Remove(name); err != nil {
 		return fmt.Errorf("error removing directories for host %v: %v", name, err)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"path"
+	"strconv"
 	"text/template"
 	"time"
 
@@ -72,7 +73,7 @@ func (provisioner *Boot2DockerProvisioner) upgradeIso() error {
 		return err
 	}
 
-	if err := mcnutils.WaitFor(drivers.MachineInState(provisioner.Driver, state.Stopped)); err != nil {
+	if err := mcnutils.WaitForOperation(mcnutils.RetryState, drivers.MachineInState(provisioner.Driver, state.Stopped)); err != nil {
 		return err
 	}
 
@@ -92,7 +93,7 @@ func (provisioner *Boot2DockerProvisioner) upgradeIso() error {
 		return err
 	}
 
-	return mcnutils.WaitFor(drivers.MachineInState(provisioner.Driver, state.Running))
+	return mcnutils.WaitForOperation(mcnutils.RetryState, drivers.MachineInState(provisioner.Driver, state.Running))
 }
 
 func (provisioner *Boot2DockerProvisioner) Package(name string, action pkgaction.PackageAction) error {
@@ -132,6 +133,10 @@ func (provisioner *Boot2DockerProvisioner) GetSwarmOptions() swarm.Options {
 	return provisioner.SwarmOptions
 }
 
+func (provisioner *Boot2DockerProvisioner) GetEngineOptions() engine.Options {
+	return provisioner.EngineOptions
+}
+
 func (provisioner *Boot2DockerProvisioner) GenerateDockerOptions(dockerPort int) (*DockerOptions, error) {
 	var (
 		engineCfg bytes.Buffer
@@ -197,7 +202,7 @@ func (provisioner *Boot2DockerProvisioner) AttemptIPContact(dockerPort int) {
 		return
 	}
 
-	if conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, dockerPort), 5*time.Second); err != nil {
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(dockerPort)), 5*time.Second); err != nil {
 		log.Warnf(`
 This machine has been allocated an IP address, but Docker Machine could not
 reach it successfully.
@@ -222,7 +227,7 @@ func (provisioner *Boot2DockerProvisioner) Provision(swarmOptions swarm.Options,
 
 	defer func() {
 		if err == nil {
-			provisioner.AttemptIPContact(engine.DefaultPort)
+			provisioner.AttemptIPContact(engineOptions.DockerPort())
 		}
 	}()
 
@@ -240,7 +245,8 @@ func (provisioner *Boot2DockerProvisioner) Provision(swarmOptions swarm.Options,
 	}
 
 	// b2d hosts need to wait for the daemon to be up
-	// before continuing with provisioning
+	// before continuing with provisioning, on the default port until
+	// the daemon is configured
 	if err = WaitForDocker(provisioner, engine.DefaultPort); err != nil {
 		return err
 	}
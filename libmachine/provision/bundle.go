@@ -0,0 +1,157 @@
+package provision
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// bundleKind is the kind of the engine bundles installed offline.
+type bundleKind string
+
+const (
+	bundleDeb    bundleKind = "deb"
+	bundleRpm    bundleKind = "rpm"
+	bundleStatic bundleKind = "static"
+
+	// bundleDir is the directory the engine bundles are copied and extracted
+	// to on the machine
+	bundleDir = "/tmp/machine-engine"
+
+	// staticDockerUnit runs the dockerd of the static binaries, which do not
+	// ship the systemd units of the packages
+	staticDockerUnit = `[Unit]
+Description=Docker Application Container Engine
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart=/usr/bin/dockerd
+ExecReload=/bin/kill -s HUP $MAINPID
+LimitNOFILE=infinity
+TasksMax=infinity
+Delegate=yes
+KillMode=process
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+// copyToMachine copies the engine bundles to the machines.
+var copyToMachine = drivers.CopyToMachine
+
+// CheckEngineBundle checks that bundle is an engine bundle which can be
+// installed offline: a deb or rpm package, or a tarball of those or of the
+// static binaries of download.docker.com.
+func CheckEngineBundle(bundle string) error {
+	_, err := inspectBundle(bundle)
+	return err
+}
+
+// inspectBundle returns the kind of the engine bundle.
+func inspectBundle(bundle string) (bundleKind, error) {
+	name := strings.ToLower(filepath.Base(bundle))
+	switch {
+	case strings.HasSuffix(name, ".deb"):
+		return bundleDeb, nil
+	case strings.HasSuffix(name, ".rpm"):
+		return bundleRpm, nil
+	case !strings.HasSuffix(name, ".tar") && !strings.HasSuffix(name, ".tgz") && !strings.HasSuffix(name, ".tar.gz"):
+		return "", fmt.Errorf("%s is not an engine bundle, expected a .deb, .rpm, .tar, .tgz or .tar.gz file", bundle)
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", fmt.Errorf("Error reading %s: %s", bundle, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("Error reading %s: %s", bundle, err)
+		}
+		switch {
+		case strings.HasSuffix(header.Name, ".deb"):
+			return bundleDeb, nil
+		case strings.HasSuffix(header.Name, ".rpm"):
+			return bundleRpm, nil
+		case path.Base(header.Name) == "dockerd":
+			return bundleStatic, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no deb or rpm packages nor dockerd binary", bundle)
+}
+
+// installEngineBundle copies the engine bundle to the machine and installs
+// it, unless Docker is already installed.
+func installEngineBundle(p Provisioner, bundle string) error {
+	if _, err := p.SSHCommand("type docker"); err == nil {
+		log.Info("Docker is already installed, skipping installation")
+		return nil
+	}
+
+	kind, err := inspectBundle(bundle)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Installing Docker from the %s bundle %s", kind, bundle)
+	if _, err := p.SSHCommand(fmt.Sprintf("rm -rf %s && mkdir -p %s", bundleDir, bundleDir)); err != nil {
+		return err
+	}
+	remote := path.Join(bundleDir, filepath.Base(bundle))
+	if err := copyToMachine(p.GetDriver(), bundle, remote); err != nil {
+		return fmt.Errorf("Error copying %s to the machine: %s", bundle, err)
+	}
+
+	commands := []string{}
+	if ext := path.Ext(remote); ext != ".deb" && ext != ".rpm" {
+		commands = append(commands, fmt.Sprintf("tar -xf %s -C %s", remote, bundleDir))
+	}
+	switch kind {
+	case bundleDeb:
+		commands = append(commands, fmt.Sprintf("sudo dpkg -i $(find %s -name '*.deb')", bundleDir))
+	case bundleRpm:
+		commands = append(commands, fmt.Sprintf("sudo rpm -Uvh --replacepkgs $(find %s -name '*.rpm')", bundleDir))
+	case bundleStatic:
+		commands = append(commands,
+			fmt.Sprintf("sudo install -m 0755 $(find %s -path '*/docker/*' -type f) /usr/bin/", bundleDir),
+			"sudo groupadd -f docker",
+			fmt.Sprintf("if ! systemctl cat docker.service >/dev/null 2>&1; then sudo tee /etc/systemd/system/docker.service <<'EOF'\n%sEOF\nfi", staticDockerUnit),
+		)
+	}
+	commands = append(commands, "rm -rf "+bundleDir)
+
+	for _, command := range commands {
+		if output, err := p.SSHCommand(command); err != nil {
+			return fmt.Errorf("Error installing Docker: %s", output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package provision
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBundle writes a gzipped tarball of empty files named names.
+func writeBundle(t *testing.T, name string, names ...string) string {
+	bundle := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, n := range names {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: n, Mode: 0755}))
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return bundle
+}
+
+func TestInspectBundle(t *testing.T) {
+	for _, tc := range []struct {
+		bundle   string
+		expected bundleKind
+	}{
+		{"docker-ce_24.0.7-1~ubuntu.22.04~jammy_amd64.deb", bundleDeb},
+		{"docker-ce-24.0.7-1.el9.x86_64.rpm", bundleRpm},
+		{writeBundle(t, "docker-24.0.7.tgz", "docker/", "docker/dockerd", "docker/containerd"), bundleStatic},
+		{writeBundle(t, "packages.tar.gz", "containerd.io.deb", "docker-ce.deb"), bundleDeb},
+		{writeBundle(t, "packages.tar.gz", "rpms/docker-ce.rpm"), bundleRpm},
+	} {
+		kind, err := inspectBundle(tc.bundle)
+		assert.NoError(t, err, tc.bundle)
+		assert.Equal(t, tc.expected, kind, tc.bundle)
+	}
+
+	assert.Error(t, CheckEngineBundle("docker.zip"))
+	assert.Error(t, CheckEngineBundle(writeBundle(t, "empty.tgz", "README.md")))
+}
+
+func TestInstallEngineBundle(t *testing.T) {
+	bundle := writeBundle(t, "docker-24.0.7.tgz", "docker/dockerd")
+	var copied string
+	copyToMachine = func(d drivers.Driver, src, dest string) error {
+		copied = dest
+		return nil
+	}
+	defer func() { copyToMachine = drivers.CopyToMachine }()
+
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	commander := &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"rm -rf /tmp/machine-engine && mkdir -p /tmp/machine-engine":                            "",
+			"tar -xf /tmp/machine-engine/docker-24.0.7.tgz -C /tmp/machine-engine":                  "",
+			"sudo install -m 0755 $(find /tmp/machine-engine -path '*/docker/*' -type f) /usr/bin/": "",
+			"sudo groupadd -f docker": "",
+			"if ! systemctl cat docker.service >/dev/null 2>&1; then sudo tee /etc/systemd/system/docker.service <<'EOF'\n" + staticDockerUnit + "EOF\nfi": "",
+			"rm -rf /tmp/machine-engine": "",
+		},
+	}
+	p.SSHCommander = commander
+
+	assert.NoError(t, installEngineBundle(p, bundle))
+	assert.Equal(t, "/tmp/machine-engine/docker-24.0.7.tgz", copied)
+
+	// Docker is not installed again when the machine is provisioned again
+	copied = ""
+	commander.Responses = map[string]string{"type docker": "docker is /usr/bin/docker"}
+	assert.NoError(t, installEngineBundle(p, "missing.tgz"))
+	assert.Empty(t, copied)
+}
@@ -47,7 +47,7 @@ func (provisioner *CentosProvisioner) Provision(swarmOptions swarm.Options, auth
 		return err
 	}
 
-	if err := installDockerGeneric(provisioner, provisioner.EngineOptions.InstallURL); err != nil {
+	if err := provisioner.installDocker(); err != nil {
 		return err
 	} else if err == nil {
 		if err := provisioner.Service("docker", serviceaction.Restart); err != nil {
@@ -0,0 +1,194 @@
+package provision
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+)
+
+const (
+	containerdConfigDir  = "/etc/containerd"
+	containerdConfigFile = containerdConfigDir + "/config.toml"
+	containerdEnvFile    = "/etc/systemd/system/containerd.service.d/10-machine.conf"
+
+	// containerdConfigTemplate enables the CRI plugin, which the containerd
+	// package of Docker disables, and the TLS API unless LocalOnly is set.
+	// The CRI plugin authenticates to the registries itself
+	containerdConfigTemplate = `version = 2
+
+[grpc]
+  address = "/run/containerd/containerd.sock"
+{{- if not .EngineOptions.LocalOnly }}
+  tcp_address = "0.0.0.0:{{.DockerPort}}"
+  tcp_tls_ca = "{{.AuthOptions.CaCertRemotePath}}"
+  tcp_tls_cert = "{{.AuthOptions.ServerCertRemotePath}}"
+  tcp_tls_key = "{{.AuthOptions.ServerKeyRemotePath}}"
+{{- end }}
+
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc]
+  runtime_type = "io.containerd.runc.v2"
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runc.options]
+  SystemdCgroup = true
+{{- if .EngineOptions.RegistryMirror }}
+[plugins."io.containerd.grpc.v1.cri".registry.mirrors."docker.io"]
+  endpoint = [{{ range $i, $mirror := .EngineOptions.RegistryMirror }}{{ if $i }}, {{ end }}"{{ $mirror }}"{{ end }}]
+{{- end }}
+{{- range .EngineOptions.InsecureRegistry }}
+[plugins."io.containerd.grpc.v1.cri".registry.configs."{{.}}".tls]
+  insecure_skip_verify = true
+{{- end }}
+{{- range $host, $ca := .EngineOptions.RegistryCA }}
+[plugins."io.containerd.grpc.v1.cri".registry.configs."{{ $host }}".tls]
+  ca_file = "/etc/containerd/certs.d/{{ $host }}/ca.crt"
+{{- end }}
+{{- range $host, $auth := .EngineOptions.RegistryAuth }}
+[plugins."io.containerd.grpc.v1.cri".registry.configs."{{ $host }}".auth]
+  auth = "{{ base64 $auth }}"
+{{- end }}
+`
+)
+
+// WithContainerd provisions containerd alone rather than Docker, listening on
+// the engine port with TLS unless LocalOnly is set.
+func WithContainerd(provisioner Provisioner, authOptions auth.Options, engineOptions engine.Options) error {
+	driver := provisioner.GetDriver()
+
+	if err := provisioner.SetHostname(driver.GetMachineName()); err != nil {
+		return err
+	}
+
+	for _, pkg := range provisioner.GetPackages() {
+		if err := provisioner.Package(pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+
+	if err := installContainerd(provisioner, engineOptions); err != nil {
+		return err
+	}
+
+	authOptions = containerdAuthOptions(authOptions)
+	if !engineOptions.LocalOnly {
+		if err := generateServerCert(driver, authOptions, provisioner.GetSwarmOptions()); err != nil {
+			return err
+		}
+		if _, err := provisioner.SSHCommand("sudo mkdir -p " + containerdConfigDir); err != nil {
+			return err
+		}
+		if err := copyServerCerts(provisioner, authOptions); err != nil {
+			return err
+		}
+	}
+
+	config, err := generateContainerdConfig(authOptions, engineOptions)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Setting containerd configuration on the remote daemon...")
+
+	if _, err := provisioner.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s <<'EOF'\n%sEOF", containerdConfigDir, containerdConfigFile, config)); err != nil {
+		return err
+	}
+	if len(engineOptions.Env) > 0 {
+		env := fmt.Sprintf("[Service]\nEnvironment=%s\n", strings.Join(engineOptions.Env, " "))
+		if _, err := provisioner.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s <<'EOF'\n%sEOF", path.Dir(containerdEnvFile), containerdEnvFile, env)); err != nil {
+			return err
+		}
+	}
+
+	if err := provisioner.Service("containerd", serviceaction.Restart); err != nil {
+		return err
+	}
+	if err := provisioner.Service("containerd", serviceaction.Enable); err != nil {
+		return err
+	}
+
+	return waitForContainerd(provisioner)
+}
+
+// waitForContainerd waits for containerd to answer on its local socket.
+func waitForContainerd(provisioner Provisioner) error {
+	return mcnutils.WaitFor(func() bool {
+		_, err := provisioner.SSHCommand("sudo ctr version")
+		return err == nil
+	})
+}
+
+// UpgradeContainerd upgrades the containerd package of the host and restarts
+// it.
+func UpgradeContainerd(provisioner Provisioner) error {
+	log.Info("Upgrading containerd...")
+	if err := provisioner.Package(containerdPackage(provisioner), pkgaction.Upgrade); err != nil {
+		return err
+	}
+
+	log.Info("Restarting containerd...")
+	return provisioner.Service("containerd", serviceaction.Restart)
+}
+
+// installContainerd installs the containerd package of the host, that of the
+// Docker repository of the download URL on the RedHat hosts, unless the
+// install URL is none.
+func installContainerd(provisioner Provisioner, engineOptions engine.Options) error {
+	if strings.EqualFold(engineOptions.InstallURL, "none") {
+		log.Info("Skipping containerd installation")
+		return nil
+	}
+	if _, err := provisioner.SSHCommand("type containerd"); err == nil {
+		log.Info("containerd is already installed, skipping installation")
+		return nil
+	}
+
+	pkg := containerdPackage(provisioner)
+	if pkg == "containerd.io" {
+		if err := addDockerCERepo(provisioner, withProxyEnv(engineOptions, "sudo -E curl -fsSL -o /etc/yum.repos.d/docker-ce.repo"), engineOptions.DownloadURL); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Installing containerd from the %s package", pkg)
+	return provisioner.Package(pkg, pkgaction.Install)
+}
+
+// containerdPackage returns the containerd package of the host, containerd.io
+// on the RedHat hosts which do not ship one.
+func containerdPackage(provisioner Provisioner) string {
+	info, err := provisioner.GetOsReleaseInfo()
+	if err != nil || info == nil {
+		return "containerd"
+	}
+	for _, id := range append([]string{info.ID}, strings.Fields(info.IDLike)...) {
+		switch id {
+		case "rhel", "centos", "fedora":
+			return "containerd.io"
+		}
+	}
+	return "containerd"
+}
+
+// containerdAuthOptions returns authOptions with the remote paths of the
+// certificates in the configuration directory of containerd.
+func containerdAuthOptions(authOptions auth.Options) auth.Options {
+	// due to windows clients, we cannot use filepath.Join as the paths
+	// will be mucked on the linux hosts
+	authOptions.CaCertRemotePath = path.Join(containerdConfigDir, "ca.pem")
+	authOptions.ServerCertRemotePath = path.Join(containerdConfigDir, "server.pem")
+	authOptions.ServerKeyRemotePath = path.Join(containerdConfigDir, "server-key.pem")
+	return authOptions
+}
+
+func generateContainerdConfig(authOptions auth.Options, engineOptions engine.Options) (string, error) {
+	return executeTemplate(containerdConfigTemplate, EngineConfigContext{
+		DockerPort:    engineOptions.DockerPort(),
+		AuthOptions:   authOptions,
+		EngineOptions: engineOptions,
+	})
+}
@@ -0,0 +1,81 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateContainerdConfig(t *testing.T) {
+	authOptions := containerdAuthOptions(auth.Options{})
+	engineOptions := engine.Options{
+		Port:             2377,
+		RegistryMirror:   []string{"https://mirror.example.com", "https://mirror2.example.com"},
+		InsecureRegistry: []string{"registry.local:5000"},
+	}
+
+	config, err := generateContainerdConfig(authOptions, engineOptions)
+	assert.NoError(t, err)
+	assert.Contains(t, config, `tcp_address = "0.0.0.0:2377"`)
+	assert.Contains(t, config, `tcp_tls_ca = "/etc/containerd/ca.pem"`)
+	assert.Contains(t, config, `tcp_tls_key = "/etc/containerd/server-key.pem"`)
+	assert.Contains(t, config, `endpoint = ["https://mirror.example.com", "https://mirror2.example.com"]`)
+	assert.Contains(t, config, `[plugins."io.containerd.grpc.v1.cri".registry.configs."registry.local:5000".tls]`)
+	assert.NotContains(t, config, "disabled_plugins")
+
+	engineOptions.RegistryCA = map[string]string{"mirror.example.com": "/tmp/ca.crt"}
+	engineOptions.RegistryAuth = map[string]string{"mirror.example.com": "user:secret"}
+	config, err = generateContainerdConfig(authOptions, engineOptions)
+	assert.NoError(t, err)
+	assert.Contains(t, config, `[plugins."io.containerd.grpc.v1.cri".registry.configs."mirror.example.com".tls]
+  ca_file = "/etc/containerd/certs.d/mirror.example.com/ca.crt"`)
+	assert.Contains(t, config, `[plugins."io.containerd.grpc.v1.cri".registry.configs."mirror.example.com".auth]
+  auth = "dXNlcjpzZWNyZXQ="`)
+
+	engineOptions.LocalOnly = true
+	config, err = generateContainerdConfig(authOptions, engineOptions)
+	assert.NoError(t, err)
+	assert.Contains(t, config, `address = "/run/containerd/containerd.sock"`)
+	assert.NotContains(t, config, "tcp_address")
+}
+
+func TestContainerdPackage(t *testing.T) {
+	for _, tc := range []struct {
+		info     *OsRelease
+		expected string
+	}{
+		{&OsRelease{ID: "ubuntu", IDLike: "debian"}, "containerd"},
+		{&OsRelease{ID: "rocky", IDLike: "rhel centos fedora"}, "containerd.io"},
+		{&OsRelease{ID: "centos"}, "containerd.io"},
+		{&OsRelease{ID: "sles", IDLike: "suse"}, "containerd"},
+	} {
+		p := NewRedHatProvisioner(tc.info.ID, &fakedriver.Driver{})
+		p.SetOsReleaseInfo(tc.info)
+		assert.Equal(t, tc.expected, containerdPackage(p), tc.info.ID)
+	}
+}
+
+func TestInstallContainerd(t *testing.T) {
+	p := NewRedHatProvisioner("rocky", &fakedriver.Driver{})
+	p.SetOsReleaseInfo(&OsRelease{ID: "rocky", IDLike: "rhel centos fedora", VersionID: "9.3"})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"sudo -E curl -fsSL -o /etc/yum.repos.d/docker-ce.repo " + dockerCERepo: "",
+			"sudo -E dnf install -y containerd.io":                                  "",
+		},
+	}
+	assert.NoError(t, installContainerd(p, engine.Options{InstallURL: drivers.DefaultEngineInstallURL}))
+
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{"type containerd": "containerd is /usr/bin/containerd"},
+	}
+	assert.NoError(t, installContainerd(p, engine.Options{InstallURL: drivers.DefaultEngineInstallURL}))
+
+	p.SSHCommander = &provisiontest.FakeSSHCommander{}
+	assert.NoError(t, installContainerd(p, engine.Options{InstallURL: "none"}))
+}
@@ -0,0 +1,177 @@
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// daemonJSONFile is the configuration file of dockerd on the Linux hosts
+const daemonJSONFile = "/etc/docker/daemon.json"
+
+var (
+	// managedDaemonSettings are the settings of daemon.json which the
+	// machine always sets, serving the API with TLS on the engine port
+	managedDaemonSettings = []string{"hosts", "tls", "tlsverify", "tlscacert", "tlscert", "tlskey", "labels"}
+
+	// daemonSettingFlags are the settings of daemon.json named after the
+	// plural of their dockerd flag
+	daemonSettingFlags = map[string]string{
+		"labels":              "label",
+		"insecure-registries": "insecure-registry",
+		"registry-mirrors":    "registry-mirror",
+		"runtimes":            "add-runtime",
+		"hosts":               "host",
+		"log-opts":            "log-opt",
+		"storage-opts":        "storage-opt",
+		"exec-opts":           "exec-opt",
+		"default-ulimits":     "default-ulimit",
+	}
+)
+
+// ReadDaemonJSON returns the dockerd settings of value, inline JSON or the
+// path of a daemon.json file, as compact JSON. The settings must be an
+// object which does not set those the machine manages.
+func ReadDaemonJSON(value string) (string, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		if data, err = ioutil.ReadFile(value); err != nil {
+			return "", err
+		}
+	}
+
+	settings, err := parseDaemonJSON(string(data))
+	if err != nil {
+		return "", err
+	}
+	if conflicts := daemonJSONConflicts(settings, engine.Options{}); len(conflicts) > 0 {
+		return "", fmt.Errorf("the settings %s are managed by machine", strings.Join(conflicts, ", "))
+	}
+
+	compact, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	return string(compact), nil
+}
+
+// CheckDaemonJSON checks that the daemon.json settings set none of those of
+// the engine options, which dockerd refuses to start with.
+func CheckDaemonJSON(daemonJSON string, engineOptions engine.Options) error {
+	settings, err := parseDaemonJSON(daemonJSON)
+	if err != nil {
+		return err
+	}
+	if conflicts := daemonJSONConflicts(settings, engineOptions); len(conflicts) > 0 {
+		return fmt.Errorf("daemon.json sets %s, also set by the engine flags", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+func parseDaemonJSON(daemonJSON string) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(daemonJSON), &settings); err != nil {
+		return nil, fmt.Errorf("invalid daemon.json: %s", err)
+	}
+	return settings, nil
+}
+
+// daemonJSONConflicts returns the sorted settings of daemon.json which are
+// also managed by the machine or set by the engine options.
+func daemonJSONConflicts(settings map[string]interface{}, engineOptions engine.Options) []string {
+	set := map[string]bool{}
+	for _, setting := range managedDaemonSettings {
+		set[setting] = true
+	}
+	if engineOptions.StorageDriver != "" {
+		set["storage-driver"] = true
+	}
+	if len(engineOptions.InsecureRegistry) > 0 {
+		set["insecure-registries"] = true
+	}
+	if len(engineOptions.RegistryMirror) > 0 {
+		set["registry-mirrors"] = true
+	}
+	if len(engineOptions.DNS) > 0 {
+		set["dns"] = true
+	}
+	if engineOptions.GraphDir != "" {
+		set["data-root"] = true
+	}
+	if engineOptions.LogLevel != "" {
+		set["log-level"] = true
+	}
+	for _, flag := range engineOptions.ArbitraryFlags {
+		set[strings.SplitN(flag, "=", 2)[0]] = true
+	}
+
+	conflicts := []string{}
+	for setting := range settings {
+		flag, plural := daemonSettingFlags[setting]
+		if set[setting] || (plural && set[flag]) {
+			conflicts = append(conflicts, setting)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// mergeDaemonJSON adds the daemon.json settings of the engine options to the
+// configuration generated for the daemon, failing on the settings it already
+// has.
+func mergeDaemonJSON(config map[string]interface{}, engineOptions engine.Options) error {
+	if engineOptions.DaemonJSON == "" {
+		return nil
+	}
+	settings, err := parseDaemonJSON(engineOptions.DaemonJSON)
+	if err != nil {
+		return err
+	}
+
+	conflicts := []string{}
+	for setting, value := range settings {
+		if _, ok := config[setting]; ok {
+			conflicts = append(conflicts, setting)
+			continue
+		}
+		config[setting] = value
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("daemon.json sets %s, also set by the engine flags", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// writeDaemonJSON writes the daemon.json settings of the engine options to
+// the host, where dockerd merges them with the flags of its unit.
+func writeDaemonJSON(p Provisioner, engineOptions engine.Options) error {
+	if engineOptions.DaemonJSON == "" {
+		return nil
+	}
+	if err := CheckDaemonJSON(engineOptions.DaemonJSON, engineOptions); err != nil {
+		return err
+	}
+
+	settings, err := parseDaemonJSON(engineOptions.DaemonJSON)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Setting the daemon.json of the remote daemon: %s", daemonJSONFile)
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s <<'EOF'\n%s\nEOF", path.Dir(daemonJSONFile), daemonJSONFile, data)); err != nil {
+		return err
+	}
+	return nil
+}
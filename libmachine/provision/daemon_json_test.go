@@ -0,0 +1,67 @@
+package provision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDaemonJSON(t *testing.T) {
+	daemonJSON, err := ReadDaemonJSON(` { "log-driver": "local", "live-restore": true }`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"live-restore":true,"log-driver":"local"}`, daemonJSON)
+
+	file := filepath.Join(t.TempDir(), "daemon.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"default-address-pools": [{"base": "10.10.0.0/16", "size": 24}]}`), 0600))
+	daemonJSON, err = ReadDaemonJSON(file)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"default-address-pools":[{"base":"10.10.0.0/16","size":24}]}`, daemonJSON)
+
+	_, err = ReadDaemonJSON(`{"tlsverify": false, "hosts": ["unix://"]}`)
+	assert.EqualError(t, err, "the settings hosts, tlsverify are managed by machine")
+
+	_, err = ReadDaemonJSON(`["log-driver"]`)
+	assert.Error(t, err)
+
+	_, err = ReadDaemonJSON(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestCheckDaemonJSON(t *testing.T) {
+	engineOptions := engine.Options{
+		StorageDriver:    "overlay2",
+		InsecureRegistry: []string{"registry.local:5000"},
+		ArbitraryFlags:   []string{"log-driver=journald", "default-ulimit=nofile=1024:2048"},
+	}
+
+	assert.NoError(t, CheckDaemonJSON(`{"live-restore": true, "registry-mirrors": ["https://mirror.local"]}`, engineOptions))
+	assert.EqualError(t,
+		CheckDaemonJSON(`{"storage-driver": "btrfs", "insecure-registries": [], "log-driver": "local", "default-ulimits": {}}`, engineOptions),
+		"daemon.json sets default-ulimits, insecure-registries, log-driver, storage-driver, also set by the engine flags")
+}
+
+func TestMergeDaemonJSON(t *testing.T) {
+	config := map[string]interface{}{"tlsverify": true}
+	assert.NoError(t, mergeDaemonJSON(config, engine.Options{DaemonJSON: `{"debug": true}`}))
+	assert.Equal(t, map[string]interface{}{"tlsverify": true, "debug": true}, config)
+
+	assert.EqualError(t, mergeDaemonJSON(config, engine.Options{DaemonJSON: `{"tlsverify": false}`}), "daemon.json sets tlsverify, also set by the engine flags")
+}
+
+func TestWriteDaemonJSON(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"sudo mkdir -p /etc/docker && sudo tee /etc/docker/daemon.json <<'EOF'\n{\n  \"live-restore\": true\n}\nEOF": "",
+		},
+	}
+
+	assert.NoError(t, writeDaemonJSON(p, engine.Options{}))
+	assert.NoError(t, writeDaemonJSON(p, engine.Options{DaemonJSON: `{"live-restore":true}`}))
+	assert.Error(t, writeDaemonJSON(p, engine.Options{DaemonJSON: `{"labels":["a=b"]}`, Labels: []string{"provider=Driver"}}))
+}
@@ -0,0 +1,70 @@
+package provision
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// holdEngineCommand holds the Docker packages installed at their version with
+// the package manager of the host, refusing their upgrades
+const holdEngineCommand = `pkgs="docker-ce docker-ce-cli docker.io docker moby-engine"
+if type apt-mark >/dev/null 2>&1; then
+  sudo apt-mark hold $(dpkg-query -W -f='${Package}\n' $pkgs 2>/dev/null)
+elif type dnf >/dev/null 2>&1; then
+  sudo dnf install -y 'dnf-command(versionlock)' && sudo dnf versionlock add $(rpm -q --qf '%{NAME}\n' $pkgs | grep -v 'not installed')
+elif type yum >/dev/null 2>&1; then
+  sudo yum install -y yum-plugin-versionlock && sudo yum versionlock add $(rpm -q --qf '%{NAME}\n' $pkgs | grep -v 'not installed')
+elif type zypper >/dev/null 2>&1; then
+  sudo zypper -n addlock $(rpm -q --qf '%{NAME}\n' $pkgs | grep -v 'not installed')
+else
+  echo "no package manager holding the packages" >&2
+  exit 1
+fi`
+
+// enginePackagedCommand succeeds if dockerd is installed by a package rather
+// than from the static binaries
+const enginePackagedCommand = `p=$(command -v dockerd) && { dpkg -S "$p" || rpm -qf "$p"; } >/dev/null 2>&1`
+
+var engineVersionRE = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// ValidateEngineVersion checks that version is a Docker version which can be
+// pinned, e.g. 24.0.7 or 24.0 for the latest patch release.
+func ValidateEngineVersion(version string) error {
+	if !engineVersionRE.MatchString(version) {
+		return fmt.Errorf("%q is not a Docker version, expected e.g. 24.0.7", version)
+	}
+	return nil
+}
+
+// matchesEngineVersion tells whether the installed Docker version is that
+// requested, or one of its patch releases if only major.minor.
+func matchesEngineVersion(installed, requested string) bool {
+	return installed == requested || strings.HasPrefix(installed, requested+".")
+}
+
+// pinEngineVersion checks that the installed Docker is of the requested
+// version, then holds its packages at that version. The static binaries,
+// which the package manager does not upgrade, are not held.
+func pinEngineVersion(p Provisioner, version string) error {
+	installed, err := DockerClientVersion(p)
+	if err != nil {
+		return err
+	}
+	if !matchesEngineVersion(installed, version) {
+		return fmt.Errorf("Docker %s is installed on the machine, %s was requested", installed, version)
+	}
+
+	if _, err := p.SSHCommand(enginePackagedCommand); err != nil {
+		log.Infof("Docker %s is installed from the static binaries, not holding its packages", installed)
+		return nil
+	}
+
+	log.Infof("Holding Docker at version %s", installed)
+	if output, err := p.SSHCommand(holdEngineCommand); err != nil {
+		return fmt.Errorf("Error holding the Docker packages at version %s: %s", installed, strings.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEngineVersion(t *testing.T) {
+	for _, version := range []string{"24", "24.0", "24.0.7"} {
+		assert.NoError(t, ValidateEngineVersion(version), version)
+	}
+	for _, version := range []string{"", "latest", "v24.0.7", "24.0.7; reboot", "24.0.7.1"} {
+		assert.Error(t, ValidateEngineVersion(version), version)
+	}
+}
+
+func TestMatchesEngineVersion(t *testing.T) {
+	assert.True(t, matchesEngineVersion("24.0.7", "24.0.7"))
+	assert.True(t, matchesEngineVersion("24.0.7", "24.0"))
+	assert.False(t, matchesEngineVersion("24.0.7", "24.0.1"))
+	assert.False(t, matchesEngineVersion("24.0.10", "24.0.1"))
+	assert.False(t, matchesEngineVersion("25.0.0", "24"))
+}
+
+func TestPinEngineVersion(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"docker --version":    "Docker version 24.0.7, build afdd53b\n",
+			enginePackagedCommand: "",
+			holdEngineCommand:     "",
+		},
+	}
+
+	assert.NoError(t, pinEngineVersion(p, "24.0"))
+	assert.EqualError(t, pinEngineVersion(p, "23.0.6"), "Docker 24.0.7 is installed on the machine, 23.0.6 was requested")
+
+	// the hold failing fails the provisioning
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"docker --version":    "Docker version 24.0.7, build afdd53b\n",
+			enginePackagedCommand: "",
+		},
+	}
+	assert.Error(t, pinEngineVersion(p, "24.0"))
+
+	// the static binaries are not held
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"docker --version": "Docker version 24.0.7, build afdd53b\n",
+		},
+	}
+	assert.NoError(t, pinEngineVersion(p, "24.0"))
+}
+
+func TestInstallDockerGenericVersion(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"if ! type docker; then curl -sSL https://get.docker.com | VERSION=24.0.7 sh -; fi": "",
+		},
+	}
+
+	assert.NoError(t, installDockerGeneric(p, engine.Options{InstallURL: "https://get.docker.com", Version: "24.0.7"}))
+}
@@ -26,7 +26,7 @@ func NewFedoraCoreOSProvisioner(d drivers.Driver) Provisioner {
 	}
 }
 
-// FedoraCoreOSProvisioner is a provisioner based on the CoreOS provisioner
+// FedoraCoreOSProvisioner is a provisioner for Fedora CoreOS
 type FedoraCoreOSProvisioner struct {
 	SystemdProvisioner
 }
@@ -0,0 +1,160 @@
+package provision
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/swarm"
+)
+
+// flatcarContainerdCommand prints the containerd flag of the docker unit of
+// the host, running dockerd with the containerd service
+const flatcarContainerdCommand = "systemctl cat docker.service | grep -o -- '--containerd=[^ ]*' | head -n 1"
+
+// ErrFlatcarDockerDisabled is returned when the Docker sysext of the Flatcar
+// host was disabled by its Ignition config.
+var ErrFlatcarDockerDisabled = errors.New("Docker is disabled on the Flatcar host, enable the docker-flatcar sysext in its Ignition config")
+
+func init() {
+	Register("Flatcar", &RegisteredProvisioner{
+		New: NewFlatcarProvisioner,
+	})
+}
+
+// NewFlatcarProvisioner creates a new provisioner for a driver
+func NewFlatcarProvisioner(d drivers.Driver) Provisioner {
+	return &FlatcarProvisioner{
+		NewSystemdProvisioner("flatcar", d),
+	}
+}
+
+// FlatcarProvisioner is a provisioner for Flatcar Container Linux, the
+// successor of CoreOS Container Linux. Docker ships with the host, which is
+// configured at its first boot by Ignition from the user data of the drivers,
+// the provisioner only adds the systemd drop-in of the engine.
+type FlatcarProvisioner struct {
+	SystemdProvisioner
+}
+
+// String returns the name of the provisioner
+func (provisioner *FlatcarProvisioner) String() string {
+	return "Flatcar"
+}
+
+// CompatibleWithHost returns whether or not this provisoner is compatible
+// with the target host
+func (provisioner *FlatcarProvisioner) CompatibleWithHost() bool {
+	return provisioner.OsReleaseInfo.ID == provisioner.OsReleaseID
+}
+
+// SetHostname sets the hostname of the remote machine, unless Ignition
+// already did
+func (provisioner *FlatcarProvisioner) SetHostname(hostname string) error {
+	log.Debugf("SetHostname: %s", hostname)
+
+	if current, err := provisioner.Hostname(); err == nil && strings.TrimSpace(current) == hostname {
+		log.Debugf("hostname is already %s", hostname)
+		return nil
+	}
+
+	command := fmt.Sprintf("sudo hostnamectl set-hostname %s", hostname)
+	if _, err := provisioner.SSHCommand(command); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GenerateDockerOptions formats a systemd drop-in unit which adds support for
+// Docker Machine, keeping the options of the docker unit of the host
+func (provisioner *FlatcarProvisioner) GenerateDockerOptions(dockerPort int) (*DockerOptions, error) {
+	var (
+		engineCfg bytes.Buffer
+	)
+
+	driverNameLabel := fmt.Sprintf("provider=%s", provisioner.Driver.DriverName())
+	provisioner.EngineOptions.Labels = append(provisioner.EngineOptions.Labels, driverNameLabel)
+
+	engineConfigTmpl := `[Service]
+Environment=TMPDIR=/var/tmp
+ExecStart=
+ExecStart=/usr/bin/dockerd --host=fd:// --host=tcp://0.0.0.0:{{.DockerPort}} --tlsverify --tlscacert {{.AuthOptions.CaCertRemotePath}} --tlscert {{.AuthOptions.ServerCertRemotePath}} --tlskey {{.AuthOptions.ServerKeyRemotePath}}{{ if .EngineOptions.StorageDriver }} --storage-driver {{.EngineOptions.StorageDriver}}{{ end }}{{ range .EngineOptions.Labels }} --label {{.}}{{ end }}{{ range .EngineOptions.InsecureRegistry }} --insecure-registry {{.}}{{ end }}{{ range .EngineOptions.RegistryMirror }} --registry-mirror {{.}}{{ end }}{{ range .EngineOptions.ArbitraryFlags }} --{{.}}{{ end }} \$DOCKER_SELINUX \$DOCKER_OPTS \$DOCKER_CGROUPS \$DOCKER_OPT_BIP \$DOCKER_OPT_MTU \$DOCKER_OPT_IPMASQ
+Environment={{range .EngineOptions.Env}}{{ printf "%q" . }} {{end}}
+`
+
+	t, err := template.New("engineConfig").Parse(engineConfigTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	engineConfigContext := EngineConfigContext{
+		DockerPort:    dockerPort,
+		AuthOptions:   provisioner.AuthOptions,
+		EngineOptions: provisioner.EngineOptions,
+	}
+
+	t.Execute(&engineCfg, engineConfigContext)
+
+	return &DockerOptions{
+		EngineOptions:     engineCfg.String(),
+		EngineOptionsPath: provisioner.DaemonOptionsFile,
+	}, nil
+}
+
+// Package installs a package on the remote host. The Flatcar provisioner does
+// not support (or need) any package installation
+func (provisioner *FlatcarProvisioner) Package(name string, action pkgaction.PackageAction) error {
+	return nil
+}
+
+// Provision provisions the machine
+func (provisioner *FlatcarProvisioner) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	provisioner.SwarmOptions = swarmOptions
+	provisioner.AuthOptions = authOptions
+	provisioner.EngineOptions = engineOptions
+
+	if _, err := provisioner.SSHCommand("type docker"); err != nil {
+		return ErrFlatcarDockerDisabled
+	}
+
+	if err := provisioner.SetHostname(provisioner.Driver.GetMachineName()); err != nil {
+		return err
+	}
+
+	// the drop-in replaces the command of the unit, dockerd keeps using the
+	// containerd service of the host
+	if output, err := provisioner.SSHCommand(flatcarContainerdCommand); err == nil && strings.TrimSpace(output) != "" {
+		socket := strings.TrimPrefix(strings.TrimSpace(output), "--containerd=")
+		provisioner.EngineOptions.ArbitraryFlags = appendEngineFlag(provisioner.EngineOptions.ArbitraryFlags, "containerd=", socket)
+	}
+
+	if err := makeDockerOptionsDir(provisioner); err != nil {
+		return err
+	}
+
+	log.Debugf("Preparing certificates")
+	provisioner.AuthOptions = setRemoteAuthOptions(provisioner)
+
+	log.Debugf("Setting up certificates")
+	if err := ConfigureAuth(provisioner); err != nil {
+		return err
+	}
+
+	log.Debug("Configuring swarm")
+	if err := configureSwarm(provisioner, swarmOptions, provisioner.AuthOptions); err != nil {
+		return err
+	}
+
+	// enable in systemd
+	log.Debug("enabling docker in systemd")
+	return provisioner.Service("docker", serviceaction.Enable)
+}
@@ -0,0 +1,51 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlatcarCompatibleWithHost(t *testing.T) {
+	p := NewFlatcarProvisioner(nil)
+
+	p.SetOsReleaseInfo(&OsRelease{ID: "flatcar", IDLike: "coreos"})
+	assert.True(t, p.CompatibleWithHost())
+
+	p.SetOsReleaseInfo(&OsRelease{ID: "fedora", VariantID: "coreos"})
+	assert.False(t, p.CompatibleWithHost())
+}
+
+func TestFlatcarGenerateDockerOptions(t *testing.T) {
+	p := NewFlatcarProvisioner(&fakedriver.Driver{}).(*FlatcarProvisioner)
+	p.EngineOptions = engine.Options{
+		Labels:         []string{"env=test"},
+		ArbitraryFlags: []string{"containerd=/run/containerd/containerd.sock"},
+	}
+	p.AuthOptions = auth.Options{
+		CaCertRemotePath:     "/etc/docker/ca.pem",
+		ServerCertRemotePath: "/etc/docker/server.pem",
+		ServerKeyRemotePath:  "/etc/docker/server-key.pem",
+	}
+
+	options, err := p.GenerateDockerOptions(2376)
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/systemd/system/docker.service.d/10-machine.conf", options.EngineOptionsPath)
+	assert.Contains(t, options.EngineOptions, "ExecStart=/usr/bin/dockerd --host=fd:// --host=tcp://0.0.0.0:2376 --tlsverify")
+	assert.Contains(t, options.EngineOptions, "--label env=test --label provider=Driver")
+	assert.Contains(t, options.EngineOptions, `--containerd=/run/containerd/containerd.sock \$DOCKER_SELINUX \$DOCKER_OPTS \$DOCKER_CGROUPS`)
+	assert.NotContains(t, options.EngineOptions, "--storage-driver")
+}
+
+func TestFlatcarProvisionWithDockerDisabled(t *testing.T) {
+	p := NewFlatcarProvisioner(&fakedriver.Driver{}).(*FlatcarProvisioner)
+	p.SSHCommander = &provisiontest.FakeSSHCommander{}
+
+	err := p.Provision(swarm.Options{}, auth.Options{}, engine.Options{})
+	assert.Equal(t, ErrFlatcarDockerDisabled, err)
+}
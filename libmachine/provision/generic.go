@@ -3,11 +3,13 @@ package provision
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/rancher/machine/libmachine/auth"
 	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/swarm"
 )
 
@@ -37,7 +39,9 @@ func (provisioner *GenericProvisioner) Hostname() (string, error) {
 }
 
 func (provisioner *GenericProvisioner) SetHostname(hostname string) error {
-	if _, err := provisioner.SSHCommand(fmt.Sprintf(
+	if current, err := provisioner.Hostname(); err == nil && strings.TrimSpace(current) == hostname {
+		log.Debugf("hostname is already %s", hostname)
+	} else if _, err := provisioner.SSHCommand(fmt.Sprintf(
 		"sudo hostname %s && echo %q | sudo tee /etc/hostname",
 		hostname,
 		hostname,
@@ -80,6 +84,10 @@ func (provisioner *GenericProvisioner) GetSwarmOptions() swarm.Options {
 	return provisioner.SwarmOptions
 }
 
+func (provisioner *GenericProvisioner) GetEngineOptions() engine.Options {
+	return provisioner.EngineOptions
+}
+
 func (provisioner *GenericProvisioner) SetOsReleaseInfo(info *OsRelease) {
 	provisioner.OsReleaseInfo = info
 }
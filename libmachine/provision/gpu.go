@@ -0,0 +1,104 @@
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+)
+
+const (
+	nvidiaToolkitPackage = "nvidia-container-toolkit"
+	nvidiaRuntime        = "nvidia"
+
+	// nvidiaRepoCommand adds the repository of the NVIDIA Container Toolkit
+	// to the APT, Zypper or YUM sources of the host
+	nvidiaRepoCommand = `if type apt-get >/dev/null 2>&1; then
+  curl -fsSL https://nvidia.github.io/libnvidia-container/gpgkey | sudo gpg --batch --yes --dearmor -o /usr/share/keyrings/nvidia-container-toolkit-keyring.gpg &&
+  curl -fsSL https://nvidia.github.io/libnvidia-container/stable/deb/nvidia-container-toolkit.list | sed 's#deb https://#deb [signed-by=/usr/share/keyrings/nvidia-container-toolkit-keyring.gpg] https://#g' | sudo tee /etc/apt/sources.list.d/nvidia-container-toolkit.list >/dev/null
+elif type zypper >/dev/null 2>&1; then
+  sudo zypper lr nvidia-container-toolkit >/dev/null 2>&1 || sudo zypper -n --gpg-auto-import-keys ar -f https://nvidia.github.io/libnvidia-container/stable/rpm/nvidia-container-toolkit.repo
+else
+  curl -fsSL https://nvidia.github.io/libnvidia-container/stable/rpm/nvidia-container-toolkit.repo | sudo tee /etc/yum.repos.d/nvidia-container-toolkit.repo >/dev/null
+fi`
+
+	// gpuCheckCommand runs nvidia-smi in a container given all the GPUs of
+	// the host, which the toolkit mounts with the driver
+	gpuCheckCommand = "sudo docker run --rm --gpus all ubuntu nvidia-smi -L"
+)
+
+// ErrNoGPU is returned when no GPU is available to the containers of the
+// machine with the NVIDIA runtime.
+var ErrNoGPU = errors.New("no GPU is available to the containers, check that the NVIDIA driver is installed on the machine")
+
+// withNvidiaRuntime returns the engine options with the nvidia runtime added
+// to the runtimes of their daemon.json, unless already there.
+func withNvidiaRuntime(engineOptions engine.Options) (engine.Options, error) {
+	settings := map[string]interface{}{}
+	if engineOptions.DaemonJSON != "" {
+		var err error
+		if settings, err = parseDaemonJSON(engineOptions.DaemonJSON); err != nil {
+			return engineOptions, err
+		}
+	}
+
+	runtimes, ok := settings["runtimes"].(map[string]interface{})
+	if !ok {
+		runtimes = map[string]interface{}{}
+	}
+	if _, ok := runtimes[nvidiaRuntime]; !ok {
+		runtimes[nvidiaRuntime] = map[string]interface{}{
+			"path": "nvidia-container-runtime",
+			"args": []interface{}{},
+		}
+	}
+	settings["runtimes"] = runtimes
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return engineOptions, err
+	}
+	engineOptions.DaemonJSON = string(data)
+	return engineOptions, nil
+}
+
+// installNvidiaToolkit installs the NVIDIA Container Toolkit from its
+// repository, then restarts Docker to load the nvidia runtime.
+func installNvidiaToolkit(p Provisioner, engineOptions engine.Options) error {
+	if _, err := p.SSHCommand("type nvidia-container-runtime"); err == nil {
+		log.Info("The NVIDIA Container Toolkit is already installed, skipping installation")
+	} else {
+		log.Info("Installing the NVIDIA Container Toolkit...")
+		if output, err := p.SSHCommand(withProxyEnv(engineOptions, nvidiaRepoCommand)); err != nil {
+			return fmt.Errorf("Error adding the NVIDIA Container Toolkit repository: %s", strings.TrimSpace(output))
+		}
+		if err := p.Package(nvidiaToolkitPackage, pkgaction.Install); err != nil {
+			return err
+		}
+		// the provisioners of the immutable hosts install no packages
+		if _, err := p.SSHCommand("type nvidia-container-runtime"); err != nil {
+			return fmt.Errorf("Error installing the NVIDIA Container Toolkit: %s installs no packages", p)
+		}
+	}
+
+	return p.Service("docker", serviceaction.Restart)
+}
+
+// verifyGPURuntime checks that the containers of the machine can be given
+// its GPUs with docker run --gpus.
+func verifyGPURuntime(p Provisioner) error {
+	log.Info("Verifying the GPUs of the machine with docker run --gpus...")
+	output, err := p.SSHCommand(gpuCheckCommand)
+	if err != nil {
+		log.Warnf("%s: %s", gpuCheckCommand, strings.TrimSpace(output))
+		return ErrNoGPU
+	}
+
+	log.Infof("GPUs available to the containers:\n%s", strings.TrimSpace(output))
+	return nil
+}
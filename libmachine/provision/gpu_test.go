@@ -0,0 +1,53 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithNvidiaRuntime(t *testing.T) {
+	engineOptions, err := withNvidiaRuntime(engine.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"runtimes":{"nvidia":{"args":[],"path":"nvidia-container-runtime"}}}`, engineOptions.DaemonJSON)
+
+	engineOptions, err = withNvidiaRuntime(engine.Options{DaemonJSON: `{"live-restore":true,"runtimes":{"nvidia":{"path":"/opt/nvidia/runtime"}}}`})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"live-restore":true,"runtimes":{"nvidia":{"path":"/opt/nvidia/runtime"}}}`, engineOptions.DaemonJSON)
+}
+
+func TestInstallNvidiaToolkit(t *testing.T) {
+	p := NewDebianProvisioner(&fakedriver.Driver{})
+	p.(*DebianProvisioner).SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"type nvidia-container-runtime":    "nvidia-container-runtime is /usr/bin/nvidia-container-runtime",
+			"sudo systemctl daemon-reload":     "",
+			"sudo systemctl -f restart docker": "",
+		},
+	}
+	assert.NoError(t, installNvidiaToolkit(p, engine.Options{}))
+
+	flatcar := NewFlatcarProvisioner(&fakedriver.Driver{})
+	flatcar.(*FlatcarProvisioner).SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			nvidiaRepoCommand: "",
+		},
+	}
+	assert.EqualError(t, installNvidiaToolkit(flatcar, engine.Options{}), "Error installing the NVIDIA Container Toolkit: Flatcar installs no packages")
+}
+
+func TestVerifyGPURuntime(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			gpuCheckCommand: "GPU 0: Tesla T4 (UUID: GPU-0)\n",
+		},
+	}
+	assert.NoError(t, verifyGPURuntime(p))
+
+	p.SSHCommander = &provisiontest.FakeSSHCommander{}
+	assert.Equal(t, ErrNoGPU, verifyGPURuntime(p))
+}
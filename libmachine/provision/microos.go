@@ -0,0 +1,182 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/swarm"
+)
+
+func init() {
+	Register("openSUSE MicroOS", &RegisteredProvisioner{
+		New: NewMicroOSProvisioner,
+	})
+}
+
+func NewMicroOSProvisioner(d drivers.Driver) Provisioner {
+	return &MicroOSProvisioner{
+		SUSEProvisioner: SUSEProvisioner{
+			NewSystemdProvisioner("opensuse-microos", d),
+		},
+	}
+}
+
+// MicroOSProvisioner provisions the immutable SUSE hosts, openSUSE MicroOS,
+// SLE Micro and SL Micro. Their root filesystem is read-only, the packages
+// are installed with transactional-update in a new snapshot, booted into
+// before starting Docker.
+type MicroOSProvisioner struct {
+	SUSEProvisioner
+	// rebootRequired tells whether a new snapshot was created
+	rebootRequired bool
+}
+
+// isTransactionalHost tells whether the host is an immutable SUSE host,
+// updated with transactional-update rather than zypper.
+func isTransactionalHost(info *OsRelease) bool {
+	id := strings.ToLower(info.ID)
+	return strings.Contains(id, "micro") && (strings.HasPrefix(id, "opensuse") || strings.HasPrefix(id, "sle") || strings.HasPrefix(id, "sl-"))
+}
+
+func (provisioner *MicroOSProvisioner) CompatibleWithHost() bool {
+	return isTransactionalHost(provisioner.OsReleaseInfo)
+}
+
+func (provisioner *MicroOSProvisioner) String() string {
+	return "openSUSE MicroOS"
+}
+
+func (provisioner *MicroOSProvisioner) Package(name string, action pkgaction.PackageAction) error {
+	var packageAction string
+
+	switch action {
+	case pkgaction.Install:
+		packageAction = "install"
+		if _, err := provisioner.SSHCommand(fmt.Sprintf("rpm -q %s", name)); err == nil {
+			log.Debugf("%s is already installed, skipping operation", name)
+			return nil
+		}
+	case pkgaction.Remove, pkgaction.Purge:
+		packageAction = "remove"
+	case pkgaction.Upgrade:
+		packageAction = "update"
+	}
+
+	// --continue builds on the snapshot of the previous updates, pending
+	// until the reboot, rather than on the running one
+	command := fmt.Sprintf("sudo transactional-update --non-interactive --continue pkg %s %s", packageAction, name)
+
+	log.Debugf("transactional-update: action=%s name=%s", action.String(), name)
+
+	if _, err := provisioner.SSHCommand(command); err != nil {
+		return err
+	}
+
+	provisioner.rebootRequired = true
+	return nil
+}
+
+// installDocker installs the docker package of the host, the install scripts
+// cannot write to its read-only root filesystem.
+func (provisioner *MicroOSProvisioner) installDocker() error {
+	installURL := provisioner.EngineOptions.InstallURL
+	if strings.EqualFold(installURL, "none") {
+		log.Info("Skipping Docker installation")
+		return nil
+	}
+	if drivers.EngineInstallURLSet(installURL) {
+		log.Warnf("Ignoring the engine install URL %s, installing the docker package of the transactional host", installURL)
+	}
+
+	return provisioner.Package("docker", pkgaction.Install)
+}
+
+// reboot reboots the host into the snapshot of the transactional updates, if
+// any.
+func (provisioner *MicroOSProvisioner) reboot() error {
+	if !provisioner.rebootRequired {
+		return nil
+	}
+
+	// ignore errors here because the SSH connection will close
+	provisioner.SSHCommand("sudo systemctl reboot")
+
+	log.Info("Rebooting into the new snapshot, waiting for the machine...")
+	if err := drivers.WaitForSSH(provisioner.Driver); err != nil {
+		return err
+	}
+	provisioner.rebootRequired = false
+	return nil
+}
+
+func (provisioner *MicroOSProvisioner) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	provisioner.SwarmOptions = swarmOptions
+	provisioner.AuthOptions = authOptions
+	provisioner.EngineOptions = engineOptions
+	swarmOptions.Env = engineOptions.Env
+
+	storageDriver, err := provisioner.storageDriver()
+	if err != nil {
+		return err
+	}
+	provisioner.EngineOptions.StorageDriver = storageDriver
+
+	log.Debug("Setting hostname")
+	if err := provisioner.SetHostname(provisioner.Driver.GetMachineName()); err != nil {
+		return err
+	}
+
+	log.Debug("Installing base packages")
+	for _, pkg := range provisioner.Packages {
+		if err := provisioner.Package(pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+
+	if err := provisioner.installDocker(); err != nil {
+		return err
+	}
+
+	if err := provisioner.reboot(); err != nil {
+		return err
+	}
+
+	if _, installed := provisioner.SSHCommand("rpm -q firewalld"); installed == nil {
+		log.Debug("Configuring SUSE firewall")
+		if err := provisioner.configureFirewall(); err != nil {
+			return err
+		}
+	}
+
+	log.Debug("Starting systemd docker service")
+	if err := provisioner.Service("docker", serviceaction.Start); err != nil {
+		return err
+	}
+
+	log.Debug("Waiting for docker daemon")
+	if err := mcnutils.WaitFor(provisioner.dockerDaemonResponding); err != nil {
+		return err
+	}
+
+	provisioner.AuthOptions = setRemoteAuthOptions(provisioner)
+
+	log.Debug("Configuring auth")
+	if err := ConfigureAuth(provisioner); err != nil {
+		return err
+	}
+
+	log.Debug("Configuring swarm")
+	if err := configureSwarm(provisioner, swarmOptions, provisioner.AuthOptions); err != nil {
+		return err
+	}
+
+	log.Debug("Enabling docker in systemd")
+	return provisioner.Service("docker", serviceaction.Enable)
+}
@@ -0,0 +1,67 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMicroOSCompatibleWithHost(t *testing.T) {
+	microOS := NewMicroOSProvisioner(&fakedriver.Driver{})
+	openSUSE := NewOpenSUSEProvisioner(&fakedriver.Driver{})
+
+	for _, info := range []*OsRelease{
+		{ID: "opensuse-microos", IDLike: "suse opensuse opensuse-tumbleweed microos"},
+		{ID: "sle-micro", IDLike: "suse"},
+		{ID: "sl-micro", IDLike: "suse"},
+	} {
+		microOS.SetOsReleaseInfo(info)
+		openSUSE.SetOsReleaseInfo(info)
+		assert.True(t, microOS.CompatibleWithHost(), info.ID)
+		assert.False(t, openSUSE.CompatibleWithHost(), info.ID)
+	}
+
+	for _, info := range []*OsRelease{
+		{ID: "opensuse-leap", IDLike: "suse opensuse"},
+		{ID: "sles", IDLike: "suse"},
+	} {
+		microOS.SetOsReleaseInfo(info)
+		assert.False(t, microOS.CompatibleWithHost(), info.ID)
+	}
+}
+
+func TestMicroOSPackage(t *testing.T) {
+	p := NewMicroOSProvisioner(&fakedriver.Driver{}).(*MicroOSProvisioner)
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"rpm -q curl": "curl-8.6.0-1.1.x86_64",
+			"sudo transactional-update --non-interactive --continue pkg install docker": "",
+		},
+	}
+
+	assert.NoError(t, p.Package("curl", pkgaction.Install))
+	assert.False(t, p.rebootRequired)
+
+	assert.NoError(t, p.Package("docker", pkgaction.Install))
+	assert.True(t, p.rebootRequired)
+}
+
+func TestMicroOSInstallDockerIgnoresInstallURL(t *testing.T) {
+	p := NewMicroOSProvisioner(&fakedriver.Driver{}).(*MicroOSProvisioner)
+	p.EngineOptions.InstallURL = "https://releases.rancher.com/install-docker/20.10.sh"
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"rpm -q docker": "docker-24.0.7-1.1.x86_64",
+		},
+	}
+
+	assert.NoError(t, p.installDocker())
+	assert.False(t, p.rebootRequired)
+
+	p.EngineOptions.InstallURL = "none"
+	p.SSHCommander = &provisiontest.FakeSSHCommander{}
+	assert.NoError(t, p.installDocker())
+}
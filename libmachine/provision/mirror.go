@@ -0,0 +1,87 @@
+package provision
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	// defaultDockerDownloadURL serves the Docker packages and GPG keys
+	// installed by get.docker.com and the docker-ce repositories
+	defaultDockerDownloadURL = "https://download.docker.com"
+
+	// packageMirrorCommand points the APT, YUM and Zypper repositories of
+	// the distribution at the mirror, keeping their paths, and disables the
+	// mirror lists of YUM
+	packageMirrorCommand = `for f in /etc/apt/sources.list /etc/apt/sources.list.d/ubuntu.sources /etc/apt/sources.list.d/debian.sources; do
+  if [ -f "$f" ]; then sudo sed -i -E 's#https?://[^/ ]+#%[1]s#g' "$f" || exit 1; fi
+done
+for f in $(ls /etc/yum.repos.d/*.repo /etc/zypp/repos.d/*.repo 2>/dev/null | grep -v docker-ce.repo); do
+  sudo sed -i -E -e 's#^(mirrorlist|metalink)=#\#\1=#' -e 's#^\#?baseurl=https?://[^/]+#baseurl=%[1]s#' "$f" || exit 1
+done`
+)
+
+// ValidateMirrorURL checks that mirror is the http or https URL of a mirror,
+// which the provisioners substitute to the scheme and host of repositories.
+func ValidateMirrorURL(mirror string) error {
+	return checkURL(mirror)
+}
+
+// checkURL checks that value is an http or https URL which can be quoted in
+// the commands and substituted by sed.
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", value)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || strings.ContainsAny(value, "'\"\\&# ") {
+		return fmt.Errorf("%q has a query, a fragment or special characters", value)
+	}
+	return nil
+}
+
+// configurePackageMirror points the repositories of the distribution at the
+// package mirror, before the provisioners install their packages.
+func configurePackageMirror(p Provisioner, mirror string) error {
+	mirror = strings.TrimSuffix(mirror, "/")
+
+	log.Infof("Using the package mirror %s", mirror)
+	if output, err := p.SSHCommand(fmt.Sprintf(packageMirrorCommand, mirror)); err != nil {
+		return fmt.Errorf("Error configuring the package mirror: %s", output)
+	}
+	return nil
+}
+
+// dockerDownloadURL returns the download URL of the Docker packages, that of
+// Docker unless another one is set.
+func dockerDownloadURL(downloadURL string) string {
+	if downloadURL == "" {
+		return defaultDockerDownloadURL
+	}
+	return strings.TrimSuffix(downloadURL, "/")
+}
+
+// addDockerCERepo adds the docker-ce repository of the download URL to the
+// RedHat hosts, its packages and GPG key downloaded from it as well.
+func addDockerCERepo(p Provisioner, command, downloadURL string) error {
+	downloadURL = dockerDownloadURL(downloadURL)
+	repo := downloadURL + dockerCERepoPath
+
+	log.Infof("Adding the Docker repository: %s", repo)
+	if _, err := p.SSHCommand(command + " " + repo); err != nil {
+		return fmt.Errorf("Error adding the Docker repository: %s", err)
+	}
+	if downloadURL == defaultDockerDownloadURL {
+		return nil
+	}
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo sed -i 's#%s#%s#g' /etc/yum.repos.d/docker-ce.repo", defaultDockerDownloadURL, downloadURL)); err != nil {
+		return fmt.Errorf("Error adding the Docker repository: %s", err)
+	}
+	return nil
+}
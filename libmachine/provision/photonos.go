@@ -180,7 +180,7 @@ func (provisioner *PhotonOSProvisioner) Provision(swarmOptions swarm.Options, au
 
 // configureFirewall sets up proper iptable rules
 func (provisioner *PhotonOSProvisioner) configureFirewall() error {
-	tcpPorts := "22 80 443 2376 2379 2380 6443 9099 9796 10250 10254 30000:32767"
+	tcpPorts := firewallTCPPorts(provisioner.EngineOptions)
 	udpPorts := "8472 30000:32767"
 	var cmds []string
 
@@ -0,0 +1,27 @@
+package provision
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhotonOSConfigureFirewallEnginePort(t *testing.T) {
+	p := NewPhotonOSProvisioner(&fakedriver.Driver{}).(*PhotonOSProvisioner)
+	p.EngineOptions.Port = 3376
+	responses := map[string]string{
+		"sudo sh -c 'iptables-save > /etc/systemd/scripts/ip4save'": "",
+	}
+	for _, port := range []string{"22", "80", "443", "3376", "2379", "2380", "6443", "9099", "9796", "10250", "10254", "30000:32767"} {
+		responses[fmt.Sprintf("sudo iptables -A INPUT -p tcp --dport %s -j ACCEPT", port)] = ""
+	}
+	for _, port := range []string{"8472", "30000:32767"} {
+		responses[fmt.Sprintf("sudo iptables -A INPUT -p udp --dport %s -j ACCEPT", port)] = ""
+	}
+	p.SSHCommander = &provisiontest.FakeSSHCommander{Responses: responses}
+
+	assert.NoError(t, p.configureFirewall())
+}
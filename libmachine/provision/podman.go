@@ -0,0 +1,165 @@
+package provision
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+)
+
+const (
+	podmanCertDir       = "/etc/containers/tls"
+	podmanRegistriesDir = "/etc/containers/registries.conf.d"
+	podmanEnvFile       = "/etc/systemd/system/podman.service.d/10-machine.conf"
+	// podmanTLSService serves the API socket of Podman with TLS, verifying
+	// the client certificates as dockerd does
+	podmanTLSService = "podman-tls.service"
+
+	podmanTLSUnitTemplate = `[Unit]
+Description=Podman API with TLS
+Requires=podman.socket
+After=podman.socket network-online.target
+
+[Service]
+ExecStart=/usr/bin/socat OPENSSL-LISTEN:{{.DockerPort}},reuseaddr,fork,cert={{.AuthOptions.ServerCertRemotePath}},key={{.AuthOptions.ServerKeyRemotePath}},cafile={{.AuthOptions.CaCertRemotePath}},verify=1 UNIX-CONNECT:/run/podman/podman.sock
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	podmanRegistriesTemplate = `{{- if .EngineOptions.RegistryMirror }}[[registry]]
+location = "docker.io"
+{{- range .EngineOptions.RegistryMirror }}
+
+[[registry.mirror]]
+location = "{{ trimScheme . }}"
+{{- end }}
+{{ end }}
+{{- range .EngineOptions.InsecureRegistry }}
+[[registry]]
+location = "{{.}}"
+insecure = true
+{{ end -}}
+`
+)
+
+// WithPodman provisions Podman rather than Docker, its Docker-compatible API
+// served with TLS on the engine port for the docker clients configured by
+// env and config.
+func WithPodman(provisioner Provisioner, authOptions auth.Options, engineOptions engine.Options) error {
+	driver := provisioner.GetDriver()
+
+	if err := provisioner.SetHostname(driver.GetMachineName()); err != nil {
+		return err
+	}
+
+	for _, pkg := range provisioner.GetPackages() {
+		if err := provisioner.Package(pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+
+	if err := installPodman(provisioner, engineOptions.InstallURL); err != nil {
+		return err
+	}
+
+	authOptions = podmanAuthOptions(authOptions)
+	if err := generateServerCert(driver, authOptions, provisioner.GetSwarmOptions()); err != nil {
+		return err
+	}
+	if _, err := provisioner.SSHCommand("sudo mkdir -p " + podmanCertDir); err != nil {
+		return err
+	}
+	if err := copyServerCerts(provisioner, authOptions); err != nil {
+		return err
+	}
+
+	context := EngineConfigContext{
+		DockerPort:    engineOptions.DockerPort(),
+		AuthOptions:   authOptions,
+		EngineOptions: engineOptions,
+	}
+	unit, err := executeTemplate(podmanTLSUnitTemplate, context)
+	if err != nil {
+		return err
+	}
+	registries, err := executeTemplate(podmanRegistriesTemplate, context)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Setting Podman configuration on the remote daemon...")
+
+	files := map[string]string{path.Join("/etc/systemd/system", podmanTLSService): unit}
+	if strings.TrimSpace(registries) != "" {
+		files[path.Join(podmanRegistriesDir, "10-machine.conf")] = registries
+	}
+	if len(engineOptions.Env) > 0 {
+		files[podmanEnvFile] = fmt.Sprintf("[Service]\nEnvironment=%s\n", strings.Join(engineOptions.Env, " "))
+	}
+	for file, content := range files {
+		if _, err := provisioner.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s <<'EOF'\n%sEOF", path.Dir(file), file, content)); err != nil {
+			return err
+		}
+	}
+
+	for _, service := range []string{"podman.socket", podmanTLSService} {
+		if err := provisioner.Service(service, serviceaction.Restart); err != nil {
+			return err
+		}
+		if err := provisioner.Service(service, serviceaction.Enable); err != nil {
+			return err
+		}
+	}
+
+	return WaitForDocker(provisioner, engineOptions.DockerPort())
+}
+
+// UpgradePodman upgrades the podman package of the host and restarts its API.
+func UpgradePodman(provisioner Provisioner) error {
+	log.Info("Upgrading podman...")
+	if err := provisioner.Package("podman", pkgaction.Upgrade); err != nil {
+		return err
+	}
+
+	log.Info("Restarting podman...")
+	return provisioner.Service("podman.socket", serviceaction.Restart)
+}
+
+// installPodman installs the podman package of the host, and socat serving
+// its API with TLS, unless the install URL is none.
+func installPodman(provisioner Provisioner, installURL string) error {
+	if strings.EqualFold(installURL, "none") {
+		log.Info("Skipping Podman installation")
+		return nil
+	}
+
+	for _, pkg := range []string{"podman", "socat"} {
+		if _, err := provisioner.SSHCommand("type " + pkg); err == nil {
+			log.Debugf("%s is already installed, skipping installation", pkg)
+			continue
+		}
+		log.Infof("Installing %s from the package of the host", pkg)
+		if err := provisioner.Package(pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podmanAuthOptions returns authOptions with the remote paths of the
+// certificates in the TLS directory of Podman.
+func podmanAuthOptions(authOptions auth.Options) auth.Options {
+	// due to windows clients, we cannot use filepath.Join as the paths
+	// will be mucked on the linux hosts
+	authOptions.CaCertRemotePath = path.Join(podmanCertDir, "ca.pem")
+	authOptions.ServerCertRemotePath = path.Join(podmanCertDir, "server.pem")
+	authOptions.ServerKeyRemotePath = path.Join(podmanCertDir, "server-key.pem")
+	return authOptions
+}
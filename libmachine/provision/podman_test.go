@@ -0,0 +1,57 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodmanTLSUnit(t *testing.T) {
+	unit, err := executeTemplate(podmanTLSUnitTemplate, EngineConfigContext{
+		DockerPort:  2376,
+		AuthOptions: podmanAuthOptions(auth.Options{}),
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, unit, "ExecStart=/usr/bin/socat OPENSSL-LISTEN:2376,reuseaddr,fork,cert=/etc/containers/tls/server.pem,key=/etc/containers/tls/server-key.pem,cafile=/etc/containers/tls/ca.pem,verify=1 UNIX-CONNECT:/run/podman/podman.sock")
+}
+
+func TestPodmanRegistries(t *testing.T) {
+	registries, err := executeTemplate(podmanRegistriesTemplate, EngineConfigContext{})
+	assert.NoError(t, err)
+	assert.Empty(t, registries)
+
+	registries, err = executeTemplate(podmanRegistriesTemplate, EngineConfigContext{
+		EngineOptions: engine.Options{
+			RegistryMirror:   []string{"https://mirror.example.com"},
+			InsecureRegistry: []string{"registry.local:5000"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `[[registry]]
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+
+[[registry]]
+location = "registry.local:5000"
+insecure = true
+`, registries)
+}
+
+func TestInstallPodman(t *testing.T) {
+	p := NewRedHatProvisioner("almalinux", &fakedriver.Driver{})
+	p.SetOsReleaseInfo(&OsRelease{ID: "almalinux", VersionID: "9.3"})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"type podman":                  "podman is /usr/bin/podman",
+			"sudo -E dnf install -y socat": "",
+		},
+	}
+
+	assert.NoError(t, installPodman(p, "https://get.docker.com"))
+}
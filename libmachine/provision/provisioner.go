@@ -1,6 +1,7 @@
 package provision
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/rancher/machine/libmachine/auth"
@@ -54,6 +55,9 @@ type Provisioner interface {
 	// Get the swarm options associated with this host.
 	GetSwarmOptions() swarm.Options
 
+	// Get the engine options the daemon is configured with.
+	GetEngineOptions() engine.Options
+
 	// Run a package action e.g. install
 	Package(name string, action pkgaction.PackageAction) error
 
@@ -105,21 +109,49 @@ func DetectProvisioner(d drivers.Driver) (Provisioner, error) {
 }
 
 func (detector StandardDetector) DetectProvisioner(d drivers.Driver) (Provisioner, error) {
-	log.Info("Waiting for SSH to be available...")
-	if err := drivers.WaitForSSH(d); err != nil {
+	winrmOptions, err := drivers.GetWinRMOptions(d)
+	if err != nil {
 		return nil, err
 	}
 
-	log.Info("Detecting the provisioner...")
+	var osReleaseInfo *OsRelease
+	if winrmOptions != nil {
+		log.Info("Waiting for WinRM to be available...")
+		if err := drivers.WaitForWinRM(d); err != nil {
+			return nil, err
+		}
 
-	osReleaseOut, err := drivers.RunSSHCommandFromDriver(d, "cat /etc/os-release")
-	if err != nil {
-		return nil, fmt.Errorf("Error getting SSH command: %s", err)
-	}
+		log.Info("Detecting the provisioner...")
 
-	osReleaseInfo, err := NewOsRelease([]byte(osReleaseOut))
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing /etc/os-release file: %s", err)
+		verOut, err := drivers.RunWinRMCommandFromDriver(d, "ver")
+		if err != nil {
+			return nil, fmt.Errorf("Error getting WinRM command: %s", err)
+		}
+
+		if osReleaseInfo, err = windowsOsRelease(verOut); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Info("Waiting for SSH to be available...")
+		if err := drivers.WaitForSSH(d); err != nil {
+			return nil, err
+		}
+
+		log.Info("Detecting the provisioner...")
+
+		osReleaseOut, err := drivers.RunSSHCommandFromDriver(d, "cat /etc/os-release")
+		if err != nil {
+			// Windows hosts running OpenSSH have no /etc/os-release
+			verOut, verErr := drivers.RunSSHCommandFromDriver(d, "cmd /c ver")
+			if verErr != nil {
+				return nil, fmt.Errorf("Error getting SSH command: %s", err)
+			}
+			if osReleaseInfo, err = windowsOsRelease(verOut); err != nil {
+				return nil, err
+			}
+		} else if osReleaseInfo, err = NewOsRelease([]byte(osReleaseOut)); err != nil {
+			return nil, fmt.Errorf("Error parsing /etc/os-release file: %s", err)
+		}
 	}
 
 	for _, p := range provisioners {
@@ -134,3 +166,110 @@ func (detector StandardDetector) DetectProvisioner(d drivers.Driver) (Provisione
 
 	return nil, ErrDetectionFailed
 }
+
+// ProvisionEngine provisions the container runtime of engineOptions, Docker
+// with the provisioner of the host by default.
+func ProvisionEngine(p Provisioner, swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	_, windows := p.(*WindowsProvisioner)
+	if !windows {
+		if hasProxy(engineOptions) {
+			if err := configureProxy(p, engineOptions); err != nil {
+				return err
+			}
+		}
+		if engineOptions.PackageMirror != "" {
+			if err := configurePackageMirror(p, engineOptions.PackageMirror); err != nil {
+				return err
+			}
+		}
+		if err := installRegistryCAs(p, engineOptions); err != nil {
+			return err
+		}
+		var err error
+		if engineOptions, err = configureSecurityModules(p, engineOptions); err != nil {
+			return err
+		}
+		if hasHostTuning(engineOptions) {
+			if err := tuneHost(p, engineOptions); err != nil {
+				return err
+			}
+		}
+	}
+	engineOptions = withProxyUnitEnv(engineOptions)
+
+	var err error
+	switch engineOptions.Runtime {
+	case engine.RuntimeContainerd:
+		log.Info("Provisioning containerd, not installing Docker...")
+		err = WithContainerd(p, authOptions, engineOptions)
+	case engine.RuntimePodman:
+		log.Info("Provisioning Podman, not installing Docker...")
+		err = WithPodman(p, authOptions, engineOptions)
+	default:
+		err = provisionDocker(p, swarmOptions, authOptions, engineOptions)
+	}
+	if err != nil || windows {
+		return err
+	}
+
+	if err := loginRegistries(p, engineOptions); err != nil {
+		return err
+	}
+	return verifyRegistryMirrors(p, engineOptions)
+}
+
+// provisionDocker provisions Docker with the provisioner of the host, from
+// the engine bundle if set, with the nvidia runtime if requested, pins its
+// version and switches it to rootless mode if requested.
+func provisionDocker(p Provisioner, swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	if engineOptions.GPURuntime {
+		if _, windows := p.(*WindowsProvisioner); windows {
+			return errors.New("the GPU runtime is not supported on Windows")
+		}
+		var err error
+		if engineOptions, err = withNvidiaRuntime(engineOptions); err != nil {
+			return err
+		}
+	}
+
+	if engineOptions.InstallTarball != "" {
+		if err := installEngineBundle(p, engineOptions.InstallTarball); err != nil {
+			return err
+		}
+		// the provisioners skip the installation
+		engineOptions.InstallURL = "none"
+	}
+	if err := p.Provision(swarmOptions, authOptions, engineOptions); err != nil {
+		return err
+	}
+
+	if engineOptions.GPURuntime {
+		if err := installNvidiaToolkit(p, engineOptions); err != nil {
+			return err
+		}
+	}
+	if engineOptions.Version != "" {
+		if err := pinEngineVersion(p, engineOptions.Version); err != nil {
+			return err
+		}
+	}
+	if engineOptions.RootlessUser != "" {
+		return WithRootless(p, authOptions, engineOptions)
+	}
+	if engineOptions.GPURuntime {
+		return verifyGPURuntime(p)
+	}
+	return nil
+}
+
+// UpgradeEngine upgrades the container runtime of engineOptions, for the
+// machines not running Docker.
+func UpgradeEngine(p Provisioner, engineOptions engine.Options) error {
+	switch engineOptions.Runtime {
+	case engine.RuntimeContainerd:
+		return UpgradeContainerd(p)
+	case engine.RuntimePodman:
+		return UpgradePodman(p)
+	}
+	return fmt.Errorf("unknown engine runtime %q", engineOptions.Runtime)
+}
@@ -0,0 +1,125 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	// proxyConfigTemplate has APT, YUM and Zypper download through the
+	// proxies, YUM supporting a single one for all the repositories
+	proxyConfigTemplate = `if [ -d /etc/apt/apt.conf.d ]; then
+  sudo tee /etc/apt/apt.conf.d/95machine-proxy >/dev/null <<'EOF' || exit 1
+{{- with .EngineOptions.HTTPProxy }}
+Acquire::http::Proxy "{{.}}";
+{{- end }}
+{{- with .EngineOptions.HTTPSProxy }}
+Acquire::https::Proxy "{{.}}";
+{{- end }}
+EOF
+fi
+for f in /etc/dnf/dnf.conf /etc/yum.conf; do
+  if [ -f "$f" ]; then sudo sed -i -e '/^proxy=/d' -e 's#^\[main\]#[main]\nproxy={{ or .EngineOptions.HTTPSProxy .EngineOptions.HTTPProxy }}#' "$f" || exit 1; fi
+done
+if type zypper >/dev/null 2>&1; then
+  sudo tee /etc/sysconfig/proxy >/dev/null <<'EOF' || exit 1
+PROXY_ENABLED="yes"
+HTTP_PROXY="{{.EngineOptions.HTTPProxy}}"
+HTTPS_PROXY="{{.EngineOptions.HTTPSProxy}}"
+NO_PROXY="{{.EngineOptions.NoProxy}}"
+EOF
+fi`
+)
+
+// ValidateProxyURL checks that proxy is the http or https URL of a proxy.
+func ValidateProxyURL(proxy string) error {
+	return checkURL(proxy)
+}
+
+// ValidateNoProxy checks that noProxy is a comma-separated list of hosts,
+// domains or CIDRs which bypass the proxies.
+func ValidateNoProxy(noProxy string) error {
+	for _, host := range strings.Split(noProxy, ",") {
+		if host == "" || strings.ContainsAny(host, "'\"\\ \t") {
+			return fmt.Errorf("%q is not a comma-separated list of hosts", noProxy)
+		}
+	}
+	return nil
+}
+
+// hasProxy tells whether the engine options set a proxy.
+func hasProxy(engineOptions engine.Options) bool {
+	return engineOptions.HTTPProxy != "" || engineOptions.HTTPSProxy != ""
+}
+
+// proxyEnv returns the proxy variables of the engine options, in upper and
+// lower case as the tools read either.
+func proxyEnv(engineOptions engine.Options) []string {
+	env := []string{}
+	for _, variable := range []struct{ name, value string }{
+		{"HTTP_PROXY", engineOptions.HTTPProxy},
+		{"HTTPS_PROXY", engineOptions.HTTPSProxy},
+		{"NO_PROXY", engineOptions.NoProxy},
+	} {
+		if variable.value != "" {
+			env = append(env, variable.name+"="+variable.value, strings.ToLower(variable.name)+"="+variable.value)
+		}
+	}
+	return env
+}
+
+// withProxyEnv prefixes command with the export of the proxy variables of the
+// engine options, for the downloads of the command and the sudo -E commands
+// it runs.
+func withProxyEnv(engineOptions engine.Options, command string) string {
+	env := proxyEnv(engineOptions)
+	if len(env) == 0 {
+		return command
+	}
+	for i, variable := range env {
+		name := strings.SplitN(variable, "=", 2)
+		env[i] = fmt.Sprintf("%s='%s'", name[0], name[1])
+	}
+	return fmt.Sprintf("export %s; %s", strings.Join(env, " "), command)
+}
+
+// withProxyUnitEnv returns the engine options with the proxy variables added
+// to the environment of the engine unit, unless already set.
+func withProxyUnitEnv(engineOptions engine.Options) engine.Options {
+	env := append([]string{}, engineOptions.Env...)
+	for _, variable := range proxyEnv(engineOptions) {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if name != strings.ToUpper(name) {
+			continue
+		}
+		set := false
+		for _, existing := range engineOptions.Env {
+			if strings.HasPrefix(existing, name+"=") {
+				set = true
+			}
+		}
+		if !set {
+			env = append(env, variable)
+		}
+	}
+	engineOptions.Env = env
+	return engineOptions
+}
+
+// configureProxy has the package managers of the host download through the
+// proxies of the engine options.
+func configureProxy(p Provisioner, engineOptions engine.Options) error {
+	command, err := executeTemplate(proxyConfigTemplate, EngineConfigContext{EngineOptions: engineOptions})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Configuring the package manager to use the proxy %s", strings.TrimSpace(engineOptions.HTTPSProxy+" "+engineOptions.HTTPProxy))
+	if output, err := p.SSHCommand(command); err != nil {
+		return fmt.Errorf("Error configuring the proxy: %s", output)
+	}
+	return nil
+}
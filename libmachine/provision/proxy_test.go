@@ -0,0 +1,60 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNoProxy(t *testing.T) {
+	assert.NoError(t, ValidateNoProxy("localhost,127.0.0.1,.corp.local,10.0.0.0/8"))
+	assert.Error(t, ValidateNoProxy("localhost,,corp.local"))
+	assert.Error(t, ValidateNoProxy("localhost, corp.local"))
+}
+
+func TestWithProxyEnv(t *testing.T) {
+	assert.Equal(t, "type docker", withProxyEnv(engine.Options{}, "type docker"))
+	assert.Equal(t,
+		"export HTTPS_PROXY='http://proxy:3128' https_proxy='http://proxy:3128' NO_PROXY='localhost' no_proxy='localhost'; type docker",
+		withProxyEnv(engine.Options{HTTPSProxy: "http://proxy:3128", NoProxy: "localhost"}, "type docker"))
+}
+
+func TestWithProxyUnitEnv(t *testing.T) {
+	engineOptions := engine.Options{
+		Env:        []string{"HTTP_PROXY=http://other:8080", "TZ=UTC"},
+		HTTPProxy:  "http://proxy:3128",
+		HTTPSProxy: "http://proxy:3128",
+	}
+
+	assert.Equal(t, []string{"HTTP_PROXY=http://other:8080", "TZ=UTC", "HTTPS_PROXY=http://proxy:3128"}, withProxyUnitEnv(engineOptions).Env)
+	assert.Equal(t, []string{"HTTP_PROXY=http://other:8080", "TZ=UTC"}, engineOptions.Env)
+}
+
+func TestConfigureProxy(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			`if [ -d /etc/apt/apt.conf.d ]; then
+  sudo tee /etc/apt/apt.conf.d/95machine-proxy >/dev/null <<'EOF' || exit 1
+Acquire::http::Proxy "http://proxy:3128";
+EOF
+fi
+for f in /etc/dnf/dnf.conf /etc/yum.conf; do
+  if [ -f "$f" ]; then sudo sed -i -e '/^proxy=/d' -e 's#^\[main\]#[main]\nproxy=http://proxy:3128#' "$f" || exit 1; fi
+done
+if type zypper >/dev/null 2>&1; then
+  sudo tee /etc/sysconfig/proxy >/dev/null <<'EOF' || exit 1
+PROXY_ENABLED="yes"
+HTTP_PROXY="http://proxy:3128"
+HTTPS_PROXY=""
+NO_PROXY="localhost"
+EOF
+fi`: "",
+		},
+	}
+
+	assert.NoError(t, configureProxy(p, engine.Options{HTTPProxy: "http://proxy:3128", NoProxy: "localhost"}))
+}
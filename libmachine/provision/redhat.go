@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"text/template"
 
 	"github.com/rancher/machine/libmachine/auth"
@@ -27,6 +28,18 @@ Environment={{range .EngineOptions.Env}}{{ printf "%q" . }} {{end}}
 	majorVersionRE = regexp.MustCompile(`^(\d+)(\..*)?`)
 )
 
+const (
+	// dockerCERepo is the Docker repository of the RHEL 8 and later hosts,
+	// which get.docker.com does not support for all the clones
+	dockerCERepo = defaultDockerDownloadURL + dockerCERepoPath
+	// dockerCERepoPath is the path of the repository file on the download
+	// URLs
+	dockerCERepoPath = "/linux/centos/docker-ce.repo"
+
+	// dnfMajorVersion is the first major version replacing yum with dnf
+	dnfMajorVersion = 8
+)
+
 type PackageListInfo struct {
 	OsRelease        string
 	OsReleaseVersion string
@@ -92,7 +105,7 @@ func (provisioner *RedHatProvisioner) Package(name string, action pkgaction.Pack
 		packageAction = "upgrade"
 	}
 
-	command := fmt.Sprintf("sudo -E yum %s -y %s", packageAction, name)
+	command := fmt.Sprintf("sudo -E %s %s -y %s", provisioner.packageManager(), packageAction, name)
 
 	if _, err := provisioner.SSHCommand(command); err != nil {
 		return err
@@ -101,6 +114,62 @@ func (provisioner *RedHatProvisioner) Package(name string, action pkgaction.Pack
 	return nil
 }
 
+// majorVersion returns the major version of the host, 0 if unknown.
+func (provisioner *RedHatProvisioner) majorVersion() int {
+	if provisioner.OsReleaseInfo == nil {
+		return 0
+	}
+	matches := majorVersionRE.FindStringSubmatch(provisioner.OsReleaseInfo.VersionID)
+	if matches == nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(matches[1])
+	return major
+}
+
+// packageManager returns dnf on RHEL 8 and later and their clones, yum on
+// the older hosts.
+func (provisioner *RedHatProvisioner) packageManager() string {
+	if provisioner.majorVersion() >= dnfMajorVersion {
+		return "dnf"
+	}
+	return "yum"
+}
+
+// installDocker installs Docker with get.docker.com on the older hosts and
+// from the docker-ce repository with dnf on RHEL 8 and later, Rocky Linux
+// and AlmaLinux, unless another --engine-install-url is set.
+func (provisioner *RedHatProvisioner) installDocker() error {
+	installURL := provisioner.EngineOptions.InstallURL
+	if provisioner.majorVersion() < dnfMajorVersion || drivers.EngineInstallURLSet(installURL) {
+		return installDockerGeneric(provisioner, provisioner.EngineOptions)
+	}
+	if _, err := provisioner.SSHCommand("type docker"); err == nil {
+		log.Info("Docker is already installed, skipping installation")
+		return nil
+	}
+
+	log.Info("Installing Docker from the docker-ce repository")
+	for _, pkg := range []string{"container-selinux", "dnf-plugins-core"} {
+		if err := provisioner.Package(pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+	if err := addDockerCERepo(provisioner, "sudo dnf config-manager --add-repo", provisioner.EngineOptions.DownloadURL); err != nil {
+		return err
+	}
+	// containerd.io ships the runc required by Docker, replacing the older
+	// one of the host which podman may depend on
+	packages := "docker-ce docker-ce-cli"
+	if version := provisioner.EngineOptions.Version; version != "" {
+		packages = fmt.Sprintf("'docker-ce-%s*' 'docker-ce-cli-%s*'", version, version)
+	}
+	if output, err := provisioner.SSHCommand(fmt.Sprintf("sudo -E dnf install -y --allowerasing %s containerd.io", packages)); err != nil {
+		return fmt.Errorf("Error installing Docker: %s", output)
+	}
+	return nil
+}
+
 func (provisioner *RedHatProvisioner) dockerDaemonResponding() bool {
 	log.Debug("checking docker daemon")
 
@@ -163,7 +232,7 @@ func (provisioner *RedHatProvisioner) Provision(swarmOptions swarm.Options, auth
 		}
 	}
 
-	if err := installDockerGeneric(provisioner, provisioner.EngineOptions.InstallURL); err != nil {
+	if err := provisioner.installDocker(); err != nil {
 		return err
 	} else if err == nil {
 		if err := provisioner.Service("docker", serviceaction.Restart); err != nil {
@@ -5,9 +5,11 @@ import (
 
 	"github.com/rancher/machine/drivers/fakedriver"
 	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/engine"
 	"github.com/rancher/machine/libmachine/provision/provisiontest"
 	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRedHatDefaultStorageDriver(t *testing.T) {
@@ -18,3 +20,49 @@ func TestRedHatDefaultStorageDriver(t *testing.T) {
 		t.Fatalf("Default storage driver should be %s", DefaultStorageDriver)
 	}
 }
+
+func TestRedHatPackageManager(t *testing.T) {
+	for versionID, expected := range map[string]string{"7.9": "yum", "8.4": "dnf", "9": "dnf", "": "yum"} {
+		p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+		p.SetOsReleaseInfo(&OsRelease{ID: "rhel", VersionID: versionID})
+		assert.Equal(t, expected, p.packageManager(), versionID)
+	}
+}
+
+func TestRedHatInstallDockerFromRepository(t *testing.T) {
+	p := NewRedHatProvisioner("rocky", &fakedriver.Driver{})
+	p.SetOsReleaseInfo(&OsRelease{ID: "rocky", VersionID: "9.3"})
+	p.EngineOptions.InstallURL = drivers.DefaultEngineInstallURL
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"sudo -E dnf install -y container-selinux":                                    "",
+			"sudo -E dnf install -y dnf-plugins-core":                                     "",
+			"sudo dnf config-manager --add-repo " + dockerCERepo:                          "",
+			"sudo -E dnf install -y --allowerasing docker-ce docker-ce-cli containerd.io": "",
+		},
+	}
+
+	assert.NoError(t, p.installDocker())
+}
+
+func TestRedHatInstallDockerWithInstallURL(t *testing.T) {
+	p := NewRedHatProvisioner("almalinux", &fakedriver.Driver{})
+	p.SetOsReleaseInfo(&OsRelease{ID: "almalinux", VersionID: "9.3"})
+	p.EngineOptions.InstallURL = "https://example.com/install.sh"
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"if ! type docker; then curl -sSL https://example.com/install.sh | sh -; fi": "",
+		},
+	}
+
+	assert.NoError(t, p.installDocker())
+}
+
+func TestAlmaLinuxCompatibleWithHost(t *testing.T) {
+	p := NewAlmaLinuxProvisioner(&fakedriver.Driver{})
+	p.SetOsReleaseInfo(&OsRelease{ID: "almalinux", IDLike: "rhel centos fedora", VersionID: "9.3"})
+	assert.True(t, p.CompatibleWithHost())
+
+	p.SetOsReleaseInfo(&OsRelease{ID: "rocky", IDLike: "rhel centos fedora", VersionID: "9.3"})
+	assert.False(t, p.CompatibleWithHost())
+}
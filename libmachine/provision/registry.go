@@ -0,0 +1,190 @@
+package provision
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// registryCertDirs are the directories the engines read the CA certificates
+// of the registries from, in a subdirectory per registry host
+var registryCertDirs = map[string]string{
+	engine.RuntimeDocker:     "/etc/docker/certs.d",
+	engine.RuntimeContainerd: "/etc/containerd/certs.d",
+	engine.RuntimePodman:     "/etc/containers/certs.d",
+}
+
+// ParseRegistryCAs parses the host=file CA certificates of registries, the
+// files made absolute as they are read again at provision time.
+func ParseRegistryCAs(values []string) (map[string]string, error) {
+	cas := map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || checkRegistryHost(kv[0]) != nil || kv[1] == "" {
+			return nil, fmt.Errorf("invalid registry CA %q, expected host=file", value)
+		}
+
+		data, err := ioutil.ReadFile(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s is not a PEM certificate", kv[1])
+		}
+		if cas[kv[0]], err = filepath.Abs(kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return cas, nil
+}
+
+// ParseRegistryAuth parses the host=username:password credentials of
+// registries.
+func ParseRegistryAuth(values []string) (map[string]string, error) {
+	credentials := map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || checkRegistryHost(kv[0]) != nil || !strings.Contains(kv[1], ":") || strings.HasPrefix(kv[1], ":") {
+			return nil, fmt.Errorf("invalid registry credentials for %q, expected host=username:password", kv[0])
+		}
+		credentials[kv[0]] = kv[1]
+	}
+	return credentials, nil
+}
+
+// checkRegistryHost checks that host is a registry host, with its port if
+// not the default one.
+func checkRegistryHost(host string) error {
+	if host == "" || strings.ContainsAny(host, "/'\"\\ \t") {
+		return fmt.Errorf("invalid registry host %q", host)
+	}
+	return nil
+}
+
+// registryHost returns the host of the registry or mirror URL.
+func registryHost(registry string) string {
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.SplitN(registry, "/", 2)[0]
+}
+
+// registryCAPath returns the remote path of the CA certificate of the
+// registry host for the runtime.
+func registryCAPath(runtime, host string) string {
+	dir, ok := registryCertDirs[runtime]
+	if !ok {
+		dir = registryCertDirs[engine.RuntimeDocker]
+	}
+	return path.Join(dir, host, "ca.crt")
+}
+
+// installRegistryCAs copies the CA certificates of the registries to the
+// certs.d directory of the runtime, which the engines trust them from.
+func installRegistryCAs(p Provisioner, engineOptions engine.Options) error {
+	for _, host := range sortedKeys(engineOptions.RegistryCA) {
+		data, err := ioutil.ReadFile(engineOptions.RegistryCA[host])
+		if err != nil {
+			return err
+		}
+
+		remote := registryCAPath(engineOptions.Runtime, host)
+		log.Infof("Trusting the CA of the registry %s: %s", host, remote)
+		if _, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s >/dev/null <<'EOF'\n%s\nEOF", path.Dir(remote), remote, strings.TrimSpace(string(data)))); err != nil {
+			return fmt.Errorf("Error installing the CA of the registry %s: %s", host, err)
+		}
+	}
+	return nil
+}
+
+// loginRegistries logs the clients of the engine of the machine in to the
+// registries with credentials, those of the rootless user for rootless
+// Docker. dockerd does not authenticate to its mirrors, the credentials are
+// those of the pulls of the clients of the machine. containerd is configured
+// with them instead.
+func loginRegistries(p Provisioner, engineOptions engine.Options) error {
+	var login string
+	switch engineOptions.Runtime {
+	case engine.RuntimeContainerd:
+		return nil
+	case engine.RuntimePodman:
+		// podman reads the credentials of the root user from there
+		login = "sudo podman login --authfile /root/.config/containers/auth.json"
+	case engine.RuntimeDocker, "":
+		if user := engineOptions.RootlessUser; user != "" {
+			login = fmt.Sprintf("sudo -H -u %[1]s env DOCKER_HOST=unix:///run/user/$(id -u %[1]s)/docker.sock docker login", user)
+			break
+		}
+		fallthrough
+	default:
+		login = "sudo docker login"
+	}
+
+	for _, host := range sortedKeys(engineOptions.RegistryAuth) {
+		credentials := strings.SplitN(engineOptions.RegistryAuth[host], ":", 2)
+		log.Infof("Logging in to the registry %s as %s", host, credentials[0])
+		if output, err := p.SSHCommand(fmt.Sprintf("printf '%%s' %s | %s --username %s --password-stdin %s", shQuote(credentials[1]), login, shQuote(credentials[0]), host)); err != nil {
+			return fmt.Errorf("Error logging in to the registry %s: %s", host, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// verifyRegistryMirrors checks that the registry mirrors answer the registry
+// API from the machine, through its proxies and with the CA and credentials
+// of their host.
+func verifyRegistryMirrors(p Provisioner, engineOptions engine.Options) error {
+	if len(engineOptions.RegistryMirror) == 0 {
+		return nil
+	}
+	if _, err := p.SSHCommand("type curl"); err != nil {
+		log.Warn("curl is not installed on the machine, not verifying the registry mirrors")
+		return nil
+	}
+
+	for _, mirror := range engineOptions.RegistryMirror {
+		host := registryHost(mirror)
+		args := []string{"-sS", "-o /dev/null", "-w '%{http_code}'", "--max-time 30"}
+		if _, ok := engineOptions.RegistryCA[host]; ok {
+			args = append(args, "--cacert "+registryCAPath(engineOptions.Runtime, host))
+		}
+		_, authenticated := engineOptions.RegistryAuth[host]
+		if authenticated {
+			args = append(args, "-u "+shQuote(engineOptions.RegistryAuth[host]))
+		}
+
+		log.Infof("Verifying the registry mirror %s", mirror)
+		command := fmt.Sprintf("sudo -E curl %s %s/v2/", strings.Join(args, " "), strings.TrimSuffix(mirror, "/"))
+		output, err := p.SSHCommand(withProxyEnv(engineOptions, command))
+		if err != nil {
+			return fmt.Errorf("Error reaching the registry mirror %s from the machine: %s", mirror, strings.TrimSpace(output))
+		}
+
+		// without credentials, the mirrors requiring them answer 401
+		switch status := strings.TrimSpace(output); {
+		case status == "200":
+		case status == "401" && !authenticated:
+			log.Warnf("The registry mirror %s requires authentication, set it with --engine-registry-auth", mirror)
+		default:
+			return fmt.Errorf("Error verifying the registry mirror %s: its registry API answered HTTP %s", mirror, status)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
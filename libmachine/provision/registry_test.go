@@ -0,0 +1,117 @@
+package provision
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRegistryCA = `-----BEGIN CERTIFICATE-----
+MIIBfTCCASOgAwIBAgIUCA==
+-----END CERTIFICATE-----`
+
+func writeTestRegistryCA(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(testRegistryCA+"\n"), 0600))
+	return file
+}
+
+func TestParseRegistryCAs(t *testing.T) {
+	file := writeTestRegistryCA(t)
+
+	cas, err := ParseRegistryCAs([]string{"registry.local:5000=" + file})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"registry.local:5000": file}, cas)
+
+	_, err = ParseRegistryCAs([]string{file})
+	assert.Error(t, err)
+	_, err = ParseRegistryCAs([]string{"https://registry.local=" + file})
+	assert.Error(t, err)
+
+	notPEM := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	_, err = ParseRegistryCAs([]string{"registry.local=" + notPEM})
+	assert.EqualError(t, err, notPEM+" is not a PEM certificate")
+}
+
+func TestParseRegistryAuth(t *testing.T) {
+	credentials, err := ParseRegistryAuth([]string{"mirror.local=user:pass:word"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"mirror.local": "user:pass:word"}, credentials)
+
+	for _, value := range []string{"mirror.local", "mirror.local=user", "mirror.local=:password", "=user:password"} {
+		_, err := ParseRegistryAuth([]string{value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	assert.Equal(t, "mirror.local:5000", registryHost("https://mirror.local:5000/v2"))
+	assert.Equal(t, "registry.local", registryHost("registry.local/library"))
+}
+
+func TestInstallRegistryCAs(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"sudo mkdir -p /etc/containers/certs.d/registry.local && sudo tee /etc/containers/certs.d/registry.local/ca.crt >/dev/null <<'EOF'\n" + testRegistryCA + "\nEOF": "",
+		},
+	}
+
+	assert.NoError(t, installRegistryCAs(p, engine.Options{
+		Runtime:    engine.RuntimePodman,
+		RegistryCA: map[string]string{"registry.local": writeTestRegistryCA(t)},
+	}))
+}
+
+func TestLoginRegistries(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			`printf '%s' 'it'\''s' | sudo docker login --username 'user' --password-stdin mirror.local`: "Login Succeeded",
+		},
+	}
+
+	assert.NoError(t, loginRegistries(p, engine.Options{RegistryAuth: map[string]string{"mirror.local": "user:it's"}}))
+	assert.NoError(t, loginRegistries(p, engine.Options{Runtime: engine.RuntimeContainerd, RegistryAuth: map[string]string{"other.local": "user:password"}}))
+}
+
+func TestVerifyRegistryMirrors(t *testing.T) {
+	engineOptions := engine.Options{
+		RegistryMirror: []string{"https://mirror.local/"},
+		RegistryCA:     map[string]string{"mirror.local": "/tmp/ca.crt"},
+	}
+	command := "sudo -E curl -sS -o /dev/null -w '%{http_code}' --max-time 30 --cacert /etc/docker/certs.d/mirror.local/ca.crt https://mirror.local/v2/"
+
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	for _, tc := range []struct {
+		status   string
+		expected string
+	}{
+		{"200", ""},
+		{"401", ""},
+		{"503", "Error verifying the registry mirror https://mirror.local/: its registry API answered HTTP 503"},
+	} {
+		p.SSHCommander = &provisiontest.FakeSSHCommander{
+			Responses: map[string]string{
+				"type curl": "curl is /usr/bin/curl",
+				command:     tc.status,
+			},
+		}
+
+		err := verifyRegistryMirrors(p, engineOptions)
+		if tc.expected == "" {
+			assert.NoError(t, err, tc.status)
+		} else {
+			assert.EqualError(t, err, tc.expected)
+		}
+	}
+
+	p.SSHCommander = &provisiontest.FakeSSHCommander{}
+	assert.NoError(t, verifyRegistryMirrors(p, engineOptions))
+}
@@ -0,0 +1,228 @@
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+)
+
+const (
+	// rootlessUserCommand creates the rootless user with subordinate ids
+	// after those of the other users, and has its systemd instance run
+	// without a login session
+	rootlessUserCommand = `id -u %[1]s >/dev/null 2>&1 || sudo useradd -m -s /bin/bash %[1]s || exit 1
+for f in /etc/subuid /etc/subgid; do
+  sudo touch $f
+  grep -q '^%[1]s:' $f || echo "%[1]s:$(awk -F: 'BEGIN { m = 100000 } $2 + $3 > m { m = $2 + $3 } END { print m }' $f):65536" | sudo tee -a $f >/dev/null || exit 1
+done
+sudo loginctl enable-linger %[1]s`
+
+	// rootlessStopRootfulCommand stops the daemon of root, which listens on
+	// the engine port and which the setup tool refuses to run along
+	rootlessStopRootfulCommand = "sudo systemctl disable --now docker.service docker.socket || sudo systemctl disable --now docker.service"
+
+	// rootlessUnitTemplate runs dockerd-rootless.sh with the options of the
+	// engine, rootlesskit forwarding the engine port from the host
+	rootlessUnitTemplate = `[Service]
+Environment="DOCKERD_ROOTLESS_ROOTLESSKIT_FLAGS=-p 0.0.0.0:{{.DockerPort}}:{{.DockerPort}}/tcp"
+{{- range .EngineOptions.Env }}
+Environment={{ printf "%q" . }}
+{{- end }}
+ExecStart=
+ExecStart=/usr/bin/dockerd-rootless.sh --host=unix://%t/docker.sock --host=tcp://0.0.0.0:{{.DockerPort}} --tlsverify --tlscacert {{.AuthOptions.CaCertRemotePath}} --tlscert {{.AuthOptions.ServerCertRemotePath}} --tlskey {{.AuthOptions.ServerKeyRemotePath}}{{ if .EngineOptions.StorageDriver }} --storage-driver {{.EngineOptions.StorageDriver}}{{ end }}{{ range .EngineOptions.Labels }} --label {{.}}{{ end }}{{ range .EngineOptions.InsecureRegistry }} --insecure-registry {{.}}{{ end }}{{ range .EngineOptions.RegistryMirror }} --registry-mirror {{.}}{{ end }}{{ range .EngineOptions.ArbitraryFlags }} --{{.}}{{ end }}
+`
+)
+
+var rootlessUserRE = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// ValidateRootlessUser checks that user is the name of a user, other than
+// root, which Docker can run rootless for.
+func ValidateRootlessUser(user string) error {
+	if !rootlessUserRE.MatchString(user) || user == "root" {
+		return fmt.Errorf("%q is not the name of a user other than root", user)
+	}
+	return nil
+}
+
+// rootlessUser is the user the rootless daemon runs as.
+type rootlessUser struct {
+	name string
+	uid  string
+	home string
+}
+
+// command returns command run as the user, with the runtime directory of its
+// systemd instance.
+func (u rootlessUser) command(command string) string {
+	return fmt.Sprintf("sudo -iu %s env XDG_RUNTIME_DIR=/run/user/%s %s", u.name, u.uid, command)
+}
+
+// configDir is the configuration directory of the rootless daemon.
+func (u rootlessUser) configDir() string {
+	return path.Join(u.home, ".config/docker")
+}
+
+// WithRootless switches the Docker provisioned on the host to the rootless
+// mode for the rootless user of the engine options, its API served with TLS
+// on the engine port. The daemon of root is disabled.
+func WithRootless(p Provisioner, authOptions auth.Options, engineOptions engine.Options) error {
+	log.Infof("Switching Docker to rootless mode for the user %s...", engineOptions.RootlessUser)
+
+	if err := installRootlessPackages(p); err != nil {
+		return err
+	}
+	if _, err := p.SSHCommand(rootlessStopRootfulCommand); err != nil {
+		return fmt.Errorf("Error stopping the Docker daemon of root: %s", err)
+	}
+
+	user, err := setupRootlessUser(p, engineOptions.RootlessUser)
+	if err != nil {
+		return err
+	}
+
+	authOptions = rootlessAuthOptions(authOptions, user)
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s", user.configDir())); err != nil {
+		return err
+	}
+	if err := copyServerCerts(p, authOptions); err != nil {
+		return err
+	}
+	if len(engineOptions.RegistryCA) > 0 {
+		// the rootless daemon reads the CA of the registries from its
+		// configuration directory
+		if _, err := p.SSHCommand(fmt.Sprintf("sudo cp -rT %s %s", registryCertDirs[engine.RuntimeDocker], path.Join(user.configDir(), "certs.d"))); err != nil {
+			return err
+		}
+	}
+
+	files := map[string]string{}
+	if files[path.Join(user.home, ".config/systemd/user/docker.service.d/10-machine.conf")], err = executeTemplate(rootlessUnitTemplate, EngineConfigContext{
+		DockerPort:    engineOptions.DockerPort(),
+		AuthOptions:   authOptions,
+		EngineOptions: p.GetEngineOptions(),
+	}); err != nil {
+		return err
+	}
+	if engineOptions.DaemonJSON != "" {
+		settings, err := parseDaemonJSON(engineOptions.DaemonJSON)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[path.Join(user.configDir(), "daemon.json")] = string(data) + "\n"
+	}
+	for file, content := range files {
+		if _, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s >/dev/null <<'EOF'\n%sEOF", path.Dir(file), file, content)); err != nil {
+			return err
+		}
+	}
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo chown -R %s: %s", user.name, path.Join(user.home, ".config"))); err != nil {
+		return err
+	}
+
+	log.Info("Setting up the rootless Docker daemon...")
+	for _, command := range []string{
+		"dockerd-rootless-setuptool.sh install --force",
+		"systemctl --user daemon-reload",
+		"systemctl --user enable docker",
+		"systemctl --user restart docker",
+	} {
+		if output, err := p.SSHCommand(user.command(command)); err != nil {
+			return fmt.Errorf("Error setting up rootless Docker: %s", strings.TrimSpace(output))
+		}
+	}
+
+	return WaitForDocker(p, engineOptions.DockerPort())
+}
+
+// installRootlessPackages installs newuidmap, slirp4netns and the rootless
+// extras of Docker, unless the host already has them.
+func installRootlessPackages(p Provisioner) error {
+	uidmap := "shadow-utils"
+	if _, err := p.SSHCommand("type apt-get"); err == nil {
+		uidmap = "uidmap"
+	}
+
+	for _, tool := range []struct{ command, pkg string }{
+		{"newuidmap", uidmap},
+		{"slirp4netns", "slirp4netns"},
+		{"dockerd-rootless-setuptool.sh", "docker-ce-rootless-extras"},
+	} {
+		if _, err := p.SSHCommand("type " + tool.command); err == nil {
+			continue
+		}
+		log.Infof("Installing %s for rootless Docker", tool.pkg)
+		if err := p.Package(tool.pkg, pkgaction.Install); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupRootlessUser creates the rootless user unless it exists, with its
+// subordinate ids and lingering systemd instance.
+func setupRootlessUser(p Provisioner, name string) (rootlessUser, error) {
+	if output, err := p.SSHCommand(fmt.Sprintf(rootlessUserCommand, name)); err != nil {
+		return rootlessUser{name: name}, fmt.Errorf("Error setting up the user %s: %s", name, strings.TrimSpace(output))
+	}
+	return lookupRootlessUser(p, name)
+}
+
+// lookupRootlessUser returns the uid and home directory of the rootless user.
+func lookupRootlessUser(p Provisioner, name string) (rootlessUser, error) {
+	user := rootlessUser{name: name}
+	uid, err := p.SSHCommand("id -u " + name)
+	if err != nil {
+		return user, err
+	}
+	home, err := p.SSHCommand(fmt.Sprintf("getent passwd %s | cut -d: -f6", name))
+	if err != nil {
+		return user, err
+	}
+	user.uid = strings.TrimSpace(uid)
+	user.home = strings.TrimSpace(home)
+	return user, nil
+}
+
+// rootlessAuthOptions returns authOptions with the remote paths of the
+// certificates in the configuration directory of the rootless daemon, which
+// reads them as the user.
+func rootlessAuthOptions(authOptions auth.Options, user rootlessUser) auth.Options {
+	// due to windows clients, we cannot use filepath.Join as the paths
+	// will be mucked on the linux hosts
+	authOptions.CaCertRemotePath = path.Join(user.configDir(), "ca.pem")
+	authOptions.ServerCertRemotePath = path.Join(user.configDir(), "server.pem")
+	authOptions.ServerKeyRemotePath = path.Join(user.configDir(), "server-key.pem")
+	return authOptions
+}
+
+// rotateRootlessCerts copies the certificates of authOptions to the
+// configuration directory of the rootless daemon and restarts it.
+func rotateRootlessCerts(p Provisioner, authOptions auth.Options, engineOptions engine.Options) error {
+	user, err := lookupRootlessUser(p, engineOptions.RootlessUser)
+	if err != nil {
+		return err
+	}
+
+	if err := copyServerCerts(p, rootlessAuthOptions(authOptions, user)); err != nil {
+		return err
+	}
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo chown -R %s: %s", user.name, user.configDir())); err != nil {
+		return err
+	}
+	if output, err := p.SSHCommand(user.command("systemctl --user restart docker")); err != nil {
+		return fmt.Errorf("Error restarting rootless Docker: %s", strings.TrimSpace(output))
+	}
+
+	return WaitForDocker(p, engineOptions.DockerPort())
+}
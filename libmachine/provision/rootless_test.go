@@ -0,0 +1,71 @@
+package provision
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRootlessUser(t *testing.T) {
+	for _, user := range []string{"docker", "svc_docker", "rootless-1"} {
+		assert.NoError(t, ValidateRootlessUser(user), user)
+	}
+	for _, user := range []string{"", "root", "Docker", "1docker", "docker user", "docker;reboot"} {
+		assert.Error(t, ValidateRootlessUser(user), user)
+	}
+}
+
+func TestSetupRootlessUser(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			fmt.Sprintf(rootlessUserCommand, "dockerd"): "",
+			"id -u dockerd":                       "1001\n",
+			"getent passwd dockerd | cut -d: -f6": "/home/dockerd\n",
+		},
+	}
+
+	user, err := setupRootlessUser(p, "dockerd")
+	assert.NoError(t, err)
+	assert.Equal(t, rootlessUser{name: "dockerd", uid: "1001", home: "/home/dockerd"}, user)
+	assert.Equal(t, "sudo -iu dockerd env XDG_RUNTIME_DIR=/run/user/1001 systemctl --user restart docker", user.command("systemctl --user restart docker"))
+
+	authOptions := rootlessAuthOptions(auth.Options{}, user)
+	assert.Equal(t, "/home/dockerd/.config/docker/ca.pem", authOptions.CaCertRemotePath)
+	assert.Equal(t, "/home/dockerd/.config/docker/server-key.pem", authOptions.ServerKeyRemotePath)
+}
+
+func TestRootlessUnitTemplate(t *testing.T) {
+	user := rootlessUser{name: "dockerd", uid: "1001", home: "/home/dockerd"}
+	unit, err := executeTemplate(rootlessUnitTemplate, EngineConfigContext{
+		DockerPort:  2377,
+		AuthOptions: rootlessAuthOptions(auth.Options{}, user),
+		EngineOptions: engine.Options{
+			Env:    []string{"HTTPS_PROXY=http://proxy:3128"},
+			Labels: []string{"provider=Driver"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `[Service]
+Environment="DOCKERD_ROOTLESS_ROOTLESSKIT_FLAGS=-p 0.0.0.0:2377:2377/tcp"
+Environment="HTTPS_PROXY=http://proxy:3128"
+ExecStart=
+ExecStart=/usr/bin/dockerd-rootless.sh --host=unix://%t/docker.sock --host=tcp://0.0.0.0:2377 --tlsverify --tlscacert /home/dockerd/.config/docker/ca.pem --tlscert /home/dockerd/.config/docker/server.pem --tlskey /home/dockerd/.config/docker/server-key.pem --label provider=Driver
+`, unit)
+}
+
+func TestLoginRegistriesRootless(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"printf '%s' 'password' | sudo -H -u dockerd env DOCKER_HOST=unix:///run/user/$(id -u dockerd)/docker.sock docker login --username 'user' --password-stdin mirror.local": "Login Succeeded",
+		},
+	}
+
+	assert.NoError(t, loginRegistries(p, engine.Options{RootlessUser: "dockerd", RegistryAuth: map[string]string{"mirror.local": "user:password"}}))
+}
@@ -0,0 +1,115 @@
+package provision
+
+import (
+	"io/ioutil"
+	"path"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/swarm"
+)
+
+// RotateCerts generates the server certificate of the machine of p again,
+// copies it to the machine with the CA and restarts the engine. Unlike
+// ConfigureAuth, the configuration of the engine is left as provisioned, the
+// certificates being copied where the runtime of engineOptions reads them.
+func RotateCerts(p Provisioner, swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	if engineOptions.Runtime == engine.RuntimeContainerd && engineOptions.LocalOnly {
+		log.Warnf("containerd of %s only listens on its local socket, there are no certificates to rotate", p.GetDriver().GetMachineName())
+		return nil
+	}
+
+	if _, windows := p.(*WindowsProvisioner); windows {
+		return rotateWindowsCerts(p, swarmOptions, authOptions)
+	}
+
+	if err := checkKeyAlgorithm(p, authOptions); err != nil {
+		return err
+	}
+
+	driver := p.GetDriver()
+	if err := generateServerCert(driver, authOptions, swarmOptions); err != nil {
+		return err
+	}
+
+	switch engineOptions.Runtime {
+	case engine.RuntimeContainerd:
+		if err := copyServerCerts(p, containerdAuthOptions(authOptions)); err != nil {
+			return err
+		}
+		if err := p.Service("containerd", serviceaction.Restart); err != nil {
+			return err
+		}
+		return waitForContainerd(p)
+	case engine.RuntimePodman:
+		if err := copyServerCerts(p, podmanAuthOptions(authOptions)); err != nil {
+			return err
+		}
+		for _, service := range []string{"podman.socket", podmanTLSService} {
+			if err := p.Service(service, serviceaction.Restart); err != nil {
+				return err
+			}
+		}
+		return WaitForDocker(p, engineOptions.DockerPort())
+	}
+
+	if engineOptions.RootlessUser != "" {
+		return rotateRootlessCerts(p, authOptions, engineOptions)
+	}
+
+	dockerPort, err := engineDockerPort(driver)
+	if err != nil {
+		return err
+	}
+
+	// the remote paths are those of setRemoteAuthOptions
+	dockerDir := p.GetDockerOptionsDir()
+	authOptions.CaCertRemotePath = path.Join(dockerDir, "ca.pem")
+	authOptions.ServerCertRemotePath = path.Join(dockerDir, "server.pem")
+	authOptions.ServerKeyRemotePath = path.Join(dockerDir, "server-key.pem")
+	if err := copyServerCerts(p, authOptions); err != nil {
+		return err
+	}
+	if err := p.Service("docker", serviceaction.Restart); err != nil {
+		return err
+	}
+	return WaitForDocker(p, dockerPort)
+}
+
+// rotateWindowsCerts rotates the certificates of the Windows machine of p,
+// in the certs.d directory of windowsAuthOptions.
+func rotateWindowsCerts(p Provisioner, swarmOptions swarm.Options, authOptions auth.Options) error {
+	driver := p.GetDriver()
+	if err := generateServerCert(driver, authOptions, swarmOptions); err != nil {
+		return err
+	}
+
+	dockerPort, err := engineDockerPort(driver)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Copying certs to the remote machine...")
+
+	remoteDir := p.GetDockerOptionsDir() + `\certs.d\`
+	for local, remote := range map[string]string{
+		authOptions.CaCertPath:     remoteDir + "ca.pem",
+		authOptions.ServerCertPath: remoteDir + "server.pem",
+		authOptions.ServerKeyPath:  remoteDir + "server-key.pem",
+	} {
+		data, err := ioutil.ReadFile(local)
+		if err != nil {
+			return err
+		}
+		if err := writeWindowsFile(p, remote, data); err != nil {
+			return err
+		}
+	}
+
+	if err := p.Service("docker", serviceaction.Restart); err != nil {
+		return err
+	}
+	return waitForWindowsDocker(p, dockerPort)
+}
@@ -0,0 +1,155 @@
+package provision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/stretchr/testify/assert"
+)
+
+type rotatingProvisioner struct {
+	NetstatProvisioner
+	commands  []string
+	services  []string
+	responses map[string]string
+}
+
+func (p *rotatingProvisioner) SSHCommand(args string) (string, error) {
+	p.commands = append(p.commands, args)
+	if output, ok := p.responses[args]; ok {
+		return output, nil
+	}
+	return p.NetstatProvisioner.SSHCommand(args)
+}
+
+func (p *rotatingProvisioner) Service(name string, action serviceaction.ServiceAction) error {
+	p.services = append(p.services, fmt.Sprintf("%s %s", action, name))
+	return nil
+}
+
+func (p *rotatingProvisioner) GetDockerOptionsDir() string {
+	return "/etc/docker"
+}
+
+func (p *rotatingProvisioner) GetDriver() drivers.Driver {
+	return &fakedriver.Driver{MockName: "machine", MockState: state.Running, MockIP: "10.0.0.1"}
+}
+
+func rotateTestAuthOptions(t *testing.T, dir string) auth.Options {
+	authOptions := auth.Options{
+		CertDir:          dir,
+		CaCertPath:       filepath.Join(dir, "ca.pem"),
+		CaPrivateKeyPath: filepath.Join(dir, "ca-key.pem"),
+		ClientCertPath:   filepath.Join(dir, "cert.pem"),
+		ClientKeyPath:    filepath.Join(dir, "key.pem"),
+		ServerCertPath:   filepath.Join(dir, "server.pem"),
+		ServerKeyPath:    filepath.Join(dir, "server-key.pem"),
+		StorePath:        filepath.Join(dir, "machine"),
+	}
+	assert.NoError(t, os.Mkdir(authOptions.StorePath, 0700))
+	assert.NoError(t, cert.BootstrapCertificates(&authOptions))
+	return authOptions
+}
+
+// copiedCerts returns the remote paths the certificates were copied to.
+func (p *rotatingProvisioner) copiedCerts() []string {
+	copied := []string{}
+	for _, command := range p.commands {
+		if strings.HasPrefix(command, "printf") && strings.Contains(command, "sudo tee") {
+			copied = append(copied, command[strings.LastIndex(command, " ")+1:])
+		}
+	}
+	return copied
+}
+
+func TestRotateCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := rotateTestAuthOptions(t, dir)
+	p := &rotatingProvisioner{NetstatProvisioner: NetstatProvisioner{&FakeProvisioner{}}}
+
+	assert.NoError(t, RotateCerts(p, swarm.Options{}, authOptions, engine.Options{}))
+
+	_, err = os.Stat(authOptions.ServerCertPath)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/etc/docker/ca.pem", "/etc/docker/server.pem", "/etc/docker/server-key.pem"}, p.copiedCerts())
+	assert.Equal(t, []string{"restart docker"}, p.services)
+}
+
+func TestRotateCertsContainerd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := rotateTestAuthOptions(t, dir)
+	p := &rotatingProvisioner{NetstatProvisioner: NetstatProvisioner{&FakeProvisioner{}}}
+
+	assert.NoError(t, RotateCerts(p, swarm.Options{}, authOptions, engine.Options{Runtime: engine.RuntimeContainerd}))
+
+	assert.ElementsMatch(t, []string{"/etc/containerd/ca.pem", "/etc/containerd/server.pem", "/etc/containerd/server-key.pem"}, p.copiedCerts())
+	assert.Equal(t, []string{"restart containerd"}, p.services)
+
+	// containerd only listening on its local socket has no certificates
+	p = &rotatingProvisioner{NetstatProvisioner: NetstatProvisioner{&FakeProvisioner{}}}
+	assert.NoError(t, RotateCerts(p, swarm.Options{}, authOptions, engine.Options{Runtime: engine.RuntimeContainerd, LocalOnly: true}))
+	assert.Empty(t, p.commands)
+	assert.Empty(t, p.services)
+}
+
+func TestRotateCertsPodman(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := rotateTestAuthOptions(t, dir)
+	p := &rotatingProvisioner{NetstatProvisioner: NetstatProvisioner{&FakeProvisioner{}}}
+
+	assert.NoError(t, RotateCerts(p, swarm.Options{}, authOptions, engine.Options{Runtime: engine.RuntimePodman}))
+
+	assert.ElementsMatch(t, []string{"/etc/containers/tls/ca.pem", "/etc/containers/tls/server.pem", "/etc/containers/tls/server-key.pem"}, p.copiedCerts())
+	assert.Equal(t, []string{"restart podman.socket", "restart " + podmanTLSService}, p.services)
+}
+
+func TestRotateCertsRootless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := rotateTestAuthOptions(t, dir)
+	p := &rotatingProvisioner{
+		NetstatProvisioner: NetstatProvisioner{&FakeProvisioner{}},
+		responses: map[string]string{
+			"id -u dockerd":                       "1001\n",
+			"getent passwd dockerd | cut -d: -f6": "/home/dockerd\n",
+		},
+	}
+
+	assert.NoError(t, RotateCerts(p, swarm.Options{}, authOptions, engine.Options{RootlessUser: "dockerd"}))
+
+	assert.ElementsMatch(t, []string{"/home/dockerd/.config/docker/ca.pem", "/home/dockerd/.config/docker/server.pem", "/home/dockerd/.config/docker/server-key.pem"}, p.copiedCerts())
+	assert.Contains(t, p.commands, "sudo chown -R dockerd: /home/dockerd/.config/docker")
+	assert.Contains(t, p.commands, "sudo -iu dockerd env XDG_RUNTIME_DIR=/run/user/1001 systemctl --user restart docker")
+	assert.Empty(t, p.services)
+}
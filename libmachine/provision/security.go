@@ -0,0 +1,105 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+)
+
+const (
+	selinuxModeCommand     = "getenforce 2>/dev/null || echo Disabled"
+	apparmorEnabledCommand = "cat /sys/module/apparmor/parameters/enabled 2>/dev/null || echo N"
+
+	// selinuxPermissiveCommand switches SELinux to permissive mode, now and
+	// after the reboots
+	selinuxPermissiveCommand = "sudo setenforce 0 && sudo sed -i 's/^SELINUX=enforcing/SELINUX=permissive/' /etc/selinux/config"
+)
+
+// configureSecurityModules configures the SELinux or AppArmor of the host for
+// the engine, rather than having users disable them beforehand, and returns
+// the engine options with the SELinux support of dockerd enabled.
+//
+// When SELinux cannot be configured, it is switched to permissive mode unless
+// SELinuxEnforcing is set.
+func configureSecurityModules(p Provisioner, engineOptions engine.Options) (engine.Options, error) {
+	mode, err := p.SSHCommand(selinuxModeCommand)
+	if err != nil {
+		return engineOptions, err
+	}
+
+	switch strings.TrimSpace(mode) {
+	case "Enforcing":
+		if err := configureSELinux(p, engineOptions); err != nil {
+			if engineOptions.SELinuxEnforcing {
+				return engineOptions, fmt.Errorf("Error configuring SELinux for the engine, keeping it enforcing: %s", err)
+			}
+			log.Warnf("Error configuring SELinux for the engine, switching it to permissive mode: %s", err)
+			if _, err := p.SSHCommand(selinuxPermissiveCommand); err != nil {
+				return engineOptions, fmt.Errorf("Error switching SELinux to permissive mode: %s", err)
+			}
+			return engineOptions, nil
+		}
+		if engineOptions.Runtime == "" || engineOptions.Runtime == engine.RuntimeDocker {
+			engineOptions.SelinuxEnabled = true
+			engineOptions.ArbitraryFlags = appendEngineFlag(engineOptions.ArbitraryFlags, "selinux-enabled", "")
+		}
+	case "Permissive":
+		log.Info("SELinux is permissive, not configuring it for the engine")
+	}
+
+	enabled, err := p.SSHCommand(apparmorEnabledCommand)
+	if err != nil {
+		return engineOptions, err
+	}
+	if strings.TrimSpace(enabled) == "Y" {
+		if err := configureAppArmor(p); err != nil {
+			return engineOptions, err
+		}
+	}
+
+	return engineOptions, nil
+}
+
+// configureSELinux installs the SELinux policy of the containers and sets its
+// booleans for the engine options.
+func configureSELinux(p Provisioner, engineOptions engine.Options) error {
+	log.Info("Configuring SELinux for the engine...")
+	if _, err := p.SSHCommand("rpm -q container-selinux"); err != nil {
+		if err := p.Package("container-selinux", pkgaction.Install); err != nil {
+			return err
+		}
+		if _, err := p.SSHCommand("rpm -q container-selinux"); err != nil {
+			return fmt.Errorf("container-selinux cannot be installed on %s", p)
+		}
+	}
+
+	// systemd in the containers manages their cgroups, and the nvidia
+	// runtime gives them the devices of the GPUs
+	booleans := []string{"container_manage_cgroup"}
+	if engineOptions.GPURuntime {
+		booleans = append(booleans, "container_use_devices")
+	}
+	for _, boolean := range booleans {
+		if output, err := p.SSHCommand(fmt.Sprintf("sudo setsebool -P %s on", boolean)); err != nil {
+			return fmt.Errorf("Error setting the SELinux boolean %s: %s", boolean, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// configureAppArmor installs apparmor_parser, which dockerd loads its
+// docker-default profile with when AppArmor is enabled.
+func configureAppArmor(p Provisioner) error {
+	if _, err := p.SSHCommand("type apparmor_parser"); err == nil {
+		return nil
+	}
+
+	log.Info("AppArmor is enabled, installing apparmor_parser for the engine profiles")
+	if err := p.Package("apparmor", pkgaction.Install); err != nil {
+		return fmt.Errorf("Error installing AppArmor, which the containers cannot start without: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureSecurityModulesSELinux(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			selinuxModeCommand:                             "Enforcing\n",
+			"rpm -q container-selinux":                     "container-selinux-2.229.0-1.el9.noarch",
+			"sudo setsebool -P container_manage_cgroup on": "",
+			"sudo setsebool -P container_use_devices on":   "",
+			apparmorEnabledCommand:                         "N\n",
+		},
+	}
+
+	engineOptions, err := configureSecurityModules(p, engine.Options{GPURuntime: true})
+	assert.NoError(t, err)
+	assert.True(t, engineOptions.SelinuxEnabled)
+	assert.Equal(t, []string{"selinux-enabled"}, engineOptions.ArbitraryFlags)
+
+	engineOptions, err = configureSecurityModules(p, engine.Options{ArbitraryFlags: []string{"selinux-enabled=false"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"selinux-enabled=false"}, engineOptions.ArbitraryFlags)
+}
+
+func TestConfigureSecurityModulesPermissive(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			selinuxModeCommand:       "Enforcing\n",
+			selinuxPermissiveCommand: "",
+			apparmorEnabledCommand:   "N\n",
+		},
+	}
+
+	// container-selinux is neither installed nor installable
+	engineOptions, err := configureSecurityModules(p, engine.Options{})
+	assert.NoError(t, err)
+	assert.False(t, engineOptions.SelinuxEnabled)
+	assert.Empty(t, engineOptions.ArbitraryFlags)
+
+	_, err = configureSecurityModules(p, engine.Options{SELinuxEnforcing: true})
+	assert.Error(t, err)
+}
+
+func TestConfigureSecurityModulesAppArmor(t *testing.T) {
+	p := NewDebianProvisioner(&fakedriver.Driver{})
+	p.(*DebianProvisioner).SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			selinuxModeCommand:     "Disabled\n",
+			apparmorEnabledCommand: "Y\n",
+			"type apparmor_parser": "apparmor_parser is /usr/sbin/apparmor_parser",
+		},
+	}
+
+	engineOptions, err := configureSecurityModules(p, engine.Options{})
+	assert.NoError(t, err)
+	assert.False(t, engineOptions.SelinuxEnabled)
+	assert.Empty(t, engineOptions.ArbitraryFlags)
+}
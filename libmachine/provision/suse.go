@@ -49,6 +49,9 @@ type SUSEProvisioner struct {
 }
 
 func (provisioner *SUSEProvisioner) CompatibleWithHost() bool {
+	if isTransactionalHost(provisioner.OsReleaseInfo) {
+		return false
+	}
 	return strings.ToLower(provisioner.OsReleaseInfo.ID) == strings.ToLower(provisioner.OsReleaseID) || strings.Contains(provisioner.OsReleaseInfo.IDLike, "opensuse")
 }
 
@@ -104,19 +107,16 @@ func (provisioner *SUSEProvisioner) dockerDaemonResponding() bool {
 	return true
 }
 
-func (provisioner *SUSEProvisioner) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
-	provisioner.SwarmOptions = swarmOptions
-	provisioner.AuthOptions = authOptions
-	provisioner.EngineOptions = engineOptions
-	swarmOptions.Env = engineOptions.Env
-
+// storageDriver returns the storage driver of the engine, btrfs on the btrfs
+// filesystems unless another is set.
+func (provisioner *SUSEProvisioner) storageDriver() (string, error) {
 	// figure out the filesystem used by /var/lib/docker
 	fs, err := provisioner.SSHCommand("stat -f -c %T /var/lib/docker")
 	if err != nil {
 		// figure out the filesystem used by /var/lib
 		fs, err = provisioner.SSHCommand("stat -f -c %T /var/lib/")
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
 	graphDriver := "overlay"
@@ -124,7 +124,16 @@ func (provisioner *SUSEProvisioner) Provision(swarmOptions swarm.Options, authOp
 		graphDriver = "btrfs"
 	}
 
-	storageDriver, err := decideStorageDriver(provisioner, graphDriver, engineOptions.StorageDriver)
+	return decideStorageDriver(provisioner, graphDriver, provisioner.EngineOptions.StorageDriver)
+}
+
+func (provisioner *SUSEProvisioner) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	provisioner.SwarmOptions = swarmOptions
+	provisioner.AuthOptions = authOptions
+	provisioner.EngineOptions = engineOptions
+	swarmOptions.Env = engineOptions.Env
+
+	storageDriver, err := provisioner.storageDriver()
 	if err != nil {
 		return err
 	}
@@ -142,7 +151,7 @@ func (provisioner *SUSEProvisioner) Provision(swarmOptions swarm.Options, authOp
 		}
 	}
 
-	if err := installDockerGeneric(provisioner, provisioner.EngineOptions.InstallURL); err != nil {
+	if err := installDockerGeneric(provisioner, provisioner.EngineOptions); err != nil {
 		return err
 	}
 
@@ -182,8 +191,14 @@ func (provisioner *SUSEProvisioner) Provision(swarmOptions swarm.Options, authOp
 	return err
 }
 
+// firewallTCPPorts returns the TCP ports opened in the firewall of the hosts,
+// the port of the engine among them.
+func firewallTCPPorts(engineOptions engine.Options) string {
+	return fmt.Sprintf("22 80 443 %d 2379 2380 6443 9099 9796 10250 10254 30000:32767", engineOptions.DockerPort())
+}
+
 func (provisioner *SUSEProvisioner) configureFirewall() error {
-	tcpPorts := "22 80 443 2376 2379 2380 6443 9099 9796 10250 10254 30000:32767"
+	tcpPorts := firewallTCPPorts(provisioner.EngineOptions)
 	udpPorts := "8472 30000:32767"
 	var cmds []string
 	// SLES15 has dropped SuSEfirewall2: https://www.suse.com/releasenotes/x86_64/SUSE-SLES/15/#fate-320794
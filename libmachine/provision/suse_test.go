@@ -0,0 +1,24 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSUSEConfigureFirewallEnginePort(t *testing.T) {
+	p := NewOpenSUSEProvisioner(&fakedriver.Driver{}).(*SUSEProvisioner)
+	p.EngineOptions.Port = 3376
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"rpm -q SuSEfirewall2": "SuSEfirewall2-3.6.312-2.13.1.noarch",
+			`sudo sed -i 's/FW_SERVICES_EXT_TCP=".*"/FW_SERVICES_EXT_TCP="22 80 443 3376 2379 2380 6443 9099 9796 10250 10254 30000:32767"/' /etc/sysconfig/SuSEfirewall2`: "",
+			`sudo sed -i 's/FW_SERVICES_EXT_UDP=".*"/FW_SERVICES_EXT_UDP="8472 30000:32767"/' /etc/sysconfig/SuSEfirewall2`:                                                "",
+			"sudo /sbin/SuSEfirewall2": "",
+		},
+	}
+
+	assert.NoError(t, p.configureFirewall())
+}
@@ -0,0 +1,89 @@
+package provision
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+const (
+	sysctlFile        = "/etc/sysctl.d/90-machine.conf"
+	kernelModulesFile = "/etc/modules-load.d/machine.conf"
+
+	// disableSwapCommand turns the swap off, and comments out the swap
+	// entries of the fstab for it to stay off after the reboots
+	disableSwapCommand = `sudo swapoff -a && sudo sed -i '/^[^#].*[[:space:]]swap[[:space:]]/ s/^/#/' /etc/fstab`
+)
+
+var (
+	sysctlKeyRE    = regexp.MustCompile(`^[a-z0-9_]+([./][a-zA-Z0-9_-]+)+$`)
+	kernelModuleRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// ParseSysctls parses the key=value kernel parameters set on the host.
+func ParseSysctls(values []string) (map[string]string, error) {
+	sysctls := map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		kv[0] = strings.TrimSpace(kv[0])
+		if len(kv) != 2 || !sysctlKeyRE.MatchString(kv[0]) || strings.TrimSpace(kv[1]) == "" || strings.ContainsAny(kv[1], "\n\r") {
+			return nil, fmt.Errorf("invalid kernel parameter %q, expected key=value", value)
+		}
+		sysctls[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	return sysctls, nil
+}
+
+// ValidateKernelModule checks that module is the name of a kernel module.
+func ValidateKernelModule(module string) error {
+	if !kernelModuleRE.MatchString(module) {
+		return fmt.Errorf("%q is not the name of a kernel module", module)
+	}
+	return nil
+}
+
+// hasHostTuning returns whether the engine options tune the kernel or swap
+// of the host.
+func hasHostTuning(engineOptions engine.Options) bool {
+	return len(engineOptions.Sysctl) > 0 || len(engineOptions.KernelModules) > 0 || engineOptions.DisableSwap
+}
+
+// tuneHost loads the kernel modules, sets the kernel parameters and turns
+// the swap off as the engine options declare, persisting them across the
+// reboots. The modules are loaded first, br_netfilter providing the
+// net.bridge parameters.
+func tuneHost(p Provisioner, engineOptions engine.Options) error {
+	if modules := engineOptions.KernelModules; len(modules) > 0 {
+		log.Infof("Loading the kernel modules %s", strings.Join(modules, ", "))
+		command := fmt.Sprintf("sudo mkdir -p /etc/modules-load.d && printf '%%s\\n' %s | sudo tee %s >/dev/null", strings.Join(modules, " "), kernelModulesFile)
+		for _, module := range modules {
+			command += " && sudo modprobe " + module
+		}
+		if output, err := p.SSHCommand(command); err != nil {
+			return fmt.Errorf("Error loading the kernel modules: %s", strings.TrimSpace(output))
+		}
+	}
+
+	if len(engineOptions.Sysctl) > 0 {
+		var lines []string
+		for _, key := range sortedKeys(engineOptions.Sysctl) {
+			lines = append(lines, fmt.Sprintf("%s = %s\n", key, engineOptions.Sysctl[key]))
+		}
+		log.Info("Setting the kernel parameters...")
+		if output, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p /etc/sysctl.d && sudo tee %s >/dev/null <<'EOF'\n%sEOF\nsudo sysctl -p %s", sysctlFile, strings.Join(lines, ""), sysctlFile)); err != nil {
+			return fmt.Errorf("Error setting the kernel parameters: %s", strings.TrimSpace(output))
+		}
+	}
+
+	if engineOptions.DisableSwap {
+		log.Info("Turning the swap off...")
+		if output, err := p.SSHCommand(disableSwapCommand); err != nil {
+			return fmt.Errorf("Error turning the swap off: %s", strings.TrimSpace(output))
+		}
+	}
+
+	return nil
+}
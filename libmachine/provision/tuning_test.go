@@ -0,0 +1,55 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/provisiontest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSysctls(t *testing.T) {
+	sysctls, err := ParseSysctls([]string{"net.ipv4.ip_forward=1", "fs.inotify.max_user_watches = 524288", "net.ipv4.ip_local_port_range=1024 65535"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"net.ipv4.ip_forward":          "1",
+		"fs.inotify.max_user_watches":  "524288",
+		"net.ipv4.ip_local_port_range": "1024 65535",
+	}, sysctls)
+
+	for _, value := range []string{"ip_forward=1", "net.ipv4.ip_forward", "net.ipv4.ip_forward=", "net.ipv4.ip_forward=1\nkernel.panic=1", "net;reboot.x=1"} {
+		_, err := ParseSysctls([]string{value})
+		assert.Error(t, err, value)
+	}
+}
+
+func TestValidateKernelModule(t *testing.T) {
+	for _, module := range []string{"br_netfilter", "overlay", "nf-conntrack"} {
+		assert.NoError(t, ValidateKernelModule(module), module)
+	}
+	for _, module := range []string{"", "br_netfilter overlay", "overlay;reboot"} {
+		assert.Error(t, ValidateKernelModule(module), module)
+	}
+}
+
+func TestTuneHost(t *testing.T) {
+	p := NewRedHatProvisioner("rhel", &fakedriver.Driver{})
+	p.SSHCommander = &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			"sudo mkdir -p /etc/modules-load.d && printf '%s\\n' br_netfilter overlay | sudo tee /etc/modules-load.d/machine.conf >/dev/null && sudo modprobe br_netfilter && sudo modprobe overlay":                     "",
+			"sudo mkdir -p /etc/sysctl.d && sudo tee /etc/sysctl.d/90-machine.conf >/dev/null <<'EOF'\nfs.inotify.max_user_watches = 524288\nnet.ipv4.ip_forward = 1\nEOF\nsudo sysctl -p /etc/sysctl.d/90-machine.conf": "",
+			disableSwapCommand: "",
+		},
+	}
+
+	engineOptions := engine.Options{
+		Sysctl:        map[string]string{"net.ipv4.ip_forward": "1", "fs.inotify.max_user_watches": "524288"},
+		KernelModules: []string{"br_netfilter", "overlay"},
+		DisableSwap:   true,
+	}
+	assert.True(t, hasHostTuning(engineOptions))
+	assert.NoError(t, tuneHost(p, engineOptions))
+
+	assert.False(t, hasHostTuning(engine.Options{}))
+}
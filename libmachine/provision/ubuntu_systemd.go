@@ -1,8 +1,9 @@
 package provision
 
 import (
+	"bufio"
 	"fmt"
-	"strconv"
+	"strings"
 
 	"github.com/rancher/machine/libmachine/auth"
 	"github.com/rancher/machine/libmachine/drivers"
@@ -12,8 +13,59 @@ import (
 	"github.com/rancher/machine/libmachine/provision/pkgaction"
 	"github.com/rancher/machine/libmachine/provision/serviceaction"
 	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/rancher/machine/libmachine/versioncmp"
 )
 
+const (
+	// dockerIOPackage is the Docker package of Ubuntu, installed with
+	// --engine-install-url docker.io rather than docker-ce
+	dockerIOPackage = "docker.io"
+
+	// minCgroupV2DockerVersion is the first version of Docker supporting the
+	// hosts with only the cgroup v2 hierarchy, the default from Ubuntu 21.10
+	minCgroupV2DockerVersion = "20.10.0"
+
+	// minNftDockerVersion is the first version of Docker writing its rules
+	// with iptables-nft, the default from Ubuntu 20.10
+	minNftDockerVersion = "20.10.0"
+
+	// ubuntuDetectCommand prints the cgroup hierarchy, the iptables backend
+	// and whether the docker unit runs dockerd with the containerd service
+	ubuntuDetectCommand = `printf 'cgroup=%s\niptables=%s\n' "$(stat -fc %T /sys/fs/cgroup/)" "$(sudo iptables --version 2>/dev/null)"; ` +
+		`if systemctl cat docker.service 2>/dev/null | grep -q -- --containerd=; then echo containerd=external; fi`
+)
+
+// ubuntuHost is what the provisioning adapts to on the Ubuntu hosts.
+type ubuntuHost struct {
+	// CgroupV2 tells whether the host only has the cgroup v2 hierarchy
+	CgroupV2 bool
+	// IptablesNft tells whether iptables is the nf_tables backend
+	IptablesNft bool
+	// ExternalContainerd tells whether the docker unit runs dockerd with
+	// the containerd service rather than its own
+	ExternalContainerd bool
+}
+
+func parseUbuntuHost(output string) ubuntuHost {
+	host := ubuntuHost{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "cgroup":
+			host.CgroupV2 = strings.TrimSpace(parts[1]) == "cgroup2fs"
+		case "iptables":
+			host.IptablesNft = strings.Contains(parts[1], "nf_tables")
+		case "containerd":
+			host.ExternalContainerd = strings.TrimSpace(parts[1]) == "external"
+		}
+	}
+	return host
+}
+
 func init() {
 	Register("Ubuntu-SystemD", &RegisteredProvisioner{
 		New: NewUbuntuSystemdProvisioner,
@@ -35,18 +87,18 @@ func (provisioner *UbuntuSystemdProvisioner) String() string {
 }
 
 func (provisioner *UbuntuSystemdProvisioner) CompatibleWithHost() bool {
-	const FirstUbuntuSystemdVersion = 15.04
+	const FirstUbuntuSystemdVersion = "15.04"
 
 	isUbuntu := provisioner.OsReleaseInfo.ID == provisioner.OsReleaseID
 	if !isUbuntu {
 		return false
 	}
-	versionNumber, err := strconv.ParseFloat(provisioner.OsReleaseInfo.VersionID, 64)
-	if err != nil {
+	// compared as versions, 22.04 and 24.04 like 15.10
+	versionID := provisioner.OsReleaseInfo.VersionID
+	if versionID == "" || strings.Trim(versionID, "0123456789.") != "" {
 		return false
 	}
-	return versionNumber >= FirstUbuntuSystemdVersion
-
+	return versioncmp.GreaterThanOrEqualTo(versionID, FirstUbuntuSystemdVersion)
 }
 
 func (provisioner *UbuntuSystemdProvisioner) Package(name string, action pkgaction.PackageAction) error {
@@ -67,7 +119,11 @@ func (provisioner *UbuntuSystemdProvisioner) Package(name string, action pkgacti
 
 	switch name {
 	case "docker":
-		name = "docker-ce"
+		// docker-ce once its repository is set up by get.docker.com
+		name = dockerIOPackage
+		if _, err := provisioner.SSHCommand("test -f /etc/apt/sources.list.d/docker.list"); err == nil {
+			name = "docker-ce"
+		}
 	}
 
 	if updateMetadata {
@@ -120,7 +176,12 @@ func (provisioner *UbuntuSystemdProvisioner) Provision(swarmOptions swarm.Option
 		}
 	}
 
-	if err := installDockerGeneric(provisioner, provisioner.EngineOptions.InstallURL); err != nil {
+	if err := provisioner.installDocker(); err != nil {
+		return err
+	}
+
+	log.Debug("adapting to the host")
+	if err := provisioner.adaptToHost(); err != nil {
 		return err
 	}
 
@@ -146,3 +207,76 @@ func (provisioner *UbuntuSystemdProvisioner) Provision(swarmOptions swarm.Option
 	err = provisioner.Service("docker", serviceaction.Enable)
 	return err
 }
+
+// installDocker installs Docker from the install URL, or the docker.io
+// package of Ubuntu with --engine-install-url docker.io or when get.docker.com
+// does not support the release yet.
+func (provisioner *UbuntuSystemdProvisioner) installDocker() error {
+	installURL := provisioner.EngineOptions.InstallURL
+	if !strings.EqualFold(installURL, dockerIOPackage) {
+		err := installDockerGeneric(provisioner, provisioner.EngineOptions)
+		if err == nil || (installURL != "" && installURL != drivers.DefaultEngineInstallURL) {
+			return err
+		}
+		log.Warnf("%s, installing the %s package of Ubuntu instead", err, dockerIOPackage)
+	} else if _, err := provisioner.SSHCommand("type docker"); err == nil {
+		log.Info("Docker is already installed, skipping installation")
+		return nil
+	}
+
+	log.Infof("Installing Docker from the %s package", dockerIOPackage)
+	pkg := dockerIOPackage
+	if version := provisioner.EngineOptions.Version; version != "" {
+		pkg = fmt.Sprintf("'%s=%s*'", dockerIOPackage, version)
+	}
+	return provisioner.Package(pkg, pkgaction.Install)
+}
+
+// adaptToHost adapts the engine options to the hosts of the recent Ubuntu
+// releases, with only cgroup v2, iptables-nft and the containerd service.
+func (provisioner *UbuntuSystemdProvisioner) adaptToHost() error {
+	output, err := provisioner.SSHCommand(ubuntuDetectCommand)
+	if err != nil {
+		return fmt.Errorf("Error detecting the host: %s", err)
+	}
+	host := parseUbuntuHost(output)
+	log.Debugf("Ubuntu host: %+v", host)
+
+	dockerVersion, err := DockerClientVersion(provisioner)
+	if err != nil {
+		return err
+	}
+
+	if host.CgroupV2 {
+		if versioncmp.LessThan(dockerVersion, minCgroupV2DockerVersion) {
+			return fmt.Errorf("Docker %s does not support the hosts with only cgroup v2, install Docker %s or later", dockerVersion, minCgroupV2DockerVersion)
+		}
+		provisioner.EngineOptions.ArbitraryFlags = appendEngineFlag(provisioner.EngineOptions.ArbitraryFlags, "exec-opt native.cgroupdriver=", "systemd")
+	}
+
+	if host.IptablesNft && versioncmp.LessThan(dockerVersion, minNftDockerVersion) {
+		log.Infof("Switching to iptables-legacy for Docker %s", dockerVersion)
+		if _, err := provisioner.SSHCommand("sudo update-alternatives --set iptables /usr/sbin/iptables-legacy && sudo update-alternatives --set ip6tables /usr/sbin/ip6tables-legacy"); err != nil {
+			return fmt.Errorf("Error switching to iptables-legacy: %s", err)
+		}
+	}
+
+	if host.ExternalContainerd {
+		// the drop-in replaces the ExecStart of the unit, which runs dockerd
+		// with the containerd service rather than its own
+		provisioner.EngineOptions.ArbitraryFlags = appendEngineFlag(provisioner.EngineOptions.ArbitraryFlags, "containerd=", "/run/containerd/containerd.sock")
+	}
+
+	return nil
+}
+
+// appendEngineFlag appends the flag prefix followed by value to flags,
+// unless a flag with prefix is set.
+func appendEngineFlag(flags []string, prefix, value string) []string {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, prefix) {
+			return flags
+		}
+	}
+	return append(flags, prefix+value)
+}
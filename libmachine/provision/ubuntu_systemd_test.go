@@ -8,6 +8,7 @@ import (
 	"github.com/rancher/machine/libmachine/engine"
 	"github.com/rancher/machine/libmachine/provision/provisiontest"
 	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestUbuntuSystemdCompatibleWithHost(t *testing.T) {
@@ -24,6 +25,13 @@ func TestUbuntuSystemdCompatibleWithHost(t *testing.T) {
 		t.Fatalf("expected to be compatible with ubuntu 15.04")
 	}
 
+	for _, version := range []string{"22.04", "24.04"} {
+		info.VersionID = version
+		if !p.CompatibleWithHost() {
+			t.Fatalf("expected to be compatible with ubuntu %s", version)
+		}
+	}
+
 	info.VersionID = "14.04"
 
 	compatible = p.CompatibleWithHost()
@@ -42,3 +50,37 @@ func TestUbuntuSystemdDefaultStorageDriver(t *testing.T) {
 		t.Fatalf("Default storage driver should be %s", DefaultStorageDriver)
 	}
 }
+
+func TestParseUbuntuHost(t *testing.T) {
+	assert.Equal(t, ubuntuHost{CgroupV2: true, IptablesNft: true, ExternalContainerd: true}, parseUbuntuHost(`cgroup=cgroup2fs
+iptables=iptables v1.8.10 (nf_tables)
+containerd=external
+`))
+	assert.Equal(t, ubuntuHost{}, parseUbuntuHost("cgroup=tmpfs\niptables=iptables v1.8.4 (legacy)\n"))
+}
+
+func TestUbuntuSystemdAdaptToHost(t *testing.T) {
+	p := NewUbuntuSystemdProvisioner(&fakedriver.Driver{}).(*UbuntuSystemdProvisioner)
+	sshCmder := &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{
+			ubuntuDetectCommand: "cgroup=cgroup2fs\niptables=iptables v1.8.10 (nf_tables)\ncontainerd=external\n",
+			"docker --version":  "Docker version 24.0.7, build 24.0.7-0ubuntu4\n",
+		},
+	}
+	p.SSHCommander = sshCmder
+	p.EngineOptions.ArbitraryFlags = []string{"exec-opt native.cgroupdriver=cgroupfs"}
+
+	assert.NoError(t, p.adaptToHost())
+	assert.Equal(t, []string{"exec-opt native.cgroupdriver=cgroupfs", "containerd=/run/containerd/containerd.sock"}, p.EngineOptions.ArbitraryFlags)
+
+	// older Docker does not support cgroup v2
+	sshCmder.Responses["docker --version"] = "Docker version 19.03.15, build 99e3ed8\n"
+	assert.EqualError(t, p.adaptToHost(), "Docker 19.03.15 does not support the hosts with only cgroup v2, install Docker 20.10.0 or later")
+
+	// and switches to iptables-legacy with cgroup v1
+	sshCmder.Responses[ubuntuDetectCommand] = "cgroup=tmpfs\niptables=iptables v1.8.7 (nf_tables)\n"
+	sshCmder.Responses["sudo update-alternatives --set iptables /usr/sbin/iptables-legacy && sudo update-alternatives --set ip6tables /usr/sbin/ip6tables-legacy"] = ""
+	p.EngineOptions.ArbitraryFlags = nil
+	assert.NoError(t, p.adaptToHost())
+	assert.Empty(t, p.EngineOptions.ArbitraryFlags)
+}
@@ -1,6 +1,8 @@
 package provision
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -9,14 +11,17 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"text/template"
 
 	"github.com/rancher/machine/libmachine/auth"
 	"github.com/rancher/machine/libmachine/cert"
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/engine"
 	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnutils"
 	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/rancher/machine/libmachine/versioncmp"
 )
 
 type DockerOptions struct {
@@ -24,15 +29,31 @@ type DockerOptions struct {
 	EngineOptionsPath string
 }
 
-func installDockerGeneric(p Provisioner, baseURL string) error {
+// installDockerGeneric installs Docker with the install script at the install
+// URL, of the version and from the download URL if set as get.docker.com
+// supports.
+func installDockerGeneric(p Provisioner, engineOptions engine.Options) error {
+	baseURL := engineOptions.InstallURL
 	if strings.EqualFold(baseURL, "none") {
 		log.Info("Skipping Docker installation")
 		return nil
 	}
+	// adopted hosts usually have Docker already
+	if _, err := p.SSHCommand("type docker"); err == nil {
+		log.Info("Docker is already installed, skipping installation")
+		return nil
+	}
 	// install docker - until cloudinit we use ubuntu everywhere so we
 	// just install it using the docker repos
 	log.Infof("Installing Docker from: %s", baseURL)
-	if output, err := p.SSHCommand(fmt.Sprintf("if ! type docker; then curl -sSL %s | sh -; fi", baseURL)); err != nil {
+	env := ""
+	if engineOptions.Version != "" {
+		env += fmt.Sprintf("VERSION=%s ", engineOptions.Version)
+	}
+	if engineOptions.DownloadURL != "" {
+		env += fmt.Sprintf("DOWNLOAD_URL=%s ", engineOptions.DownloadURL)
+	}
+	if output, err := p.SSHCommand(withProxyEnv(engineOptions, fmt.Sprintf("if ! type docker; then curl -sSL %s | %ssh -; fi", baseURL, env))); err != nil {
 		return fmt.Errorf("Error installing Docker: %s", output)
 	}
 
@@ -62,71 +83,59 @@ func setRemoteAuthOptions(p Provisioner) auth.Options {
 }
 
 func ConfigureAuth(p Provisioner) error {
-	var (
-		err error
-	)
-
 	driver := p.GetDriver()
-	machineName := driver.GetMachineName()
 	authOptions := p.GetAuthOptions()
-	swarmOptions := p.GetSwarmOptions()
-	org := mcnutils.GetUsername() + "." + machineName
-	bits := 2048
 
-	ip, err := driver.GetIP()
-	if err != nil {
+	if err := checkKeyAlgorithm(p, authOptions); err != nil {
 		return err
 	}
 
-	log.Info("Copying certs to the local machine directory...")
-
-	if err := mcnutils.CopyFile(authOptions.CaCertPath, filepath.Join(authOptions.StorePath, "ca.pem")); err != nil {
-		return fmt.Errorf("Copying ca.pem to machine dir failed: %s", err)
+	if err := generateServerCert(driver, authOptions, p.GetSwarmOptions()); err != nil {
+		return err
 	}
 
-	if err := mcnutils.CopyFile(authOptions.ClientCertPath, filepath.Join(authOptions.StorePath, "cert.pem")); err != nil {
-		return fmt.Errorf("Copying cert.pem to machine dir failed: %s", err)
+	if err := p.Service("docker", serviceaction.Stop); err != nil {
+		return err
 	}
 
-	if err := mcnutils.CopyFile(authOptions.ClientKeyPath, filepath.Join(authOptions.StorePath, "key.pem")); err != nil {
-		return fmt.Errorf("Copying key.pem to machine dir failed: %s", err)
+	if _, err := p.SSHCommand(`if [ ! -z "$(ip link show docker0)" ]; then sudo ip link delete docker0; fi`); err != nil {
+		return err
 	}
 
-	// The Host IP is always added to the certificate's SANs list
-	hosts := append(authOptions.ServerCertSANs, ip, "localhost")
-	log.Debugf("generating server cert: %s ca-key=%s private-key=%s org=%s san=%s",
-		authOptions.ServerCertPath,
-		authOptions.CaCertPath,
-		authOptions.CaPrivateKeyPath,
-		org,
-		hosts,
-	)
+	if err := copyServerCerts(p, authOptions); err != nil {
+		return err
+	}
 
-	// TODO: Switch to passing just authOptions to this func
-	// instead of all these individual fields
-	err = cert.GenerateCert(&cert.Options{
-		Hosts:       hosts,
-		CertFile:    authOptions.ServerCertPath,
-		KeyFile:     authOptions.ServerKeyPath,
-		CAFile:      authOptions.CaCertPath,
-		CAKeyFile:   authOptions.CaPrivateKeyPath,
-		Org:         org,
-		Bits:        bits,
-		SwarmMaster: swarmOptions.Master,
-	})
+	dockerPort, err := engineDockerPort(driver)
+	if err != nil {
+		return err
+	}
 
+	dkrcfg, err := p.GenerateDockerOptions(dockerPort)
 	if err != nil {
-		return fmt.Errorf("error generating server cert: %s", err)
+		return err
 	}
 
-	if err := p.Service("docker", serviceaction.Stop); err != nil {
+	log.Info("Setting Docker configuration on the remote daemon...")
+
+	if _, err := p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && printf %%s \"%s\" | sudo tee %s", path.Dir(dkrcfg.EngineOptionsPath), dkrcfg.EngineOptions, dkrcfg.EngineOptionsPath)); err != nil {
 		return err
 	}
 
-	if _, err := p.SSHCommand(`if [ ! -z "$(ip link show docker0)" ]; then sudo ip link delete docker0; fi`); err != nil {
+	if err := writeDaemonJSON(p, p.GetEngineOptions()); err != nil {
 		return err
 	}
 
+	if err := p.Service("docker", serviceaction.Restart); err != nil {
+		return err
+	}
+
+	return WaitForDocker(p, dockerPort)
+}
+
+// copyServerCerts copies the CA certificate and the server certificate and
+// key to their remote paths on the host.
+func copyServerCerts(p SSHCommander, authOptions auth.Options) error {
 	// upload certs and configure TLS auth
 	caCert, err := ioutil.ReadFile(authOptions.CaCertPath)
 	if err != nil {
@@ -157,44 +166,97 @@ func ConfigureAuth(p Provisioner) error {
 		return err
 	}
 
-	if _, err := p.SSHCommand(fmt.Sprintf(certTransferCmdFmt, string(serverKey), authOptions.ServerKeyRemotePath)); err != nil {
-		return err
-	}
+	_, err = p.SSHCommand(fmt.Sprintf(certTransferCmdFmt, string(serverKey), authOptions.ServerKeyRemotePath))
+	return err
+}
 
-	dockerURL, err := driver.GetURL()
+// generateServerCert copies the client certificates to the machine
+// directory and generates the server certificate for the host.
+func generateServerCert(driver drivers.Driver, authOptions auth.Options, swarmOptions swarm.Options) error {
+	machineName := driver.GetMachineName()
+	org := mcnutils.GetUsername() + "." + machineName
+	bits := 2048
+
+	ip, err := driver.GetIP()
 	if err != nil {
 		return err
 	}
-	u, err := url.Parse(dockerURL)
-	if err != nil {
-		return err
+
+	log.Info("Copying certs to the local machine directory...")
+
+	if err := mcnutils.CopyFile(authOptions.CaCertPath, filepath.Join(authOptions.StorePath, "ca.pem")); err != nil {
+		return fmt.Errorf("Copying ca.pem to machine dir failed: %s", err)
 	}
-	dockerPort := engine.DefaultPort
-	parts := strings.Split(u.Host, ":")
-	if len(parts) == 2 {
-		dPort, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return err
-		}
-		dockerPort = dPort
+
+	if err := mcnutils.CopyFile(authOptions.ClientCertPath, filepath.Join(authOptions.StorePath, "cert.pem")); err != nil {
+		return fmt.Errorf("Copying cert.pem to machine dir failed: %s", err)
 	}
 
-	dkrcfg, err := p.GenerateDockerOptions(dockerPort)
+	if err := mcnutils.CopyFile(authOptions.ClientKeyPath, filepath.Join(authOptions.StorePath, "key.pem")); err != nil {
+		return fmt.Errorf("Copying key.pem to machine dir failed: %s", err)
+	}
+
+	// The Host IP is always added to the certificate's SANs list, with the
+	// host of the engine URL when the driver serves it on another address
+	hosts := append(append([]string{}, authOptions.ServerCertSANs...), ip, "localhost")
+	dockerURL, err := driver.GetURL()
 	if err != nil {
 		return err
 	}
+	if u, err := url.Parse(dockerURL); err == nil && u.Hostname() != "" && u.Hostname() != ip {
+		hosts = append(hosts, u.Hostname())
+	}
+	log.Debugf("generating server cert: %s ca-key=%s private-key=%s org=%s san=%s",
+		authOptions.ServerCertPath,
+		authOptions.CaCertPath,
+		authOptions.CaPrivateKeyPath,
+		org,
+		hosts,
+	)
 
-	log.Info("Setting Docker configuration on the remote daemon...")
+	// TODO: Switch to passing just authOptions to this func
+	// instead of all these individual fields
+	err = cert.GenerateCert(&cert.Options{
+		Hosts:       hosts,
+		CertFile:    authOptions.ServerCertPath,
+		KeyFile:     authOptions.ServerKeyPath,
+		CAFile:      authOptions.CaCertPath,
+		CAKeyFile:   authOptions.CaPrivateKeyPath,
+		Org:         org,
+		Bits:        bits,
+		SwarmMaster: swarmOptions.Master,
 
-	if _, err = p.SSHCommand(fmt.Sprintf("sudo mkdir -p %s && printf %%s \"%s\" | sudo tee %s", path.Dir(dkrcfg.EngineOptionsPath), dkrcfg.EngineOptions, dkrcfg.EngineOptionsPath)); err != nil {
-		return err
-	}
+		SignerCommand: authOptions.CaSignerCommand,
+		Subject:       authOptions.ServerCertSubject,
+		KeyAlgorithm:  cert.KeyAlgorithm(authOptions.KeyAlgorithm),
+	})
 
-	if err := p.Service("docker", serviceaction.Restart); err != nil {
-		return err
+	if err != nil {
+		return fmt.Errorf("error generating server cert: %s", err)
 	}
 
-	return WaitForDocker(p, dockerPort)
+	return nil
+}
+
+// engineDockerPort returns the port of the Docker URL of the driver.
+func engineDockerPort(driver drivers.Driver) (int, error) {
+	dockerURL, err := driver.GetURL()
+	if err != nil {
+		return 0, err
+	}
+	u, err := url.Parse(dockerURL)
+	if err != nil {
+		return 0, err
+	}
+	dockerPort := engine.DefaultPort
+	if port := u.Port(); port != "" {
+		dPort, err := strconv.Atoi(port)
+		if err != nil {
+			return 0, err
+		}
+		dockerPort = dPort
+	}
+	return dockerPort, nil
 }
 
 func matchNetstatOut(reDaemonListening, netstatOut string) bool {
@@ -271,7 +333,7 @@ func checkDaemonUp(p Provisioner, dockerPort int) func() bool {
 }
 
 func WaitForDocker(p Provisioner, dockerPort int) error {
-	if err := mcnutils.WaitForSpecific(checkDaemonUp(p, dockerPort), 10, 3*time.Second); err != nil {
+	if err := mcnutils.WaitForOperation(mcnutils.RetryDocker, checkDaemonUp(p, dockerPort)); err != nil {
 		return NewErrDaemonAvailable(err)
 	}
 
@@ -300,6 +362,40 @@ func DockerClientVersion(ssh SSHCommander) (string, error) {
 	return strings.TrimRight(words[2], ","), nil
 }
 
+// minEd25519DockerVersion is the first version of Docker built with a Go
+// supporting Ed25519 in TLS.
+const minEd25519DockerVersion = "20.10.0"
+
+// checkKeyAlgorithm checks that the engine on the host supports the keys of
+// the certificates, the CA and client certificates being possibly shared
+// with machines created with another algorithm.
+func checkKeyAlgorithm(ssh SSHCommander, authOptions auth.Options) error {
+	ed25519 := cert.KeyAlgorithm(authOptions.KeyAlgorithm) == cert.Ed25519
+	for _, path := range []string{authOptions.CaCertPath, authOptions.ClientCertPath} {
+		if ed25519 {
+			break
+		}
+		uses, err := cert.UsesEd25519(path)
+		if err != nil {
+			log.Debugf("Error reading the key algorithm of %s: %s", path, err)
+			continue
+		}
+		ed25519 = uses
+	}
+	if !ed25519 {
+		return nil
+	}
+
+	dockerVersion, err := DockerClientVersion(ssh)
+	if err != nil {
+		return err
+	}
+	if versioncmp.LessThan(dockerVersion, minEd25519DockerVersion) {
+		return fmt.Errorf("Docker %s does not support Ed25519 keys, %s or later is required: use --tls-key-algorithm %s or %s", dockerVersion, minEd25519DockerVersion, cert.ECDSAP256, cert.RSA2048)
+	}
+	return nil
+}
+
 func waitForLockAptGetUpdate(ssh SSHCommander) error {
 	return waitForLock(ssh, "sudo apt-get update")
 }
@@ -325,3 +421,32 @@ func waitForLock(ssh SSHCommander, cmd string) error {
 	}
 	return nil
 }
+
+// shQuote quotes a string for the shell.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// executeTemplate executes the configuration template text with context,
+// with the trimScheme function removing the scheme of the URLs and base64
+// encoding strings.
+func executeTemplate(text string, context EngineConfigContext) (string, error) {
+	t, err := template.New("engineConfig").Funcs(template.FuncMap{
+		"trimScheme": func(u string) string {
+			if i := strings.Index(u, "://"); i >= 0 {
+				return u[i+3:]
+			}
+			return u
+		},
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	err = t.Execute(&out, context)
+	return out.String(), err
+}
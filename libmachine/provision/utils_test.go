@@ -1,17 +1,23 @@
 package provision
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/rancher/machine/drivers/fakedriver"
 	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
 	"github.com/rancher/machine/libmachine/engine"
 	"github.com/rancher/machine/libmachine/provision/pkgaction"
 	"github.com/rancher/machine/libmachine/provision/provisiontest"
 	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/state"
 	"github.com/rancher/machine/libmachine/swarm"
 	"github.com/stretchr/testify/assert"
 )
@@ -282,3 +288,76 @@ func TestDockerClientVersion(t *testing.T) {
 		}
 	}
 }
+
+type installedDockerProvisioner struct {
+	FakeProvisioner
+	commands []string
+}
+
+func (p *installedDockerProvisioner) SSHCommand(args string) (string, error) {
+	p.commands = append(p.commands, args)
+	return "docker is /usr/bin/docker", nil
+}
+
+func TestInstallDockerGenericSkipsInstalledDocker(t *testing.T) {
+	p := &installedDockerProvisioner{}
+
+	assert.NoError(t, installDockerGeneric(p, engine.Options{InstallURL: "https://get.docker.com"}))
+	assert.Equal(t, []string{"type docker"}, p.commands)
+}
+
+func TestCheckKeyAlgorithm(t *testing.T) {
+	sshCmder := &provisiontest.FakeSSHCommander{
+		Responses: map[string]string{"docker --version": "Docker version 19.03.15, build 99e3ed8\n"},
+	}
+
+	assert.NoError(t, checkKeyAlgorithm(sshCmder, auth.Options{KeyAlgorithm: "ecdsa-p256"}))
+	assert.Error(t, checkKeyAlgorithm(sshCmder, auth.Options{KeyAlgorithm: "ed25519"}))
+
+	sshCmder.Responses["docker --version"] = "Docker version 20.10.21, build baeda1f\n"
+	assert.NoError(t, checkKeyAlgorithm(sshCmder, auth.Options{KeyAlgorithm: "ed25519"}))
+}
+
+// urlDriver serves the engine on another address than its IP, as amazonec2
+// does on the interface of --amazonec2-docker-interface.
+type urlDriver struct {
+	fakedriver.Driver
+	url string
+}
+
+func (d *urlDriver) GetURL() (string, error) {
+	return d.url, nil
+}
+
+func TestGenerateServerCertURLHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authOptions := rotateTestAuthOptions(t, dir)
+	var driver drivers.Driver = &urlDriver{
+		Driver: fakedriver.Driver{MockName: "machine", MockState: state.Running, MockIP: "10.0.0.1"},
+		url:    "tcp://10.0.1.5:2376",
+	}
+	if err := generateServerCert(driver, authOptions, swarm.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(authOptions.ServerCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	serverCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ips := []string{}
+	for _, ip := range serverCert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.1.5"}, ips)
+}
@@ -0,0 +1,400 @@
+package provision
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"github.com/rancher/machine/libmachine/provision/pkgaction"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/rancher/machine/libmachine/swarm"
+	"github.com/rancher/machine/libmachine/winrm"
+)
+
+const (
+	windowsDockerDir = `C:\ProgramData\docker`
+
+	// windowsInstallURL installs Mirantis Container Runtime, formerly
+	// Docker EE, which is the engine supported on Windows Server.
+	windowsInstallURL = "https://get.mirantis.com/install.ps1"
+
+	// windowsChunkSize keeps each command writing a file below the command
+	// line limit once encoded.
+	windowsChunkSize = 2000
+)
+
+var reWindowsVersion = regexp.MustCompile(`Microsoft Windows \[Version ([0-9.]+)\]`)
+
+func init() {
+	Register("Windows", &RegisteredProvisioner{
+		New: NewWindowsProvisioner,
+	})
+}
+
+func NewWindowsProvisioner(d drivers.Driver) Provisioner {
+	return &WindowsProvisioner{
+		GenericProvisioner{
+			SSHCommander:      windowsCommander{Driver: d},
+			DockerOptionsDir:  windowsDockerDir,
+			DaemonOptionsFile: windowsDockerDir + `\config\daemon.json`,
+			OsReleaseID:       "windows",
+			Packages:          []string{},
+			Driver:            d,
+		},
+	}
+}
+
+// WindowsProvisioner provisions Windows Server hosts reachable over WinRM
+// or OpenSSH. Its commands are PowerShell scripts.
+type WindowsProvisioner struct {
+	GenericProvisioner
+}
+
+// windowsCommander runs PowerShell scripts over WinRM, or over SSH when the
+// driver does not use WinRM.
+type windowsCommander struct {
+	Driver drivers.Driver
+}
+
+func (c windowsCommander) SSHCommand(script string) (string, error) {
+	return runWindowsCommand(c.Driver, winrm.PowerShell("$ErrorActionPreference = 'Stop'\n$ProgressPreference = 'SilentlyContinue'\n"+script))
+}
+
+func runWindowsCommand(d drivers.Driver, command string) (string, error) {
+	opts, err := drivers.GetWinRMOptions(d)
+	if err != nil {
+		return "", err
+	}
+	if opts != nil {
+		return drivers.RunWinRMCommandFromDriver(d, command)
+	}
+	return drivers.RunSSHCommandFromDriver(d, command)
+}
+
+// windowsOsRelease builds the OS release info from the output of "ver",
+// e.g. "Microsoft Windows [Version 10.0.20348.587]".
+func windowsOsRelease(ver string) (*OsRelease, error) {
+	match := reWindowsVersion.FindStringSubmatch(ver)
+	if match == nil {
+		return nil, fmt.Errorf("unexpected Windows version %q", strings.TrimSpace(ver))
+	}
+	return &OsRelease{
+		ID:         "windows",
+		Name:       "Microsoft Windows",
+		VersionID:  match[1],
+		PrettyName: match[0],
+	}, nil
+}
+
+// psQuote quotes a string for PowerShell.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (provisioner *WindowsProvisioner) String() string {
+	return "windows"
+}
+
+func (provisioner *WindowsProvisioner) CompatibleWithHost() bool {
+	return provisioner.OsReleaseInfo.ID == provisioner.OsReleaseID
+}
+
+func (provisioner *WindowsProvisioner) Hostname() (string, error) {
+	hostname, err := provisioner.SSHCommand("hostname")
+	return strings.TrimSpace(hostname), err
+}
+
+// SetHostname renames the computer, which takes effect after a restart.
+func (provisioner *WindowsProvisioner) SetHostname(hostname string) error {
+	if current, err := provisioner.Hostname(); err == nil && strings.EqualFold(current, hostname) {
+		log.Debugf("hostname is already %s", hostname)
+		return nil
+	}
+
+	_, err := provisioner.SSHCommand(fmt.Sprintf("Rename-Computer -NewName %s -Force -WarningAction SilentlyContinue", psQuote(hostname)))
+	return err
+}
+
+func (provisioner *WindowsProvisioner) Package(name string, action pkgaction.PackageAction) error {
+	if name != "docker" || (action != pkgaction.Install && action != pkgaction.Upgrade) {
+		return fmt.Errorf("cannot %s %s on Windows", action, name)
+	}
+	return provisioner.runInstallScript(provisioner.EngineOptions.InstallURL)
+}
+
+func (provisioner *WindowsProvisioner) Service(name string, action serviceaction.ServiceAction) error {
+	var command string
+	switch action {
+	case serviceaction.Start:
+		command = "Start-Service -Name %s"
+	case serviceaction.Stop:
+		command = "Stop-Service -Name %s"
+	case serviceaction.Restart:
+		command = "Restart-Service -Name %s"
+	case serviceaction.Enable:
+		command = "Set-Service -Name %s -StartupType Automatic"
+	case serviceaction.Disable:
+		command = "Set-Service -Name %s -StartupType Disabled"
+	default:
+		return nil
+	}
+
+	_, err := provisioner.SSHCommand(fmt.Sprintf(command, psQuote(name)))
+	return err
+}
+
+func (provisioner *WindowsProvisioner) GenerateDockerOptions(dockerPort int) (*DockerOptions, error) {
+	driverNameLabel := fmt.Sprintf("provider=%s", provisioner.Driver.DriverName())
+	provisioner.EngineOptions.Labels = append(provisioner.EngineOptions.Labels, driverNameLabel)
+
+	if len(provisioner.EngineOptions.ArbitraryFlags) > 0 {
+		log.Warnf("Ignoring engine options %v, they are not supported on Windows", provisioner.EngineOptions.ArbitraryFlags)
+	}
+
+	config := map[string]interface{}{
+		"hosts":     []string{"npipe://", fmt.Sprintf("tcp://0.0.0.0:%d", dockerPort)},
+		"tlsverify": true,
+		"tlscacert": provisioner.AuthOptions.CaCertRemotePath,
+		"tlscert":   provisioner.AuthOptions.ServerCertRemotePath,
+		"tlskey":    provisioner.AuthOptions.ServerKeyRemotePath,
+		"labels":    provisioner.EngineOptions.Labels,
+	}
+	if len(provisioner.EngineOptions.InsecureRegistry) > 0 {
+		config["insecure-registries"] = provisioner.EngineOptions.InsecureRegistry
+	}
+	if len(provisioner.EngineOptions.RegistryMirror) > 0 {
+		config["registry-mirrors"] = provisioner.EngineOptions.RegistryMirror
+	}
+	if len(provisioner.EngineOptions.DNS) > 0 {
+		config["dns"] = provisioner.EngineOptions.DNS
+	}
+	if provisioner.EngineOptions.GraphDir != "" {
+		config["data-root"] = provisioner.EngineOptions.GraphDir
+	}
+	if provisioner.EngineOptions.LogLevel != "" {
+		config["log-level"] = provisioner.EngineOptions.LogLevel
+	}
+	if err := mergeDaemonJSON(config, provisioner.EngineOptions); err != nil {
+		return nil, err
+	}
+
+	engineCfg, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return &DockerOptions{
+		EngineOptions:     string(engineCfg),
+		EngineOptionsPath: provisioner.DaemonOptionsFile,
+	}, nil
+}
+
+func (provisioner *WindowsProvisioner) Provision(swarmOptions swarm.Options, authOptions auth.Options, engineOptions engine.Options) error {
+	provisioner.SwarmOptions = swarmOptions
+	provisioner.AuthOptions = authOptions
+	provisioner.EngineOptions = engineOptions
+
+	if swarmOptions.IsSwarm {
+		return errors.New("swarm is not supported on Windows")
+	}
+
+	log.Debug("setting hostname")
+	if err := provisioner.SetHostname(provisioner.Driver.GetMachineName()); err != nil {
+		return err
+	}
+
+	if err := provisioner.installDocker(engineOptions.InstallURL); err != nil {
+		return err
+	}
+
+	provisioner.AuthOptions = windowsAuthOptions(provisioner)
+
+	log.Debug("configuring auth")
+	if err := provisioner.configureAuth(); err != nil {
+		return err
+	}
+
+	return provisioner.Service("docker", serviceaction.Enable)
+}
+
+func (provisioner *WindowsProvisioner) installDocker(installURL string) error {
+	if strings.EqualFold(installURL, "none") {
+		log.Info("Skipping Docker installation")
+		return nil
+	}
+	if _, err := provisioner.SSHCommand("Get-Command docker | Out-Null"); err == nil {
+		log.Info("Docker is already installed, skipping installation")
+		return nil
+	}
+
+	if err := provisioner.runInstallScript(installURL); err != nil {
+		return err
+	}
+
+	return provisioner.restartIfPending()
+}
+
+// runInstallScript runs the PowerShell install script at the URL, the
+// Mirantis one unless another was given.
+func (provisioner *WindowsProvisioner) runInstallScript(installURL string) error {
+	if installURL == "" || installURL == drivers.DefaultEngineInstallURL {
+		installURL = windowsInstallURL
+	}
+
+	log.Infof("Installing Docker from: %s", installURL)
+	if _, err := provisioner.SSHCommand(fmt.Sprintf(`[Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12
+$script = Join-Path $env:TEMP 'install-docker.ps1'
+Invoke-WebRequest -UseBasicParsing -Uri %s -OutFile $script
+& $script
+Remove-Item -Path $script`, psQuote(installURL))); err != nil {
+		return fmt.Errorf("Error installing Docker: %s", err)
+	}
+
+	return nil
+}
+
+// restartIfPending restarts the host when the Containers feature was just
+// enabled, as it only works after a restart. This also applies a new
+// hostname.
+func (provisioner *WindowsProvisioner) restartIfPending() error {
+	out, err := provisioner.SSHCommand("(Get-WindowsOptionalFeature -Online -FeatureName Containers).State")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "EnablePending" {
+		return nil
+	}
+
+	bootTime := func() (string, error) {
+		return provisioner.SSHCommand("(Get-CimInstance -ClassName Win32_OperatingSystem).LastBootUpTime.ToString('o')")
+	}
+	before, err := bootTime()
+	if err != nil {
+		return err
+	}
+
+	log.Info("Restarting the host to enable the Containers feature...")
+	if _, err := provisioner.SSHCommand("Restart-Computer -Force"); err != nil {
+		// the connection may close before the command returns
+		log.Debugf("Restart-Computer: %s", err)
+	}
+
+	return mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		after, err := bootTime()
+		return err == nil && after != before, nil
+	}, 60, 5*time.Second)
+}
+
+func windowsAuthOptions(p Provisioner) auth.Options {
+	certsDir := p.GetDockerOptionsDir() + `\certs.d`
+	authOptions := p.GetAuthOptions()
+
+	authOptions.CaCertRemotePath = certsDir + `\ca.pem`
+	authOptions.ServerCertRemotePath = certsDir + `\server.pem`
+	authOptions.ServerKeyRemotePath = certsDir + `\server-key.pem`
+
+	return authOptions
+}
+
+func (provisioner *WindowsProvisioner) configureAuth() error {
+	if err := generateServerCert(provisioner.Driver, provisioner.AuthOptions, provisioner.SwarmOptions); err != nil {
+		return err
+	}
+
+	log.Info("Copying certs to the remote machine...")
+
+	authOptions := provisioner.AuthOptions
+	for local, remote := range map[string]string{
+		authOptions.CaCertPath:     authOptions.CaCertRemotePath,
+		authOptions.ServerCertPath: authOptions.ServerCertRemotePath,
+		authOptions.ServerKeyPath:  authOptions.ServerKeyRemotePath,
+	} {
+		data, err := ioutil.ReadFile(local)
+		if err != nil {
+			return err
+		}
+		if err := writeWindowsFile(provisioner, remote, data); err != nil {
+			return err
+		}
+	}
+
+	dockerPort, err := engineDockerPort(provisioner.Driver)
+	if err != nil {
+		return err
+	}
+
+	dkrcfg, err := provisioner.GenerateDockerOptions(dockerPort)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Setting Docker configuration on the remote daemon...")
+
+	if err := writeWindowsFile(provisioner, dkrcfg.EngineOptionsPath, []byte(dkrcfg.EngineOptions)); err != nil {
+		return err
+	}
+
+	rule := fmt.Sprintf("Docker TLS %d", dockerPort)
+	if _, err := provisioner.SSHCommand(fmt.Sprintf(
+		"if (-not (Get-NetFirewallRule -DisplayName %s -ErrorAction SilentlyContinue)) { New-NetFirewallRule -DisplayName %s -Direction Inbound -Protocol TCP -LocalPort %d -Action Allow | Out-Null }",
+		psQuote(rule), psQuote(rule), dockerPort,
+	)); err != nil {
+		return err
+	}
+
+	if err := provisioner.Service("docker", serviceaction.Restart); err != nil {
+		return err
+	}
+
+	return waitForWindowsDocker(provisioner, dockerPort)
+}
+
+// writeWindowsFile writes the file in base64 chunks, as a whole certificate
+// would not fit in a command line.
+func writeWindowsFile(ssh SSHCommander, path string, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	tmp := path + ".b64"
+
+	for i := 0; i < len(encoded); i += windowsChunkSize {
+		end := i + windowsChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		script := fmt.Sprintf("Add-Content -Path %s -Value %s -NoNewline", psQuote(tmp), psQuote(encoded[i:end]))
+		if i == 0 {
+			script = fmt.Sprintf("New-Item -ItemType Directory -Force -Path (Split-Path -Parent %s) | Out-Null\nSet-Content -Path %s -Value %s -NoNewline", psQuote(path), psQuote(tmp), psQuote(encoded[i:end]))
+		}
+		if _, err := ssh.SSHCommand(script); err != nil {
+			return err
+		}
+	}
+
+	_, err := ssh.SSHCommand(fmt.Sprintf("[IO.File]::WriteAllBytes(%s, [Convert]::FromBase64String((Get-Content -Raw -Path %s)))\nRemove-Item -Path %s", psQuote(path), psQuote(tmp), psQuote(tmp)))
+	return err
+}
+
+func waitForWindowsDocker(ssh SSHCommander, dockerPort int) error {
+	if err := mcnutils.WaitForSpecific(func() bool {
+		out, err := ssh.SSHCommand(fmt.Sprintf("[bool](Get-NetTCPConnection -State Listen -LocalPort %d -ErrorAction SilentlyContinue)", dockerPort))
+		if err != nil {
+			log.Warnf("Error running command: %s", err)
+			return false
+		}
+		return strings.TrimSpace(out) == "True"
+	}, 10, 3*time.Second); err != nil {
+		return NewErrDaemonAvailable(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package provision
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/auth"
+	"github.com/rancher/machine/libmachine/engine"
+	"github.com/rancher/machine/libmachine/provision/serviceaction"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSSHCommander struct {
+	commands []string
+}
+
+func (c *recordingSSHCommander) SSHCommand(args string) (string, error) {
+	c.commands = append(c.commands, args)
+	return "", nil
+}
+
+func TestWindowsOsRelease(t *testing.T) {
+	info, err := windowsOsRelease("\r\nMicrosoft Windows [Version 10.0.20348.587]\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.20348.587", info.VersionID)
+
+	p := NewWindowsProvisioner(nil)
+	p.SetOsReleaseInfo(info)
+	assert.True(t, p.CompatibleWithHost())
+
+	_, err = windowsOsRelease("'ver' is not recognized")
+	assert.Error(t, err)
+}
+
+func TestWindowsGenerateDockerOptions(t *testing.T) {
+	p := NewWindowsProvisioner(&fakedriver.Driver{}).(*WindowsProvisioner)
+	p.AuthOptions = windowsAuthOptions(p)
+	p.EngineOptions = engine.Options{
+		Labels:           []string{"env=test"},
+		InsecureRegistry: []string{"registry.local:5000"},
+	}
+
+	opts, err := p.GenerateDockerOptions(2376)
+	assert.NoError(t, err)
+	assert.Equal(t, `C:\ProgramData\docker\config\daemon.json`, opts.EngineOptionsPath)
+	assert.Equal(t, `{
+  "hosts": [
+    "npipe://",
+    "tcp://0.0.0.0:2376"
+  ],
+  "insecure-registries": [
+    "registry.local:5000"
+  ],
+  "labels": [
+    "env=test",
+    "provider=Driver"
+  ],
+  "tlscacert": "C:\\ProgramData\\docker\\certs.d\\ca.pem",
+  "tlscert": "C:\\ProgramData\\docker\\certs.d\\server.pem",
+  "tlskey": "C:\\ProgramData\\docker\\certs.d\\server-key.pem",
+  "tlsverify": true
+}`, opts.EngineOptions)
+}
+
+func TestWindowsAuthOptions(t *testing.T) {
+	p := NewWindowsProvisioner(nil).(*WindowsProvisioner)
+	p.AuthOptions = auth.Options{CaCertPath: "/store/ca.pem"}
+
+	authOptions := windowsAuthOptions(p)
+	assert.Equal(t, "/store/ca.pem", authOptions.CaCertPath)
+	assert.Equal(t, `C:\ProgramData\docker\certs.d\ca.pem`, authOptions.CaCertRemotePath)
+}
+
+func TestWindowsService(t *testing.T) {
+	p := NewWindowsProvisioner(nil).(*WindowsProvisioner)
+	commander := &recordingSSHCommander{}
+	p.SSHCommander = commander
+
+	assert.NoError(t, p.Service("docker", serviceaction.Restart))
+	assert.NoError(t, p.Service("docker", serviceaction.Enable))
+	assert.Equal(t, []string{
+		"Restart-Service -Name 'docker'",
+		"Set-Service -Name 'docker' -StartupType Automatic",
+	}, commander.commands)
+}
+
+func TestWriteWindowsFile(t *testing.T) {
+	commander := &recordingSSHCommander{}
+	data := []byte(strings.Repeat("certificate", 200))
+
+	assert.NoError(t, writeWindowsFile(commander, `C:\ProgramData\docker\certs.d\ca.pem`, data))
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	assert.Len(t, commander.commands, 3)
+	assert.Equal(t, `New-Item -ItemType Directory -Force -Path (Split-Path -Parent 'C:\ProgramData\docker\certs.d\ca.pem') | Out-Null
+Set-Content -Path 'C:\ProgramData\docker\certs.d\ca.pem.b64' -Value '`+encoded[:windowsChunkSize]+`' -NoNewline`, commander.commands[0])
+	assert.Equal(t, `Add-Content -Path 'C:\ProgramData\docker\certs.d\ca.pem.b64' -Value '`+encoded[windowsChunkSize:]+`' -NoNewline`, commander.commands[1])
+	assert.Equal(t, `[IO.File]::WriteAllBytes('C:\ProgramData\docker\certs.d\ca.pem', [Convert]::FromBase64String((Get-Content -Raw -Path 'C:\ProgramData\docker\certs.d\ca.pem.b64')))
+Remove-Item -Path 'C:\ProgramData\docker\certs.d\ca.pem.b64'`, commander.commands[2])
+}
+
+func TestPsQuote(t *testing.T) {
+	assert.Equal(t, `'it''s'`, psQuote("it's"))
+}
@@ -0,0 +1,220 @@
+// Package reconcile heals the machines found stopped or in error, restarting
+// or recreating them according to a policy.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rancher/machine/libmachine"
+	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/events"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/persist"
+	"github.com/rancher/machine/libmachine/state"
+)
+
+// Policy is what is done to the machines found stopped or in error.
+type Policy string
+
+const (
+	// Restart starts the machines again
+	Restart Policy = "restart"
+	// Recreate removes the resources of the machines and creates them
+	// again with the same configuration
+	Recreate Policy = "recreate"
+	// AlertOnly only reports the machines, publishing an Error event
+	AlertOnly Policy = "alert-only"
+)
+
+// maxBackoff bounds the wait before healing again a machine whose healing
+// failed.
+const maxBackoff = time.Hour
+
+// ParsePolicy returns the policy named s.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Restart, Recreate, AlertOnly:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid policy %q, expected %s, %s or %s", s, Restart, Recreate, AlertOnly)
+}
+
+// Result is what a pass of the reconciler did to a machine.
+type Result struct {
+	Machine string
+	State   state.State
+	// Action is the policy applied, empty if the machine was left as is
+	Action Policy
+	Err    error
+}
+
+// Reconciler heals the machines found stopped or in error.
+type Reconciler struct {
+	API    libmachine.API
+	Policy Policy
+	// Interval is the time between two passes of Run
+	Interval time.Duration
+	// Events publishes the alerts of the machines, and the events of
+	// their restarts
+	Events *events.Bus
+	// Report, if not nil, is called with the result of each machine of a
+	// pass
+	Report func(Result)
+
+	failures map[string]int
+	retryAt  map[string]time.Time
+}
+
+// Run reconciles the machines named every Interval, until ctx is done.
+func (r *Reconciler) Run(ctx context.Context, names []string) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.Reconcile(ctx, names)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile heals the machines named which are stopped or in error, once.
+// The machines whose healing failed are left alone for a time doubling with
+// each failure, up to an hour.
+func (r *Reconciler) Reconcile(ctx context.Context, names []string) []Result {
+	results := []Result{}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		if time.Now().Before(r.retryAt[name]) {
+			continue
+		}
+
+		result := r.reconcile(ctx, name)
+		r.recordResult(result)
+		if r.Report != nil {
+			r.Report(result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (r *Reconciler) recordResult(result Result) {
+	if r.failures == nil {
+		r.failures = map[string]int{}
+		r.retryAt = map[string]time.Time{}
+	}
+
+	if result.Err == nil || result.Action == "" {
+		delete(r.failures, result.Machine)
+		delete(r.retryAt, result.Machine)
+		return
+	}
+
+	r.failures[result.Machine]++
+	backoff := r.Interval << uint(r.failures[result.Machine]-1)
+	if backoff <= 0 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	r.retryAt[result.Machine] = time.Now().Add(backoff)
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, name string) Result {
+	result := Result{Machine: name}
+
+	// the machine is left alone while another process changes it
+	unlock, err := persist.LockHost(ctx, r.API, name)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer unlock()
+
+	h, err := r.API.Load(name)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	policy := r.Policy
+	if h.IsCreating() {
+		// create holds the lock, the creation of the machine failed
+		result.State = state.Error
+		if policy == Restart {
+			policy = AlertOnly
+		}
+	} else {
+		// the machines whose state cannot be told are not healed, the
+		// API of their driver may be down
+		if result.State, err = drivers.GetState(ctx, h.Driver); err != nil {
+			result.Err = fmt.Errorf("error getting the state: %s", err)
+			return result
+		}
+		if result.State != state.Stopped && result.State != state.Error {
+			return result
+		}
+	}
+
+	result.Action = policy
+	switch policy {
+	case Restart:
+		result.Err = r.restart(ctx, h)
+	case Recreate:
+		result.Err = r.recreate(ctx, h)
+	default:
+		log.Warnf("%s is %s", h.Name, result.State)
+		r.Events.Publish(events.New(h, events.Error, fmt.Errorf("the machine is %s", result.State)))
+	}
+	return result
+}
+
+func (r *Reconciler) restart(ctx context.Context, h *host.Host) error {
+	r.Events.Publish(events.New(h, events.Starting, nil))
+	if err := h.StartContext(ctx); err != nil {
+		r.Events.Publish(events.New(h, events.Error, err))
+		return err
+	}
+	r.Events.Publish(events.New(h, events.Started, nil))
+
+	if err := r.API.Save(h); err != nil {
+		return fmt.Errorf("Error saving host to store: %s", err)
+	}
+	return nil
+}
+
+// recreate removes the resources of h and creates it again, or resumes its
+// creation if it failed.
+func (r *Reconciler) recreate(ctx context.Context, h *host.Host) error {
+	if h.IsCreating() {
+		return r.API.CreateContext(ctx, h)
+	}
+
+	log.Infof("Removing the resources of %q to recreate it...", h.Name)
+	// the machine recreated has another host key
+	if err := h.ForgetHostKey(); err != nil {
+		log.Debugf("Error forgetting the host key of %s: %s", h.Name, err)
+	}
+	r.Events.Publish(events.New(h, events.Removing, nil))
+	if err := drivers.Remove(ctx, h.Driver); err != nil {
+		r.Events.Publish(events.New(h, events.Error, err))
+		return fmt.Errorf("error removing the machine: %s", err)
+	}
+	r.Events.Publish(events.New(h, events.Removed, nil))
+
+	// the creation resumes from its start if the process dies, rather
+	// than leaving a machine without resources
+	h.CreatePhase = host.CreateStarted
+	h.InvalidateIPCache()
+	if err := r.API.Save(h); err != nil {
+		return fmt.Errorf("Error saving host to store: %s", err)
+	}
+
+	return r.API.CreateContext(ctx, h)
+}
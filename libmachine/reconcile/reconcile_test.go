@@ -0,0 +1,96 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rancher/machine/drivers/fakedriver"
+	"github.com/rancher/machine/libmachine/events"
+	"github.com/rancher/machine/libmachine/host"
+	"github.com/rancher/machine/libmachine/libmachinetest"
+	"github.com/rancher/machine/libmachine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingRemoveDriver struct {
+	fakedriver.Driver
+}
+
+func (d *failingRemoveDriver) Remove() error {
+	return errors.New("quota exceeded")
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("alert-only")
+	assert.NoError(t, err)
+	assert.Equal(t, AlertOnly, policy)
+
+	_, err = ParsePolicy("reboot")
+	assert.EqualError(t, err, `invalid policy "reboot", expected restart, recreate or alert-only`)
+}
+
+func TestReconcileAlertOnly(t *testing.T) {
+	bus := &events.Bus{}
+	received, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+
+	r := &Reconciler{
+		API: &libmachinetest.FakeAPI{
+			Hosts: []*host.Host{
+				{Name: "running", Driver: &fakedriver.Driver{MockState: state.Running}},
+				{Name: "stopped", Driver: &fakedriver.Driver{MockState: state.Stopped}},
+			},
+		},
+		Policy: AlertOnly,
+		Events: bus,
+	}
+
+	results := r.Reconcile(context.Background(), []string{"running", "stopped"})
+
+	assert.Equal(t, []Result{
+		{Machine: "running", State: state.Running},
+		{Machine: "stopped", State: state.Stopped, Action: AlertOnly},
+	}, results)
+	e := <-received
+	assert.Equal(t, "stopped", e.Machine)
+	assert.Equal(t, events.Error, e.Type)
+	assert.Equal(t, "the machine is Stopped", e.Error)
+}
+
+func TestReconcileRecreate(t *testing.T) {
+	h := &host.Host{Name: "machine", Driver: &fakedriver.Driver{MockState: state.Error}}
+	r := &Reconciler{
+		API:    &libmachinetest.FakeAPI{Hosts: []*host.Host{h}},
+		Policy: Recreate,
+	}
+
+	results := r.Reconcile(context.Background(), []string{"machine"})
+
+	assert.Equal(t, []Result{{Machine: "machine", State: state.Error, Action: Recreate}}, results)
+	assert.Equal(t, host.CreateStarted, h.CreatePhase)
+}
+
+func TestReconcileBacksOff(t *testing.T) {
+	r := &Reconciler{
+		API: &libmachinetest.FakeAPI{
+			Hosts: []*host.Host{
+				{Name: "machine", Driver: &failingRemoveDriver{fakedriver.Driver{MockState: state.Stopped}}},
+			},
+		},
+		Policy:   Recreate,
+		Interval: time.Minute,
+	}
+
+	results := r.Reconcile(context.Background(), []string{"machine"})
+	assert.Len(t, results, 1)
+	assert.EqualError(t, results[0].Err, "error removing the machine: quota exceeded")
+
+	assert.Empty(t, r.Reconcile(context.Background(), []string{"machine"}))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), r.retryAt["machine"], 5*time.Second)
+
+	r.retryAt["machine"] = time.Now()
+	r.Reconcile(context.Background(), []string{"machine"})
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), r.retryAt["machine"], 5*time.Second)
+}
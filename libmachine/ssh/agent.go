@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/rancher/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned when forwarding the ssh-agent without one running.
+var ErrNoAgent = errors.New("no ssh-agent is running, SSH_AUTH_SOCK is not set")
+
+// AgentForwarder is implemented by the clients which can forward the local
+// ssh-agent to the machine, e.g. for ssh -A.
+type AgentForwarder interface {
+	// ForwardAgent has the shells of the client forward the agent
+	ForwardAgent() error
+}
+
+var (
+	// the connection to the agent is shared by the clients, as the signers
+	// use it for the lifetime of the process
+	agentOnce   sync.Once
+	agentClient agent.ExtendedAgent
+)
+
+// sshAgent returns the client of the agent at SSH_AUTH_SOCK, nil if none is
+// running.
+func sshAgent() agent.ExtendedAgent {
+	agentOnce.Do(func() {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			log.Debugf("Error connecting to the ssh-agent at %s: %s", sock, err)
+			return
+		}
+		agentClient = agent.NewClient(conn)
+	})
+	return agentClient
+}
+
+// agentAuth returns the authentication with the keys of the running agent,
+// nil if none is running.
+func agentAuth() ssh.AuthMethod {
+	a := sshAgent()
+	if a == nil {
+		return nil
+	}
+	return ssh.PublicKeysCallback(a.Signers)
+}
+
+// ForwardAgent has the shells of the client forward the local agent.
+func (client *NativeClient) ForwardAgent() error {
+	if sshAgent() == nil {
+		return ErrNoAgent
+	}
+	client.forwardAgent = true
+	return nil
+}
+
+// requestAgentForwarding forwards the agent to the machine over conn for
+// session.
+func (client *NativeClient) requestAgentForwarding(conn *ssh.Client, session *ssh.Session) error {
+	if err := agent.ForwardToAgent(conn, sshAgent()); err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(session)
+}
+
+// ForwardAgent has the shells of the client forward the local agent.
+func (client *ExternalClient) ForwardAgent() error {
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		return ErrNoAgent
+	}
+	client.BaseArgs = append([]string{"-A"}, client.BaseArgs...)
+	return nil
+}
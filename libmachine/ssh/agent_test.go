@@ -0,0 +1,25 @@
+package ssh
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalClientAgent(t *testing.T) {
+	os.Setenv("SSH_AUTH_SOCK", "/tmp/machine-test-agent.sock")
+	defer os.Unsetenv("SSH_AUTH_SOCK")
+
+	// the keys of the agent are used in place of a missing key file
+	client, err := NewExternalClient("/usr/bin/ssh", "docker", "10.0.0.2", 22, &Auth{Keys: []string{"/tmp/private-key-not-exist"}})
+	assert.NoError(t, err)
+	assert.NotContains(t, client.BaseArgs, "IdentitiesOnly=yes")
+	assert.NotContains(t, client.BaseArgs, "-i")
+
+	assert.NoError(t, client.ForwardAgent())
+	assert.Equal(t, "-A", client.BaseArgs[0])
+
+	os.Unsetenv("SSH_AUTH_SOCK")
+	assert.Equal(t, ErrNoAgent, client.ForwardAgent())
+}
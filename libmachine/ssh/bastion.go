@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/machine/libmachine/mcnutils"
+	"golang.org/x/crypto/ssh"
+)
+
+// Bastion is a jump host the SSH connections to a machine tunnel through,
+// e.g. to reach the machines with only private IPs.
+type Bastion struct {
+	Host string
+	// Port is the SSH port of the bastion, 22 if not set
+	Port int `json:",omitempty"`
+	// User is the user on the bastion, the local one if not set
+	User string `json:",omitempty"`
+	// KeyPath is the private key of the user on the bastion, the keys of the
+	// machine being used if not set
+	KeyPath string `json:",omitempty"`
+}
+
+// ParseBastion parses a bastion given as [user@]host[:port].
+func ParseBastion(s string) (*Bastion, error) {
+	b := &Bastion{Host: s}
+	if i := strings.LastIndex(b.Host, "@"); i >= 0 {
+		b.User, b.Host = b.Host[:i], b.Host[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(b.Host); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, fmt.Errorf("invalid port %q of the bastion %q", port, s)
+		}
+		b.Host, b.Port = host, p
+	}
+	b.Host = mcnutils.UnbracketHost(b.Host)
+	if b.Host == "" || strings.ContainsAny(b.Host, " \t") {
+		return nil, fmt.Errorf("invalid bastion %q, expected [user@]host[:port]", s)
+	}
+	return b, nil
+}
+
+func (b *Bastion) user() string {
+	if b.User == "" {
+		return mcnutils.GetUsername()
+	}
+	return b.User
+}
+
+func (b *Bastion) addr() string {
+	port := b.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(b.Host, strconv.Itoa(port))
+}
+
+// String returns the bastion as user@host:port.
+func (b *Bastion) String() string {
+	return b.user() + "@" + b.addr()
+}
+
+// ProxyCommand returns the command of the ssh binary at sshBinaryPath
+// forwarding the connections through the bastion, for the ProxyCommand
+// option of the external clients.
+func (b *Bastion) ProxyCommand(sshBinaryPath string) string {
+	args := []string{
+		fmt.Sprintf("%q", sshBinaryPath),
+		"-F", "/dev/null",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "LogLevel=quiet",
+	}
+	if b.KeyPath != "" {
+		args = append(args, "-o", "IdentitiesOnly=yes", "-i", fmt.Sprintf("%q", b.KeyPath))
+	}
+	port := b.Port
+	if port == 0 {
+		port = 22
+	}
+	args = append(args, "-p", strconv.Itoa(port), "-W", "%h:%p", b.user()+"@"+mcnutils.BracketHost(b.Host))
+	return strings.Join(args, " ")
+}
+
+// config returns the configuration of the connections to the bastion, with
+// the key of the bastion or else the authentication of machineConfig.
+func (b *Bastion) config(machineConfig ssh.ClientConfig) (ssh.ClientConfig, error) {
+	config := machineConfig
+	config.User = b.user()
+	// the key of the bastion is recorded under its address, not the alias
+	// of the machine
+	config.HostKeyCallback = hostKeyCallback("")
+	if b.KeyPath != "" {
+		signer, err := NewSigner(b.KeyPath)
+		if err != nil {
+			return ssh.ClientConfig{}, err
+		}
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	}
+	return config, nil
+}
+
+// dial connects to addr through the bastion, connected to with
+// bastionConfig. The connection to the bastion is closed with the returned
+// client.
+func (b *Bastion) dial(addr string, bastionConfig, config *ssh.ClientConfig) (*ssh.Client, error) {
+	bastion, err := ssh.Dial("tcp", b.addr(), bastionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error dialing the bastion %s: %s", b, err)
+	}
+
+	conn, err := bastion.Dial("tcp", addr)
+	if err != nil {
+		closeConn(bastion)
+		return nil, fmt.Errorf("Error dialing %s through the bastion %s: %s", addr, b, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		closeConn(bastion)
+		return nil, err
+	}
+
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		client.Wait()
+		closeConn(bastion)
+	}()
+	return client, nil
+}
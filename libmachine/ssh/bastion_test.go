@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBastion(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Bastion
+	}{
+		{"bastion.example.com", Bastion{Host: "bastion.example.com"}},
+		{"jump@bastion.example.com:2222", Bastion{Host: "bastion.example.com", Port: 2222, User: "jump"}},
+		{"[fd00::1]:22", Bastion{Host: "fd00::1", Port: 22}},
+	}
+	for _, c := range cases {
+		bastion, err := ParseBastion(c.in)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, *bastion)
+	}
+
+	for _, in := range []string{"", "jump@", "bastion:ssh", "bastion:0"} {
+		_, err := ParseBastion(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestExternalClientSetBastion(t *testing.T) {
+	client := &ExternalClient{BinaryPath: "/usr/bin/ssh", BaseArgs: []string{"docker@10.0.0.2"}}
+	client.SetBastion(&Bastion{Host: "bastion.example.com", User: "jump", KeyPath: "/keys/jump"})
+
+	assert.Equal(t, []string{
+		"-o", `ProxyCommand="/usr/bin/ssh" -F /dev/null -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=quiet -o IdentitiesOnly=yes -i "/keys/jump" -p 22 -W %h:%p jump@bastion.example.com`,
+		"docker@10.0.0.2",
+	}, client.BaseArgs)
+}
@@ -43,16 +43,26 @@ type ExternalClient struct {
 }
 
 type NativeClient struct {
-	Config      ssh.ClientConfig
-	Hostname    string
-	Port        int
-	openSession *ssh.Session
-	openClient  *ssh.Client
+	Config   ssh.ClientConfig
+	Hostname string
+	Port     int
+	// Bastion, if set, is the jump host the connections tunnel through,
+	// connected to with BastionConfig
+	Bastion       *Bastion
+	BastionConfig ssh.ClientConfig
+	openSession   *ssh.Session
+	openClient    *ssh.Client
+	forwardAgent  bool
+	forwards      []Forward
+	forwardOnly   bool
 }
 
 type Auth struct {
 	Passwords []string
 	Keys      []string
+	// HostKeyAlias, if set, is the name the host key of the machine is
+	// recorded under in the known hosts file, rather than its address
+	HostKeyAlias string
 }
 
 type ClientType string
@@ -77,8 +87,6 @@ var (
 		"-o", "LogLevel=quiet", // suppress "Warning: Permanently added '[localhost]:2022' (ECDSA) to the list of known hosts."
 		"-o", "PasswordAuthentication=no",
 		"-o", "ServerAliveInterval=60", // prevents connection to be dropped if command takes too long
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
 	}
 	defaultClientType = External
 )
@@ -95,24 +103,42 @@ func SetDefaultClient(clientType ClientType) {
 	}
 }
 
+// GetDefaultClient returns the type of the clients NewClient returns when the
+// ssh binary is found.
+func GetDefaultClient() ClientType {
+	return defaultClientType
+}
+
 func NewClient(user string, host string, port int, auth *Auth) (Client, error) {
-	sshBinaryPath, err := exec.LookPath("ssh")
-	if err != nil {
-		log.Debug("SSH binary not found, using native Go implementation")
-		client, err := NewNativeClient(user, host, port, auth)
-		log.Debug(client)
-		return client, err
-	}
+	return NewClientWithBastion(user, host, port, auth, nil)
+}
 
-	if defaultClientType == Native {
-		log.Debug("Using SSH client type: native")
+// NewClientWithBastion returns a client like NewClient, tunnelling through
+// bastion if it is not nil.
+func NewClientWithBastion(user string, host string, port int, auth *Auth, bastion *Bastion) (Client, error) {
+	sshBinaryPath, err := exec.LookPath("ssh")
+	if err != nil || defaultClientType == Native || len(auth.Passwords) > 0 {
+		if err != nil {
+			log.Debug("SSH binary not found, using native Go implementation")
+		} else if len(auth.Passwords) > 0 {
+			// the ssh binary only reads passwords from the terminal
+			log.Debug("Using native Go implementation for the SSH password")
+		} else {
+			log.Debug("Using SSH client type: native")
+		}
 		client, err := NewNativeClient(user, host, port, auth)
+		if err == nil && bastion != nil {
+			err = client.(*NativeClient).SetBastion(bastion)
+		}
 		log.Debug(client)
 		return client, err
 	}
 
 	log.Debug("Using SSH client type: external")
 	client, err := NewExternalClient(sshBinaryPath, user, host, port, auth)
+	if err == nil && bastion != nil {
+		client.SetBastion(bastion)
+	}
 	log.Debug(client)
 	return client, err
 }
@@ -135,33 +161,69 @@ func NewNativeConfig(user string, auth *Auth) (ssh.ClientConfig, error) {
 		authMethods []ssh.AuthMethod
 	)
 
+	// the keys are tried by a single method, as the methods are tried once
+	// per type
+	var signers []ssh.Signer
+	agent := sshAgent()
 	for _, k := range auth.Keys {
-		key, err := ioutil.ReadFile(k)
-		if err != nil {
-			return ssh.ClientConfig{}, err
+		signer, err := NewSigner(k)
+		if os.IsNotExist(err) && agent != nil {
+			log.Debugf("SSH private key %s not found, using the keys of the ssh-agent", k)
+			continue
 		}
-
-		privateKey, err := ssh.ParsePrivateKey(key)
 		if err != nil {
 			return ssh.ClientConfig{}, err
 		}
 
-		authMethods = append(authMethods, ssh.PublicKeys(privateKey))
+		signers = append(signers, signer)
 	}
 
-	for _, p := range auth.Passwords {
-		authMethods = append(authMethods, ssh.Password(p))
+	if len(signers) > 0 || agent != nil {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agent == nil {
+				return signers, nil
+			}
+			agentSigners, err := agent.Signers()
+			if err != nil {
+				log.Debugf("Error getting the keys of the ssh-agent: %s", err)
+				return signers, nil
+			}
+			return append(append([]ssh.Signer{}, signers...), agentSigners...), nil
+		}))
 	}
 
+	// the passwords are tried when no key is accepted, then prompted for
+	passwords := newPasswordSource(user, auth.Passwords)
+	authMethods = append(authMethods, passwords.methods()...)
+
 	return ssh.ClientConfig{
 		User:            user,
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: passwords.hostKeyCallback(hostKeyCallback(auth.HostKeyAlias)),
 	}, nil
 }
 
+// SetBastion has the client tunnel through bastion.
+func (client *NativeClient) SetBastion(bastion *Bastion) error {
+	config, err := bastion.config(client.Config)
+	if err != nil {
+		return fmt.Errorf("Error getting config for the bastion %s: %s", bastion, err)
+	}
+	client.Bastion = bastion
+	client.BastionConfig = config
+	return nil
+}
+
+func (client *NativeClient) dial() (*ssh.Client, error) {
+	addr := net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port))
+	if client.Bastion != nil {
+		return client.Bastion.dial(addr, &client.BastionConfig, &client.Config)
+	}
+	return ssh.Dial("tcp", addr, &client.Config)
+}
+
 func (client *NativeClient) dialSuccess() bool {
-	conn, err := ssh.Dial("tcp", net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port)), &client.Config)
+	conn, err := client.dial()
 	if err != nil {
 		log.Debugf("Error dialing TCP: %s", err)
 		return false
@@ -170,26 +232,49 @@ func (client *NativeClient) dialSuccess() bool {
 	return true
 }
 
+// poolKey identifies the connections of the client in the pool.
+func (client *NativeClient) poolKey() string {
+	key := fmt.Sprintf("%s@%s", client.Config.User, net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port)))
+	if client.Bastion != nil {
+		key += " via " + client.Bastion.String()
+	}
+	return key
+}
+
+// session opens a session on the connection to the machine kept in the pool
+// or else on a new one, which release closes unless it is kept.
 func (client *NativeClient) session(command string) (*ssh.Client, *ssh.Session, error) {
+	if conn, session := pool.session(client.poolKey()); conn != nil {
+		return conn, session, nil
+	}
+
 	if err := mcnutils.WaitFor(client.dialSuccess); err != nil {
 		return nil, nil, fmt.Errorf("Error attempting SSH client dial: %s", err)
 	}
 
-	conn, err := ssh.Dial("tcp", net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port)), &client.Config)
+	conn, err := client.dial()
 	if err != nil {
 		return nil, nil, fmt.Errorf("Mysterious error dialing TCP for SSH (we already succeeded at least once) : %s", err)
 	}
+	pool.put(client.poolKey(), conn)
 	session, err := conn.NewSession()
 
 	return conn, session, err
 }
 
+// release closes conn, opened by session, unless it is kept in the pool.
+func (client *NativeClient) release(conn *ssh.Client) {
+	if err := pool.release(client.poolKey(), conn); err != nil {
+		log.Debugf("Error closing SSH Client: %s", err)
+	}
+}
+
 func (client *NativeClient) Output(command string) (string, error) {
 	conn, session, err := client.session(command)
 	if err != nil {
 		return "", nil
 	}
-	defer closeConn(conn)
+	defer client.release(conn)
 	defer session.Close()
 
 	output, err := session.CombinedOutput(command)
@@ -202,7 +287,7 @@ func (client *NativeClient) OutputWithPty(command string) (string, error) {
 	if err != nil {
 		return "", nil
 	}
-	defer closeConn(conn)
+	defer client.release(conn)
 	defer session.Close()
 
 	fd := int(os.Stdout.Fd())
@@ -260,7 +345,7 @@ func (client *NativeClient) Wait() error {
 
 	_ = client.openSession.Close()
 
-	err = client.openClient.Close()
+	err = pool.release(client.poolKey(), client.openClient)
 	if err != nil {
 		return err
 	}
@@ -274,12 +359,23 @@ func (client *NativeClient) Shell(args ...string) error {
 	var (
 		termWidth, termHeight int
 	)
-	conn, err := ssh.Dial("tcp", net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port)), &client.Config)
+	conn, err := client.dial()
 	if err != nil {
 		return err
 	}
 	defer closeConn(conn)
 
+	stopForwards, err := client.startForwards(conn)
+	if err != nil {
+		return err
+	}
+	defer stopForwards()
+
+	if client.forwardOnly {
+		// until the connection is lost or the process interrupted
+		return conn.Wait()
+	}
+
 	session, err := conn.NewSession()
 	if err != nil {
 		return err
@@ -287,6 +383,12 @@ func (client *NativeClient) Shell(args ...string) error {
 
 	defer session.Close()
 
+	if client.forwardAgent {
+		if err := client.requestAgentForwarding(conn, session); err != nil {
+			return err
+		}
+	}
+
 	session.Stdout = os.Stdout
 	session.Stderr = os.Stderr
 	session.Stdin = os.Stdin
@@ -338,7 +440,13 @@ func NewExternalClient(sshBinaryPath, user, host string, port int, auth *Auth) (
 	client := &ExternalClient{
 		BinaryPath: sshBinaryPath,
 	}
-	var args []string
+	// the connections are multiplexed and the host keys verified if set,
+	// ssh using the first value given for an option
+	args := append(append(pool.controlArgs(), baseSSHArgs...), HostKeyCheckingArgs(auth.HostKeyAlias)...)
+	if canPrompt() {
+		// ssh prompts for the password itself
+		args = append([]string{"-o", "PasswordAuthentication=yes"}, args...)
+	}
 	// http proxy should be used for the SSH connection
 	proxy, err := util.GetProxyURL("http://" + host)
 	if err != nil {
@@ -351,22 +459,23 @@ func NewExternalClient(sshBinaryPath, user, host string, port int, auth *Auth) (
 	ncBinaryPath, _ := exec.LookPath("nc")
 	log.Debugf("proxy_url: %s; ncBinaryPath: %s", proxy_url, ncBinaryPath)
 	if proxy_url != "" && ncBinaryPath != "" {
-		args = append(baseSSHArgs, "-o", fmt.Sprintf(SSHProxyArg, ncBinaryPath, proxy_url), fmt.Sprintf("%s@%s", user, host))
+		args = append(args, "-o", fmt.Sprintf(SSHProxyArg, ncBinaryPath, proxy_url), fmt.Sprintf("%s@%s", user, host))
 	} else {
-		args = append(baseSSHArgs, fmt.Sprintf("%s@%s", user, host))
-	}
-
-	// If no identities are explicitly provided, also look at the identities
-	// offered by ssh-agent
-	if len(auth.Keys) > 0 {
-		args = append(args, "-o", "IdentitiesOnly=yes")
+		args = append(args, fmt.Sprintf("%s@%s", user, host))
 	}
 
 	// Specify which private keys to use to authorize the SSH request.
+	var identities []string
+	missing := 0
 	for _, privateKeyPath := range auth.Keys {
 		if privateKeyPath != "" {
 			// Check each private key before use it
 			fi, err := os.Stat(privateKeyPath)
+			if os.IsNotExist(err) && os.Getenv("SSH_AUTH_SOCK") != "" {
+				log.Debugf("SSH private key %s not found, using the keys of the ssh-agent", privateKeyPath)
+				missing++
+				continue
+			}
 			if err != nil {
 				// Abort if key not accessible
 				return nil, err
@@ -383,10 +492,17 @@ func NewExternalClient(sshBinaryPath, user, host string, port int, auth *Auth) (
 					return nil, fmt.Errorf("permissions %#o for '%s' are too open", perm, privateKeyPath)
 				}
 			}
-			args = append(args, "-i", privateKeyPath)
+			identities = append(identities, "-i", privateKeyPath)
 		}
 	}
 
+	// If no identities are explicitly provided, also look at the identities
+	// offered by ssh-agent
+	if len(auth.Keys) > missing {
+		args = append(args, "-o", "IdentitiesOnly=yes")
+	}
+	args = append(args, identities...)
+
 	// Set which port to use for SSH.
 	args = append(args, "-p", fmt.Sprintf("%d", port))
 
@@ -395,6 +511,14 @@ func NewExternalClient(sshBinaryPath, user, host string, port int, auth *Auth) (
 	return client, nil
 }
 
+// SetBastion has the client tunnel through bastion, in place of the http
+// proxy.
+func (client *ExternalClient) SetBastion(bastion *Bastion) {
+	// ssh uses the first value given for an option
+	proxyArgs := []string{"-o", "ProxyCommand=" + bastion.ProxyCommand(client.BinaryPath)}
+	client.BaseArgs = append(proxyArgs, client.BaseArgs...)
+}
+
 func getSSHCmd(binaryPath string, args ...string) *exec.Cmd {
 	// remove the quote to avoid parsing errors
 	for i, arg := range args {
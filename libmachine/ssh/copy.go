@@ -0,0 +1,466 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// partSuffix is that of the files being copied, renamed once complete. The
+// copies interrupted resume from them.
+const partSuffix = ".machine-part"
+
+// CopyOptions are the options of the copies of the native client.
+type CopyOptions struct {
+	// Recursive copies the directories
+	Recursive bool
+	// Delta skips the files whose content is the same on both sides, e.g.
+	// for large repeated uploads
+	Delta bool
+	// Progress, if set, is written the progress of the files copied
+	Progress io.Writer
+}
+
+// remoteFile is a file or directory on the machine.
+type remoteFile struct {
+	path string
+	dir  bool
+	mode os.FileMode
+	size int64
+}
+
+// Upload copies the local file or directory src to dest on the machine, into
+// dest if it is a directory. Only the machine needs a POSIX shell, with find,
+// stat and sha256sum for the delta copies.
+func (client *NativeClient) Upload(src, dest string, opts CopyOptions) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%s is a directory, copy it recursively", src)
+	}
+
+	target := dest
+	isDir, err := client.isRemoteDir(dest)
+	if err != nil {
+		return err
+	}
+	if isDir || strings.HasSuffix(dest, "/") {
+		target = path.Join(dest, filepath.Base(src))
+	}
+
+	remote, err := client.listRemote(target)
+	if err != nil {
+		return err
+	}
+	var hashes map[string]string
+	if opts.Delta {
+		if hashes, err = client.remoteHashes(target); err != nil {
+			return err
+		}
+	}
+
+	dirs := []string{path.Dir(target)}
+	var files []string
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, remotePath(target, src, p))
+		} else if fi.Mode().IsRegular() {
+			files = append(files, p)
+		} else {
+			log.Warnf("Skipping %s, not a regular file", p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := client.run("mkdir -p "+shellQuoteAll(dirs), nil, nil); err != nil {
+		return err
+	}
+
+	for _, p := range files {
+		if err := client.uploadFile(p, remotePath(target, src, p), remote, hashes, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remotePath returns the path on the machine of the local p under src,
+// copied to target.
+func remotePath(target, src, p string) string {
+	rel, _ := filepath.Rel(src, p)
+	return path.Join(target, filepath.ToSlash(rel))
+}
+
+func (client *NativeClient) uploadFile(p, target string, remote map[string]remoteFile, hashes map[string]string, opts CopyOptions) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if hash, ok := hashes[target]; ok {
+		local, err := fileHash(p)
+		if err != nil {
+			return err
+		}
+		if local == hash {
+			log.Debugf("Skipping %s, unchanged on the machine", p)
+			return nil
+		}
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	part := target + partSuffix
+	redirect := ">"
+	var offset int64
+	if partial, ok := remote[part]; ok && partial.size <= fi.Size() {
+		// the part is of another version of the file if its content differs
+		local, err := filePrefixHash(p, partial.size)
+		if err != nil {
+			return err
+		}
+		hash, err := client.remotePrefixHash(part, partial.size)
+		if err != nil {
+			return err
+		}
+		if local == hash {
+			offset = partial.size
+			redirect = ">>"
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			log.Debugf("Resuming the copy of %s at %d bytes", p, offset)
+		} else {
+			log.Debugf("Copying %s again, the interrupted copy is of another version", p)
+		}
+	}
+
+	progress := newCopyProgress(opts.Progress, filepath.Base(p), fi.Size(), offset)
+	defer progress.done()
+
+	command := fmt.Sprintf("cat %s %s && chmod %o %s && mv -f %s %s",
+		redirect, shellQuote(part), fi.Mode().Perm(), shellQuote(part), shellQuote(part), shellQuote(target))
+	return client.run(command, io.TeeReader(f, progress), nil)
+}
+
+// Download copies the file or directory src on the machine to the local
+// dest, into dest if it is a directory.
+func (client *NativeClient) Download(src, dest string, opts CopyOptions) error {
+	src = path.Clean(src)
+	remote, err := client.listRemote(src)
+	if err != nil {
+		return err
+	}
+	root, ok := remote[src]
+	if !ok {
+		return fmt.Errorf("%s: no such file or directory on the machine", src)
+	}
+	if root.dir && !opts.Recursive {
+		return fmt.Errorf("%s is a directory, copy it recursively", src)
+	}
+
+	var hashes map[string]string
+	if opts.Delta {
+		if hashes, err = client.remoteHashes(src); err != nil {
+			return err
+		}
+	}
+
+	target := dest
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		target = filepath.Join(dest, path.Base(src))
+	}
+
+	for _, f := range sortedRemoteFiles(remote) {
+		if strings.HasSuffix(f.path, partSuffix) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(f.path, src), "/")
+		local := filepath.Join(target, filepath.FromSlash(rel))
+		if f.dir {
+			if err := os.MkdirAll(local, f.mode|0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			return err
+		}
+		if err := client.downloadFile(f, local, hashes[f.path], opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *NativeClient) downloadFile(f remoteFile, local, hash string, opts CopyOptions) error {
+	if hash != "" {
+		if localHash, err := fileHash(local); err == nil && localHash == hash {
+			log.Debugf("Skipping %s, unchanged locally", f.path)
+			return nil
+		}
+	}
+
+	part := local + partSuffix
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var offset int64
+	if fi, err := os.Stat(part); err == nil && fi.Size() <= f.size {
+		// the part is of another version of the file if its content differs
+		local, err := filePrefixHash(part, fi.Size())
+		if err != nil {
+			return err
+		}
+		hash, err := client.remotePrefixHash(f.path, fi.Size())
+		if err != nil {
+			return err
+		}
+		if local == hash {
+			offset = fi.Size()
+			flags = os.O_WRONLY | os.O_APPEND
+			log.Debugf("Resuming the copy of %s at %d bytes", f.path, offset)
+		} else {
+			log.Debugf("Copying %s again, the interrupted copy is of another version", f.path)
+		}
+	}
+
+	out, err := os.OpenFile(part, flags, f.mode)
+	if err != nil {
+		return err
+	}
+
+	progress := newCopyProgress(opts.Progress, path.Base(f.path), f.size, offset)
+	err = client.run(fmt.Sprintf("tail -c +%d %s", offset+1, shellQuote(f.path)), nil, io.MultiWriter(out, progress))
+	progress.done()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(part, f.mode); err != nil {
+		return err
+	}
+	return os.Rename(part, local)
+}
+
+// isRemoteDir tells whether p is a directory on the machine.
+func (client *NativeClient) isRemoteDir(p string) (bool, error) {
+	var out bytes.Buffer
+	if err := client.run(fmt.Sprintf("if [ -d %s ]; then echo dir; fi", shellQuote(p)), nil, &out); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out.String()) == "dir", nil
+}
+
+// listRemote returns the files and directories under root on the machine,
+// by path, none if it does not exist.
+func (client *NativeClient) listRemote(root string) (map[string]remoteFile, error) {
+	var out bytes.Buffer
+	command := fmt.Sprintf("find %s \\( -type d -o -type f \\) -exec stat -c '%%f %%s %%n' {} + 2>/dev/null; true", shellQuote(root))
+	if err := client.run(command, nil, &out); err != nil {
+		return nil, err
+	}
+
+	files := map[string]remoteFile{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		// the raw mode in hex, the size and the path
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		mode, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		files[fields[2]] = remoteFile{
+			path: fields[2],
+			dir:  mode&0170000 == 0040000,
+			mode: os.FileMode(mode & 0777),
+			size: size,
+		}
+	}
+	return files, scanner.Err()
+}
+
+// remoteHashes returns the SHA-256 of the files under root on the machine,
+// by path.
+func (client *NativeClient) remoteHashes(root string) (map[string]string, error) {
+	var out bytes.Buffer
+	command := fmt.Sprintf("find %s -type f ! -name '*%s' -exec sha256sum {} + 2>/dev/null; true", shellQuote(root), partSuffix)
+	if err := client.run(command, nil, &out); err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if fields := strings.SplitN(scanner.Text(), "  ", 2); len(fields) == 2 {
+			hashes[fields[1]] = fields[0]
+		}
+	}
+	return hashes, scanner.Err()
+}
+
+// remotePrefixHash returns the SHA-256 of the first n bytes of the file p on
+// the machine.
+func (client *NativeClient) remotePrefixHash(p string, n int64) (string, error) {
+	var out bytes.Buffer
+	if err := client.run(fmt.Sprintf("head -c %d %s | sha256sum", n, shellQuote(p)), nil, &out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(out.String()), "-")), nil
+}
+
+// run runs command on the machine with stdin and stdout, its stderr in the
+// error.
+func (client *NativeClient) run(command string, stdin io.Reader, stdout io.Writer) error {
+	conn, session, err := client.session(command)
+	if err != nil {
+		return err
+	}
+	defer client.release(conn)
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func sortedRemoteFiles(files map[string]remoteFile) []remoteFile {
+	sorted := make([]remoteFile, 0, len(files))
+	for _, f := range files {
+		sorted = append(sorted, f)
+	}
+	// the directories before their content
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+	return sorted
+}
+
+func fileHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// filePrefixHash returns the SHA-256 of the first n bytes of the file p.
+func filePrefixHash(p string, n int64) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellQuoteAll(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// copyProgress writes the progress of the copy of a file, as scp does.
+type copyProgress struct {
+	w       io.Writer
+	name    string
+	size    int64
+	copied  int64
+	written time.Time
+}
+
+func newCopyProgress(w io.Writer, name string, size, offset int64) *copyProgress {
+	p := &copyProgress{w: w, name: name, size: size, copied: offset}
+	p.print()
+	return p
+}
+
+func (p *copyProgress) Write(b []byte) (int, error) {
+	p.copied += int64(len(b))
+	if time.Since(p.written) > 100*time.Millisecond {
+		p.print()
+	}
+	return len(b), nil
+}
+
+func (p *copyProgress) print() {
+	if p.w == nil {
+		return
+	}
+	percent := int64(100)
+	if p.size > 0 {
+		percent = p.copied * 100 / p.size
+	}
+	fmt.Fprintf(p.w, "\r%-40s %3d%% %10s", p.name, percent, formatSize(p.copied))
+	p.written = time.Now()
+}
+
+func (p *copyProgress) done() {
+	if p.w == nil {
+		return
+	}
+	p.print()
+	fmt.Fprintln(p.w)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
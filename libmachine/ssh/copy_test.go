@@ -0,0 +1,174 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// serveShell serves the exec requests on l, running their command with sh
+// as the machines do.
+func serveShell(l net.Listener, config *ssh.ServerConfig) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(c, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					for req := range requests {
+						if req.Type != "exec" {
+							req.Reply(false, nil)
+							continue
+						}
+						req.Reply(true, nil)
+
+						cmd := exec.Command("sh", "-c", string(req.Payload[4:]))
+						cmd.Stdin = channel
+						cmd.Stdout = channel
+						cmd.Stderr = channel.Stderr()
+						status := uint32(0)
+						if err := cmd.Run(); err != nil {
+							status = 1
+						}
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+						return
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func newShellClient(t *testing.T) *NativeClient {
+	if runtime.GOOS == "windows" {
+		t.Skip("the machine commands need a POSIX shell")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serveShell(l, newServerConfig(t))
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := NewNativeClient("docker", host, p, &Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*NativeClient)
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func readFile(t *testing.T, p string) string {
+	content, err := ioutil.ReadFile(p)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestNativeClientUploadRecursive(t *testing.T) {
+	client := newShellClient(t)
+
+	src := filepath.Join(t.TempDir(), "app")
+	writeFiles(t, src, map[string]string{"main.go": "package main", "sub/data.txt": "data"})
+	assert.NoError(t, os.Chmod(filepath.Join(src, "main.go"), 0755))
+	machine := t.TempDir()
+
+	assert.Error(t, client.Upload(src, machine, CopyOptions{}))
+
+	var progress bytes.Buffer
+	assert.NoError(t, client.Upload(src, machine, CopyOptions{Recursive: true, Progress: &progress}))
+	assert.Equal(t, "package main", readFile(t, filepath.Join(machine, "app", "main.go")))
+	assert.Equal(t, "data", readFile(t, filepath.Join(machine, "app", "sub", "data.txt")))
+	fi, err := os.Stat(filepath.Join(machine, "app", "main.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	assert.Contains(t, progress.String(), "100%")
+
+	// only the changed files are copied again
+	writeFiles(t, src, map[string]string{"sub/data.txt": "new data"})
+	progress.Reset()
+	assert.NoError(t, client.Upload(src, machine, CopyOptions{Recursive: true, Delta: true, Progress: &progress}))
+	assert.Equal(t, "new data", readFile(t, filepath.Join(machine, "app", "sub", "data.txt")))
+	assert.Contains(t, progress.String(), "data.txt")
+	assert.NotContains(t, progress.String(), "main.go")
+}
+
+func TestNativeClientUploadResumes(t *testing.T) {
+	client := newShellClient(t)
+
+	src := filepath.Join(t.TempDir(), "image.tar")
+	writeFiles(t, filepath.Dir(src), map[string]string{"image.tar": "0123456789"})
+	machine := t.TempDir()
+	// the first 4 bytes were copied before the copy was interrupted
+	writeFiles(t, machine, map[string]string{"image.tar" + partSuffix: "0123"})
+
+	assert.NoError(t, client.Upload(src, filepath.Join(machine, "image.tar"), CopyOptions{}))
+	assert.Equal(t, "0123456789", readFile(t, filepath.Join(machine, "image.tar")))
+	_, err := os.Stat(filepath.Join(machine, "image.tar"+partSuffix))
+	assert.True(t, os.IsNotExist(err))
+
+	// the interrupted copy of another version of the file is not resumed
+	writeFiles(t, machine, map[string]string{"image.tar" + partSuffix: "abcd"})
+	assert.NoError(t, client.Upload(src, filepath.Join(machine, "image.tar"), CopyOptions{}))
+	assert.Equal(t, "0123456789", readFile(t, filepath.Join(machine, "image.tar")))
+}
+
+func TestNativeClientDownload(t *testing.T) {
+	client := newShellClient(t)
+
+	machine := filepath.Join(t.TempDir(), "logs")
+	writeFiles(t, machine, map[string]string{"docker.log": "started", "old/1.log": "stopped"})
+	dest := t.TempDir()
+	// a copy of docker.log was interrupted
+	writeFiles(t, filepath.Join(dest, "logs"), map[string]string{"docker.log" + partSuffix: "sta"})
+
+	assert.NoError(t, client.Download(machine+"/", dest, CopyOptions{Recursive: true}))
+	assert.Equal(t, "started", readFile(t, filepath.Join(dest, "logs", "docker.log")))
+	assert.Equal(t, "stopped", readFile(t, filepath.Join(dest, "logs", "old", "1.log")))
+
+	// the interrupted copy of another version of the file is not resumed
+	writeFiles(t, filepath.Join(dest, "logs"), map[string]string{"docker.log" + partSuffix: "sto"})
+	assert.NoError(t, client.Download(filepath.Join(machine, "docker.log"), filepath.Join(dest, "logs"), CopyOptions{}))
+	assert.Equal(t, "started", readFile(t, filepath.Join(dest, "logs", "docker.log")))
+
+	err := client.Download(filepath.Join(machine, "missing"), dest, CopyOptions{})
+	assert.True(t, err != nil && strings.Contains(err.Error(), "no such file"))
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", formatSize(512))
+	assert.Equal(t, "1.5KiB", formatSize(1536))
+	assert.Equal(t, "2.0GiB", formatSize(2<<30))
+}
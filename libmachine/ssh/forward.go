@@ -0,0 +1,315 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rancher/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// ForwardType is the type of a port forwarding, as the ssh option.
+type ForwardType string
+
+const (
+	// LocalForward forwards a local port to an address the machine reaches
+	LocalForward ForwardType = "L"
+	// RemoteForward forwards a port of the machine to a local address
+	RemoteForward ForwardType = "R"
+	// DynamicForward serves SOCKS5 on a local port, the machine connecting
+	DynamicForward ForwardType = "D"
+)
+
+// Forward is a port forwarding of the shells of a client.
+type Forward struct {
+	Type ForwardType
+	// BindAddress and BindPort are the address listened on, locally or on
+	// the machine for RemoteForward
+	BindAddress string
+	BindPort    int
+	// Host and HostPort are the address connected to, but for
+	// DynamicForward
+	Host     string
+	HostPort int
+}
+
+// PortForwarder is implemented by the clients which can forward ports while
+// their shells run, e.g. for ssh -L.
+type PortForwarder interface {
+	// Forward adds a port forwarding to the shells
+	Forward(f Forward) error
+	// ForwardOnly has the shells only forward the ports, running no
+	// command until interrupted, e.g. for ssh -N
+	ForwardOnly() error
+}
+
+// ParseForward parses a forwarding of type t given as the ssh option, i.e.
+// [bind_address:]port:host:hostport for LocalForward and RemoteForward and
+// [bind_address:]port for DynamicForward.
+func ParseForward(t ForwardType, spec string) (Forward, error) {
+	f := Forward{Type: t}
+	parts := splitForward(spec)
+
+	hostParts := 2
+	if t == DynamicForward {
+		hostParts = 0
+	}
+	switch len(parts) - hostParts {
+	case 1:
+		f.BindAddress = "localhost"
+	case 2:
+		f.BindAddress, parts = parts[0], parts[1:]
+	default:
+		return f, fmt.Errorf("invalid -%s %q, expected %s", t, spec, forwardUsage(t))
+	}
+
+	var err error
+	if f.BindPort, err = parsePort(parts[0]); err != nil {
+		return f, fmt.Errorf("invalid -%s %q: %s", t, spec, err)
+	}
+	if t != DynamicForward {
+		f.Host = parts[1]
+		if f.HostPort, err = parsePort(parts[2]); err != nil {
+			return f, fmt.Errorf("invalid -%s %q: %s", t, spec, err)
+		}
+	}
+	return f, nil
+}
+
+func forwardUsage(t ForwardType) string {
+	if t == DynamicForward {
+		return "[bind_address:]port"
+	}
+	return "[bind_address:]port:host:hostport"
+}
+
+// splitForward splits spec at the colons, but those of bracketed IPv6
+// addresses.
+func splitForward(spec string) []string {
+	var parts []string
+	for spec != "" {
+		if strings.HasPrefix(spec, "[") {
+			if end := strings.Index(spec, "]"); end > 0 {
+				parts = append(parts, spec[1:end])
+				spec = strings.TrimPrefix(spec[end+1:], ":")
+				continue
+			}
+		}
+		i := strings.Index(spec, ":")
+		if i < 0 {
+			parts = append(parts, spec)
+			break
+		}
+		parts = append(parts, spec[:i])
+		spec = spec[i+1:]
+	}
+	return parts
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+func (f Forward) bindAddr() string {
+	return net.JoinHostPort(f.BindAddress, strconv.Itoa(f.BindPort))
+}
+
+func (f Forward) hostAddr() string {
+	return net.JoinHostPort(f.Host, strconv.Itoa(f.HostPort))
+}
+
+// String returns the forwarding as the ssh option.
+func (f Forward) String() string {
+	bind := net.JoinHostPort(f.BindAddress, strconv.Itoa(f.BindPort))
+	if f.Type == DynamicForward {
+		return bind
+	}
+	return bind + ":" + f.hostAddr()
+}
+
+// Forward adds a port forwarding to the shells of the client.
+func (client *NativeClient) Forward(f Forward) error {
+	client.forwards = append(client.forwards, f)
+	return nil
+}
+
+// ForwardOnly has the shells of the client only forward the ports.
+func (client *NativeClient) ForwardOnly() error {
+	client.forwardOnly = true
+	return nil
+}
+
+// startForwards starts the port forwardings of the client over conn,
+// returning the func stopping them.
+func (client *NativeClient) startForwards(conn *ssh.Client) (func(), error) {
+	var listeners []net.Listener
+	stop := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, f := range client.forwards {
+		var (
+			l    net.Listener
+			err  error
+			dial func(net.Conn) (net.Conn, error)
+		)
+		switch f.Type {
+		case LocalForward:
+			target := f.hostAddr()
+			l, err = net.Listen("tcp", f.bindAddr())
+			dial = func(net.Conn) (net.Conn, error) { return conn.Dial("tcp", target) }
+		case RemoteForward:
+			target := f.hostAddr()
+			l, err = conn.Listen("tcp", f.bindAddr())
+			dial = func(net.Conn) (net.Conn, error) { return net.Dial("tcp", target) }
+		case DynamicForward:
+			l, err = net.Listen("tcp", f.bindAddr())
+			dial = func(c net.Conn) (net.Conn, error) { return socks5Connect(c, conn) }
+		default:
+			err = fmt.Errorf("unsupported forwarding type %q", f.Type)
+		}
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("Error forwarding %s: %s", f, err)
+		}
+
+		log.Debugf("Forwarding -%s %s", f.Type, f)
+		listeners = append(listeners, l)
+		go serveForward(l, dial)
+	}
+	return stop, nil
+}
+
+// serveForward pipes the connections accepted on l to those dial opens,
+// until l is closed.
+func serveForward(l net.Listener, dial func(net.Conn) (net.Conn, error)) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			target, err := dial(c)
+			if err != nil {
+				log.Debugf("Error forwarding a connection from %s: %s", c.RemoteAddr(), err)
+				return
+			}
+			defer target.Close()
+			pipe(c, target)
+		}()
+	}
+}
+
+// pipe copies between a and b until both directions are done.
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// wake up the other direction
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+}
+
+// socks5Connect serves the SOCKS5 handshake of a CONNECT request without
+// authentication on c, connecting to the address requested from the
+// machine of conn.
+func socks5Connect(c net.Conn, conn *ssh.Client) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 5 {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if _, err := io.ReadFull(c, make([]byte, header[1])); err != nil {
+		return nil, err
+	}
+	// no authentication
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c, request); err != nil {
+		return nil, err
+	}
+	if request[1] != 1 {
+		c.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, errors.New("only the SOCKS CONNECT command is supported")
+	}
+
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(c, length); err != nil {
+			return nil, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return nil, err
+	}
+
+	target, err := conn.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		// general failure
+		c.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	if _, err := c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+// Forward adds a port forwarding to the shells of the client.
+func (client *ExternalClient) Forward(f Forward) error {
+	client.BaseArgs = append([]string{"-" + string(f.Type), f.String()}, client.BaseArgs...)
+	return nil
+}
+
+// ForwardOnly has the shells of the client only forward the ports.
+func (client *ExternalClient) ForwardOnly() error {
+	client.BaseArgs = append([]string{"-N"}, client.BaseArgs...)
+	return nil
+}
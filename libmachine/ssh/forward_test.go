@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForward(t *testing.T) {
+	cases := []struct {
+		forwardType ForwardType
+		spec        string
+		expected    Forward
+		expectedErr string
+	}{
+		{LocalForward, "8080:10.0.0.3:80", Forward{Type: LocalForward, BindAddress: "localhost", BindPort: 8080, Host: "10.0.0.3", HostPort: 80}, ""},
+		{RemoteForward, "0.0.0.0:9000:localhost:3000", Forward{Type: RemoteForward, BindAddress: "0.0.0.0", BindPort: 9000, Host: "localhost", HostPort: 3000}, ""},
+		{LocalForward, "[::1]:8080:[fd00::3]:80", Forward{Type: LocalForward, BindAddress: "::1", BindPort: 8080, Host: "fd00::3", HostPort: 80}, ""},
+		{DynamicForward, "1080", Forward{Type: DynamicForward, BindAddress: "localhost", BindPort: 1080}, ""},
+		{DynamicForward, "127.0.0.1:1080", Forward{Type: DynamicForward, BindAddress: "127.0.0.1", BindPort: 1080}, ""},
+		{LocalForward, "8080", Forward{}, `invalid -L "8080", expected [bind_address:]port:host:hostport`},
+		{DynamicForward, "a:b:1080", Forward{}, `invalid -D "a:b:1080", expected [bind_address:]port`},
+		{LocalForward, "8080:10.0.0.3:http", Forward{}, `invalid -L "8080:10.0.0.3:http": invalid port "http"`},
+		{RemoteForward, "70000:localhost:80", Forward{}, `invalid -R "70000:localhost:80": invalid port "70000"`},
+	}
+
+	for _, c := range cases {
+		forward, err := ParseForward(c.forwardType, c.spec)
+		if c.expectedErr != "" {
+			assert.EqualError(t, err, c.expectedErr)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, forward)
+	}
+}
+
+func TestForwardString(t *testing.T) {
+	forward, err := ParseForward(LocalForward, "[::1]:8080:10.0.0.3:80")
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:8080:10.0.0.3:80", forward.String())
+
+	forward, err = ParseForward(DynamicForward, "1080")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:1080", forward.String())
+}
+
+func TestExternalClientForward(t *testing.T) {
+	client := &ExternalClient{BaseArgs: []string{"docker@10.0.0.2"}}
+	assert.NoError(t, client.Forward(Forward{Type: LocalForward, BindAddress: "localhost", BindPort: 8080, Host: "10.0.0.3", HostPort: 80}))
+	assert.NoError(t, client.ForwardOnly())
+	assert.Equal(t, []string{"-N", "-L", "localhost:8080:10.0.0.3:80", "docker@10.0.0.2"}, client.BaseArgs)
+}
+
+// freePort returns a local port nothing listens on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestNativeClientForward(t *testing.T) {
+	// the service reached from the machine, echoing a line
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	go func() {
+		for {
+			c, err := service.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, _ := bufio.NewReader(c).ReadString('\n')
+				io.WriteString(c, line)
+			}()
+		}
+	}()
+	serviceAddr := service.Addr().(*net.TCPAddr)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var conns int32
+	go serveSSH(l, newServerConfig(t), &conns)
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	c, err := NewNativeClient("docker", host, p, &Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*NativeClient)
+
+	localPort, socksPort := freePort(t), freePort(t)
+	assert.NoError(t, client.Forward(Forward{Type: LocalForward, BindAddress: "127.0.0.1", BindPort: localPort, Host: "127.0.0.1", HostPort: serviceAddr.Port}))
+	assert.NoError(t, client.Forward(Forward{Type: DynamicForward, BindAddress: "127.0.0.1", BindPort: socksPort}))
+
+	conn, err := client.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stop, err := client.startForwards(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	echo := func(c net.Conn) string {
+		io.WriteString(c, "hello\n")
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		return line
+	}
+
+	local, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	assert.Equal(t, "hello\n", echo(local))
+
+	socks, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(socksPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	// no authentication, then CONNECT to the IPv4 address of the service
+	socks.Write([]byte{5, 1, 0})
+	reply := make([]byte, 2)
+	io.ReadFull(socks, reply)
+	assert.Equal(t, []byte{5, 0}, reply)
+	request := append([]byte{5, 1, 0, 1}, serviceAddr.IP.To4()...)
+	socks.Write(append(request, byte(serviceAddr.Port>>8), byte(serviceAddr.Port)))
+	reply = make([]byte, 10)
+	io.ReadFull(socks, reply)
+	assert.Equal(t, byte(0), reply[1])
+	assert.Equal(t, "hello\n", echo(socks))
+}
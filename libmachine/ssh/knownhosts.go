@@ -0,0 +1,179 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rancher/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// knownHostsFile records the host keys of the machines, not verified if
+	// empty
+	knownHostsFile string
+	knownHostsLock sync.Mutex
+)
+
+// ErrHostKeyChanged is returned when a machine presents another host key
+// than the one recorded.
+type ErrHostKeyChanged struct {
+	Addr string
+}
+
+func (e ErrHostKeyChanged) Error() string {
+	return fmt.Sprintf("the SSH host key of %s changed, someone may be intercepting the connection; if the machine was rebuilt, forget its key with ssh --reset-hostkey", e.Addr)
+}
+
+// SetKnownHostsFile has the clients record the host key of a machine in path
+// on the first connection and verify it on the next ones. The host keys are
+// not verified if path is empty, the default.
+func SetKnownHostsFile(path string) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	knownHostsFile = path
+}
+
+// hostKeyCallback returns the callback verifying the host keys against the
+// known hosts file, recording those of the unknown hosts. The keys are
+// recorded under alias if set, e.g. the name of the machine, rather than the
+// address dialed, which changes for the machines reached through a tunnel.
+func hostKeyCallback(alias string) ssh.HostKeyCallback {
+	knownHostsLock.Lock()
+	path := knownHostsFile
+	knownHostsLock.Unlock()
+
+	if path == "" {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if alias != "" {
+			// recorded as ssh records its HostKeyAlias, without the port
+			hostname = net.JoinHostPort(alias, "22")
+		}
+
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return addKnownHost(path, hostname, key)
+		}
+
+		callback, err := knownhosts.New(path)
+		if err != nil {
+			return err
+		}
+
+		err = callback(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok {
+			if len(keyErr.Want) > 0 {
+				return ErrHostKeyChanged{Addr: knownhosts.Normalize(hostname)}
+			}
+			return addKnownHost(path, hostname, key)
+		}
+		return err
+	}
+}
+
+func addKnownHost(path, hostname string, key ssh.PublicKey) error {
+	log.Debugf("Recording the SSH host key of %s in %s", hostname, path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// HostKeyCheckingArgs returns the options of the ssh binary verifying the
+// host keys like hostKeyCallback, which requires OpenSSH 7.6, under alias if
+// set.
+func HostKeyCheckingArgs(alias string) []string {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	if knownHostsFile == "" {
+		return []string{
+			"-o", "StrictHostKeyChecking=no",
+			"-o", "UserKnownHostsFile=/dev/null",
+		}
+	}
+	args := []string{
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", fmt.Sprintf("UserKnownHostsFile=%q", knownHostsFile),
+		"-o", "HashKnownHosts=no",
+	}
+	if alias != "" {
+		args = append(args, "-o", "HostKeyAlias="+alias)
+	}
+	return args
+}
+
+// ForgetHostKey removes the host key of the machine recorded under alias, and
+// at host and port as it was before the aliases, from the known hosts file,
+// for the key recorded on the next connection, e.g. after the machine was
+// rebuilt. host is ignored if empty.
+func ForgetHostKey(alias, host string, port int) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	if knownHostsFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(knownHostsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	if alias != "" {
+		addrs = append(addrs, knownhosts.Normalize(net.JoinHostPort(alias, "22")))
+	}
+	if host != "" {
+		addrs = append(addrs, knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port))))
+	}
+	var kept []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 0 && containsHost(fields[0], addrs) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	content := strings.Join(kept, "\n")
+	if len(kept) > 0 {
+		content += "\n"
+	}
+	return ioutil.WriteFile(knownHostsFile, []byte(content), 0600)
+}
+
+func containsHost(hosts string, addrs []string) bool {
+	for _, h := range strings.Split(hosts, ",") {
+		for _, addr := range addrs {
+			if h == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	SetKnownHostsFile(filepath.Join(tmpDir, "known_hosts"))
+	defer SetKnownHostsFile("")
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 2222}
+	key, other := newHostKey(t), newHostKey(t)
+
+	// the key is recorded on the first connection, then verified
+	assert.NoError(t, hostKeyCallback("")("10.0.0.2:2222", remote, key))
+	assert.NoError(t, hostKeyCallback("")("10.0.0.2:2222", remote, key))
+	assert.Equal(t, ErrHostKeyChanged{Addr: "[10.0.0.2]:2222"}, hostKeyCallback("")("10.0.0.2:2222", remote, other))
+
+	// another host is recorded besides
+	assert.NoError(t, hostKeyCallback("")("10.0.0.3:22", remote, other))
+
+	assert.NoError(t, ForgetHostKey("", "10.0.0.2", 2222))
+	assert.NoError(t, hostKeyCallback("")("10.0.0.2:2222", remote, other))
+	assert.NoError(t, hostKeyCallback("")("10.0.0.3:22", remote, other))
+}
+
+func TestHostKeyCallbackAlias(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	SetKnownHostsFile(filepath.Join(tmpDir, "known_hosts"))
+	defer SetKnownHostsFile("")
+
+	key, other := newHostKey(t), newHostKey(t)
+	tunnel := func(port int) net.Addr { return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port} }
+
+	// the key is verified whatever the port of the tunnel to the machine
+	assert.NoError(t, hostKeyCallback("node-7")("127.0.0.1:40001", tunnel(40001), key))
+	assert.NoError(t, hostKeyCallback("node-7")("127.0.0.1:40002", tunnel(40002), key))
+	assert.Equal(t, ErrHostKeyChanged{Addr: "node-7"}, hostKeyCallback("node-7")("127.0.0.1:40002", tunnel(40002), other))
+
+	// another machine given a port used before is not mistaken for it
+	assert.NoError(t, hostKeyCallback("node-8")("127.0.0.1:40001", tunnel(40001), other))
+
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, "known_hosts"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	assert.NoError(t, ForgetHostKey("node-7", "", 0))
+	assert.NoError(t, hostKeyCallback("node-7")("127.0.0.1:40003", tunnel(40003), other))
+	assert.Equal(t, ErrHostKeyChanged{Addr: "node-8"}, hostKeyCallback("node-8")("127.0.0.1:40003", tunnel(40003), key))
+}
+
+func TestHostKeyCheckingArgs(t *testing.T) {
+	assert.Equal(t, []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null"}, HostKeyCheckingArgs(""))
+
+	SetKnownHostsFile("/store/known_hosts")
+	defer SetKnownHostsFile("")
+	assert.Equal(t, []string{
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", `UserKnownHostsFile="/store/known_hosts"`,
+		"-o", "HashKnownHosts=no",
+	}, HostKeyCheckingArgs(""))
+	assert.Equal(t, []string{
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", `UserKnownHostsFile="/store/known_hosts"`,
+		"-o", "HashKnownHosts=no",
+		"-o", "HostKeyAlias=node-7",
+	}, HostKeyCheckingArgs("node-7"))
+}
@@ -0,0 +1,176 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/pkg/term"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PasswordEnv is the environment variable holding the SSH password of the
+// machines, tried when no key is accepted.
+const PasswordEnv = "MACHINE_SSH_PASSWORD"
+
+// maxPasswordPrompts is the number of times a password is prompted for per
+// connection, as OpenSSH does.
+const maxPasswordPrompts = 3
+
+// ErrPasswordRequired is returned when the machine asks for a password or a
+// keyboard-interactive answer which can neither be given nor prompted for.
+var ErrPasswordRequired = errors.New("no SSH key was accepted, set " + PasswordEnv + " to the password of the machine")
+
+var (
+	// promptPasswords tells whether the passwords are prompted for on the
+	// terminal, which the CLI enables for the interactive commands
+	promptPasswords     bool
+	promptPasswordsLock sync.Mutex
+	// the passwords prompted for, by user@host:port, as a command opens many
+	// connections
+	passwords     = map[string]string{}
+	passwordsLock sync.Mutex
+)
+
+// SetPasswordPrompt has the native clients prompt for the password of a
+// machine on the terminal, and for the keyboard-interactive challenges, e.g.
+// of 2FA, when no key is accepted. The prompts are disabled by default, for
+// the commands running unattended.
+func SetPasswordPrompt(enabled bool) {
+	promptPasswordsLock.Lock()
+	defer promptPasswordsLock.Unlock()
+	promptPasswords = enabled
+}
+
+// canPrompt tells whether the passwords can be prompted for.
+func canPrompt() bool {
+	promptPasswordsLock.Lock()
+	defer promptPasswordsLock.Unlock()
+	return promptPasswords && term.IsTerminal(os.Stdin.Fd())
+}
+
+// passwordSource answers the password and keyboard-interactive
+// authentications of a connection, with the passwords given, that of
+// PasswordEnv, then those prompted for.
+type passwordSource struct {
+	sync.Mutex
+	user      string
+	passwords []string
+	// addr, tried and prompts are those of the connection being
+	// authenticated
+	addr    string
+	tried   int
+	prompts int
+}
+
+func newPasswordSource(user string, passwords []string) *passwordSource {
+	s := &passwordSource{user: user}
+	s.passwords = append(s.passwords, passwords...)
+	if password, ok := os.LookupEnv(PasswordEnv); ok {
+		s.passwords = append(s.passwords, password)
+	}
+	return s
+}
+
+// methods returns the password and keyboard-interactive authentications.
+func (s *passwordSource) methods() []ssh.AuthMethod {
+	// the methods are tried once per type, each retries with the next
+	// password until the source is exhausted
+	return []ssh.AuthMethod{
+		ssh.RetryableAuthMethod(ssh.PasswordCallback(s.password), 0),
+		ssh.RetryableAuthMethod(ssh.KeyboardInteractive(s.challenge), 0),
+	}
+}
+
+// hostKeyCallback wraps callback, called once per connection before the
+// authentication, to start answering that of the connection to hostname.
+func (s *passwordSource) hostKeyCallback(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		s.Lock()
+		s.addr = s.user + "@" + hostname
+		s.tried = 0
+		s.prompts = 0
+		s.Unlock()
+		return callback(hostname, remote, key)
+	}
+}
+
+// password returns the next password to try.
+func (s *passwordSource) password() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.tried < len(s.passwords) {
+		s.tried++
+		return s.passwords[s.tried-1], nil
+	}
+
+	passwordsLock.Lock()
+	defer passwordsLock.Unlock()
+
+	// the password prompted for by a previous connection, once
+	if password, ok := passwords[s.addr]; ok && s.tried == len(s.passwords) {
+		s.tried++
+		return password, nil
+	}
+	delete(passwords, s.addr)
+
+	if s.prompts >= maxPasswordPrompts {
+		return "", fmt.Errorf("no password was accepted by %s", s.addr)
+	}
+	s.prompts++
+	password, err := prompt(fmt.Sprintf("%s's password: ", s.addr), false)
+	if err != nil {
+		return "", err
+	}
+	passwords[s.addr] = password
+	return password, nil
+}
+
+// challenge answers the keyboard-interactive questions, with the passwords
+// for those asking one and prompting for the others.
+func (s *passwordSource) challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if len(questions) == 0 {
+		return nil, nil
+	}
+
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		var err error
+		if !echos[i] && strings.Contains(strings.ToLower(question), "password") {
+			answers[i], err = s.password()
+		} else {
+			if i == 0 && (name != "" || instruction != "") && canPrompt() {
+				fmt.Fprintln(os.Stderr, strings.TrimSpace(name+"\n"+instruction))
+			}
+			answers[i], err = prompt(question, echos[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+// prompt reads the answer to question on the terminal, echoed or not,
+// failing if the prompts are disabled or stdin is not a terminal.
+func prompt(question string, echo bool) (string, error) {
+	if !canPrompt() {
+		return "", ErrPasswordRequired
+	}
+
+	fmt.Fprint(os.Stderr, question)
+	if echo {
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		return strings.TrimRight(answer, "\r\n"), err
+	}
+
+	answer, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(answer), err
+}
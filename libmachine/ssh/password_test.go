@@ -0,0 +1,93 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// newPasswordClient returns a native client of an SSH server authenticating
+// with config.
+func newPasswordClient(t *testing.T, config *ssh.ServerConfig, auth *Auth) *NativeClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var conns int32
+	go serveSSH(l, config, &conns)
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := NewNativeClient("docker", host, p, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*NativeClient)
+}
+
+func TestNativeClientPassword(t *testing.T) {
+	config := newServerConfig(t)
+	config.NoClientAuth = false
+	config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if string(password) != "secret" {
+			return nil, errors.New("wrong password")
+		}
+		return nil, nil
+	}
+
+	client := newPasswordClient(t, config, &Auth{Passwords: []string{"wrong", "secret"}})
+
+	// each connection tries the passwords again
+	for i := 0; i < 2; i++ {
+		output, err := client.Output("uptime")
+		assert.NoError(t, err)
+		assert.Equal(t, "uptime", output)
+	}
+}
+
+func TestNativeClientKeyboardInteractive(t *testing.T) {
+	t.Setenv(PasswordEnv, "secret")
+
+	config := newServerConfig(t)
+	config.NoClientAuth = false
+	config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != 1 || answers[0] != "secret" {
+			return nil, errors.New("wrong password")
+		}
+		return nil, nil
+	}
+
+	client := newPasswordClient(t, config, &Auth{})
+
+	output, err := client.Output("uptime")
+	assert.NoError(t, err)
+	assert.Equal(t, "uptime", output)
+}
+
+func TestPasswordSourceWithoutPrompt(t *testing.T) {
+	SetPasswordPrompt(false)
+
+	s := newPasswordSource("docker", []string{"secret"})
+	s.hostKeyCallback(ssh.InsecureIgnoreHostKey())("10.0.0.2:22", nil, nil)
+
+	password, err := s.password()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password)
+
+	_, err = s.password()
+	assert.Equal(t, ErrPasswordRequired, err)
+
+	// the 2FA code cannot be prompted for
+	_, err = s.challenge("", "", []string{"Verification code: "}, []bool{false})
+	assert.Equal(t, ErrPasswordRequired, err)
+}
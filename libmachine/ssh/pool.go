@@ -0,0 +1,165 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxControlPathLength leaves room in the 104 bytes of a unix socket path for
+// the 40 bytes of the %C hash of the external clients.
+const maxControlPathLength = 60
+
+// connPool holds the connections of the native clients, one per machine,
+// for the sessions of the commands to reuse.
+type connPool struct {
+	sync.Mutex
+	enabled    bool
+	controlDir string
+	conns      map[string]*ssh.Client
+}
+
+var pool = &connPool{conns: map[string]*ssh.Client{}}
+
+// keepaliveTimeout is how long a kept connection has to answer before it is
+// reused, those to the machines rebooted or reset hanging otherwise.
+var keepaliveTimeout = 5 * time.Second
+
+// SetMultiplexing has the clients reuse one connection per machine for their
+// commands rather than connecting for each, e.g. while provisioning over
+// high-latency links. The external clients keep their master connection in
+// controlDir, unless it is too long for a socket path or on Windows, where
+// OpenSSH does not support it. Multiplexing is disabled if controlDir is
+// empty, the default.
+func SetMultiplexing(controlDir string) {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.enabled = controlDir != ""
+	pool.controlDir = controlDir
+}
+
+// CloseConnections closes the connections kept by the native clients.
+func CloseConnections() {
+	pool.Lock()
+	defer pool.Unlock()
+	for key, conn := range pool.conns {
+		closeConn(conn)
+		delete(pool.conns, key)
+	}
+}
+
+// CloseConnectionsTo closes the connections kept to the machine at address
+// and port, e.g. as it reboots, for the commands not to reuse them.
+func CloseConnectionsTo(address string, port int) {
+	target := "@" + net.JoinHostPort(address, strconv.Itoa(port))
+	pool.Lock()
+	defer pool.Unlock()
+	for key, conn := range pool.conns {
+		if strings.HasSuffix(strings.SplitN(key, " via ", 2)[0], target) {
+			closeConn(conn)
+			delete(pool.conns, key)
+		}
+	}
+}
+
+// session opens a session on the connection kept for key, nil if none is
+// kept or it was lost.
+func (p *connPool) session(key string) (*ssh.Client, *ssh.Session) {
+	p.Lock()
+	conn := p.conns[key]
+	p.Unlock()
+	if conn == nil {
+		return nil, nil
+	}
+
+	err := keepalive(conn)
+	var session *ssh.Session
+	if err == nil {
+		session, err = conn.NewSession()
+	}
+	if err != nil {
+		log.Debugf("Lost the SSH connection to %s: %s", key, err)
+		p.Lock()
+		if p.conns[key] == conn {
+			delete(p.conns, key)
+		}
+		p.Unlock()
+		closeConn(conn)
+		return nil, nil
+	}
+	return conn, session
+}
+
+// keepalive checks that the machine of conn answers a keepalive request
+// within keepaliveTimeout. The machines not knowing the request answer it
+// too, with a failure.
+func keepalive(conn *ssh.Client) error {
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(keepaliveTimeout):
+		return errors.New("no answer to the keepalive")
+	}
+}
+
+// put keeps conn for key if multiplexing is enabled and no other connection
+// is kept.
+func (p *connPool) put(key string, conn *ssh.Client) {
+	p.Lock()
+	defer p.Unlock()
+	if p.enabled && p.conns[key] == nil {
+		p.conns[key] = conn
+	}
+}
+
+// release closes conn unless it is kept for key.
+func (p *connPool) release(key string, conn *ssh.Client) error {
+	p.Lock()
+	kept := p.conns[key] == conn
+	p.Unlock()
+	if kept {
+		return nil
+	}
+	return conn.Close()
+}
+
+// controlArgs returns the options of the ssh binary multiplexing the
+// connections, nil if multiplexing is disabled.
+func (p *connPool) controlArgs() []string {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.enabled || runtime.GOOS == "windows" {
+		return nil
+	}
+	if len(p.controlDir) > maxControlPathLength {
+		log.Debugf("Not multiplexing the SSH connections, %s is too long for a socket path", p.controlDir)
+		return nil
+	}
+	if err := os.MkdirAll(p.controlDir, 0700); err != nil {
+		log.Debugf("Not multiplexing the SSH connections: %s", err)
+		return nil
+	}
+
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", fmt.Sprintf("ControlPath=%q", filepath.Join(p.controlDir, "%C")),
+		"-o", "ControlPersist=60s",
+	}
+}
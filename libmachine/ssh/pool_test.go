@@ -0,0 +1,243 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newServerConfig(t *testing.T) *ssh.ServerConfig {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	return config
+}
+
+// serveSSH serves the exec requests on l, echoing their command, and the
+// direct-tcpip channels, and counts the connections accepted.
+func serveSSH(l net.Listener, config *ssh.ServerConfig, conns *int32) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(conns, 1)
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(c, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				if newChannel.ChannelType() == "direct-tcpip" {
+					go serveDirectTCPIP(newChannel)
+					continue
+				}
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					for req := range requests {
+						if req.Type != "exec" {
+							req.Reply(false, nil)
+							continue
+						}
+						req.Reply(true, nil)
+						// the payload is the length prefixed command
+						channel.Write(req.Payload[4:])
+						channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+						return
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// serveDirectTCPIP connects to the address requested by newChannel, as the
+// local forwardings of the clients.
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+	var request struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &request); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(request.Host, strconv.Itoa(int(request.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer target.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	go io.Copy(target, channel)
+	io.Copy(channel, target)
+}
+
+func TestNativeClientMultiplexing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var conns int32
+	go serveSSH(l, newServerConfig(t), &conns)
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := NewNativeClient("docker", host, p, &Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() {
+		output, err := client.Output("uptime")
+		assert.NoError(t, err)
+		assert.Equal(t, "uptime", output)
+	}
+
+	// without multiplexing, each command probes the machine and connects
+	run()
+	run()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&conns))
+
+	SetMultiplexing(t.TempDir())
+	defer SetMultiplexing("")
+	defer CloseConnections()
+
+	atomic.StoreInt32(&conns, 0)
+	run()
+	run()
+	run()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&conns))
+}
+
+func TestExternalClientMultiplexing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("OpenSSH does not multiplex on Windows")
+	}
+
+	// a short path, the temporary dirs may be too long for a socket path
+	controlDir := "/tmp/machine-test-cm"
+	defer os.RemoveAll(controlDir)
+	SetMultiplexing(controlDir)
+	defer SetMultiplexing("")
+
+	client, err := NewExternalClient("/usr/bin/ssh", "docker", "10.0.0.2", 22, &Auth{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"-o", "ControlMaster=auto",
+		"-o", fmt.Sprintf("ControlPath=%q", filepath.Join(controlDir, "%C")),
+		"-o", "ControlPersist=60s",
+	}, client.BaseArgs[:6])
+}
+
+func TestNativeClientMultiplexingClosedConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var conns int32
+	go serveSSH(l, newServerConfig(t), &conns)
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := NewNativeClient("docker", host, p, &Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetMultiplexing(t.TempDir())
+	defer SetMultiplexing("")
+	defer CloseConnections()
+
+	_, err = client.Output("uptime")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&conns))
+
+	// the machine reboots
+	CloseConnectionsTo("10.0.0.2", p)
+	_, err = client.Output("uptime")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&conns))
+
+	CloseConnectionsTo(host, p)
+	_, err = client.Output("uptime")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&conns))
+}
+
+func TestConnPoolKeepalive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the machine stops answering once connected, as when it is reset
+	config := newServerConfig(t)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ssh.NewServerConn(c, config)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client, err := NewNativeClient("docker", host, p, &Auth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.(*NativeClient).dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(timeout time.Duration) { keepaliveTimeout = timeout }(keepaliveTimeout)
+	keepaliveTimeout = 100 * time.Millisecond
+	pool := &connPool{enabled: true, conns: map[string]*ssh.Client{}}
+	pool.put("docker@machine", conn)
+
+	kept, session := pool.session("docker@machine")
+	assert.Nil(t, kept)
+	assert.Nil(t, session)
+	assert.Empty(t, pool.conns)
+}
@@ -0,0 +1,119 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/pkg/term"
+	"github.com/rancher/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PassphraseEnv is the environment variable holding the passphrase of the
+// encrypted private keys, prompted for if unset and stdin is a terminal.
+const PassphraseEnv = "MACHINE_SSH_KEY_PASSPHRASE"
+
+// ErrPassphraseRequired is returned for an encrypted private key when no
+// passphrase can be read.
+var ErrPassphraseRequired = errors.New("the private key is encrypted, set " + PassphraseEnv + " to its passphrase")
+
+var (
+	// the passphrases prompted for, by key, as a command opens many sessions
+	passphrases     = map[string][]byte{}
+	passphrasesLock sync.Mutex
+)
+
+// NewSigner loads the private key at path, RSA, ECDSA or Ed25519, in PEM or
+// OpenSSH format. An encrypted key is decrypted with the passphrase of
+// PassphraseEnv or prompted for. The OpenSSH user certificate of the key, at
+// path-cert.pub as written by ssh-keygen, is used if it exists.
+func NewSigner(path string) (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase, perr := readPassphrase(path)
+		if perr != nil {
+			return nil, perr
+		}
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase); err != nil {
+			forgetPassphrase(path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the private key %s: %s", path, err)
+	}
+
+	certPath := path + "-cert.pub"
+	certBytes, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return signer, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return certSigner(signer, certPath, certBytes)
+}
+
+// certSigner returns a signer authenticating with the OpenSSH certificate of
+// the key of signer.
+func certSigner(signer ssh.Signer, certPath string, certBytes []byte) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the certificate %s: %s", certPath, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an OpenSSH certificate", certPath)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is not a user certificate", certPath)
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("%s is the certificate of another key", certPath)
+	}
+
+	log.Debugf("Using SSH certificate: %s", certPath)
+	return ssh.NewCertSigner(cert, signer)
+}
+
+func readPassphrase(path string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	passphrasesLock.Lock()
+	defer passphrasesLock.Unlock()
+	if passphrase, ok := passphrases[path]; ok {
+		return passphrase, nil
+	}
+
+	fd := os.Stdin.Fd()
+	if !term.IsTerminal(fd) {
+		return nil, ErrPassphraseRequired
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, err := terminal.ReadPassword(int(fd))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	passphrases[path] = passphrase
+	return passphrase, nil
+}
+
+func forgetPassphrase(path string) {
+	passphrasesLock.Lock()
+	defer passphrasesLock.Unlock()
+	delete(passphrases, path)
+}
@@ -0,0 +1,107 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func writeECKey(t *testing.T, path string, passphrase string) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		// the legacy PEM encryption, as of ssh-keygen -m PEM
+		if block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES128); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestNewSignerEncrypted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	keyPath := filepath.Join(tmpDir, "id_ecdsa")
+	writeECKey(t, keyPath, "secret")
+
+	os.Setenv(PassphraseEnv, "secret")
+	defer os.Unsetenv(PassphraseEnv)
+
+	signer, err := NewSigner(keyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoECDSA256, signer.PublicKey().Type())
+
+	os.Setenv(PassphraseEnv, "wrong")
+	_, err = NewSigner(keyPath)
+	assert.Error(t, err)
+}
+
+func TestNewSignerCertificate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	keyPath := filepath.Join(tmpDir, "id_ecdsa")
+	priv := writeECKey(t, keyPath, "")
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"docker"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigner(keyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.CertAlgoECDSA256v01, signer.PublicKey().Type())
+
+	// the certificate of another key is rejected
+	writeECKey(t, keyPath, "")
+	_, err = NewSigner(keyPath)
+	assert.Error(t, err)
+}
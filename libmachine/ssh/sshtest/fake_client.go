@@ -1,6 +1,10 @@
 package sshtest
 
-import "io"
+import (
+	"io"
+
+	"github.com/rancher/machine/libmachine/ssh"
+)
 
 type CmdResult struct {
 	Out string
@@ -10,6 +14,9 @@ type CmdResult struct {
 type FakeClient struct {
 	ActivatedShell []string
 	Outputs        map[string]CmdResult
+	AgentForwarded bool
+	Forwards       []ssh.Forward
+	ForwardedOnly  bool
 }
 
 func (fsc *FakeClient) Output(command string) (string, error) {
@@ -22,6 +29,21 @@ func (fsc *FakeClient) Shell(args ...string) error {
 	return nil
 }
 
+func (fsc *FakeClient) ForwardAgent() error {
+	fsc.AgentForwarded = true
+	return nil
+}
+
+func (fsc *FakeClient) Forward(f ssh.Forward) error {
+	fsc.Forwards = append(fsc.Forwards, f)
+	return nil
+}
+
+func (fsc *FakeClient) ForwardOnly() error {
+	fsc.ForwardedOnly = true
+	return nil
+}
+
 func (fsc *FakeClient) Start(command string) (io.ReadCloser, io.ReadCloser, error) {
 	return nil, nil, nil
 }
@@ -0,0 +1,286 @@
+// Package winrm runs commands on Windows hosts over WinRM, the
+// WS-Management remote shell, for hosts that are not reachable over SSH.
+package winrm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	DefaultPort      = 5985
+	DefaultHTTPSPort = 5986
+
+	actionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	commandStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+	signalTerminate  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+
+	// faultTimedOut is returned by Receive when the command produced no
+	// output within the operation timeout; the command is still running.
+	faultTimedOut = "2150858793"
+)
+
+// Client runs commands in a cmd shell with Basic authentication, which
+// needs either HTTPS or AllowUnencrypted on the WinRM service.
+type Client struct {
+	Endpoint string
+	User     string
+	Password string
+	client   *http.Client
+}
+
+func NewClient(host string, port int, https, insecure bool, user, password string) *Client {
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+
+	return &Client{
+		Endpoint: fmt.Sprintf("%s://%s/wsman", scheme, net.JoinHostPort(host, strconv.Itoa(port))),
+		User:     user,
+		Password: password,
+		client: &http.Client{
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+}
+
+// Output runs a command and returns its standard output. A non-zero exit
+// code is an error carrying the standard error.
+func (c *Client) Output(command string) (string, error) {
+	shellID, err := c.createShell()
+	if err != nil {
+		return "", err
+	}
+	defer c.deleteShell(shellID)
+
+	var resp struct {
+		CommandID string `xml:"Body>CommandResponse>CommandId"`
+	}
+	body := fmt.Sprintf("<rsp:CommandLine><rsp:Command>%s</rsp:Command></rsp:CommandLine>", escape(command))
+	if err := c.send(actionCommand, shellID, commandOptions, body, &resp); err != nil {
+		return "", err
+	}
+	defer c.signal(shellID, resp.CommandID)
+
+	var stdout, stderr bytes.Buffer
+	for {
+		done, exitCode, err := c.receive(shellID, resp.CommandID, &stdout, &stderr)
+		if err != nil {
+			return stdout.String(), err
+		}
+		if done {
+			if exitCode != 0 {
+				return stdout.String(), fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+			}
+			return stdout.String(), nil
+		}
+	}
+}
+
+func (c *Client) createShell() (string, error) {
+	var resp struct {
+		ShellID string `xml:"Body>Shell>ShellId"`
+	}
+	body := "<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>"
+	if err := c.send(actionCreate, "", shellOptions, body, &resp); err != nil {
+		return "", err
+	}
+	if resp.ShellID == "" {
+		return "", fmt.Errorf("%s: no shell ID in the response", c.Endpoint)
+	}
+	return resp.ShellID, nil
+}
+
+func (c *Client) deleteShell(shellID string) error {
+	return c.send(actionDelete, shellID, nil, "", nil)
+}
+
+func (c *Client) signal(shellID, commandID string) error {
+	body := fmt.Sprintf(`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`, escape(commandID), signalTerminate)
+	return c.send(actionSignal, shellID, nil, body, nil)
+}
+
+// receive appends the output produced so far, and reports whether the
+// command is done.
+func (c *Client) receive(shellID, commandID string, stdout, stderr *bytes.Buffer) (bool, int, error) {
+	var resp struct {
+		Streams []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Body>ReceiveResponse>Stream"`
+		State struct {
+			State    string `xml:"State,attr"`
+			ExitCode int    `xml:"ExitCode"`
+		} `xml:"Body>ReceiveResponse>CommandState"`
+	}
+	body := fmt.Sprintf(`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`, escape(commandID))
+	if err := c.send(actionReceive, shellID, nil, body, &resp); err != nil {
+		if fault, ok := err.(*Fault); ok && fault.Detail.WSManFault.Code == faultTimedOut {
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+
+	for _, stream := range resp.Streams {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Value))
+		if err != nil {
+			return false, 0, fmt.Errorf("cannot decode the %s stream: %s", stream.Name, err)
+		}
+		if stream.Name == "stderr" {
+			stderr.Write(data)
+		} else {
+			stdout.Write(data)
+		}
+	}
+
+	return resp.State.State == commandStateDone, resp.State.ExitCode, nil
+}
+
+var (
+	shellOptions = map[string]string{
+		"WINRS_NOPROFILE": "FALSE",
+		"WINRS_CODEPAGE":  "65001",
+	}
+	commandOptions = map[string]string{
+		"WINRS_CONSOLEMODE_STDIN": "TRUE",
+		"WINRS_SKIP_CMD_SHELL":    "FALSE",
+	}
+)
+
+var envelope = template.Must(template.New("envelope").Parse(`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<env:Header>
+<a:To>{{.To}}</a:To>
+<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<w:MaxEnvelopeSize env:mustUnderstand="true">153600</w:MaxEnvelopeSize>
+<a:MessageID>uuid:{{.MessageID}}</a:MessageID>
+<w:Locale xml:lang="en-US" env:mustUnderstand="false"/>
+<w:OperationTimeout>PT60S</w:OperationTimeout>
+<w:ResourceURI env:mustUnderstand="true">http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</w:ResourceURI>
+<a:Action env:mustUnderstand="true">{{.Action}}</a:Action>
+{{- if .ShellID}}
+<w:SelectorSet><w:Selector Name="ShellId">{{.ShellID}}</w:Selector></w:SelectorSet>
+{{- end}}
+{{- if .Options}}
+<w:OptionSet>{{range $name, $value := .Options}}<w:Option Name="{{$name}}">{{$value}}</w:Option>{{end}}</w:OptionSet>
+{{- end}}
+</env:Header>
+<env:Body>{{.Body}}</env:Body>
+</env:Envelope>`))
+
+// Fault is a SOAP fault returned by the WinRM service.
+type Fault struct {
+	Reason string `xml:"Reason>Text"`
+	Detail struct {
+		WSManFault struct {
+			Code string `xml:"Code,attr"`
+		}
+	}
+}
+
+func (f *Fault) Error() string {
+	return strings.TrimSpace(f.Reason)
+}
+
+func (c *Client) send(action, shellID string, options map[string]string, body string, out interface{}) error {
+	var buf bytes.Buffer
+	if err := envelope.Execute(&buf, map[string]interface{}{
+		"To":        c.Endpoint,
+		"MessageID": newUUID(),
+		"Action":    action,
+		"ShellID":   shellID,
+		"Options":   options,
+		"Body":      body,
+	}); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.Endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.User, c.Password)
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s: unauthorized, check the user, the password and that Basic authentication is enabled", c.Endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Fault *Fault `xml:"Body>Fault"`
+		}
+		if err := xml.Unmarshal(data, &fault); err == nil && fault.Fault != nil {
+			return fault.Fault
+		}
+		return fmt.Errorf("%s: %s", c.Endpoint, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("cannot parse the WinRM response: %s", err)
+	}
+	return nil
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// PowerShell returns a command line running the script with PowerShell,
+// for cmd as well as PowerShell shells, over WinRM or OpenSSH. Scripts are
+// passed encoded so they need no quoting, which makes the command line
+// about three times longer than the script; cmd limits it to 8191
+// characters.
+func PowerShell(script string) string {
+	runes := utf16.Encode([]rune(script))
+	b := make([]byte, 2*len(runes))
+	for i, r := range runes {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " + base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,91 @@
+package winrm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const timedOutFault = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><s:Fault><s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot complete the operation within the time specified in OperationTimeout.</s:Text></s:Reason><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858793"/></s:Detail></s:Fault></s:Body></s:Envelope>`
+
+func newServer(t *testing.T, exitCode int) *httptest.Server {
+	receives := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if user != "Administrator" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), actionCreate):
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:Shell><rsp:ShellId>SHELL-1</rsp:ShellId></rsp:Shell></s:Body></s:Envelope>`)
+		case strings.Contains(string(body), actionCommand):
+			assert.Contains(t, string(body), `<w:Selector Name="ShellId">SHELL-1</w:Selector>`)
+			assert.Contains(t, string(body), "<rsp:Command>echo &lt;hello&gt;</rsp:Command>")
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:CommandResponse><rsp:CommandId>CMD-1</rsp:CommandId></rsp:CommandResponse></s:Body></s:Envelope>`)
+		case strings.Contains(string(body), actionReceive):
+			receives++
+			if receives == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, timedOutFault)
+				return
+			}
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Body><rsp:ReceiveResponse>
+<rsp:Stream Name="stdout" CommandId="CMD-1">aGVsbG8NCg==</rsp:Stream>
+<rsp:Stream Name="stderr" CommandId="CMD-1">b29wcw==</rsp:Stream>
+<rsp:CommandState CommandId="CMD-1" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>
+</rsp:ReceiveResponse></s:Body></s:Envelope>`, commandStateDone, exitCode)
+		case strings.Contains(string(body), actionSignal), strings.Contains(string(body), actionDelete):
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body/></s:Envelope>`)
+		default:
+			t.Errorf("unexpected request: %s", body)
+		}
+	}))
+}
+
+func newTestClient(url, password string) *Client {
+	c := NewClient("localhost", DefaultPort, false, false, "Administrator", password)
+	c.Endpoint = url + "/wsman"
+	return c
+}
+
+func TestOutput(t *testing.T) {
+	server := newServer(t, 0)
+	defer server.Close()
+
+	out, err := newTestClient(server.URL, "secret").Output("echo <hello>")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\r\n", out)
+}
+
+func TestOutputExitCode(t *testing.T) {
+	server := newServer(t, 1)
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, "secret").Output("echo <hello>")
+	assert.EqualError(t, err, "exit code 1: oops")
+}
+
+func TestOutputUnauthorized(t *testing.T) {
+	server := newServer(t, 0)
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, "wrong").Output("echo <hello>")
+	assert.EqualError(t, err, server.URL+"/wsman: unauthorized, check the user, the password and that Basic authentication is enabled")
+}
+
+func TestNewClient(t *testing.T) {
+	assert.Equal(t, "http://10.0.0.5:5985/wsman", NewClient("10.0.0.5", DefaultPort, false, false, "", "").Endpoint)
+	assert.Equal(t, "https://[fd00::5]:5986/wsman", NewClient("fd00::5", DefaultHTTPSPort, true, false, "", "").Endpoint)
+}
+
+func TestPowerShell(t *testing.T) {
+	assert.Equal(t, "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand ZABpAHIA", PowerShell("dir"))
+}